@@ -12,6 +12,9 @@
   const tokenInput = d.getElementById('two-factor-token')
   const trustCheckbox = d.getElementById('two-factor-trust-device')
   const longRunCheckbox = d.getElementById('long-run-session')
+  const webauthnOptions = d.getElementById('two-factor-webauthn-options')
+  const webauthnButton = d.getElementById('two-factor-webauthn')
+  const mailFallbackButton = d.getElementById('two-factor-mail-fallback')
 
   const storage = w.localStorage
 
@@ -82,5 +85,89 @@
       .catch((err) => w.showError(twofaField, err))
   }
 
+  // The binary values are exchanged with the stack in base64url
+  const fromBase64url = function (str) {
+    const base64 = str.replace(/-/g, '+').replace(/_/g, '/')
+    const bin = w.atob(base64)
+    const bytes = new Uint8Array(bin.length)
+    for (let i = 0; i < bin.length; i++) {
+      bytes[i] = bin.charCodeAt(i)
+    }
+    return bytes.buffer
+  }
+
+  const toBase64url = function (buffer) {
+    const bytes = new Uint8Array(buffer)
+    let bin = ''
+    for (let i = 0; i < bytes.length; i++) {
+      bin += String.fromCharCode(bytes[i])
+    }
+    return w
+      .btoa(bin)
+      .replace(/\+/g, '-')
+      .replace(/\//g, '_')
+      .replace(/=+$/, '')
+  }
+
+  const onClickWebAuthn = function () {
+    const options = JSON.parse(webauthnOptions.value)
+    options.challenge = fromBase64url(options.challenge)
+    options.allowCredentials = options.allowCredentials.map(function (cred) {
+      return { type: cred.type, id: fromBase64url(cred.id) }
+    })
+    return w.navigator.credentials
+      .get({ publicKey: options })
+      .then(function (assertion) {
+        passcodeInput.value = JSON.stringify({
+          id: assertion.id,
+          response: {
+            clientDataJSON: toBase64url(assertion.response.clientDataJSON),
+            authenticatorData: toBase64url(
+              assertion.response.authenticatorData
+            ),
+            signature: toBase64url(assertion.response.signature),
+          },
+        })
+        return onSubmitTwoFactorCode(new Event('submit'))
+      })
+      .catch((err) => w.showError(twofaField, err))
+  }
+
+  const onClickMailFallback = function () {
+    mailFallbackButton.setAttribute('disabled', true)
+    const data = new URLSearchParams()
+    data.append('two-factor-token', tokenInput.value)
+    const headers = new Headers()
+    headers.append('Content-Type', 'application/x-www-form-urlencoded')
+    headers.append('Accept', 'application/json')
+    return fetch('/auth/twofactor/mail', {
+      method: 'POST',
+      headers: headers,
+      body: data,
+      credentials: 'same-origin',
+    })
+      .then((response) => {
+        return response.json().then(function (body) {
+          if (response.status < 400) {
+            tokenInput.value = body.two_factor_token
+            mailFallbackButton.textContent = mailFallbackButton.dataset.sent
+          } else {
+            w.showError(twofaField, body.error)
+          }
+        })
+      })
+      .catch((err) => w.showError(twofaField, err))
+  }
+
   twofaForm.addEventListener('submit', onSubmitTwoFactorCode)
+  if (webauthnButton && webauthnOptions && webauthnOptions.value) {
+    if (w.PublicKeyCredential) {
+      webauthnButton.addEventListener('click', onClickWebAuthn)
+    } else {
+      webauthnButton.classList.add('d-none')
+    }
+  }
+  if (mailFallbackButton) {
+    mailFallbackButton.addEventListener('click', onClickMailFallback)
+  }
 })(window, document)
@@ -190,6 +190,18 @@ func (c *Client) UpdateApp(opts *AppOptions, safe bool) (*AppManifest, error) {
 	return readAppManifestStream(res)
 }
 
+// RollbackApp is used to reinstall the previous version of a webapp.
+func (c *Client) RollbackApp(opts *AppOptions) (*AppManifest, error) {
+	res, err := c.Req(&request.Options{
+		Method: "POST",
+		Path:   makeAppsPath(opts.AppType, url.PathEscape(opts.Slug)+"/rollback"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readAppManifest(res)
+}
+
 // UninstallApp is used to uninstall an application.
 func (c *Client) UninstallApp(opts *AppOptions) (*AppManifest, error) {
 	res, err := c.Req(&request.Options{
@@ -98,6 +98,7 @@ type UpdatesOptions struct {
 	Slugs              []string
 	ForceRegistry      bool
 	OnlyRegistry       bool
+	SkipRollout        bool
 	Logs               chan *JobLog
 }
 
@@ -373,6 +374,7 @@ func (c *Client) Updates(opts *UpdatesOptions) error {
 		"Slugs":              {strings.Join(opts.Slugs, ",")},
 		"ForceRegistry":      {strconv.FormatBool(opts.ForceRegistry)},
 		"OnlyRegistry":       {strconv.FormatBool(opts.OnlyRegistry)},
+		"SkipRollout":        {strconv.FormatBool(opts.SkipRollout)},
 	}
 	channel, err := c.RealtimeClient(RealtimeOptions{
 		DocTypes: []string{"io.cozy.jobs", "io.cozy.jobs.logs"},
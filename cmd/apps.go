@@ -75,6 +75,30 @@ var updateWebappCmd = &cobra.Command{
 	},
 }
 
+var rollbackWebappCmd = &cobra.Command{
+	Use:   "rollback <slug>",
+	Short: "Reinstall the previous version of the application with the specified slug name.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			errPrintfln("%s", errMissingDomain)
+			return cmd.Usage()
+		}
+		c := newClient(flagDomain, consts.Apps)
+		manifest, err := c.RollbackApp(&client.AppOptions{
+			AppType: consts.Apps,
+			Slug:    args[0],
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s has been rolled back to %s\n", manifest.Attrs.Slug, manifest.Attrs.Version)
+		return nil
+	},
+}
+
 var uninstallWebappCmd = &cobra.Command{
 	Use:     "uninstall <slug>",
 	Short:   "Uninstall the application with the specified slug name.",
@@ -670,6 +694,7 @@ func init() {
 	webappsCmdGroup.AddCommand(showWebappCmd)
 	webappsCmdGroup.AddCommand(installWebappCmd)
 	webappsCmdGroup.AddCommand(updateWebappCmd)
+	webappsCmdGroup.AddCommand(rollbackWebappCmd)
 	webappsCmdGroup.AddCommand(uninstallWebappCmd)
 
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
@@ -17,6 +17,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/keymgmt"
 	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/howeyc/gopass"
 	"github.com/spf13/cobra"
 )
@@ -143,6 +144,49 @@ keyfiles written in:
 	},
 }
 
+var genVAPIDKeyCmd = &cobra.Command{
+	Use:   "gen-vapid-key <filepath>",
+	Short: "Generate a VAPID key for the Web Push notifications",
+	Long: `
+cozy-stack config gen-vapid-key generates a VAPID key, used for sending the Web
+Push notifications to the browsers, and saves it in the specified path.
+
+The path of this file can then be used for the vapid_private_key_path parameter
+of the notifications section of the configuration file. The public key is
+printed, but it can also be fetched by the web apps with the
+GET /notifications/webpush/key route.
+
+The file permissions are 0400.`,
+
+	Example: `$ cozy-stack config gen-vapid-key ~/vapid.pem
+key file written in:
+  ~/vapid.pem
+public key:
+  BEl62iUYgUivxIkv69yViEuiBIa-Ib9-SkvMeAtA3LFgDzkrxZJjSgSnfckjBJuBkr3qBUYIHBQFLXYp5Nksh8U
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+
+		filename := filepath.Join(utils.AbsPath(args[0]))
+		key, err := webpush.GenerateKey()
+		if err != nil {
+			return err
+		}
+		marshaled, err := webpush.MarshalKey(key)
+		if err != nil {
+			return err
+		}
+		if err = writeFile(filename, marshaled, 0400); err != nil {
+			return err
+		}
+		errPrintfln("key file written in:\n  %s", filename)
+		fmt.Printf("public key:\n  %s\n", webpush.EncodePublicKey(&key.PublicKey))
+		return nil
+	},
+}
+
 var encryptCredentialsDataCmd = &cobra.Command{
 	Use:   "encrypt-data <encoding keyfile> <text>",
 	Short: "Encrypt data with the specified encryption keyfile.",
@@ -398,6 +442,7 @@ var listContextsCmd = &cobra.Command{
 func init() {
 	configCmdGroup.AddCommand(adminPasswdCmd)
 	configCmdGroup.AddCommand(genKeysCmd)
+	configCmdGroup.AddCommand(genVAPIDKeyCmd)
 	configCmdGroup.AddCommand(encryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(decryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(encryptCredentialsCmd)
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/spf13/cobra"
+)
+
+var flagContactsGroup string
+var flagContactsFormat string
+var flagContactsVCardVersion string
+
+var contactsCmdGroup = &cobra.Command{
+	Use:     "contacts <command>",
+	Aliases: []string{"contact"},
+	Short:   "Import and export the contacts",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var importContactsCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import the contacts from a vCard or CSV file",
+	Long: `
+cozy-stack contacts import imports the contacts from a vCard file (3.0 or 4.0),
+or from a CSV file exported by Google Contacts or Outlook.
+
+The contacts with the same email address, phone number or cozy URL as an
+existing contact are merged in it.
+`,
+	Example: "$ cozy-stack contacts import --domain cozy.localhost:8080 ~/contacts.vcf",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			errPrintfln("%s", errMissingDomain)
+			return cmd.Usage()
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		contentType := "text/vcard"
+		if strings.ToLower(filepath.Ext(args[0])) == ".csv" {
+			contentType = "text/csv"
+		}
+		queries := url.Values{}
+		if flagContactsGroup != "" {
+			queries.Add("Group", flagContactsGroup)
+		}
+		scopes := []string{consts.Contacts}
+		if flagContactsGroup != "" {
+			scopes = append(scopes, consts.ContactsGroups)
+		}
+		c := newClient(flagDomain, scopes...)
+		res, err := c.Req(&request.Options{
+			Method:  "POST",
+			Path:    "/contacts/import",
+			Queries: queries,
+			Headers: request.Headers{"Content-Type": contentType},
+			Body:    file,
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		var result struct {
+			Created   int `json:"created"`
+			Merged    int `json:"merged"`
+			Unchanged int `json:"unchanged"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return err
+		}
+		fmt.Printf("Created: %d\nMerged: %d\nUnchanged: %d\n",
+			result.Created, result.Merged, result.Unchanged)
+		return nil
+	},
+}
+
+var exportContactsCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the contacts to a vCard or CSV file",
+	Long: `
+cozy-stack contacts export exports the contacts, or the contacts of a group, in
+the vCard or CSV format. The contacts are written on the standard output if no
+file is given.
+`,
+	Example: "$ cozy-stack contacts export --domain cozy.localhost:8080 --format csv contacts.csv",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return cmd.Usage()
+		}
+		if flagDomain == "" {
+			errPrintfln("%s", errMissingDomain)
+			return cmd.Usage()
+		}
+		queries := url.Values{
+			"Format":  {flagContactsFormat},
+			"Version": {flagContactsVCardVersion},
+		}
+		scopes := []string{consts.Contacts}
+		if flagContactsGroup != "" {
+			queries.Add("Group", flagContactsGroup)
+			scopes = append(scopes, consts.ContactsGroups)
+		}
+		c := newClient(flagDomain, scopes...)
+		res, err := c.Req(&request.Options{
+			Method:  "GET",
+			Path:    "/contacts/export",
+			Queries: queries,
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		var out io.Writer = os.Stdout
+		if len(args) == 1 {
+			file, err := os.Create(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		_, err = io.Copy(out, res.Body)
+		return err
+	},
+}
+
+func init() {
+	importContactsCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
+	importContactsCmd.Flags().StringVar(&flagContactsGroup, "group", "", "Add the imported contacts to the group with this identifier")
+	exportContactsCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
+	exportContactsCmd.Flags().StringVar(&flagContactsGroup, "group", "", "Export only the contacts of the group with this identifier")
+	exportContactsCmd.Flags().StringVar(&flagContactsFormat, "format", "vcard", "The format of the export: vcard or csv")
+	exportContactsCmd.Flags().StringVar(&flagContactsVCardVersion, "vcard-version", "3.0", "The version of vCard: 3.0 or 4.0")
+
+	contactsCmdGroup.AddCommand(importContactsCmd)
+	contactsCmdGroup.AddCommand(exportContactsCmd)
+	RootCmd.AddCommand(contactsCmdGroup)
+}
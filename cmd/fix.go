@@ -25,6 +25,7 @@ import (
 var dryRunFlag bool
 var withMetadataFlag bool
 var noDryRunFlag bool
+var flagFixSharingMember int
 
 var fixerCmdGroup = &cobra.Command{
 	Use:     "fix <command>",
@@ -409,10 +410,60 @@ this instance are correctly set.
 	},
 }
 
+var sharingsFixer = &cobra.Command{
+	Use:   "sharings <domain> <sharing-id>",
+	Short: "Repair the synchronization of a sharing",
+	Long: `
+This fixer tries to repair a sharing where the synchronization is stuck. For
+each member (or only the given one), the missing triggers are recreated, the
+last sequence numbers are reset, the credentials are refreshed, and the initial
+sync is started again.
+
+The health report of the sharing can be seen with the
+GET /sharings/:sharing-id/health route.
+`,
+	Example: "$ cozy-stack fix sharings alice.cozy.localhost ce8835a061d0ef68947afe69a0046722 --member 1",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		body := map[string]interface{}{"sharing_id": args[1]}
+		if flagFixSharingMember >= 0 {
+			body["member"] = flagFixSharingMember
+		}
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		c := newAdminClient()
+		path := fmt.Sprintf("/instances/%s/fixers/sharings", domain)
+		res, err := c.Req(&request.Options{
+			Method: "POST",
+			Path:   path,
+			Body:   bytes.NewReader(buf),
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		var result map[string][]string
+		if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return err
+		}
+		for member, actions := range result {
+			fmt.Printf("Member %s: %s\n", member, strings.Join(actions, ", "))
+		}
+		return nil
+	},
+}
+
 func init() {
 	thumbnailsFixer.Flags().BoolVar(&dryRunFlag, "dry-run", false, "Dry run")
 	thumbnailsFixer.Flags().BoolVar(&withMetadataFlag, "with-metadata", false, "Recalculate images metadata")
 	contentMismatch64Kfixer.Flags().BoolVar(&noDryRunFlag, "no-dry-run", false, "Do not dry run")
+	sharingsFixer.Flags().IntVar(&flagFixSharingMember, "member", -1, "Index of the member to fix (all the members by default)")
 
 	fixerCmdGroup.AddCommand(jobsFixer)
 	fixerCmdGroup.AddCommand(md5FixerCmd)
@@ -423,6 +474,7 @@ func init() {
 	fixerCmdGroup.AddCommand(contentMismatch64Kfixer)
 	fixerCmdGroup.AddCommand(orphanAccountFixer)
 	fixerCmdGroup.AddCommand(indexesFixer)
+	fixerCmdGroup.AddCommand(sharingsFixer)
 
 	RootCmd.AddCommand(fixerCmdGroup)
 }
@@ -42,6 +42,7 @@ var flagForce bool
 var flagJSON bool
 var flagForceRegistry bool
 var flagOnlyRegistry bool
+var flagSkipRollout bool
 var flagSwiftLayout int
 var flagUUID string
 var flagOIDCID string
@@ -874,6 +875,7 @@ updated.`,
 				Slugs:         args,
 				ForceRegistry: flagForceRegistry,
 				OnlyRegistry:  flagOnlyRegistry,
+				SkipRollout:   flagSkipRollout,
 				Logs:          logs,
 			})
 		}
@@ -886,6 +888,7 @@ updated.`,
 			Slugs:              args,
 			ForceRegistry:      flagForceRegistry,
 			OnlyRegistry:       flagOnlyRegistry,
+			SkipRollout:        flagSkipRollout,
 		})
 	},
 }
@@ -1127,6 +1130,7 @@ func init() {
 	updateCmd.Flags().StringVar(&flagContextName, "context-name", "", "Work only on the instances with the given context name")
 	updateCmd.Flags().BoolVar(&flagForceRegistry, "force-registry", false, "Force to update all applications sources from git to the registry")
 	updateCmd.Flags().BoolVar(&flagOnlyRegistry, "only-registry", false, "Only update applications installed from the registry")
+	updateCmd.Flags().BoolVar(&flagSkipRollout, "skip-rollout", false, "Update the webapps on all the instances, even with a staged rollout")
 	exportCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
 	importCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
 	importCmd.Flags().BoolVar(&flagForce, "force", false, "Force the import without asking for confirmation")
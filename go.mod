@@ -44,6 +44,7 @@ require (
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.8.1
 	github.com/stretchr/testify v1.7.0
+	github.com/tetratelabs/wazero v1.2.1
 	github.com/ugorji/go/codec v1.2.6
 	golang.org/x/crypto v0.0.0-20210813211128-0a44fdfbc16e
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
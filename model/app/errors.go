@@ -34,4 +34,7 @@ var (
 	ErrBadChecksum = errors.New("Application checksum does not match")
 	// ErrLinkedAppExists is used when an OAuth client is linked to this app
 	ErrLinkedAppExists = errors.New("A linked OAuth client exists for this app")
+	// ErrNoPreviousVersion is used when trying to rollback a webapp that has
+	// no previous version available
+	ErrNoPreviousVersion = errors.New("No previous version is available for this app")
 )
@@ -49,6 +49,7 @@ type Installer struct {
 
 	overridenParameters map[string]interface{}
 	permissionsAcked    bool
+	allowRolledBack     bool
 
 	man     Manifest
 	src     *url.URL
@@ -71,6 +72,10 @@ type InstallerOptions struct {
 	PermissionsAcked bool
 	Registries       []*url.URL
 
+	// Used to reinstall a version of a webapp that has been rolled back. This
+	// version is skipped by the automatic updates.
+	AllowRolledBack bool
+
 	// Used to override the "Parameters" field of konnectors during installation.
 	// This modification is useful to allow the parameterization of a konnector
 	// at its installation as we do not have yet a registry up and running.
@@ -172,6 +177,7 @@ func NewInstaller(in *instance.Instance, fs appfs.Copier, opts *InstallerOptions
 
 		overridenParameters: opts.OverridenParameters,
 		permissionsAcked:    opts.PermissionsAcked,
+		allowRolledBack:     opts.AllowRolledBack,
 
 		man:     man,
 		src:     src,
@@ -372,6 +378,14 @@ func (i *Installer) update() error {
 		makeUpdate = (newManifest.Version() != oldManifest.Version())
 	}
 
+	// A version of a webapp that has been rolled back is not reinstalled,
+	// except when it is explicitly asked.
+	if webapp, ok := oldManifest.(*WebappManifest); ok && makeUpdate && !i.allowRolledBack {
+		if v := webapp.RolledBackVersion(); v != "" && v == newManifest.Version() {
+			makeUpdate = false
+		}
+	}
+
 	// Check the possible permissions changes before updating. If the
 	// verifyPermissions flag is activated (for non manual updates for example),
 	// we cancel out the update and mark the UpdateAvailable field of the
@@ -442,6 +456,9 @@ func (i *Installer) update() error {
 		if err := i.fetcher.Fetch(i.src, i.fs, i.man); err != nil {
 			return err
 		}
+		if webapp, ok := i.man.(*WebappManifest); ok {
+			webapp.pushVersion(oldManifest.(*WebappManifest))
+		}
 		i.man.SetAvailableVersion("")
 		i.man.SetState(i.endState)
 	} else {
@@ -514,6 +531,9 @@ func (i *Installer) ReadManifest(state State) (Manifest, error) {
 			return nil, err
 		}
 	}
+	if err := set.CheckFields(); err != nil {
+		return nil, err
+	}
 
 	shouldOverrideParameters := (i.overridenParameters != nil &&
 		i.man.AppType() == consts.KonnectorType &&
@@ -569,6 +589,9 @@ func DoLazyUpdate(in *instance.Instance, man Manifest, copier appfs.Copier, regi
 	if err != nil || src.Scheme != "registry" {
 		return man
 	}
+	if !InRollout(in, man) {
+		return man
+	}
 	var v *registry.Version
 	channel, _ := getRegistryChannel(src)
 	v, errv := registry.GetLatestVersion(man.Slug(), channel, registries)
@@ -603,6 +626,9 @@ func DoLazyUpdate(in *instance.Instance, man Manifest, copier appfs.Copier, regi
 	if man.AvailableVersion() != "" && v.Version == man.AvailableVersion() {
 		return man
 	}
+	if webapp, ok := man.(*WebappManifest); ok && v.Version == webapp.RolledBackVersion() {
+		return man
+	}
 	if channel == "stable" && !isMoreRecent(man.Version(), v.Version) {
 		return man
 	}
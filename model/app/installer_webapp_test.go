@@ -8,6 +8,7 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/appfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -698,6 +699,65 @@ func TestWebappUpdateWithService(t *testing.T) {
 	assert.Equal(t, t1, t2)
 }
 
+func TestWebappRollback(t *testing.T) {
+	manGen = manifestWebapp
+	manName = app.WebappManifestName
+	doUpgrade(1)
+
+	inst, err := app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Install,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+		SourceURL: "git://localhost/",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err := inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	version1 := man.Version()
+
+	server := appfs.NewAferoFileServer(baseFS, nil)
+	_, err = app.Rollback(db, server, "cozy-app-rollback")
+	assert.Equal(t, app.ErrNoPreviousVersion, err)
+
+	doUpgrade(2)
+	inst, err = app.NewInstaller(db, fs, &app.InstallerOptions{
+		Operation: app.Update,
+		Type:      consts.WebappType,
+		Slug:      "cozy-app-rollback",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	man, err = inst.RunSync()
+	if !assert.NoError(t, err) {
+		return
+	}
+	version2 := man.Version()
+	assert.NotEqual(t, version1, version2)
+	history := man.(*app.WebappManifest).VersionsHistory()
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, version1, history[0].Version)
+		assert.Equal(t, "git://localhost/", history[0].Source)
+	}
+
+	rolled, err := app.Rollback(db, server, "cozy-app-rollback")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, version1, rolled.Version())
+	assert.Equal(t, version2, rolled.RolledBackVersion())
+	assert.Empty(t, rolled.VersionsHistory())
+
+	saved, err := app.GetWebappBySlug(db, "cozy-app-rollback")
+	assert.NoError(t, err)
+	assert.Equal(t, version1, saved.Version())
+	assert.Equal(t, version2, saved.RolledBackVersion())
+}
+
 func TestWebappUninstall(t *testing.T) {
 	manGen = manifestWebapp
 	manName = app.WebappManifestName
@@ -38,10 +38,11 @@ type KonnManifest struct {
 		Err              string                 `json:"error"`
 
 		// Just readers
-		Name            string `json:"name"`
-		Icon            string `json:"icon"`
-		Language        string `json:"language"`
-		OnDeleteAccount string `json:"on_delete_account"`
+		Name            string   `json:"name"`
+		Icon            string   `json:"icon"`
+		Language        string   `json:"language"`
+		OnDeleteAccount string   `json:"on_delete_account"`
+		AllowedHosts    []string `json:"allowed_hosts"`
 
 		// Fields with complex types
 		Permissions   permission.Set `json:"permissions"`
@@ -158,13 +159,18 @@ func (m *KonnManifest) Name() string { return m.val.Name }
 func (m *KonnManifest) Icon() string { return m.val.Icon }
 
 // Language returns the programming language used for executing the konnector
-// (only "node" for the moment).
+// ("node" by default, or "wasm" for a WebAssembly module).
 func (m *KonnManifest) Language() string { return m.val.Language }
 
 // OnDeleteAccount can be used to specify a file path which will be executed
 // when an account associated with the konnector is deleted.
 func (m *KonnManifest) OnDeleteAccount() string { return m.val.OnDeleteAccount }
 
+// AllowedHosts returns the list of hosts that the konnector can reach when it
+// is executed in the sandbox. A host can start with "*." to allow all its
+// subdomains.
+func (m *KonnManifest) AllowedHosts() []string { return m.val.AllowedHosts }
+
 // VendorLink returns the vendor link.
 func (m *KonnManifest) VendorLink() interface{} {
 	return m.doc.M["vendor_link"]
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// MaxVersionsHistory is the maximal number of previous versions kept in the
+// history of a webapp.
+const MaxVersionsHistory = 10
+
+// VersionEntry is a previous version of a webapp, kept in the history of its
+// manifest. The files of this version are still available in appfs.
+type VersionEntry struct {
+	Version     string    `json:"version"`
+	Checksum    string    `json:"checksum,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	InstalledAt time.Time `json:"installed_at"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+}
+
+// Rollout is the configuration of a staged rollout for the updates of a
+// webapp in a context: a new version is only installed automatically on the
+// canary instances and on a percentage of the other instances.
+type Rollout struct {
+	Percentage int
+	Canaries   []string
+}
+
+// GetRollout returns the rollout configuration of the webapp with the given
+// slug for the given context. It can be configured for a slug, or for all the
+// webapps with the "default" key. It returns nil if the updates are not
+// staged.
+func GetRollout(contextName, slug string) *Rollout {
+	contexts := config.GetConfig().Contexts
+	if contexts == nil {
+		return nil
+	}
+	context, ok := contexts[contextName].(map[string]interface{})
+	if !ok {
+		context, ok = contexts[config.DefaultInstanceContext].(map[string]interface{})
+	}
+	if !ok {
+		return nil
+	}
+	channels, ok := context["rollout"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	channel, ok := channels[slug].(map[string]interface{})
+	if !ok {
+		channel, ok = channels["default"].(map[string]interface{})
+	}
+	if !ok {
+		return nil
+	}
+
+	r := &Rollout{Percentage: 100}
+	switch p := channel["percentage"].(type) {
+	case int:
+		r.Percentage = p
+	case float64:
+		r.Percentage = int(p)
+	}
+	if canaries, ok := channel["canaries"].([]interface{}); ok {
+		for _, c := range canaries {
+			if domain, ok := c.(string); ok {
+				r.Canaries = append(r.Canaries, domain)
+			}
+		}
+	}
+	return r
+}
+
+// Includes returns true if the instance with the given domain should receive
+// the new versions of the webapp. The choice is stable: an instance that is in
+// the first 10% will also be in the first 20%.
+func (r *Rollout) Includes(domain, slug string) bool {
+	if r == nil || r.Percentage >= 100 {
+		return true
+	}
+	for _, canary := range r.Canaries {
+		if canary == domain {
+			return true
+		}
+	}
+	if r.Percentage <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(slug + "/" + domain))
+	return int(h.Sum32()%100) < r.Percentage
+}
+
+// InRollout returns true if the instance can receive the automatic updates of
+// the given application, according to the rollout of its context.
+func InRollout(inst *instance.Instance, man Manifest) bool {
+	if man.AppType() != consts.WebappType {
+		return true
+	}
+	return GetRollout(inst.ContextName, man.Slug()).Includes(inst.Domain, man.Slug())
+}
+
+// pushVersion adds the version of the old manifest to the history of the new
+// one.
+func (m *WebappManifest) pushVersion(old *WebappManifest) {
+	if old.val.Version == m.val.Version && old.val.Checksum == m.val.Checksum {
+		return
+	}
+	installedAt := old.val.UpdatedAt
+	if len(old.val.VersionsHistory) == 0 && installedAt.IsZero() {
+		installedAt = old.val.CreatedAt
+	}
+	entry := VersionEntry{
+		Version:     old.val.Version,
+		Checksum:    old.val.Checksum,
+		Source:      old.val.Source,
+		InstalledAt: installedAt,
+		ReplacedAt:  time.Now(),
+	}
+	history := append([]VersionEntry{entry}, old.val.VersionsHistory...)
+	if len(history) > MaxVersionsHistory {
+		history = history[:MaxVersionsHistory]
+	}
+	m.val.VersionsHistory = history
+	m.val.RolledBackVersion = ""
+}
+
+// Rollback reinstalls the previous version of a webapp. Its files are still in
+// appfs, so nothing is downloaded and it is instant. The current version is
+// marked as rolled back, and it won't be reinstalled by the automatic
+// updates.
+func Rollback(inst *instance.Instance, fs appfs.FileServer, slug string) (*WebappManifest, error) {
+	man, err := GetWebappBySlug(inst, slug)
+	if err != nil {
+		return nil, err
+	}
+	if man.FromAppsDir || len(man.val.VersionsHistory) == 0 {
+		return nil, ErrNoPreviousVersion
+	}
+	if s := man.State(); s != Ready && s != Installed {
+		return nil, ErrBadState
+	}
+
+	prev := man.val.VersionsHistory[0]
+	r, err := fs.Open(slug, prev.Version, prev.Checksum, WebappManifestName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPreviousVersion
+		}
+		return nil, err
+	}
+	defer r.Close()
+	newManifest, err := man.ReadManifest(io.LimitReader(r, ManifestMaxSize), slug, prev.Source)
+	if err != nil {
+		return nil, err
+	}
+	rolled := newManifest.(*WebappManifest)
+	rolled.val.Version = prev.Version
+	rolled.val.Checksum = prev.Checksum
+	rolled.val.VersionsHistory = man.val.VersionsHistory[1:]
+	rolled.val.RolledBackVersion = man.val.Version
+
+	// Keep the permissions that were added after the installation
+	extraPerms := permission.Set{}
+	alteredPerms, err := permission.GetForWebapp(inst, slug)
+	if err != nil {
+		return nil, err
+	}
+	if alteredPerms != nil {
+		extraPerms, err = permission.Diff(man.Permissions(), alteredPerms.Permissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := rolled.Update(inst, extraPerms); err != nil {
+		return nil, err
+	}
+	realtime.GetHub().Publish(inst, realtime.EventUpdate, rolled.Clone(), nil)
+	return rolled, nil
+}
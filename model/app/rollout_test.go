@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutIncludes(t *testing.T) {
+	var none *Rollout
+	assert.True(t, none.Includes("alice.cozy.example", "drive"))
+
+	r := &Rollout{Percentage: 0, Canaries: []string{"alice.cozy.example"}}
+	assert.True(t, r.Includes("alice.cozy.example", "drive"))
+	assert.False(t, r.Includes("bob.cozy.example", "drive"))
+
+	// An instance included with a low percentage is still included with a
+	// higher one.
+	low := &Rollout{Percentage: 10}
+	high := &Rollout{Percentage: 50}
+	count := 0
+	for i := 0; i < 1000; i++ {
+		domain := fmt.Sprintf("user%d.cozy.example", i)
+		if low.Includes(domain, "drive") {
+			count++
+			assert.True(t, high.Includes(domain, "drive"))
+		}
+	}
+	assert.InDelta(t, 100, count, 40)
+}
+
+func TestGetRollout(t *testing.T) {
+	cfg := config.GetConfig()
+	was := cfg.Contexts
+	defer func() { cfg.Contexts = was }()
+	cfg.Contexts = map[string]interface{}{
+		"beta": map[string]interface{}{
+			"rollout": map[string]interface{}{
+				"default": map[string]interface{}{
+					"percentage": 50,
+				},
+				"drive": map[string]interface{}{
+					"percentage": 10,
+					"canaries":   []interface{}{"alice.cozy.example"},
+				},
+			},
+		},
+	}
+
+	r := GetRollout("beta", "drive")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 10, r.Percentage)
+		assert.Equal(t, []string{"alice.cozy.example"}, r.Canaries)
+	}
+	r = GetRollout("beta", "photos")
+	if assert.NotNil(t, r) {
+		assert.Equal(t, 50, r.Percentage)
+		assert.Empty(t, r.Canaries)
+	}
+	assert.Nil(t, GetRollout("other", "drive"))
+}
+
+func TestPushVersion(t *testing.T) {
+	old := &WebappManifest{}
+	old.val.Version = "1.0.0"
+	old.val.Checksum = "abc"
+	old.val.Source = "registry://drive/stable"
+	old.val.CreatedAt = time.Now().Add(-time.Hour)
+	old.val.RolledBackVersion = "1.1.0"
+
+	same := &WebappManifest{}
+	same.val.Version = "1.0.0"
+	same.val.Checksum = "abc"
+	same.pushVersion(old)
+	assert.Empty(t, same.val.VersionsHistory)
+
+	man := &WebappManifest{}
+	man.val.Version = "1.2.0"
+	man.val.RolledBackVersion = old.val.RolledBackVersion
+	man.pushVersion(old)
+	if assert.Len(t, man.val.VersionsHistory, 1) {
+		entry := man.val.VersionsHistory[0]
+		assert.Equal(t, "1.0.0", entry.Version)
+		assert.Equal(t, "abc", entry.Checksum)
+		assert.Equal(t, "registry://drive/stable", entry.Source)
+		assert.Equal(t, old.val.CreatedAt, entry.InstalledAt)
+	}
+	assert.Empty(t, man.val.RolledBackVersion)
+
+	for i := 0; i < 2*MaxVersionsHistory; i++ {
+		next := &WebappManifest{}
+		next.val.Version = fmt.Sprintf("2.%d.0", i)
+		next.pushVersion(man)
+		man = next
+	}
+	assert.Len(t, man.val.VersionsHistory, MaxVersionsHistory)
+	assert.Equal(t, "2.18.0", man.val.VersionsHistory[0].Version)
+}
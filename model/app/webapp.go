@@ -47,6 +47,10 @@ type Service struct {
 	Debounce       string `json:"debounce"`
 	TriggerOptions string `json:"trigger"`
 	TriggerID      string `json:"trigger_id"`
+
+	// AllowedHosts is the list of hosts that a service shipped as a
+	// WebAssembly module can reach.
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
 }
 
 // Services is a map to define services assciated with an application.
@@ -92,6 +96,10 @@ type WebappManifest struct {
 		UpdatedAt        time.Time `json:"updated_at"`
 		Err              string    `json:"error"`
 
+		// Fields managed by the stack for the rollbacks
+		VersionsHistory   []VersionEntry `json:"versions_history,omitempty"`
+		RolledBackVersion string         `json:"rolled_back_version,omitempty"`
+
 		// Just readers
 		Name       string `json:"name"`
 		NamePrefix string `json:"name_prefix"`
@@ -205,6 +213,17 @@ func (m *WebappManifest) Services() Services {
 	return m.val.Services
 }
 
+// VersionsHistory returns the previous versions of the webapp, from the most
+// recent to the oldest.
+func (m *WebappManifest) VersionsHistory() []VersionEntry {
+	return m.val.VersionsHistory
+}
+
+// RolledBackVersion returns the version that has been rolled back, if any.
+func (m *WebappManifest) RolledBackVersion() string {
+	return m.val.RolledBackVersion
+}
+
 // SetError is part of the Manifest interface
 func (m *WebappManifest) SetError(err error) {
 	m.SetState(Errored)
@@ -255,6 +274,16 @@ func (m *WebappManifest) MarshalJSON() ([]byte, error) {
 	} else {
 		m.doc.M["error"] = m.val.Err
 	}
+	if len(m.val.VersionsHistory) == 0 {
+		delete(m.doc.M, "versions_history")
+	} else {
+		m.doc.M["versions_history"] = m.val.VersionsHistory
+	}
+	if m.val.RolledBackVersion == "" {
+		delete(m.doc.M, "rolled_back_version")
+	} else {
+		m.doc.M["rolled_back_version"] = m.val.RolledBackVersion
+	}
 	// XXX: keep the weird UnmarshalJSON of permission.Set
 	m.doc.M["permissions"] = m.val.Permissions
 	return json.Marshal(m.doc)
@@ -285,6 +314,8 @@ func (m *WebappManifest) ReadManifest(r io.Reader, slug, sourceURL string) (Mani
 	newManifest.val.Source = sourceURL
 	newManifest.Instance = m.Instance
 	newManifest.oldServices = m.val.Services
+	newManifest.val.VersionsHistory = m.val.VersionsHistory
+	newManifest.val.RolledBackVersion = m.val.RolledBackVersion
 	if newManifest.val.Routes == nil {
 		newManifest.val.Routes = make(Routes)
 		newManifest.val.Routes["/"] = Route{
@@ -0,0 +1,235 @@
+package bitwarden
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// AttachmentsDirName is the path of the directory where the encrypted content
+// of the attachments is stored. As they are normal files in the VFS, they are
+// counted in the disk quota of the instance.
+const AttachmentsDirName = "/.cozy_bitwarden/attachments"
+
+// MaxAttachmentSize is the maximal size of an attachment (100MB, like on
+// Bitwarden).
+const MaxAttachmentSize = 100 * 1024 * 1024
+
+var (
+	// ErrAttachmentNotFound is used when the cipher has no attachment with the
+	// given identifier.
+	ErrAttachmentNotFound = errors.New("Attachment not found")
+	// ErrAttachmentTooBig is used when the attachment exceeds the maximal size
+	// or the available disk space.
+	ErrAttachmentTooBig = errors.New("Attachment is too big")
+)
+
+// Attachment is a file attached to a cipher. Its name and its content are
+// encrypted on client-side, with a key that is itself encrypted.
+type Attachment struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Key      string `json:"key,omitempty"`
+	Size     int64  `json:"size"`
+	// FileID is the identifier of the io.cozy.files with the encrypted
+	// content. It is empty until the content has been uploaded.
+	FileID string `json:"file_id,omitempty"`
+	// Domain is the domain of the instance where the content has been
+	// uploaded. The ciphers shared with the Cozy organization are replicated
+	// on the instances of the other members, but not the content of their
+	// attachments.
+	Domain string `json:"domain,omitempty"`
+}
+
+// NewAttachment returns an attachment, with a new identifier, whose content
+// has not been uploaded.
+func NewAttachment(fileName, key string, size int64) (*Attachment, error) {
+	if size > MaxAttachmentSize {
+		return nil, ErrAttachmentTooBig
+	}
+	return &Attachment{
+		ID:       crypto.GenerateRandomString(32),
+		FileName: fileName,
+		Key:      key,
+		Size:     size,
+	}, nil
+}
+
+// IsUploadedOn returns true if the content of the attachment has been
+// uploaded on the given instance.
+func (a *Attachment) IsUploadedOn(inst *instance.Instance) bool {
+	return a.FileID != "" && a.Domain == inst.Domain
+}
+
+// Path returns the path of the file with the content of the attachment.
+func (a *Attachment) Path() string {
+	return path.Join(AttachmentsDirName, a.ID)
+}
+
+// FindAttachment returns the attachment of the cipher with the given
+// identifier.
+func (c *Cipher) FindAttachment(id string) (*Attachment, error) {
+	for i := range c.Attachments {
+		if c.Attachments[i].ID == id {
+			return &c.Attachments[i], nil
+		}
+	}
+	return nil, ErrAttachmentNotFound
+}
+
+// RemoveAttachment removes the attachment with the given identifier from the
+// cipher. It does not delete its content.
+func (c *Cipher) RemoveAttachment(id string) {
+	attachments := c.Attachments[:0]
+	for _, a := range c.Attachments {
+		if a.ID != id {
+			attachments = append(attachments, a)
+		}
+	}
+	c.Attachments = attachments
+}
+
+// CheckAttachmentSize returns an error if the attachment exceeds the
+// available disk space of the instance.
+func CheckAttachmentSize(inst *instance.Instance, size int64) error {
+	if size > MaxAttachmentSize {
+		return ErrAttachmentTooBig
+	}
+	fs := inst.VFS()
+	quota := fs.DiskQuota()
+	if quota <= 0 {
+		return nil
+	}
+	used, err := fs.DiskUsage()
+	if err != nil {
+		return err
+	}
+	if used+size > quota {
+		return ErrAttachmentTooBig
+	}
+	return nil
+}
+
+// UploadAttachment writes the encrypted content of the attachment in the
+// VFS. If the attachment has already a content, it is replaced.
+func UploadAttachment(inst *instance.Instance, a *Attachment, content io.Reader, size int64) error {
+	if size > MaxAttachmentSize {
+		return ErrAttachmentTooBig
+	}
+	var oldFileID string
+	if a.IsUploadedOn(inst) {
+		oldFileID = a.FileID
+	}
+	fileID, err := writeEncryptedFile(inst, AttachmentsDirName, a.ID, oldFileID, content, size)
+	if err != nil {
+		if err == vfs.ErrFileTooBig {
+			return ErrAttachmentTooBig
+		}
+		return err
+	}
+	a.FileID = fileID
+	a.Domain = inst.Domain
+	a.Size = size
+	return nil
+}
+
+// DeleteAttachment deletes the content of the attachment.
+func DeleteAttachment(inst *instance.Instance, a *Attachment) error {
+	if !a.IsUploadedOn(inst) {
+		return nil
+	}
+	return destroyEncryptedFile(inst, AttachmentsDirName, a.ID, a.FileID)
+}
+
+// DeleteAttachments deletes the content of all the attachments of the given
+// ciphers.
+func DeleteAttachments(inst *instance.Instance, ciphers ...*Cipher) error {
+	var errd error
+	for _, c := range ciphers {
+		for i := range c.Attachments {
+			if err := DeleteAttachment(inst, &c.Attachments[i]); err != nil {
+				errd = err
+			}
+		}
+	}
+	return errd
+}
+
+// writeEncryptedFile writes some content encrypted on client-side in a file of
+// the given directory, and returns the identifier of this file. If oldFileID
+// is not empty, the content of this file is replaced (only if it is the file
+// with the given name in this directory).
+func writeEncryptedFile(inst *instance.Instance, dirName, name, oldFileID string, content io.Reader, size int64) (string, error) {
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, dirName)
+	if err != nil {
+		return "", err
+	}
+
+	var olddoc *vfs.FileDoc
+	if oldFileID != "" {
+		olddoc, err = encryptedFileByID(fs, dirName, name, oldFileID)
+		if err != nil {
+			return "", err
+		}
+	}
+	newdoc, err := vfs.NewFileDoc(name, dir.ID(), size, nil,
+		"application/octet-stream", "files", time.Now(), false, false, nil)
+	if err != nil {
+		return "", err
+	}
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	if err = file.Close(); err != nil {
+		return "", err
+	}
+	return newdoc.ID(), nil
+}
+
+// destroyEncryptedFile deletes the file with the given identifier, if it
+// exists and if it is the file with the given name in the directory.
+func destroyEncryptedFile(inst *instance.Instance, dirName, name, fileID string) error {
+	if fileID == "" {
+		return nil
+	}
+	fs := inst.VFS()
+	doc, err := encryptedFileByID(fs, dirName, name, fileID)
+	if err != nil || doc == nil {
+		return err
+	}
+	return fs.DestroyFile(doc)
+}
+
+// encryptedFileByID returns the file with the given identifier, or nil if it
+// does not exist or is not the file with the given name in the directory. The
+// identifier comes from a document that may have been replicated from another
+// instance, and must not be used to reach the other files.
+func encryptedFileByID(fs vfs.VFS, dirName, name, fileID string) (*vfs.FileDoc, error) {
+	doc, err := fs.FileByID(fileID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	fullpath, err := doc.Path(fs)
+	if err != nil {
+		return nil, err
+	}
+	if fullpath != path.Join(dirName, name) {
+		return nil, nil
+	}
+	return doc, nil
+}
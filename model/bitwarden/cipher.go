@@ -80,6 +80,7 @@ type Cipher struct {
 	Login          *LoginData             `json:"login,omitempty"`
 	Data           *MapData               `json:"data,omitempty"`
 	Fields         []Field                `json:"fields"`
+	Attachments    []Attachment           `json:"attachments,omitempty"`
 	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 	DeletedDate    *time.Time             `json:"deletedDate,omitempty"`
 }
@@ -109,6 +110,10 @@ func (c *Cipher) Clone() couchdb.Doc {
 	}
 	cloned.Fields = make([]Field, len(c.Fields))
 	copy(cloned.Fields, c.Fields)
+	if c.Attachments != nil {
+		cloned.Attachments = make([]Attachment, len(c.Attachments))
+		copy(cloned.Attachments, c.Attachments)
+	}
 	if c.Metadata != nil {
 		cloned.Metadata = c.Metadata.Clone()
 	}
@@ -170,6 +175,7 @@ func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
 		}
 		if !c.SharedWithCozy {
 			ciphers = append(ciphers, &c)
+			_ = DeleteAttachments(inst, &c)
 		}
 		return nil
 	})
@@ -0,0 +1,627 @@
+package bitwarden
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// EmergencyAccessType is the level of access given to the grantee.
+type EmergencyAccessType int
+
+// EmergencyAccessView is the only supported type: the grantee can read the
+// ciphers of the grantor. The takeover of the account is not possible on
+// Cozy, as the passphrase is also used for the whole instance.
+const EmergencyAccessView EmergencyAccessType = 0
+
+// EmergencyAccessStatus is the state of an emergency access.
+type EmergencyAccessStatus int
+
+const (
+	// EmergencyAccessInvited is used when the grantee has been invited, but
+	// has not yet accepted the invitation.
+	EmergencyAccessInvited EmergencyAccessStatus = 0
+	// EmergencyAccessAccepted is used when the grantee has accepted the
+	// invitation, and the grantor has not yet confirmed it.
+	EmergencyAccessAccepted EmergencyAccessStatus = 1
+	// EmergencyAccessConfirmed is used when the grantor has given their key,
+	// encrypted with the public key of the grantee.
+	EmergencyAccessConfirmed EmergencyAccessStatus = 2
+	// EmergencyAccessRecoveryInitiated is used when the grantee has asked for
+	// the access. It will be approved automatically after the waiting period
+	// if the grantor does not reject it.
+	EmergencyAccessRecoveryInitiated EmergencyAccessStatus = 3
+	// EmergencyAccessRecoveryApproved is used when the grantee can access the
+	// ciphers of the grantor.
+	EmergencyAccessRecoveryApproved EmergencyAccessStatus = 4
+)
+
+// EmergencyAccessWorker is the worker type used to approve the recovery of an
+// emergency access when the waiting period is over.
+const EmergencyAccessWorker = "bitwarden-emergency-access"
+
+var (
+	// ErrEmergencyAccessNotFound is used when the emergency access does not
+	// exist.
+	ErrEmergencyAccessNotFound = errors.New("Emergency access not found")
+	// ErrEmergencyAccessInvalidStatus is used when an action is not possible
+	// with the current status of the emergency access.
+	ErrEmergencyAccessInvalidStatus = errors.New("Emergency access in invalid state")
+	// ErrEmergencyAccessInvalidType is used for an unsupported type of
+	// emergency access.
+	ErrEmergencyAccessInvalidType = errors.New("Only the view access is supported")
+	// ErrEmergencyAccessUnknownGrantee is used when the grantee is not a
+	// contact with a known public key.
+	ErrEmergencyAccessUnknownGrantee = errors.New("The grantee must be a member of a shared organization")
+	// ErrEmergencyAccessInvalidToken is used when a stack sends a request for
+	// an emergency access with a wrong token.
+	ErrEmergencyAccessInvalidToken = errors.New("Invalid token")
+	// ErrEmergencyAccessUnknownGrantor is used when an invitation is received
+	// from a user who is not a member of an organization shared with the
+	// grantee.
+	ErrEmergencyAccessUnknownGrantor = errors.New("The grantor must be a member of a shared organization")
+)
+
+// EmergencyAccess is a trust relationship between a grantor and a grantee,
+// on two cozy instances. The grantee can ask to read the ciphers of the
+// grantor, and will have access to them after a waiting period, unless the
+// grantor rejects it. The same document, with the same identifier, is kept on
+// the instances of the grantor and of the grantee, and the two stacks use a
+// shared token to keep them in sync.
+type EmergencyAccess struct {
+	CouchID  string `json:"_id,omitempty"`
+	CouchRev string `json:"_rev,omitempty"`
+	// Grantor is true if the current instance is the one of the grantor.
+	Grantor bool `json:"grantor"`
+	// Email, Name, UserID, and Instance are about the other party.
+	Email               string                 `json:"email"`
+	Name                string                 `json:"name,omitempty"`
+	UserID              string                 `json:"user_id"`
+	Instance            string                 `json:"instance"`
+	Token               string                 `json:"token"`
+	Type                EmergencyAccessType    `json:"type"`
+	Status              EmergencyAccessStatus  `json:"status"`
+	WaitTimeDays        int                    `json:"wait_time_days"`
+	KeyEncrypted        string                 `json:"key_encrypted,omitempty"`
+	RecoveryInitiatedAt *time.Time             `json:"recovery_initiated_at,omitempty"`
+	Metadata            *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the emergency access qualified identifier
+func (e *EmergencyAccess) ID() string { return e.CouchID }
+
+// Rev returns the emergency access revision
+func (e *EmergencyAccess) Rev() string { return e.CouchRev }
+
+// DocType returns the emergency access document type
+func (e *EmergencyAccess) DocType() string { return consts.BitwardenEmergencyAccesses }
+
+// Clone implements couchdb.Doc
+func (e *EmergencyAccess) Clone() couchdb.Doc {
+	cloned := *e
+	if e.RecoveryInitiatedAt != nil {
+		date := *e.RecoveryInitiatedAt
+		cloned.RecoveryInitiatedAt = &date
+	}
+	if e.Metadata != nil {
+		cloned.Metadata = e.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the emergency access qualified identifier
+func (e *EmergencyAccess) SetID(id string) { e.CouchID = id }
+
+// SetRev changes the emergency access revision
+func (e *EmergencyAccess) SetRev(rev string) { e.CouchRev = rev }
+
+// CheckToken returns an error if the token sent by the other stack is not the
+// expected one.
+func (e *EmergencyAccess) CheckToken(token string) error {
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(e.Token)) != 1 {
+		return ErrEmergencyAccessInvalidToken
+	}
+	return nil
+}
+
+// RecoveryApprovalDate returns the date when the recovery will be approved
+// automatically.
+func (e *EmergencyAccess) RecoveryApprovalDate() time.Time {
+	if e.RecoveryInitiatedAt == nil {
+		return time.Time{}
+	}
+	return e.RecoveryInitiatedAt.Add(time.Duration(e.WaitTimeDays) * 24 * time.Hour)
+}
+
+// EmergencyAccessState is the part of an emergency access that is
+// synchronized between the stacks of the grantor and of the grantee.
+type EmergencyAccessState struct {
+	Type                EmergencyAccessType   `json:"type"`
+	Status              EmergencyAccessStatus `json:"status"`
+	WaitTimeDays        int                   `json:"wait_time_days"`
+	RecoveryInitiatedAt *time.Time            `json:"recovery_initiated_at,omitempty"`
+}
+
+// State returns the state of the emergency access.
+func (e *EmergencyAccess) State() *EmergencyAccessState {
+	return &EmergencyAccessState{
+		Type:                e.Type,
+		Status:              e.Status,
+		WaitTimeDays:        e.WaitTimeDays,
+		RecoveryInitiatedAt: e.RecoveryInitiatedAt,
+	}
+}
+
+// SetState updates the emergency access with the state sent by the other
+// stack.
+func (e *EmergencyAccess) SetState(state *EmergencyAccessState) {
+	e.Type = state.Type
+	e.Status = state.Status
+	e.WaitTimeDays = state.WaitTimeDays
+	e.RecoveryInitiatedAt = state.RecoveryInitiatedAt
+	if e.Metadata != nil {
+		e.Metadata.ChangeUpdatedAt()
+	}
+}
+
+// EmergencyAccessInvitation is sent by the stack of the grantor to the stack
+// of the grantee. The token is sent in the Authorization header.
+type EmergencyAccessInvitation struct {
+	ID           string              `json:"id"`
+	Token        string              `json:"-"`
+	Email        string              `json:"email"`
+	Name         string              `json:"name"`
+	UserID       string              `json:"user_id"`
+	Instance     string              `json:"instance"`
+	Type         EmergencyAccessType `json:"type"`
+	WaitTimeDays int                 `json:"wait_time_days"`
+}
+
+// GetEmergencyAccess returns the emergency access with the given identifier.
+func GetEmergencyAccess(inst *instance.Instance, id string) (*EmergencyAccess, error) {
+	e := &EmergencyAccess{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenEmergencyAccesses, id, e); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrEmergencyAccessNotFound
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// ListEmergencyAccesses returns the emergency accesses where the instance is
+// the grantor (if grantor is true), or the grantee.
+func ListEmergencyAccesses(inst *instance.Instance, grantor bool) ([]*EmergencyAccess, error) {
+	var all []*EmergencyAccess
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenEmergencyAccesses, req, &all); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list []*EmergencyAccess
+	for _, e := range all {
+		if e.Grantor == grantor {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+// findGrantee returns the contact with the given email, and the URL of their
+// instance. The grantee must have shared an organization with the grantor, as
+// it is how their public key has been exchanged.
+func findGrantee(inst *instance.Instance, email string) (*Contact, string, error) {
+	var contacts []*Contact
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenContacts, req, &contacts); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, "", ErrEmergencyAccessUnknownGrantee
+		}
+		return nil, "", err
+	}
+	var contact *Contact
+	for _, c := range contacts {
+		if c.Email == email && c.PublicKey != "" {
+			contact = c
+		}
+	}
+	if contact == nil {
+		return nil, "", ErrEmergencyAccessUnknownGrantee
+	}
+
+	grantee, err := findMemberInstance(inst, contact.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if grantee == "" {
+		return nil, "", ErrEmergencyAccessUnknownGrantee
+	}
+	return contact, grantee, nil
+}
+
+// findMemberInstance returns the URL of the instance of the user with the
+// given identifier, if they are a member of an organization shared with the
+// current instance, or an empty string.
+func findMemberInstance(inst *instance.Instance, userID string) (string, error) {
+	var orgs []*Organization
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenOrganizations, req, &orgs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, org := range orgs {
+		for domain, member := range org.Members {
+			if userID != "" && member.UserID == userID && domain != inst.Domain {
+				u := url.URL{Scheme: inst.Scheme(), Host: domain}
+				return u.String(), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// InviteEmergencyAccess creates an emergency access for the grantee with the
+// given email, and sends the invitation to their stack.
+func InviteEmergencyAccess(inst *instance.Instance, email string, typ EmergencyAccessType, waitTimeDays int) (*EmergencyAccess, error) {
+	if typ != EmergencyAccessView {
+		return nil, ErrEmergencyAccessInvalidType
+	}
+	contact, grantee, err := findGrantee(inst, email)
+	if err != nil {
+		return nil, err
+	}
+	md := metadata.New()
+	md.DocTypeVersion = DocTypeVersion
+	e := &EmergencyAccess{
+		Grantor:      true,
+		Email:        contact.Email,
+		UserID:       contact.UserID,
+		Instance:     grantee,
+		Token:        crypto.GenerateRandomString(32),
+		Type:         typ,
+		Status:       EmergencyAccessInvited,
+		WaitTimeDays: waitTimeDays,
+		Metadata:     md,
+	}
+	if err := couchdb.CreateDoc(inst, e); err != nil {
+		return nil, err
+	}
+	if err := SendEmergencyAccessInvitation(inst, e); err != nil {
+		_ = couchdb.DeleteDoc(inst, e)
+		return nil, err
+	}
+	return e, nil
+}
+
+// SendEmergencyAccessInvitation sends the invitation to the stack of the
+// grantee.
+func SendEmergencyAccessInvitation(inst *instance.Instance, e *EmergencyAccess) error {
+	if !e.Grantor || e.Status != EmergencyAccessInvited {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	name, _ := inst.PublicName()
+	invitation := &EmergencyAccessInvitation{
+		ID:           e.ID(),
+		Token:        e.Token,
+		Email:        string(inst.PassphraseSalt()),
+		Name:         name,
+		UserID:       inst.ID(),
+		Instance:     inst.PageURL("", nil),
+		Type:         e.Type,
+		WaitTimeDays: e.WaitTimeDays,
+	}
+	return e.remote(http.MethodPost, "/bitwarden/emergency-access", invitation, nil)
+}
+
+// ReceiveEmergencyAccessInvitation creates the emergency access on the stack
+// of the grantee. The grantor must be a member of an organization shared with
+// the grantee, and the invitation is checked with a request to the stack of
+// the grantor, at the address known for this member (not the one sent in the
+// invitation).
+func ReceiveEmergencyAccessInvitation(inst *instance.Instance, invitation *EmergencyAccessInvitation) (*EmergencyAccess, error) {
+	if invitation.ID == "" || invitation.Token == "" || invitation.Instance == "" {
+		return nil, ErrEmergencyAccessInvalidToken
+	}
+	if invitation.Type != EmergencyAccessView {
+		return nil, ErrEmergencyAccessInvalidType
+	}
+	grantor, err := findMemberInstance(inst, invitation.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if grantor == "" {
+		return nil, ErrEmergencyAccessUnknownGrantor
+	}
+	md := metadata.New()
+	md.DocTypeVersion = DocTypeVersion
+	e := &EmergencyAccess{
+		CouchID:      invitation.ID,
+		Grantor:      false,
+		Email:        invitation.Email,
+		Name:         invitation.Name,
+		UserID:       invitation.UserID,
+		Instance:     grantor,
+		Token:        invitation.Token,
+		Type:         invitation.Type,
+		Status:       EmergencyAccessInvited,
+		WaitTimeDays: invitation.WaitTimeDays,
+		Metadata:     md,
+	}
+	var state EmergencyAccessState
+	if err := e.remote(http.MethodGet, "/bitwarden/emergency-access/"+e.ID(), nil, &state); err != nil {
+		if err == ErrEmergencyAccessNotFound {
+			return nil, ErrEmergencyAccessInvalidToken
+		}
+		return nil, err
+	}
+	if state.Status != EmergencyAccessInvited {
+		return nil, ErrEmergencyAccessInvalidStatus
+	}
+	e.SetState(&state)
+	if old, err := GetEmergencyAccess(inst, invitation.ID); err == nil {
+		// The invitation has been sent again
+		if old.Grantor {
+			return nil, ErrEmergencyAccessInvalidStatus
+		}
+		if err := old.CheckToken(invitation.Token); err != nil {
+			return nil, err
+		}
+		e.SetRev(old.Rev())
+		return e, couchdb.UpdateDoc(inst, e)
+	}
+	return e, couchdb.CreateNamedDocWithDB(inst, e)
+}
+
+// UpdateEmergencyAccess changes the type and waiting period of an emergency
+// access, on the stack of the grantor.
+func UpdateEmergencyAccess(inst *instance.Instance, e *EmergencyAccess, typ EmergencyAccessType, waitTimeDays int) error {
+	if !e.Grantor {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	if typ != EmergencyAccessView {
+		return ErrEmergencyAccessInvalidType
+	}
+	e.Type = typ
+	e.WaitTimeDays = waitTimeDays
+	return e.saveAndNotify(inst)
+}
+
+// AcceptEmergencyAccess is called on the stack of the grantee to accept the
+// invitation.
+func AcceptEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if e.Grantor || e.Status != EmergencyAccessInvited {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	return e.forward(inst, "accept")
+}
+
+// ConfirmEmergencyAccess is called on the stack of the grantor to give the
+// key of the grantor, encrypted with the public key of the grantee.
+func ConfirmEmergencyAccess(inst *instance.Instance, e *EmergencyAccess, key string) error {
+	if !e.Grantor || e.Status != EmergencyAccessAccepted {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.KeyEncrypted = key
+	e.Status = EmergencyAccessConfirmed
+	return e.saveAndNotify(inst)
+}
+
+// InitiateEmergencyAccess is called on the stack of the grantee to ask for the
+// access to the ciphers of the grantor.
+func InitiateEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if e.Grantor || e.Status != EmergencyAccessConfirmed {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	return e.forward(inst, "initiate")
+}
+
+// ApproveEmergencyAccess is called on the stack of the grantor to approve the
+// recovery before the end of the waiting period.
+func ApproveEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if !e.Grantor || e.Status != EmergencyAccessRecoveryInitiated {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessRecoveryApproved
+	return e.saveAndNotify(inst)
+}
+
+// RejectEmergencyAccess is called on the stack of the grantor to reject the
+// recovery, or to revoke an approved one.
+func RejectEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if !e.Grantor {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	if e.Status != EmergencyAccessRecoveryInitiated && e.Status != EmergencyAccessRecoveryApproved {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessConfirmed
+	e.RecoveryInitiatedAt = nil
+	return e.saveAndNotify(inst)
+}
+
+// DeleteEmergencyAccess deletes the emergency access, on both stacks.
+func DeleteEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if err := e.remote(http.MethodDelete, "/bitwarden/emergency-access/"+e.ID(), nil, nil); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Infof("Cannot delete the emergency access %s on %s: %s", e.ID(), e.Instance, err)
+	}
+	return couchdb.DeleteDoc(inst, e)
+}
+
+// ReceiveEmergencyAccessAction applies on the stack of the grantor an action
+// of the grantee (accept or initiate).
+func ReceiveEmergencyAccessAction(inst *instance.Instance, e *EmergencyAccess, action string) error {
+	if !e.Grantor {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	switch action {
+	case "accept":
+		if e.Status != EmergencyAccessInvited {
+			return ErrEmergencyAccessInvalidStatus
+		}
+		e.Status = EmergencyAccessAccepted
+	case "initiate":
+		if e.Status != EmergencyAccessConfirmed {
+			return ErrEmergencyAccessInvalidStatus
+		}
+		now := time.Now()
+		e.Status = EmergencyAccessRecoveryInitiated
+		e.RecoveryInitiatedAt = &now
+		if e.WaitTimeDays <= 0 {
+			e.Status = EmergencyAccessRecoveryApproved
+		}
+	default:
+		return ErrEmergencyAccessInvalidStatus
+	}
+	if e.Metadata != nil {
+		e.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, e); err != nil {
+		return err
+	}
+	if e.Status == EmergencyAccessRecoveryInitiated {
+		return scheduleEmergencyAccessApproval(inst, e)
+	}
+	return nil
+}
+
+// EmergencyAccessMessage is the message for the job that approves the
+// recovery of an emergency access when the waiting period is over.
+type EmergencyAccessMessage struct {
+	EmergencyAccessID string `json:"emergency_access_id"`
+}
+
+// ApproveExpiredEmergencyAccess approves the recovery of the emergency access
+// with the given identifier if it is still initiated and the waiting period is
+// over.
+func ApproveExpiredEmergencyAccess(inst *instance.Instance, id string) error {
+	e, err := GetEmergencyAccess(inst, id)
+	if err != nil {
+		if err == ErrEmergencyAccessNotFound {
+			return nil
+		}
+		return err
+	}
+	if e.Status != EmergencyAccessRecoveryInitiated || e.RecoveryApprovalDate().After(time.Now()) {
+		return nil
+	}
+	return ApproveEmergencyAccess(inst, e)
+}
+
+func scheduleEmergencyAccessApproval(inst *instance.Instance, e *EmergencyAccess) error {
+	msg := &EmergencyAccessMessage{EmergencyAccessID: e.ID()}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@at",
+		WorkerType: EmergencyAccessWorker,
+		Arguments:  e.RecoveryApprovalDate().Format(time.RFC3339),
+	}, msg)
+	if err != nil {
+		return err
+	}
+	return job.System().AddTrigger(t)
+}
+
+// saveAndNotify saves the emergency access on the stack of the grantor, and
+// sends its new state to the stack of the grantee.
+func (e *EmergencyAccess) saveAndNotify(inst *instance.Instance) error {
+	if e.Metadata != nil {
+		e.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, e); err != nil {
+		return err
+	}
+	if e.Status == EmergencyAccessInvited {
+		return nil
+	}
+	if err := e.remote(http.MethodPut, "/bitwarden/emergency-access/"+e.ID(), e.State(), nil); err != nil {
+		inst.Logger().WithField("nspace", "bitwarden").
+			Infof("Cannot update the emergency access %s on %s: %s", e.ID(), e.Instance, err)
+	}
+	return nil
+}
+
+// forward sends an action of the grantee to the stack of the grantor, and
+// saves the new state.
+func (e *EmergencyAccess) forward(inst *instance.Instance, action string) error {
+	var state EmergencyAccessState
+	path := "/bitwarden/emergency-access/" + e.ID() + "/" + action
+	if err := e.remote(http.MethodPost, path, nil, &state); err != nil {
+		return err
+	}
+	e.SetState(&state)
+	return couchdb.UpdateDoc(inst, e)
+}
+
+// ViewEmergencyAccess asks the stack of the grantor for the encrypted key and
+// ciphers. The response is kept as is, to be sent to the client.
+func ViewEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) (json.RawMessage, error) {
+	if e.Grantor || e.Status != EmergencyAccessRecoveryApproved {
+		return nil, ErrEmergencyAccessInvalidStatus
+	}
+	var view json.RawMessage
+	path := "/bitwarden/emergency-access/" + e.ID() + "/view"
+	if err := e.remote(http.MethodPost, path, nil, &view); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// remote makes a request to the stack of the other party.
+func (e *EmergencyAccess) remote(method, path string, in, out interface{}) error {
+	u, err := url.Parse(e.Instance)
+	if err != nil {
+		return err
+	}
+	opts := &request.Options{
+		Method: method,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   path,
+		Headers: request.Headers{
+			"Accept":        "application/json",
+			"Authorization": "Bearer " + e.Token,
+		},
+	}
+	if in != nil {
+		body, err := request.WriteJSON(in)
+		if err != nil {
+			return err
+		}
+		opts.Headers["Content-Type"] = "application/json"
+		opts.Body = body
+	}
+	if out == nil {
+		opts.NoResponse = true
+	}
+	res, err := request.Req(opts)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return ErrEmergencyAccessNotFound
+		}
+		if res != nil && res.StatusCode == http.StatusConflict {
+			return ErrEmergencyAccessInvalidStatus
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return request.ReadJSON(res.Body, out)
+}
+
+var _ couchdb.Doc = &EmergencyAccess{}
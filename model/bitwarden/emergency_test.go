@@ -0,0 +1,40 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmergencyAccessToken(t *testing.T) {
+	e := &EmergencyAccess{Token: "b4f1b3c1ae8e4aa7bd2ed2c8a8b3b6a1"}
+	assert.NoError(t, e.CheckToken("b4f1b3c1ae8e4aa7bd2ed2c8a8b3b6a1"))
+	assert.Equal(t, ErrEmergencyAccessInvalidToken, e.CheckToken("wrong"))
+	assert.Equal(t, ErrEmergencyAccessInvalidToken, e.CheckToken(""))
+	e.Token = ""
+	assert.Equal(t, ErrEmergencyAccessInvalidToken, e.CheckToken(""))
+}
+
+func TestEmergencyAccessState(t *testing.T) {
+	e := &EmergencyAccess{
+		Grantor:      true,
+		Type:         EmergencyAccessView,
+		Status:       EmergencyAccessConfirmed,
+		WaitTimeDays: 3,
+	}
+	assert.True(t, e.RecoveryApprovalDate().IsZero())
+
+	initiated := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	e.Status = EmergencyAccessRecoveryInitiated
+	e.RecoveryInitiatedAt = &initiated
+	expected := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	assert.True(t, expected.Equal(e.RecoveryApprovalDate()))
+
+	other := &EmergencyAccess{Grantor: false}
+	other.SetState(e.State())
+	assert.False(t, other.Grantor)
+	assert.Equal(t, EmergencyAccessRecoveryInitiated, other.Status)
+	assert.Equal(t, 3, other.WaitTimeDays)
+	assert.Equal(t, &initiated, other.RecoveryInitiatedAt)
+}
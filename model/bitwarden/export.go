@@ -0,0 +1,215 @@
+package bitwarden
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// ExportsDirName is the path of the directory where the vault exports are
+// written.
+const ExportsDirName = "/.cozy_bitwarden/exports"
+
+// MaxVaultExports is the number of vault exports that are kept: the older
+// ones are deleted when a new export is made.
+const MaxVaultExports = 5
+
+// ExportWorker is the worker type used to export the vault.
+const ExportWorker = "bitwarden-export"
+
+// ExportMessage is the message for the job that exports the vault. The
+// EncKeyValidation is a random string encrypted by the client with the key of
+// the user, that allows to check that the right key is used for the import.
+type ExportMessage struct {
+	EncKeyValidation string `json:"enc_key_validation"`
+}
+
+// exportVault is the encrypted JSON format of the Bitwarden exports. The
+// ciphers and folders are still encrypted with the key of the user.
+// See https://bitwarden.com/help/encrypted-export/
+type exportVault struct {
+	Encrypted        bool            `json:"encrypted"`
+	EncKeyValidation string          `json:"encKeyValidation_DO_NOT_EDIT"`
+	Folders          []*exportFolder `json:"folders"`
+	Items            []*exportItem   `json:"items"`
+}
+
+type exportFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type exportItem struct {
+	ID             string      `json:"id"`
+	OrganizationID *string     `json:"organizationId"`
+	FolderID       *string     `json:"folderId"`
+	Type           CipherType  `json:"type"`
+	Reprompt       int         `json:"reprompt"`
+	Name           string      `json:"name"`
+	Notes          *string     `json:"notes"`
+	Favorite       bool        `json:"favorite"`
+	Login          *LoginData  `json:"login,omitempty"`
+	SecureNote     MapData     `json:"secureNote,omitempty"`
+	Card           MapData     `json:"card,omitempty"`
+	Identity       MapData     `json:"identity,omitempty"`
+	Fields         []Field     `json:"fields,omitempty"`
+	CollectionIDs  interface{} `json:"collectionIds"`
+}
+
+func newExportItem(c *Cipher) *exportItem {
+	item := &exportItem{
+		ID:       c.ID(),
+		Type:     c.Type,
+		Name:     c.Name,
+		Favorite: c.Favorite,
+		Login:    c.Login,
+		Fields:   c.Fields,
+	}
+	if c.FolderID != "" {
+		folderID := c.FolderID
+		item.FolderID = &folderID
+	}
+	if c.Notes != "" {
+		notes := c.Notes
+		item.Notes = &notes
+	}
+	if c.Data != nil {
+		switch c.Type {
+		case SecureNoteType:
+			item.SecureNote = *c.Data
+		case CardType:
+			item.Card = *c.Data
+		case IdentityType:
+			item.Identity = *c.Data
+		}
+	}
+	return item
+}
+
+// ExportVault writes the personal ciphers and the folders of the user, still
+// encrypted, in a new file in the exports directory. The ciphers of the
+// organizations and those in the trash are not exported.
+func ExportVault(inst *instance.Instance, encKeyValidation string) (*vfs.FileDoc, error) {
+	export := &exportVault{
+		Encrypted:        true,
+		EncKeyValidation: encKeyValidation,
+		Folders:          []*exportFolder{},
+		Items:            []*exportItem{},
+	}
+
+	var folders []*Folder
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenFolders, req, &folders); err != nil {
+		if !couchdb.IsNoDatabaseError(err) {
+			return nil, err
+		}
+	}
+	for _, f := range folders {
+		export.Folders = append(export.Folders, &exportFolder{ID: f.ID(), Name: f.Name})
+	}
+
+	var ciphers []*Cipher
+	req = &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenCiphers, req, &ciphers); err != nil {
+		if !couchdb.IsNoDatabaseError(err) {
+			return nil, err
+		}
+	}
+	for _, c := range ciphers {
+		if c.OrganizationID != "" || c.DeletedDate != nil {
+			continue
+		}
+		export.Items = append(export.Items, newExportItem(c))
+	}
+
+	content, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, ExportsDirName)
+	if err != nil {
+		return nil, err
+	}
+	name := "bitwarden_encrypted_export_" + time.Now().UTC().Format("20060102150405") + ".json"
+	doc, err := vfs.NewFileDoc(name, dir.ID(), int64(len(content)), nil,
+		"application/json", "text", time.Now(), false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.CreateFile(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = bytes.NewReader(content).WriteTo(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+
+	cleanOldExports(inst, dir)
+	return doc, nil
+}
+
+// ListExports returns the files of the vault exports, the most recent first.
+func ListExports(inst *instance.Instance) ([]*vfs.FileDoc, error) {
+	fs := inst.VFS()
+	dir, err := fs.DirByPath(ExportsDirName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return listExports(inst, dir)
+}
+
+// ExportPath returns the path of an export file.
+func ExportPath(doc *vfs.FileDoc) string {
+	return path.Join(ExportsDirName, doc.DocName)
+}
+
+func listExports(inst *instance.Instance, dir *vfs.DirDoc) ([]*vfs.FileDoc, error) {
+	var files []*vfs.FileDoc
+	iter := inst.VFS().DirIterator(dir, nil)
+	for {
+		_, file, err := iter.Next()
+		if err == vfs.ErrIteratorDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files, nil
+}
+
+func cleanOldExports(inst *instance.Instance, dir *vfs.DirDoc) {
+	files, err := listExports(inst, dir)
+	if err != nil || len(files) <= MaxVaultExports {
+		return
+	}
+	fs := inst.VFS()
+	for _, file := range files[MaxVaultExports:] {
+		if err := fs.DestroyFile(file); err != nil {
+			inst.Logger().WithField("nspace", "bitwarden").
+				Infof("Cannot delete the old export %s: %s", file.ID(), err)
+		}
+	}
+}
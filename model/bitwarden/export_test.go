@@ -0,0 +1,32 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExportItem(t *testing.T) {
+	card := MapData{"cardholderName": "2.OWV2VIqLfoWPs9DiouXGUO==|fsN6nbT+udGmOWv8K4otgw=="}
+	c := &Cipher{
+		CouchID:  "4c2869dd0e1c499fb116a824016df251",
+		Type:     CardType,
+		Name:     "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==",
+		FolderID: "8ba5e0b6e1d84a0e8ef3c8ab6b4d7e5a",
+		Data:     &card,
+	}
+	item := newExportItem(c)
+	data, err := json.Marshal(item)
+	assert.NoError(t, err)
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &result))
+	assert.Equal(t, "4c2869dd0e1c499fb116a824016df251", result["id"])
+	assert.Equal(t, "8ba5e0b6e1d84a0e8ef3c8ab6b4d7e5a", result["folderId"])
+	assert.Nil(t, result["organizationId"])
+	assert.Nil(t, result["notes"])
+	assert.Equal(t, float64(CardType), result["type"])
+	assert.Equal(t, map[string]interface{}(card), result["card"])
+	assert.NotContains(t, result, "login")
+	assert.NotContains(t, result, "identity")
+}
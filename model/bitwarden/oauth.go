@@ -16,6 +16,20 @@ import (
 // BitwardenScope is the OAuth scope, and it is hard-coded with the doctypes
 // needed by the Bitwarden apps.
 var BitwardenScope = strings.Join([]string{
+	consts.BitwardenProfiles,
+	consts.BitwardenCiphers,
+	consts.BitwardenFolders,
+	consts.BitwardenOrganizations,
+	consts.BitwardenContacts,
+	consts.BitwardenSends,
+	consts.Konnectors,
+	consts.AppsSuggestion,
+	consts.Support,
+}, " ")
+
+// previousBitwardenScope is here to help the transition of bitwarden tokens,
+// as the com.bitwarden.sends doctype has been added to the bitwarden scope.
+var previousBitwardenScope = strings.Join([]string{
 	consts.BitwardenProfiles,
 	consts.BitwardenCiphers,
 	consts.BitwardenFolders,
@@ -42,7 +56,7 @@ var oldBitwardenScope = strings.Join([]string{
 // bitwarden token.
 func IsBitwardenScope(scope string) bool {
 	switch scope {
-	case BitwardenScope, oldBitwardenScope:
+	case BitwardenScope, previousBitwardenScope, oldBitwardenScope:
 		return true
 	default:
 		return false
@@ -0,0 +1,345 @@
+package bitwarden
+
+import (
+	"errors"
+	"io"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// SendType is used to know if a send is for a text or for a file.
+type SendType int
+
+// SendTypeText and SendTypeFile are the 2 possible types of sends.
+const (
+	SendTypeText SendType = 0
+	SendTypeFile SendType = 1
+)
+
+// SendsDirName is the path of the directory where the encrypted content of the
+// file sends is stored.
+const SendsDirName = "/.cozy_bitwarden/sends"
+
+// MaxSendDeletionDelay is the maximal delay between the creation (or the last
+// update) of a send and its deletion date.
+const MaxSendDeletionDelay = 31 * 24 * time.Hour
+
+// SendDeletionWorker is the worker type used to delete the sends when their
+// deletion date is reached.
+const SendDeletionWorker = "bitwarden-sends"
+
+var (
+	// ErrSendNotFound is used when the send does not exist, or when it can no
+	// longer be accessed (disabled, expired, or max access count reached).
+	ErrSendNotFound = errors.New("Send not found")
+	// ErrSendPasswordRequired is used when a password is needed to access the
+	// send, but none was given.
+	ErrSendPasswordRequired = errors.New("Password is required")
+	// ErrSendInvalidPassword is used when the password for the send is wrong.
+	ErrSendInvalidPassword = errors.New("Invalid password")
+	// ErrSendInvalidDeletionDate is used when the deletion date is missing or
+	// too far in the future.
+	ErrSendInvalidDeletionDate = errors.New("Invalid deletion date")
+	// ErrSendInvalidExpirationDate is used when the expiration date is after
+	// the deletion date.
+	ErrSendInvalidExpirationDate = errors.New("Invalid expiration date")
+	// ErrSendInvalidType is used when the content of the send does not match
+	// its type.
+	ErrSendInvalidType = errors.New("Invalid send type")
+)
+
+// SendText is the (encrypted) text shared with a send.
+type SendText struct {
+	Text   string `json:"text,omitempty"`
+	Hidden bool   `json:"hidden"`
+}
+
+// SendFile is the file shared with a send. Its name and content are encrypted
+// on client-side.
+type SendFile struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	// FileID is the identifier of the io.cozy.files with the encrypted
+	// content. It is empty until the content has been uploaded.
+	FileID string `json:"file_id,omitempty"`
+}
+
+// Send is a text or a file shared via a link with people that may not have
+// a Bitwarden account. It is deleted automatically at its deletion date, and
+// it can no longer be accessed after its expiration date, or when the maximal
+// number of accesses has been reached.
+type Send struct {
+	CouchID        string                 `json:"_id,omitempty"`
+	CouchRev       string                 `json:"_rev,omitempty"`
+	Type           SendType               `json:"type"`
+	Name           string                 `json:"name"`
+	Notes          string                 `json:"notes,omitempty"`
+	Key            string                 `json:"key"`
+	Password       []byte                 `json:"password,omitempty"`
+	Text           *SendText              `json:"text,omitempty"`
+	File           *SendFile              `json:"file,omitempty"`
+	MaxAccessCount *int                   `json:"max_access_count,omitempty"`
+	AccessCount    int                    `json:"access_count"`
+	ExpirationDate *time.Time             `json:"expiration_date,omitempty"`
+	DeletionDate   time.Time              `json:"deletion_date"`
+	Disabled       bool                   `json:"disabled,omitempty"`
+	HideEmail      bool                   `json:"hide_email,omitempty"`
+	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the send qualified identifier
+func (s *Send) ID() string { return s.CouchID }
+
+// Rev returns the send revision
+func (s *Send) Rev() string { return s.CouchRev }
+
+// DocType returns the send document type
+func (s *Send) DocType() string { return consts.BitwardenSends }
+
+// Clone implements couchdb.Doc
+func (s *Send) Clone() couchdb.Doc {
+	cloned := *s
+	if s.Password != nil {
+		cloned.Password = make([]byte, len(s.Password))
+		copy(cloned.Password, s.Password)
+	}
+	if s.Text != nil {
+		text := *s.Text
+		cloned.Text = &text
+	}
+	if s.File != nil {
+		file := *s.File
+		cloned.File = &file
+	}
+	if s.MaxAccessCount != nil {
+		max := *s.MaxAccessCount
+		cloned.MaxAccessCount = &max
+	}
+	if s.ExpirationDate != nil {
+		date := *s.ExpirationDate
+		cloned.ExpirationDate = &date
+	}
+	if s.Metadata != nil {
+		cloned.Metadata = s.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the send qualified identifier
+func (s *Send) SetID(id string) { s.CouchID = id }
+
+// SetRev changes the send revision
+func (s *Send) SetRev(rev string) { s.CouchRev = rev }
+
+// AccessID returns the identifier used in the links for accessing the send.
+func (s *Send) AccessID() string { return s.CouchID }
+
+// FilePath returns the path of the file with the encrypted content of a file
+// send.
+func (s *Send) FilePath() string {
+	if s.File == nil {
+		return ""
+	}
+	return path.Join(SendsDirName, s.File.ID)
+}
+
+// HasPassword returns true if the send is protected by a password.
+func (s *Send) HasPassword() bool { return len(s.Password) > 0 }
+
+// SetPassword changes the password of the send. The password is already
+// derived on client-side, and it is hashed again before being stored. An empty
+// password removes the protection.
+func (s *Send) SetPassword(password string) error {
+	if password == "" {
+		s.Password = nil
+		return nil
+	}
+	hash, err := crypto.GenerateFromPassphrase([]byte(password))
+	if err != nil {
+		return err
+	}
+	s.Password = hash
+	return nil
+}
+
+// Validate checks that the send can be saved.
+func (s *Send) Validate() error {
+	switch s.Type {
+	case SendTypeText:
+		if s.Text == nil {
+			return ErrSendInvalidType
+		}
+	case SendTypeFile:
+		if s.File == nil {
+			return ErrSendInvalidType
+		}
+	default:
+		return ErrSendInvalidType
+	}
+	if s.DeletionDate.IsZero() || s.DeletionDate.After(time.Now().Add(MaxSendDeletionDelay)) {
+		return ErrSendInvalidDeletionDate
+	}
+	if s.ExpirationDate != nil && s.ExpirationDate.After(s.DeletionDate) {
+		return ErrSendInvalidExpirationDate
+	}
+	return nil
+}
+
+// CanBeAccessed returns true if the send is not disabled, not expired, and if
+// the max access count has not been reached.
+func (s *Send) CanBeAccessed() bool {
+	now := time.Now()
+	if s.Disabled || !s.DeletionDate.After(now) {
+		return false
+	}
+	if s.ExpirationDate != nil && !s.ExpirationDate.After(now) {
+		return false
+	}
+	if s.MaxAccessCount != nil && s.AccessCount >= *s.MaxAccessCount {
+		return false
+	}
+	return true
+}
+
+// CheckAccess returns an error if the send cannot be accessed with the given
+// password.
+func (s *Send) CheckAccess(password string) error {
+	if !s.CanBeAccessed() {
+		return ErrSendNotFound
+	}
+	if !s.HasPassword() {
+		return nil
+	}
+	if password == "" {
+		return ErrSendPasswordRequired
+	}
+	if _, err := crypto.CompareHashAndPassphrase(s.Password, []byte(password)); err != nil {
+		return ErrSendInvalidPassword
+	}
+	return nil
+}
+
+// GetSend returns the send with the given identifier.
+func GetSend(inst *instance.Instance, id string) (*Send, error) {
+	send := &Send{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenSends, id, send); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrSendNotFound
+		}
+		return nil, err
+	}
+	return send, nil
+}
+
+// ListSends returns all the sends of the instance.
+func ListSends(inst *instance.Instance) ([]*Send, error) {
+	var sends []*Send
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenSends, req, &sends); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return sends, nil
+}
+
+// CreateSend saves a new send, and schedules its deletion.
+func CreateSend(inst *instance.Instance, s *Send) error {
+	if err := couchdb.CreateDoc(inst, s); err != nil {
+		return err
+	}
+	return scheduleSendDeletion(inst, s)
+}
+
+// UpdateSend saves the changes of a send, and schedules its deletion (the
+// deletion date may have been changed).
+func UpdateSend(inst *instance.Instance, s *Send) error {
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	return scheduleSendDeletion(inst, s)
+}
+
+// RecordSendAccess increments the access count of the send.
+func RecordSendAccess(inst *instance.Instance, s *Send) error {
+	s.AccessCount++
+	return couchdb.UpdateDoc(inst, s)
+}
+
+// UploadSendFile writes the encrypted content of a file send in the VFS.
+func UploadSendFile(inst *instance.Instance, s *Send, content io.Reader, size int64) error {
+	if s.File == nil {
+		return ErrSendInvalidType
+	}
+	if size > MaxAttachmentSize {
+		return ErrAttachmentTooBig
+	}
+	fileID, err := writeEncryptedFile(inst, SendsDirName, s.File.ID, s.File.FileID, content, size)
+	if err != nil {
+		if err == vfs.ErrFileTooBig {
+			return ErrAttachmentTooBig
+		}
+		return err
+	}
+	s.File.FileID = fileID
+	s.File.Size = size
+	return nil
+}
+
+// DeleteSend deletes the send, and the content of its file if any.
+func DeleteSend(inst *instance.Instance, s *Send) error {
+	if s.File != nil {
+		if err := destroyEncryptedFile(inst, SendsDirName, s.File.ID, s.File.FileID); err != nil {
+			return err
+		}
+	}
+	return couchdb.DeleteDoc(inst, s)
+}
+
+// SendDeletionMessage is the message for the job that deletes a send when its
+// deletion date is reached.
+type SendDeletionMessage struct {
+	SendID string `json:"send_id"`
+}
+
+// DeleteExpiredSend deletes the send with the given identifier if its deletion
+// date has been reached. If the deletion date has been postponed, the send is
+// kept, as another job has been scheduled for it.
+func DeleteExpiredSend(inst *instance.Instance, id string) error {
+	s, err := GetSend(inst, id)
+	if err != nil {
+		if err == ErrSendNotFound {
+			return nil
+		}
+		return err
+	}
+	if s.DeletionDate.After(time.Now()) {
+		return nil
+	}
+	return DeleteSend(inst, s)
+}
+
+func scheduleSendDeletion(inst *instance.Instance, s *Send) error {
+	msg := &SendDeletionMessage{SendID: s.ID()}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@at",
+		WorkerType: SendDeletionWorker,
+		Arguments:  s.DeletionDate.Format(time.RFC3339),
+	}, msg)
+	if err != nil {
+		return err
+	}
+	return job.System().AddTrigger(t)
+}
+
+var _ couchdb.Doc = &Send{}
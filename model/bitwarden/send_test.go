@@ -0,0 +1,62 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendValidate(t *testing.T) {
+	s := &Send{
+		Type:         SendTypeText,
+		Text:         &SendText{Text: "2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM="},
+		DeletionDate: time.Now().Add(7 * 24 * time.Hour),
+	}
+	assert.NoError(t, s.Validate())
+
+	s.Type = SendTypeFile
+	assert.Equal(t, ErrSendInvalidType, s.Validate())
+	s.Type = SendTypeText
+
+	s.DeletionDate = time.Now().Add(MaxSendDeletionDelay + time.Hour)
+	assert.Equal(t, ErrSendInvalidDeletionDate, s.Validate())
+	s.DeletionDate = time.Time{}
+	assert.Equal(t, ErrSendInvalidDeletionDate, s.Validate())
+
+	s.DeletionDate = time.Now().Add(24 * time.Hour)
+	expiration := time.Now().Add(48 * time.Hour)
+	s.ExpirationDate = &expiration
+	assert.Equal(t, ErrSendInvalidExpirationDate, s.Validate())
+}
+
+func TestSendCheckAccess(t *testing.T) {
+	max := 2
+	s := &Send{
+		Type:           SendTypeText,
+		Text:           &SendText{Text: "2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM="},
+		MaxAccessCount: &max,
+		DeletionDate:   time.Now().Add(24 * time.Hour),
+	}
+	assert.NoError(t, s.CheckAccess(""))
+
+	assert.NoError(t, s.SetPassword("secret"))
+	assert.True(t, s.HasPassword())
+	assert.Equal(t, ErrSendPasswordRequired, s.CheckAccess(""))
+	assert.Equal(t, ErrSendInvalidPassword, s.CheckAccess("wrong"))
+	assert.NoError(t, s.CheckAccess("secret"))
+	assert.NoError(t, s.SetPassword(""))
+	assert.False(t, s.HasPassword())
+
+	s.AccessCount = 2
+	assert.Equal(t, ErrSendNotFound, s.CheckAccess(""))
+	s.AccessCount = 1
+
+	expired := time.Now().Add(-time.Minute)
+	s.ExpirationDate = &expired
+	assert.Equal(t, ErrSendNotFound, s.CheckAccess(""))
+	s.ExpirationDate = nil
+
+	s.Disabled = true
+	assert.Equal(t, ErrSendNotFound, s.CheckAccess(""))
+}
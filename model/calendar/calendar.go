@@ -0,0 +1,324 @@
+// Package calendar is for the events and the tasks of the calendar. They are
+// stored as io.cozy.calendar.events and io.cozy.calendar.todos documents, and
+// they can be synchronized via CalDAV.
+package calendar
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// DocTypeVersion is the version of the io.cozy.calendar.* documents created
+// by the stack.
+const DocTypeVersion = "1"
+
+// EventsCalendar and TodosCalendar are the identifiers of the two calendars:
+// one for the events and one for the tasks.
+const (
+	EventsCalendar = "events"
+	TodosCalendar  = "todos"
+)
+
+// changesBatchSize is the maximal number of changes loaded from CouchDB in a
+// single request when synchronizing a calendar.
+const changesBatchSize = 1000
+
+// Calendar is a collection of events or of tasks, as exposed via CalDAV.
+type Calendar struct {
+	ID      string
+	Name    string
+	DocType string
+	// Component is the iCalendar component used for the items of this
+	// calendar: VEVENT or VTODO.
+	Component string
+}
+
+var calendars = []*Calendar{
+	{ID: EventsCalendar, Name: "Cozy", DocType: consts.CalendarEvents, Component: "VEVENT"},
+	{ID: TodosCalendar, Name: "Cozy Tasks", DocType: consts.CalendarTodos, Component: "VTODO"},
+}
+
+// ListCalendars returns the calendars.
+func ListCalendars() []*Calendar {
+	return calendars
+}
+
+// GetCalendar returns the calendar with the given identifier.
+func GetCalendar(id string) (*Calendar, error) {
+	for _, cal := range calendars {
+		if cal.ID == id {
+			return cal, nil
+		}
+	}
+	return nil, ErrCalendarNotFound
+}
+
+// Item is an event or a task. It is a JSON document, to keep the fields added
+// by the front applications. The fields that have an equivalent in the
+// iCalendar format are extracted from the iCalendar sent by the CalDAV
+// clients, and the iCalendar is kept in the ical field for the round-trip.
+type Item struct {
+	couchdb.JSONDoc
+}
+
+// NewItem returns a new blank item for the calendar.
+func NewItem(cal *Calendar, id string) *Item {
+	item := &Item{JSONDoc: couchdb.JSONDoc{
+		Type: cal.DocType,
+		M:    make(map[string]interface{}),
+	}}
+	item.SetID(id)
+	return item
+}
+
+// ListItems returns the events or the tasks of the calendar.
+func (cal *Calendar) ListItems(db couchdb.Database) ([]*Item, error) {
+	var docs []couchdb.JSONDoc
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(db, cal.DocType, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	items := make([]*Item, 0, len(docs))
+	for _, doc := range docs {
+		doc.Type = cal.DocType
+		items = append(items, &Item{JSONDoc: doc})
+	}
+	return items, nil
+}
+
+// GetItem returns the event or task with the given identifier.
+func (cal *Calendar) GetItem(db couchdb.Database, id string) (*Item, error) {
+	item := NewItem(cal, "")
+	if err := couchdb.GetDoc(db, cal.DocType, id, &item.JSONDoc); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrItemNotFound
+		}
+		return nil, err
+	}
+	item.Type = cal.DocType
+	return item, nil
+}
+
+// SaveItem creates or updates an event or a task from an iCalendar stream.
+// For a new item, its identifier must have been set by the caller.
+func (cal *Calendar) SaveItem(db couchdb.Database, item *Item, ics []byte) error {
+	root, err := Parse(bytes.NewReader(ics))
+	if err != nil {
+		return err
+	}
+	main := mainComponent(root, cal.Component)
+	if main == nil {
+		return ErrInvalidComponent
+	}
+	uid := main.Text("UID")
+	for _, comp := range root.Components(cal.Component) {
+		if comp.Text("UID") != uid {
+			// A calendar object resource must have a single UID
+			return ErrInvalidComponent
+		}
+	}
+
+	fields := extractFields(cal, main)
+	for _, key := range managedFields(cal) {
+		if value, ok := fields[key]; ok {
+			item.M[key] = value
+		} else {
+			delete(item.M, key)
+		}
+	}
+	item.M["ical"] = string(ics)
+
+	if item.Rev() == "" {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		item.M["cozyMetadata"] = md
+		return couchdb.CreateNamedDocWithDB(db, item)
+	}
+	if meta, ok := item.Get("cozyMetadata").(map[string]interface{}); ok {
+		meta["updatedAt"] = time.Now().UTC()
+	}
+	return couchdb.UpdateDoc(db, item)
+}
+
+// DeleteItem deletes an event or a task.
+func (cal *Calendar) DeleteItem(db couchdb.Database, item *Item) error {
+	return couchdb.DeleteDoc(db, item)
+}
+
+// SyncToken returns the current sequence of the database for the calendar.
+// It changes each time an item is created, updated, or deleted.
+func (cal *Calendar) SyncToken(db couchdb.Database) (string, error) {
+	status, err := couchdb.DBStatus(db, cal.DocType)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return "0", nil
+		}
+		return "", err
+	}
+	return status.UpdateSeq, nil
+}
+
+// Changes is the list of the changes in a calendar since a sync token.
+type Changes struct {
+	// Updated is the list of the items created or updated.
+	Updated []*Item
+	// Removed is the list of the identifiers of the deleted items.
+	Removed []string
+	// SyncToken is the token to use for the next synchronization.
+	SyncToken string
+}
+
+// Changes returns the changes in the calendar since the given sync token.
+// For an empty token, all the items of the calendar are returned.
+func (cal *Calendar) Changes(db couchdb.Database, since string) (*Changes, error) {
+	changes := &Changes{}
+	if since == "" {
+		token, err := cal.SyncToken(db)
+		if err != nil {
+			return nil, err
+		}
+		items, err := cal.ListItems(db)
+		if err != nil {
+			return nil, err
+		}
+		changes.Updated = items
+		changes.SyncToken = token
+		return changes, nil
+	}
+
+	for {
+		res, err := couchdb.GetChanges(db, &couchdb.ChangesRequest{
+			DocType:     cal.DocType,
+			Since:       since,
+			IncludeDocs: true,
+			Limit:       changesBatchSize,
+		})
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				changes.SyncToken = since
+				return changes, nil
+			}
+			if couchErr, ok := couchdb.IsCouchError(err); ok && couchErr.StatusCode == http.StatusBadRequest {
+				return nil, ErrInvalidSyncToken
+			}
+			return nil, err
+		}
+		for _, change := range res.Results {
+			if strings.HasPrefix(change.DocID, "_design") {
+				continue
+			}
+			if change.Deleted || change.Doc.M == nil {
+				changes.Removed = append(changes.Removed, change.DocID)
+			} else {
+				doc := change.Doc
+				doc.Type = cal.DocType
+				changes.Updated = append(changes.Updated, &Item{JSONDoc: doc})
+			}
+		}
+		since = res.LastSeq
+		if res.Pending == 0 || len(res.Results) == 0 {
+			break
+		}
+	}
+	changes.SyncToken = since
+	return changes, nil
+}
+
+// Period is a time range where the user is busy.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// FreeBusy returns the periods where the user is busy between the two given
+// times, from the events that are not transparent nor cancelled. The
+// overlapping periods are merged.
+func FreeBusy(db couchdb.Database, from, to time.Time) ([]Period, error) {
+	cal, _ := GetCalendar(EventsCalendar)
+	items, err := cal.ListItems(db)
+	if err != nil {
+		return nil, err
+	}
+	var periods []Period
+	for _, item := range items {
+		if transparent, _ := item.Get("transparent").(bool); transparent {
+			continue
+		}
+		if status, _ := item.Get("status").(string); strings.EqualFold(status, "CANCELLED") {
+			continue
+		}
+		for _, p := range item.Periods(from, to) {
+			if p.Start.Before(from) {
+				p.Start = from
+			}
+			if p.End.After(to) {
+				p.End = to
+			}
+			if p.End.After(p.Start) {
+				periods = append(periods, p)
+			}
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].Start.Before(periods[j].Start)
+	})
+	var merged []Period
+	for _, p := range periods {
+		if n := len(merged); n > 0 && !p.Start.After(merged[n-1].End) {
+			if p.End.After(merged[n-1].End) {
+				merged[n-1].End = p.End
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged, nil
+}
+
+// FreeBusyCalendar returns a VCALENDAR with a VFREEBUSY component for the
+// given periods.
+func FreeBusyCalendar(from, to time.Time, periods []Period) *Component {
+	root := newCalendarComponent()
+	fb := NewComponent("VFREEBUSY")
+	fb.Set("DTSTAMP", time.Now().UTC().Format(icalUTCFormat), nil)
+	fb.Set("DTSTART", from.UTC().Format(icalUTCFormat), nil)
+	fb.Set("DTEND", to.UTC().Format(icalUTCFormat), nil)
+	for _, p := range periods {
+		value := p.Start.UTC().Format(icalUTCFormat) + "/" + p.End.UTC().Format(icalUTCFormat)
+		fb.Add("FREEBUSY", value, map[string][]string{"FBTYPE": {"BUSY"}})
+	}
+	root.Children = append(root.Children, fb)
+	return root
+}
+
+func newCalendarComponent() *Component {
+	root := NewComponent("VCALENDAR")
+	root.Set("VERSION", "2.0", nil)
+	root.Set("PRODID", "-//Cozy Cloud//Cozy Stack//EN", nil)
+	return root
+}
+
+// mainComponent returns the component of the given type (VEVENT or VTODO)
+// that is not an exception of a recurring item, ie without a RECURRENCE-ID.
+func mainComponent(root *Component, name string) *Component {
+	comps := root.Components(name)
+	for _, comp := range comps {
+		if comp.Get("RECURRENCE-ID") == nil {
+			return comp
+		}
+	}
+	if len(comps) > 0 {
+		return comps[0]
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const weeklyMeeting = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Paris\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19701025T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-42\r\n" +
+	"DTSTAMP:20210401T080000Z\r\n" +
+	"DTSTART;TZID=Europe/Paris:20210405T100000\r\n" +
+	"DTEND;TZID=Europe/Paris:20210405T110000\r\n" +
+	"SUMMARY:Weekly meeting\\, with the team\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=4\r\n" +
+	"EXDATE;TZID=Europe/Paris:20210412T100000\r\n" +
+	"X-CUSTOM;X-PARAM=\"a:b\":kept as is\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	root, err := Parse(strings.NewReader(weeklyMeeting))
+	assert.NoError(t, err)
+	assert.Equal(t, "VCALENDAR", root.Name)
+	assert.Len(t, root.Components("VTIMEZONE"), 1)
+	event := mainComponent(root, "VEVENT")
+	if assert.NotNil(t, event) {
+		assert.Equal(t, "Weekly meeting, with the team", event.Text("SUMMARY"))
+		assert.Equal(t, "a:b", event.Get("X-CUSTOM").Param("x-param"))
+		assert.Len(t, event.Components("VALARM"), 1)
+	}
+
+	// Round-trip
+	assert.Equal(t, weeklyMeeting, string(root.Bytes()))
+
+	_, err = Parse(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.Equal(t, ErrInvalidICalendar, err)
+	_, err = Parse(strings.NewReader("BEGIN:VCARD\r\nEND:VCARD\r\n"))
+	assert.Equal(t, ErrInvalidICalendar, err)
+}
+
+func TestParseDuration(t *testing.T) {
+	d, err := parseDuration("-PT15M")
+	assert.NoError(t, err)
+	assert.Equal(t, -15*time.Minute, d)
+	d, err = parseDuration("P1W")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+	d, err = parseDuration("P1DT2H30M")
+	assert.NoError(t, err)
+	assert.Equal(t, 26*time.Hour+30*time.Minute, d)
+	_, err = parseDuration("PT15")
+	assert.Error(t, err)
+	_, err = parseDuration("15M")
+	assert.Error(t, err)
+}
+
+func TestRecurrence(t *testing.T) {
+	start := time.Date(2021, 4, 5, 10, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r, err := ParseRecurrence("FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4")
+	assert.NoError(t, err)
+	occurrences := r.Occurrences(start, start, end, nil)
+	if assert.Len(t, occurrences, 4) {
+		assert.Equal(t, time.Wednesday, occurrences[1].Weekday())
+		assert.Equal(t, 14, occurrences[3].Day())
+	}
+
+	r, err = ParseRecurrence("FREQ=MONTHLY;UNTIL=20210901")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, end, []time.Time{start.AddDate(0, 1, 0)})
+	assert.Len(t, occurrences, 4)
+
+	r, err = ParseRecurrence("FREQ=DAILY;INTERVAL=2")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, start.AddDate(0, 0, 10), nil)
+	assert.Len(t, occurrences, 5)
+
+	_, err = ParseRecurrence("FREQ=SECONDLY")
+	assert.Error(t, err)
+	_, err = ParseRecurrence("FREQ=MONTHLY;BYDAY=MO;BYSETPOS=-1")
+	assert.Error(t, err)
+	_, err = ParseRecurrence("FREQ=WEEKLY;BYDAY=2MO")
+	assert.Error(t, err)
+	_, err = ParseRecurrence("FREQ=MONTHLY;BYDAY=XX")
+	assert.Error(t, err)
+}
+
+func TestRecurrenceSkipAhead(t *testing.T) {
+	start := time.Date(1990, 1, 1, 9, 0, 0, 0, time.UTC)
+	from := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+
+	// More than 10000 days since the start of the event
+	r, err := ParseRecurrence("FREQ=DAILY")
+	assert.NoError(t, err)
+	occurrences := r.Occurrences(start, from, to, nil)
+	if assert.Len(t, occurrences, 7) {
+		assert.Equal(t, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), occurrences[0])
+	}
+
+	r, err = ParseRecurrence("FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, from, from.AddDate(0, 0, 14), nil)
+	assert.Len(t, occurrences, 2)
+
+	// The occurrences before from are counted
+	r, err = ParseRecurrence("FREQ=DAILY;COUNT=10000")
+	assert.NoError(t, err)
+	assert.Len(t, r.Occurrences(start, from, to, nil), 0)
+	r, err = ParseRecurrence("FREQ=DAILY;COUNT=13209")
+	assert.NoError(t, err)
+	assert.Len(t, r.Occurrences(start, from, to, nil), 0)
+	r, err = ParseRecurrence("FREQ=DAILY;COUNT=13212")
+	assert.NoError(t, err)
+	assert.Len(t, r.Occurrences(start, from, to, nil), 3)
+}
+
+func TestRecurrenceByDay(t *testing.T) {
+	start := time.Date(2021, 1, 1, 18, 30, 0, 0, time.UTC)
+	end := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The second monday of each month
+	r, err := ParseRecurrence("FREQ=MONTHLY;BYDAY=2MO")
+	assert.NoError(t, err)
+	occurrences := r.Occurrences(start, start, end, nil)
+	if assert.Len(t, occurrences, 12) {
+		assert.Equal(t, time.Date(2021, 1, 11, 18, 30, 0, 0, time.UTC), occurrences[0])
+		assert.Equal(t, time.Date(2021, 11, 8, 18, 30, 0, 0, time.UTC), occurrences[10])
+	}
+
+	// The last friday of each month
+	r, err = ParseRecurrence("FREQ=MONTHLY;BYDAY=-1FR;COUNT=3")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, end, nil)
+	if assert.Len(t, occurrences, 3) {
+		assert.Equal(t, 29, occurrences[0].Day())
+		assert.Equal(t, 26, occurrences[1].Day())
+		assert.Equal(t, 26, occurrences[2].Day())
+	}
+
+	// Friday the 13th
+	r, err = ParseRecurrence("FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, end, nil)
+	if assert.Len(t, occurrences, 1) {
+		assert.Equal(t, time.August, occurrences[0].Month())
+	}
+
+	// The fourth thursday of November
+	r, err = ParseRecurrence("FREQ=YEARLY;BYMONTH=11;BYDAY=4TH")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, end.AddDate(1, 0, 0), nil)
+	if assert.Len(t, occurrences, 2) {
+		assert.Equal(t, time.Date(2021, 11, 25, 18, 30, 0, 0, time.UTC), occurrences[0])
+		assert.Equal(t, time.Date(2022, 11, 24, 18, 30, 0, 0, time.UTC), occurrences[1])
+	}
+
+	// The last day of each month
+	r, err = ParseRecurrence("FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=2")
+	assert.NoError(t, err)
+	occurrences = r.Occurrences(start, start, end, nil)
+	if assert.Len(t, occurrences, 2) {
+		assert.Equal(t, 31, occurrences[0].Day())
+		assert.Equal(t, 28, occurrences[1].Day())
+	}
+}
+
+func TestItemFields(t *testing.T) {
+	cal, _ := GetCalendar(EventsCalendar)
+	root, err := Parse(strings.NewReader(weeklyMeeting))
+	assert.NoError(t, err)
+	fields := extractFields(cal, mainComponent(root, "VEVENT"))
+	assert.Equal(t, "meeting-42", fields["uid"])
+	assert.Equal(t, "2021-04-05T10:00:00+02:00", fields["start"])
+	assert.Equal(t, "2021-04-05T11:00:00+02:00", fields["end"])
+	assert.Equal(t, "Europe/Paris", fields["timezone"])
+	assert.Equal(t, false, fields["allDay"])
+	assert.Equal(t, []interface{}{"2021-04-12T10:00:00+02:00"}, fields["exdates"])
+
+	item := NewItem(cal, "meeting-42")
+	for k, v := range fields {
+		item.M[k] = v
+	}
+	item.M["ical"] = weeklyMeeting
+
+	// 3 occurrences, as one of the 4 is excluded
+	from := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	periods := item.Periods(from, to)
+	if assert.Len(t, periods, 3) {
+		assert.Equal(t, time.Hour, periods[0].End.Sub(periods[0].Start))
+		assert.Equal(t, 19, periods[1].Start.Day())
+	}
+	assert.False(t, item.InTimeRange(to, to.AddDate(0, 1, 0)))
+
+	// The fields modified by a webapp are applied on the iCalendar
+	item.M["summary"] = "Monthly meeting"
+	item.M["start"] = "2021-04-05T09:00:00+02:00"
+	ics := string(item.ICalendar(cal).Bytes())
+	assert.Contains(t, ics, "SUMMARY:Monthly meeting\r\n")
+	assert.Contains(t, ics, "DTSTART;TZID=Europe/Paris:20210405T090000\r\n")
+	assert.Contains(t, ics, "DTEND;TZID=Europe/Paris:20210405T110000\r\n")
+	assert.Contains(t, ics, "X-CUSTOM;X-PARAM=\"a:b\":kept as is\r\n")
+	assert.Contains(t, ics, "BEGIN:VTIMEZONE\r\n")
+	assert.Contains(t, ics, "TRIGGER:-PT15M\r\n")
+
+	// An item created by a webapp, without iCalendar
+	todos, _ := GetCalendar(TodosCalendar)
+	todo := NewItem(todos, "todo-1")
+	todo.M["summary"] = "Buy some milk"
+	todo.M["due"] = "2021-04-12"
+	todo.M["allDay"] = true
+	todo.M["priority"] = 1
+	ics = string(todo.ICalendar(todos).Bytes())
+	assert.Contains(t, ics, "BEGIN:VTODO\r\n")
+	assert.Contains(t, ics, "UID:todo-1\r\n")
+	assert.Contains(t, ics, "DUE;VALUE=DATE:20210412\r\n")
+	assert.Contains(t, ics, "PRIORITY:1\r\n")
+}
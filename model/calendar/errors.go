@@ -0,0 +1,21 @@
+package calendar
+
+import "errors"
+
+var (
+	// ErrInvalidICalendar is returned when an iCalendar stream cannot be parsed
+	ErrInvalidICalendar = errors.New("Invalid iCalendar")
+	// ErrCalendarNotFound is returned when no calendar has been found for the
+	// given identifier
+	ErrCalendarNotFound = errors.New("No calendar has been found")
+	// ErrItemNotFound is returned when no event or todo has been found for
+	// the given identifier
+	ErrItemNotFound = errors.New("No event or todo has been found")
+	// ErrInvalidComponent is returned when an iCalendar stream has no
+	// component for the calendar, like a VTODO sent to the calendar of the
+	// events
+	ErrInvalidComponent = errors.New("The iCalendar has no supported component")
+	// ErrInvalidSyncToken is returned when the token for synchronizing a
+	// calendar is not valid
+	ErrInvalidSyncToken = errors.New("Invalid sync token")
+)
@@ -0,0 +1,354 @@
+package calendar
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// icalLineLength is the maximal length of a line in an iCalendar stream, in
+// octets, before it is folded (RFC 5545 section 3.1).
+const icalLineLength = 75
+
+// Property is a content line of an iCalendar component, like
+// `DTSTART;TZID=Europe/Paris:20210412T100000`.
+type Property struct {
+	Name   string
+	Params map[string][]string
+	// Value is the raw value, still escaped.
+	Value string
+}
+
+// Param returns the first value of the parameter with the given name.
+func (p *Property) Param(name string) string {
+	values := p.Params[strings.ToUpper(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Text returns the value of a text property, unescaped.
+func (p *Property) Text() string {
+	return unescapeText(p.Value)
+}
+
+// Component is an iCalendar component, like VCALENDAR, VEVENT, VTODO, VALARM
+// or VTIMEZONE. The components can be nested.
+type Component struct {
+	Name       string
+	Properties []*Property
+	Children   []*Component
+}
+
+// NewComponent returns a new component with the given name.
+func NewComponent(name string) *Component {
+	return &Component{Name: strings.ToUpper(name)}
+}
+
+// Get returns the first property with the given name, or nil.
+func (c *Component) Get(name string) *Property {
+	name = strings.ToUpper(name)
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetAll returns all the properties with the given name.
+func (c *Component) GetAll(name string) []*Property {
+	name = strings.ToUpper(name)
+	var props []*Property
+	for _, p := range c.Properties {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Text returns the unescaped value of the first property with the given name.
+func (c *Component) Text(name string) string {
+	if p := c.Get(name); p != nil {
+		return p.Text()
+	}
+	return ""
+}
+
+// Set replaces the properties with the given name by a single property. An
+// empty value removes the properties.
+func (c *Component) Set(name, value string, params map[string][]string) {
+	name = strings.ToUpper(name)
+	c.Remove(name)
+	if value == "" {
+		return
+	}
+	c.Properties = append(c.Properties, &Property{Name: name, Params: params, Value: value})
+}
+
+// SetText is like Set, but it escapes the value.
+func (c *Component) SetText(name, value string) {
+	c.Set(name, escapeText(value), nil)
+}
+
+// Add appends a property to the component.
+func (c *Component) Add(name, value string, params map[string][]string) {
+	c.Properties = append(c.Properties, &Property{
+		Name:   strings.ToUpper(name),
+		Params: params,
+		Value:  value,
+	})
+}
+
+// Remove deletes the properties with the given name.
+func (c *Component) Remove(name string) {
+	name = strings.ToUpper(name)
+	props := c.Properties[:0]
+	for _, p := range c.Properties {
+		if p.Name != name {
+			props = append(props, p)
+		}
+	}
+	c.Properties = props
+}
+
+// Components returns the children components with the given name.
+func (c *Component) Components(name string) []*Component {
+	name = strings.ToUpper(name)
+	var children []*Component
+	for _, child := range c.Children {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Encode writes the component, with its children, and with the long lines
+// folded.
+func (c *Component) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	c.encode(&buf)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Bytes returns the encoded component.
+func (c *Component) Bytes() []byte {
+	var buf bytes.Buffer
+	c.encode(&buf)
+	return buf.Bytes()
+}
+
+func (c *Component) encode(buf *bytes.Buffer) {
+	writeLine(buf, "BEGIN:"+c.Name)
+	for _, p := range c.Properties {
+		var line strings.Builder
+		line.WriteString(p.Name)
+		keys := make([]string, 0, len(p.Params))
+		for k := range p.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line.WriteByte(';')
+			line.WriteString(k)
+			line.WriteByte('=')
+			for i, value := range p.Params[k] {
+				if i > 0 {
+					line.WriteByte(',')
+				}
+				if strings.ContainsAny(value, ":;,") {
+					value = `"` + strings.Replace(value, `"`, "'", -1) + `"`
+				}
+				line.WriteString(value)
+			}
+		}
+		line.WriteByte(':')
+		line.WriteString(p.Value)
+		writeLine(buf, line.String())
+	}
+	for _, child := range c.Children {
+		child.encode(buf)
+	}
+	writeLine(buf, "END:"+c.Name)
+}
+
+func writeLine(buf *bytes.Buffer, line string) {
+	for len(line) > icalLineLength {
+		// Don't cut in the middle of a multi-bytes character
+		cut := icalLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// Parse parses an iCalendar stream, and returns its VCALENDAR component.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var root *Component
+	var stack []*Component
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch p.Name {
+		case "BEGIN":
+			comp := NewComponent(strings.TrimSpace(p.Value))
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, comp)
+			} else if root != nil {
+				return nil, ErrInvalidICalendar
+			} else {
+				root = comp
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(strings.TrimSpace(p.Value)) {
+				return nil, ErrInvalidICalendar
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, ErrInvalidICalendar
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, p)
+		}
+	}
+	if root == nil || len(stack) > 0 || root.Name != "VCALENDAR" {
+		return nil, ErrInvalidICalendar
+	}
+	return root, nil
+}
+
+// unfoldLines reads the lines, and joins the lines that have been folded (a
+// line starting with a space or a tab is the continuation of the previous
+// one).
+func unfoldLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Remove the BOM that some tools put at the start of the file
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+	return lines, nil
+}
+
+func parseLine(line string) (*Property, error) {
+	// The name and the parameters are separated from the value by the first
+	// colon that is not inside a quoted parameter value.
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return nil, ErrInvalidICalendar
+	}
+	p := &Property{
+		Params: make(map[string][]string),
+		Value:  line[colon+1:],
+	}
+	parts := splitQuoted(line[:colon], ';')
+	p.Name = strings.ToUpper(strings.TrimSpace(parts[0]))
+	if p.Name == "" {
+		return nil, ErrInvalidICalendar
+	}
+	for _, param := range parts[1:] {
+		eq := strings.Index(param, "=")
+		if eq < 0 {
+			return nil, ErrInvalidICalendar
+		}
+		key := strings.ToUpper(strings.TrimSpace(param[:eq]))
+		for _, v := range splitQuoted(param[eq+1:], ',') {
+			p.Params[key] = append(p.Params[key], strings.Trim(v, `"`))
+		}
+	}
+	return p, nil
+}
+
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == sep && !quoted {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		switch r {
+		case 'n', 'N':
+			b.WriteRune('\n')
+		default:
+			b.WriteRune(r)
+		}
+		escaped = false
+	}
+	return b.String()
+}
+
+func escapeText(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, ",", `\,`, -1)
+	return strings.Replace(s, ";", `\;`, -1)
+}
@@ -0,0 +1,418 @@
+package calendar
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// docDateFormat is the format used for the dates of the all-day items in the
+// documents.
+const docDateFormat = "2006-01-02"
+
+var eventFields = []string{
+	"uid", "summary", "description", "location", "status", "start", "end",
+	"allDay", "timezone", "transparent", "rrule", "exdates", "alarms",
+}
+
+var todoFields = []string{
+	"uid", "summary", "description", "status", "start", "due", "completed",
+	"allDay", "timezone", "priority", "percentComplete", "rrule", "exdates",
+	"alarms",
+}
+
+func managedFields(cal *Calendar) []string {
+	if cal.Component == "VTODO" {
+		return todoFields
+	}
+	return eventFields
+}
+
+// extractFields returns the fields of the document for an event or a task,
+// from its iCalendar component.
+func extractFields(cal *Calendar, comp *Component) map[string]interface{} {
+	fields := make(map[string]interface{})
+	setText := func(key, prop string) {
+		if value := comp.Text(prop); value != "" {
+			fields[key] = value
+		}
+	}
+	setText("uid", "UID")
+	setText("summary", "SUMMARY")
+	setText("description", "DESCRIPTION")
+	setText("status", "STATUS")
+	if rrule := comp.Get("RRULE"); rrule != nil {
+		fields["rrule"] = rrule.Value
+	}
+
+	start, allDay, timezone := extractDate(comp.Get("DTSTART"))
+	if start != nil {
+		fields["start"] = formatDocTime(*start, allDay)
+		fields["allDay"] = allDay
+		if timezone != "" {
+			fields["timezone"] = timezone
+		}
+	}
+
+	var exdates []interface{}
+	for _, p := range comp.GetAll("EXDATE") {
+		for _, value := range strings.Split(p.Value, ",") {
+			t, dateOnly, err := parseDateTimeValue(value, p.Param("TZID"), p.Param("VALUE") == "DATE")
+			if err == nil {
+				exdates = append(exdates, formatDocTime(t, dateOnly))
+			}
+		}
+	}
+	if len(exdates) > 0 {
+		fields["exdates"] = exdates
+	}
+
+	var alarms []interface{}
+	for _, alarm := range comp.Components("VALARM") {
+		a := map[string]interface{}{}
+		if action := alarm.Text("ACTION"); action != "" {
+			a["action"] = action
+		}
+		if trigger := alarm.Get("TRIGGER"); trigger != nil {
+			a["trigger"] = trigger.Value
+			if related := trigger.Param("RELATED"); related != "" {
+				a["related"] = related
+			}
+		}
+		if desc := alarm.Text("DESCRIPTION"); desc != "" {
+			a["description"] = desc
+		}
+		alarms = append(alarms, a)
+	}
+	if len(alarms) > 0 {
+		fields["alarms"] = alarms
+	}
+
+	if cal.Component == "VTODO" {
+		if due, dueAllDay, _ := extractDate(comp.Get("DUE")); due != nil {
+			fields["due"] = formatDocTime(*due, dueAllDay)
+			if start == nil {
+				fields["allDay"] = dueAllDay
+			}
+		}
+		if completed, _, _ := extractDate(comp.Get("COMPLETED")); completed != nil {
+			fields["completed"] = formatDocTime(*completed, false)
+		}
+		if n, err := strconv.Atoi(comp.Text("PRIORITY")); err == nil {
+			fields["priority"] = n
+		}
+		if n, err := strconv.Atoi(comp.Text("PERCENT-COMPLETE")); err == nil {
+			fields["percentComplete"] = n
+		}
+		return fields
+	}
+
+	setText("location", "LOCATION")
+	if strings.EqualFold(comp.Text("TRANSP"), "TRANSPARENT") {
+		fields["transparent"] = true
+	}
+	end, endAllDay, _ := extractDate(comp.Get("DTEND"))
+	if end == nil && start != nil {
+		if d, err := parseDuration(comp.Text("DURATION")); err == nil {
+			t := start.Add(d)
+			end, endAllDay = &t, allDay
+		} else if allDay {
+			// An all-day event without end lasts one day
+			t := start.AddDate(0, 0, 1)
+			end, endAllDay = &t, true
+		}
+	}
+	if end != nil {
+		fields["end"] = formatDocTime(*end, endAllDay)
+	}
+	return fields
+}
+
+func extractDate(p *Property) (*time.Time, bool, string) {
+	if p == nil {
+		return nil, false, ""
+	}
+	t, allDay, err := parseDateTime(p)
+	if err != nil {
+		return nil, false, ""
+	}
+	timezone := ""
+	if tzid := p.Param("TZID"); tzid != "" && !allDay && loadLocation(tzid) != time.UTC {
+		timezone = tzid
+	}
+	return &t, allDay, timezone
+}
+
+func formatDocTime(t time.Time, allDay bool) string {
+	if allDay {
+		return t.Format(docDateFormat)
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseDocTime(value interface{}, allDay bool) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(docDateFormat, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// ICalendar returns the item as an iCalendar stream. If the item was sent by
+// a CalDAV client, its iCalendar is used (with the timezones, the exceptions
+// of the recurring events, etc.), and only the fields that have been modified
+// in the document since (by a front application for example) are updated.
+func (it *Item) ICalendar(cal *Calendar) *Component {
+	if raw, ok := it.Get("ical").(string); ok && raw != "" {
+		if root, err := Parse(strings.NewReader(raw)); err == nil {
+			if main := mainComponent(root, cal.Component); main != nil {
+				old := normalizeFields(extractFields(cal, main))
+				if applyFields(cal, root, main, it.fields(cal), old) {
+					main.Set("LAST-MODIFIED", time.Now().UTC().Format(icalUTCFormat), nil)
+				}
+				return root
+			}
+		}
+	}
+
+	root := newCalendarComponent()
+	comp := NewComponent(cal.Component)
+	comp.Set("DTSTAMP", time.Now().UTC().Format(icalUTCFormat), nil)
+	root.Children = append(root.Children, comp)
+	fields := it.fields(cal)
+	if uid, _ := fields["uid"].(string); uid == "" {
+		fields["uid"] = it.ID()
+	}
+	applyFields(cal, root, comp, fields, map[string]interface{}{})
+	return root
+}
+
+// fields returns the managed fields of the document, normalized for the
+// comparison with the fields extracted from the iCalendar.
+func (it *Item) fields(cal *Calendar) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, key := range managedFields(cal) {
+		if value, ok := it.M[key]; ok && value != nil {
+			fields[key] = value
+		}
+	}
+	return normalizeFields(fields)
+}
+
+// normalizeFields makes a JSON round-trip on the fields, to have the same
+// types (float64 for the numbers, []interface{} for the arrays, etc.) as the
+// documents loaded from CouchDB.
+func normalizeFields(fields map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{})
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return fields
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&normalized); err != nil {
+		return fields
+	}
+	return normalized
+}
+
+// applyFields updates the properties of the component for the fields that are
+// different from the old values. It returns true if a field has been changed.
+func applyFields(cal *Calendar, root, comp *Component, fields, old map[string]interface{}) bool {
+	changed := func(keys ...string) bool {
+		for _, key := range keys {
+			if !reflect.DeepEqual(fields[key], old[key]) {
+				return true
+			}
+		}
+		return false
+	}
+	text := func(key, prop string) {
+		if changed(key) {
+			value, _ := fields[key].(string)
+			comp.SetText(prop, value)
+		}
+	}
+	text("uid", "UID")
+	text("summary", "SUMMARY")
+	text("description", "DESCRIPTION")
+	text("status", "STATUS")
+	if changed("rrule") {
+		rrule, _ := fields["rrule"].(string)
+		comp.Set("RRULE", rrule, nil)
+	}
+
+	allDay, _ := fields["allDay"].(bool)
+	timezone, _ := fields["timezone"].(string)
+	dates := changed("allDay", "timezone")
+	setDate := func(key, prop string) {
+		if !dates && !changed(key) {
+			return
+		}
+		t, ok := parseDocTime(fields[key], allDay)
+		if !ok {
+			comp.Remove(prop)
+			return
+		}
+		value, params := formatDateTime(t, allDay, timezone, root)
+		comp.Set(prop, value, params)
+	}
+	setDate("start", "DTSTART")
+
+	if changed("exdates") {
+		comp.Remove("EXDATE")
+		exdates, _ := fields["exdates"].([]interface{})
+		for _, exdate := range exdates {
+			if t, ok := parseDocTime(exdate, allDay); ok {
+				value, params := formatDateTime(t, allDay, timezone, root)
+				comp.Add("EXDATE", value, params)
+			}
+		}
+	}
+
+	if changed("alarms") {
+		children := comp.Children[:0]
+		for _, child := range comp.Children {
+			if child.Name != "VALARM" {
+				children = append(children, child)
+			}
+		}
+		comp.Children = children
+		alarms, _ := fields["alarms"].([]interface{})
+		for _, a := range alarms {
+			alarm, ok := a.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			valarm := NewComponent("VALARM")
+			action, _ := alarm["action"].(string)
+			if action == "" {
+				action = "DISPLAY"
+			}
+			valarm.SetText("ACTION", action)
+			trigger, _ := alarm["trigger"].(string)
+			var params map[string][]string
+			if related, _ := alarm["related"].(string); related != "" {
+				params = map[string][]string{"RELATED": {related}}
+			}
+			valarm.Set("TRIGGER", trigger, params)
+			desc, _ := alarm["description"].(string)
+			if desc == "" && action == "DISPLAY" {
+				desc, _ = fields["summary"].(string)
+			}
+			valarm.SetText("DESCRIPTION", desc)
+			comp.Children = append(comp.Children, valarm)
+		}
+	}
+
+	if cal.Component == "VTODO" {
+		setDate("due", "DUE")
+		if changed("completed") {
+			if t, ok := parseDocTime(fields["completed"], false); ok {
+				comp.Set("COMPLETED", t.UTC().Format(icalUTCFormat), nil)
+			} else {
+				comp.Remove("COMPLETED")
+			}
+		}
+		number := func(key, prop string) {
+			if changed(key) {
+				value := ""
+				if n, ok := fields[key].(float64); ok {
+					value = strconv.Itoa(int(n))
+				}
+				comp.Set(prop, value, nil)
+			}
+		}
+		number("priority", "PRIORITY")
+		number("percentComplete", "PERCENT-COMPLETE")
+	} else {
+		text("location", "LOCATION")
+		if changed("transparent") {
+			if transparent, _ := fields["transparent"].(bool); transparent {
+				comp.Set("TRANSP", "TRANSPARENT", nil)
+			} else {
+				comp.Remove("TRANSP")
+			}
+		}
+		if dates || changed("end") {
+			comp.Remove("DURATION")
+		}
+		setDate("end", "DTEND")
+	}
+
+	return changed(managedFields(cal)...)
+}
+
+// Periods returns the periods of the occurrences of the event or task that
+// overlap the given time range.
+func (it *Item) Periods(from, to time.Time) []Period {
+	allDay, _ := it.Get("allDay").(bool)
+	start, ok := parseDocTime(it.Get("start"), allDay)
+	end, hasEnd := parseDocTime(it.Get("end"), allDay)
+	if !hasEnd {
+		end, hasEnd = parseDocTime(it.Get("due"), allDay)
+	}
+	if !ok {
+		if !hasEnd {
+			return nil
+		}
+		start = end
+	}
+	if !hasEnd || end.Before(start) {
+		end = start
+	}
+	if timezone, _ := it.Get("timezone").(string); timezone != "" {
+		// The location is needed for the recurrences across DST changes
+		start = start.In(loadLocation(timezone))
+	}
+	duration := end.Sub(start)
+
+	starts := []time.Time{start}
+	if rrule, _ := it.Get("rrule").(string); rrule != "" {
+		if r, err := ParseRecurrence(rrule); err == nil {
+			var excluded []time.Time
+			exdates, _ := it.Get("exdates").([]interface{})
+			for _, exdate := range exdates {
+				if t, ok := parseDocTime(exdate, allDay); ok {
+					excluded = append(excluded, t)
+				}
+			}
+			// The occurrences that start before from can still overlap the
+			// time range
+			starts = r.Occurrences(start, from.Add(-duration), to, excluded)
+		}
+	}
+
+	var periods []Period
+	for _, s := range starts {
+		e := s.Add(duration)
+		overlaps := s.Before(to) && e.After(from)
+		if duration == 0 {
+			overlaps = !s.Before(from) && s.Before(to)
+		}
+		if overlaps {
+			periods = append(periods, Period{Start: s, End: e})
+		}
+	}
+	return periods
+}
+
+// InTimeRange returns true if the item has an occurrence in the time range,
+// or if it has no date (like a task without due date).
+func (it *Item) InTimeRange(from, to time.Time) bool {
+	_, hasStart := it.Get("start").(string)
+	_, hasEnd := it.Get("end").(string)
+	_, hasDue := it.Get("due").(string)
+	if !hasStart && !hasEnd && !hasDue {
+		return true
+	}
+	return len(it.Periods(from, to)) > 0
+}
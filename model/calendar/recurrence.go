@@ -0,0 +1,539 @@
+package calendar
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeriods is the maximal number of periods (days, weeks, months or years)
+// examined in a time range for a recurring event, to avoid looping forever on
+// the rules without an end.
+const maxPeriods = 10000
+
+const (
+	icalDateFormat     = "20060102"
+	icalDateTimeFormat = "20060102T150405"
+	icalUTCFormat      = "20060102T150405Z"
+)
+
+// parseDateTime parses the value of a DATE or DATE-TIME property, like
+// DTSTART or DUE. The second returned value is true for a date without time
+// (all-day events). The TZID parameter is used to find the location of the
+// local times, and the floating times are considered as UTC.
+func parseDateTime(p *Property) (time.Time, bool, error) {
+	return parseDateTimeValue(strings.TrimSpace(p.Value), p.Param("TZID"), p.Param("VALUE") == "DATE")
+}
+
+func parseDateTimeValue(value, tzid string, dateOnly bool) (time.Time, bool, error) {
+	if dateOnly || len(value) == len(icalDateFormat) {
+		t, err := time.Parse(icalDateFormat, value)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalUTCFormat, value)
+		return t, false, err
+	}
+	loc := loadLocation(tzid)
+	t, err := time.ParseInLocation(icalDateTimeFormat, value, loc)
+	return t, false, err
+}
+
+// loadLocation returns the location for a TZID. The TZID are usually the
+// names of the IANA database, sometimes with a prefix like
+// /mozilla.org/20050126_1/Europe/Paris. UTC is used for the unknown TZID.
+func loadLocation(tzid string) *time.Location {
+	if tzid == "" {
+		return time.UTC
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	parts := strings.Split(tzid, "/")
+	for i := 1; i < len(parts); i++ {
+		if loc, err := time.LoadLocation(strings.Join(parts[i:], "/")); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// formatDateTime returns the value and the parameters of a DATE or DATE-TIME
+// property. The local time is used with a TZID parameter if the calendar has
+// a VTIMEZONE for this location, else the time is converted to UTC.
+func formatDateTime(t time.Time, allDay bool, tzid string, cal *Component) (string, map[string][]string) {
+	if allDay {
+		return t.Format(icalDateFormat), map[string][]string{"VALUE": {"DATE"}}
+	}
+	if tzid != "" && cal != nil && hasTimezone(cal, tzid) {
+		loc := loadLocation(tzid)
+		if loc != time.UTC {
+			return t.In(loc).Format(icalDateTimeFormat), map[string][]string{"TZID": {tzid}}
+		}
+	}
+	return t.UTC().Format(icalUTCFormat), nil
+}
+
+func hasTimezone(cal *Component, tzid string) bool {
+	for _, tz := range cal.Components("VTIMEZONE") {
+		if tz.Text("TZID") == tzid {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDuration parses a duration like PT15M, -P1D or P1W.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	if !strings.HasPrefix(s, "P") {
+		return 0, ErrInvalidICalendar
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T':
+			inTime = true
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, ErrInvalidICalendar
+			}
+			num = ""
+			switch {
+			case r == 'W' && !inTime:
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case r == 'D' && !inTime:
+				d += time.Duration(n) * 24 * time.Hour
+			case r == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case r == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case r == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, ErrInvalidICalendar
+			}
+		}
+	}
+	if num != "" {
+		return 0, ErrInvalidICalendar
+	}
+	return sign * d, nil
+}
+
+// Recurrence is a parsed RRULE. Only the FREQ, INTERVAL, COUNT, UNTIL,
+// BYDAY, BYMONTHDAY and BYMONTH parts are used to compute the occurrences, and
+// the rules with other parts (like BYSETPOS or BYHOUR) are rejected.
+type Recurrence struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// WeekdayNum is a day of the BYDAY part, like MO, 2MO or -1FR. Nth is 0 when
+// there is no ordinal, ie for every Monday of the period.
+type WeekdayNum struct {
+	Weekday time.Weekday
+	Nth     int
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// ParseRecurrence parses the value of a RRULE property.
+func ParseRecurrence(rule string) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		switch key {
+		case "FREQ":
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, ErrInvalidICalendar
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, ErrInvalidICalendar
+			}
+			r.Count = n
+		case "UNTIL":
+			until, _, err := parseDateTimeValue(value, "", false)
+			if err != nil {
+				return nil, ErrInvalidICalendar
+			}
+			if len(value) == len(icalDateFormat) {
+				// The whole day is included
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			r.Until = &until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(day)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, ErrInvalidICalendar
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return nil, ErrInvalidICalendar
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+			sort.Slice(r.ByMonth, func(i, j int) bool { return r.ByMonth[i] < r.ByMonth[j] })
+		case "BYSETPOS", "BYWEEKNO", "BYYEARDAY", "BYHOUR", "BYMINUTE", "BYSECOND":
+			return nil, ErrInvalidICalendar
+		}
+	}
+	switch r.Freq {
+	case "DAILY", "WEEKLY":
+		// The ordinals are only allowed for the monthly and yearly rules
+		for _, wd := range r.ByDay {
+			if wd.Nth != 0 {
+				return nil, ErrInvalidICalendar
+			}
+		}
+		if r.Freq == "WEEKLY" && len(r.ByMonthDay) > 0 {
+			return nil, ErrInvalidICalendar
+		}
+		return r, nil
+	case "MONTHLY", "YEARLY":
+		return r, nil
+	}
+	return nil, ErrInvalidICalendar
+}
+
+// parseWeekdayNum parses a day of the BYDAY part, like MO, +2MO or -1FR.
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return WeekdayNum{}, ErrInvalidICalendar
+	}
+	wd, ok := weekdays[value[len(value)-2:]]
+	if !ok {
+		return WeekdayNum{}, ErrInvalidICalendar
+	}
+	num := WeekdayNum{Weekday: wd}
+	if ordinal := value[:len(value)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, ErrInvalidICalendar
+		}
+		num.Nth = n
+	}
+	return num, nil
+}
+
+// Occurrences returns the start times of the occurrences of a recurring
+// event that starts at the given time, in the [from, to) time range. The
+// excluded dates are skipped. The periods before from are not expanded,
+// except for counting the occurrences when the rule has a COUNT.
+func (r *Recurrence) Occurrences(start, from, to time.Time, excluded []time.Time) []time.Time {
+	var occurrences []time.Time
+	count := 0
+	first := r.periodIndex(start, from)
+	if first < 0 {
+		first = 0
+	}
+	if r.Count > 0 {
+		for i := 0; i < first; i++ {
+			for _, t := range r.period(start, i) {
+				if !t.Before(start) {
+					count++
+				}
+			}
+			if count >= r.Count {
+				return nil
+			}
+		}
+	}
+
+	last := r.periodIndex(start, to)
+	for i := first; i <= last && i < first+maxPeriods; i++ {
+		for _, t := range r.period(start, i) {
+			if t.Before(start) {
+				continue
+			}
+			if !t.Before(to) || (r.Until != nil && t.After(*r.Until)) {
+				return occurrences
+			}
+			if r.Count > 0 && count >= r.Count {
+				return occurrences
+			}
+			count++
+			if t.Before(from) || isExcluded(t, excluded) {
+				continue
+			}
+			occurrences = append(occurrences, t)
+		}
+	}
+	return occurrences
+}
+
+func isExcluded(t time.Time, excluded []time.Time) bool {
+	for _, ex := range excluded {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// periodIndex returns the index of the period of the rule that contains the
+// given time. The period 0 is the one of start.
+func (r *Recurrence) periodIndex(start, t time.Time) int {
+	t = t.In(start.Location())
+	switch r.Freq {
+	case "DAILY":
+		return floorDiv(daysBetween(start, t), r.Interval)
+	case "WEEKLY":
+		return floorDiv(floorDiv(daysBetween(startOfWeek(start), t), 7), r.Interval)
+	case "MONTHLY":
+		months := (t.Year()-start.Year())*12 + int(t.Month()) - int(start.Month())
+		return floorDiv(months, r.Interval)
+	case "YEARLY":
+		return floorDiv(t.Year()-start.Year(), r.Interval)
+	}
+	return 0
+}
+
+// period returns the occurrences of the i-th period of the rule, ie the day,
+// week, month or year that is i*interval after the one of start, in
+// chronological order. They can be before start for the first period.
+func (r *Recurrence) period(start time.Time, i int) []time.Time {
+	var days []time.Time
+	switch r.Freq {
+	case "DAILY":
+		day := r.at(start, start.Year(), start.Month(), start.Day()+i*r.Interval)
+		if r.matchesMonth(day) && r.matchesMonthDay(day) && r.matchesWeekday(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		monday := startOfWeek(start)
+		for d := 0; d < 7; d++ {
+			day := r.at(start, monday.Year(), monday.Month(), monday.Day()+7*i*r.Interval+d)
+			if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		month := time.Date(start.Year(), start.Month()+time.Month(i*r.Interval), 1, 0, 0, 0, 0, time.UTC)
+		if r.matchesMonth(month) {
+			days = r.monthDays(start, month.Year(), month.Month())
+		}
+	case "YEARLY":
+		year := start.Year() + i*r.Interval
+		if len(r.ByDay) > 0 && len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 {
+			// The ordinals are for the whole year, like 20MO
+			first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+			length := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+			for _, d := range r.expandByDay(first, length) {
+				days = append(days, r.at(start, year, time.January, d))
+			}
+			break
+		}
+		months := r.ByMonth
+		if len(months) == 0 {
+			if len(r.ByMonthDay) > 0 {
+				months = []time.Month{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			} else {
+				months = []time.Month{start.Month()}
+			}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(start, year, month)...)
+		}
+	}
+	return days
+}
+
+// monthDays returns the occurrences in the given month, for the monthly
+// rules and the yearly rules with BYMONTH or BYMONTHDAY. The months without
+// the day of start (like the 31st) are skipped when there is no BY part.
+func (r *Recurrence) monthDays(start time.Time, year int, month time.Month) []time.Time {
+	n := daysIn(year, month)
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	var days []int
+	switch {
+	case len(r.ByMonthDay) > 0:
+		var byDay map[int]bool
+		if len(r.ByDay) > 0 {
+			byDay = make(map[int]bool)
+			for _, d := range r.expandByDay(first, n) {
+				byDay[d] = true
+			}
+		}
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = n + 1 + d
+			}
+			if d >= 1 && d <= n && (byDay == nil || byDay[d]) {
+				days = append(days, d)
+			}
+		}
+	case len(r.ByDay) > 0:
+		days = r.expandByDay(first, n)
+	case start.Day() <= n:
+		days = []int{start.Day()}
+	}
+	var occurrences []time.Time
+	for _, d := range sortUnique(days) {
+		occurrences = append(occurrences, r.at(start, year, month, d))
+	}
+	return occurrences
+}
+
+// expandByDay returns the days (1 for the first one) that match the BYDAY
+// part in the period of the given length that starts at first. The ordinals
+// are counted in this period, from the end for the negative ones.
+func (r *Recurrence) expandByDay(first time.Time, length int) []int {
+	var days []int
+	for _, wd := range r.ByDay {
+		offset := (int(wd.Weekday) - int(first.Weekday()) + 7) % 7
+		switch {
+		case wd.Nth == 0:
+			for d := offset + 1; d <= length; d += 7 {
+				days = append(days, d)
+			}
+		case wd.Nth > 0:
+			if d := offset + 1 + 7*(wd.Nth-1); d <= length {
+				days = append(days, d)
+			}
+		default:
+			lastOffset := offset + 7*((length-1-offset)/7)
+			if d := lastOffset + 1 + 7*(wd.Nth+1); d >= 1 {
+				days = append(days, d)
+			}
+		}
+	}
+	return sortUnique(days)
+}
+
+func sortUnique(days []int) []int {
+	sort.Ints(days)
+	unique := days[:0]
+	for _, d := range days {
+		if len(unique) == 0 || unique[len(unique)-1] != d {
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
+
+// at returns the time of an occurrence on the given day, with the clock of
+// start. The day can overflow the month, like time.Date.
+func (r *Recurrence) at(start time.Time, year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(),
+		start.Nanosecond(), start.Location())
+}
+
+func (r *Recurrence) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == t.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recurrence) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	n := daysIn(t.Year(), t.Month())
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || n+1+d == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recurrence) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// startOfWeek returns the monday of the week of t (the weeks start on
+// monday).
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// daysBetween returns the number of days between the dates of a and b, in
+// their own location.
+func daysBetween(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int((db.Unix() - da.Unix()) / 86400)
+}
+
+// daysIn returns the number of days in the month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,231 @@
+package contact
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// DefaultAddressBook is the identifier of the address book with all the
+// contacts. The other address books are the groups of contacts, and their
+// identifiers are the identifiers of the groups.
+const DefaultAddressBook = "default"
+
+// DocTypeVersion is the version of the io.cozy.contacts documents created by
+// the stack.
+const DocTypeVersion = "2"
+
+// changesBatchSize is the maximal number of changes loaded from CouchDB in a
+// single request when synchronizing an address book.
+const changesBatchSize = 1000
+
+// AddressBook is a collection of contacts, as exposed via CardDAV. The
+// default address book has all the contacts, and there is also an address
+// book for each group of contacts.
+type AddressBook struct {
+	ID    string
+	Name  string
+	Group *Group
+}
+
+// ListAddressBooks returns the default address book, and the address books
+// for the groups if withGroups is true.
+func ListAddressBooks(db couchdb.Database, withGroups bool) ([]*AddressBook, error) {
+	books := []*AddressBook{defaultAddressBook()}
+	if !withGroups {
+		return books, nil
+	}
+	groups, err := ListGroups(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		books = append(books, &AddressBook{ID: g.ID(), Name: g.Name(), Group: g})
+	}
+	return books, nil
+}
+
+// GetAddressBook returns the address book with the given identifier.
+func GetAddressBook(db couchdb.Database, id string) (*AddressBook, error) {
+	if id == DefaultAddressBook {
+		return defaultAddressBook(), nil
+	}
+	g, err := FindGroup(db, id)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrAddressBookNotFound
+		}
+		return nil, err
+	}
+	if g.IsTrashed() {
+		return nil, ErrAddressBookNotFound
+	}
+	return &AddressBook{ID: g.ID(), Name: g.Name(), Group: g}, nil
+}
+
+func defaultAddressBook() *AddressBook {
+	return &AddressBook{ID: DefaultAddressBook, Name: "Cozy"}
+}
+
+// Contains returns true if the contact is in the address book.
+func (ab *AddressBook) Contains(c *Contact) bool {
+	if c.IsTrashed() || strings.HasPrefix(c.ID(), "_design") {
+		return false
+	}
+	return ab.Group == nil || c.InGroup(ab.Group.ID())
+}
+
+// ListContacts returns the contacts of the address book.
+func (ab *AddressBook) ListContacts(db couchdb.Database) ([]*Contact, error) {
+	docs, err := listActiveContacts(db)
+	if err != nil {
+		return nil, err
+	}
+	contacts := docs[:0]
+	for _, c := range docs {
+		if ab.Contains(c) {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts, nil
+}
+
+// GetContact returns the contact with the given identifier, if it is in the
+// address book.
+func (ab *AddressBook) GetContact(db couchdb.Database, id string) (*Contact, error) {
+	c, err := Find(db, id)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if !ab.Contains(c) {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// SaveContact creates or updates a contact from a vCard. For a new contact,
+// the given identifier is used for the document, and for the address book of
+// a group, the contact is added to this group.
+func (ab *AddressBook) SaveContact(db couchdb.Database, c *Contact, card *VCard) error {
+	c.ApplyVCard(card)
+	if ab.Group != nil {
+		c.AddToGroup(ab.Group.ID())
+	}
+	now := time.Now().UTC()
+	if c.Rev() == "" {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		c.M["cozyMetadata"] = md
+		return couchdb.CreateNamedDocWithDB(db, c)
+	}
+	if meta, ok := c.Get("cozyMetadata").(map[string]interface{}); ok {
+		meta["updatedAt"] = now
+	}
+	// A contact that was in the trash is restored when it is sent again
+	delete(c.M, "trashed")
+	return couchdb.UpdateDoc(db, c)
+}
+
+// RemoveContact removes the contact from the address book. For the default
+// address book, the contact is put in the trash, like the contacts
+// application does. For the address book of a group, the contact is only
+// removed from the group.
+func (ab *AddressBook) RemoveContact(db couchdb.Database, c *Contact) error {
+	if ab.Group != nil {
+		c.RemoveFromGroup(ab.Group.ID())
+	} else {
+		c.M["trashed"] = true
+	}
+	if meta, ok := c.Get("cozyMetadata").(map[string]interface{}); ok {
+		meta["updatedAt"] = time.Now().UTC()
+	}
+	return couchdb.UpdateDoc(db, c)
+}
+
+// SyncToken returns the current sequence of the contacts database. It changes
+// each time a contact is created, updated, or deleted.
+func SyncToken(db couchdb.Database) (string, error) {
+	status, err := couchdb.DBStatus(db, consts.Contacts)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return "0", nil
+		}
+		return "", err
+	}
+	return status.UpdateSeq, nil
+}
+
+// AddressBookChanges is the list of the changes in an address book since a
+// sync token.
+type AddressBookChanges struct {
+	// Updated is the list of the contacts created or updated in the address
+	// book.
+	Updated []*Contact
+	// Removed is the list of the identifiers of the contacts that have been
+	// removed from the address book (or that are not in this address book).
+	Removed []string
+	// SyncToken is the token to use for the next synchronization.
+	SyncToken string
+}
+
+// Changes returns the changes in the address book since the given sync token.
+// For an empty token, all the contacts of the address book are returned.
+func (ab *AddressBook) Changes(db couchdb.Database, since string) (*AddressBookChanges, error) {
+	changes := &AddressBookChanges{}
+	if since == "" {
+		token, err := SyncToken(db)
+		if err != nil {
+			return nil, err
+		}
+		contacts, err := ab.ListContacts(db)
+		if err != nil {
+			return nil, err
+		}
+		changes.Updated = contacts
+		changes.SyncToken = token
+		return changes, nil
+	}
+
+	for {
+		res, err := couchdb.GetChanges(db, &couchdb.ChangesRequest{
+			DocType:     consts.Contacts,
+			Since:       since,
+			IncludeDocs: true,
+			Limit:       changesBatchSize,
+		})
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				changes.SyncToken = since
+				return changes, nil
+			}
+			if couchErr, ok := couchdb.IsCouchError(err); ok && couchErr.StatusCode == http.StatusBadRequest {
+				return nil, ErrInvalidSyncToken
+			}
+			return nil, err
+		}
+		for _, change := range res.Results {
+			if strings.HasPrefix(change.DocID, "_design") {
+				continue
+			}
+			c := &Contact{JSONDoc: change.Doc}
+			if change.Deleted || c.M == nil || !ab.Contains(c) {
+				changes.Removed = append(changes.Removed, change.DocID)
+			} else {
+				changes.Updated = append(changes.Updated, c)
+			}
+		}
+		since = res.LastSeq
+		if res.Pending == 0 || len(res.Results) == 0 {
+			break
+		}
+	}
+	changes.SyncToken = since
+	return changes, nil
+}
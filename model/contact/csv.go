@@ -0,0 +1,342 @@
+package contact
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// googleMultiSeparator is used by Google Contacts to put several values in the
+// same cell, like two emails with the same type.
+const googleMultiSeparator = " ::: "
+
+// The columns of the CSV files exported by Outlook for the emails, phones and
+// addresses, with the type to use for them.
+var outlookEmails = []string{"e-mail address", "e-mail 2 address", "e-mail 3 address"}
+
+var outlookPhones = []struct{ column, kind string }{
+	{"mobile phone", "cell"},
+	{"home phone", "home"},
+	{"home phone 2", "home"},
+	{"business phone", "work"},
+	{"business phone 2", "work"},
+	{"other phone", "other"},
+	{"primary phone", ""},
+}
+
+var outlookAddresses = []struct{ prefix, kind string }{
+	{"home", "home"},
+	{"business", "work"},
+	{"other", "other"},
+}
+
+// csvRecord gives access to the cells of a CSV line by the name of their
+// column.
+type csvRecord struct {
+	columns map[string]int
+	values  []string
+}
+
+func (r *csvRecord) get(names ...string) string {
+	for _, name := range names {
+		if i, ok := r.columns[name]; ok && i < len(r.values) {
+			if value := strings.TrimSpace(r.values[i]); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+func (r *csvRecord) has(name string) bool {
+	_, ok := r.columns[name]
+	return ok
+}
+
+// ParseCSV parses a CSV file exported by Google Contacts or by Outlook, and
+// returns the contacts (not saved).
+func ParseCSV(r io.Reader) ([]*Contact, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	// Outlook uses a semicolon as separator for some languages
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrInvalidCSV
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+	if len(columns) < 2 {
+		return nil, ErrInvalidCSV
+	}
+
+	var contacts []*Contact
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidCSV
+		}
+		record := &csvRecord{columns: columns, values: values}
+		if c := contactFromCSV(record); c != nil {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts, nil
+}
+
+// contactFromCSV returns the contact for a CSV line. The columns of Google
+// Contacts (both the old and new formats) and of Outlook are recognized.
+func contactFromCSV(r *csvRecord) *Contact {
+	c := New()
+	name := make(map[string]interface{})
+	setStringField(name, "givenName", r.get("given name", "first name"))
+	setStringField(name, "additionalName", r.get("additional name", "middle name"))
+	setStringField(name, "familyName", r.get("family name", "last name"))
+	setStringField(name, "namePrefix", r.get("name prefix", "title"))
+	setStringField(name, "nameSuffix", r.get("name suffix", "suffix"))
+	if len(name) > 0 {
+		c.M["name"] = name
+	}
+	setStringField(c.M, "fullname", r.get("name", "display name"))
+	setStringField(c.M, "birthday", normalizeVCardDate(r.get("birthday")))
+	setStringField(c.M, "note", r.get("notes"))
+	setStringField(c.M, "company", r.get("organization 1 - name", "organization name", "company"))
+	setStringField(c.M, "jobTitle", r.get("organization 1 - title", "organization title", "job title"))
+
+	var emails, phones, addresses []interface{}
+	for i := 1; r.has("e-mail " + strconv.Itoa(i) + " - value"); i++ {
+		prefix := "e-mail " + strconv.Itoa(i) + " - "
+		kind := r.get(prefix+"type", prefix+"label")
+		for _, address := range strings.Split(r.get(prefix+"value"), googleMultiSeparator) {
+			emails = append(emails, csvTypedField("address", address, kind))
+		}
+	}
+	for _, column := range outlookEmails {
+		if address := r.get(column); address != "" {
+			emails = append(emails, csvTypedField("address", address, ""))
+		}
+	}
+
+	for i := 1; r.has("phone " + strconv.Itoa(i) + " - value"); i++ {
+		prefix := "phone " + strconv.Itoa(i) + " - "
+		kind := r.get(prefix+"type", prefix+"label")
+		for _, number := range strings.Split(r.get(prefix+"value"), googleMultiSeparator) {
+			phones = append(phones, csvTypedField("number", number, kind))
+		}
+	}
+	for _, col := range outlookPhones {
+		if number := r.get(col.column); number != "" {
+			phones = append(phones, csvTypedField("number", number, col.kind))
+		}
+	}
+
+	for i := 1; r.has("address "+strconv.Itoa(i)+" - street") || r.has("address "+strconv.Itoa(i)+" - formatted"); i++ {
+		prefix := "address " + strconv.Itoa(i) + " - "
+		addr := csvTypedField("street", r.get(prefix+"street"), r.get(prefix+"type", prefix+"label"))
+		setStringField(addr, "pobox", r.get(prefix+"po box"))
+		setStringField(addr, "city", r.get(prefix+"city"))
+		setStringField(addr, "region", r.get(prefix+"region"))
+		setStringField(addr, "postcode", r.get(prefix+"postal code"))
+		setStringField(addr, "country", r.get(prefix+"country"))
+		setStringField(addr, "formattedAddress", r.get(prefix+"formatted"))
+		if hasAddressValue(addr) {
+			addresses = append(addresses, addr)
+		}
+	}
+	for _, col := range outlookAddresses {
+		addr := csvTypedField("street", r.get(col.prefix+" street"), col.kind)
+		setStringField(addr, "pobox", r.get(col.prefix+" po box"))
+		setStringField(addr, "city", r.get(col.prefix+" city"))
+		setStringField(addr, "region", r.get(col.prefix+" state"))
+		setStringField(addr, "postcode", r.get(col.prefix+" postal code"))
+		setStringField(addr, "country", r.get(col.prefix+" country/region", col.prefix+" country"))
+		if hasAddressValue(addr) {
+			addresses = append(addresses, addr)
+		}
+	}
+
+	emails, phones = compactFields(emails, "address"), compactFields(phones, "number")
+	if len(emails) > 0 {
+		c.M["email"] = emails
+	}
+	if len(phones) > 0 {
+		c.M["phone"] = phones
+	}
+	if len(addresses) > 0 {
+		c.M["address"] = addresses
+	}
+	if c.PrimaryName() == "" && len(emails) == 0 && len(phones) == 0 {
+		return nil
+	}
+	return c
+}
+
+// csvTypedField returns the map for an email, phone or address. Google
+// Contacts uses a star before the type of the primary value, like "* Home".
+func csvTypedField(key, value, kind string) map[string]interface{} {
+	field := make(map[string]interface{})
+	setStringField(field, key, value)
+	kind = strings.TrimSpace(kind)
+	if strings.HasPrefix(kind, "*") {
+		field["primary"] = true
+		kind = strings.TrimSpace(strings.TrimPrefix(kind, "*"))
+	}
+	if kind != "" {
+		field["type"] = strings.ToLower(kind)
+	}
+	return field
+}
+
+func hasAddressValue(addr map[string]interface{}) bool {
+	for key := range addr {
+		if key != "type" && key != "primary" {
+			return true
+		}
+	}
+	return false
+}
+
+// compactFields removes the values that are empty, and the duplicates.
+func compactFields(fields []interface{}, key string) []interface{} {
+	var compacted []interface{}
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		field, _ := f.(map[string]interface{})
+		value := stringField(field, key)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		compacted = append(compacted, field)
+	}
+	return compacted
+}
+
+// WriteCSV writes the contacts in the CSV format of Google Contacts, that can
+// be imported by most of the applications.
+func WriteCSV(w io.Writer, contacts []*Contact) error {
+	nbEmails, nbPhones, nbAddresses := 1, 1, 1
+	for _, c := range contacts {
+		if n := len(objectsField(c.M, "email")); n > nbEmails {
+			nbEmails = n
+		}
+		if n := len(objectsField(c.M, "phone")); n > nbPhones {
+			nbPhones = n
+		}
+		if n := len(objectsField(c.M, "address")); n > nbAddresses {
+			nbAddresses = n
+		}
+	}
+
+	header := []string{
+		"Name", "Given Name", "Additional Name", "Family Name", "Name Prefix",
+		"Name Suffix", "Birthday", "Notes", "Organization 1 - Name",
+		"Organization 1 - Title",
+	}
+	for i := 1; i <= nbEmails; i++ {
+		prefix := "E-mail " + strconv.Itoa(i) + " - "
+		header = append(header, prefix+"Type", prefix+"Value")
+	}
+	for i := 1; i <= nbPhones; i++ {
+		prefix := "Phone " + strconv.Itoa(i) + " - "
+		header = append(header, prefix+"Type", prefix+"Value")
+	}
+	for i := 1; i <= nbAddresses; i++ {
+		prefix := "Address " + strconv.Itoa(i) + " - "
+		header = append(header, prefix+"Type", prefix+"Formatted", prefix+"Street",
+			prefix+"City", prefix+"PO Box", prefix+"Region", prefix+"Postal Code",
+			prefix+"Country")
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		name, _ := c.Get("name").(map[string]interface{})
+		company, _ := c.Get("company").(string)
+		jobTitle, _ := c.Get("jobTitle").(string)
+		birthday, _ := c.Get("birthday").(string)
+		note, _ := c.Get("note").(string)
+		record := []string{
+			c.PrimaryName(),
+			stringField(name, "givenName"),
+			stringField(name, "additionalName"),
+			stringField(name, "familyName"),
+			stringField(name, "namePrefix"),
+			stringField(name, "nameSuffix"),
+			birthday, note, company, jobTitle,
+		}
+		record = appendCSVFields(record, objectsField(c.M, "email"), nbEmails, "address")
+		record = appendCSVFields(record, objectsField(c.M, "phone"), nbPhones, "number")
+		addresses := objectsField(c.M, "address")
+		for i := 0; i < nbAddresses; i++ {
+			if i >= len(addresses) {
+				record = append(record, "", "", "", "", "", "", "", "")
+				continue
+			}
+			addr := addresses[i]
+			record = append(record, csvType(addr),
+				stringField(addr, "formattedAddress"),
+				stringField(addr, "street"),
+				stringField(addr, "city"),
+				stringField(addr, "pobox"),
+				stringField(addr, "region"),
+				stringField(addr, "postcode"),
+				stringField(addr, "country"))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func appendCSVFields(record []string, fields []map[string]interface{}, nb int, key string) []string {
+	for i := 0; i < nb; i++ {
+		if i < len(fields) {
+			record = append(record, csvType(fields[i]), stringField(fields[i], key))
+		} else {
+			record = append(record, "", "")
+		}
+	}
+	return record
+}
+
+// csvType returns the type of an email, phone or address for the CSV, with
+// the star used by Google Contacts for the primary value.
+func csvType(field map[string]interface{}) string {
+	kind := stringField(field, "type")
+	if kind != "" {
+		kind = strings.ToUpper(kind[:1]) + kind[1:]
+	}
+	if primary, ok := field["primary"].(bool); ok && primary {
+		kind = strings.TrimSpace("* " + kind)
+	}
+	return kind
+}
@@ -0,0 +1,529 @@
+package contact
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// DedupWorker is the type of the worker that detects the duplicate contacts.
+const DedupWorker = "contacts-dedup"
+
+// The states of a suggestion of duplicate contacts.
+const (
+	// DuplicatePending is for a suggestion that the user has not yet seen
+	DuplicatePending = "pending"
+	// DuplicateDismissed is for a suggestion that the user has rejected: the
+	// contacts are not the same person
+	DuplicateDismissed = "dismissed"
+	// DuplicateMerged is for a suggestion that has been accepted
+	DuplicateMerged = "merged"
+)
+
+// The reasons why two contacts are considered as duplicates.
+const (
+	ReasonEmail = "email"
+	ReasonPhone = "phone"
+	ReasonCozy  = "cozy"
+	ReasonName  = "name"
+)
+
+// reasonScores are the probabilities that two contacts are the same person
+// when they share an email address, a phone number or a cozy URL.
+var reasonScores = map[string]float64{
+	ReasonEmail: 1,
+	ReasonCozy:  1,
+	ReasonPhone: 0.8,
+}
+
+// minNameSimilarity is the minimal similarity between two names (1 is for the
+// same name) to consider that the contacts may be duplicates, and nameWeight
+// is the probability given to a name similarity of 1.
+const (
+	minNameSimilarity = 0.85
+	nameWeight        = 0.6
+)
+
+// accentsReplacer is used to compare the names without their diacritics.
+var accentsReplacer = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y",
+	"æ", "ae", "œ", "oe", "ß", "ss",
+)
+
+// Duplicate is a suggestion to merge two contacts that are probably the same
+// person. The suggestions are created by the contacts-dedup worker.
+type Duplicate struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	ContactIDs []string  `json:"contact_ids"`
+	Reasons    []string  `json:"reasons"`
+	Score      float64   `json:"score"`
+	State      string    `json:"state"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (d *Duplicate) ID() string { return d.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (d *Duplicate) Rev() string { return d.DocRev }
+
+// SetID is used to implement the couchdb.Doc interface
+func (d *Duplicate) SetID(id string) { d.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (d *Duplicate) SetRev(rev string) { d.DocRev = rev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (d *Duplicate) DocType() string { return consts.ContactsDuplicates }
+
+// Clone implements couchdb.Doc
+func (d *Duplicate) Clone() couchdb.Doc {
+	cloned := *d
+	cloned.ContactIDs = make([]string, len(d.ContactIDs))
+	copy(cloned.ContactIDs, d.ContactIDs)
+	cloned.Reasons = make([]string, len(d.Reasons))
+	copy(cloned.Reasons, d.Reasons)
+	return &cloned
+}
+
+// pairKey returns a key for the pair of contacts of this suggestion.
+func (d *Duplicate) pairKey() string {
+	return strings.Join(d.ContactIDs, "|")
+}
+
+// hasContact returns true if the given contact is one of the contacts of this
+// suggestion.
+func (d *Duplicate) hasContact(contactID string) bool {
+	for _, id := range d.ContactIDs {
+		if id == contactID {
+			return true
+		}
+	}
+	return false
+}
+
+// Dismiss marks the suggestion as rejected by the user. The suggestion will
+// not be proposed again.
+func (d *Duplicate) Dismiss(db prefixer.Prefixer) error {
+	d.State = DuplicateDismissed
+	d.UpdatedAt = time.Now().UTC()
+	return couchdb.UpdateDoc(db, d)
+}
+
+// FindDuplicate returns the suggestion of duplicates with the given
+// identifier.
+func FindDuplicate(db prefixer.Prefixer, id string) (*Duplicate, error) {
+	doc := &Duplicate{}
+	if err := couchdb.GetDoc(db, consts.ContactsDuplicates, id, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ListDuplicates returns the suggestions of duplicates, sorted by score. If
+// state is not empty, only the suggestions in this state are returned.
+func ListDuplicates(db prefixer.Prefixer, state string) ([]*Duplicate, error) {
+	var docs []*Duplicate
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(db, consts.ContactsDuplicates, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	dups := docs[:0]
+	for _, d := range docs {
+		if strings.HasPrefix(d.ID(), "_design") {
+			continue
+		}
+		if state == "" || d.State == state {
+			dups = append(dups, d)
+		}
+	}
+	sortDuplicates(dups)
+	return dups, nil
+}
+
+func sortDuplicates(dups []*Duplicate) {
+	sort.SliceStable(dups, func(i, j int) bool {
+		if dups[i].Score != dups[j].Score {
+			return dups[i].Score > dups[j].Score
+		}
+		return dups[i].pairKey() < dups[j].pairKey()
+	})
+}
+
+// normalizeName returns the name in a form that can be compared: lowercase,
+// without the diacritics and the punctuation, and with the words sorted (to
+// find "Bob Martin" and "Martin Bob").
+func normalizeName(name string) string {
+	name = accentsReplacer.Replace(strings.ToLower(name))
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// nameSimilarity returns a number between 0 and 1 for the similarity of two
+// normalized names, based on the Levenshtein distance.
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	max := len(ra)
+	if len(rb) > max {
+		max = len(rb)
+	}
+	if max == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(max)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// findDuplicates returns the pairs of contacts that are probably the same
+// person. The contacts are compared by their matching keys (email addresses,
+// phone numbers and cozy URLs) and by the similarity of their names. Only the
+// contacts with a common word in their names are compared, to avoid comparing
+// every contact with all the others.
+func findDuplicates(contacts []*Contact) []*Duplicate {
+	pairs := make(map[string]*Duplicate)
+	addReason := func(a, b *Contact, reason string, score float64) {
+		if a.ID() == b.ID() {
+			return
+		}
+		ids := []string{a.ID(), b.ID()}
+		sort.Strings(ids)
+		key := strings.Join(ids, "|")
+		dup, ok := pairs[key]
+		if !ok {
+			dup = &Duplicate{ContactIDs: ids, State: DuplicatePending}
+			pairs[key] = dup
+		}
+		for _, r := range dup.Reasons {
+			if r == reason {
+				return
+			}
+		}
+		dup.Reasons = append(dup.Reasons, reason)
+		// The scores are combined like independent probabilities
+		dup.Score = 1 - (1-dup.Score)*(1-score)
+	}
+
+	byKey := make(map[string][]*Contact)
+	byWord := make(map[string][]*Contact)
+	names := make(map[string]string, len(contacts))
+	for _, c := range contacts {
+		seen := make(map[string]bool)
+		for _, key := range c.matchingKeys() {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			reason := key[:strings.IndexByte(key, ':')]
+			for _, other := range byKey[key] {
+				addReason(other, c, reason, reasonScores[reason])
+			}
+			byKey[key] = append(byKey[key], c)
+		}
+
+		name := normalizeName(c.PrimaryName())
+		if len([]rune(name)) < 3 {
+			continue
+		}
+		names[c.ID()] = name
+		compared := make(map[string]bool)
+		for _, word := range strings.Fields(name) {
+			for _, other := range byWord[word] {
+				if compared[other.ID()] {
+					continue
+				}
+				compared[other.ID()] = true
+				if sim := nameSimilarity(name, names[other.ID()]); sim >= minNameSimilarity {
+					addReason(other, c, ReasonName, sim*nameWeight)
+				}
+			}
+			if !seen["word:"+word] {
+				seen["word:"+word] = true
+				byWord[word] = append(byWord[word], c)
+			}
+		}
+	}
+
+	dups := make([]*Duplicate, 0, len(pairs))
+	for _, dup := range pairs {
+		dup.Score = math.Round(dup.Score*100) / 100
+		sort.Strings(dup.Reasons)
+		dups = append(dups, dup)
+	}
+	sortDuplicates(dups)
+	return dups
+}
+
+// DetectDuplicates looks for the contacts that are probably the same person,
+// and creates a suggestion for each new pair of duplicates. The pairs that
+// are already known (even dismissed or merged) are not suggested again, and
+// the pending suggestions for contacts that no longer exist are removed. It
+// returns the number of new suggestions.
+func DetectDuplicates(db prefixer.Prefixer) (int, error) {
+	contacts, err := listActiveContacts(db)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := ListDuplicates(db, "")
+	if err != nil {
+		return 0, err
+	}
+
+	active := make(map[string]bool, len(contacts))
+	for _, c := range contacts {
+		active[c.ID()] = true
+	}
+	known := make(map[string]bool, len(existing))
+	for _, d := range existing {
+		known[d.pairKey()] = true
+		if d.State != DuplicatePending {
+			continue
+		}
+		for _, id := range d.ContactIDs {
+			if !active[id] {
+				if err := couchdb.DeleteDoc(db, d); err != nil {
+					return 0, err
+				}
+				break
+			}
+		}
+	}
+
+	created := 0
+	for _, dup := range findDuplicates(contacts) {
+		if known[dup.pairKey()] {
+			continue
+		}
+		dup.CreatedAt = time.Now().UTC()
+		dup.UpdatedAt = dup.CreatedAt
+		if err := couchdb.CreateDoc(db, dup); err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// UpdateSharingMembers is called when some contacts are merged, to update the
+// members of the sharings that were added from the merged contacts. It does
+// nothing here, and it will be overridden from the sharing package.
+var UpdateSharingMembers = func(inst *instance.Instance, survivor *Contact, merged []*Contact) error {
+	return nil
+}
+
+// MergeContacts merges some contacts in the survivor contact: the survivor is
+// completed with their values (see Merge), the references to the merged
+// contacts in the files and in the sharings are rewritten to the survivor,
+// and the merged contacts are deleted. The suggestions of duplicates between
+// these contacts are marked as merged.
+func MergeContacts(inst *instance.Instance, survivorID string, mergedIDs []string) (*Contact, error) {
+	if survivorID == "" || len(mergedIDs) == 0 {
+		return nil, ErrInvalidMerge
+	}
+	seen := map[string]bool{survivorID: true}
+	uniqueIDs := make([]string, 0, len(mergedIDs))
+	for _, id := range mergedIDs {
+		if id == "" || id == survivorID {
+			return nil, ErrInvalidMerge
+		}
+		if !seen[id] {
+			seen[id] = true
+			uniqueIDs = append(uniqueIDs, id)
+		}
+	}
+	mergedIDs = uniqueIDs
+
+	// All the checks are made before writing anything
+	survivor, err := Find(inst, survivorID)
+	if err != nil {
+		return nil, err
+	}
+	if survivor.IsTrashed() {
+		return nil, ErrNotFound
+	}
+	merged := make([]*Contact, 0, len(mergedIDs))
+	for _, id := range mergedIDs {
+		c, err := Find(inst, id)
+		if err != nil {
+			return nil, err
+		}
+		if c.IsTrashed() {
+			return nil, ErrNotFound
+		}
+		// The myself contact can be the survivor, but it cannot be removed
+		if me, _ := c.Get("me").(bool); me {
+			return nil, ErrInvalidMerge
+		}
+		merged = append(merged, c)
+	}
+
+	changed := false
+	for _, c := range merged {
+		changed = survivor.Merge(c) || changed
+	}
+	if changed {
+		if meta, ok := survivor.Get("cozyMetadata").(map[string]interface{}); ok {
+			meta["updatedAt"] = time.Now().UTC()
+		} else {
+			md := metadata.New()
+			md.DocTypeVersion = DocTypeVersion
+			survivor.M["cozyMetadata"] = md
+		}
+		if err := couchdb.UpdateDoc(inst, survivor); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range merged {
+		if err := rewriteFileReferences(inst, c.ID(), survivor.ID()); err != nil {
+			return nil, err
+		}
+	}
+	if err := UpdateSharingMembers(inst, survivor, merged); err != nil {
+		inst.Logger().WithField("nspace", "contacts").
+			Warnf("Cannot update the sharings for merged contacts: %s", err)
+	}
+	for _, c := range merged {
+		if err := couchdb.DeleteDoc(inst, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := updateMergedDuplicates(inst, survivorID, mergedIDs); err != nil {
+		return nil, err
+	}
+	return survivor, nil
+}
+
+// rewriteFileReferences replaces the references to a contact in the files and
+// directories by references to another contact.
+func rewriteFileReferences(inst *instance.Instance, fromID, toID string) error {
+	from := couchdb.DocReference{Type: consts.Contacts, ID: fromID}
+	to := couchdb.DocReference{Type: consts.Contacts, ID: toID}
+	req := &couchdb.ViewRequest{
+		StartKey: []string{from.Type, from.ID},
+		EndKey:   []string{from.Type, from.ID, couchdb.MaxString},
+		Reduce:   false,
+	}
+	var res couchdb.ViewResponse
+	if err := couchdb.ExecView(inst, couchdb.FilesReferencedByView, req, &res); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+
+	fs := inst.VFS()
+	for _, row := range res.Rows {
+		dir, file, err := fs.DirOrFileByID(row.ID)
+		if err != nil {
+			return err
+		}
+		if dir != nil {
+			olddoc := dir.Clone().(*vfs.DirDoc)
+			dir.RemoveReferencedBy(from)
+			dir.AddReferencedBy(to)
+			err = fs.UpdateDirDoc(olddoc, dir)
+		} else {
+			olddoc := file.Clone().(*vfs.FileDoc)
+			file.RemoveReferencedBy(from)
+			file.AddReferencedBy(to)
+			err = fs.UpdateFileDoc(olddoc, file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMergedDuplicates updates the suggestions after a merge: the
+// suggestions between the merged contacts are marked as merged, and the other
+// suggestions for the merged contacts are removed (they will be detected
+// again for the survivor if they are still relevant).
+func updateMergedDuplicates(db prefixer.Prefixer, survivorID string, mergedIDs []string) error {
+	dups, err := ListDuplicates(db, "")
+	if err != nil {
+		return err
+	}
+	group := map[string]bool{survivorID: true}
+	for _, id := range mergedIDs {
+		group[id] = true
+	}
+	for _, d := range dups {
+		inGroup, outGroup := 0, 0
+		for _, id := range d.ContactIDs {
+			if group[id] {
+				inGroup++
+			} else {
+				outGroup++
+			}
+		}
+		if inGroup == 0 || (outGroup > 0 && d.hasContact(survivorID)) {
+			continue
+		}
+		if outGroup == 0 {
+			if d.State == DuplicateMerged {
+				continue
+			}
+			d.State = DuplicateMerged
+			d.UpdatedAt = time.Now().UTC()
+			err = couchdb.UpdateDoc(db, d)
+		} else {
+			err = couchdb.DeleteDoc(db, d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package contact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	assert.Equal(t, "bob martin", normalizeName("Martin, Bob"))
+	assert.Equal(t, "francois helene", normalizeName("Hélène  François"))
+	assert.Equal(t, 1.0, nameSimilarity("bob martin", "bob martin"))
+	assert.InDelta(t, 0.9, nameSimilarity("bob martin", "bob marton"), 0.001)
+	assert.Less(t, nameSimilarity("bob martin", "alice martin"), minNameSimilarity)
+	assert.Equal(t, 0.0, nameSimilarity("", ""))
+}
+
+func TestFindDuplicates(t *testing.T) {
+	newContact := func(id, name string, fields map[string]interface{}) *Contact {
+		c := New()
+		c.SetID(id)
+		c.M["fullname"] = name
+		for k, v := range fields {
+			c.M[k] = v
+		}
+		return c
+	}
+	contacts := []*Contact{
+		newContact("a", "Bob Martin", map[string]interface{}{
+			"email": []interface{}{map[string]interface{}{"address": "bob@example.com"}},
+			"phone": []interface{}{map[string]interface{}{"number": "+33 6 12 34 56 78"}},
+		}),
+		newContact("b", "Robert", map[string]interface{}{
+			"email": []interface{}{map[string]interface{}{"address": "BOB@example.com "}},
+		}),
+		newContact("c", "Martin Bob", map[string]interface{}{
+			"phone": []interface{}{map[string]interface{}{"number": "06 12 34 56 78"}},
+			"cozy":  []interface{}{map[string]interface{}{"url": "https://bob.mycozy.cloud/"}},
+		}),
+		newContact("d", "Alice", map[string]interface{}{
+			"cozy": []interface{}{map[string]interface{}{"url": "bob.mycozy.cloud"}},
+		}),
+		newContact("e", "Alice Martin", nil),
+		newContact("f", "Bob Marten", nil),
+	}
+
+	dups := findDuplicates(contacts)
+	byPair := make(map[string]*Duplicate)
+	for _, d := range dups {
+		byPair[d.pairKey()] = d
+	}
+	assert.Len(t, byPair, 5)
+
+	if d, ok := byPair["a|b"]; assert.True(t, ok) {
+		assert.Equal(t, []string{ReasonEmail}, d.Reasons)
+		assert.Equal(t, 1.0, d.Score)
+		assert.Equal(t, DuplicatePending, d.State)
+	}
+	if d, ok := byPair["a|c"]; assert.True(t, ok) {
+		assert.Equal(t, []string{ReasonName, ReasonPhone}, d.Reasons)
+		assert.Equal(t, 0.92, d.Score)
+	}
+	if d, ok := byPair["c|d"]; assert.True(t, ok) {
+		assert.Equal(t, []string{ReasonCozy}, d.Reasons)
+	}
+	if d, ok := byPair["a|f"]; assert.True(t, ok) {
+		assert.Equal(t, []string{ReasonName}, d.Reasons)
+		assert.Equal(t, 0.54, d.Score)
+	}
+	assert.Contains(t, byPair, "c|f")
+	assert.NotContains(t, byPair, "a|e")
+	// No transitivity: b and c have nothing in common
+	assert.NotContains(t, byPair, "b|c")
+
+	// The suggestions are sorted by score
+	for i := 1; i < len(dups); i++ {
+		assert.GreaterOrEqual(t, dups[i-1].Score, dups[i].Score)
+	}
+}
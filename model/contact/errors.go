@@ -8,4 +8,20 @@ var (
 	ErrNoMailAddress = errors.New("The contact has no email address")
 	// ErrNotFound is returned when no contact has been found for a query
 	ErrNotFound = errors.New("No contact has been found")
+	// ErrInvalidVCard is returned when a vCard cannot be parsed
+	ErrInvalidVCard = errors.New("Invalid vCard")
+	// ErrInvalidCSV is returned when a CSV file of contacts cannot be parsed
+	ErrInvalidCSV = errors.New("Invalid CSV file")
+	// ErrUnknownFormat is returned when the format for importing or exporting
+	// contacts is not supported
+	ErrUnknownFormat = errors.New("Unknown format for the contacts")
+	// ErrInvalidMerge is returned when the contacts to merge are not valid,
+	// like a contact merged with itself
+	ErrInvalidMerge = errors.New("Invalid contacts to merge")
+	// ErrAddressBookNotFound is returned when no address book has been found
+	// for the given identifier
+	ErrAddressBookNotFound = errors.New("No address book has been found")
+	// ErrInvalidSyncToken is returned when the token for synchronizing an
+	// address book is not valid
+	ErrInvalidSyncToken = errors.New("Invalid sync token")
 )
@@ -0,0 +1,125 @@
+package contact
+
+import (
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// Group is a struct for a group of contacts. Like the contacts, it uses a
+// map to not lose the fields added by the front applications.
+type Group struct {
+	couchdb.JSONDoc
+}
+
+// DocType returns the group document type
+func (g *Group) DocType() string { return consts.ContactsGroups }
+
+// Name returns the name of the group
+func (g *Group) Name() string {
+	name, _ := g.Get("name").(string)
+	return name
+}
+
+// IsTrashed returns true if the group has been put in the trash.
+func (g *Group) IsTrashed() bool {
+	trashed, _ := g.Get("trashed").(bool)
+	return trashed
+}
+
+// FindGroup returns the group of contacts stored in database from a given ID
+func FindGroup(db prefixer.Prefixer, groupID string) (*Group, error) {
+	doc := &Group{}
+	err := couchdb.GetDoc(db, consts.ContactsGroups, groupID, doc)
+	return doc, err
+}
+
+// ListGroups returns the groups of contacts that are not in the trash.
+func ListGroups(db prefixer.Prefixer) ([]*Group, error) {
+	var docs []*Group
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(db, consts.ContactsGroups, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	groups := docs[:0]
+	for _, g := range docs {
+		if !g.IsTrashed() {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+// IsTrashed returns true if the contact has been put in the trash.
+func (c *Contact) IsTrashed() bool {
+	trashed, _ := c.Get("trashed").(bool)
+	return trashed
+}
+
+// GroupIDs returns the identifiers of the groups of the contact.
+func (c *Contact) GroupIDs() []string {
+	var ids []string
+	for _, ref := range c.groupReferences() {
+		if id := stringField(ref, "_id"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// InGroup returns true if the contact is a member of the given group.
+func (c *Contact) InGroup(groupID string) bool {
+	for _, id := range c.GroupIDs() {
+		if id == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// AddToGroup adds the contact to the given group. The caller is responsible
+// for saving the contact.
+func (c *Contact) AddToGroup(groupID string) {
+	if c.InGroup(groupID) {
+		return
+	}
+	var data []interface{}
+	for _, ref := range c.groupReferences() {
+		data = append(data, ref)
+	}
+	data = append(data, map[string]interface{}{
+		"_id":   groupID,
+		"_type": consts.ContactsGroups,
+	})
+	c.setGroupReferences(data)
+}
+
+// RemoveFromGroup removes the contact from the given group. The caller is
+// responsible for saving the contact.
+func (c *Contact) RemoveFromGroup(groupID string) {
+	data := []interface{}{}
+	for _, ref := range c.groupReferences() {
+		if stringField(ref, "_id") != groupID {
+			data = append(data, ref)
+		}
+	}
+	c.setGroupReferences(data)
+}
+
+func (c *Contact) groupReferences() []map[string]interface{} {
+	rels, _ := c.Get("relationships").(map[string]interface{})
+	groups, _ := rels["groups"].(map[string]interface{})
+	return objectsField(groups, "data")
+}
+
+func (c *Contact) setGroupReferences(data []interface{}) {
+	rels, ok := c.Get("relationships").(map[string]interface{})
+	if !ok {
+		rels = make(map[string]interface{})
+		c.M["relationships"] = rels
+	}
+	rels["groups"] = map[string]interface{}{"data": data}
+}
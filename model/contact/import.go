@@ -0,0 +1,309 @@
+package contact
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// The formats for importing and exporting the contacts.
+const (
+	FormatVCard = "vcard"
+	FormatCSV   = "csv"
+)
+
+// minPhoneDigits is the number of digits at the end of the phone numbers that
+// are compared to find the duplicates. It allows to find the same number with
+// and without the international prefix, like +33 6 12 34 56 78 and
+// 06 12 34 56 78.
+const minPhoneDigits = 9
+
+// ImportResult is the result of an import of contacts.
+type ImportResult struct {
+	// Created is the number of new contacts.
+	Created int `json:"created"`
+	// Merged is the number of existing contacts that have been completed with
+	// the imported values.
+	Merged int `json:"merged"`
+	// Unchanged is the number of imported contacts that were already known
+	// with the same values.
+	Unchanged int `json:"unchanged"`
+}
+
+// ReadContacts parses a vCard or CSV file, and returns the contacts (not
+// saved). The format is detected from the content.
+func ReadContacts(r io.Reader) ([]*Contact, error) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(64)
+	start = bytes.TrimLeft(bytes.TrimPrefix(start, []byte("\ufeff")), " \t\r\n")
+	if bytes.HasPrefix(bytes.ToUpper(start), []byte("BEGIN:VCARD")) {
+		cards, err := ParseVCards(br)
+		if err != nil {
+			return nil, err
+		}
+		contacts := make([]*Contact, 0, len(cards))
+		for _, card := range cards {
+			c := New()
+			c.ApplyVCard(card)
+			contacts = append(contacts, c)
+		}
+		return contacts, nil
+	}
+	return ParseCSV(br)
+}
+
+// normalizeEmail returns the email address in a form that can be compared.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizePhone returns the last digits of a phone number, or an empty
+// string if the number is too short to be compared.
+func normalizePhone(number string) string {
+	var digits []rune
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < minPhoneDigits {
+		return ""
+	}
+	return string(digits[len(digits)-minPhoneDigits:])
+}
+
+// normalizeCozyURL returns the URL of a cozy instance in a form that can be
+// compared, without the scheme and the trailing slash.
+func normalizeCozyURL(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+	u = strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+	return strings.TrimSuffix(u, "/")
+}
+
+// matchingKeys returns the keys used to find the duplicates of a contact: its
+// email addresses, phone numbers and cozy URLs.
+func (c *Contact) matchingKeys() []string {
+	var keys []string
+	for _, email := range objectsField(c.M, "email") {
+		if address := normalizeEmail(stringField(email, "address")); address != "" {
+			keys = append(keys, "email:"+address)
+		}
+	}
+	for _, phone := range objectsField(c.M, "phone") {
+		if number := normalizePhone(stringField(phone, "number")); number != "" {
+			keys = append(keys, "phone:"+number)
+		}
+	}
+	for _, cozy := range objectsField(c.M, "cozy") {
+		if u := normalizeCozyURL(stringField(cozy, "url")); u != "" {
+			keys = append(keys, "cozy:"+u)
+		}
+	}
+	return keys
+}
+
+// Merge completes the contact with the values of another contact: the emails,
+// phones, addresses and cozy URLs that are missing are added, and the empty
+// fields are filled. The values of the contact are never overwritten. It
+// returns true if the contact has been modified.
+func (c *Contact) Merge(other *Contact) bool {
+	changed := false
+	changed = mergeObjects(c, other, "email", func(email map[string]interface{}) string {
+		return normalizeEmail(stringField(email, "address"))
+	}) || changed
+	changed = mergeObjects(c, other, "phone", func(phone map[string]interface{}) string {
+		// The short numbers are compared as is
+		if number := normalizePhone(stringField(phone, "number")); number != "" {
+			return number
+		}
+		return strings.TrimSpace(stringField(phone, "number"))
+	}) || changed
+	changed = mergeObjects(c, other, "address", addressKey) || changed
+	changed = mergeObjects(c, other, "cozy", func(cozy map[string]interface{}) string {
+		return normalizeCozyURL(stringField(cozy, "url"))
+	}) || changed
+
+	for _, field := range []string{"fullname", "company", "jobTitle", "birthday", "note"} {
+		if value, _ := other.Get(field).(string); value != "" {
+			if current, _ := c.Get(field).(string); current == "" {
+				c.M[field] = value
+				changed = true
+			}
+		}
+	}
+
+	if otherName, ok := other.Get("name").(map[string]interface{}); ok {
+		name, ok := c.Get("name").(map[string]interface{})
+		if !ok {
+			name = make(map[string]interface{})
+		}
+		for key, value := range otherName {
+			if s, _ := value.(string); s != "" && stringField(name, key) == "" {
+				name[key] = s
+				changed = true
+			}
+		}
+		if len(name) > 0 {
+			c.M["name"] = name
+		}
+	}
+
+	for _, groupID := range other.GroupIDs() {
+		if !c.InGroup(groupID) {
+			c.AddToGroup(groupID)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// mergeObjects adds the values of a list field (like the emails) of the other
+// contact that are not in the contact. The values are compared with the keyOf
+// function.
+func mergeObjects(c, other *Contact, field string, keyOf func(map[string]interface{}) string) bool {
+	existing := objectsField(c.M, field)
+	known := make(map[string]bool, len(existing))
+	values := make([]interface{}, 0, len(existing))
+	for _, obj := range existing {
+		known[keyOf(obj)] = true
+		values = append(values, obj)
+	}
+	changed := false
+	for _, obj := range objectsField(other.M, field) {
+		k := keyOf(obj)
+		if k == "" || known[k] {
+			continue
+		}
+		known[k] = true
+		if len(existing) > 0 {
+			// The contact has already a primary value
+			delete(obj, "primary")
+		}
+		values = append(values, obj)
+		changed = true
+	}
+	if changed {
+		c.M[field] = values
+	}
+	return changed
+}
+
+// addressKey returns a string for comparing two postal addresses.
+func addressKey(addr map[string]interface{}) string {
+	var parts []string
+	for _, key := range []string{"pobox", "street", "city", "region", "postcode", "country"} {
+		parts = append(parts, strings.ToLower(strings.Join(strings.Fields(stringField(addr, key)), " ")))
+	}
+	key := strings.Join(parts, "|")
+	if key == "|||||" {
+		return strings.ToLower(stringField(addr, "formattedAddress"))
+	}
+	return key
+}
+
+// listActiveContacts returns all the contacts that are not in the trash.
+func listActiveContacts(db couchdb.Database) ([]*Contact, error) {
+	var docs []*Contact
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(db, consts.Contacts, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	contacts := docs[:0]
+	for _, c := range docs {
+		if !c.IsTrashed() && !strings.HasPrefix(c.ID(), "_design") {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts, nil
+}
+
+// Import saves the contacts. A contact with the same email address, phone
+// number or cozy URL as an existing contact is merged in it (see Merge)
+// instead of being created. If groupID is not empty, the imported contacts
+// are added to this group.
+func Import(db couchdb.Database, contacts []*Contact, groupID string) (*ImportResult, error) {
+	existing, err := listActiveContacts(db)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]*Contact)
+	for _, c := range existing {
+		for _, key := range c.matchingKeys() {
+			if _, ok := index[key]; !ok {
+				index[key] = c
+			}
+		}
+	}
+
+	result := &ImportResult{}
+	for _, c := range contacts {
+		if groupID != "" {
+			c.AddToGroup(groupID)
+		}
+		var duplicate *Contact
+		for _, key := range c.matchingKeys() {
+			if dup, ok := index[key]; ok {
+				duplicate = dup
+				break
+			}
+		}
+
+		if duplicate == nil {
+			md := metadata.New()
+			md.DocTypeVersion = DocTypeVersion
+			c.M["cozyMetadata"] = md
+			if err := couchdb.CreateDoc(db, c); err != nil {
+				return result, err
+			}
+			result.Created++
+			duplicate = c
+		} else if duplicate.Merge(c) {
+			if meta, ok := duplicate.Get("cozyMetadata").(map[string]interface{}); ok {
+				meta["updatedAt"] = time.Now().UTC()
+			}
+			if err := couchdb.UpdateDoc(db, duplicate); err != nil {
+				return result, err
+			}
+			result.Merged++
+		} else {
+			result.Unchanged++
+		}
+
+		for _, key := range duplicate.matchingKeys() {
+			if _, ok := index[key]; !ok {
+				index[key] = duplicate
+			}
+		}
+	}
+	return result, nil
+}
+
+// Export writes the contacts of the address book in the given format. For
+// vCard, the version can be 3.0 or 4.0.
+func (ab *AddressBook) Export(db couchdb.Database, w io.Writer, format, version string) error {
+	if format != FormatVCard && format != FormatCSV {
+		return ErrUnknownFormat
+	}
+	contacts, err := ab.ListContacts(db)
+	if err != nil {
+		return err
+	}
+	if format == FormatCSV {
+		return WriteCSV(w, contacts)
+	}
+	for _, c := range contacts {
+		if err := c.ToVCard(version).Encode(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
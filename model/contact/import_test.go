@@ -0,0 +1,126 @@
+package contact
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGoogleCSV(t *testing.T) {
+	raw := "Name,Given Name,Family Name,Birthday,Notes,E-mail 1 - Type,E-mail 1 - Value,E-mail 2 - Type,E-mail 2 - Value,Phone 1 - Type,Phone 1 - Value,Address 1 - Type,Address 1 - Formatted,Address 1 - Street,Address 1 - City,Address 1 - Postal Code,Address 1 - Country,Organization 1 - Name,Organization 1 - Title\n" +
+		"Bob Martin,Bob,Martin,1985-04-12,\"A note, with a comma\",* Work,bob@example.com ::: bob@work.example.com,Home,bob@home.example.com,Mobile,+33 6 12 34 56 78,Home,,12 rue des Lilas,Paris,75001,France,Cozy Cloud,Engineer\n" +
+		",,,,,,,,,,,,,,,,,,\n"
+	contacts, err := ParseCSV(strings.NewReader(raw))
+	assert.NoError(t, err)
+	if !assert.Len(t, contacts, 1) {
+		return
+	}
+	c := contacts[0]
+	assert.Equal(t, "Bob Martin", c.M["fullname"])
+	assert.Equal(t, "Martin", c.M["name"].(map[string]interface{})["familyName"])
+	assert.Equal(t, "A note, with a comma", c.M["note"])
+	assert.Equal(t, "Cozy Cloud", c.M["company"])
+	assert.Equal(t, "Engineer", c.M["jobTitle"])
+	emails := c.M["email"].([]interface{})
+	if assert.Len(t, emails, 3) {
+		first := emails[0].(map[string]interface{})
+		assert.Equal(t, "bob@example.com", first["address"])
+		assert.Equal(t, "work", first["type"])
+		assert.Equal(t, true, first["primary"])
+		assert.Equal(t, "bob@home.example.com", emails[2].(map[string]interface{})["address"])
+	}
+	addresses := c.M["address"].([]interface{})
+	if assert.Len(t, addresses, 1) {
+		assert.Equal(t, "Paris", addresses[0].(map[string]interface{})["city"])
+	}
+}
+
+func TestParseOutlookCSV(t *testing.T) {
+	raw := "\ufeffFirst Name;Middle Name;Last Name;Company;Job Title;Home Street;Home City;Home Postal Code;Mobile Phone;Business Phone;E-mail Address\r\n" +
+		"Ada;;Lovelace;;;1 Main Street;London;W1;07700 900123;;ada@example.com\r\n"
+	contacts, err := ParseCSV(strings.NewReader(raw))
+	assert.NoError(t, err)
+	if !assert.Len(t, contacts, 1) {
+		return
+	}
+	c := contacts[0]
+	assert.Equal(t, "Ada Lovelace", c.PrimaryName())
+	assert.Equal(t, "07700 900123", c.PrimaryPhoneNumber())
+	phones := c.M["phone"].([]interface{})
+	assert.Equal(t, "cell", phones[0].(map[string]interface{})["type"])
+	addresses := c.M["address"].([]interface{})
+	if assert.Len(t, addresses, 1) {
+		addr := addresses[0].(map[string]interface{})
+		assert.Equal(t, "1 Main Street", addr["street"])
+		assert.Equal(t, "home", addr["type"])
+	}
+
+	_, err = ParseCSV(strings.NewReader("just one column\n"))
+	assert.Equal(t, ErrInvalidCSV, err)
+}
+
+func TestReadContactsAndWriteCSV(t *testing.T) {
+	vcards := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Bob\r\nEMAIL;TYPE=home,pref:bob@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Alice\r\nTEL:+33 1 23 45 67 89\r\nEND:VCARD\r\n"
+	contacts, err := ReadContacts(strings.NewReader(vcards))
+	assert.NoError(t, err)
+	assert.Len(t, contacts, 2)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, contacts))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.True(t, strings.HasPrefix(lines[0], "Name,Given Name,"))
+		assert.Contains(t, lines[1], "* Home,bob@example.com")
+	}
+
+	// Round-trip
+	parsed, err := ReadContacts(&buf)
+	assert.NoError(t, err)
+	if assert.Len(t, parsed, 2) {
+		assert.Equal(t, "Bob", parsed[0].PrimaryName())
+		assert.Equal(t, contacts[0].M["email"], parsed[0].M["email"])
+		assert.Equal(t, "+33 1 23 45 67 89", parsed[1].PrimaryPhoneNumber())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	c := New()
+	c.M["fullname"] = "Bob"
+	c.M["email"] = []interface{}{
+		map[string]interface{}{"address": "bob@example.com", "primary": true},
+	}
+	c.M["phone"] = []interface{}{
+		map[string]interface{}{"number": "+33 6 12 34 56 78"},
+	}
+
+	other := New()
+	other.M["fullname"] = "Robert"
+	other.M["company"] = "Cozy Cloud"
+	other.M["email"] = []interface{}{
+		map[string]interface{}{"address": "BOB@example.com"},
+		map[string]interface{}{"address": "bob@work.example.com", "primary": true},
+	}
+	other.M["phone"] = []interface{}{
+		map[string]interface{}{"number": "06 12 34 56 78"},
+	}
+	other.AddToGroup("friends")
+
+	assert.ElementsMatch(t, []string{"email:bob@example.com", "phone:612345678"}, c.matchingKeys())
+	assert.True(t, c.Merge(other))
+	assert.Equal(t, "Bob", c.M["fullname"])
+	assert.Equal(t, "Cozy Cloud", c.M["company"])
+	emails := c.M["email"].([]interface{})
+	if assert.Len(t, emails, 2) {
+		second := emails[1].(map[string]interface{})
+		assert.Equal(t, "bob@work.example.com", second["address"])
+		assert.NotContains(t, second, "primary")
+	}
+	assert.Len(t, c.M["phone"], 1)
+	assert.True(t, c.InGroup("friends"))
+
+	// Merging again doesn't change anything
+	assert.False(t, c.Merge(other))
+}
@@ -0,0 +1,663 @@
+package contact
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// VCardVersion3 and VCardVersion4 are the versions of the vCard format that
+// can be generated.
+const (
+	VCardVersion3 = "3.0"
+	VCardVersion4 = "4.0"
+)
+
+// vcardLineLength is the maximal length of a line in a vCard, in octets, before
+// it is folded (RFC 6350 section 3.2).
+const vcardLineLength = 75
+
+// VCardProperty is a content line of a vCard, like
+// `item1.TEL;TYPE=work,voice:+33 1 23 45 67 89`.
+type VCardProperty struct {
+	Group  string
+	Name   string
+	Params map[string][]string
+	// Value is the raw value, still escaped.
+	Value string
+}
+
+// Param returns the first value of the parameter with the given name.
+func (p *VCardProperty) Param(name string) string {
+	values := p.Params[strings.ToUpper(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Types returns the values of the TYPE parameter, in lower case.
+func (p *VCardProperty) Types() []string {
+	var types []string
+	for _, t := range p.Params["TYPE"] {
+		for _, v := range strings.Split(t, ",") {
+			if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+				types = append(types, v)
+			}
+		}
+	}
+	return types
+}
+
+// Text returns the value of a text property, unescaped.
+func (p *VCardProperty) Text() string {
+	return unescapeVCardText(p.Value)
+}
+
+// Components returns the unescaped components of a structured property, like
+// N or ADR, that are separated by semicolons.
+func (p *VCardProperty) Components() []string {
+	var parts []string
+	var current strings.Builder
+	escaped := false
+	for _, r := range p.Value {
+		switch {
+		case escaped:
+			current.WriteRune('\\')
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			parts = append(parts, unescapeVCardText(current.String()))
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, unescapeVCardText(current.String()))
+}
+
+// VCard is a parsed vCard, kept as a list of properties. The BEGIN and END
+// lines are not included in the properties.
+type VCard struct {
+	Properties []*VCardProperty
+}
+
+// Get returns the first property with the given name, or nil.
+func (v *VCard) Get(name string) *VCardProperty {
+	name = strings.ToUpper(name)
+	for _, p := range v.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetAll returns all the properties with the given name.
+func (v *VCard) GetAll(name string) []*VCardProperty {
+	name = strings.ToUpper(name)
+	var props []*VCardProperty
+	for _, p := range v.Properties {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Add appends a property to the vCard.
+func (v *VCard) Add(name, value string, params map[string][]string) {
+	v.Properties = append(v.Properties, &VCardProperty{
+		Name:   strings.ToUpper(name),
+		Params: params,
+		Value:  value,
+	})
+}
+
+// Version returns the version of the vCard.
+func (v *VCard) Version() string {
+	if p := v.Get("VERSION"); p != nil {
+		return strings.TrimSpace(p.Value)
+	}
+	return ""
+}
+
+// Encode writes the vCard with the BEGIN and END lines, and with the long
+// lines folded.
+func (v *VCard) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	writeVCardLine(&buf, "BEGIN:VCARD")
+	for _, p := range v.Properties {
+		var line strings.Builder
+		if p.Group != "" {
+			line.WriteString(p.Group)
+			line.WriteByte('.')
+		}
+		line.WriteString(p.Name)
+		keys := make([]string, 0, len(p.Params))
+		for k := range p.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line.WriteByte(';')
+			line.WriteString(k)
+			line.WriteByte('=')
+			for i, value := range p.Params[k] {
+				if i > 0 {
+					line.WriteByte(',')
+				}
+				if strings.ContainsAny(value, ":;,") {
+					value = `"` + strings.Replace(value, `"`, "'", -1) + `"`
+				}
+				line.WriteString(value)
+			}
+		}
+		line.WriteByte(':')
+		line.WriteString(p.Value)
+		writeVCardLine(&buf, line.String())
+	}
+	writeVCardLine(&buf, "END:VCARD")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Bytes returns the encoded vCard.
+func (v *VCard) Bytes() []byte {
+	var buf bytes.Buffer
+	_ = v.Encode(&buf)
+	return buf.Bytes()
+}
+
+func writeVCardLine(buf *bytes.Buffer, line string) {
+	for len(line) > vcardLineLength {
+		// Don't cut in the middle of a multi-bytes character
+		cut := vcardLineLength
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// ParseVCards parses a stream with one or several vCards.
+func ParseVCards(r io.Reader) ([]*VCard, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var cards []*VCard
+	var current *VCard
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseVCardLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VCARD"):
+			if current != nil {
+				return nil, ErrInvalidVCard
+			}
+			current = &VCard{}
+		case p.Name == "END" && strings.EqualFold(p.Value, "VCARD"):
+			if current == nil {
+				return nil, ErrInvalidVCard
+			}
+			cards = append(cards, current)
+			current = nil
+		case current == nil:
+			return nil, ErrInvalidVCard
+		default:
+			current.Properties = append(current.Properties, p)
+		}
+	}
+	if current != nil {
+		return nil, ErrInvalidVCard
+	}
+	return cards, nil
+}
+
+// ParseVCard parses a single vCard.
+func ParseVCard(data []byte) (*VCard, error) {
+	cards, err := ParseVCards(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(cards) != 1 {
+		return nil, ErrInvalidVCard
+	}
+	return cards[0], nil
+}
+
+// unfoldVCardLines reads the lines, and joins the lines that have been folded
+// (a line starting with a space or a tab is the continuation of the previous
+// one).
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Remove the BOM that some tools put at the start of the file
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+	return lines, nil
+}
+
+func parseVCardLine(line string) (*VCardProperty, error) {
+	// The name and the parameters are separated from the value by the first
+	// colon that is not inside a quoted parameter value.
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return nil, ErrInvalidVCard
+	}
+	p := &VCardProperty{
+		Params: make(map[string][]string),
+		Value:  line[colon+1:],
+	}
+
+	parts := splitVCardParams(line[:colon])
+	name := parts[0]
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		p.Group = name[:dot]
+		name = name[dot+1:]
+	}
+	p.Name = strings.ToUpper(strings.TrimSpace(name))
+	if p.Name == "" {
+		return nil, ErrInvalidVCard
+	}
+	for _, param := range parts[1:] {
+		key, value := param, ""
+		if eq := strings.Index(param, "="); eq >= 0 {
+			key, value = param[:eq], param[eq+1:]
+		} else {
+			// vCard 2.1 allows the parameters without a name for the types,
+			// like TEL;WORK;VOICE:...
+			key, value = "TYPE", param
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		for _, v := range splitVCardParamValues(value) {
+			p.Params[key] = append(p.Params[key], v)
+		}
+	}
+	return p, nil
+}
+
+func splitVCardParams(s string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ';' && !quoted {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func splitVCardParamValues(s string) []string {
+	var values []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ',' && !quoted {
+			values = append(values, strings.Trim(s[start:i], `"`))
+			start = i + 1
+		}
+	}
+	return append(values, strings.Trim(s[start:], `"`))
+}
+
+func unescapeVCardText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		switch r {
+		case 'n', 'N':
+			b.WriteRune('\n')
+		default:
+			b.WriteRune(r)
+		}
+		escaped = false
+	}
+	return b.String()
+}
+
+func escapeVCardText(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	s = strings.Replace(s, ",", `\,`, -1)
+	return strings.Replace(s, ";", `\;`, -1)
+}
+
+func escapeVCardComponents(parts ...string) string {
+	for i, part := range parts {
+		parts[i] = escapeVCardText(part)
+	}
+	return strings.Join(parts, ";")
+}
+
+// ToVCard returns the contact as a vCard in the given version (3.0 or 4.0).
+// Only the fields that have an equivalent in the vCard format are exported.
+func (c *Contact) ToVCard(version string) *VCard {
+	if version != VCardVersion4 {
+		version = VCardVersion3
+	}
+	card := &VCard{}
+	card.Add("VERSION", version, nil)
+	card.Add("PRODID", "-//Cozy Cloud//Cozy Stack//EN", nil)
+	card.Add("UID", escapeVCardText(c.ID()), nil)
+
+	name, _ := c.Get("name").(map[string]interface{})
+	card.Add("FN", escapeVCardText(c.PrimaryName()), nil)
+	card.Add("N", escapeVCardComponents(
+		stringField(name, "familyName"),
+		stringField(name, "givenName"),
+		stringField(name, "additionalName"),
+		stringField(name, "namePrefix"),
+		stringField(name, "nameSuffix"),
+	), nil)
+
+	for _, email := range objectsField(c.M, "email") {
+		if address := stringField(email, "address"); address != "" {
+			card.Add("EMAIL", escapeVCardText(address), vcardTypeParams(version, email))
+		}
+	}
+	for _, phone := range objectsField(c.M, "phone") {
+		if number := stringField(phone, "number"); number != "" {
+			card.Add("TEL", escapeVCardText(number), vcardTypeParams(version, phone))
+		}
+	}
+	for _, addr := range objectsField(c.M, "address") {
+		street := stringField(addr, "street")
+		if number := stringField(addr, "number"); number != "" {
+			street = number + " " + street
+		}
+		value := escapeVCardComponents(
+			stringField(addr, "pobox"),
+			"",
+			street,
+			stringField(addr, "city"),
+			stringField(addr, "region"),
+			stringField(addr, "postcode"),
+			stringField(addr, "country"),
+		)
+		if value != ";;;;;;" {
+			card.Add("ADR", value, vcardTypeParams(version, addr))
+		}
+	}
+
+	if company, ok := c.Get("company").(string); ok && company != "" {
+		card.Add("ORG", escapeVCardText(company), nil)
+	}
+	if title, ok := c.Get("jobTitle").(string); ok && title != "" {
+		card.Add("TITLE", escapeVCardText(title), nil)
+	}
+	if birthday, ok := c.Get("birthday").(string); ok && birthday != "" {
+		card.Add("BDAY", escapeVCardText(birthday), nil)
+	}
+	if note, ok := c.Get("note").(string); ok && note != "" {
+		card.Add("NOTE", escapeVCardText(note), nil)
+	}
+	for _, cozy := range objectsField(c.M, "cozy") {
+		if u := stringField(cozy, "url"); u != "" {
+			card.Add("URL", u, map[string][]string{"TYPE": {"cozy"}})
+		}
+	}
+	if meta, ok := c.Get("cozyMetadata").(map[string]interface{}); ok {
+		if updatedAt := stringField(meta, "updatedAt"); updatedAt != "" {
+			card.Add("REV", updatedAt, nil)
+		}
+	}
+	return card
+}
+
+// ApplyVCard updates the fields of the contact with the values from the
+// vCard. The fields that have no equivalent in the vCard format (like the
+// relationships) are kept.
+func (c *Contact) ApplyVCard(card *VCard) {
+	if p := card.Get("N"); p != nil {
+		parts := append(p.Components(), "", "", "", "", "")
+		name := make(map[string]interface{})
+		setStringField(name, "familyName", parts[0])
+		setStringField(name, "givenName", parts[1])
+		setStringField(name, "additionalName", parts[2])
+		setStringField(name, "namePrefix", parts[3])
+		setStringField(name, "nameSuffix", parts[4])
+		c.M["name"] = name
+	} else {
+		delete(c.M, "name")
+	}
+	if p := card.Get("FN"); p != nil && p.Text() != "" {
+		c.M["fullname"] = p.Text()
+	} else {
+		delete(c.M, "fullname")
+	}
+
+	var emails []interface{}
+	for _, p := range card.GetAll("EMAIL") {
+		if address := strings.TrimSpace(p.Text()); address != "" {
+			emails = append(emails, vcardTypedField(p, "address", address))
+		}
+	}
+	c.M["email"] = emptyIfNil(emails)
+
+	var phones []interface{}
+	for _, p := range card.GetAll("TEL") {
+		number := strings.TrimPrefix(strings.TrimSpace(p.Text()), "tel:")
+		if number != "" {
+			phones = append(phones, vcardTypedField(p, "number", number))
+		}
+	}
+	c.M["phone"] = emptyIfNil(phones)
+
+	var addresses []interface{}
+	for _, p := range card.GetAll("ADR") {
+		parts := append(p.Components(), "", "", "", "", "", "", "")
+		addr := vcardTypedField(p, "pobox", parts[0])
+		street := parts[2]
+		if parts[1] != "" {
+			street = strings.TrimSpace(street + "\n" + parts[1])
+		}
+		setStringField(addr, "street", street)
+		setStringField(addr, "city", parts[3])
+		setStringField(addr, "region", parts[4])
+		setStringField(addr, "postcode", parts[5])
+		setStringField(addr, "country", parts[6])
+		if label := p.Param("LABEL"); label != "" {
+			addr["formattedAddress"] = label
+		}
+		if strings.Join(parts, "") != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	c.M["address"] = emptyIfNil(addresses)
+
+	var cozys []interface{}
+	for _, p := range card.GetAll("URL") {
+		for _, t := range p.Types() {
+			if t == "cozy" && p.Value != "" {
+				cozys = append(cozys, map[string]interface{}{"url": p.Value})
+				break
+			}
+		}
+	}
+	if len(cozys) > 0 {
+		c.M["cozy"] = cozys
+	}
+
+	applyVCardText(c, card, "ORG", "company")
+	applyVCardText(c, card, "TITLE", "jobTitle")
+	applyVCardText(c, card, "NOTE", "note")
+	if p := card.Get("BDAY"); p != nil && p.Text() != "" {
+		c.M["birthday"] = normalizeVCardDate(p.Text())
+	} else {
+		delete(c.M, "birthday")
+	}
+}
+
+func applyVCardText(c *Contact, card *VCard, prop, field string) {
+	value := ""
+	if p := card.Get(prop); p != nil {
+		if prop == "ORG" {
+			// Only the organization name is kept, not the units
+			value = strings.TrimSpace(p.Components()[0])
+		} else {
+			value = strings.TrimSpace(p.Text())
+		}
+	}
+	if value == "" {
+		delete(c.M, field)
+	} else {
+		c.M[field] = value
+	}
+}
+
+// normalizeVCardDate transforms the dates in the basic format of ISO 8601
+// (19850412) to the extended format (1985-04-12) used by the cozy apps.
+func normalizeVCardDate(date string) string {
+	if len(date) == 8 && strings.Trim(date, "0123456789") == "" {
+		return date[0:4] + "-" + date[4:6] + "-" + date[6:8]
+	}
+	if strings.HasPrefix(date, "--") && len(date) == 6 {
+		// Birthday without the year
+		return "--" + date[2:4] + "-" + date[4:6]
+	}
+	return date
+}
+
+// vcardTypeParams returns the parameters for the type and the preference of
+// an email, phone or address. The vCard 4 uses the PREF parameter for the
+// preference, when the vCard 3 uses a pref type.
+func vcardTypeParams(version string, field map[string]interface{}) map[string][]string {
+	var types []string
+	if t := stringField(field, "type"); t != "" {
+		types = append(types, strings.ToLower(t))
+	}
+	params := make(map[string][]string)
+	if primary, ok := field["primary"].(bool); ok && primary {
+		if version == VCardVersion4 {
+			params["PREF"] = []string{"1"}
+		} else {
+			types = append(types, "pref")
+		}
+	}
+	if len(types) > 0 {
+		params["TYPE"] = types
+	}
+	if label := stringField(field, "label"); label != "" {
+		params["X-LABEL"] = []string{label}
+	}
+	return params
+}
+
+// vcardTypedField returns the map for an email, phone or address, with the
+// type and primary fields filled from the vCard parameters.
+func vcardTypedField(p *VCardProperty, key, value string) map[string]interface{} {
+	field := make(map[string]interface{})
+	setStringField(field, key, value)
+	primary := p.Param("PREF") != ""
+	for _, t := range p.Types() {
+		switch t {
+		case "pref":
+			primary = true
+		case "internet", "voice", "x400":
+			// These types are the default values and are not kept
+		default:
+			if _, ok := field["type"]; !ok {
+				field["type"] = t
+			}
+		}
+	}
+	if primary {
+		field["primary"] = true
+	}
+	if label := p.Param("X-LABEL"); label != "" {
+		field["label"] = label
+	}
+	return field
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	if m == nil {
+		return ""
+	}
+	s, _ := m[key].(string)
+	return s
+}
+
+func setStringField(m map[string]interface{}, key, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		m[key] = value
+	}
+}
+
+func objectsField(m map[string]interface{}, key string) []map[string]interface{} {
+	var objects []map[string]interface{}
+	switch values := m[key].(type) {
+	case []interface{}:
+		for _, v := range values {
+			if obj, ok := v.(map[string]interface{}); ok {
+				objects = append(objects, obj)
+			}
+		}
+	case []map[string]interface{}:
+		objects = values
+	}
+	return objects
+}
+
+func emptyIfNil(values []interface{}) []interface{} {
+	if values == nil {
+		return []interface{}{}
+	}
+	return values
+}
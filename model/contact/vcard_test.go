@@ -0,0 +1,109 @@
+package contact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVCard(t *testing.T) {
+	raw := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Bob Martin\r\n" +
+		"N:Martin;Bob;;Dr.;\r\n" +
+		"EMAIL;TYPE=INTERNET,WORK,pref:bob@example.com\r\n" +
+		"item1.EMAIL;TYPE=INTERNET:bob@home.example.com\r\n" +
+		"TEL;TYPE=CELL:+33 6 12 34 56 78\r\n" +
+		"ADR;TYPE=HOME:;;12 rue des Lilas;Paris;;75001;France\r\n" +
+		"ORG:Cozy Cloud;Engineering\r\n" +
+		"NOTE:A long note\\, with a comma\\nand a new line that is folded because it is \r\n" +
+		" really long\r\n" +
+		"BDAY:19850412\r\n" +
+		"END:VCARD\r\n"
+	card, err := ParseVCard([]byte(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0", card.Version())
+	emails := card.GetAll("email")
+	if assert.Len(t, emails, 2) {
+		assert.Equal(t, []string{"internet", "work", "pref"}, emails[0].Types())
+		assert.Equal(t, "item1", emails[1].Group)
+	}
+	assert.Equal(t, "A long note, with a comma\nand a new line that is folded because it is really long",
+		card.Get("NOTE").Text())
+
+	c := New()
+	c.M["relationships"] = map[string]interface{}{}
+	c.ApplyVCard(card)
+	assert.Equal(t, "Bob Martin", c.M["fullname"])
+	name := c.M["name"].(map[string]interface{})
+	assert.Equal(t, "Bob", name["givenName"])
+	assert.Equal(t, "Martin", name["familyName"])
+	assert.Equal(t, "Dr.", name["namePrefix"])
+	assert.Equal(t, "Cozy Cloud", c.M["company"])
+	assert.Equal(t, "1985-04-12", c.M["birthday"])
+	assert.Contains(t, c.M, "relationships")
+	mails := c.M["email"].([]interface{})
+	if assert.Len(t, mails, 2) {
+		first := mails[0].(map[string]interface{})
+		assert.Equal(t, "bob@example.com", first["address"])
+		assert.Equal(t, "work", first["type"])
+		assert.Equal(t, true, first["primary"])
+	}
+	addresses := c.M["address"].([]interface{})
+	if assert.Len(t, addresses, 1) {
+		addr := addresses[0].(map[string]interface{})
+		assert.Equal(t, "12 rue des Lilas", addr["street"])
+		assert.Equal(t, "Paris", addr["city"])
+		assert.Equal(t, "75001", addr["postcode"])
+		assert.Equal(t, "home", addr["type"])
+	}
+
+	_, err = ParseVCard([]byte("BEGIN:VCARD\r\nFN:Missing end\r\n"))
+	assert.Equal(t, ErrInvalidVCard, err)
+	cards, err := ParseVCards(strings.NewReader(raw + raw))
+	assert.NoError(t, err)
+	assert.Len(t, cards, 2)
+}
+
+func TestToVCard(t *testing.T) {
+	c := New()
+	c.SetID("ada")
+	c.M["fullname"] = "Ada Lovelace"
+	c.M["name"] = map[string]interface{}{"givenName": "Ada", "familyName": "Lovelace"}
+	c.M["email"] = []interface{}{
+		map[string]interface{}{"address": "ada@example.com", "type": "home", "primary": true},
+	}
+	c.M["note"] = "Countess; mathematician"
+
+	v3 := string(c.ToVCard(VCardVersion3).Bytes())
+	assert.True(t, strings.HasPrefix(v3, "BEGIN:VCARD\r\nVERSION:3.0\r\n"))
+	assert.Contains(t, v3, "UID:ada\r\n")
+	assert.Contains(t, v3, "N:Lovelace;Ada;;;\r\n")
+	assert.Contains(t, v3, "EMAIL;TYPE=home,pref:ada@example.com\r\n")
+	assert.Contains(t, v3, "NOTE:Countess\\; mathematician\r\n")
+	assert.True(t, strings.HasSuffix(v3, "END:VCARD\r\n"))
+
+	v4 := string(c.ToVCard(VCardVersion4).Bytes())
+	assert.Contains(t, v4, "VERSION:4.0\r\n")
+	assert.Contains(t, v4, "EMAIL;PREF=1;TYPE=home:ada@example.com\r\n")
+
+	// Round-trip
+	card, err := ParseVCard([]byte(v4))
+	assert.NoError(t, err)
+	other := New()
+	other.ApplyVCard(card)
+	assert.Equal(t, c.M["fullname"], other.M["fullname"])
+	assert.Equal(t, c.M["note"], other.M["note"])
+	assert.Equal(t, c.M["email"], other.M["email"])
+
+	// Long lines are folded
+	c.M["note"] = strings.Repeat("é", 100)
+	folded := string(c.ToVCard(VCardVersion3).Bytes())
+	for _, line := range strings.Split(folded, "\r\n") {
+		assert.True(t, len(line) <= 76)
+	}
+	card, err = ParseVCard([]byte(folded))
+	assert.NoError(t, err)
+	assert.Equal(t, c.M["note"], card.Get("NOTE").Text())
+}
@@ -0,0 +1,106 @@
+package instance
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// appPasswordLen is the number of random characters in an app-specific
+// password. With the 62 letters alphabet of GenerateRandomString, it gives
+// more than 140 bits of entropy.
+const appPasswordLen = 24
+
+// appPasswordUsagePrecision is the precision of the last-used time of the
+// app-specific passwords: the instance is not saved on each request made
+// with such a password, only when the last-used time is older than that.
+const appPasswordUsagePrecision = 10 * time.Minute
+
+// AppPassword is a revocable password for the clients that can't use OAuth2
+// (WebDAV mounts, CalDAV/CardDAV apps, scripts). It can be sent via HTTP
+// Basic auth on the routes that accept it, and gives only the permissions
+// chosen by the user when it was created.
+type AppPassword struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Hash        string         `json:"hash"`
+	Permissions permission.Set `json:"permissions"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at,omitempty"`
+}
+
+// Permission returns a non-persisted permission document for the requests
+// authenticated with this password.
+func (ap *AppPassword) Permission() *permission.Permission {
+	return &permission.Permission{
+		Type:        permission.TypeAppPassword,
+		SourceID:    consts.AppPasswords + "/" + ap.ID,
+		Permissions: ap.Permissions,
+	}
+}
+
+// GenerateAppPassword creates a new app-specific password with the given
+// permissions, and returns it with the password in clear. Only the hash of the
+// password is kept, the caller is responsible for saving the instance.
+func (i *Instance) GenerateAppPassword(name string, perms permission.Set) (*AppPassword, string) {
+	password := crypto.GenerateRandomString(appPasswordLen)
+	ap := AppPassword{
+		ID:          hex.EncodeToString(crypto.GenerateRandomBytes(8)),
+		Name:        name,
+		Hash:        hashAppPassword(password),
+		Permissions: perms,
+		CreatedAt:   time.Now().UTC(),
+	}
+	i.AppPasswords = append(i.AppPasswords, ap)
+	return &ap, password
+}
+
+// RemoveAppPassword removes the app-specific password with the given ID. The
+// caller is responsible for saving the instance.
+func (i *Instance) RemoveAppPassword(id string) error {
+	for k, ap := range i.AppPasswords {
+		if ap.ID == id {
+			i.AppPasswords = append(i.AppPasswords[:k:k], i.AppPasswords[k+1:]...)
+			return nil
+		}
+	}
+	return ErrAppPasswordNotFound
+}
+
+// CheckAppPassword returns the app-specific password that matches the given
+// password, or nil if there is none. Its last-used time is updated.
+func (i *Instance) CheckAppPassword(password string) *AppPassword {
+	password = strings.TrimSpace(password)
+	if len(password) != appPasswordLen {
+		return nil
+	}
+	hash := []byte(hashAppPassword(password))
+	for k := range i.AppPasswords {
+		ap := &i.AppPasswords[k]
+		if subtle.ConstantTimeCompare([]byte(ap.Hash), hash) != 1 {
+			continue
+		}
+		now := time.Now().UTC()
+		if ap.LastUsedAt == nil || now.Sub(*ap.LastUsedAt) > appPasswordUsagePrecision {
+			ap.LastUsedAt = &now
+			if err := i.Update(); err != nil {
+				i.Logger().WithField("nspace", "auth").
+					Warnf("Cannot update the last-used time of an app password: %s", err)
+			}
+		}
+		found := *ap
+		return &found
+	}
+	return nil
+}
+
+func hashAppPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,45 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppPasswords(t *testing.T) {
+	inst := &Instance{}
+	perms := permission.Set{permission.Rule{Type: consts.Files, Verbs: permission.ALL}}
+	ap, password := inst.GenerateAppPassword("WebDAV on my laptop", perms)
+	assert.Len(t, password, appPasswordLen)
+	assert.NotEqual(t, password, ap.Hash)
+	assert.Len(t, inst.AppPasswords, 1)
+	other, otherPassword := inst.GenerateAppPassword("Backup script", perms)
+	assert.NotEqual(t, ap.ID, other.ID)
+	assert.NotEqual(t, password, otherPassword)
+
+	// Avoid saving the instance in this test
+	now := time.Now().UTC()
+	for k := range inst.AppPasswords {
+		inst.AppPasswords[k].LastUsedAt = &now
+	}
+
+	found := inst.CheckAppPassword(password)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, ap.ID, found.ID)
+		pdoc := found.Permission()
+		assert.Equal(t, permission.TypeAppPassword, pdoc.Type)
+		assert.Equal(t, consts.AppPasswords+"/"+ap.ID, pdoc.SourceID)
+		assert.True(t, pdoc.Permissions.AllowWholeType(permission.GET, consts.Files))
+		assert.False(t, pdoc.Permissions.AllowWholeType(permission.GET, consts.Contacts))
+	}
+	assert.Nil(t, inst.CheckAppPassword(password[1:]))
+	assert.Nil(t, inst.CheckAppPassword(""))
+
+	assert.NoError(t, inst.RemoveAppPassword(ap.ID))
+	assert.Equal(t, ErrAppPasswordNotFound, inst.RemoveAppPassword(ap.ID))
+	assert.Nil(t, inst.CheckAppPassword(password))
+	assert.NotNil(t, inst.CheckAppPassword(otherPassword))
+}
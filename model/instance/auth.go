@@ -5,6 +5,7 @@ import (
 	"encoding/base32"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/pkg/crypto"
@@ -50,6 +51,11 @@ const (
 	Basic AuthMode = iota
 	// TwoFactorMail authentication mode, with passcode sent via email
 	TwoFactorMail
+	// TwoFactorTOTP authentication mode, with passcode generated by an
+	// authenticator app
+	TwoFactorTOTP
+	// TwoFactorWebAuthn authentication mode, with a security key
+	TwoFactorWebAuthn
 )
 
 // AuthModeToString encode authentication mode in a string
@@ -57,6 +63,10 @@ func AuthModeToString(authMode AuthMode) string {
 	switch authMode {
 	case TwoFactorMail:
 		return "two_factor_mail"
+	case TwoFactorTOTP:
+		return "two_factor_totp"
+	case TwoFactorWebAuthn:
+		return "two_factor_webauthn"
 	default:
 		return "basic"
 	}
@@ -68,6 +78,10 @@ func StringToAuthMode(authMode string) (AuthMode, error) {
 	switch authMode {
 	case "two_factor_mail":
 		return TwoFactorMail, nil
+	case "two_factor_totp":
+		return TwoFactorTOTP, nil
+	case "two_factor_webauthn":
+		return TwoFactorWebAuthn, nil
 	case "basic":
 		return Basic, nil
 	default:
@@ -81,6 +95,42 @@ func (i *Instance) HasAuthMode(authMode AuthMode) bool {
 	return i.AuthMode == authMode
 }
 
+// HasTwoFactor returns true if a second factor is required to log in.
+func (i *Instance) HasTwoFactor() bool {
+	return i.AuthMode != Basic
+}
+
+// CheckSecondFactor returns an error if the second factor for the given
+// authentication mode has not been enrolled.
+func (i *Instance) CheckSecondFactor(authMode AuthMode) error {
+	switch authMode {
+	case TwoFactorTOTP:
+		if len(i.TOTPSecret) == 0 {
+			return ErrNoSecondFactor
+		}
+	case TwoFactorWebAuthn:
+		if len(i.WebAuthnCredentials) == 0 {
+			return ErrNoSecondFactor
+		}
+	}
+	return nil
+}
+
+// HasTwoFactorMailFallback returns true if a passcode can be sent by mail when
+// the second factor is an authenticator app or a security key, for the users
+// who can't use them. It can be enabled in the context configuration.
+func (i *Instance) HasTwoFactorMailFallback() bool {
+	if i.HasAuthMode(TwoFactorMail) {
+		return true
+	}
+	if ctxSettings, ok := i.SettingsContext(); ok {
+		if fallback, ok := ctxSettings["two_factor_mail_fallback"].(bool); ok {
+			return fallback
+		}
+	}
+	return false
+}
+
 // GenerateTwoFactorSecrets generates a (token, passcode) pair that can be
 // used as a two factor authentication secret value. The token is used to allow
 // the two-factor form — meaning the user has correctly entered its passphrase
@@ -109,18 +159,46 @@ func (i *Instance) GenerateTwoFactorSecrets() (token []byte, passcode string, er
 	return
 }
 
+// CheckTwoFactorToken returns true if the token has been generated for the
+// two-factor authentication of this instance.
+func (i *Instance) CheckTwoFactorToken(token []byte) bool {
+	_, err := crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
+	return err == nil
+}
+
 // ValidateTwoFactorPasscode validates the given (token, passcode) pair for two
-// factor authentication.
+// factor authentication. The passcode can be the one sent by mail, the one
+// generated by the authenticator app, a WebAuthn assertion (serialized in
+// JSON), or a recovery code.
 func (i *Instance) ValidateTwoFactorPasscode(token []byte, passcode string) bool {
 	salt, err := crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
 	if err != nil {
 		return false
 	}
+	if i.HasTwoFactorMailFallback() && i.validateMailPasscode(salt, passcode) {
+		return true
+	}
+	if i.HasAuthMode(TwoFactorMail) {
+		return false
+	}
+	// Without two-factor authentication, the passcode can have been sent by
+	// mail when a second factor was asked for a suspicious login
+	if !i.HasTwoFactor() {
+		return i.validateMailPasscode(salt, passcode)
+	}
+	if len(i.TOTPSecret) > 0 && i.validateTOTP(passcode) {
+		return true
+	}
+	if len(i.WebAuthnCredentials) > 0 && strings.HasPrefix(passcode, "{") {
+		return i.validateWebAuthnAssertion(token, []byte(passcode)) == nil
+	}
+	return i.useRecoveryCode(passcode)
+}
 
+func (i *Instance) validateMailPasscode(salt []byte, passcode string) bool {
 	h := hkdf.New(sha256.New, i.SessionSecret(), salt, nil)
 	key := make([]byte, 32)
-	_, err = io.ReadFull(h, key)
-	if err != nil {
+	if _, err := io.ReadFull(h, key); err != nil {
 		return false
 	}
 	ok, err := totp.ValidateCustom(passcode, base32.StdEncoding.EncodeToString(key),
@@ -20,6 +20,15 @@ var (
 	// ErrInvalidTwoFactor is returned when the two-factor authentication
 	// verification is invalid.
 	ErrInvalidTwoFactor = errors.New("Invalid two-factor parameters")
+	// ErrInvalidWebAuthn is returned when a WebAuthn credential or assertion
+	// is invalid.
+	ErrInvalidWebAuthn = errors.New("Invalid WebAuthn credential")
+	// ErrNoSecondFactor is returned when an authentication mode is chosen
+	// but its second factor has not been enrolled.
+	ErrNoSecondFactor = errors.New("The second factor has not been enrolled")
+	// ErrAppPasswordNotFound is returned when an app-specific password is
+	// not found on the instance.
+	ErrAppPasswordNotFound = errors.New("App password not found")
 	// ErrResetAlreadyRequested is returned when a passphrase reset token is already set and valid
 	ErrResetAlreadyRequested = errors.New("The passphrase reset has already been requested")
 	// ErrUnknownAuthMode is returned when an unknown authentication mode is
@@ -91,6 +91,24 @@ type Instance struct {
 	OAuthSecret []byte `json:"oauth_secret,omitempty"`
 	// CLISecret is used to authenticate request from the CLI
 	CLISecret []byte `json:"cli_secret,omitempty"`
+	// TOTPSecret is shared with the authenticator app of the user for the
+	// two-factor authentication
+	TOTPSecret []byte `json:"totp_secret,omitempty"`
+	// TOTPLastStep is the time step of the last passcode accepted from the
+	// authenticator app, to refuse a passcode that has already been used
+	TOTPLastStep int64 `json:"totp_last_step,omitempty"`
+	// WebAuthnCredentials are the security keys registered for the two-factor
+	// authentication
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// RecoveryCodes are the hashes of the codes that can be used once when
+	// the second factor is not available
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// AppPasswords are the passwords generated for the clients that can't use
+	// OAuth2 (only their hashes are kept)
+	AppPasswords []AppPassword `json:"app_passwords,omitempty"`
+	// OIDCPrivateKey is the RSA private key (PKCS#8) used to sign the ID
+	// tokens when the stack is used as an OpenID Connect provider
+	OIDCPrivateKey []byte `json:"oidc_private_key,omitempty"`
 
 	// FeatureFlags is the feature flags that are specific to this instance
 	FeatureFlags map[string]interface{} `json:"feature_flags,omitempty"`
@@ -145,6 +163,28 @@ func (i *Instance) Clone() couchdb.Doc {
 
 	cloned.CLISecret = make([]byte, len(i.CLISecret))
 	copy(cloned.CLISecret, i.CLISecret)
+
+	cloned.TOTPSecret = make([]byte, len(i.TOTPSecret))
+	copy(cloned.TOTPSecret, i.TOTPSecret)
+
+	cloned.WebAuthnCredentials = make([]WebAuthnCredential, len(i.WebAuthnCredentials))
+	copy(cloned.WebAuthnCredentials, i.WebAuthnCredentials)
+
+	cloned.RecoveryCodes = make([]string, len(i.RecoveryCodes))
+	copy(cloned.RecoveryCodes, i.RecoveryCodes)
+
+	cloned.OIDCPrivateKey = make([]byte, len(i.OIDCPrivateKey))
+	copy(cloned.OIDCPrivateKey, i.OIDCPrivateKey)
+
+	cloned.AppPasswords = make([]AppPassword, len(i.AppPasswords))
+	for k, ap := range i.AppPasswords {
+		ap.Permissions = append(permission.Set{}, ap.Permissions...)
+		if ap.LastUsedAt != nil {
+			lastUsed := *ap.LastUsedAt
+			ap.LastUsedAt = &lastUsed
+		}
+		cloned.AppPasswords[k] = ap
+	}
 	return &cloned
 }
 
@@ -356,8 +356,8 @@ func addTriggers(i *instance.Instance) error {
 
 // Triggers returns the list of the triggers to add when an instance is created
 func Triggers(db prefixer.Prefixer) []job.TriggerInfos {
-	// Create/update/remove thumbnails when an image is created/updated/removed
 	return []job.TriggerInfos{
+		// Create/update/remove thumbnails when an image is created/updated/removed
 		{
 			Domain:     db.DomainName(),
 			Prefix:     db.DBPrefix(),
@@ -365,5 +365,14 @@ func Triggers(db prefixer.Prefixer) []job.TriggerInfos {
 			WorkerType: "thumbnail",
 			Arguments:  "io.cozy.files:CREATED,UPDATED,DELETED:image:class",
 		},
+		// Look for duplicate contacts when some contacts are created/updated
+		{
+			Domain:     db.DomainName(),
+			Prefix:     db.DBPrefix(),
+			Type:       "@event",
+			WorkerType: "contacts-dedup",
+			Arguments:  "io.cozy.contacts:CREATED,UPDATED",
+			Debounce:   "10m",
+		},
 	}
 }
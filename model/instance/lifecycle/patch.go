@@ -90,6 +90,9 @@ func Patch(i *instance.Instance, opts *Options) error {
 			if err != nil {
 				return err
 			}
+			if err = i.CheckSecondFactor(authMode); err != nil {
+				return err
+			}
 			if i.AuthMode != authMode {
 				i.AuthMode = authMode
 				needUpdate = true
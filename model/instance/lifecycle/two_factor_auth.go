@@ -31,3 +31,106 @@ func SendMailConfirmationCode(inst *instance.Instance) error {
 		TemplateValues: map[string]interface{}{"TwoFactorActivationPasscode": passcode},
 	})
 }
+
+// StartTwoFactor must be called when the passphrase has been checked and a
+// second factor is required. It returns the token for the second step. The
+// passcode is sent by mail only for the two_factor_mail mode, as the other
+// modes use an authenticator app or a security key. For an instance without
+// two-factor authentication, a second factor can still be asked (for example,
+// for a login from a new country), and the passcode is sent by mail.
+func StartTwoFactor(inst *instance.Instance) ([]byte, error) {
+	if inst.HasAuthMode(instance.TwoFactorMail) || !inst.HasTwoFactor() {
+		return SendTwoFactorPasscode(inst)
+	}
+	token, _, err := inst.GenerateTwoFactorSecrets()
+	return token, err
+}
+
+// SendTwoFactorFallbackPasscode sends by mail a passcode to a user who can't
+// use their authenticator app or security key, if the context allows it.
+func SendTwoFactorFallbackPasscode(inst *instance.Instance) ([]byte, error) {
+	if !inst.HasTwoFactorMailFallback() {
+		return nil, instance.ErrInvalidTwoFactor
+	}
+	return SendTwoFactorPasscode(inst)
+}
+
+// EnableTOTP saves the secret shared with the authenticator app, and uses it
+// as the second factor. If the instance has no recovery codes, they are
+// generated and returned.
+func EnableTOTP(inst *instance.Instance, secret []byte) ([]string, error) {
+	inst.TOTPSecret = secret
+	inst.AuthMode = instance.TwoFactorTOTP
+	var codes []string
+	if len(inst.RecoveryCodes) == 0 {
+		codes = inst.GenerateRecoveryCodes()
+	}
+	return codes, update(inst)
+}
+
+// DisableTOTP removes the secret shared with the authenticator app.
+func DisableTOTP(inst *instance.Instance) error {
+	inst.TOTPSecret = nil
+	fallbackAuthMode(inst)
+	return update(inst)
+}
+
+// AddWebAuthnCredential adds a security key to the instance, and uses
+// security keys as the second factor. If the instance has no recovery codes,
+// they are generated and returned.
+func AddWebAuthnCredential(inst *instance.Instance, cred *instance.WebAuthnCredential) ([]string, error) {
+	inst.WebAuthnCredentials = append(inst.WebAuthnCredentials, *cred)
+	inst.AuthMode = instance.TwoFactorWebAuthn
+	var codes []string
+	if len(inst.RecoveryCodes) == 0 {
+		codes = inst.GenerateRecoveryCodes()
+	}
+	return codes, update(inst)
+}
+
+// RemoveWebAuthnCredential removes a security key from the instance.
+func RemoveWebAuthnCredential(inst *instance.Instance, id string) error {
+	creds := inst.WebAuthnCredentials[:0]
+	for _, cred := range inst.WebAuthnCredentials {
+		if cred.ID != id {
+			creds = append(creds, cred)
+		}
+	}
+	if len(creds) == len(inst.WebAuthnCredentials) {
+		return instance.ErrInvalidWebAuthn
+	}
+	inst.WebAuthnCredentials = creds
+	fallbackAuthMode(inst)
+	return update(inst)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes by new ones.
+func RegenerateRecoveryCodes(inst *instance.Instance) ([]string, error) {
+	if len(inst.TOTPSecret) == 0 && len(inst.WebAuthnCredentials) == 0 {
+		return nil, instance.ErrNoSecondFactor
+	}
+	codes := inst.GenerateRecoveryCodes()
+	return codes, update(inst)
+}
+
+// fallbackAuthMode changes the authentication mode when its second factor has
+// been removed: the other enrolled second factor is used if any, else the
+// passcode sent by mail if the context allows it, else the passphrase only.
+func fallbackAuthMode(inst *instance.Instance) {
+	if len(inst.TOTPSecret) == 0 && len(inst.WebAuthnCredentials) == 0 {
+		inst.RecoveryCodes = nil
+	}
+	if inst.CheckSecondFactor(inst.AuthMode) == nil {
+		return
+	}
+	switch {
+	case len(inst.WebAuthnCredentials) > 0:
+		inst.AuthMode = instance.TwoFactorWebAuthn
+	case len(inst.TOTPSecret) > 0:
+		inst.AuthMode = instance.TwoFactorTOTP
+	case inst.HasTwoFactorMailFallback():
+		inst.AuthMode = instance.TwoFactorMail
+	default:
+		inst.AuthMode = instance.Basic
+	}
+}
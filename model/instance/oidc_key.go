@@ -0,0 +1,47 @@
+package instance
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// OIDCSigningKey returns the RSA private key used to sign the ID tokens when
+// the stack acts as an OpenID Connect provider. The key is generated and
+// saved on the instance the first time it is needed.
+func (i *Instance) OIDCSigningKey() (*rsa.PrivateKey, error) {
+	if len(i.OIDCPrivateKey) == 0 {
+		_, priv, err := crypto.GenerateRSAKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		i.OIDCPrivateKey = priv
+		if err := i.Update(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(i.OIDCPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Invalid OIDC signing key")
+	}
+	return priv, nil
+}
+
+// OIDCKeyID returns the identifier of the public key used to sign the ID
+// tokens, for the kid header of the tokens and the JWKS endpoint.
+func OIDCKeyID(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
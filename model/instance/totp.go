@@ -0,0 +1,179 @@
+package instance
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// TOTPSecretLen is the length of the secret shared with the authenticator
+// apps (in bytes).
+const TOTPSecretLen = 20
+
+// RecoveryCodesCount is the number of recovery codes generated for an
+// instance with an authenticator app or a security key as second factor.
+const RecoveryCodesCount = 10
+
+// The authenticator apps only support the default options of RFC 6238: SHA1
+// and 6 digits every 30 seconds.
+var authenticatorTOTPOptions = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var totpEnrolmentMACConfig = crypto.MACConfig{
+	Name:   "totp-enrolment",
+	MaxAge: 15 * time.Minute,
+	MaxLen: 256,
+}
+
+// TOTPEnrolment contains the informations for adding the instance to an
+// authenticator app.
+type TOTPEnrolment struct {
+	// Secret is the base32 encoded secret, for the apps where it must be
+	// typed manually
+	Secret string `json:"secret"`
+	// URL is the otpauth:// URL to display as a QR-code
+	URL string `json:"url"`
+	// Token must be sent back with a passcode to finish the enrolment
+	Token string `json:"token"`
+}
+
+// GenerateTOTPEnrolment generates a new secret for an authenticator app. The
+// secret is not saved on the instance until a passcode generated by the
+// authenticator app has been checked with ValidateTOTPEnrolment.
+func (i *Instance) GenerateTOTPEnrolment() (*TOTPEnrolment, error) {
+	secret := crypto.GenerateRandomBytes(TOTPSecretLen)
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      i.TemplateTitle(),
+		AccountName: i.Domain,
+		Secret:      secret,
+		Period:      authenticatorTOTPOptions.Period,
+		Digits:      authenticatorTOTPOptions.Digits,
+		Algorithm:   authenticatorTOTPOptions.Algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := crypto.EncodeAuthMessage(totpEnrolmentMACConfig, i.SessionSecret(), secret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrolment{
+		Secret: key.Secret(),
+		URL:    key.URL(),
+		Token:  string(token),
+	}, nil
+}
+
+// ValidateTOTPEnrolment checks that the passcode has been generated by an
+// authenticator app with the secret of the enrolment token, and returns this
+// secret.
+func (i *Instance) ValidateTOTPEnrolment(token []byte, passcode string) ([]byte, error) {
+	secret, err := crypto.DecodeAuthMessage(totpEnrolmentMACConfig, i.SessionSecret(), token, nil)
+	if err != nil {
+		return nil, ErrInvalidTwoFactor
+	}
+	if !checkTOTP(secret, passcode) {
+		return nil, ErrInvalidTwoFactor
+	}
+	return secret, nil
+}
+
+// validateTOTP checks a passcode generated by the authenticator app. A
+// passcode can be used only once: its time step is saved on the instance, and
+// the passcodes for this step or an earlier one are refused.
+func (i *Instance) validateTOTP(passcode string) bool {
+	step, ok := i.checkTOTPStep(passcode, time.Now().UTC())
+	if !ok {
+		return false
+	}
+	i.TOTPLastStep = step
+	if err := i.Update(); err != nil {
+		i.Logger().WithField("nspace", "auth").
+			Errorf("Cannot save the TOTP time step: %s", err)
+		return false
+	}
+	return true
+}
+
+// checkTOTPStep returns the time step of the passcode, and false if the
+// passcode is invalid or its time step is not after the last accepted one.
+func (i *Instance) checkTOTPStep(passcode string, now time.Time) (int64, bool) {
+	if len(passcode) != authenticatorTOTPOptions.Digits.Length() {
+		return 0, false
+	}
+	secret := base32.StdEncoding.EncodeToString(i.TOTPSecret)
+	period := int64(authenticatorTOTPOptions.Period)
+	current := now.Unix() / period
+	for skew := -int64(authenticatorTOTPOptions.Skew); skew <= int64(authenticatorTOTPOptions.Skew); skew++ {
+		step := current + skew
+		code, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0).UTC(), authenticatorTOTPOptions)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) == 1 {
+			return step, step > i.TOTPLastStep
+		}
+	}
+	return 0, false
+}
+
+func checkTOTP(secret []byte, passcode string) bool {
+	ok, err := totp.ValidateCustom(passcode, base32.StdEncoding.EncodeToString(secret),
+		time.Now().UTC(), authenticatorTOTPOptions)
+	return ok && err == nil
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the instance by new
+// ones, and returns them. Only their hashes are kept on the instance, the
+// caller is responsible for saving it.
+func (i *Instance) GenerateRecoveryCodes() []string {
+	codes := make([]string, RecoveryCodesCount)
+	hashes := make([]string, RecoveryCodesCount)
+	for k := range codes {
+		code := hex.EncodeToString(crypto.GenerateRandomBytes(8))
+		codes[k] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		hashes[k] = hashRecoveryCode(code)
+	}
+	i.RecoveryCodes = hashes
+	return codes
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode returns true if the code is one of the recovery codes of
+// the instance. In that case, the code is removed from the list, as it can be
+// used only once.
+func (i *Instance) useRecoveryCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	hash := hashRecoveryCode(code)
+	for k, h := range i.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		i.RecoveryCodes = append(i.RecoveryCodes[:k:k], i.RecoveryCodes[k+1:]...)
+		if err := i.Update(); err != nil {
+			i.Logger().WithField("nspace", "auth").
+				Errorf("Cannot remove the recovery code: %s", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package instance
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestAuthModeStrings(t *testing.T) {
+	for _, mode := range []AuthMode{Basic, TwoFactorMail, TwoFactorTOTP, TwoFactorWebAuthn} {
+		parsed, err := StringToAuthMode(AuthModeToString(mode))
+		assert.NoError(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+
+	inst := &Instance{AuthMode: TwoFactorTOTP}
+	assert.True(t, inst.HasTwoFactor())
+	assert.Equal(t, ErrNoSecondFactor, inst.CheckSecondFactor(TwoFactorTOTP))
+	assert.Equal(t, ErrNoSecondFactor, inst.CheckSecondFactor(TwoFactorWebAuthn))
+	assert.NoError(t, inst.CheckSecondFactor(TwoFactorMail))
+	inst.TOTPSecret = []byte("secret")
+	assert.NoError(t, inst.CheckSecondFactor(TwoFactorTOTP))
+}
+
+func TestTOTPEnrolment(t *testing.T) {
+	inst := &Instance{
+		Domain:     "alice.cozy.example.net",
+		SessSecret: crypto.GenerateRandomBytes(SessionSecretLen),
+	}
+	enrolment, err := inst.GenerateTOTPEnrolment()
+	assert.NoError(t, err)
+	assert.Contains(t, enrolment.URL, "otpauth://totp/")
+	assert.Contains(t, enrolment.URL, enrolment.Secret)
+
+	_, err = inst.ValidateTOTPEnrolment([]byte(enrolment.Token), "000000x")
+	assert.Equal(t, ErrInvalidTwoFactor, err)
+
+	passcode, err := totp.GenerateCode(enrolment.Secret, time.Now())
+	assert.NoError(t, err)
+	secret, err := inst.ValidateTOTPEnrolment([]byte(enrolment.Token), passcode)
+	assert.NoError(t, err)
+	assert.Equal(t, enrolment.Secret, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+
+	inst.TOTPSecret = secret
+	now := time.Now().UTC()
+	step, ok := inst.checkTOTPStep(passcode, now)
+	assert.True(t, ok)
+	assert.InDelta(t, now.Unix()/30, step, 1)
+	_, ok = inst.checkTOTPStep("123", now)
+	assert.False(t, ok)
+
+	// A passcode cannot be used twice
+	inst.TOTPLastStep = step
+	_, ok = inst.checkTOTPStep(passcode, now)
+	assert.False(t, ok)
+	next, err := totp.GenerateCode(enrolment.Secret, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	_, ok = inst.checkTOTPStep(next, now)
+	assert.True(t, ok)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	inst := &Instance{}
+	codes := inst.GenerateRecoveryCodes()
+	assert.Len(t, codes, RecoveryCodesCount)
+	assert.Len(t, inst.RecoveryCodes, RecoveryCodesCount)
+	for i, code := range codes {
+		assert.Len(t, code, 19)
+		assert.Equal(t, inst.RecoveryCodes[i], hashRecoveryCode(code))
+		assert.NotContains(t, inst.RecoveryCodes, code)
+	}
+	// The dashes and the case are ignored
+	upper := []byte(codes[0])
+	for i, c := range upper {
+		if c >= 'a' && c <= 'f' {
+			upper[i] = c - 'a' + 'A'
+		}
+	}
+	assert.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(string(upper)))
+	assert.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(codes[0][0:4]+codes[0][5:]))
+}
+
+// fakeAuthenticator is a security key for the tests
+type fakeAuthenticator struct {
+	id        []byte
+	key       *ecdsa.PrivateKey
+	signCount uint32
+}
+
+func (a *fakeAuthenticator) authData(inst *Instance, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(inst.WebAuthnRPID()))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := byte(authDataUserPresent)
+	if attested {
+		flags |= authDataAttested
+	}
+	data = append(data, flags)
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, a.signCount)
+	data = append(data, count...)
+	if !attested {
+		return data
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	idLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLen, uint16(len(a.id)))
+	data = append(data, idLen...)
+	data = append(data, a.id...)
+	var coseKey []byte
+	_ = codec.NewEncoderBytes(&coseKey, &codec.CborHandle{}).Encode(map[int64]interface{}{
+		1:  2,
+		3:  coseAlgES256,
+		-1: 1,
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	return append(data, coseKey...)
+}
+
+func clientDataJSON(inst *Instance, typ string, challenge []byte) []byte {
+	data, _ := json.Marshal(webAuthnClientData{
+		Type:      typ,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    inst.Scheme() + "://" + inst.ContextualDomain(),
+	})
+	return data
+}
+
+func TestWebAuthn(t *testing.T) {
+	inst := &Instance{
+		Domain:     "alice.cozy.example.net",
+		SessSecret: crypto.GenerateRandomBytes(SessionSecretLen),
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	authenticator := &fakeAuthenticator{id: crypto.GenerateRandomBytes(16), key: key}
+
+	// Registration
+	challenge := crypto.GenerateRandomBytes(32)
+	token, err := crypto.EncodeAuthMessage(webAuthnRegistrationMACConfig, inst.SessionSecret(), challenge, nil)
+	assert.NoError(t, err)
+	var attObj []byte
+	err = codec.NewEncoderBytes(&attObj, &codec.CborHandle{}).Encode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authenticator.authData(inst, true),
+	})
+	assert.NoError(t, err)
+	att := &WebAuthnAttestation{ID: base64.RawURLEncoding.EncodeToString(authenticator.id)}
+	att.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(attObj)
+
+	att.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON(inst, "webauthn.get", challenge))
+	_, err = inst.ValidateWebAuthnRegistration(token, "My key", att)
+	assert.Equal(t, ErrInvalidWebAuthn, err)
+
+	att.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON(inst, "webauthn.create", challenge))
+	cred, err := inst.ValidateWebAuthnRegistration(token, "My key", att)
+	assert.NoError(t, err)
+	assert.Equal(t, att.ID, cred.ID)
+	assert.Equal(t, "My key", cred.Name)
+	inst.WebAuthnCredentials = append(inst.WebAuthnCredentials, *cred)
+
+	// The same key can't be registered twice
+	_, err = inst.ValidateWebAuthnRegistration(token, "My key", att)
+	assert.Equal(t, ErrInvalidWebAuthn, err)
+
+	// Assertion
+	twoFactorToken, _, err := inst.GenerateTwoFactorSecrets()
+	assert.NoError(t, err)
+	options := inst.WebAuthnRequestOptions(twoFactorToken)
+	assert.Equal(t, "alice.cozy.example.net", options.RPID)
+	assert.Len(t, options.AllowCredentials, 1)
+	assertChallenge, err := base64.RawURLEncoding.DecodeString(options.Challenge)
+	assert.NoError(t, err)
+
+	sign := func(clientData []byte) []byte {
+		authData := authenticator.authData(inst, false)
+		hash := sha256.Sum256(clientData)
+		signed := sha256.Sum256(append(append([]byte{}, authData...), hash[:]...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, signed[:])
+		assert.NoError(t, err)
+		var assertion WebAuthnAssertion
+		assertion.ID = cred.ID
+		assertion.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientData)
+		assertion.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+		assertion.Response.Signature = base64.RawURLEncoding.EncodeToString(sig)
+		data, _ := json.Marshal(assertion)
+		return data
+	}
+
+	good := sign(clientDataJSON(inst, "webauthn.get", assertChallenge))
+	assert.NoError(t, inst.validateWebAuthnAssertion(twoFactorToken, good))
+
+	otherToken, _, err := inst.GenerateTwoFactorSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, ErrInvalidWebAuthn, inst.validateWebAuthnAssertion(otherToken, good))
+
+	bad := sign(clientDataJSON(inst, "webauthn.get", challenge))
+	assert.Equal(t, ErrInvalidWebAuthn, inst.validateWebAuthnAssertion(twoFactorToken, bad))
+
+	assert.False(t, inst.isWebAuthnOrigin("https://evil.example.net"))
+	assert.True(t, inst.isWebAuthnOrigin(inst.Scheme()+"://settings.alice.cozy.example.net"))
+	assert.False(t, inst.isWebAuthnOrigin(inst.Scheme()+"://drive.alice.cozy.example.net"))
+}
@@ -0,0 +1,480 @@
+package instance
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	pkgcrypto "github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/ugorji/go/codec"
+)
+
+// The COSE algorithms supported for the security keys
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// The flags of the authenticator data
+const (
+	authDataUserPresent = 0x01
+	authDataAttested    = 0x40
+)
+
+// webAuthnTimeout is the time given to the user to use their security key
+const webAuthnTimeout = 2 * time.Minute
+
+var webAuthnRegistrationMACConfig = pkgcrypto.MACConfig{
+	Name:   "webauthn-registration",
+	MaxAge: 15 * time.Minute,
+	MaxLen: 256,
+}
+
+// WebAuthnCredential is a security key registered for the two-factor
+// authentication.
+type WebAuthnCredential struct {
+	// ID is the credential identifier, encoded in base64url
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// PublicKey is the public key of the credential, in PKIX format
+	PublicKey []byte    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebAuthnRelyingParty is the relying party in the WebAuthn options.
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUser is the user in the WebAuthn options.
+type WebAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// WebAuthnCredentialParameter is an algorithm accepted for the credentials.
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// WebAuthnCredentialDescriptor identifies a credential in the WebAuthn
+// options.
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// WebAuthnCreationOptions are the options for navigator.credentials.create.
+// The binary values are encoded in base64url.
+type WebAuthnCreationOptions struct {
+	Challenge          string                         `json:"challenge"`
+	RP                 WebAuthnRelyingParty           `json:"rp"`
+	User               WebAuthnUser                   `json:"user"`
+	PubKeyCredParams   []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout            int64                          `json:"timeout"`
+	ExcludeCredentials []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	Attestation        string                         `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are the options for navigator.credentials.get. The
+// binary values are encoded in base64url.
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	RPID             string                         `json:"rpId"`
+	Timeout          int64                          `json:"timeout"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnAttestation is the credential created by the browser for
+// registering a security key. The binary values are encoded in base64url.
+type WebAuthnAttestation struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// WebAuthnAssertion is the credential sent by the browser when the user logs
+// in with a security key. The binary values are encoded in base64url.
+type WebAuthnAssertion struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+	} `json:"response"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webAuthnAuthData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// WebAuthnRPID returns the relying party identifier for WebAuthn, ie the
+// domain of the instance.
+func (i *Instance) WebAuthnRPID() string {
+	return strings.Split(i.ContextualDomain(), ":")[0]
+}
+
+func (i *Instance) webAuthnDescriptors() []WebAuthnCredentialDescriptor {
+	descriptors := make([]WebAuthnCredentialDescriptor, len(i.WebAuthnCredentials))
+	for k, cred := range i.WebAuthnCredentials {
+		descriptors[k] = WebAuthnCredentialDescriptor{Type: "public-key", ID: cred.ID}
+	}
+	return descriptors
+}
+
+// GenerateWebAuthnRegistration returns the options for registering a new
+// security key, and a token that must be sent back with the credential.
+func (i *Instance) GenerateWebAuthnRegistration() (string, *WebAuthnCreationOptions, error) {
+	challenge := pkgcrypto.GenerateRandomBytes(32)
+	token, err := pkgcrypto.EncodeAuthMessage(webAuthnRegistrationMACConfig, i.SessionSecret(), challenge, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	name, _ := i.PublicName()
+	if name == "" {
+		name = i.Domain
+	}
+	options := &WebAuthnCreationOptions{
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		RP: WebAuthnRelyingParty{
+			ID:   i.WebAuthnRPID(),
+			Name: i.TemplateTitle(),
+		},
+		User: WebAuthnUser{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(i.DocID)),
+			Name:        i.Domain,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []WebAuthnCredentialParameter{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            webAuthnTimeout.Milliseconds(),
+		ExcludeCredentials: i.webAuthnDescriptors(),
+		Attestation:        "none",
+	}
+	return string(token), options, nil
+}
+
+// ValidateWebAuthnRegistration checks the credential created by the browser
+// for the registration token, and returns the security key to add to the
+// instance.
+func (i *Instance) ValidateWebAuthnRegistration(token []byte, name string, att *WebAuthnAttestation) (*WebAuthnCredential, error) {
+	challenge, err := pkgcrypto.DecodeAuthMessage(webAuthnRegistrationMACConfig, i.SessionSecret(), token, nil)
+	if err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	if _, err := i.checkWebAuthnClientData(att.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeWebAuthnBase64(att.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	var attestation struct {
+		Fmt      string `codec:"fmt"`
+		AuthData []byte `codec:"authData"`
+	}
+	if err := codec.NewDecoderBytes(raw, &codec.CborHandle{}).Decode(&attestation); err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	authData, err := i.parseWebAuthnAuthData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.Flags&authDataAttested == 0 || authData.PublicKey == nil {
+		return nil, ErrInvalidWebAuthn
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(authData.CredentialID)
+	if rawID, err := decodeWebAuthnBase64(att.ID); err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+		return nil, ErrInvalidWebAuthn
+	}
+	if i.findWebAuthnCredential(id) != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	return &WebAuthnCredential{
+		ID:        id,
+		Name:      name,
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// WebAuthnRequestOptions returns the options for logging in with one of the
+// security keys of the instance, after the passphrase has been checked and
+// the two-factor token generated.
+func (i *Instance) WebAuthnRequestOptions(token []byte) *WebAuthnRequestOptions {
+	return &WebAuthnRequestOptions{
+		Challenge:        base64.RawURLEncoding.EncodeToString(webAuthnChallenge(token)),
+		RPID:             i.WebAuthnRPID(),
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		AllowCredentials: i.webAuthnDescriptors(),
+		UserVerification: "discouraged",
+	}
+}
+
+// webAuthnChallenge derives the challenge for the assertion from the
+// two-factor token, as it includes a random salt.
+func webAuthnChallenge(token []byte) []byte {
+	sum := sha256.Sum256(append([]byte("webauthn:"), token...))
+	return sum[:]
+}
+
+// validateWebAuthnAssertion checks the assertion (serialized in JSON) sent by
+// the browser for the two-factor token. The signature counter of the security
+// key is updated.
+func (i *Instance) validateWebAuthnAssertion(token, data []byte) error {
+	var assertion WebAuthnAssertion
+	if err := json.Unmarshal(data, &assertion); err != nil {
+		return ErrInvalidWebAuthn
+	}
+	cred := i.findWebAuthnCredential(strings.TrimRight(assertion.ID, "="))
+	if cred == nil {
+		return ErrInvalidWebAuthn
+	}
+	clientData, err := i.checkWebAuthnClientData(assertion.Response.ClientDataJSON, "webauthn.get", webAuthnChallenge(token))
+	if err != nil {
+		return err
+	}
+	rawAuthData, err := decodeWebAuthnBase64(assertion.Response.AuthenticatorData)
+	if err != nil {
+		return ErrInvalidWebAuthn
+	}
+	authData, err := i.parseWebAuthnAuthData(rawAuthData)
+	if err != nil {
+		return err
+	}
+	sig, err := decodeWebAuthnBase64(assertion.Response.Signature)
+	if err != nil {
+		return ErrInvalidWebAuthn
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(rawAuthData, clientDataHash[:]...)
+	if err := verifyWebAuthnSignature(cred.PublicKey, signed, sig); err != nil {
+		return err
+	}
+
+	// A counter that does not increase is the sign of a cloned security key
+	if (authData.SignCount != 0 || cred.SignCount != 0) && authData.SignCount <= cred.SignCount {
+		return ErrInvalidWebAuthn
+	}
+	if authData.SignCount != 0 {
+		cred.SignCount = authData.SignCount
+		if err := i.Update(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Instance) findWebAuthnCredential(id string) *WebAuthnCredential {
+	for k := range i.WebAuthnCredentials {
+		if i.WebAuthnCredentials[k].ID == id {
+			return &i.WebAuthnCredentials[k]
+		}
+	}
+	return nil
+}
+
+// checkWebAuthnClientData checks the type, the challenge and the origin of
+// the client data, and returns them decoded from base64url.
+func (i *Instance) checkWebAuthnClientData(encoded, typ string, challenge []byte) ([]byte, error) {
+	raw, err := decodeWebAuthnBase64(encoded)
+	if err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	if clientData.Type != typ {
+		return nil, ErrInvalidWebAuthn
+	}
+	sent, err := decodeWebAuthnBase64(clientData.Challenge)
+	if err != nil || !bytes.Equal(sent, challenge) {
+		return nil, ErrInvalidWebAuthn
+	}
+	if !i.isWebAuthnOrigin(clientData.Origin) {
+		return nil, ErrInvalidWebAuthn
+	}
+	return raw, nil
+}
+
+// isWebAuthnOrigin returns true if the origin is the instance (for the login
+// page) or the settings application (for registering a security key). The
+// other applications must not be able to use the security keys of the user.
+func (i *Instance) isWebAuthnOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != i.Scheme() {
+		return false
+	}
+	return u.Host == i.ContextualDomain() || u.Host == i.SubDomain(consts.SettingsSlug).Host
+}
+
+func (i *Instance) parseWebAuthnAuthData(data []byte) (*webAuthnAuthData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidWebAuthn
+	}
+	authData := &webAuthnAuthData{
+		RPIDHash:  data[0:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(i.WebAuthnRPID()))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return nil, ErrInvalidWebAuthn
+	}
+	if authData.Flags&authDataUserPresent == 0 {
+		return nil, ErrInvalidWebAuthn
+	}
+	if authData.Flags&authDataAttested == 0 {
+		return authData, nil
+	}
+
+	// Attested credential data: AAGUID (16 bytes), length of the credential
+	// ID (2 bytes), credential ID, and the public key in COSE format
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidWebAuthn
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, ErrInvalidWebAuthn
+	}
+	authData.CredentialID = rest[:idLen]
+	var coseKey map[int64]interface{}
+	if err := codec.NewDecoderBytes(rest[idLen:], &codec.CborHandle{}).Decode(&coseKey); err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	publicKey, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	authData.PublicKey, err = x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, ErrInvalidWebAuthn
+	}
+	return authData, nil
+}
+
+// parseCOSEKey converts a public key in the COSE format (RFC 8152) to a Go
+// public key.
+func parseCOSEKey(key map[int64]interface{}) (interface{}, error) {
+	alg, _ := coseInt(key[3])
+	switch alg {
+	case coseAlgES256:
+		x, okX := key[-2].([]byte)
+		y, okY := key[-3].([]byte)
+		if !okX || !okY {
+			return nil, ErrInvalidWebAuthn
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrInvalidWebAuthn
+		}
+		return pub, nil
+	case coseAlgEdDSA:
+		x, ok := key[-2].([]byte)
+		if !ok || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidWebAuthn
+		}
+		return ed25519.PublicKey(x), nil
+	case coseAlgRS256:
+		n, okN := key[-1].([]byte)
+		e, okE := key[-2].([]byte)
+		if !okN || !okE {
+			return nil, ErrInvalidWebAuthn
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	}
+	return nil, ErrInvalidWebAuthn
+}
+
+func coseInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func verifyWebAuthnSignature(publicKey, signed, sig []byte) error {
+	pub, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return ErrInvalidWebAuthn
+	}
+	hash := sha256.Sum256(signed)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+			return ErrInvalidWebAuthn
+		}
+		if !ecdsa.Verify(pub, hash[:], esig.R, esig.S) {
+			return ErrInvalidWebAuthn
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrInvalidWebAuthn
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig); err != nil {
+			return ErrInvalidWebAuthn
+		}
+	default:
+		return ErrInvalidWebAuthn
+	}
+	return nil
+}
+
+func decodeWebAuthnBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -93,6 +93,17 @@ type (
 		FinishedAt  time.Time   `json:"finished_at"`
 		Error       string      `json:"error,omitempty"`
 		ForwardLogs bool        `json:"forward_logs,omitempty"`
+		// Usage is only filled for the jobs executed in a sandbox
+		Usage *ResourceUsage `json:"resource_usage,omitempty"`
+	}
+
+	// ResourceUsage contains the resources used by the last execution of a
+	// job in a sandbox.
+	ResourceUsage struct {
+		CPUTime     time.Duration `json:"cpu_time"`
+		MemoryPeak  uint64        `json:"memory_peak"`
+		PidsPeak    uint64        `json:"pids_peak,omitempty"`
+		DeniedHosts []string      `json:"denied_hosts,omitempty"`
 	}
 
 	// JobRequest struct is used to represent a new job request.
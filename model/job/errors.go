@@ -12,6 +12,8 @@ var (
 	ErrClosed = errors.New("jobs: closed")
 	// ErrNotFoundJob is used when the job could not be found
 	ErrNotFoundJob = errors.New("jobs: not found")
+	// ErrNotFoundReport is used when the report of a job could not be found
+	ErrNotFoundReport = errors.New("jobs: report not found")
 	// ErrQueueClosed is used to indicate the queue is closed
 	ErrQueueClosed = errors.New("jobs: queue is closed")
 	// ErrUnknownWorker the asked worker does not exist
@@ -159,9 +159,9 @@ func TestRedisSchedulerWithTimeTriggers(t *testing.T) {
 			trigger.Infos().Metadata = nil
 			assert.Equal(t, tin.Infos(), trigger.Infos())
 		default:
-			// Just ignore the @event trigger for generating thumbnails
+			// Just ignore the @event triggers added on the instance creation
 			infos := trigger.Infos()
-			if infos.Type != "@event" || infos.WorkerType != "thumbnail" {
+			if infos.Type != "@event" || (infos.WorkerType != "thumbnail" && infos.WorkerType != "contacts-dedup") {
 				t.Fatalf("unknown trigger ID %s", trigger.Infos().TID)
 			}
 		}
@@ -0,0 +1,189 @@
+package job
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// MaxReportsPerSlug is the number of reports kept for a konnector: the
+	// older ones are deleted when a new report is saved.
+	MaxReportsPerSlug = 100
+	// ReportsMaxAge is the duration after which a report is deleted.
+	ReportsMaxAge = 30 * 24 * time.Hour
+	// MaxReportEntries is the maximal number of log lines, HTTP calls, or
+	// files kept in a report.
+	MaxReportEntries = 1000
+)
+
+// Report is a structured report of the execution of a konnector, saved when
+// its job has finished. Its identifier is the identifier of the job.
+type Report struct {
+	DocID        string           `json:"_id,omitempty"`
+	DocRev       string           `json:"_rev,omitempty"`
+	WorkerType   string           `json:"worker"`
+	Slug         string           `json:"slug"`
+	TriggerID    string           `json:"trigger_id,omitempty"`
+	AccountID    string           `json:"account_id,omitempty"`
+	Manual       bool             `json:"manual_execution,omitempty"`
+	State        State            `json:"state"`
+	Error        string           `json:"error,omitempty"`
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   time.Time        `json:"finished_at"`
+	Duration     time.Duration    `json:"duration"`
+	Logs         []ReportLog      `json:"logs"`
+	HTTPCalls    []ReportHTTPCall `json:"http_calls"`
+	FilesCreated []string         `json:"files_created"`
+	FilesUpdated []string         `json:"files_updated"`
+	BillsSaved   int              `json:"bills_saved"`
+	Truncated    bool             `json:"truncated,omitempty"`
+}
+
+// ReportLog is a log line of a konnector, with its level.
+type ReportLog struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// ReportHTTPCall is an HTTP request made by a konnector.
+type ReportHTTPCall struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Status   int           `json:"status,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// ID implements the couchdb.Doc interface
+func (r *Report) ID() string { return r.DocID }
+
+// Rev implements the couchdb.Doc interface
+func (r *Report) Rev() string { return r.DocRev }
+
+// DocType implements the couchdb.Doc interface
+func (r *Report) DocType() string { return consts.JobReports }
+
+// Clone implements the couchdb.Doc interface
+func (r *Report) Clone() couchdb.Doc {
+	cloned := *r
+	cloned.Logs = make([]ReportLog, len(r.Logs))
+	copy(cloned.Logs, r.Logs)
+	cloned.HTTPCalls = make([]ReportHTTPCall, len(r.HTTPCalls))
+	copy(cloned.HTTPCalls, r.HTTPCalls)
+	cloned.FilesCreated = make([]string, len(r.FilesCreated))
+	copy(cloned.FilesCreated, r.FilesCreated)
+	cloned.FilesUpdated = make([]string, len(r.FilesUpdated))
+	copy(cloned.FilesUpdated, r.FilesUpdated)
+	return &cloned
+}
+
+// SetID implements the couchdb.Doc interface
+func (r *Report) SetID(id string) { r.DocID = id }
+
+// SetRev implements the couchdb.Doc interface
+func (r *Report) SetRev(rev string) { r.DocRev = rev }
+
+// Fetch implements the permission.Fetcher interface
+func (r *Report) Fetch(field string) []string {
+	switch field {
+	case "worker":
+		return []string{r.WorkerType}
+	case "slug":
+		return []string{r.Slug}
+	case "trigger_id":
+		return []string{r.TriggerID}
+	}
+	return nil
+}
+
+// Save persists the report, and deletes the old reports of the same
+// konnector.
+func (r *Report) Save(db prefixer.Prefixer) error {
+	if err := couchdb.CreateNamedDocWithDB(db, r); err != nil {
+		return err
+	}
+	return rotateReports(db, r.Slug)
+}
+
+// GetReport returns the report of the job with the given identifier.
+func GetReport(db prefixer.Prefixer, jobID string) (*Report, error) {
+	var report Report
+	if err := couchdb.GetDoc(db, consts.JobReports, jobID, &report); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrNotFoundReport
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// GetReports returns the reports of the jobs launched by the given trigger,
+// from the most recent to the oldest.
+func GetReports(db prefixer.Prefixer, triggerID string, limit int) ([]*Report, error) {
+	if limit <= 0 || limit > 50 {
+		limit = 50
+	}
+	var reports []*Report
+	req := &couchdb.FindRequest{
+		UseIndex: "by-trigger-id",
+		Selector: mango.Equal("trigger_id", triggerID),
+		Sort: mango.SortBy{
+			{Field: "trigger_id", Direction: mango.Desc},
+			{Field: "started_at", Direction: mango.Desc},
+		},
+		Limit: limit,
+	}
+	err := couchdb.FindDocs(db, consts.JobReports, req, &reports)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// rotateReports deletes the reports of a konnector that are too old, or that
+// are over the MaxReportsPerSlug limit.
+func rotateReports(db prefixer.Prefixer, slug string) error {
+	sort := mango.SortBy{
+		{Field: "slug", Direction: mango.Desc},
+		{Field: "started_at", Direction: mango.Desc},
+	}
+	var over []*Report
+	req := &couchdb.FindRequest{
+		UseIndex: "by-slug",
+		Selector: mango.Equal("slug", slug),
+		Sort:     sort,
+		Skip:     MaxReportsPerSlug,
+		Limit:    MaxReportsPerSlug,
+	}
+	if err := couchdb.FindDocs(db, consts.JobReports, req, &over); err != nil {
+		return err
+	}
+	var old []*Report
+	req = &couchdb.FindRequest{
+		UseIndex: "by-slug",
+		Selector: mango.And(
+			mango.Equal("slug", slug),
+			mango.Lt("started_at", time.Now().Add(-ReportsMaxAge)),
+		),
+		Sort:  sort,
+		Limit: MaxReportsPerSlug,
+	}
+	if err := couchdb.FindDocs(db, consts.JobReports, req, &old); err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{})
+	docs := make([]couchdb.Doc, 0, len(over)+len(old))
+	for _, r := range append(over, old...) {
+		if _, ok := seen[r.ID()]; !ok {
+			seen[r.ID()] = struct{}{}
+			docs = append(docs, r)
+		}
+	}
+	return couchdb.BulkDeleteDocs(db, consts.JobReports, docs)
+}
@@ -158,6 +158,12 @@ func (c *WorkerContext) SetNoRetry() {
 	c.noRetry = true
 }
 
+// SetResourceUsage records the resources used by the execution of the job,
+// that will be saved with the job.
+func (c *WorkerContext) SetResourceUsage(usage *ResourceUsage) {
+	c.job.Usage = usage
+}
+
 // NoRetry returns the no-retry flag.
 func (c *WorkerContext) NoRetry() bool {
 	return c.noRetry
@@ -179,6 +185,16 @@ func (c *WorkerContext) ID() string {
 	return c.id
 }
 
+// JobID returns the identifier of the job.
+func (c *WorkerContext) JobID() string {
+	return c.job.ID()
+}
+
+// WorkerType returns the type of the worker executing the job.
+func (c *WorkerContext) WorkerType() string {
+	return c.job.WorkerType
+}
+
 // Logger return the logger associated with the worker context.
 func (c *WorkerContext) Logger() *logrus.Entry {
 	return c.log
@@ -447,6 +447,11 @@ func writeInstanceDoc(in *instance.Instance, name string, now time.Time, tw *tar
 	clone.SessSecret = nil
 	clone.OAuthSecret = nil
 	clone.CLISecret = nil
+	clone.TOTPSecret = nil
+	clone.WebAuthnCredentials = nil
+	clone.RecoveryCodes = nil
+	clone.AppPasswords = nil
+	clone.OIDCPrivateKey = nil
 	clone.SwiftLayout = 0
 	clone.IndexViewsVersion = 0
 	return writeDoc("", name, clone, now, tw)
@@ -112,7 +112,8 @@ func (im *importer) importZip(zr *zip.Reader) error {
 			// We don't want to import the sessions from another instance
 			continue
 		case consts.BitwardenCiphers, consts.BitwardenFolders, consts.BitwardenProfiles,
-			consts.BitwardenOrganizations, consts.BitwardenContacts:
+			consts.BitwardenOrganizations, consts.BitwardenContacts, consts.BitwardenSends,
+			consts.BitwardenEmergencyAccesses:
 			// Bitwarden documents are encypted E2E, so they cannot be imported
 			// as raw documents
 			continue
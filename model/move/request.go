@@ -189,7 +189,7 @@ func checkSourceToken(inst *instance.Instance, source RequestCredentials) error
 	if c.ClientID != source.ClientID {
 		return permission.ErrInvalidToken
 	}
-	if c.ClientSecret != source.ClientSecret {
+	if !c.CheckSecret(source.ClientSecret) {
 		return permission.ErrInvalidToken
 	}
 	return nil
@@ -0,0 +1,78 @@
+package center
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// matrixChannel posts the notifications as messages in a Matrix room. By
+// default, the messages are sent by the bot account of the configuration, but
+// the user can also give the homeserver and the access token of their own
+// account.
+type matrixChannel struct{}
+
+func init() {
+	RegisterChannel(&matrixChannel{})
+}
+
+func (m *matrixChannel) Name() string { return "matrix" }
+
+func (m *matrixChannel) Validate(conf *config.NotificationChannel, settings *notification.ChannelSettings) error {
+	if !strings.HasPrefix(settings.RoomID, "!") || !strings.Contains(settings.RoomID, ":") {
+		return notification.ErrInvalidChannelSettings
+	}
+	homeserver, token, chosenByUser := matrixAccount(conf, settings)
+	if homeserver == "" || token == "" {
+		return notification.ErrInvalidChannelSettings
+	}
+	if chosenByUser && !isValidUserURL(homeserver) {
+		return notification.ErrInvalidChannelSettings
+	}
+	return nil
+}
+
+func (m *matrixChannel) Send(inst *instance.Instance, conf *config.NotificationChannel, settings *notification.ChannelSettings, msg *ChannelMessage) error {
+	homeserver, token, chosenByUser := matrixAccount(conf, settings)
+	formatted := "<strong>" + html.EscapeString(msg.Title) + "</strong>"
+	if msg.Message != "" {
+		formatted += "<br>" + strings.ReplaceAll(html.EscapeString(msg.Message), "\n", "<br>")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           channelText(msg),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The transaction ID makes the retries of the job idempotent
+	u := strings.TrimSuffix(homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(settings.RoomID) + "/send/m.room.message/" +
+		url.PathEscape(inst.Domain+"-"+msg.NotificationID)
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doChannelRequest(channelClient(chosenByUser), req)
+}
+
+// matrixAccount returns the homeserver and the access token to use for
+// sending the messages, and true if they have been given by the user.
+func matrixAccount(conf *config.NotificationChannel, settings *notification.ChannelSettings) (string, string, bool) {
+	if settings.URL != "" && settings.Token != "" {
+		return settings.URL, settings.Token, true
+	}
+	return conf.URL, conf.Token, false
+}
@@ -0,0 +1,75 @@
+package center
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// ntfyTopic is the format of the topics accepted by ntfy.
+var ntfyTopic = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// ntfyChannel publishes the notifications on a topic of a ntfy server, that
+// the user can follow with the ntfy apps.
+type ntfyChannel struct{}
+
+func init() {
+	RegisterChannel(&ntfyChannel{})
+}
+
+func (n *ntfyChannel) Name() string { return "ntfy" }
+
+func (n *ntfyChannel) Validate(conf *config.NotificationChannel, settings *notification.ChannelSettings) error {
+	if !ntfyTopic.MatchString(settings.Topic) {
+		return notification.ErrInvalidChannelSettings
+	}
+	server, _, chosenByUser := ntfyServer(conf, settings)
+	if chosenByUser {
+		if !isValidUserURL(server) {
+			return notification.ErrInvalidChannelSettings
+		}
+	} else if u, err := url.Parse(server); err != nil || u.Host == "" {
+		return notification.ErrInvalidChannelSettings
+	}
+	return nil
+}
+
+func (n *ntfyChannel) Send(inst *instance.Instance, conf *config.NotificationChannel, settings *notification.ChannelSettings, msg *ChannelMessage) error {
+	server, token, chosenByUser := ntfyServer(conf, settings)
+	u := strings.TrimSuffix(server, "/") + "/" + settings.Topic
+	body := msg.Message
+	if body == "" {
+		body = msg.Title
+	}
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if msg.Title != "" && msg.Message != "" {
+		// The non-ASCII characters are encoded as described in RFC 2047
+		req.Header.Set("Title", mime.BEncoding.Encode("utf-8", msg.Title))
+	}
+	if msg.Priority == "high" {
+		req.Header.Set("Priority", "high")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doChannelRequest(channelClient(chosenByUser), req)
+}
+
+// ntfyServer returns the URL of the server and the access token to use for
+// publishing the messages, and true if they have been given by the user.
+func ntfyServer(conf *config.NotificationChannel, settings *notification.ChannelSettings) (string, string, bool) {
+	if settings.URL != "" {
+		return settings.URL, settings.Token, true
+	}
+	return conf.URL, conf.Token, false
+}
@@ -0,0 +1,67 @@
+package center
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// webhookSignatureHeader is the HTTP header with the signature of the payload,
+// when the user has given a secret.
+const webhookSignatureHeader = "X-Cozy-Signature"
+
+// webhookChannel sends the notifications as JSON to an URL given by the user.
+type webhookChannel struct{}
+
+// webhookPayload is the JSON body sent to the webhook.
+type webhookPayload struct {
+	Domain string `json:"domain"`
+	*ChannelMessage
+}
+
+func init() {
+	RegisterChannel(&webhookChannel{})
+}
+
+func (w *webhookChannel) Name() string { return "webhook" }
+
+func (w *webhookChannel) Validate(conf *config.NotificationChannel, settings *notification.ChannelSettings) error {
+	if !isValidUserURL(settings.URL) {
+		return notification.ErrInvalidChannelSettings
+	}
+	return nil
+}
+
+func (w *webhookChannel) Send(inst *instance.Instance, conf *config.NotificationChannel, settings *notification.ChannelSettings, msg *ChannelMessage) error {
+	body, err := json.Marshal(&webhookPayload{
+		Domain:         inst.Domain,
+		ChannelMessage: msg,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, settings.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if settings.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhook(settings.Secret, body))
+	}
+	return doChannelRequest(userChannelsClient, req)
+}
+
+// signWebhook returns the HMAC-SHA256 of the body with the secret, in the
+// sha256=<hex> format used by the signature header.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
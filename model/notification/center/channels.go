@@ -0,0 +1,213 @@
+package center
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+)
+
+// ChannelWorker is the type of the worker that sends the notifications to the
+// channels like webhook, matrix or ntfy.
+const ChannelWorker = "notifications-channel"
+
+// ChannelMessage is a notification to send to a channel.
+type ChannelMessage struct {
+	Channel        string                 `json:"channel"`
+	NotificationID string                 `json:"notification_id"`
+	Source         string                 `json:"source"`
+	Slug           string                 `json:"slug,omitempty"`
+	Category       string                 `json:"category,omitempty"`
+	Title          string                 `json:"title,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	Priority       string                 `json:"priority,omitempty"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
+
+// Channel is the interface for the plugins that send the notifications to an
+// external service, like a chat tool. The channels are enabled per context in
+// the configuration file, and then by the users in their preferences.
+type Channel interface {
+	// Name is the name of the channel, used in the configuration file, in the
+	// preferences of the user, and in the preferred channels of the
+	// notifications.
+	Name() string
+	// Validate checks the settings of the user for this channel.
+	Validate(conf *config.NotificationChannel, settings *notification.ChannelSettings) error
+	// Send sends the notification to the external service.
+	Send(inst *instance.Instance, conf *config.NotificationChannel, settings *notification.ChannelSettings, msg *ChannelMessage) error
+}
+
+var channels = make(map[string]Channel)
+
+// RegisterChannel adds a channel for the notifications. It is called in the
+// init functions of the channels.
+func RegisterChannel(ch Channel) {
+	channels[ch.Name()] = ch
+}
+
+// channelsClient is the HTTP client used by the channels for the servers of
+// the configuration.
+var channelsClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// userChannelsClient is the HTTP client used by the channels for the URLs
+// chosen by the users: it refuses to connect to the private addresses and it
+// doesn't follow the redirections.
+var userChannelsClient = safehttp.NewClient(10*time.Second, false)
+
+// isValidUserURL returns true if the URL given by a user for a channel can be
+// used: it must be an https URL (http is allowed only for development).
+func isValidUserURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && build.IsDevRelease())
+}
+
+// channelClient returns the HTTP client to use for a server of the
+// configuration, or for an URL chosen by the user.
+func channelClient(chosenByUser bool) *http.Client {
+	if chosenByUser {
+		return userChannelsClient
+	}
+	return channelsClient
+}
+
+// channelConfig returns the configuration of a channel for the given
+// context, or nil if the channel is not available in this context.
+func channelConfig(contextName, name string) *config.NotificationChannel {
+	configuration := config.GetConfig().Notifications.Channels
+	if ctx, ok := configuration[contextName]; ok {
+		if c, ok := ctx[name]; ok {
+			return &c
+		}
+		return nil
+	}
+	if ctx, ok := configuration[config.DefaultInstanceContext]; ok {
+		if c, ok := ctx[name]; ok {
+			return &c
+		}
+	}
+	return nil
+}
+
+// ValidateChannels checks the settings of the channels in the preferences of
+// the user. The disabled channels are not checked, but they still must be
+// known by the stack.
+func ValidateChannels(inst *instance.Instance, prefs *notification.Preferences) error {
+	for name, settings := range prefs.Channels {
+		ch, ok := channels[name]
+		if !ok || settings == nil {
+			return notification.ErrUnknownChannel
+		}
+		if !settings.Enabled {
+			continue
+		}
+		conf := channelConfig(inst.ContextName, name)
+		if conf == nil {
+			return notification.ErrChannelNotAvailable
+		}
+		if err := ch.Validate(conf, settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendToChannels pushes a job for each channel enabled by the user, and
+// returns the names of those channels.
+func sendToChannels(inst *instance.Instance, prefs *notification.Preferences, n *notification.Notification, at string) map[string]bool {
+	sent := make(map[string]bool)
+	log := inst.Logger().WithField("nspace", "notifications")
+	for _, name := range prefs.EnabledChannels() {
+		if _, ok := channels[name]; !ok {
+			continue
+		}
+		if channelConfig(inst.ContextName, name) == nil {
+			continue
+		}
+		msg, err := job.NewMessage(&ChannelMessage{
+			Channel:        name,
+			NotificationID: n.ID(),
+			Source:         n.Source(),
+			Slug:           preferencesSlug(n),
+			Category:       n.Category,
+			Title:          n.Title,
+			Message:        n.Message,
+			Priority:       n.Priority,
+			Data:           n.Data,
+			CreatedAt:      n.CreatedAt,
+		})
+		if err == nil {
+			err = pushJobOrTrigger(inst, msg, ChannelWorker, at)
+		}
+		if err != nil {
+			log.Errorf("Error while sending to the %s channel: %s", name, err)
+			continue
+		}
+		sent[name] = true
+	}
+	return sent
+}
+
+// SendToChannel sends the notification with the channel of the message, and
+// the settings of the user for this channel.
+func SendToChannel(inst *instance.Instance, msg *ChannelMessage) error {
+	ch, ok := channels[msg.Channel]
+	if !ok {
+		return notification.ErrUnknownChannel
+	}
+	conf := channelConfig(inst.ContextName, msg.Channel)
+	if conf == nil {
+		return notification.ErrChannelNotAvailable
+	}
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	settings, ok := prefs.Channels[msg.Channel]
+	if !ok || settings == nil || !settings.Enabled {
+		// The user has disabled the channel since the notification was created
+		return nil
+	}
+	return ch.Send(inst, conf, settings, msg)
+}
+
+// doChannelRequest sends the request of a channel with the given client, and
+// returns an error if the response has not a 2xx status code. The body of the
+// response is not included in the error, as it may come from a server chosen
+// by the user.
+func doChannelRequest(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// channelText returns the notification as a text with the title on the first
+// line.
+func channelText(msg *ChannelMessage) string {
+	if msg.Message == "" {
+		return msg.Title
+	}
+	if msg.Title == "" {
+		return msg.Message
+	}
+	return msg.Title + "\n" + msg.Message
+}
@@ -0,0 +1,159 @@
+package center
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func fakeService(t *testing.T) (*httptest.Server, *receivedRequest) {
+	received := &receivedRequest{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		received.method = r.Method
+		received.path = r.URL.EscapedPath()
+		received.header = r.Header
+		received.body = body
+		w.WriteHeader(http.StatusOK)
+	}))
+	return ts, received
+}
+
+// allowLocalURLs lets the channels send the requests for the URLs chosen by
+// the user to the fake services, on http://127.0.0.1.
+func allowLocalURLs() func() {
+	mode, client := build.BuildMode, userChannelsClient
+	build.BuildMode = build.ModeDev
+	userChannelsClient = &http.Client{}
+	return func() {
+		build.BuildMode, userChannelsClient = mode, client
+	}
+}
+
+func TestChannels(t *testing.T) {
+	ts, received := fakeService(t)
+	defer ts.Close()
+	defer allowLocalURLs()()
+
+	inst := &instance.Instance{Domain: "alice.cozy.localhost"}
+	conf := &config.NotificationChannel{URL: ts.URL, Token: "bot-token"}
+	msg := &ChannelMessage{
+		NotificationID: "123",
+		Source:         "cozy/app/banks/balance-lower",
+		Slug:           "banks",
+		Category:       "balance-lower",
+		Title:          "Balance lower than 100 €",
+		Message:        "Your balance is 42 €",
+		Priority:       "high",
+	}
+
+	webhook := channels["webhook"]
+	require.NotNil(t, webhook)
+	settings := &notification.ChannelSettings{Enabled: true}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, webhook.Validate(conf, settings))
+	settings.URL = ts.URL + "/hook"
+	settings.Secret = "s3cr3t"
+	assert.NoError(t, webhook.Validate(conf, settings))
+	assert.NoError(t, webhook.Send(inst, conf, settings, msg))
+	assert.Equal(t, http.MethodPost, received.method)
+	assert.Equal(t, "/hook", received.path)
+	assert.Equal(t, signWebhook("s3cr3t", received.body), received.header.Get(webhookSignatureHeader))
+	var payload map[string]interface{}
+	assert.NoError(t, json.Unmarshal(received.body, &payload))
+	assert.Equal(t, "alice.cozy.localhost", payload["domain"])
+	assert.Equal(t, "Balance lower than 100 €", payload["title"])
+
+	matrix := channels["matrix"]
+	require.NotNil(t, matrix)
+	settings = &notification.ChannelSettings{Enabled: true, RoomID: "#cozy:matrix.org"}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, matrix.Validate(conf, settings))
+	settings.RoomID = "!abcdef:matrix.org"
+	assert.NoError(t, matrix.Validate(conf, settings))
+	assert.Equal(t, notification.ErrInvalidChannelSettings, matrix.Validate(&config.NotificationChannel{}, settings))
+	assert.NoError(t, matrix.Send(inst, conf, settings, msg))
+	assert.Equal(t, http.MethodPut, received.method)
+	assert.Equal(t, "/_matrix/client/v3/rooms/%21abcdef:matrix.org/send/m.room.message/alice.cozy.localhost-123", received.path)
+	assert.Equal(t, "Bearer bot-token", received.header.Get("Authorization"))
+	payload = nil
+	assert.NoError(t, json.Unmarshal(received.body, &payload))
+	assert.Equal(t, "m.text", payload["msgtype"])
+	assert.Equal(t, "Balance lower than 100 €\nYour balance is 42 €", payload["body"])
+
+	ntfy := channels["ntfy"]
+	require.NotNil(t, ntfy)
+	settings = &notification.ChannelSettings{Enabled: true, Topic: "not a topic"}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, ntfy.Validate(conf, settings))
+	settings.Topic = "alice-cozy"
+	assert.NoError(t, ntfy.Validate(conf, settings))
+	assert.NoError(t, ntfy.Send(inst, conf, settings, msg))
+	assert.Equal(t, http.MethodPost, received.method)
+	assert.Equal(t, "/alice-cozy", received.path)
+	assert.Equal(t, "high", received.header.Get("Priority"))
+	assert.Equal(t, "=?utf-8?b?QmFsYW5jZSBsb3dlciB0aGFuIDEwMCDigqw=?=", received.header.Get("Title"))
+	assert.Equal(t, "Your balance is 42 €", string(received.body))
+}
+
+func TestChannelsStatusCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("private details"))
+	}))
+	defer ts.Close()
+	defer allowLocalURLs()()
+
+	inst := &instance.Instance{Domain: "alice.cozy.localhost"}
+	settings := &notification.ChannelSettings{Enabled: true, URL: ts.URL}
+	err := channels["webhook"].Send(inst, &config.NotificationChannel{}, settings, &ChannelMessage{Title: "foo"})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "private details")
+}
+
+func TestChannelsUserURLs(t *testing.T) {
+	ts, received := fakeService(t)
+	defer ts.Close()
+	mode := build.BuildMode
+	build.BuildMode = build.ModeProd
+	defer func() { build.BuildMode = mode }()
+
+	inst := &instance.Instance{Domain: "alice.cozy.localhost"}
+	conf := &config.NotificationChannel{URL: ts.URL, Token: "bot-token"}
+	settings := &notification.ChannelSettings{Enabled: true, URL: "http://hooks.example.org/cozy"}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, channels["webhook"].Validate(conf, settings))
+	settings.URL = "https://hooks.example.org/cozy"
+	assert.NoError(t, channels["webhook"].Validate(conf, settings))
+
+	settings = &notification.ChannelSettings{Enabled: true, Topic: "alice-cozy", URL: "http://ntfy.example.org"}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, channels["ntfy"].Validate(conf, settings))
+	settings = &notification.ChannelSettings{Enabled: true, RoomID: "!abcdef:matrix.org", URL: "http://matrix.example.org", Token: "user-token"}
+	assert.Equal(t, notification.ErrInvalidChannelSettings, channels["matrix"].Validate(conf, settings))
+
+	// The URLs chosen by the user can't target a private address
+	settings = &notification.ChannelSettings{Enabled: true, Topic: "alice-cozy", URL: ts.URL}
+	err := channels["ntfy"].Send(inst, conf, settings, &ChannelMessage{Title: "foo"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), safehttp.ErrForbiddenAddress.Error())
+	assert.Empty(t, received.method)
+
+	// But the servers of the configuration can
+	settings.URL = ""
+	assert.NoError(t, channels["ntfy"].Send(inst, conf, settings, &ChannelMessage{Title: "foo"}))
+	assert.Equal(t, "/alice-cozy", received.path)
+}
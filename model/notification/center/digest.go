@@ -0,0 +1,265 @@
+package center
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/mail"
+)
+
+// DigestWorker is the type of the worker that sends the digest mails.
+const DigestWorker = "notifications-digest"
+
+// digestEntry is a notification waiting to be sent in the next digest mail.
+type digestEntry struct {
+	DocID          string    `json:"_id,omitempty"`
+	DocRev         string    `json:"_rev,omitempty"`
+	NotificationID string    `json:"notification_id"`
+	Slug           string    `json:"slug"`
+	Category       string    `json:"category"`
+	Title          string    `json:"title,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (e *digestEntry) ID() string         { return e.DocID }
+func (e *digestEntry) Rev() string        { return e.DocRev }
+func (e *digestEntry) DocType() string    { return consts.NotificationsDigest }
+func (e *digestEntry) SetID(id string)    { e.DocID = id }
+func (e *digestEntry) SetRev(rev string)  { e.DocRev = rev }
+func (e *digestEntry) Clone() couchdb.Doc { cloned := *e; return &cloned }
+
+// digestScheduleID is the identifier of the document with the date of the
+// next digest mail, in the same database as the digest entries. It is not
+// kept in the preferences: two notifications that arrive at the same time
+// must not schedule two digests, and the creation of this document fails
+// with a conflict for the second one.
+const digestScheduleID = "next-digest"
+
+// digestSchedule is the document with the date of the next digest mail, when
+// some notifications are waiting for it.
+type digestSchedule struct {
+	DocID  string    `json:"_id,omitempty"`
+	DocRev string    `json:"_rev,omitempty"`
+	NextAt time.Time `json:"next_at"`
+}
+
+func (d *digestSchedule) ID() string         { return d.DocID }
+func (d *digestSchedule) Rev() string        { return d.DocRev }
+func (d *digestSchedule) DocType() string    { return consts.NotificationsDigest }
+func (d *digestSchedule) SetID(id string)    { d.DocID = id }
+func (d *digestSchedule) SetRev(rev string)  { d.DocRev = rev }
+func (d *digestSchedule) Clone() couchdb.Doc { cloned := *d; return &cloned }
+
+// digestGroup is the list of the notifications of an app in the digest mail.
+type digestGroup struct {
+	Name    string
+	Entries []*digestEntry
+}
+
+var digestHTML = template.Must(template.New("digest").Parse(`<p>{{.Intro}}</p>
+{{range .Groups}}<h3>{{.Name}}</h3>
+<ul>
+{{range .Entries}}<li><strong>{{.Title}}</strong>{{if .Message}}<br>{{.Message}}{{end}}</li>
+{{end}}</ul>
+{{end}}`))
+
+// addToDigest keeps the notification for the next digest mail, and makes
+// sure that the digest is scheduled.
+func addToDigest(inst *instance.Instance, prefs *notification.Preferences, p *notification.Properties, n *notification.Notification) error {
+	title := n.Title
+	if title == "" && p != nil {
+		title = p.Description
+	}
+	entry := &digestEntry{
+		NotificationID: n.ID(),
+		Slug:           preferencesSlug(n),
+		Category:       n.Category,
+		Title:          title,
+		Message:        n.Message,
+		CreatedAt:      n.CreatedAt,
+	}
+	if err := couchdb.CreateDoc(inst, entry); err != nil {
+		return err
+	}
+	return scheduleDigest(inst, prefs)
+}
+
+// scheduleDigest adds a trigger for the next digest mail, unless there is
+// already one.
+func scheduleDigest(inst *instance.Instance, prefs *notification.Preferences) error {
+	now := time.Now()
+	next := prefs.NextDigestAfter(now, prefs.Location(instanceTimezone(inst)))
+	schedule := &digestSchedule{DocID: digestScheduleID, NextAt: next.UTC()}
+	old := &digestSchedule{}
+	err := couchdb.GetDoc(inst, consts.NotificationsDigest, digestScheduleID, old)
+	switch {
+	case err == nil:
+		if old.NextAt.After(now) {
+			return nil
+		}
+		// The previous digest has not been sent, the worker may have failed
+		schedule.SetRev(old.Rev())
+		err = couchdb.UpdateDoc(inst, schedule)
+	case couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err):
+		err = couchdb.CreateNamedDocWithDB(inst, schedule)
+	}
+	if err != nil {
+		if couchdb.IsConflictError(err) {
+			// The digest has been scheduled for another notification
+			return nil
+		}
+		return err
+	}
+
+	msg, err := job.NewMessage(struct{}{})
+	if err == nil {
+		err = pushJobOrTrigger(inst, msg, DigestWorker, next.Format(time.RFC3339))
+	}
+	if err != nil {
+		_ = couchdb.DeleteDoc(inst, schedule)
+		return err
+	}
+	return nil
+}
+
+// SendDigest sends a mail with the notifications that are waiting for the
+// digest, grouped by app.
+func SendDigest(inst *instance.Instance) error {
+	entries, err := listDigestEntries(inst)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		if err := sendDigestMail(inst, entries); err != nil {
+			return err
+		}
+		docs := make([]couchdb.Doc, len(entries))
+		for i := range entries {
+			docs[i] = entries[i]
+		}
+		if err := couchdb.BulkDeleteDocs(inst, consts.NotificationsDigest, docs); err != nil {
+			return err
+		}
+	}
+
+	schedule := &digestSchedule{}
+	err = couchdb.GetDoc(inst, consts.NotificationsDigest, digestScheduleID, schedule)
+	if err == nil {
+		err = couchdb.DeleteDoc(inst, schedule)
+	}
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+
+	// Some notifications may have been added while the mail was sent
+	remaining, err := listDigestEntries(inst)
+	if err != nil || len(remaining) == 0 {
+		return err
+	}
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	return scheduleDigest(inst, prefs)
+}
+
+func listDigestEntries(inst *instance.Instance) ([]*digestEntry, error) {
+	var docs []*digestEntry
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.NotificationsDigest, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := docs[:0]
+	for _, e := range docs {
+		if !strings.HasPrefix(e.ID(), "_design") && e.ID() != digestScheduleID {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func sendDigestMail(inst *instance.Instance, entries []*digestEntry) error {
+	var groups []*digestGroup
+	bySlug := make(map[string]*digestGroup)
+	for _, e := range entries {
+		g, ok := bySlug[e.Slug]
+		if !ok {
+			name := e.Slug
+			if name == notification.StackSlug || name == "" {
+				name = "Cozy"
+			}
+			g = &digestGroup{Name: name}
+			bySlug[e.Slug] = g
+			groups = append(groups, g)
+		}
+		g.Entries = append(g.Entries, e)
+	}
+
+	intro := inst.Translate("Notifications Digest Intro", len(entries))
+	var text strings.Builder
+	text.WriteString(intro + "\n")
+	for _, g := range groups {
+		text.WriteString("\n" + g.Name + "\n")
+		for _, e := range g.Entries {
+			text.WriteString("- " + e.Title + "\n")
+			if e.Message != "" {
+				text.WriteString("  " + e.Message + "\n")
+			}
+		}
+	}
+	var html bytes.Buffer
+	err := digestHTML.Execute(&html, struct {
+		Intro  string
+		Groups []*digestGroup
+	}{intro, groups})
+	if err != nil {
+		return err
+	}
+
+	msg, err := job.NewMessage(&mail.Options{
+		Mode:    mail.ModeFromStack,
+		Subject: inst.Translate("Notifications Digest Subject", len(entries)),
+		Parts: []*mail.Part{
+			{Body: text.String(), Type: "text/plain"},
+			{Body: html.String(), Type: "text/html"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "sendmail",
+		Message:    msg,
+	})
+	return err
+}
+
+// preferencesSlug returns the slug used for the notification in the
+// preferences of the user.
+func preferencesSlug(n *notification.Notification) string {
+	if n.Originator == "stack" {
+		return notification.StackSlug
+	}
+	return n.Slug
+}
+
+// instanceTimezone returns the timezone from the settings of the instance.
+func instanceTimezone(inst *instance.Instance) string {
+	settings, err := inst.SettingsDocument()
+	if err != nil {
+		return ""
+	}
+	tz, _ := settings.M["tz"].(string)
+	return tz
+}
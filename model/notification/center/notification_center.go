@@ -206,8 +206,31 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 		return nil
 	}
 
-	var errm error
 	log := inst.Logger().WithField("nspace", "notifications")
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	switch prefs.ModeFor(preferencesSlug(n), n.Category) {
+	case notification.ModeMuted:
+		log.Debugf("Notification %s was not sent (muted)", n.ID())
+		return nil
+	case notification.ModeDigest:
+		return addToDigest(inst, prefs, p, n)
+	}
+	if at == "" {
+		loc := prefs.Location(instanceTimezone(inst))
+		if end, ok := prefs.QuietHoursEnd(time.Now(), loc); ok {
+			log.Debugf("Notification %s delayed to the end of the quiet hours", n.ID())
+			at = end.Format(time.RFC3339)
+		}
+	}
+
+	// The notification is also sent to the channels enabled by the user, like
+	// matrix or ntfy, in addition to the preferred channels
+	sentChannels := sendToChannels(inst, prefs, n, at)
+
+	var errm error
 	for _, channel := range preferredChannels {
 		switch channel {
 		case "mobile":
@@ -235,7 +258,13 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 			log.Errorf("Error while sending sms: %s", err)
 			errm = multierror.Append(errm, err)
 		default:
+			if sentChannels[channel] {
+				return nil
+			}
 			err := fmt.Errorf("Unknown channel for notification: %s", channel)
+			if _, ok := channels[channel]; ok {
+				err = fmt.Errorf("Channel not enabled for notification: %s", channel)
+			}
 			errm = multierror.Append(errm, err)
 		}
 	}
@@ -378,5 +407,12 @@ func ensureMailFallback(channels []string) []string {
 
 func hasNotifiableDevice(inst *instance.Instance) bool {
 	cs, err := oauth.GetNotifiables(inst)
-	return err == nil && len(cs) > 0
+	if err == nil && len(cs) > 0 {
+		return true
+	}
+	if _, err := notification.WebPushSender(); err != nil {
+		return false
+	}
+	subs, err := notification.ListWebPushSubscriptions(inst)
+	return err == nil && len(subs) > 0
 }
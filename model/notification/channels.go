@@ -0,0 +1,162 @@
+package notification
+
+import (
+	"sort"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// channelSecretsID is the identifier of the document with the secrets and
+// the access tokens of the channels.
+const channelSecretsID = "channels"
+
+// ChannelSettings are the settings of the user for a channel that sends the
+// notifications to an external service. The fields that are used depend of
+// the channel:
+//   - webhook: the URL, and the secret for signing the payloads
+//   - matrix: the room, and optionally the URL of a homeserver and the access
+//     token of an account to use instead of the bot of the configuration
+//   - ntfy: the topic, and optionally the URL of a server and its token.
+//
+// The secret and the token are not persisted in the preferences, that can be
+// read by the apps, but in a io.cozy.notifications.secrets document that only
+// the stack can read.
+type ChannelSettings struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	Token   string `json:"token,omitempty"`
+	RoomID  string `json:"room_id,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+}
+
+// EnabledChannels returns the names of the channels enabled by the user,
+// sorted alphabetically.
+func (p *Preferences) EnabledChannels() []string {
+	var names []string
+	for name, settings := range p.Channels {
+		if settings != nil && settings.Enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// channelSecret is the secret and the access token of a channel.
+type channelSecret struct {
+	Secret string `json:"secret,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// channelSecrets is the document with the secrets and the access tokens of
+// the channels, by name of channel.
+type channelSecrets struct {
+	DocID    string                   `json:"_id,omitempty"`
+	DocRev   string                   `json:"_rev,omitempty"`
+	Channels map[string]channelSecret `json:"channels"`
+}
+
+func (c *channelSecrets) ID() string        { return c.DocID }
+func (c *channelSecrets) Rev() string       { return c.DocRev }
+func (c *channelSecrets) DocType() string   { return consts.NotificationsSecrets }
+func (c *channelSecrets) SetID(id string)   { c.DocID = id }
+func (c *channelSecrets) SetRev(rev string) { c.DocRev = rev }
+func (c *channelSecrets) Clone() couchdb.Doc {
+	cloned := *c
+	cloned.Channels = make(map[string]channelSecret, len(c.Channels))
+	for k, v := range c.Channels {
+		cloned.Channels[k] = v
+	}
+	return &cloned
+}
+
+// getChannelSecrets returns the document with the secrets of the channels,
+// or an empty document if there is none.
+func getChannelSecrets(db prefixer.Prefixer) (*channelSecrets, error) {
+	doc := &channelSecrets{}
+	err := couchdb.GetDoc(db, consts.NotificationsSecrets, channelSecretsID, doc)
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// loadSecrets fills the secrets and the access tokens of the channels.
+func (p *Preferences) loadSecrets(db prefixer.Prefixer) error {
+	if len(p.Channels) == 0 {
+		return nil
+	}
+	doc, err := getChannelSecrets(db)
+	if err != nil {
+		return err
+	}
+	for name, secret := range doc.Channels {
+		if settings := p.Channels[name]; settings != nil {
+			settings.Secret = secret.Secret
+			settings.Token = secret.Token
+		}
+	}
+	return nil
+}
+
+// extractSecrets removes the secrets and the access tokens from the channels
+// and returns them.
+func (p *Preferences) extractSecrets() map[string]channelSecret {
+	secrets := make(map[string]channelSecret)
+	for name, settings := range p.Channels {
+		if settings == nil || (settings.Secret == "" && settings.Token == "") {
+			continue
+		}
+		secrets[name] = channelSecret{Secret: settings.Secret, Token: settings.Token}
+		settings.Secret = ""
+		settings.Token = ""
+	}
+	return secrets
+}
+
+// saveSecrets persists the secrets and the access tokens of the channels.
+func saveSecrets(db prefixer.Prefixer, secrets map[string]channelSecret) error {
+	doc, err := getChannelSecrets(db)
+	if err != nil {
+		return err
+	}
+	if doc.DocRev == "" && len(secrets) == 0 {
+		return nil
+	}
+	doc.DocID = channelSecretsID
+	doc.Channels = secrets
+	if doc.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(db, doc)
+	}
+	return couchdb.UpdateDoc(db, doc)
+}
+
+// WithoutSecrets returns a copy of the preferences without the secrets and
+// the access tokens of the channels, for the responses of the API.
+func (p *Preferences) WithoutSecrets() *Preferences {
+	cloned := p.Clone().(*Preferences)
+	cloned.extractSecrets()
+	return cloned
+}
+
+// KeepSecrets copies the secrets and the access tokens of the old
+// preferences to the channels where they are omitted, as the API doesn't
+// return them. They are kept only if the URL of the channel has not changed,
+// to not send them to another server.
+func (p *Preferences) KeepSecrets(old *Preferences) {
+	for name, settings := range p.Channels {
+		previous := old.Channels[name]
+		if settings == nil || previous == nil || settings.URL != previous.URL {
+			continue
+		}
+		if settings.Secret == "" {
+			settings.Secret = previous.Secret
+		}
+		if settings.Token == "" {
+			settings.Token = previous.Token
+		}
+	}
+}
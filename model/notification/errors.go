@@ -0,0 +1,33 @@
+package notification
+
+import "errors"
+
+var (
+	// ErrUnknownMode is used when the preferences have a mode that is not
+	// immediate, digest or muted, or a category key without a slug.
+	ErrUnknownMode = errors.New("Unknown mode for the notifications")
+	// ErrInvalidDigest is used when the frequency or the weekday of the digest
+	// is not valid.
+	ErrInvalidDigest = errors.New("Invalid frequency or weekday for the digest")
+	// ErrInvalidTime is used when a time of the day in the preferences is not
+	// formatted like 22:30.
+	ErrInvalidTime = errors.New("Invalid time of the day")
+	// ErrUnknownTimezone is used when the timezone of the preferences is not
+	// known.
+	ErrUnknownTimezone = errors.New("Unknown timezone")
+	// ErrWebPushNotConfigured is used when there is no VAPID key in the
+	// configuration for sending the Web Push notifications.
+	ErrWebPushNotConfigured = errors.New("Web Push is not configured")
+	// ErrInvalidSubscription is used when a Web Push subscription has no
+	// endpoint or no keys.
+	ErrInvalidSubscription = errors.New("Invalid Web Push subscription")
+	// ErrUnknownChannel is used when the preferences have settings for a
+	// channel that does not exist.
+	ErrUnknownChannel = errors.New("Unknown channel for the notifications")
+	// ErrChannelNotAvailable is used when a channel is not enabled in the
+	// configuration for the context of the instance.
+	ErrChannelNotAvailable = errors.New("This channel is not available")
+	// ErrInvalidChannelSettings is used when the settings of a channel are
+	// missing a required field, like the room of Matrix or the topic of ntfy.
+	ErrInvalidChannelSettings = errors.New("Invalid settings for the channel")
+)
@@ -0,0 +1,336 @@
+package notification
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// PreferencesDocTypeVersion represents the doctype version. Each time this
+// document structure is modified, update this value
+const PreferencesDocTypeVersion = "1"
+
+// The modes for sending the notifications of an app or a category.
+const (
+	// ModeImmediate is for sending the notifications as soon as possible
+	ModeImmediate = "immediate"
+	// ModeDigest is for grouping the notifications in a digest mail
+	ModeDigest = "digest"
+	// ModeMuted is for keeping the notifications in the notification center
+	// without sending them
+	ModeMuted = "muted"
+)
+
+// The frequencies for sending the digest mail.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// StackSlug is used as the slug of the app in the preferences for the
+// notifications sent by the stack itself.
+const StackSlug = "stack"
+
+const (
+	defaultDigestTime    = "08:00"
+	defaultDigestWeekday = "monday"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// QuietHours is a period of the day, in the timezone of the user, where the
+// notifications are not sent. They are delayed to the end of the period. The
+// period can span midnight, like from 22:00 to 07:00.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Preferences are the choices of the user for how the notifications are
+// sent. The mode of a notification is taken from its category (with the
+// "<slug>/<category>" key), else from its app, else from the default mode.
+type Preferences struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	Default    string            `json:"default,omitempty"`
+	Apps       map[string]string `json:"apps,omitempty"`
+	Categories map[string]string `json:"categories,omitempty"`
+
+	DigestFrequency string `json:"digest_frequency,omitempty"`
+	DigestTime      string `json:"digest_time,omitempty"`
+	DigestWeekday   string `json:"digest_weekday,omitempty"`
+
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+	// Timezone can be used to override the timezone of the instance settings
+	Timezone string `json:"timezone,omitempty"`
+
+	// Channels are the settings for the channels like webhook, matrix or
+	// ntfy, by name of channel
+	Channels map[string]*ChannelSettings `json:"channels,omitempty"`
+
+	Metadata *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (p *Preferences) ID() string { return p.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (p *Preferences) Rev() string { return p.DocRev }
+
+// SetID is used to implement the couchdb.Doc interface
+func (p *Preferences) SetID(id string) { p.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (p *Preferences) SetRev(rev string) { p.DocRev = rev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (p *Preferences) DocType() string { return consts.Settings }
+
+// Clone implements couchdb.Doc
+func (p *Preferences) Clone() couchdb.Doc {
+	cloned := *p
+	cloned.Apps = make(map[string]string, len(p.Apps))
+	for k, v := range p.Apps {
+		cloned.Apps[k] = v
+	}
+	cloned.Categories = make(map[string]string, len(p.Categories))
+	for k, v := range p.Categories {
+		cloned.Categories[k] = v
+	}
+	if p.QuietHours != nil {
+		quiet := *p.QuietHours
+		cloned.QuietHours = &quiet
+	}
+	cloned.Channels = make(map[string]*ChannelSettings, len(p.Channels))
+	for k, v := range p.Channels {
+		if v == nil {
+			cloned.Channels[k] = nil
+			continue
+		}
+		settings := *v
+		cloned.Channels[k] = &settings
+	}
+	if p.Metadata != nil {
+		cloned.Metadata = p.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// GetPreferences returns the preferences of the user for the notifications.
+// If the user has not set them, the default preferences are returned (all the
+// notifications are sent immediately).
+func GetPreferences(db prefixer.Prefixer) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := couchdb.GetDoc(db, consts.Settings, consts.NotificationsSettingsID, prefs)
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		return nil, err
+	}
+	if err := prefs.loadSecrets(db); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// Save persists the preferences in CouchDB.
+func (p *Preferences) Save(db prefixer.Prefixer) error {
+	p.DocID = consts.NotificationsSettingsID
+	if p.Metadata == nil {
+		md := metadata.New()
+		md.DocTypeVersion = PreferencesDocTypeVersion
+		p.Metadata = md
+	} else {
+		p.Metadata.ChangeUpdatedAt()
+	}
+	// The secrets of the channels are kept out of the settings document
+	doc := p.Clone().(*Preferences)
+	secrets := doc.extractSecrets()
+	var err error
+	if doc.DocRev == "" {
+		err = couchdb.CreateNamedDocWithDB(db, doc)
+	} else {
+		err = couchdb.UpdateDoc(db, doc)
+	}
+	if err != nil {
+		return err
+	}
+	p.SetRev(doc.Rev())
+	return saveSecrets(db, secrets)
+}
+
+// Validate checks that the preferences are valid.
+func (p *Preferences) Validate() error {
+	if p.Default != "" && !isValidMode(p.Default) {
+		return ErrUnknownMode
+	}
+	for _, mode := range p.Apps {
+		if !isValidMode(mode) {
+			return ErrUnknownMode
+		}
+	}
+	for key, mode := range p.Categories {
+		if !strings.Contains(key, "/") {
+			return ErrUnknownMode
+		}
+		if !isValidMode(mode) {
+			return ErrUnknownMode
+		}
+	}
+
+	switch p.DigestFrequency {
+	case "", DigestDaily, DigestWeekly:
+	default:
+		return ErrInvalidDigest
+	}
+	if p.DigestTime != "" {
+		if _, err := parseClock(p.DigestTime); err != nil {
+			return err
+		}
+	}
+	if _, ok := weekdays[p.weekdayName()]; !ok {
+		return ErrInvalidDigest
+	}
+	if p.QuietHours != nil {
+		if _, err := parseClock(p.QuietHours.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(p.QuietHours.End); err != nil {
+			return err
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return ErrUnknownTimezone
+		}
+	}
+	return nil
+}
+
+func isValidMode(mode string) bool {
+	return mode == ModeImmediate || mode == ModeDigest || mode == ModeMuted
+}
+
+// ModeFor returns the mode for a notification of the given app and category.
+// The slug is StackSlug for the notifications of the stack.
+func (p *Preferences) ModeFor(slug, category string) string {
+	if mode, ok := p.Categories[slug+"/"+category]; ok && mode != "" {
+		return mode
+	}
+	if mode, ok := p.Apps[slug]; ok && mode != "" {
+		return mode
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return ModeImmediate
+}
+
+// Location returns the timezone to use for the quiet hours and the digest
+// mails. The timezone of the preferences has the priority over the timezone of
+// the instance settings (tz).
+func (p *Preferences) Location(tz string) *time.Location {
+	if p.Timezone != "" {
+		tz = p.Timezone
+	}
+	if tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// QuietHoursEnd returns the end of the quiet hours if now is inside them,
+// and false if the notifications can be sent now.
+func (p *Preferences) QuietHoursEnd(now time.Time, loc *time.Location) (time.Time, bool) {
+	if p.QuietHours == nil {
+		return time.Time{}, false
+	}
+	start, err := parseClock(p.QuietHours.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := parseClock(p.QuietHours.End)
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+
+	now = now.In(loc)
+	current := now.Hour()*60 + now.Minute()
+	endDay := 0
+	if start < end {
+		if current < start || current >= end {
+			return time.Time{}, false
+		}
+	} else {
+		// The quiet hours span midnight
+		if current < start && current >= end {
+			return time.Time{}, false
+		}
+		if current >= start {
+			endDay = 1
+		}
+	}
+	return atClock(now, endDay, end), true
+}
+
+// NextDigestAfter returns the date of the next digest mail after now.
+func (p *Preferences) NextDigestAfter(now time.Time, loc *time.Location) time.Time {
+	digestTime := p.DigestTime
+	if digestTime == "" {
+		digestTime = defaultDigestTime
+	}
+	clock, err := parseClock(digestTime)
+	if err != nil {
+		clock, _ = parseClock(defaultDigestTime)
+	}
+
+	now = now.In(loc)
+	next := atClock(now, 0, clock)
+	if !next.After(now) {
+		next = atClock(now, 1, clock)
+	}
+	if p.DigestFrequency == DigestWeekly {
+		weekday := weekdays[p.weekdayName()]
+		for next.Weekday() != weekday {
+			next = atClock(next, 1, clock)
+		}
+	}
+	return next
+}
+
+func (p *Preferences) weekdayName() string {
+	if p.DigestWeekday == "" {
+		return defaultDigestWeekday
+	}
+	return strings.ToLower(p.DigestWeekday)
+}
+
+// parseClock parses a time of the day like "22:30", and returns the number of
+// minutes since midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, ErrInvalidTime
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// atClock returns the date for the given number of minutes since midnight, on
+// the day of t plus some days.
+func atClock(t time.Time, days, minutes int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, minutes/60, minutes%60, 0, 0, t.Location())
+}
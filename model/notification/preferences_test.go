@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferencesMode(t *testing.T) {
+	prefs := &Preferences{}
+	assert.NoError(t, prefs.Validate())
+	assert.Equal(t, ModeImmediate, prefs.ModeFor("banks", "balance-lower"))
+
+	prefs = &Preferences{
+		Default:    ModeDigest,
+		Apps:       map[string]string{"banks": ModeMuted},
+		Categories: map[string]string{"banks/balance-lower": ModeImmediate},
+	}
+	assert.NoError(t, prefs.Validate())
+	assert.Equal(t, ModeImmediate, prefs.ModeFor("banks", "balance-lower"))
+	assert.Equal(t, ModeMuted, prefs.ModeFor("banks", "transaction-greater"))
+	assert.Equal(t, ModeDigest, prefs.ModeFor(StackSlug, "disk-quota"))
+
+	prefs.Apps["drive"] = "sometimes"
+	assert.Equal(t, ErrUnknownMode, prefs.Validate())
+	delete(prefs.Apps, "drive")
+	prefs.Categories["balance-lower"] = ModeMuted
+	assert.Equal(t, ErrUnknownMode, prefs.Validate())
+	delete(prefs.Categories, "balance-lower")
+	prefs.DigestFrequency = "monthly"
+	assert.Equal(t, ErrInvalidDigest, prefs.Validate())
+	prefs.DigestFrequency = DigestWeekly
+	prefs.DigestWeekday = "Friday"
+	assert.NoError(t, prefs.Validate())
+	prefs.QuietHours = &QuietHours{Start: "22h", End: "07:00"}
+	assert.Equal(t, ErrInvalidTime, prefs.Validate())
+	prefs.QuietHours.Start = "22:00"
+	prefs.Timezone = "Mars/Olympus"
+	assert.Equal(t, ErrUnknownTimezone, prefs.Validate())
+}
+
+func TestQuietHours(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if !assert.NoError(t, err) {
+		return
+	}
+	prefs := &Preferences{QuietHours: &QuietHours{Start: "22:00", End: "07:30"}}
+
+	// 23:00 in Paris
+	now := time.Date(2021, 6, 1, 21, 0, 0, 0, time.UTC)
+	end, ok := prefs.QuietHoursEnd(now, paris)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 6, 2, 7, 30, 0, 0, paris), end)
+
+	// 06:00 in Paris
+	now = time.Date(2021, 6, 2, 4, 0, 0, 0, time.UTC)
+	end, ok = prefs.QuietHoursEnd(now, paris)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 6, 2, 7, 30, 0, 0, paris), end)
+
+	// 12:00 in Paris
+	now = time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)
+	_, ok = prefs.QuietHoursEnd(now, paris)
+	assert.False(t, ok)
+
+	prefs.QuietHours = &QuietHours{Start: "12:00", End: "14:00"}
+	end, ok = prefs.QuietHoursEnd(now, paris)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 6, 2, 14, 0, 0, 0, paris), end)
+}
+
+func TestNextDigest(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Wednesday, 10:00 in Paris
+	now := time.Date(2021, 6, 2, 8, 0, 0, 0, time.UTC)
+
+	prefs := &Preferences{}
+	next := prefs.NextDigestAfter(now, paris)
+	assert.Equal(t, time.Date(2021, 6, 3, 8, 0, 0, 0, paris), next)
+
+	prefs.DigestTime = "18:15"
+	next = prefs.NextDigestAfter(now, paris)
+	assert.Equal(t, time.Date(2021, 6, 2, 18, 15, 0, 0, paris), next)
+
+	prefs.DigestFrequency = DigestWeekly
+	next = prefs.NextDigestAfter(now, paris)
+	assert.Equal(t, time.Date(2021, 6, 7, 18, 15, 0, 0, paris), next)
+	assert.Equal(t, time.Monday, next.Weekday())
+}
+
+func TestChannelSecrets(t *testing.T) {
+	old := &Preferences{Channels: map[string]*ChannelSettings{
+		"webhook": {Enabled: true, URL: "https://hooks.example.org/cozy", Secret: "s3cr3t"},
+		"matrix":  {Enabled: true, URL: "https://matrix.example.org", Token: "user-token", RoomID: "!abc:example.org"},
+	}}
+
+	public := old.WithoutSecrets()
+	assert.Empty(t, public.Channels["webhook"].Secret)
+	assert.Empty(t, public.Channels["matrix"].Token)
+	assert.Equal(t, "s3cr3t", old.Channels["webhook"].Secret)
+
+	prefs := &Preferences{Channels: map[string]*ChannelSettings{
+		"webhook": {Enabled: false, URL: "https://hooks.example.org/cozy"},
+		"matrix":  {Enabled: true, URL: "https://other.example.org", RoomID: "!abc:example.org"},
+	}}
+	prefs.KeepSecrets(old)
+	assert.Equal(t, "s3cr3t", prefs.Channels["webhook"].Secret)
+	assert.Empty(t, prefs.Channels["matrix"].Token)
+}
@@ -0,0 +1,185 @@
+package notification
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+)
+
+// WebPushSubscription is a subscription of a browser for the Web Push
+// notifications, as given by PushManager.subscribe(). The identifier is
+// derived from the endpoint, so that a browser that subscribes again updates
+// its subscription.
+type WebPushSubscription struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	webpush.Subscription
+	ExpirationTime *int64    `json:"expirationTime,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) ID() string { return s.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) Rev() string { return s.DocRev }
+
+// SetID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetID(id string) { s.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetRev(rev string) { s.DocRev = rev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) DocType() string { return consts.NotificationsWebPush }
+
+// Clone implements couchdb.Doc
+func (s *WebPushSubscription) Clone() couchdb.Doc {
+	cloned := *s
+	if s.ExpirationTime != nil {
+		expiration := *s.ExpirationTime
+		cloned.ExpirationTime = &expiration
+	}
+	return &cloned
+}
+
+// Validate checks that the subscription has an endpoint and the keys for the
+// encryption of the payloads.
+func (s *WebPushSubscription) Validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ErrInvalidSubscription
+	}
+	if s.Keys.P256dh == "" || s.Keys.Auth == "" {
+		return ErrInvalidSubscription
+	}
+	return nil
+}
+
+// Expired returns true if the browser has given an expiration time for the
+// subscription, and it has passed.
+func (s *WebPushSubscription) Expired(now time.Time) bool {
+	if s.ExpirationTime == nil || *s.ExpirationTime == 0 {
+		return false
+	}
+	return now.After(time.Unix(0, *s.ExpirationTime*int64(time.Millisecond)))
+}
+
+func webPushSubscriptionID(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:16])
+}
+
+// SaveWebPushSubscription creates the subscription, or updates it if the
+// browser was already subscribed with the same endpoint.
+func SaveWebPushSubscription(db prefixer.Prefixer, sub *WebPushSubscription) error {
+	if err := sub.Validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	sub.DocID = webPushSubscriptionID(sub.Endpoint)
+	sub.UpdatedAt = now
+	old, err := GetWebPushSubscription(db, sub.DocID)
+	if err == nil {
+		sub.DocRev = old.DocRev
+		sub.CreatedAt = old.CreatedAt
+		return couchdb.UpdateDoc(db, sub)
+	}
+	if !couchdb.IsNotFoundError(err) {
+		return err
+	}
+	sub.DocRev = ""
+	sub.CreatedAt = now
+	return couchdb.CreateNamedDocWithDB(db, sub)
+}
+
+// GetWebPushSubscription returns the subscription with the given identifier.
+func GetWebPushSubscription(db prefixer.Prefixer, id string) (*WebPushSubscription, error) {
+	sub := &WebPushSubscription{}
+	if err := couchdb.GetDoc(db, consts.NotificationsWebPush, id, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ListWebPushSubscriptions returns the Web Push subscriptions of the browsers.
+func ListWebPushSubscriptions(db prefixer.Prefixer) ([]*WebPushSubscription, error) {
+	var docs []*WebPushSubscription
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(db, consts.NotificationsWebPush, req, &docs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	subs := docs[:0]
+	for _, s := range docs {
+		if !strings.HasPrefix(s.ID(), "_design") {
+			subs = append(subs, s)
+		}
+	}
+	return subs, nil
+}
+
+// DeleteWebPushSubscription removes a subscription.
+func DeleteWebPushSubscription(db prefixer.Prefixer, sub *WebPushSubscription) error {
+	return couchdb.DeleteDoc(db, sub)
+}
+
+// webPushClient is the HTTP client used to send the messages to the push
+// services of the browsers. The endpoints of the subscriptions are given by
+// the apps: they can't be on a private address, and the redirections are not
+// followed.
+var webPushClient = safehttp.NewClient(10*time.Second, false)
+
+var (
+	vapidOnce   sync.Once
+	vapidSender *webpush.Sender
+)
+
+// WebPushSender returns the sender for the Web Push notifications, with the
+// VAPID key from the configuration. ErrWebPushNotConfigured is returned if
+// there is no VAPID key.
+func WebPushSender() (*webpush.Sender, error) {
+	vapidOnce.Do(func() {
+		conf := config.GetConfig().Notifications
+		if conf.VAPIDPrivateKeyPath == "" {
+			return
+		}
+		log := logger.WithNamespace("webpush")
+		data, err := ioutil.ReadFile(conf.VAPIDPrivateKeyPath)
+		if err != nil {
+			log.Errorf("Cannot read the VAPID key: %s", err)
+			return
+		}
+		key, err := webpush.ParseKey(data)
+		if err != nil {
+			log.Errorf("Cannot parse the VAPID key: %s", err)
+			return
+		}
+		vapidSender = &webpush.Sender{
+			Key:     key,
+			Subject: conf.VAPIDSubject,
+			Client:  webPushClient,
+		}
+	})
+	if vapidSender == nil {
+		return nil, ErrWebPushNotConfigured
+	}
+	return vapidSender, nil
+}
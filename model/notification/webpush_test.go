@@ -0,0 +1,31 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebPushSubscription(t *testing.T) {
+	sub := &WebPushSubscription{}
+	sub.Endpoint = "https://updates.push.services.mozilla.com/wpush/v2/gAAAAABf"
+	assert.Equal(t, ErrInvalidSubscription, sub.Validate())
+	sub.Keys.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	sub.Keys.Auth = "BTBZMqHH6r4Tts7J_aSIgg"
+	assert.NoError(t, sub.Validate())
+	sub.Endpoint = "http://push.example.net/"
+	assert.Equal(t, ErrInvalidSubscription, sub.Validate())
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.False(t, sub.Expired(now))
+	expiration := now.Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	sub.ExpirationTime = &expiration
+	assert.False(t, sub.Expired(now))
+	assert.True(t, sub.Expired(now.Add(2*time.Hour)))
+
+	id := webPushSubscriptionID(sub.Endpoint)
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, webPushSubscriptionID(sub.Endpoint))
+	assert.NotEqual(t, id, webPushSubscriptionID(sub.Endpoint+"x"))
+}
@@ -1,6 +1,11 @@
 package oauth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -16,6 +21,50 @@ type AccessCode struct {
 	ClientID string `json:"client_id"`
 	IssuedAt int64  `json:"issued_at"`
 	Scope    string `json:"scope"`
+
+	// The PKCE challenge, see https://tools.ietf.org/html/rfc7636
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+
+	// The nonce and the authentication time, for the ID token of OpenID
+	// Connect
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+}
+
+// AccessCodeOptions are the optional parameters of the authorization request
+// that are kept with the access code.
+type AccessCodeOptions struct {
+	// CodeChallenge and CodeChallengeMethod are used for PKCE
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce and AuthTime are used for the ID token of OpenID Connect
+	Nonce    string
+	AuthTime int64
+}
+
+const (
+	// PKCEMethodPlain is the code challenge method where the challenge is
+	// the verifier
+	PKCEMethodPlain = "plain"
+	// PKCEMethodS256 is the code challenge method where the challenge is the
+	// base64url encoded SHA256 hash of the verifier
+	PKCEMethodS256 = "S256"
+)
+
+// The code verifier and the challenges are made of 43 to 128 unreserved
+// characters.
+var pkceRegexp = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// ValidCodeChallenge returns true if the code challenge and its method are
+// valid for PKCE. An empty method means plain.
+func ValidCodeChallenge(challenge, method string) bool {
+	switch method {
+	case "", PKCEMethodPlain, PKCEMethodS256:
+		return pkceRegexp.MatchString(challenge)
+	default:
+		return false
+	}
 }
 
 // ID returns the access code qualified identifier
@@ -36,19 +85,22 @@ func (ac *AccessCode) SetID(id string) { ac.Code = id }
 // SetRev changes the access code revision
 func (ac *AccessCode) SetRev(rev string) { ac.CouchRev = rev }
 
-// CreateAccessCode an access code for the given clientID, persisted in CouchDB
-func CreateAccessCode(i *instance.Instance, client *Client, scope string) (*AccessCode, error) {
-	if client.Pending {
-		client.Pending = false
-		client.ClientID = ""
-		_ = couchdb.UpdateDoc(i, client)
-		client.ClientID = client.CouchID
-	}
+// CreateAccessCode an access code for the given clientID, persisted in
+// CouchDB.
+func CreateAccessCode(i *instance.Instance, client *Client, scope string, opts AccessCodeOptions) (*AccessCode, error) {
+	client.confirm(i)
 
+	if opts.CodeChallenge != "" && opts.CodeChallengeMethod == "" {
+		opts.CodeChallengeMethod = PKCEMethodPlain
+	}
 	ac := &AccessCode{
-		ClientID: client.ClientID,
-		IssuedAt: crypto.Timestamp(),
-		Scope:    scope,
+		ClientID:            client.ClientID,
+		IssuedAt:            crypto.Timestamp(),
+		Scope:               scope,
+		CodeChallenge:       opts.CodeChallenge,
+		CodeChallengeMethod: opts.CodeChallengeMethod,
+		Nonce:               opts.Nonce,
+		AuthTime:            opts.AuthTime,
 	}
 	if err := couchdb.CreateDoc(i, ac); err != nil {
 		return nil, err
@@ -56,4 +108,21 @@ func CreateAccessCode(i *instance.Instance, client *Client, scope string) (*Acce
 	return ac, nil
 }
 
+// CheckVerifier returns true if the code verifier matches the PKCE challenge
+// of the access code, or if the access code has no challenge.
+func (ac *AccessCode) CheckVerifier(verifier string) bool {
+	if ac.CodeChallenge == "" {
+		return true
+	}
+	if !pkceRegexp.MatchString(verifier) {
+		return false
+	}
+	expected := verifier
+	if ac.CodeChallengeMethod == PKCEMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(ac.CodeChallenge)) == 1
+}
+
 var _ couchdb.Doc = &AccessCode{}
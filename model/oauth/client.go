@@ -1,6 +1,7 @@
 package oauth
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -41,6 +42,29 @@ const ClientSecretLen = 24
 // for login/authentication purposes.
 const ScopeLogin = "login"
 
+const (
+	// GrantTypeAuthorizationCode is the grant type for the authorization code
+	// flow
+	GrantTypeAuthorizationCode = "authorization_code"
+	// GrantTypeRefreshToken is the grant type for refreshing an access token
+	GrantTypeRefreshToken = "refresh_token"
+	// GrantTypeDeviceCode is the grant type of the device authorization flow,
+	// see https://tools.ietf.org/html/rfc8628
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+const (
+	// AuthMethodSecretPost is the default authentication method for the
+	// clients: the client_secret is sent in the body of the request
+	AuthMethodSecretPost = "client_secret_post"
+	// AuthMethodSecretBasic is the authentication method where the
+	// client_secret is sent via HTTP Basic auth
+	AuthMethodSecretBasic = "client_secret_basic"
+	// AuthMethodNone is used by the public clients, that don't have a
+	// client_secret (they must use PKCE for the authorization code flow)
+	AuthMethodNone = "none"
+)
+
 // CleanMessage is used for messages to the clean-clients worker.
 type CleanMessage struct {
 	ClientID string `json:"client_id"`
@@ -65,8 +89,8 @@ type Client struct {
 	AllowLoginScope   bool   `json:"allow_login_scope,omitempty"`         // Allow to generate token for a "login" scope (no permissions)
 	Pending           bool   `json:"pending,omitempty"`                   // True until a token is generated
 
-	RedirectURIs    []string `json:"redirect_uris"`              // Declared by the client (mandatory)
-	GrantTypes      []string `json:"grant_types"`                // Forced by the server to ["authorization_code", "refresh_token"]
+	RedirectURIs    []string `json:"redirect_uris"`              // Declared by the client (mandatory, except for the device flow)
+	GrantTypes      []string `json:"grant_types"`                // Forced by the server to ["authorization_code", "refresh_token"], plus the device code if declared by the client
 	ResponseTypes   []string `json:"response_types"`             // Forced by the server to ["code"]
 	ClientName      string   `json:"client_name"`                // Declared by the client (mandatory)
 	ClientKind      string   `json:"client_kind,omitempty"`      // Declared by the client (optional, can be "desktop", "mobile", "browser", etc.)
@@ -76,6 +100,9 @@ type Client struct {
 	SoftwareID      string   `json:"software_id"`                // Declared by the client (mandatory)
 	SoftwareVersion string   `json:"software_version,omitempty"` // Declared by the client (optional)
 
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"` // Declared by the client (optional, "none" for a public client)
+	TokensRevokedAt         int64  `json:"tokens_revoked_at,omitempty"`          // The tokens issued before this timestamp are revoked
+
 	// Notifications parameters
 	Notifications map[string]notification.Properties `json:"notifications,omitempty"`
 
@@ -252,7 +279,7 @@ type ClientRegistrationError struct {
 }
 
 func (c *Client) checkMandatoryFields(i *instance.Instance) *ClientRegistrationError {
-	if len(c.RedirectURIs) == 0 {
+	if len(c.RedirectURIs) == 0 && !c.hasGrantType(GrantTypeDeviceCode) {
 		return &ClientRegistrationError{
 			Code:        http.StatusBadRequest,
 			Error:       "invalid_redirect_uri",
@@ -285,6 +312,15 @@ func (c *Client) checkMandatoryFields(i *instance.Instance) *ClientRegistrationE
 			Description: "software_id is mandatory",
 		}
 	}
+	switch c.TokenEndpointAuthMethod {
+	case "", AuthMethodSecretPost, AuthMethodSecretBasic, AuthMethodNone:
+	default:
+		return &ClientRegistrationError{
+			Code:        http.StatusBadRequest,
+			Error:       "invalid_client_metadata",
+			Description: "token_endpoint_auth_method is not supported",
+		}
+	}
 	c.NotificationPlatform = strings.ToLower(c.NotificationPlatform)
 	switch c.NotificationPlatform {
 	case "", PlatformFirebase, PlatformAPNS:
@@ -399,11 +435,15 @@ func (c *Client) Create(i *instance.Instance, opts ...CreateOptions) *ClientRegi
 	c.CouchID = ""
 	c.CouchRev = ""
 	c.ClientID = ""
-	secret := crypto.GenerateRandomBytes(ClientSecretLen)
-	c.ClientSecret = string(crypto.Base64Encode(secret))
+	c.ClientSecret = ""
+	if !c.IsPublic() {
+		secret := crypto.GenerateRandomBytes(ClientSecretLen)
+		c.ClientSecret = string(crypto.Base64Encode(secret))
+	}
 	c.SecretExpiresAt = 0
 	c.RegistrationToken = ""
-	c.GrantTypes = []string{"authorization_code", "refresh_token"}
+	c.TokensRevokedAt = 0
+	c.GrantTypes = c.allowedGrantTypes()
 	c.ResponseTypes = []string{"code"}
 
 	// Adding Metadata
@@ -476,6 +516,8 @@ func (c *Client) Update(i *instance.Instance, old *Client) *ClientRegistrationEr
 		return err
 	}
 
+	// A public client can't become confidential, and vice versa
+	c.TokenEndpointAuthMethod = old.TokenEndpointAuthMethod
 	switch c.ClientSecret {
 	case "":
 		c.ClientSecret = old.ClientSecret
@@ -496,7 +538,8 @@ func (c *Client) Update(i *instance.Instance, old *Client) *ClientRegistrationEr
 	c.ClientID = ""
 	c.SecretExpiresAt = 0
 	c.RegistrationToken = ""
-	c.GrantTypes = []string{"authorization_code", "refresh_token"}
+	c.TokensRevokedAt = old.TokensRevokedAt
+	c.GrantTypes = c.allowedGrantTypes()
 	c.ResponseTypes = []string{"code"}
 	c.AllowLoginScope = old.AllowLoginScope
 	c.OnboardingSecret = ""
@@ -547,6 +590,73 @@ func (c *Client) Delete(i *instance.Instance) *ClientRegistrationError {
 	return nil
 }
 
+// IsPublic returns true if the client can't keep a secret (mobile apps, CLI
+// tools, etc.), and has no client_secret.
+func (c *Client) IsPublic() bool {
+	return c.TokenEndpointAuthMethod == AuthMethodNone
+}
+
+// CheckSecret returns true if the given secret is the client_secret of this
+// client. It always returns false for a public client.
+func (c *Client) CheckSecret(secret string) bool {
+	if c.IsPublic() || c.ClientSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.ClientSecret)) == 1
+}
+
+func (c *Client) hasGrantType(grantType string) bool {
+	for _, grant := range c.GrantTypes {
+		if grant == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowDeviceFlow returns true if the client has been registered for the
+// device authorization grant.
+func (c *Client) AllowDeviceFlow() bool {
+	return c.hasGrantType(GrantTypeDeviceCode)
+}
+
+// allowedGrantTypes returns the grant types for the client: the authorization
+// code and refresh token are always allowed, and the device code only if the
+// client has asked for it.
+func (c *Client) allowedGrantTypes() []string {
+	grants := []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+	if c.hasGrantType(GrantTypeDeviceCode) {
+		grants = append(grants, GrantTypeDeviceCode)
+	}
+	return grants
+}
+
+// confirm removes the pending flag of the client, as a token will be created
+// for it.
+func (c *Client) confirm(i *instance.Instance) {
+	if c.Pending {
+		c.Pending = false
+		c.ClientID = ""
+		_ = couchdb.UpdateDoc(i, c)
+		c.ClientID = c.CouchID
+	}
+}
+
+// RevokeTokens revokes all the access and refresh tokens that have been
+// issued for this client until now.
+func (c *Client) RevokeTokens(i *instance.Instance) error {
+	c.TokensRevokedAt = crypto.Timestamp()
+	c.ClientID = ""
+	defer func() { c.ClientID = c.CouchID }()
+	return couchdb.UpdateDoc(i, c)
+}
+
+// IsRevoked returns true if the token with the given claims has been issued
+// before a revocation of the tokens of this client.
+func (c *Client) IsRevoked(claims *permission.Claims) bool {
+	return c.TokensRevokedAt > 0 && claims.IssuedAt <= c.TokensRevokedAt
+}
+
 // AcceptRedirectURI returns true if the given URI matches the registered
 // redirect_uris
 func (c *Client) AcceptRedirectURI(u string) bool {
@@ -644,6 +754,11 @@ func (c *Client) ValidToken(i *instance.Instance, audience, token string) (permi
 			Errorf("Expected %s subject for %s token, but was: %s", audience, c.CouchID, claims.Subject)
 		return claims, false
 	}
+	if c.IsRevoked(&claims) {
+		i.Logger().WithField("nspace", "oauth").
+			Infof("The %s token for %s has been revoked", audience, c.CouchID)
+		return claims, false
+	}
 	return claims, true
 }
 
@@ -0,0 +1,233 @@
+package oauth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// DeviceCodeTTL is the validity duration of a device code.
+const DeviceCodeTTL = 10 * time.Minute
+
+// DeviceCodeInterval is the minimal number of seconds that a device must wait
+// between two polling requests on the token endpoint.
+const DeviceCodeInterval = 5
+
+// The user codes are made of consonants only, to avoid typing errors and
+// forming words. See https://tools.ietf.org/html/rfc8628#section-6.1
+const (
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLen     = 8
+)
+
+const (
+	deviceCodePending  = "pending"
+	deviceCodeApproved = "approved"
+)
+
+var (
+	// ErrAuthorizationPending is used when the user has not yet approved the
+	// device authorization request.
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is used when the device polls the token endpoint too often.
+	ErrSlowDown = errors.New("slow_down")
+	// ErrExpiredToken is used when the device code has expired.
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrInvalidDeviceCode is used when the device code or the user code is
+	// unknown.
+	ErrInvalidDeviceCode = errors.New("invalid_grant")
+)
+
+// DeviceCode is used for the OAuth2 device authorization grant, for the
+// devices without a browser or with limited input capabilities (CLI tools,
+// TVs). See https://tools.ietf.org/html/rfc8628
+//
+// The user code is used as the identifier of the document, and the device
+// code given to the device is the user code followed by a secret.
+type DeviceCode struct {
+	UserCode     string     `json:"_id,omitempty"`
+	CouchRev     string     `json:"_rev,omitempty"`
+	Secret       string     `json:"secret"`
+	ClientID     string     `json:"client_id"`
+	Scope        string     `json:"scope"`
+	Status       string     `json:"status"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	Interval     int        `json:"interval"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty"`
+}
+
+// ID returns the device code qualified identifier
+func (dc *DeviceCode) ID() string { return dc.UserCode }
+
+// Rev returns the device code revision
+func (dc *DeviceCode) Rev() string { return dc.CouchRev }
+
+// DocType returns the device code document type
+func (dc *DeviceCode) DocType() string { return consts.OAuthDeviceCodes }
+
+// Clone implements couchdb.Doc
+func (dc *DeviceCode) Clone() couchdb.Doc {
+	cloned := *dc
+	if dc.LastPolledAt != nil {
+		polled := *dc.LastPolledAt
+		cloned.LastPolledAt = &polled
+	}
+	return &cloned
+}
+
+// SetID changes the device code qualified identifier
+func (dc *DeviceCode) SetID(id string) { dc.UserCode = id }
+
+// SetRev changes the device code revision
+func (dc *DeviceCode) SetRev(rev string) { dc.CouchRev = rev }
+
+// DeviceCode returns the code that the device uses to poll the token
+// endpoint.
+func (dc *DeviceCode) DeviceCode() string {
+	return dc.UserCode + "." + dc.Secret
+}
+
+// DisplayUserCode returns the user code, formatted for being displayed to the
+// user, like WDJB-MJHT.
+func (dc *DeviceCode) DisplayUserCode() string {
+	return dc.UserCode[:userCodeLen/2] + "-" + dc.UserCode[userCodeLen/2:]
+}
+
+// Expired returns true if the device code can no longer be used.
+func (dc *DeviceCode) Expired() bool {
+	return time.Now().After(dc.ExpiresAt)
+}
+
+// CreateDeviceCode creates a device code for the client and the given scope,
+// and persists it in CouchDB.
+func CreateDeviceCode(i *instance.Instance, client *Client, scope string) (*DeviceCode, error) {
+	var err error
+	// Retry a few times in case of collision on the user code
+	for k := 0; k < 3; k++ {
+		dc := &DeviceCode{
+			UserCode:  generateUserCode(),
+			Secret:    crypto.GenerateRandomString(32),
+			ClientID:  client.ClientID,
+			Scope:     scope,
+			Status:    deviceCodePending,
+			ExpiresAt: time.Now().UTC().Add(DeviceCodeTTL),
+			Interval:  DeviceCodeInterval,
+		}
+		if err = couchdb.CreateNamedDocWithDB(i, dc); err == nil {
+			return dc, nil
+		}
+		if !couchdb.IsConflictError(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func generateUserCode() string {
+	code := make([]byte, userCodeLen)
+	for k, b := range crypto.GenerateRandomBytes(userCodeLen) {
+		code[k] = userCodeCharset[int(b)%len(userCodeCharset)]
+	}
+	return string(code)
+}
+
+// normalizeUserCode removes the dashes and spaces typed by the user, and puts
+// the letters in uppercase.
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeCharset, r) {
+			return r
+		}
+		return -1
+	}, userCode)
+}
+
+// FindDeviceCodeByUserCode returns the pending device code for the user code
+// typed by the user.
+func FindDeviceCodeByUserCode(i *instance.Instance, userCode string) (*DeviceCode, error) {
+	userCode = normalizeUserCode(userCode)
+	if len(userCode) != userCodeLen {
+		return nil, ErrInvalidDeviceCode
+	}
+	dc := &DeviceCode{}
+	if err := couchdb.GetDoc(i, consts.OAuthDeviceCodes, userCode, dc); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrInvalidDeviceCode
+		}
+		return nil, err
+	}
+	if dc.Expired() {
+		return nil, ErrExpiredToken
+	}
+	if dc.Status != deviceCodePending {
+		return nil, ErrInvalidDeviceCode
+	}
+	return dc, nil
+}
+
+// FindDeviceCode returns the device code document for the code sent by the
+// device to the token endpoint.
+func FindDeviceCode(i *instance.Instance, client *Client, deviceCode string) (*DeviceCode, error) {
+	parts := strings.SplitN(deviceCode, ".", 2)
+	if len(parts) != 2 || len(parts[0]) != userCodeLen {
+		return nil, ErrInvalidDeviceCode
+	}
+	dc := &DeviceCode{}
+	if err := couchdb.GetDoc(i, consts.OAuthDeviceCodes, parts[0], dc); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrInvalidDeviceCode
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(dc.Secret)) != 1 {
+		return nil, ErrInvalidDeviceCode
+	}
+	if dc.ClientID != client.ClientID {
+		return nil, ErrInvalidDeviceCode
+	}
+	return dc, nil
+}
+
+// Approve is called when the user has approved the authorization request for
+// the device.
+func (dc *DeviceCode) Approve(i *instance.Instance, client *Client) error {
+	client.confirm(i)
+	dc.Status = deviceCodeApproved
+	return couchdb.UpdateDoc(i, dc)
+}
+
+// Poll is called when the device asks the token endpoint if the user has
+// approved the request. It returns nil if the request has been approved, and
+// the tokens can be issued. Else, it returns an error that can be sent to the
+// device (authorization_pending, slow_down, expired_token).
+func (dc *DeviceCode) Poll(i *instance.Instance) error {
+	if dc.Expired() {
+		_ = couchdb.DeleteDoc(i, dc)
+		return ErrExpiredToken
+	}
+	if dc.Status == deviceCodeApproved {
+		// The device code can be used only once
+		return couchdb.DeleteDoc(i, dc)
+	}
+
+	now := time.Now().UTC()
+	var err error = ErrAuthorizationPending
+	if dc.LastPolledAt != nil && now.Sub(*dc.LastPolledAt) < time.Duration(dc.Interval)*time.Second {
+		dc.Interval += DeviceCodeInterval
+		err = ErrSlowDown
+	}
+	dc.LastPolledAt = &now
+	if updateErr := couchdb.UpdateDoc(i, dc); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+var _ couchdb.Doc = &DeviceCode{}
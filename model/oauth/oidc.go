@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// The OpenID Connect scopes that can be asked by a client, in addition to the
+// permissions, when the stack is used as an OpenID Connect provider.
+// See https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// IDTokenValidityDuration is the validity duration of the ID tokens.
+const IDTokenValidityDuration = 1 * time.Hour
+
+// SplitOIDCScope separates the OpenID Connect scopes from the permissions in
+// the scope asked by a client.
+func SplitOIDCScope(scope string) ([]string, string) {
+	var oidc, perms []string
+	for _, part := range strings.Fields(scope) {
+		switch part {
+		case ScopeOpenID, ScopeProfile, ScopeEmail:
+			oidc = append(oidc, part)
+		default:
+			perms = append(perms, part)
+		}
+	}
+	return oidc, strings.Join(perms, " ")
+}
+
+// HasOpenIDScope returns true if the scope is for an OpenID Connect
+// authentication.
+func HasOpenIDScope(scope string) bool {
+	oidc, _ := SplitOIDCScope(scope)
+	for _, s := range oidc {
+		if s == ScopeOpenID {
+			return true
+		}
+	}
+	return false
+}
+
+// Issuer returns the issuer identifier of the instance, as an OpenID Connect
+// provider.
+func Issuer(i *instance.Instance) string {
+	return i.PageURL("", nil)
+}
+
+// UserInfo is the set of claims about the owner of the instance that can be
+// given to a client, depending on the scope that it has.
+type UserInfo struct {
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Locale            string `json:"locale,omitempty"`
+	Website           string `json:"website,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
+
+// Subject returns the identifier of the owner of the instance for the OpenID
+// Connect clients. It doesn't change when the instance is moved to another
+// domain.
+func Subject(i *instance.Instance) string {
+	return i.ID()
+}
+
+// GetUserInfo returns the claims about the owner of the instance that are
+// allowed by the scope.
+func GetUserInfo(i *instance.Instance, scope string) (*UserInfo, error) {
+	info := &UserInfo{}
+	oidc, _ := SplitOIDCScope(scope)
+	for _, s := range oidc {
+		switch s {
+		case ScopeProfile:
+			name, err := i.PublicName()
+			if err != nil {
+				return nil, err
+			}
+			info.Name = name
+			info.PreferredUsername = strings.SplitN(i.Domain, ".", 2)[0]
+			info.Locale = i.Locale
+			info.Website = i.PageURL("/", nil)
+		case ScopeEmail:
+			email, err := i.SettingsEMail()
+			if err != nil {
+				return nil, err
+			}
+			info.Email = email
+		}
+	}
+	return info, nil
+}
+
+// IDTokenClaims are the claims of an ID token.
+// See https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type IDTokenClaims struct {
+	jwt.StandardClaims
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	UserInfo
+}
+
+// CreateIDToken returns an ID token for this client, signed with the RSA key
+// of the instance. The nonce and the authentication time come from the
+// authorization request.
+func (c *Client) CreateIDToken(i *instance.Instance, scope, nonce string, authTime int64) (string, error) {
+	key, err := i.OIDCSigningKey()
+	if err != nil {
+		return "", err
+	}
+	info, err := GetUserInfo(i, scope)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := IDTokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  c.ClientID,
+			Issuer:    Issuer(i),
+			Subject:   Subject(i),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(IDTokenValidityDuration).Unix(),
+		},
+		Nonce:    nonce,
+		AuthTime: authTime,
+		UserInfo: *info,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = instance.OIDCKeyID(&key.PublicKey)
+	return token.SignedString(key)
+}
+
+// JSONWebKey is the public key used to sign the ID tokens, in the JWK format.
+// See https://tools.ietf.org/html/rfc7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// GetJSONWebKeys returns the set of public keys that can be used to check
+// the signature of the ID tokens.
+func GetJSONWebKeys(i *instance.Instance) ([]JSONWebKey, error) {
+	key, err := i.OIDCSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	pub := &key.PublicKey
+	e := big.NewInt(int64(pub.E)).Bytes()
+	return []JSONWebKey{
+		{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			KeyID:     instance.OIDCKeyID(pub),
+			Modulus:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(e),
+		},
+	}, nil
+}
@@ -0,0 +1,74 @@
+package oauth_test
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestSplitOIDCScope(t *testing.T) {
+	oidc, rest := oauth.SplitOIDCScope("openid io.cozy.files:GET profile io.cozy.contacts")
+	assert.Equal(t, []string{"openid", "profile"}, oidc)
+	assert.Equal(t, "io.cozy.files:GET io.cozy.contacts", rest)
+
+	oidc, rest = oauth.SplitOIDCScope("io.cozy.files")
+	assert.Empty(t, oidc)
+	assert.Equal(t, "io.cozy.files", rest)
+
+	assert.True(t, oauth.HasOpenIDScope("openid email"))
+	assert.False(t, oauth.HasOpenIDScope("email io.cozy.files"))
+}
+
+func TestIDToken(t *testing.T) {
+	_, priv, err := crypto.GenerateRSAKeyPair()
+	assert.NoError(t, err)
+	inst := &instance.Instance{
+		DocID:          "d1f9e5a0c6b04d4f",
+		Domain:         "alice.cozy.example",
+		OIDCPrivateKey: priv,
+	}
+	client := &oauth.Client{ClientID: "gitea-client"}
+
+	keys, err := oauth.GetJSONWebKeys(inst)
+	assert.NoError(t, err)
+	if !assert.Len(t, keys, 1) {
+		return
+	}
+	assert.Equal(t, "RSA", keys[0].KeyType)
+	assert.Equal(t, "RS256", keys[0].Algorithm)
+	n, err := base64.RawURLEncoding.DecodeString(keys[0].Modulus)
+	assert.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(keys[0].Exponent)
+	assert.NoError(t, err)
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+
+	// The profile and email scopes are not used to avoid reading the settings
+	tokenString, err := client.CreateIDToken(inst, "openid io.cozy.files", "n0nc3", 1577836800)
+	assert.NoError(t, err)
+
+	claims := oauth.IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		_, ok := token.Method.(*jwt.SigningMethodRSA)
+		assert.True(t, ok, "The signing method should be RSA")
+		assert.Equal(t, keys[0].KeyID, token.Header["kid"])
+		return pub, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, token.Valid)
+	assert.Equal(t, "gitea-client", claims.Audience)
+	assert.Equal(t, oauth.Issuer(inst), claims.Issuer)
+	assert.Equal(t, inst.DocID, claims.Subject)
+	assert.Equal(t, "n0nc3", claims.Nonce)
+	assert.Equal(t, int64(1577836800), claims.AuthTime)
+	assert.Empty(t, claims.Email)
+}
@@ -0,0 +1,64 @@
+package oauth_test
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/stretchr/testify/assert"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mJ92IZqfQ0mvaqkf6ueu3RZjz8Ov2kdBjftJeZ4CVP"
+	challenge := "h_edX5WUh1y-IdKFV8Yow4Um3XmBm_kR3cZyTjX4Kmc"
+
+	assert.True(t, oauth.ValidCodeChallenge(challenge, oauth.PKCEMethodS256))
+	assert.True(t, oauth.ValidCodeChallenge(verifier, ""))
+	assert.False(t, oauth.ValidCodeChallenge(challenge, "S512"))
+	assert.False(t, oauth.ValidCodeChallenge("too-short", oauth.PKCEMethodPlain))
+
+	ac := &oauth.AccessCode{CodeChallenge: challenge, CodeChallengeMethod: oauth.PKCEMethodS256}
+	assert.True(t, ac.CheckVerifier(verifier))
+	assert.False(t, ac.CheckVerifier(challenge))
+	assert.False(t, ac.CheckVerifier(""))
+
+	ac = &oauth.AccessCode{CodeChallenge: verifier, CodeChallengeMethod: oauth.PKCEMethodPlain}
+	assert.True(t, ac.CheckVerifier(verifier))
+	assert.False(t, ac.CheckVerifier(challenge))
+
+	ac = &oauth.AccessCode{}
+	assert.True(t, ac.CheckVerifier(""))
+}
+
+func TestPublicClient(t *testing.T) {
+	confidential := &oauth.Client{ClientSecret: "s3cr3t"}
+	assert.False(t, confidential.IsPublic())
+	assert.True(t, confidential.CheckSecret("s3cr3t"))
+	assert.False(t, confidential.CheckSecret("s3cr3"))
+	assert.False(t, confidential.CheckSecret(""))
+
+	public := &oauth.Client{TokenEndpointAuthMethod: oauth.AuthMethodNone}
+	assert.True(t, public.IsPublic())
+	assert.False(t, public.CheckSecret(""))
+
+	assert.False(t, public.AllowDeviceFlow())
+	public.GrantTypes = []string{oauth.GrantTypeDeviceCode}
+	assert.True(t, public.AllowDeviceFlow())
+}
+
+func TestTokensRevocation(t *testing.T) {
+	client := &oauth.Client{}
+	claims := &permission.Claims{StandardClaims: jwt.StandardClaims{IssuedAt: 1000}}
+	assert.False(t, client.IsRevoked(claims))
+	client.TokensRevokedAt = 1000
+	assert.True(t, client.IsRevoked(claims))
+	claims.IssuedAt = 1001
+	assert.False(t, client.IsRevoked(claims))
+}
+
+func TestDeviceCodeFormat(t *testing.T) {
+	dc := &oauth.DeviceCode{UserCode: "WDJBMJHT", Secret: "foobar"}
+	assert.Equal(t, "WDJB-MJHT", dc.DisplayUserCode())
+	assert.Equal(t, "WDJBMJHT.foobar", dc.DeviceCode())
+}
@@ -21,14 +21,16 @@ var blockList = map[string]bool{
 	consts.RemoteSecrets:         none,
 
 	// Only stack can manipulate them
-	consts.Sessions:         none,
-	consts.Permissions:      none,
-	consts.Intents:          none,
-	consts.OAuthClients:     none,
-	consts.OAuthAccessCodes: none,
-	consts.Archives:         none,
-	consts.Sharings:         none,
-	consts.Shared:           none,
+	consts.Sessions:             none,
+	consts.Permissions:          none,
+	consts.Intents:              none,
+	consts.OAuthClients:         none,
+	consts.OAuthAccessCodes:     none,
+	consts.OAuthDeviceCodes:     none,
+	consts.Archives:             none,
+	consts.Sharings:             none,
+	consts.Shared:               none,
+	consts.NotificationsSecrets: none,
 
 	// Synthetic doctypes (API only)
 	consts.CertifiedCarbonCopy:     none,
@@ -51,6 +53,7 @@ var blockList = map[string]bool{
 
 	// Only stack can write them
 	consts.Jobs:              readable,
+	consts.JobReports:        readable,
 	consts.Triggers:          readable,
 	consts.Apps:              readable,
 	consts.Konnectors:        readable,
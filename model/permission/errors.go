@@ -25,6 +25,11 @@ var (
 	ErrBadScope = echo.NewHTTPError(http.StatusBadRequest,
 		"Permission scope is empty or malformed")
 
+	// ErrInvalidFields is used when a rule has fields that can't be used
+	// for restricting the access to the documents
+	ErrInvalidFields = echo.NewHTTPError(http.StatusBadRequest,
+		"Invalid fields in the permission rules")
+
 	// ErrNotSubset is returned on requests attempting to create a Set of
 	// permissions which is not a subset of the request's own token.
 	ErrNotSubset = echo.NewHTTPError(http.StatusForbidden,
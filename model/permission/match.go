@@ -40,6 +40,10 @@ func matchOnFields(r Rule, o Fetcher, fields ...string) bool {
 }
 
 func matchVerb(r Rule, v Verb) bool {
+	// a rule that restricts the fields only allows to read the documents
+	if r.HasFieldsRestriction() && v != GET {
+		return false
+	}
 	return r.Verbs.Contains(v)
 }
 
@@ -62,6 +62,10 @@ const (
 	// TypeShareInteract is the value of Permission.Type for reading and
 	// writing a note in a shared folder.
 	TypeShareInteract = "share-interact"
+
+	// TypeAppPassword is the value of Permission.Type for the permissions of
+	// an app-specific password
+	TypeAppPassword = "app-password"
 )
 
 // ID implements jsonapi.Doc
@@ -464,6 +468,9 @@ func checkSetPermissions(set Set, parent *Permission) error {
 	if parent.Type != TypeWebapp && parent.Type != TypeKonnector && parent.Type != TypeOauth {
 		return ErrOnlyAppCanCreateSubSet
 	}
+	if err := set.CheckFields(); err != nil {
+		return err
+	}
 	if !set.IsSubSetOf(parent.Permissions) {
 		return ErrNotSubset
 	}
@@ -230,7 +230,7 @@ func TestStringToSet(t *testing.T) {
 	_, err := UnmarshalRuleString("")
 	assert.Error(t, err)
 
-	_, err = UnmarshalRuleString("type:verb:selec:value:wtf")
+	_, err = UnmarshalRuleString("type:verb:selec:value:fields:wtf")
 	assert.Error(t, err)
 
 	set, err := UnmarshalScopeString("io.cozy.contacts io.cozy.files:GET:io.cozy.files.music-dir")
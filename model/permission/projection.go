@@ -0,0 +1,333 @@
+package permission
+
+import (
+	"sort"
+	"strings"
+)
+
+// fieldsSep is used to separate the fields in the last part of a scope
+const fieldsSep = ","
+
+// excludedPrefix is used in a scope to mark a field as excluded
+const excludedPrefix = "-"
+
+// metaFields are the fields of a document that can always be read, even if
+// a rule restricts the fields.
+var metaFields = map[string]bool{
+	"_id":      true,
+	"_rev":     true,
+	"_deleted": true,
+	"_type":    true,
+}
+
+// Projection describes the fields of a document that can be read. When the
+// included fields are not nil, only those fields can be read (except the
+// excluded ones). Else, all the fields can be read except the excluded ones.
+type Projection struct {
+	Included map[string]bool
+	Excluded map[string]bool
+}
+
+// HasFieldsRestriction returns true if the rule restricts the fields of the
+// documents that can be read.
+func (r Rule) HasFieldsRestriction() bool {
+	return len(r.Fields) > 0 || len(r.ExcludedFields) > 0
+}
+
+// projection returns the projection of the rule, or nil if the rule allows
+// to read all the fields.
+func (r Rule) projection() *Projection {
+	if !r.HasFieldsRestriction() {
+		return nil
+	}
+	p := &Projection{Excluded: make(map[string]bool)}
+	for _, f := range r.ExcludedFields {
+		p.Excluded[f] = true
+	}
+	if len(r.Fields) > 0 {
+		p.Included = make(map[string]bool)
+		for _, f := range r.Fields {
+			if !p.Excluded[f] {
+				p.Included[f] = true
+			}
+		}
+		p.Excluded = make(map[string]bool)
+	}
+	return p
+}
+
+// validFields returns true if the fields of the rule are not empty, are not
+// the metadata fields, and are not both included and excluded.
+func (r Rule) validFields() bool {
+	for _, fields := range [][]string{r.Fields, r.ExcludedFields} {
+		for _, f := range fields {
+			if f == "" || metaFields[f] || strings.ContainsAny(f, partSep+ruleSep+fieldsSep) {
+				return false
+			}
+		}
+	}
+	for _, f := range r.Fields {
+		if contains(r.ExcludedFields, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckFields returns an error if a rule of the set has invalid fields.
+func (s Set) CheckFields() error {
+	for _, r := range s {
+		if !r.validFields() {
+			return ErrInvalidFields
+		}
+	}
+	return nil
+}
+
+// setProjection sets the fields of the rule from the projection.
+func (r *Rule) setProjection(p *Projection) {
+	r.Fields = nil
+	r.ExcludedFields = nil
+	if p == nil {
+		return
+	}
+	if p.Included != nil {
+		r.Fields = sortedKeys(p.Included)
+	} else {
+		r.ExcludedFields = sortedKeys(p.Excluded)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// marshalFields returns the fields of the rule for a scope, like
+// name,email,-phone
+func (r Rule) marshalFields() string {
+	fields := make([]string, 0, len(r.Fields)+len(r.ExcludedFields))
+	fields = append(fields, r.Fields...)
+	for _, f := range r.ExcludedFields {
+		fields = append(fields, excludedPrefix+f)
+	}
+	return strings.Join(fields, fieldsSep)
+}
+
+// unmarshalFields parses the fields of a rule in a scope
+func (r *Rule) unmarshalFields(in string) {
+	for _, f := range strings.Split(in, fieldsSep) {
+		if strings.HasPrefix(f, excludedPrefix) {
+			r.ExcludedFields = append(r.ExcludedFields, strings.TrimPrefix(f, excludedPrefix))
+		} else {
+			r.Fields = append(r.Fields, f)
+		}
+	}
+}
+
+// CanRead returns true if the field can be read with this projection.
+func (p *Projection) CanRead(field string) bool {
+	if p == nil || metaFields[field] {
+		return true
+	}
+	if p.Excluded[field] {
+		return false
+	}
+	return p.Included == nil || p.Included[field]
+}
+
+// Contains returns true if all the fields that can be read with the other
+// projection can also be read with this one.
+func (p *Projection) Contains(other *Projection) bool {
+	if p == nil {
+		return true
+	}
+	if other == nil {
+		return false
+	}
+	if other.Included == nil {
+		if p.Included != nil {
+			return false
+		}
+		for f := range p.Excluded {
+			if !other.Excluded[f] {
+				return false
+			}
+		}
+		return true
+	}
+	for f := range other.Included {
+		if other.CanRead(f) && !p.CanRead(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// union returns a projection with the fields that can be read with p or with
+// other.
+func (p *Projection) union(other *Projection) *Projection {
+	if p == nil || other == nil {
+		return nil
+	}
+	res := &Projection{Excluded: make(map[string]bool)}
+	switch {
+	case p.Included != nil && other.Included != nil:
+		res.Included = make(map[string]bool)
+		for _, proj := range []*Projection{p, other} {
+			for f := range proj.Included {
+				if proj.CanRead(f) {
+					res.Included[f] = true
+				}
+			}
+		}
+	case p.Included == nil && other.Included == nil:
+		for f := range p.Excluded {
+			if other.Excluded[f] {
+				res.Excluded[f] = true
+			}
+		}
+	default:
+		inc, exc := p, other
+		if inc.Included == nil {
+			inc, exc = other, p
+		}
+		for f := range exc.Excluded {
+			if !inc.CanRead(f) {
+				res.Excluded[f] = true
+			}
+		}
+	}
+	return res
+}
+
+// Apply returns a copy of the document with only the fields that can be
+// read.
+func (p *Projection) Apply(doc map[string]interface{}) map[string]interface{} {
+	if p == nil {
+		return doc
+	}
+	res := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if p.CanRead(k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// canReadPath returns true if the (possibly nested) field can be read with
+// the projection.
+func (p *Projection) canReadPath(field string) bool {
+	return p.CanRead(strings.SplitN(field, ".", 2)[0])
+}
+
+// CanReadQuery returns true if the mango query (its selector, sort and
+// fields) uses only fields that can be read, as the results would else leak
+// their values.
+func (p *Projection) CanReadQuery(req map[string]interface{}) bool {
+	if p == nil {
+		return true
+	}
+	if !p.canReadSelector(req["selector"]) {
+		return false
+	}
+	if order, ok := req["sort"].([]interface{}); ok {
+		for _, s := range order {
+			switch field := s.(type) {
+			case string:
+				if !p.canReadPath(field) {
+					return false
+				}
+			case map[string]interface{}:
+				for name := range field {
+					if !p.canReadPath(name) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	if fields, ok := req["fields"].([]interface{}); ok {
+		for _, f := range fields {
+			if name, ok := f.(string); ok && !p.canReadPath(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *Projection) canReadSelector(selector interface{}) bool {
+	switch s := selector.(type) {
+	case []interface{}:
+		for _, v := range s {
+			if !p.canReadSelector(v) {
+				return false
+			}
+		}
+	case map[string]interface{}:
+		for k, v := range s {
+			if !strings.HasPrefix(k, "$") && !p.canReadPath(k) {
+				return false
+			}
+			if !p.canReadSelector(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unionOfRules returns the projection for the rules that match the
+// predicate, or nil if one of them allows to read all the fields.
+func (s Set) unionOfRules(predicate func(Rule) bool) *Projection {
+	var res *Projection
+	for _, r := range s {
+		if !predicate(r) {
+			continue
+		}
+		p := r.projection()
+		if p == nil {
+			return nil
+		}
+		if res == nil {
+			res = p
+		} else {
+			res = res.union(p)
+		}
+	}
+	return res
+}
+
+// Projection returns the fields of the given document that can be read with
+// this set, or nil if all the fields can be read.
+func (s Set) Projection(o Fetcher) *Projection {
+	return s.unionOfRules(func(r Rule) bool {
+		return r.Verbs.Contains(GET) &&
+			MatchType(r, o.DocType()) &&
+			matchValues(r, o)
+	})
+}
+
+// ProjectionForType returns the fields that can be read for all the
+// documents of the given doctype, or nil if all the fields can be read.
+func (s Set) ProjectionForType(doctype string) *Projection {
+	return s.unionOfRules(func(r Rule) bool {
+		return r.Verbs.Contains(GET) &&
+			MatchType(r, doctype) &&
+			matchWholeType(r)
+	})
+}
+
+// HasFieldsRestriction returns true if a rule of the set for the given
+// doctype restricts the fields that can be read.
+func (s Set) HasFieldsRestriction(doctype string) bool {
+	return s.Some(func(r Rule) bool {
+		return MatchType(r, doctype) && r.HasFieldsRestriction()
+	})
+}
@@ -0,0 +1,152 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldsScopeString(t *testing.T) {
+	rule, err := UnmarshalRuleString("io.cozy.contacts:GET:::fullname,email,-phone")
+	assert.NoError(t, err)
+	assert.Equal(t, "io.cozy.contacts", rule.Type)
+	assert.Equal(t, Verbs(GET), rule.Verbs)
+	assert.Nil(t, rule.Values)
+	assert.Equal(t, "", rule.Selector)
+	assert.Equal(t, []string{"fullname", "email"}, rule.Fields)
+	assert.Equal(t, []string{"phone"}, rule.ExcludedFields)
+
+	out, err := rule.MarshalScopeString()
+	assert.NoError(t, err)
+	assert.Equal(t, "io.cozy.contacts:GET:::fullname,email,-phone", out)
+
+	rule, err = UnmarshalRuleString("io.cozy.bank.operations:GET:foo,bar:account:-amount")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, rule.Values)
+	assert.Equal(t, "account", rule.Selector)
+	assert.Nil(t, rule.Fields)
+	assert.Equal(t, []string{"amount"}, rule.ExcludedFields)
+
+	_, err = UnmarshalRuleString("io.cozy.contacts:GET:::_rev")
+	assert.Error(t, err)
+	_, err = UnmarshalRuleString("io.cozy.contacts:GET:::name,,email")
+	assert.Error(t, err)
+	_, err = UnmarshalRuleString("io.cozy.contacts:GET:::name,-name")
+	assert.Error(t, err)
+}
+
+func TestFieldsRestrictionOnlyForReading(t *testing.T) {
+	s := Set{Rule{Type: "io.cozy.contacts", Fields: []string{"fullname"}}}
+	doc := &validable{doctype: "io.cozy.contacts"}
+	assert.True(t, s.Allow(GET, doc))
+	assert.False(t, s.Allow(PUT, doc))
+	assert.False(t, s.AllowWholeType(POST, "io.cozy.contacts"))
+	assert.True(t, s.HasFieldsRestriction("io.cozy.contacts"))
+	assert.False(t, s.HasFieldsRestriction("io.cozy.files"))
+}
+
+func TestProjection(t *testing.T) {
+	doc := map[string]interface{}{
+		"_id":      "123",
+		"_rev":     "1-abc",
+		"fullname": "Alice",
+		"email":    "alice@example.net",
+		"phone":    "0123456789",
+	}
+
+	s := Set{Rule{Type: "io.cozy.contacts", Fields: []string{"fullname", "email"}}}
+	p := s.ProjectionForType("io.cozy.contacts")
+	assert.Equal(t, map[string]interface{}{
+		"_id":      "123",
+		"_rev":     "1-abc",
+		"fullname": "Alice",
+		"email":    "alice@example.net",
+	}, p.Apply(doc))
+
+	s = Set{Rule{Type: "io.cozy.contacts", ExcludedFields: []string{"phone"}}}
+	p = s.ProjectionForType("io.cozy.contacts")
+	assert.True(t, p.CanRead("fullname"))
+	assert.False(t, p.CanRead("phone"))
+	assert.Len(t, p.Apply(doc), 4)
+
+	// The union of the rules is used
+	s = Set{
+		Rule{Type: "io.cozy.contacts", Fields: []string{"fullname"}},
+		Rule{Type: "io.cozy.contacts", ExcludedFields: []string{"phone", "email"}},
+	}
+	p = s.ProjectionForType("io.cozy.contacts")
+	assert.True(t, p.CanRead("fullname"))
+	assert.True(t, p.CanRead("address"))
+	assert.False(t, p.CanRead("email"))
+
+	s = append(s, Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET)})
+	assert.Nil(t, s.ProjectionForType("io.cozy.contacts"))
+	assert.Equal(t, doc, s.ProjectionForType("io.cozy.contacts").Apply(doc))
+
+	// The rules on some documents only apply to those documents
+	s = Set{
+		Rule{Type: "io.cozy.contacts", Fields: []string{"fullname"}},
+		Rule{Type: "io.cozy.contacts", Values: []string{"123"}},
+	}
+	assert.Nil(t, s.Projection(&validable{id: "123", doctype: "io.cozy.contacts"}))
+	p = s.Projection(&validable{id: "456", doctype: "io.cozy.contacts"})
+	assert.False(t, p.CanRead("email"))
+}
+
+func TestCanReadQuery(t *testing.T) {
+	s := Set{Rule{Type: "io.cozy.contacts", Fields: []string{"fullname", "email"}}}
+	p := s.ProjectionForType("io.cozy.contacts")
+	assert.True(t, p.CanReadQuery(map[string]interface{}{
+		"selector": map[string]interface{}{"email.address": map[string]interface{}{"$gt": nil}},
+		"sort":     []interface{}{"fullname"},
+	}))
+	assert.False(t, p.CanReadQuery(map[string]interface{}{
+		"selector": map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"fullname": "Alice"},
+			map[string]interface{}{"phone": "0123456789"},
+		}},
+	}))
+	assert.False(t, p.CanReadQuery(map[string]interface{}{
+		"selector": map[string]interface{}{},
+		"sort":     []interface{}{map[string]interface{}{"phone": "asc"}},
+	}))
+
+	var nilProjection *Projection
+	assert.True(t, nilProjection.CanReadQuery(map[string]interface{}{
+		"selector": map[string]interface{}{"phone": "0123456789"},
+	}))
+}
+
+func TestSubsetWithFields(t *testing.T) {
+	all := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET)}}
+	some := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), Fields: []string{"fullname", "email"}}}
+	one := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), Fields: []string{"email"}}}
+	notPhone := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), ExcludedFields: []string{"phone"}}}
+
+	assert.True(t, some.IsSubSetOf(all))
+	assert.False(t, all.IsSubSetOf(some))
+	assert.True(t, one.IsSubSetOf(some))
+	assert.False(t, some.IsSubSetOf(one))
+	assert.True(t, some.IsSubSetOf(notPhone))
+	assert.False(t, notPhone.IsSubSetOf(some))
+
+	notPhoneNorEmail := Set{Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), ExcludedFields: []string{"phone", "email"}}}
+	assert.True(t, notPhoneNorEmail.IsSubSetOf(notPhone))
+	assert.False(t, notPhone.IsSubSetOf(notPhoneNorEmail))
+	assert.False(t, some.IsSubSetOf(notPhoneNorEmail))
+}
+
+func TestMergeRulesWithFields(t *testing.T) {
+	r1 := Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), Fields: []string{"fullname"}}
+	r2 := Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET), Fields: []string{"email"}}
+	merged, err := r1.Merge(r2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"email", "fullname"}, merged.Fields)
+	assert.Nil(t, merged.ExcludedFields)
+
+	r3 := Rule{Type: "io.cozy.contacts", Verbs: Verbs(GET)}
+	merged, err = r2.Merge(r3)
+	assert.NoError(t, err)
+	assert.Nil(t, merged.Fields)
+	assert.Nil(t, merged.ExcludedFields)
+}
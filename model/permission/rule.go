@@ -33,28 +33,42 @@ type Rule struct {
 	// Selector is the field which must be one of Values.
 	Selector string   `json:"selector,omitempty"`
 	Values   []string `json:"values,omitempty"`
+
+	// Fields is the list of the fields of the documents that can be read. If
+	// it is empty, all the fields can be read.
+	Fields []string `json:"fields,omitempty"`
+
+	// ExcludedFields is the list of the fields of the documents that can't
+	// be read.
+	ExcludedFields []string `json:"excluded_fields,omitempty"`
 }
 
 // MarshalScopeString transform a Rule into a string of the shape
-// io.cozy.files:GET:io.cozy.files.music-dir
+// io.cozy.files:GET:io.cozy.files.music-dir, or
+// io.cozy.contacts:GET:::fullname,email,-phone when the fields are restricted
 func (r Rule) MarshalScopeString() (string, error) {
 	out := r.Type
 	hasVerbs := len(r.Verbs) != 0
 	hasValues := len(r.Values) != 0
 	hasSelector := r.Selector != ""
+	hasFields := r.HasFieldsRestriction()
 
-	if hasVerbs || hasValues || hasSelector {
+	if hasVerbs || hasValues || hasSelector || hasFields {
 		out += partSep + r.Verbs.String()
 	}
 
-	if hasValues {
+	if hasValues || hasFields {
 		out += partSep + strings.Join(r.Values, valueSep)
 	}
 
-	if hasSelector {
+	if hasSelector || hasFields {
 		out += partSep + r.Selector
 	}
 
+	if hasFields {
+		out += partSep + r.marshalFields()
+	}
+
 	return out, nil
 }
 
@@ -63,11 +77,19 @@ func UnmarshalRuleString(in string) (Rule, error) {
 	var out Rule
 	parts := strings.Split(in, partSep)
 	switch len(parts) {
+	case 5:
+		out.unmarshalFields(parts[4])
+		if !out.validFields() {
+			return out, ErrBadScope
+		}
+		fallthrough
 	case 4:
 		out.Selector = parts[3]
 		fallthrough
 	case 3:
-		out.Values = strings.Split(parts[2], valueSep)
+		if parts[2] != "" || len(parts) < 5 {
+			out.Values = strings.Split(parts[2], valueSep)
+		}
 		fallthrough
 	case 2:
 		out.Verbs = VerbSplit(parts[1])
@@ -174,5 +196,8 @@ func (r Rule) Merge(r2 Rule) (*Rule, error) {
 		}
 	}
 
+	// Fields
+	newRule.setProjection(r.projection().union(r2.projection()))
+
 	return newRule, nil
 }
@@ -157,6 +157,10 @@ func (s *Set) RuleInSubset(r2 Rule) bool {
 			continue
 		}
 
+		if !r.projection().Contains(r2.projection()) {
+			continue
+		}
+
 		if r.Selector == "" && len(r.Values) == 0 {
 			return true
 		}
@@ -196,6 +200,8 @@ func (s Set) HasSameRules(other Set) bool {
 		for _, otherRule := range other {
 			if reflect.DeepEqual(rule.Values, otherRule.Values) &&
 				rule.Selector == otherRule.Selector &&
+				reflect.DeepEqual(rule.Fields, otherRule.Fields) &&
+				reflect.DeepEqual(rule.ExcludedFields, otherRule.ExcludedFields) &&
 				rule.Verbs.ContainsAll(otherRule.Verbs) &&
 				otherRule.Verbs.ContainsAll(rule.Verbs) &&
 				reflect.DeepEqual(otherRule.Type, rule.Type) {
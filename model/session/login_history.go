@@ -30,6 +30,9 @@ type LoginEntry struct {
 	City        string `json:"city,omitempty"`
 	Subdivision string `json:"subdivision,omitempty"`
 	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	// NewCountry is true for the first login from this country
+	NewCountry bool `json:"new_country,omitempty"`
 	// XXX No omitempty on os and browser, because they are indexed in couchdb
 	UA                 string    `json:"user_agent"`
 	OS                 string    `json:"os"`
@@ -59,7 +62,16 @@ func (l *LoginEntry) Clone() couchdb.Doc {
 	return &clone
 }
 
-func lookupIP(ip, locale string) (city, subdivision, country, timezone string) {
+// location is the result of the lookup of an IP address in the geodb.
+type location struct {
+	City        string
+	Subdivision string
+	Country     string
+	CountryCode string
+	Timezone    string
+}
+
+func lookupIP(ip, locale string) (loc location) {
 	geodb := config.GetConfig().GeoDB
 	if geodb == "" {
 		return
@@ -79,7 +91,8 @@ func lookupIP(ip, locale string) (city, subdivision, country, timezone string) {
 			Names map[string]string `maxminddb:"names"`
 		} `maxminddb:"subdivisions"`
 		Country struct {
-			Names map[string]string `maxminddb:"names"`
+			ISOCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
 		} `maxminddb:"country"`
 		Location struct {
 			TimeZone string `maxminddb:"time_zone"`
@@ -92,40 +105,91 @@ func lookupIP(ip, locale string) (city, subdivision, country, timezone string) {
 		return
 	}
 	if c, ok := record.City.Names[locale]; ok {
-		city = c
+		loc.City = c
 	} else if c, ok := record.City.Names["en"]; ok {
-		city = c
+		loc.City = c
 	}
 	if len(record.Subdivisions) > 0 {
 		if s, ok := record.Subdivisions[0].Names[locale]; ok {
-			subdivision = s
+			loc.Subdivision = s
 		} else if s, ok := record.Subdivisions[0].Names["en"]; ok {
-			city = s
+			loc.City = s
 		}
 	}
 	if c, ok := record.Country.Names[locale]; ok {
-		country = c
+		loc.Country = c
 	} else if c, ok := record.Country.Names["en"]; ok {
-		country = c
+		loc.Country = c
 	}
-	timezone = record.Location.TimeZone
+	loc.CountryCode = record.Country.ISOCode
+	loc.Timezone = record.Location.TimeZone
 	return
 }
 
-// StoreNewLoginEntry creates a new login entry in the database associated with
-// the given instance.
-func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
-	req *http.Request, logMessage string, notifEnabled bool,
-) error {
+// clientIP returns the IP address of the client that has sent the request.
+// When the stack is behind a reverse proxy, the last address of the
+// X-Forwarded-For header is the one added by this proxy. The addresses
+// before it are sent by the client, and can be forged.
+func clientIP(req *http.Request) string {
 	var ip string
-	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
+	if values := req.Header["X-Forwarded-For"]; len(values) > 0 {
+		hops := strings.Split(values[len(values)-1], ",")
+		ip = strings.TrimSpace(hops[len(hops)-1])
 	}
 	if ip == "" {
 		ip = strings.Split(req.RemoteAddr, ":")[0]
 	}
+	return ip
+}
+
+// IsFromNewCountry returns true if the request comes from a country where the
+// user has never logged in before. It can be used to ask for a second factor,
+// even if the two-factor authentication is not enabled. The first login with
+// a known location is not considered as coming from a new country.
+func IsFromNewCountry(i *instance.Instance, req *http.Request) bool {
+	loc := lookupIP(clientIP(req), i.Locale)
+	return isNewCountry(i, loc.CountryCode)
+}
 
-	city, subdivision, country, timezone := lookupIP(ip, i.Locale)
+// NeedsTwoFactor returns true if a second factor must be asked after the
+// passphrase for a login: when the two-factor authentication is enabled, or
+// when the login comes from a new country. It must be used by all the logins
+// with the passphrase.
+func NeedsTwoFactor(i *instance.Instance, req *http.Request) bool {
+	return i.HasTwoFactor() || IsFromNewCountry(i, req)
+}
+
+func isNewCountry(i *instance.Instance, countryCode string) bool {
+	if countryCode == "" {
+		return false
+	}
+	var results []*LoginEntry
+	r := &couchdb.FindRequest{
+		UseIndex: "by-country-code",
+		Selector: mango.Equal("country_code", countryCode),
+		Limit:    1,
+	}
+	if err := couchdb.FindDocs(i, consts.SessionsLogins, r, &results); err != nil || len(results) > 0 {
+		return false
+	}
+	r = &couchdb.FindRequest{
+		UseIndex: "by-country-code",
+		Selector: mango.Exists("country_code"),
+		Limit:    1,
+	}
+	if err := couchdb.FindDocs(i, consts.SessionsLogins, r, &results); err != nil {
+		return false
+	}
+	return len(results) > 0
+}
+
+// StoreNewLoginEntry creates a new login entry in the database associated with
+// the given instance.
+func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
+	req *http.Request, logMessage string, notifEnabled bool,
+) error {
+	ip := clientIP(req)
+	loc := lookupIP(ip, i.Locale)
 	ua := user_agent.New(req.UserAgent())
 
 	browser, _ := ua.Browser()
@@ -134,24 +198,26 @@ func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string,
 	createdAt := time.Now()
 	i.Logger().WithField("nspace", "loginaudit").
 		Infof("New connection from %s at %s (%s)", ip, createdAt, logMessage)
-	if timezone != "" {
-		if loc, err := time.LoadLocation(timezone); err == nil {
-			createdAt = createdAt.In(loc)
+	if loc.Timezone != "" {
+		if tz, err := time.LoadLocation(loc.Timezone); err == nil {
+			createdAt = createdAt.In(tz)
 		}
 	}
 
 	l := &LoginEntry{
 		IP:                 ip,
 		SessionID:          sessionID,
-		City:               city,
-		Subdivision:        subdivision,
-		Country:            country,
+		City:               loc.City,
+		Subdivision:        loc.Subdivision,
+		Country:            loc.Country,
+		CountryCode:        loc.CountryCode,
 		UA:                 req.UserAgent(),
 		OS:                 os,
 		Browser:            browser,
 		ClientRegistration: clientID != "",
 		CreatedAt:          createdAt,
 	}
+	l.NewCountry = isNewCountry(i, l.CountryCode)
 
 	if err := couchdb.CreateDoc(i, l); err != nil {
 		return err
@@ -193,7 +259,7 @@ func sendLoginNotification(i *instance.Instance, l *LoginEntry) error {
 		changePassphraseLink = i.ChangePasswordURL()
 	}
 	var activateTwoFALink string
-	if !i.HasAuthMode(instance.TwoFactorMail) {
+	if !i.HasTwoFactor() {
 		settingsURL := i.SubDomain(consts.SettingsSlug)
 		settingsURL.Fragment = "/profile"
 		activateTwoFALink = settingsURL.String()
@@ -0,0 +1,45 @@
+package session
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://alice.cozy.example.net/", nil)
+	req.RemoteAddr = "192.0.2.1:34567"
+	assert.Equal(t, "192.0.2.1", clientIP(req))
+
+	// Only the address added by the reverse proxy is trusted
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.42")
+	assert.Equal(t, "198.51.100.42", clientIP(req))
+	req.Header.Add("X-Forwarded-For", "198.51.100.43")
+	assert.Equal(t, "198.51.100.43", clientIP(req))
+}
+
+func TestIsNewCountry(t *testing.T) {
+	inst := &instance.Instance{Domain: "new-country.cozy.example.net"}
+	_ = couchdb.DeleteDB(inst, consts.SessionsLogins)
+	require.NoError(t, couchdb.CreateDB(inst, consts.SessionsLogins))
+	defer func() {
+		_ = couchdb.DeleteDB(inst, consts.SessionsLogins)
+	}()
+	for _, index := range couchdb.IndexesByDoctype(consts.SessionsLogins) {
+		require.NoError(t, couchdb.DefineIndex(inst, index))
+	}
+
+	// The first login with a known location is not from a new country
+	assert.False(t, isNewCountry(inst, "FR"))
+	require.NoError(t, couchdb.CreateDoc(inst, &LoginEntry{CountryCode: "FR"}))
+	assert.False(t, isNewCountry(inst, "FR"))
+	assert.False(t, isNewCountry(inst, ""))
+	assert.True(t, isNewCountry(inst, "BR"))
+	require.NoError(t, couchdb.CreateDoc(inst, &LoginEntry{CountryCode: "BR"}))
+	assert.False(t, isNewCountry(inst, "BR"))
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/mssola/user_agent"
 )
 
 // SessionMaxAge is the maximum duration of the session in seconds
@@ -40,6 +41,12 @@ type Session struct {
 	CreatedAt time.Time `json:"created_at"`
 	LastSeen  time.Time `json:"last_seen"`
 	LongRun   bool      `json:"long_run"`
+	// Informations about the device where the session has been opened
+	UA      string `json:"user_agent,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Browser string `json:"browser,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Country string `json:"country,omitempty"`
 }
 
 // DocType implements couchdb.Doc
@@ -75,8 +82,9 @@ func (s *Session) OlderThan(t time.Duration) bool {
 	return time.Now().After(s.LastSeen.Add(t))
 }
 
-// New creates a session in couchdb for the given instance
-func New(i *instance.Instance, longRun bool) (*Session, error) {
+// New creates a session in couchdb for the given instance. The request, if
+// not nil, is used to know the device where the session is opened.
+func New(i *instance.Instance, longRun bool, req *http.Request) (*Session, error) {
 	now := time.Now()
 	s := &Session{
 		instance:  i,
@@ -84,6 +92,14 @@ func New(i *instance.Instance, longRun bool) (*Session, error) {
 		CreatedAt: now,
 		LongRun:   longRun,
 	}
+	if req != nil {
+		ua := user_agent.New(req.UserAgent())
+		s.UA = req.UserAgent()
+		s.OS = ua.OS()
+		s.Browser, _ = ua.Browser()
+		s.IP = clientIP(req)
+		s.Country = lookupIP(s.IP, i.Locale).Country
+	}
 	if err := couchdb.CreateDoc(i, s); err != nil {
 		return nil, err
 	}
@@ -183,6 +199,21 @@ func GetAll(inst *instance.Instance) ([]*Session, error) {
 	return sessions, nil
 }
 
+// GetByID returns the session with the given ID, or ErrInvalidID if there is
+// no such session.
+func GetByID(inst *instance.Instance, sessionID string) (*Session, error) {
+	s := &Session{}
+	err := couchdb.GetDoc(inst, consts.Sessions, sessionID, s)
+	if couchdb.IsNotFoundError(err) {
+		return nil, ErrInvalidID
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.instance = inst
+	return s, nil
+}
+
 // Delete is a function to delete the session in couchdb,
 // and returns a cookie with a negative MaxAge to clear it
 func (s *Session) Delete(i *instance.Instance) *http.Cookie {
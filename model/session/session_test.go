@@ -2,17 +2,25 @@ package session
 
 import (
 	"encoding/base64"
+	"fmt"
 	"os"
 	"testing"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
 )
 
 var JWTSecret = []byte("foobar")
 
 func TestMain(m *testing.M) {
 	config.UseTestFile()
+
+	if _, err := couchdb.CheckStatus(); err != nil {
+		fmt.Println("This test need couchdb to run.")
+		os.Exit(1)
+	}
+
 	conf := config.GetConfig()
 	conf.Authentication = make(map[string]interface{})
 	confAuth := make(map[string]interface{})
@@ -0,0 +1,436 @@
+package sharing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The delta transfer is inspired by the rsync algorithm. When a shared file
+// is updated, the instance that receives the update computes a signature of
+// its current version of the file: a weak rolling checksum and a strong
+// checksum for each block of this version. The instance that sends the
+// update uses this signature to find the blocks that are already known by the
+// other side, and it sends only the instructions for copying those blocks and
+// the data that has changed.
+//
+// The delta has the following binary format:
+//
+//   - a header with the deltaMagic string, the block size (uvarint) and the
+//     md5sum of the base version (16 bytes)
+//   - a list of operations, each starting with a byte for its type:
+//       - deltaOpCopy, followed by the index of the first block and the
+//         number of consecutive blocks to copy (uvarints)
+//       - deltaOpLiteral, followed by the length (uvarint) and the data
+//   - deltaOpEnd
+
+const (
+	// minDeltaSize is the minimal size of a file for using the delta transfer
+	minDeltaSize = 64 * 1024
+	// maxDeltaSize is the maximal size of the base version for computing a
+	// signature
+	maxDeltaSize = 1 << 30
+
+	minBlockSize   = 2 * 1024
+	maxBlockSize   = 256 * 1024
+	maxLiteralSize = 256 * 1024
+
+	deltaMagic     = "COZYDELTA1"
+	deltaOpCopy    = 'C'
+	deltaOpLiteral = 'L'
+	deltaOpEnd     = 'E'
+)
+
+// ErrInvalidDelta is used when a delta cannot be applied to a file
+var ErrInvalidDelta = errors.New("The delta cannot be applied")
+
+// Signature is the list of checksums of the blocks of a file. It is used to
+// compute a delta with a new version of this file.
+type Signature struct {
+	BlockSize int              `json:"block_size"`
+	Size      int64            `json:"size"`
+	MD5Sum    []byte           `json:"md5sum"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// BlockSignature contains the weak and strong checksums for a block.
+type BlockSignature struct {
+	Weak   uint32 `json:"weak"`
+	Strong []byte `json:"strong"`
+}
+
+// blockSizeFor returns the size of the blocks for a file of the given size:
+// roughly the square root of the size, like rsync.
+func blockSizeFor(size int64) int {
+	bs := minBlockSize
+	for bs < maxBlockSize && int64(bs)*int64(bs) < size {
+		bs *= 2
+	}
+	return bs
+}
+
+// weakChecksum is the rolling checksum used by rsync.
+type weakChecksum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newWeakChecksum(block []byte) *weakChecksum {
+	w := &weakChecksum{n: uint32(len(block))}
+	for i, c := range block {
+		w.a += uint32(c)
+		w.b += uint32(len(block)-i) * uint32(c)
+	}
+	return w
+}
+
+func (w *weakChecksum) sum() uint32 {
+	return (w.a & 0xffff) | (w.b << 16)
+}
+
+// roll removes the out byte from the start of the window, and adds the in
+// byte at its end.
+func (w *weakChecksum) roll(out, in byte) {
+	w.a = w.a - uint32(out) + uint32(in)
+	w.b = w.b - w.n*uint32(out) + w.a
+}
+
+func strongChecksum(block []byte) []byte {
+	sum := md5.Sum(block)
+	return sum[:]
+}
+
+// ComputeSignature reads the content of a file, and computes the checksums of
+// its blocks.
+func ComputeSignature(r io.Reader, size int64, md5sum []byte) (*Signature, error) {
+	bs := blockSizeFor(size)
+	sig := &Signature{
+		BlockSize: bs,
+		Size:      size,
+		MD5Sum:    md5sum,
+		Blocks:    make([]BlockSignature, 0, size/int64(bs)+1),
+	}
+	buf := make([]byte, bs)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Blocks = append(sig.Blocks, BlockSignature{
+				Weak:   newWeakChecksum(buf[:n]).sum(),
+				Strong: strongChecksum(buf[:n]),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deltaWriter writes the operations of a delta, and merges the consecutive
+// copies.
+type deltaWriter struct {
+	w         *bufio.Writer
+	literal   []byte
+	copyStart int
+	copyCount int
+	varint    [binary.MaxVarintLen64]byte
+}
+
+func (dw *deltaWriter) writeUvarint(x uint64) error {
+	n := binary.PutUvarint(dw.varint[:], x)
+	_, err := dw.w.Write(dw.varint[:n])
+	return err
+}
+
+func (dw *deltaWriter) flushCopy() error {
+	if dw.copyCount == 0 {
+		return nil
+	}
+	if err := dw.w.WriteByte(deltaOpCopy); err != nil {
+		return err
+	}
+	if err := dw.writeUvarint(uint64(dw.copyStart)); err != nil {
+		return err
+	}
+	if err := dw.writeUvarint(uint64(dw.copyCount)); err != nil {
+		return err
+	}
+	dw.copyCount = 0
+	return nil
+}
+
+func (dw *deltaWriter) flushLiteral() error {
+	if len(dw.literal) == 0 {
+		return nil
+	}
+	if err := dw.w.WriteByte(deltaOpLiteral); err != nil {
+		return err
+	}
+	if err := dw.writeUvarint(uint64(len(dw.literal))); err != nil {
+		return err
+	}
+	if _, err := dw.w.Write(dw.literal); err != nil {
+		return err
+	}
+	dw.literal = dw.literal[:0]
+	return nil
+}
+
+func (dw *deltaWriter) addCopy(index int) error {
+	if err := dw.flushLiteral(); err != nil {
+		return err
+	}
+	if dw.copyCount > 0 && dw.copyStart+dw.copyCount == index {
+		dw.copyCount++
+		return nil
+	}
+	if err := dw.flushCopy(); err != nil {
+		return err
+	}
+	dw.copyStart = index
+	dw.copyCount = 1
+	return nil
+}
+
+func (dw *deltaWriter) addLiteral(data ...byte) error {
+	if err := dw.flushCopy(); err != nil {
+		return err
+	}
+	dw.literal = append(dw.literal, data...)
+	if len(dw.literal) >= maxLiteralSize {
+		return dw.flushLiteral()
+	}
+	return nil
+}
+
+// addTail is called at the end of the new version, when the window has not
+// matched a block: the end of the window can still match the last block of
+// the base version if it is shorter than the others.
+func (dw *deltaWriter) addTail(sig *Signature, window []byte) error {
+	last := len(sig.Blocks) - 1
+	if last >= 0 {
+		size := blockLen(sig, last)
+		if size < len(window) {
+			tail := window[len(window)-size:]
+			if newWeakChecksum(tail).sum() == sig.Blocks[last].Weak &&
+				bytes.Equal(sig.Blocks[last].Strong, strongChecksum(tail)) {
+				if err := dw.addLiteral(window[:len(window)-size]...); err != nil {
+					return err
+				}
+				return dw.addCopy(last)
+			}
+		}
+	}
+	return dw.addLiteral(window...)
+}
+
+// isValid returns true if the signature can be used to compute a delta. It
+// comes from the other instance, and its block size is used for allocating
+// the buffers.
+func (sig *Signature) isValid() bool {
+	bs := int64(sig.BlockSize)
+	if bs <= 0 || bs > maxBlockSize || sig.Size < 0 || sig.Size > maxDeltaSize {
+		return false
+	}
+	if int64(len(sig.Blocks)) > (sig.Size+bs-1)/bs {
+		return false
+	}
+	for _, block := range sig.Blocks {
+		if len(block.Strong) != md5.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// ComputeDelta reads the new version of a file, and writes the delta with the
+// base version described by the signature.
+func ComputeDelta(sig *Signature, r io.Reader, w io.Writer) error {
+	if !sig.isValid() {
+		return ErrInvalidDelta
+	}
+	bs := sig.BlockSize
+	weaks := make(map[uint32][]int)
+	for i, block := range sig.Blocks {
+		weaks[block.Weak] = append(weaks[block.Weak], i)
+	}
+	findBlock := func(weak uint32, window []byte) int {
+		var strong []byte
+		for _, i := range weaks[weak] {
+			if strong == nil {
+				strong = strongChecksum(window)
+			}
+			if bytes.Equal(sig.Blocks[i].Strong, strong) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	dw := &deltaWriter{
+		w:       bufio.NewWriter(w),
+		literal: make([]byte, 0, maxLiteralSize),
+	}
+	if _, err := dw.w.WriteString(deltaMagic); err != nil {
+		return err
+	}
+	if err := dw.writeUvarint(uint64(bs)); err != nil {
+		return err
+	}
+	var md5sum [md5.Size]byte
+	copy(md5sum[:], sig.MD5Sum)
+	if _, err := dw.w.Write(md5sum[:]); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, 2*bs)
+	readWindow := func() ([]byte, error) {
+		window := make([]byte, bs, 2*bs)
+		n, err := io.ReadFull(br, window)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return window[:n], err
+	}
+
+	window, err := readWindow()
+	if err != nil {
+		return err
+	}
+	weak := newWeakChecksum(window)
+	for len(window) > 0 {
+		if i := findBlock(weak.sum(), window); i >= 0 && len(window) == blockLen(sig, i) {
+			if err := dw.addCopy(i); err != nil {
+				return err
+			}
+			if window, err = readWindow(); err != nil {
+				return err
+			}
+			weak = newWeakChecksum(window)
+			continue
+		}
+
+		in, err := br.ReadByte()
+		if err == io.EOF {
+			if err := dw.addTail(sig, window); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		out := window[0]
+		if err := dw.addLiteral(out); err != nil {
+			return err
+		}
+		weak.roll(out, in)
+		window = append(window[1:], in)
+	}
+
+	if err := dw.flushCopy(); err != nil {
+		return err
+	}
+	if err := dw.flushLiteral(); err != nil {
+		return err
+	}
+	if err := dw.w.WriteByte(deltaOpEnd); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// blockLen returns the length of the block with the given index.
+func blockLen(sig *Signature, index int) int {
+	if index == len(sig.Blocks)-1 {
+		if rest := int(sig.Size % int64(sig.BlockSize)); rest > 0 {
+			return rest
+		}
+	}
+	return sig.BlockSize
+}
+
+// ApplyDelta rebuilds the new version of a file from the base version and the
+// delta, and writes it.
+func ApplyDelta(base io.ReaderAt, baseSize int64, baseMD5 []byte, delta io.Reader, w io.Writer) error {
+	r := bufio.NewReader(delta)
+	bs, err := readDeltaHeader(r, baseMD5)
+	if err != nil {
+		return err
+	}
+	return applyDeltaOps(base, baseSize, bs, r, w)
+}
+
+// readDeltaHeader reads the header of a delta, and checks that the delta has
+// been computed for the given base version. It returns the block size.
+func readDeltaHeader(r *bufio.Reader, baseMD5 []byte) (int64, error) {
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return 0, ErrInvalidDelta
+	}
+	bs, err := binary.ReadUvarint(r)
+	if err != nil || bs == 0 || bs > maxBlockSize {
+		return 0, ErrInvalidDelta
+	}
+	md5sum := make([]byte, md5.Size)
+	if _, err = io.ReadFull(r, md5sum); err != nil {
+		return 0, ErrInvalidDelta
+	}
+	if !bytes.Equal(md5sum, baseMD5) {
+		return 0, ErrInvalidDelta
+	}
+	return int64(bs), nil
+}
+
+// applyDeltaOps reads the operations of a delta (after its header), and
+// writes the content that they describe.
+func applyDeltaOps(base io.ReaderAt, baseSize, bs int64, r *bufio.Reader, w io.Writer) error {
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return ErrInvalidDelta
+		}
+		switch op {
+		case deltaOpCopy:
+			start, err := binary.ReadUvarint(r)
+			if err != nil {
+				return ErrInvalidDelta
+			}
+			count, err := binary.ReadUvarint(r)
+			if err != nil {
+				return ErrInvalidDelta
+			}
+			offset := int64(start) * bs
+			length := int64(count) * bs
+			if count == 0 || offset >= baseSize {
+				return ErrInvalidDelta
+			}
+			if offset+length > baseSize {
+				length = baseSize - offset
+			}
+			if _, err = io.Copy(w, io.NewSectionReader(base, offset, length)); err != nil {
+				return err
+			}
+		case deltaOpLiteral:
+			length, err := binary.ReadUvarint(r)
+			if err != nil || length > maxLiteralSize {
+				return ErrInvalidDelta
+			}
+			if _, err = io.CopyN(w, r, int64(length)); err != nil {
+				if err == io.EOF {
+					return ErrInvalidDelta
+				}
+				return err
+			}
+		case deltaOpEnd:
+			return nil
+		default:
+			return ErrInvalidDelta
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package sharing
+
+import (
+	"bytes"
+	"crypto/md5"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func roundTripDelta(t *testing.T, base, updated []byte) int {
+	baseMD5 := md5.Sum(base)
+	sig, err := ComputeSignature(bytes.NewReader(base), int64(len(base)), baseMD5[:])
+	assert.NoError(t, err)
+
+	delta := &bytes.Buffer{}
+	err = ComputeDelta(sig, bytes.NewReader(updated), delta)
+	assert.NoError(t, err)
+	size := delta.Len()
+
+	result := &bytes.Buffer{}
+	err = ApplyDelta(bytes.NewReader(base), int64(len(base)), baseMD5[:], delta, result)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(updated, result.Bytes()))
+	return size
+}
+
+func TestDelta(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	base := make([]byte, 300*1024+123)
+	rng.Read(base)
+
+	// Same content
+	size := roundTripDelta(t, base, base)
+	assert.True(t, size < 1024)
+
+	// Some bytes changed in the middle
+	updated := make([]byte, len(base))
+	copy(updated, base)
+	copy(updated[100000:], []byte("hello world"))
+	size = roundTripDelta(t, base, updated)
+	assert.True(t, size < 20*1024)
+
+	// Some bytes inserted at the beginning
+	updated = append([]byte("inserted"), base...)
+	size = roundTripDelta(t, base, updated)
+	assert.True(t, size < 20*1024)
+
+	// Truncated and appended
+	updated = append([]byte{}, base[:200000]...)
+	updated = append(updated, []byte("the end")...)
+	size = roundTripDelta(t, base, updated)
+	assert.True(t, size < 20*1024)
+
+	// Completely different content
+	other := make([]byte, 100*1024)
+	rng.Read(other)
+	roundTripDelta(t, base, other)
+
+	// Empty contents
+	roundTripDelta(t, base, []byte{})
+	roundTripDelta(t, []byte{}, other)
+}
+
+func TestDeltaWithAnotherBase(t *testing.T) {
+	base := bytes.Repeat([]byte("foo bar baz "), 10000)
+	baseMD5 := md5.Sum(base)
+	sig, err := ComputeSignature(bytes.NewReader(base), int64(len(base)), baseMD5[:])
+	assert.NoError(t, err)
+	delta := &bytes.Buffer{}
+	err = ComputeDelta(sig, bytes.NewReader(append(base, 'x')), delta)
+	assert.NoError(t, err)
+
+	other := bytes.Repeat([]byte("qux "), 10000)
+	otherMD5 := md5.Sum(other)
+	err = ApplyDelta(bytes.NewReader(other), int64(len(other)), otherMD5[:], delta, &bytes.Buffer{})
+	assert.Equal(t, ErrInvalidDelta, err)
+
+	err = ApplyDelta(bytes.NewReader(base), int64(len(base)), baseMD5[:], bytes.NewReader([]byte("garbage")), &bytes.Buffer{})
+	assert.Equal(t, ErrInvalidDelta, err)
+}
+
+func TestDeltaWithInvalidSignature(t *testing.T) {
+	base := bytes.Repeat([]byte("foo bar baz "), 10000)
+	baseMD5 := md5.Sum(base)
+	sig, err := ComputeSignature(bytes.NewReader(base), int64(len(base)), baseMD5[:])
+	assert.NoError(t, err)
+	assert.True(t, sig.isValid())
+
+	huge := *sig
+	huge.BlockSize = 1 << 40
+	err = ComputeDelta(&huge, bytes.NewReader(base), &bytes.Buffer{})
+	assert.Equal(t, ErrInvalidDelta, err)
+
+	tooManyBlocks := *sig
+	tooManyBlocks.Blocks = append(tooManyBlocks.Blocks, sig.Blocks...)
+	assert.False(t, tooManyBlocks.isValid())
+
+	badStrong := *sig
+	badStrong.Blocks = append([]BlockSignature{{Strong: []byte("short")}}, sig.Blocks[1:]...)
+	assert.False(t, badStrong.isValid())
+}
@@ -51,4 +51,12 @@ var (
 	ErrAlreadyAccepted = errors.New("Sharing already accepted by this recipient")
 	// ErrCannotOpenFile is used when opening a file fails
 	ErrCannotOpenFile = errors.New("The file cannot be opened")
+	// ErrInvalidCloudID is used when an OCM cloud ID is not in the
+	// user@server format
+	ErrInvalidCloudID = errors.New("The cloud ID is invalid")
+	// ErrOCMNotSupported is used when a remote server does not expose an
+	// Open Cloud Mesh endpoint for sharing files via WebDAV
+	ErrOCMNotSupported = errors.New("The server does not support Open Cloud Mesh")
+	// ErrInvalidOCMShare is used when an OCM share or notification is invalid
+	ErrInvalidOCMShare = errors.New("The OCM share is invalid")
 )
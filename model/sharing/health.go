@@ -0,0 +1,323 @@
+package sharing
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/lock"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// maxFailedJobs is the maximal number of failed jobs listed in a health
+// report for each sharing worker.
+const maxFailedJobs = 20
+
+// sharingWorkers are the workers used for the synchronization of a sharing.
+var sharingWorkers = []string{"share-track", "share-replicate", "share-upload"}
+
+// Health is a report on the state of the synchronization of a sharing. It can
+// be used to understand why the synchronization is stuck.
+type Health struct {
+	SharingID  string                   `json:"sharing_id"`
+	Active     bool                     `json:"active"`
+	Owner      bool                     `json:"owner"`
+	Initial    bool                     `json:"initial_sync,omitempty"`
+	Healthy    bool                     `json:"healthy"`
+	Members    []MemberHealth           `json:"members"`
+	FailedJobs []FailedJob              `json:"failed_jobs"`
+	Checks     []map[string]interface{} `json:"checks"`
+}
+
+// MemberHealth is the part of the health report for a member of the sharing.
+// The sequence numbers and the pending counts are only filled for the members
+// to which this instance sends its changes.
+type MemberHealth struct {
+	Index              int                `json:"index"`
+	Status             string             `json:"status"`
+	Instance           string             `json:"instance,omitempty"`
+	LastReplicatedSeq  string             `json:"last_replicated_seq,omitempty"`
+	LastUploadedSeq    string             `json:"last_uploaded_seq,omitempty"`
+	PendingReplication int                `json:"pending_replication"`
+	PendingUploads     int                `json:"pending_uploads"`
+	Credentials        *CredentialsHealth `json:"credentials,omitempty"`
+}
+
+// CredentialsHealth tells if the credentials for a member can be used to
+// send the changes to this member.
+type CredentialsHealth struct {
+	Valid              bool       `json:"valid"`
+	HasClient          bool       `json:"has_client"`
+	HasAccessToken     bool       `json:"has_access_token"`
+	HasRefreshToken    bool       `json:"has_refresh_token"`
+	AccessTokenExpired bool       `json:"access_token_expired"`
+	IssuedAt           *time.Time `json:"issued_at,omitempty"`
+}
+
+// FailedJob is a job of a sharing worker that has failed.
+type FailedJob struct {
+	ID         string    `json:"id"`
+	Worker     string    `json:"worker"`
+	Error      string    `json:"error"`
+	QueuedAt   time.Time `json:"queued_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// HealthReport computes a health report for the sharing.
+func (s *Sharing) HealthReport(inst *instance.Instance) (*Health, error) {
+	h := &Health{
+		SharingID:  s.SID,
+		Active:     s.Active,
+		Owner:      s.Owner,
+		Initial:    s.Initial,
+		Members:    make([]MemberHealth, len(s.Members)),
+		FailedJobs: []FailedJob{},
+		Checks:     s.checkConsistency(inst),
+	}
+	h.Healthy = len(h.Checks) == 0
+
+	for i := range s.Members {
+		m := &s.Members[i]
+		mh := MemberHealth{
+			Index:    i,
+			Status:   m.Status,
+			Instance: m.Instance,
+		}
+		if s.Active && s.sendsChangesTo(i) {
+			if creds := s.FindCredentials(m); creds != nil {
+				mh.Credentials = credentialsHealth(creds)
+				if !mh.Credentials.Valid {
+					h.Healthy = false
+				}
+			}
+			var err error
+			mh.LastReplicatedSeq, mh.PendingReplication, err = s.pendingChanges(inst, m, "replicator")
+			if err != nil {
+				return nil, err
+			}
+			if s.FirstFilesRule() != nil {
+				mh.LastUploadedSeq, mh.PendingUploads, err = s.pendingChanges(inst, m, "upload")
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		h.Members[i] = mh
+	}
+
+	jobs, err := s.failedJobs(inst)
+	if err != nil {
+		return nil, err
+	}
+	h.FailedJobs = jobs
+	return h, nil
+}
+
+// sendsChangesTo returns true if this instance sends its changes to the
+// member with the given index.
+func (s *Sharing) sendsChangesTo(index int) bool {
+	if s.Owner {
+		return index > 0 && s.Members[index].Status == MemberStatusReady
+	}
+	return index == 0 && !s.ReadOnly()
+}
+
+// pendingChanges returns the last sequence number of the given worker for
+// the member, and the number of changes in io.cozy.shared since this
+// sequence number. The changes for the other sharings are counted too, so
+// it is an upper bound.
+func (s *Sharing) pendingChanges(inst *instance.Instance, m *Member, worker string) (string, int, error) {
+	seq, err := s.getLastSeqNumber(inst, m, worker)
+	if err != nil {
+		return "", 0, err
+	}
+	res, err := couchdb.GetChanges(inst, &couchdb.ChangesRequest{
+		DocType: consts.Shared,
+		Since:   seq,
+		Limit:   1,
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return seq, 0, nil
+		}
+		return "", 0, err
+	}
+	return seq, len(res.Results) + res.Pending, nil
+}
+
+// credentialsHealth checks the credentials used to send changes to a member.
+// The access token is not verified (it has been signed by the other
+// instance), only its issue date is looked at.
+func credentialsHealth(creds *Credentials) *CredentialsHealth {
+	ch := &CredentialsHealth{
+		HasClient:      creds.Client != nil,
+		HasAccessToken: creds.AccessToken != nil && creds.AccessToken.AccessToken != "",
+	}
+	if creds.AccessToken != nil {
+		ch.HasRefreshToken = creds.AccessToken.RefreshToken != ""
+		claims := jwt.StandardClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(creds.AccessToken.AccessToken, &claims); err == nil && claims.IssuedAt > 0 {
+			issuedAt := time.Unix(claims.IssuedAt, 0).UTC()
+			ch.IssuedAt = &issuedAt
+			ch.AccessTokenExpired = issuedAt.Add(consts.AccessTokenValidityDuration).Before(time.Now())
+		}
+	}
+	ch.Valid = ch.HasClient && ch.HasAccessToken && ch.HasRefreshToken
+	return ch
+}
+
+// failedJobs returns the last failed jobs of the sharing workers for this
+// sharing.
+func (s *Sharing) failedJobs(inst *instance.Instance) ([]FailedJob, error) {
+	failed := []FailedJob{}
+	for _, worker := range sharingWorkers {
+		var jobs []*job.Job
+		req := &couchdb.FindRequest{
+			UseIndex: "by-worker-and-state",
+			Selector: mango.And(
+				mango.Equal("worker", worker),
+				mango.Equal("state", job.Errored),
+			),
+			Limit: 100,
+		}
+		err := couchdb.FindDocs(inst, consts.Jobs, req, &jobs)
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				continue
+			}
+			return nil, err
+		}
+		count := 0
+		for _, j := range jobs {
+			var msg struct {
+				SharingID string `json:"sharing_id"`
+			}
+			if err := j.Message.Unmarshal(&msg); err != nil || msg.SharingID != s.SID {
+				continue
+			}
+			failed = append(failed, FailedJob{
+				ID:         j.JobID,
+				Worker:     j.WorkerType,
+				Error:      j.Error,
+				QueuedAt:   j.QueuedAt,
+				FinishedAt: j.FinishedAt,
+			})
+			count++
+			if count >= maxFailedJobs {
+				break
+			}
+		}
+	}
+	return failed, nil
+}
+
+// FixMember tries to repair the synchronization of the sharing with the
+// member at the given index: the missing triggers are recreated, the last
+// sequence numbers are reset, the credentials are refreshed, and the initial
+// sync is started again. It returns the list of the actions that have been
+// done.
+func (s *Sharing) FixMember(inst *instance.Instance, index int) ([]string, error) {
+	if !s.Active {
+		return nil, ErrInvalidSharing
+	}
+	if index < 0 || index >= len(s.Members) || !s.sendsChangesTo(index) {
+		return nil, ErrMemberNotFound
+	}
+	m := &s.Members[index]
+	if m.Instance == "" {
+		return nil, ErrInvalidURL
+	}
+	creds := s.FindCredentials(m)
+	if creds == nil {
+		return nil, ErrInvalidSharing
+	}
+
+	mu := lock.ReadWrite(inst, "sharings/"+s.SID)
+	if err := mu.Lock(); err != nil {
+		return nil, err
+	}
+	defer mu.Unlock()
+
+	actions, err := s.fixTriggers(inst)
+	if err != nil {
+		return actions, err
+	}
+
+	if err := s.ClearLastSequenceNumbers(inst, m); err != nil {
+		return actions, err
+	}
+	actions = append(actions, "reset_sequence_numbers")
+
+	if err := creds.Refresh(inst, s, m); err != nil {
+		return actions, err
+	}
+	actions = append(actions, "refresh_credentials")
+
+	if !s.Owner {
+		s.pushJob(inst, "share-replicate")
+		if s.FirstFilesRule() != nil {
+			s.pushJob(inst, "share-upload")
+		}
+		return append(actions, "restart_sync"), nil
+	}
+
+	if pending, err := s.ReplicateTo(inst, m, true); err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Error on initial replication (%s): %s", s.SID, err)
+		s.retryWorker(inst, "share-replicate", 0)
+	} else if pending {
+		s.pushJob(inst, "share-replicate")
+	}
+	if s.FirstFilesRule() != nil {
+		if err := s.InitialUpload(inst, m); err != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Warnf("Error on initial upload (%s): %s", s.SID, err)
+			s.retryWorker(inst, "share-upload", 0)
+		}
+	}
+	return append(actions, "restart_initial_sync"), nil
+}
+
+// fixTriggers recreates the triggers of the sharing that are missing.
+func (s *Sharing) fixTriggers(inst *instance.Instance) ([]string, error) {
+	actions := []string{}
+	for _, id := range []*string{&s.Triggers.TrackID, &s.Triggers.ReplicateID, &s.Triggers.UploadID} {
+		if *id == "" {
+			continue
+		}
+		err := couchdb.GetDoc(inst, consts.Triggers, *id, nil)
+		if couchdb.IsNotFoundError(err) {
+			*id = ""
+		} else if err != nil {
+			return actions, err
+		}
+	}
+
+	before := s.Triggers
+	if err := s.AddTrackTriggers(inst); err != nil {
+		return actions, err
+	}
+	if s.Owner || !s.ReadOnly() {
+		if err := s.AddReplicateTrigger(inst); err != nil {
+			return actions, err
+		}
+		if s.FirstFilesRule() != nil {
+			if err := s.AddUploadTrigger(inst); err != nil {
+				return actions, err
+			}
+		}
+	}
+	if before.TrackID != s.Triggers.TrackID {
+		actions = append(actions, "add_track_trigger")
+	}
+	if before.ReplicateID != s.Triggers.ReplicateID {
+		actions = append(actions, "add_replicate_trigger")
+	}
+	if before.UploadID != s.Triggers.UploadID {
+		actions = append(actions, "add_upload_trigger")
+	}
+	return actions, nil
+}
@@ -0,0 +1,68 @@
+package sharing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/auth"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func TestCredentialsHealth(t *testing.T) {
+	ch := credentialsHealth(&Credentials{})
+	assert.False(t, ch.Valid)
+	assert.False(t, ch.HasClient)
+	assert.False(t, ch.HasAccessToken)
+
+	issuedAt := time.Now().Add(-30 * 24 * time.Hour)
+	token, err := crypto.NewJWT([]byte("secret"), jwt.StandardClaims{
+		IssuedAt: issuedAt.Unix(),
+	})
+	assert.NoError(t, err)
+	ch = credentialsHealth(&Credentials{
+		Client: &auth.Client{ClientID: "foo"},
+		AccessToken: &auth.AccessToken{
+			AccessToken:  token,
+			RefreshToken: "bar",
+		},
+	})
+	assert.True(t, ch.Valid)
+	assert.True(t, ch.AccessTokenExpired)
+	if assert.NotNil(t, ch.IssuedAt) {
+		assert.Equal(t, issuedAt.Unix(), ch.IssuedAt.Unix())
+	}
+
+	token, err = crypto.NewJWT([]byte("secret"), jwt.StandardClaims{
+		IssuedAt: time.Now().Unix(),
+	})
+	assert.NoError(t, err)
+	ch = credentialsHealth(&Credentials{
+		Client:      &auth.Client{ClientID: "foo"},
+		AccessToken: &auth.AccessToken{AccessToken: token},
+	})
+	assert.False(t, ch.Valid)
+	assert.False(t, ch.AccessTokenExpired)
+}
+
+func TestCheckConsistencyInvalidCredentials(t *testing.T) {
+	s := &Sharing{
+		SID:    "sharing-with-broken-credentials",
+		Active: true,
+		Owner:  true,
+		Members: []Member{
+			{Status: MemberStatusOwner, Instance: "https://alice.cozy.example"},
+			{Status: MemberStatusReady, Instance: "https://bob.cozy.example"},
+		},
+	}
+	var checks []map[string]interface{}
+	assert.NotPanics(t, func() { checks = s.checkConsistency(nil) })
+	found := false
+	for _, check := range checks {
+		if check["type"] == "invalid_number_of_credentials" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
@@ -33,6 +33,9 @@ func (s *Sharing) SendInvitations(inst *instance.Instance, perms *permission.Per
 		if i == 0 || m.Status != MemberStatusMailNotSent { // i == 0 is for the owner
 			continue
 		}
+		if m.OCM != nil { // OCM shares are sent by SendOCMShares
+			continue
+		}
 		state := s.Credentials[i-1].State
 		g.Go(func() error {
 			link := m.InvitationLink(inst, s, state, perms)
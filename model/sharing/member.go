@@ -39,6 +39,9 @@ const (
 	MemberStatusReady = "ready"
 	// MemberStatusRevoked is for a revoked member
 	MemberStatusRevoked = "revoked"
+	// MemberStatusFederated is for a recipient on an Open Cloud Mesh server
+	// that has accepted the sharing
+	MemberStatusFederated = "federated"
 )
 
 const maximalNumberOfMembers = 90
@@ -60,6 +63,9 @@ type Member struct {
 	Email      string `json:"email,omitempty"`
 	Instance   string `json:"instance,omitempty"`
 	ReadOnly   bool   `json:"read_only,omitempty"`
+
+	// OCM is only used for a member on an Open Cloud Mesh server
+	OCM *OCMMember `json:"ocm,omitempty"`
 }
 
 // PrimaryName returns the main name of this member
@@ -150,7 +156,9 @@ func (s *Sharing) addMember(inst *instance.Instance, m Member) (string, error) {
 			continue // Skip the owner
 		}
 		var found bool
-		if m.Email == "" {
+		if m.OCM != nil {
+			found = member.OCM != nil && m.OCM.CloudID == member.OCM.CloudID
+		} else if m.Email == "" {
 			found = m.Instance == member.Instance
 		} else {
 			found = m.Email == member.Email
@@ -158,7 +166,7 @@ func (s *Sharing) addMember(inst *instance.Instance, m Member) (string, error) {
 		if !found {
 			continue
 		}
-		if member.Status == MemberStatusReady {
+		if member.Status == MemberStatusReady || member.Status == MemberStatusFederated {
 			return "", nil
 		}
 		idx = i
@@ -166,6 +174,7 @@ func (s *Sharing) addMember(inst *instance.Instance, m Member) (string, error) {
 		s.Members[i].Name = m.Name
 		s.Members[i].Instance = m.Instance
 		s.Members[i].ReadOnly = m.ReadOnly
+		s.Members[i].OCM = m.OCM
 		break
 	}
 	if idx < 1 {
@@ -504,6 +513,11 @@ func (s *Sharing) FindMemberByCode(perms *permission.Permission, sharecode strin
 			return &s.Members[i], nil
 		}
 	}
+	for i, m := range s.Members {
+		if m.OCM != nil && m.OCM.CloudID == emailOrInstance {
+			return &s.Members[i], nil
+		}
+	}
 	return nil, ErrMemberNotFound
 }
 
@@ -846,6 +860,10 @@ func (s *Sharing) RevokeMember(inst *instance.Instance, index int) error {
 	m := &s.Members[index]
 	c := &s.Credentials[index-1]
 
+	if m.OCM != nil {
+		s.notifyOCMRevocation(inst, m)
+	}
+
 	// No need to contact the revoked member if the sharing is not ready
 	if m.Status == MemberStatusReady {
 		if err := s.NotifyMemberRevocation(inst, m, c); err != nil {
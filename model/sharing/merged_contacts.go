@@ -0,0 +1,86 @@
+package sharing
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+func init() {
+	contact.UpdateSharingMembers = updateMergedMembers
+}
+
+// updateMergedMembers is called when some contacts have been merged in the
+// survivor contact. The members of the sharings are not linked to the
+// contacts by their identifiers, but by their email addresses and cozy URLs:
+// the members added from a merged contact keep working, as the survivor has
+// their addresses, but their name is updated to the name of the survivor.
+func updateMergedMembers(inst *instance.Instance, survivor *contact.Contact, merged []*contact.Contact) error {
+	name := survivor.PrimaryName()
+	if name == "" {
+		return nil
+	}
+	emails := make(map[string]bool)
+	urls := make(map[string]bool)
+	for _, c := range merged {
+		if addrs, ok := c.Get("email").([]interface{}); ok {
+			for _, addr := range addrs {
+				if m, ok := addr.(map[string]interface{}); ok {
+					if email, ok := m["address"].(string); ok && email != "" {
+						emails[strings.ToLower(email)] = true
+					}
+				}
+			}
+		}
+		if cozys, ok := c.Get("cozy").([]interface{}); ok {
+			for _, cozy := range cozys {
+				if m, ok := cozy.(map[string]interface{}); ok {
+					if u, ok := m["url"].(string); ok && u != "" {
+						urls[strings.TrimSuffix(strings.ToLower(u), "/")] = true
+					}
+				}
+			}
+		}
+	}
+	if len(emails) == 0 && len(urls) == 0 {
+		return nil
+	}
+
+	err := couchdb.ForeachDocs(inst, consts.Sharings, func(_ string, data json.RawMessage) error {
+		var s Sharing
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		// Only the owner has the names of the members from the contacts
+		if !s.Owner {
+			return nil
+		}
+		changed := false
+		for i := range s.Members {
+			if i == 0 {
+				continue // skip the owner
+			}
+			m := &s.Members[i]
+			if !emails[strings.ToLower(m.Email)] &&
+				!urls[strings.TrimSuffix(strings.ToLower(m.Instance), "/")] {
+				continue
+			}
+			if m.Name != name {
+				m.Name = name
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		return couchdb.UpdateDoc(inst, &s)
+	})
+	if couchdb.IsNoDatabaseError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,615 @@
+package sharing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/auth"
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Open Cloud Mesh (OCM) is a protocol for sharing files between servers of
+// different vendors (Nextcloud, ownCloud, Seafile, etc.). A share is sent
+// with a shared secret, and the recipient uses this secret to access the
+// files via WebDAV. On the stack, an OCM share is mapped to a sharing:
+//
+//   - when a Cozy user shares a folder with a user on an OCM server, this user
+//     is added as a read-only member of the sharing, and the shared secret is
+//     a sharecode of the preview permissions for this member
+//   - when a Cozy user receives a share from an OCM server, a sharing is
+//     created on their instance, with the remote user as the owner, and the
+//     shared secret is kept in the credentials for the owner.
+//
+// See https://cs3org.github.io/OCM-API/docs.html
+
+const (
+	// OCMAPIVersion is the version of the OCM API implemented by the stack
+	OCMAPIVersion = "1.0-proposal1"
+	// OCMWebDAVPath is the path of the WebDAV endpoint used by the OCM servers
+	// to access the files shared with them
+	OCMWebDAVPath = "/ocm/webdav/"
+
+	// OCMShareAccepted is the notification for an accepted share
+	OCMShareAccepted = "SHARE_ACCEPTED"
+	// OCMShareDeclined is the notification for a declined share
+	OCMShareDeclined = "SHARE_DECLINED"
+	// OCMShareUnshared is the notification for a share that has been revoked
+	// by its owner
+	OCMShareUnshared = "SHARE_UNSHARED"
+)
+
+// ocmDiscoveryPaths are the paths where the discovery of an OCM server can be
+// made, by order of preference.
+var ocmDiscoveryPaths = []string{"/.well-known/ocm", "/ocm-provider/"}
+
+// OCMMember contains the information about a member of a sharing that is a
+// user of an OCM server.
+type OCMMember struct {
+	CloudID  string `json:"cloud_id"`
+	Endpoint string `json:"endpoint"`
+	// WebDAV is the URL for accessing the files, only for the owner of a
+	// share received from an OCM server
+	WebDAV string `json:"webdav,omitempty"`
+}
+
+// OCMShare contains the information about a share received from an OCM
+// server.
+type OCMShare struct {
+	ProviderID   string `json:"provider_id"`
+	ResourceType string `json:"resource_type"`
+}
+
+// OCMDiscovery is the document returned by the discovery endpoint of an OCM
+// server.
+type OCMDiscovery struct {
+	Enabled       bool              `json:"enabled"`
+	APIVersion    string            `json:"apiVersion"`
+	EndPoint      string            `json:"endPoint"`
+	Provider      string            `json:"provider,omitempty"`
+	ResourceTypes []OCMResourceType `json:"resourceTypes"`
+}
+
+// OCMResourceType describes a type of resource that can be shared with an
+// OCM server, and the protocols that can be used to access it.
+type OCMResourceType struct {
+	Name       string            `json:"name"`
+	ShareTypes []string          `json:"shareTypes"`
+	Protocols  map[string]string `json:"protocols"`
+}
+
+// OCMShareRequest is the body of a request for creating a share on an OCM
+// server.
+type OCMShareRequest struct {
+	ShareWith         string      `json:"shareWith"`
+	Name              string      `json:"name"`
+	Description       string      `json:"description,omitempty"`
+	ProviderID        string      `json:"providerId"`
+	Owner             string      `json:"owner"`
+	Sender            string      `json:"sender"`
+	OwnerDisplayName  string      `json:"ownerDisplayName,omitempty"`
+	SenderDisplayName string      `json:"senderDisplayName,omitempty"`
+	ShareType         string      `json:"shareType"`
+	ResourceType      string      `json:"resourceType"`
+	Protocol          OCMProtocol `json:"protocol"`
+}
+
+// OCMProtocol is the protocol that can be used to access a shared resource.
+type OCMProtocol struct {
+	Name    string `json:"name"`
+	Options struct {
+		SharedSecret string `json:"sharedSecret"`
+		Permissions  string `json:"permissions,omitempty"`
+	} `json:"options"`
+}
+
+// OCMNotification is the body of a request for notifying an OCM server of a
+// change on a share.
+type OCMNotification struct {
+	NotificationType string `json:"notificationType"`
+	ResourceType     string `json:"resourceType"`
+	ProviderID       string `json:"providerId"`
+	Notification     struct {
+		SharedSecret string `json:"sharedSecret"`
+		Message      string `json:"message,omitempty"`
+	} `json:"notification"`
+}
+
+// LocalOCMDiscovery returns the discovery document for the given instance.
+func LocalOCMDiscovery(inst *instance.Instance) *OCMDiscovery {
+	return &OCMDiscovery{
+		Enabled:    true,
+		APIVersion: OCMAPIVersion,
+		EndPoint:   inst.PageURL("/ocm", nil),
+		Provider:   "Cozy",
+		ResourceTypes: []OCMResourceType{
+			{
+				Name:       "file",
+				ShareTypes: []string{"user"},
+				Protocols:  map[string]string{"webdav": OCMWebDAVPath},
+			},
+		},
+	}
+}
+
+// WebDAVURL returns the URL of the WebDAV endpoint for accessing the files
+// shared by this OCM server, or an empty string if there is none.
+func (d *OCMDiscovery) WebDAVURL() string {
+	for _, rt := range d.ResourceTypes {
+		if rt.Name != "file" || rt.Protocols["webdav"] == "" {
+			continue
+		}
+		base, err := url.Parse(d.EndPoint)
+		if err != nil {
+			return ""
+		}
+		ref, err := url.Parse(rt.Protocols["webdav"])
+		if err != nil {
+			return ""
+		}
+		return base.ResolveReference(ref).String()
+	}
+	return ""
+}
+
+// ParseCloudID splits a cloud ID (user@server) into the user and the URL of
+// the server. The server is reached via HTTPS, except if a scheme is given
+// in the cloud ID.
+func ParseCloudID(cloudID string) (string, string, error) {
+	idx := strings.LastIndex(cloudID, "@")
+	if idx <= 0 || idx == len(cloudID)-1 {
+		return "", "", ErrInvalidCloudID
+	}
+	user, server := cloudID[:idx], cloudID[idx+1:]
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" || !isAllowedOCMScheme(u.Scheme) {
+		return "", "", ErrInvalidCloudID
+	}
+	return user, u.Scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// OCMCloudID returns the cloud ID of the owner of the given instance.
+func OCMCloudID(inst *instance.Instance) string {
+	user := strings.SplitN(inst.Domain, ".", 2)[0]
+	u, err := url.Parse(inst.PageURL("", nil))
+	if err != nil {
+		return user + "@" + inst.Domain
+	}
+	if u.Scheme == "https" {
+		return user + "@" + u.Host
+	}
+	return user + "@" + u.Scheme + "://" + u.Host
+}
+
+// isOCMCloudIDOf returns true if the cloud ID is the one of the owner of the
+// given instance.
+func isOCMCloudIDOf(inst *instance.Instance, cloudID string) bool {
+	user, server, err := ParseCloudID(cloudID)
+	if err != nil {
+		return false
+	}
+	expectedUser, expectedServer, err := ParseCloudID(OCMCloudID(inst))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(user, expectedUser) && strings.EqualFold(server, expectedServer)
+}
+
+// ocmSharingID returns the identifier of the sharing for a share received
+// from an OCM server. It is derived from the shared secret, as the
+// notifications from the OCM server only have the secret and the provider ID
+// to identify the share.
+func ocmSharingID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ocmClient is the HTTP client for the requests to the OCM servers. As their
+// URLs are given by the remote servers, the connections to the internal
+// network are refused.
+var ocmClient = safehttp.NewClient(30*time.Second, true)
+
+// isAllowedOCMScheme returns true if the scheme can be used for the requests
+// to an OCM server: only https is allowed, except for the development.
+func isAllowedOCMScheme(scheme string) bool {
+	return scheme == "https" || (scheme == "http" && build.IsDevRelease())
+}
+
+// ocmRequest makes an HTTP request to an OCM server.
+func ocmRequest(method, rawURL string, headers request.Headers, body io.Reader) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || !isAllowedOCMScheme(u.Scheme) {
+		return nil, ErrInvalidURL
+	}
+	opts := &request.Options{
+		Method:  method,
+		Scheme:  u.Scheme,
+		Domain:  u.Host,
+		Path:    u.Path,
+		Queries: u.Query(),
+		Headers: headers,
+		Body:    body,
+	}
+	// The instances of a development stack are on localhost
+	if !build.IsDevRelease() {
+		opts.Client = ocmClient
+	}
+	return request.Req(opts)
+}
+
+// DiscoverOCM fetches the discovery document of the OCM server at the given
+// URL.
+func DiscoverOCM(server string) (*OCMDiscovery, error) {
+	for _, path := range ocmDiscoveryPaths {
+		res, err := ocmRequest(http.MethodGet, server+path, request.Headers{
+			"Accept": "application/json",
+		}, nil)
+		if err != nil {
+			continue
+		}
+		var disco OCMDiscovery
+		err = json.NewDecoder(res.Body).Decode(&disco)
+		res.Body.Close()
+		if err == nil && disco.Enabled && disco.EndPoint != "" {
+			disco.EndPoint = strings.TrimSuffix(disco.EndPoint, "/")
+			return &disco, nil
+		}
+	}
+	return nil, ErrOCMNotSupported
+}
+
+// sendOCMNotification sends a notification to the OCM endpoint of a remote
+// server.
+func sendOCMNotification(endpoint, notificationType, providerID, secret string) error {
+	n := OCMNotification{
+		NotificationType: notificationType,
+		ResourceType:     "file",
+		ProviderID:       providerID,
+	}
+	n.Notification.SharedSecret = secret
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	res, err := ocmRequest(http.MethodPost, endpoint+"/notifications", request.Headers{
+		"Accept":       "application/json",
+		"Content-Type": "application/json",
+	}, bytes.NewReader(body))
+	if err != nil {
+		return ErrRequestFailed
+	}
+	res.Body.Close()
+	return nil
+}
+
+// AddOCMRecipient adds a user of an OCM server as a read-only recipient of
+// the sharing. The share is sent to the OCM server by SendOCMShares.
+func (s *Sharing) AddOCMRecipient(inst *instance.Instance, cloudID string) error {
+	if !s.Owner {
+		return ErrInvalidSharing
+	}
+	rule := s.FirstFilesRule()
+	if rule == nil || !rule.FilesByID() || len(rule.Values) != 1 {
+		return ErrInvalidSharing
+	}
+	_, server, err := ParseCloudID(cloudID)
+	if err != nil {
+		return err
+	}
+	disco, err := DiscoverOCM(server)
+	if err != nil {
+		return err
+	}
+	m := Member{
+		Status:   MemberStatusMailNotSent,
+		Name:     cloudID,
+		ReadOnly: true,
+		OCM: &OCMMember{
+			CloudID:  cloudID,
+			Endpoint: disco.EndPoint,
+		},
+	}
+	_, err = s.addMember(inst, m)
+	return err
+}
+
+// SendOCMShares sends the share to the OCM servers of the members that have
+// not received it yet.
+func (s *Sharing) SendOCMShares(inst *instance.Instance) error {
+	if !s.Owner {
+		return ErrInvalidSharing
+	}
+	pending := false
+	for i, m := range s.Members {
+		if i > 0 && m.OCM != nil && m.Status == MemberStatusMailNotSent {
+			pending = true
+		}
+	}
+	if !pending {
+		return nil
+	}
+
+	perms, err := s.CreatePreviewPermissions(inst)
+	if err != nil {
+		return err
+	}
+	name, err := inst.PublicName()
+	if err != nil {
+		return err
+	}
+	cloudID := OCMCloudID(inst)
+	title := s.FirstFilesRule().Title
+
+	var errm error
+	for i := range s.Members {
+		m := &s.Members[i]
+		if i == 0 || m.OCM == nil || m.Status != MemberStatusMailNotSent {
+			continue
+		}
+		req := OCMShareRequest{
+			ShareWith:         m.OCM.CloudID,
+			Name:              title,
+			Description:       s.Description,
+			ProviderID:        s.SID,
+			Owner:             cloudID,
+			Sender:            cloudID,
+			OwnerDisplayName:  name,
+			SenderDisplayName: name,
+			ShareType:         "user",
+			ResourceType:      "file",
+		}
+		req.Protocol.Name = "webdav"
+		req.Protocol.Options.SharedSecret = perms.Codes[m.OCM.CloudID]
+		req.Protocol.Options.Permissions = "{http://open-cloud-mesh.org/ns}share-permissions"
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		res, err := ocmRequest(http.MethodPost, m.OCM.Endpoint+"/shares", request.Headers{
+			"Accept":       "application/json",
+			"Content-Type": "application/json",
+		}, bytes.NewReader(body))
+		if err != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Warnf("Can't send the OCM share to %s: %s", m.OCM.CloudID, err)
+			errm = multierror.Append(errm, ErrInvitationNotSent)
+			continue
+		}
+		res.Body.Close()
+		m.Status = MemberStatusPendingInvitation
+	}
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	return errm
+}
+
+// notifyOCMRevocation tells the OCM server of a member that the share has
+// been revoked. Errors are just logged.
+func (s *Sharing) notifyOCMRevocation(inst *instance.Instance, m *Member) {
+	if m.Status == MemberStatusMailNotSent || m.Status == MemberStatusRevoked {
+		return
+	}
+	perms, err := permission.GetForSharePreview(inst, s.SID)
+	if err == nil {
+		err = sendOCMNotification(m.OCM.Endpoint, OCMShareUnshared, s.SID, perms.Codes[m.OCM.CloudID])
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Can't notify %s of the OCM share revocation: %s", m.OCM.CloudID, err)
+	}
+}
+
+// FindOCMSharing returns the sharing for a notification sent by an OCM
+// server: the provider ID is the sharing ID for a share sent by this
+// instance, and the sharing ID is derived from the secret for a share
+// received by this instance.
+func FindOCMSharing(inst *instance.Instance, n *OCMNotification) (*Sharing, error) {
+	if n.ProviderID == "" || n.Notification.SharedSecret == "" {
+		return nil, ErrInvalidOCMShare
+	}
+	if s, err := FindSharing(inst, n.ProviderID); err == nil && s.Owner {
+		return s, nil
+	}
+	s, err := FindSharing(inst, ocmSharingID(n.Notification.SharedSecret))
+	if err != nil {
+		return nil, err
+	}
+	if s.OCM == nil || s.OCM.ProviderID != n.ProviderID {
+		return nil, ErrInvalidOCMShare
+	}
+	return s, nil
+}
+
+// HandleOCMNotification updates the sharing after a notification from an OCM
+// server.
+func (s *Sharing) HandleOCMNotification(inst *instance.Instance, n *OCMNotification) error {
+	secret := n.Notification.SharedSecret
+
+	if !s.Owner {
+		if n.NotificationType != OCMShareUnshared {
+			return ErrInvalidOCMShare
+		}
+		if len(s.Credentials) == 0 || s.Credentials[0].AccessToken == nil ||
+			s.Credentials[0].AccessToken.AccessToken != secret {
+			return ErrInvalidOCMShare
+		}
+		s.Active = false
+		s.Credentials = nil
+		s.Members[0].Status = MemberStatusRevoked
+		return couchdb.UpdateDoc(inst, s)
+	}
+
+	m, err := s.FindMemberBySharecode(inst, secret)
+	if err != nil {
+		return err
+	}
+	if m.OCM == nil || m.Status == MemberStatusRevoked {
+		return ErrMemberNotFound
+	}
+	switch n.NotificationType {
+	case OCMShareAccepted:
+		m.Status = MemberStatusFederated
+		return couchdb.UpdateDoc(inst, s)
+	case OCMShareDeclined, OCMShareUnshared:
+		m.Status = MemberStatusRevoked
+		if c := s.FindCredentials(m); c != nil {
+			*c = Credentials{}
+		}
+		return s.NoMoreRecipient(inst)
+	}
+	return ErrInvalidOCMShare
+}
+
+// ReceiveOCMShare creates a sharing on the instance of the recipient for a
+// share sent by an OCM server. The user will have to accept it.
+func ReceiveOCMShare(inst *instance.Instance, req *OCMShareRequest) (*Sharing, error) {
+	secret := req.Protocol.Options.SharedSecret
+	if req.ShareType != "user" || req.ResourceType != "file" ||
+		req.Protocol.Name != "webdav" || secret == "" ||
+		req.ProviderID == "" || req.Name == "" {
+		return nil, ErrInvalidOCMShare
+	}
+	if !isOCMCloudIDOf(inst, req.ShareWith) {
+		return nil, ErrInvalidOCMShare
+	}
+	owner := req.Owner
+	if owner == "" {
+		owner = req.Sender
+	}
+	_, server, err := ParseCloudID(owner)
+	if err != nil {
+		return nil, err
+	}
+	disco, err := DiscoverOCM(server)
+	if err != nil {
+		return nil, err
+	}
+	webdav := disco.WebDAVURL()
+	if webdav == "" {
+		return nil, ErrOCMNotSupported
+	}
+	ownerName := req.OwnerDisplayName
+	if ownerName == "" {
+		ownerName = owner
+	}
+	name, err := inst.PublicName()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sharing{
+		SID:         ocmSharingID(secret),
+		Description: req.Description,
+		AppSlug:     consts.DriveSlug,
+		CreatedAt:   time.Now(),
+		OCM: &OCMShare{
+			ProviderID:   req.ProviderID,
+			ResourceType: req.ResourceType,
+		},
+		Rules: []Rule{
+			{
+				Title:   req.Name,
+				DocType: consts.Files,
+				Values:  []string{req.ProviderID},
+				Local:   true,
+				Add:     ActionRuleNone,
+				Update:  ActionRuleNone,
+				Remove:  ActionRuleNone,
+			},
+		},
+		Members: []Member{
+			{
+				Status: MemberStatusOwner,
+				Name:   ownerName,
+				OCM: &OCMMember{
+					CloudID:  owner,
+					Endpoint: disco.EndPoint,
+					WebDAV:   webdav,
+				},
+			},
+			{
+				Status:     MemberStatusPendingInvitation,
+				PublicName: name,
+				Instance:   inst.PageURL("", nil),
+				ReadOnly:   true,
+			},
+		},
+		Credentials: []Credentials{
+			{
+				AccessToken: &auth.AccessToken{
+					TokenType:   "Basic",
+					AccessToken: secret,
+				},
+			},
+		},
+	}
+	s.UpdatedAt = s.CreatedAt
+	if err := s.ValidateRules(); err != nil {
+		return nil, err
+	}
+	if err := couchdb.CreateNamedDocWithDB(inst, s); err != nil {
+		if couchdb.IsConflictError(err) {
+			return nil, ErrAlreadyAccepted
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// AcceptOCMShare is used on the instance of the recipient to accept a share
+// received from an OCM server.
+func (s *Sharing) AcceptOCMShare(inst *instance.Instance) error {
+	if s.Owner || s.OCM == nil || len(s.Credentials) == 0 || s.Credentials[0].AccessToken == nil {
+		return ErrInvalidSharing
+	}
+	if s.Active {
+		return ErrAlreadyAccepted
+	}
+	owner := &s.Members[0]
+	secret := s.Credentials[0].AccessToken.AccessToken
+	if err := sendOCMNotification(owner.OCM.Endpoint, OCMShareAccepted, s.OCM.ProviderID, secret); err != nil {
+		return err
+	}
+	s.Active = true
+	s.Members[1].Status = MemberStatusReady
+	s.UpdatedAt = time.Now()
+	return couchdb.UpdateDoc(inst, s)
+}
+
+// DeclineOCMShare is used on the instance of the recipient to decline a
+// share received from an OCM server, or to leave it after it was accepted.
+func (s *Sharing) DeclineOCMShare(inst *instance.Instance) error {
+	if s.Owner || s.OCM == nil {
+		return ErrInvalidSharing
+	}
+	if len(s.Credentials) > 0 && s.Credentials[0].AccessToken != nil {
+		owner := &s.Members[0]
+		secret := s.Credentials[0].AccessToken.AccessToken
+		if err := sendOCMNotification(owner.OCM.Endpoint, OCMShareDeclined, s.OCM.ProviderID, secret); err != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Warnf("Can't notify %s of the OCM share decline: %s", owner.OCM.CloudID, err)
+		}
+	}
+	s.Active = false
+	s.Credentials = nil
+	s.Members[1].Status = MemberStatusRevoked
+	s.UpdatedAt = time.Now()
+	return couchdb.UpdateDoc(inst, s)
+}
@@ -0,0 +1,108 @@
+package sharing
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCloudID(t *testing.T) {
+	user, server, err := ParseCloudID("bob@nextcloud.example.net")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", user)
+	assert.Equal(t, "https://nextcloud.example.net", server)
+
+	user, server, err = ParseCloudID("bob@example.net@cloud.example.net/nextcloud/")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob@example.net", user)
+	assert.Equal(t, "https://cloud.example.net/nextcloud", server)
+
+	user, server, err = ParseCloudID("alice@http://alice.cozy.localhost:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, "http://alice.cozy.localhost:8080", server)
+
+	for _, invalid := range []string{"", "bob", "bob@", "@example.net", "bob@ftp://example.net"} {
+		_, _, err = ParseCloudID(invalid)
+		assert.Equal(t, ErrInvalidCloudID, err)
+	}
+}
+
+func TestOCMDiscoveryWebDAVURL(t *testing.T) {
+	disco := &OCMDiscovery{
+		Enabled:  true,
+		EndPoint: "https://cloud.example.net/ocm",
+		ResourceTypes: []OCMResourceType{
+			{Name: "file", Protocols: map[string]string{"webdav": "/public.php/webdav/"}},
+		},
+	}
+	assert.Equal(t, "https://cloud.example.net/public.php/webdav/", disco.WebDAVURL())
+
+	disco.ResourceTypes[0].Protocols = map[string]string{}
+	assert.Equal(t, "", disco.WebDAVURL())
+}
+
+func TestParseOCMEntries(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:response>
+    <d:href>/public.php/webdav/Photos/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/public.php/webdav/Photos/Summer%202021/</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getlastmodified>Tue, 03 Aug 2021 10:00:00 GMT</d:getlastmodified>
+        <d:resourcetype><d:collection/></d:resourcetype>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/public.php/webdav/Photos/beach.jpg</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength>12345</d:getcontentlength>
+        <d:getcontenttype>image/jpeg</d:getcontenttype>
+        <d:getetag>"abc"</d:getetag>
+        <d:resourcetype/>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><oc:size/></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+	var ms WebDAVMultistatus
+	assert.NoError(t, xml.NewDecoder(strings.NewReader(body)).Decode(&ms))
+
+	entries := parseOCMEntries(&ms, "https://cloud.example.net/public.php/webdav/Photos", "/Photos")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "Summer 2021", entries[0].Name)
+		assert.Equal(t, "/Photos/Summer 2021", entries[0].Path)
+		assert.Equal(t, "directory", entries[0].Type)
+		assert.Equal(t, 2021, entries[0].UpdatedAt.Year())
+		assert.Equal(t, "beach.jpg", entries[1].Name)
+		assert.Equal(t, "file", entries[1].Type)
+		assert.Equal(t, int64(12345), entries[1].Size)
+		assert.Equal(t, "image/jpeg", entries[1].Mime)
+		assert.Equal(t, "abc", entries[1].ETag)
+	}
+
+	// The XML written by the stack can be read by the client
+	out, err := xml.Marshal(ms)
+	assert.NoError(t, err)
+	var again WebDAVMultistatus
+	assert.NoError(t, xml.Unmarshal(out, &again))
+	assert.Len(t, again.Responses, 3)
+	assert.NotNil(t, again.Responses[1].Propstat[0].Prop.ResourceType.Collection)
+	assert.Nil(t, again.Responses[2].Propstat[0].Prop.ResourceType.Collection)
+}
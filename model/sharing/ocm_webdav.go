@@ -0,0 +1,199 @@
+package sharing
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+)
+
+// WebDAVMultistatus is the XML document returned for a PROPFIND request.
+type WebDAVMultistatus struct {
+	XMLName   xml.Name         `xml:"DAV: multistatus"`
+	Responses []WebDAVResponse `xml:"DAV: response"`
+}
+
+// WebDAVResponse contains the properties of a file or directory in a
+// multistatus document.
+type WebDAVResponse struct {
+	Href     string           `xml:"DAV: href"`
+	Propstat []WebDAVPropstat `xml:"DAV: propstat"`
+}
+
+// WebDAVPropstat is a group of properties with their status.
+type WebDAVPropstat struct {
+	Prop   WebDAVProp `xml:"DAV: prop"`
+	Status string     `xml:"DAV: status"`
+}
+
+// WebDAVProp is the list of the properties that are used for the OCM shares.
+type WebDAVProp struct {
+	DisplayName   string             `xml:"DAV: displayname,omitempty"`
+	ContentLength string             `xml:"DAV: getcontentlength,omitempty"`
+	ContentType   string             `xml:"DAV: getcontenttype,omitempty"`
+	LastModified  string             `xml:"DAV: getlastmodified,omitempty"`
+	ETag          string             `xml:"DAV: getetag,omitempty"`
+	ResourceType  WebDAVResourceType `xml:"DAV: resourcetype"`
+}
+
+// WebDAVResourceType tells if the resource is a collection (directory).
+type WebDAVResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+// OCMEntry is a file or a directory inside a share received from an OCM
+// server.
+type OCMEntry struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size,omitempty"`
+	Mime      string    `json:"mime,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// ResolveOCMPath returns the directory or the file at the given path, relative
+// to the folder (or file) shared by the sharing. It is used by the WebDAV
+// endpoint for the OCM servers.
+func (s *Sharing) ResolveOCMPath(inst *instance.Instance, p string) (*vfs.DirDoc, *vfs.FileDoc, error) {
+	rule := s.FirstFilesRule()
+	if rule == nil || !rule.FilesByID() || len(rule.Values) != 1 {
+		return nil, nil, ErrInvalidSharing
+	}
+	fs := inst.VFS()
+	dir, file, err := fs.DirOrFileByID(rule.Values[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir != nil && strings.HasPrefix(dir.Fullpath, vfs.TrashDirName) {
+		return nil, nil, os.ErrNotExist
+	}
+	cleaned := path.Clean("/" + p)
+	if cleaned == "/" {
+		return dir, file, nil
+	}
+	if dir == nil {
+		return nil, nil, os.ErrNotExist
+	}
+	return fs.DirOrFileByPath(path.Join(dir.Fullpath, cleaned))
+}
+
+// ocmWebDAVURL returns the URL of a file or directory inside a share received
+// from an OCM server.
+func (s *Sharing) ocmWebDAVURL(p string) (string, error) {
+	if s.Owner || s.OCM == nil || !s.Active || len(s.Credentials) == 0 ||
+		s.Credentials[0].AccessToken == nil || s.Members[0].OCM == nil {
+		return "", ErrInvalidSharing
+	}
+	base := strings.TrimSuffix(s.Members[0].OCM.WebDAV, "/")
+	cleaned := path.Clean("/" + p)
+	if cleaned == "/" {
+		return base + "/", nil
+	}
+	return base + cleaned, nil
+}
+
+// ocmWebDAVRequest makes a WebDAV request on the server of the owner of a
+// share received from an OCM server. The shared secret is used as the
+// username for the basic authentication.
+func (s *Sharing) ocmWebDAVRequest(method, p string, headers request.Headers) (*http.Response, error) {
+	u, err := s.ocmWebDAVURL(p)
+	if err != nil {
+		return nil, err
+	}
+	auth := &request.BasicAuthorizer{Username: s.Credentials[0].AccessToken.AccessToken}
+	headers["Authorization"] = auth.AuthHeader()
+	res, err := ocmRequest(method, u, headers, nil)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		}
+		return nil, ErrRequestFailed
+	}
+	return res, nil
+}
+
+// ListOCMShare lists the content of a directory inside a share received from
+// an OCM server.
+func (s *Sharing) ListOCMShare(inst *instance.Instance, dir string) ([]OCMEntry, error) {
+	res, err := s.ocmWebDAVRequest("PROPFIND", dir, request.Headers{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var ms WebDAVMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Infof("Invalid PROPFIND response for %s: %s", s.SID, err)
+		return nil, ErrRequestFailed
+	}
+	base, err := s.ocmWebDAVURL(dir)
+	if err != nil {
+		return nil, err
+	}
+	return parseOCMEntries(&ms, base, path.Clean("/"+dir)), nil
+}
+
+// parseOCMEntries transforms the responses of a PROPFIND on the given URL to
+// a list of entries. The response for the directory itself is skipped.
+func parseOCMEntries(ms *WebDAVMultistatus, dirURL, dirPath string) []OCMEntry {
+	self := ""
+	if u, err := url.Parse(dirURL); err == nil {
+		self = strings.TrimSuffix(u.Path, "/")
+	}
+	entries := make([]OCMEntry, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		href := r.Href
+		if u, err := url.Parse(href); err == nil {
+			href = u.Path
+		}
+		href = strings.TrimSuffix(href, "/")
+		if href == self || href == "" {
+			continue
+		}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			name := path.Base(href)
+			entry := OCMEntry{
+				Name: name,
+				Path: path.Join(dirPath, name),
+				Type: "file",
+				Mime: ps.Prop.ContentType,
+				ETag: strings.Trim(ps.Prop.ETag, `"`),
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				entry.Type = "directory"
+				entry.Mime = ""
+			}
+			if ps.Prop.ContentLength != "" {
+				entry.Size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				entry.UpdatedAt = t
+			}
+			entries = append(entries, entry)
+			break
+		}
+	}
+	return entries
+}
+
+// OpenOCMFile returns the response of a GET request for a file inside a
+// share received from an OCM server. The caller must close the body.
+func (s *Sharing) OpenOCMFile(inst *instance.Instance, file string) (*http.Response, error) {
+	return s.ocmWebDAVRequest(http.MethodGet, file, request.Headers{})
+}
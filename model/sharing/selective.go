@@ -0,0 +1,436 @@
+package sharing
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/lock"
+)
+
+// SelectiveSync is used on the instance of a recipient to choose which
+// folders of a shared directory have their content synchronized. The files
+// outside of those folders are kept as placeholders: their metadata are
+// synchronized, but their content is empty on the recipient's instance (and
+// doesn't count in the disk quota). The content can be fetched on demand from
+// the owner's instance.
+type SelectiveSync struct {
+	Enabled bool `json:"enabled"`
+
+	// Folders is the list of the identifiers of the directories (inside the
+	// shared directory) that are materialized.
+	Folders []string `json:"folders,omitempty"`
+
+	// Pending is true when the files must be fetched or evicted by the
+	// share-upload worker after a change of the selection.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// Clone returns a copy of the selective sync settings
+func (sel *SelectiveSync) Clone() *SelectiveSync {
+	if sel == nil {
+		return nil
+	}
+	cloned := *sel
+	cloned.Folders = make([]string, len(sel.Folders))
+	copy(cloned.Folders, sel.Folders)
+	return &cloned
+}
+
+// errBatchDone is used to stop walking the shared directory when enough files
+// have been processed for a job.
+var errBatchDone = errors.New("batch done")
+
+// emptyMD5 is the md5sum of an empty content, used for placeholders.
+var emptyMD5 = md5.New().Sum(nil)
+
+// IsPlaceholder returns true if the given file is a placeholder for a file of
+// a sharing with a selective sync. If the content of the file has been
+// changed locally, the placeholder metadata is stale and it is no longer
+// considered as a placeholder.
+func IsPlaceholder(file *vfs.FileDoc) bool {
+	if _, _, ok := placeholderInfo(file.Metadata); !ok {
+		return false
+	}
+	return file.ByteSize == 0 && bytes.Equal(file.MD5Sum, emptyMD5)
+}
+
+// placeholderInfo returns the size and md5sum of the content on the owner's
+// instance of a file that is kept as a placeholder.
+func placeholderInfo(meta map[string]interface{}) (int64, []byte, bool) {
+	infos, ok := meta[consts.SharingPlaceholderKey].(map[string]interface{})
+	if !ok {
+		return 0, nil, false
+	}
+	var size int64
+	switch v := infos["size"].(type) {
+	case string:
+		size, _ = strconv.ParseInt(v, 10, 64)
+	case float64:
+		size = int64(v)
+	case int64:
+		size = v
+	}
+	var sum []byte
+	switch v := infos["md5sum"].(type) {
+	case string:
+		sum, _ = base64.StdEncoding.DecodeString(v)
+	case []byte:
+		sum = v
+	}
+	if len(sum) == 0 {
+		return 0, nil, false
+	}
+	return size, sum, true
+}
+
+// placeholderMarker returns the value put in the metadata of a placeholder
+// to keep the size and md5sum of the real content.
+func placeholderMarker(size int64, md5sum []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"size":   strconv.FormatInt(size, 10),
+		"md5sum": base64.StdEncoding.EncodeToString(md5sum),
+	}
+}
+
+// asPlaceholder returns a copy of the target with an empty content, and the
+// size and md5sum of the real content kept in its metadata.
+func asPlaceholder(target *FileDocWithRevisions) *FileDocWithRevisions {
+	doc := target.FileDoc.Clone().(*vfs.FileDoc)
+	doc.Metadata[consts.SharingPlaceholderKey] = placeholderMarker(target.ByteSize, target.MD5Sum)
+	doc.ByteSize = 0
+	doc.MD5Sum = emptyMD5
+	return &FileDocWithRevisions{
+		FileDoc:   doc,
+		Revisions: target.Revisions,
+	}
+}
+
+// restorePlaceholderFields is used before sending a file document to the
+// owner's instance: for a placeholder, the size and md5sum of the real
+// content are sent, not those of the empty local content. It returns true if
+// the file is a placeholder.
+func restorePlaceholderFields(file map[string]interface{}) bool {
+	meta, ok := file["metadata"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	size, sum, ok := placeholderInfo(meta)
+	delete(meta, consts.SharingPlaceholderKey)
+	if !ok || file["md5sum"] != base64.StdEncoding.EncodeToString(emptyMD5) {
+		return false
+	}
+	file["size"] = strconv.FormatInt(size, 10)
+	file["md5sum"] = base64.StdEncoding.EncodeToString(sum)
+	return true
+}
+
+// isInsideFolders returns true if the given path is one of the folders or is
+// inside one of them.
+func isInsideFolders(folders []string, pth string) bool {
+	for _, folder := range folders {
+		if strings.HasPrefix(pth+"/", folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedFolder returns the directory shared by this sharing. Selective sync
+// only works for a sharing of a folder.
+func (s *Sharing) sharedFolder(inst *instance.Instance) (*vfs.DirDoc, error) {
+	rule := s.FirstFilesRule()
+	if rule == nil || rule.Selector == couchdb.SelectorReferencedBy || len(rule.Values) != 1 {
+		return nil, ErrInvalidSharing
+	}
+	parts := strings.Split(rule.Values[0], "/")
+	dir, _, err := inst.VFS().DirOrFileByID(parts[len(parts)-1])
+	if err != nil || dir == nil {
+		return nil, ErrInvalidSharing
+	}
+	return dir, nil
+}
+
+// selectedPaths returns the paths of the folders selected for being
+// materialized.
+func (s *Sharing) selectedPaths(inst *instance.Instance) []string {
+	if s.SelectiveSync == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(s.SelectiveSync.Folders))
+	for _, id := range s.SelectiveSync.Folders {
+		if dir, err := inst.VFS().DirByID(id); err == nil {
+			paths = append(paths, dir.Fullpath)
+		}
+	}
+	return paths
+}
+
+// shouldMaterialize returns true if a file with the given dir_id (as sent by
+// the owner's instance) and size must have its content synchronized on this
+// instance.
+func (s *Sharing) shouldMaterialize(inst *instance.Instance, dirID string, size int64) (bool, error) {
+	if s.Owner || s.SelectiveSync == nil || !s.SelectiveSync.Enabled || size == 0 {
+		return true, nil
+	}
+	var parent *vfs.DirDoc
+	var err error
+	if dirID == "" {
+		parent, err = s.sharedFolder(inst)
+	} else {
+		parent, err = inst.VFS().DirByID(dirID)
+	}
+	if err != nil {
+		// The parent directory will be recreated, and we don't know yet where
+		// it will be: let's keep the file as a placeholder, and the reconcile
+		// step will fetch it if needed.
+		return false, nil
+	}
+	return isInsideFolders(s.selectedPaths(inst), parent.Fullpath), nil
+}
+
+// UpdateSelectiveSync changes the selective sync settings of a sharing, on
+// the instance of a recipient. The files are then fetched or evicted in the
+// background by the share-upload worker.
+func (s *Sharing) UpdateSelectiveSync(inst *instance.Instance, sel *SelectiveSync) error {
+	if s.Owner || !s.Active {
+		return ErrInvalidSharing
+	}
+	root, err := s.sharedFolder(inst)
+	if err != nil {
+		return err
+	}
+	folders := make([]string, 0, len(sel.Folders))
+	for _, id := range sel.Folders {
+		dir, err := inst.VFS().DirByID(id)
+		if err != nil || !isInsideFolders([]string{root.Fullpath}, dir.Fullpath) {
+			return ErrFolderNotFound
+		}
+		folders = append(folders, dir.DocID)
+	}
+	s.SelectiveSync = &SelectiveSync{
+		Enabled: sel.Enabled,
+		Folders: folders,
+		Pending: true,
+	}
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	s.pushJob(inst, "share-upload")
+	return nil
+}
+
+// reconcilePlaceholders walks the shared directory to fetch the content of
+// the placeholders inside the selected folders, and to evict the content of
+// the files outside of them. It returns true if there are more files to
+// process. The files that cannot be reconciled are skipped: they don't count
+// in the batch, and if a batch has only failures (like when the owner's
+// instance is unreachable), the walk stops without asking for another job.
+// The sharing is then kept as pending, and the reconciliation will be tried
+// again with the next upload. When the walk reaches the end, the files that
+// have failed are left as placeholders, that the user can fetch on demand.
+func (s *Sharing) reconcilePlaceholders(inst *instance.Instance) (bool, error) {
+	root, err := s.sharedFolder(inst)
+	if err != nil {
+		return false, err
+	}
+	enabled := s.SelectiveSync.Enabled
+	folders := s.selectedPaths(inst)
+	done, failed := 0, 0
+	err = vfs.Walk(inst.VFS(), root.Fullpath, func(name string, dir *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+		materialize := !enabled || isInsideFolders(folders, path.Dir(name))
+		if materialize && IsPlaceholder(file) {
+			err = s.FetchPlaceholder(inst, file)
+		} else if !materialize && !IsPlaceholder(file) && file.ByteSize > 0 {
+			err = s.evictFile(inst, file)
+		} else {
+			return nil
+		}
+		if err == vfs.ErrFileTooBig {
+			return err
+		}
+		if err != nil {
+			// The file is left as is, the user can still fetch it on demand
+			inst.Logger().WithField("nspace", "sharing").
+				Warnf("Cannot reconcile placeholder %s: %s", file.DocID, err)
+			failed++
+			if failed >= BatchSize && done == 0 {
+				return errBatchDone
+			}
+			return nil
+		}
+		done++
+		if done >= BatchSize {
+			return errBatchDone
+		}
+		return nil
+	})
+	if err == errBatchDone {
+		// No progress means that the next batch would fail the same way
+		return done > 0, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.SelectiveSync.Pending = false
+	return false, couchdb.UpdateDoc(inst, s)
+}
+
+// FetchPlaceholder downloads the content of a placeholder from the owner's
+// instance, and replaces the empty local content with it.
+func (s *Sharing) FetchPlaceholder(inst *instance.Instance, file *vfs.FileDoc) error {
+	if s.Owner || len(s.Credentials) == 0 {
+		return ErrInvalidSharing
+	}
+	sid := consts.Files + "/" + file.DocID
+	mu := lock.ReadWrite(inst, "shared/"+sid)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	// The file may have been updated while waiting for the lock
+	olddoc, err := inst.VFS().FileByID(file.DocID)
+	if err != nil {
+		return err
+	}
+	if !IsPlaceholder(olddoc) {
+		return nil
+	}
+	// Only the files of this sharing can be fetched with its credentials
+	root, err := s.sharedFolder(inst)
+	if err != nil {
+		return err
+	}
+	parent, err := inst.VFS().DirByID(olddoc.DirID)
+	if err != nil || !isInsideFolders([]string{root.Fullpath}, parent.Fullpath) {
+		return ErrMissingFileMetadata
+	}
+
+	m := &s.Members[0]
+	creds := &s.Credentials[0]
+	if creds.AccessToken == nil {
+		return ErrInvalidSharing
+	}
+	u, err := url.Parse(m.Instance)
+	if m.Instance == "" || err != nil {
+		return ErrInvalidURL
+	}
+	opts := &request.Options{
+		Method: http.MethodGet,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/sharings/" + s.SID + "/io.cozy.files/" + olddoc.DocID + "/content",
+		Headers: request.Headers{
+			"Authorization": "Bearer " + creds.AccessToken.AccessToken,
+		},
+		ParseError: ParseRequestError,
+		Client:     http.DefaultClient,
+	}
+	res, err := request.Req(opts)
+	if res != nil && res.StatusCode/100 == 4 {
+		res, err = RefreshToken(inst, err, s, m, creds, opts, nil)
+	}
+	if err != nil {
+		if res != nil && res.StatusCode/100 == 5 {
+			return ErrInternalServerError
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	newdoc := olddoc.Clone().(*vfs.FileDoc)
+	delete(newdoc.Metadata, consts.SharingPlaceholderKey)
+	newdoc.MD5Sum = nil
+	newdoc.ByteSize = res.ContentLength
+	return replaceContent(inst, olddoc, newdoc, res.Body)
+}
+
+// evictFile replaces the content of a file by an empty content, and keeps the
+// file as a placeholder.
+func (s *Sharing) evictFile(inst *instance.Instance, file *vfs.FileDoc) error {
+	sid := consts.Files + "/" + file.DocID
+	mu := lock.ReadWrite(inst, "shared/"+sid)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	olddoc, err := inst.VFS().FileByID(file.DocID)
+	if err != nil {
+		return err
+	}
+	if IsPlaceholder(olddoc) || olddoc.ByteSize == 0 {
+		return nil
+	}
+	newdoc := olddoc.Clone().(*vfs.FileDoc)
+	newdoc.Metadata[consts.SharingPlaceholderKey] = placeholderMarker(olddoc.ByteSize, olddoc.MD5Sum)
+	newdoc.ByteSize = 0
+	newdoc.MD5Sum = emptyMD5
+	return replaceContent(inst, olddoc, newdoc, ioutil.NopCloser(bytes.NewReader(nil)))
+}
+
+// replaceContent writes a new content for a file, without keeping the
+// previous content as an old version: it is either an empty placeholder, or
+// a content that can be fetched again from the owner's instance.
+func replaceContent(inst *instance.Instance, olddoc, newdoc *vfs.FileDoc, body io.ReadCloser) error {
+	fs := inst.VFS()
+	version := vfs.NewVersion(olddoc)
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return err
+	}
+	if err = copyFileContent(inst, file, body); err != nil {
+		return err
+	}
+	if v, err := vfs.FindVersion(inst, version.DocID); err == nil {
+		if err = fs.CleanOldVersion(olddoc.DocID, v); err != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Infof("Cannot clean version %s: %s", v.DocID, err)
+		}
+	}
+	return nil
+}
+
+// GetFileContent returns the file on the owner's instance for the given
+// XORed identifier, if it is a file shared with the member. It is used by a
+// recipient to fetch the content of a placeholder.
+func (s *Sharing) GetFileContent(inst *instance.Instance, m *Member, xoredID string) (*vfs.FileDoc, error) {
+	creds := s.FindCredentials(m)
+	if creds == nil {
+		return nil, ErrInvalidSharing
+	}
+	fileID := XorID(xoredID, creds.XorKey)
+	ref := &SharedRef{}
+	err := couchdb.GetDoc(inst, consts.Shared, consts.Files+"/"+fileID, ref)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, ErrMissingFileMetadata
+		}
+		return nil, err
+	}
+	info, ok := ref.Infos[s.SID]
+	if !ok || info.Removed || !info.Binary {
+		return nil, ErrMissingFileMetadata
+	}
+	return inst.VFS().FileByID(fileID)
+}
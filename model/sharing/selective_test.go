@@ -0,0 +1,74 @@
+package sharing
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsInsideFolders(t *testing.T) {
+	folders := []string{"/Shared/Photos", "/Shared/Docs/2020"}
+	assert.True(t, isInsideFolders(folders, "/Shared/Photos"))
+	assert.True(t, isInsideFolders(folders, "/Shared/Photos/Holidays"))
+	assert.True(t, isInsideFolders(folders, "/Shared/Docs/2020/Bills"))
+	assert.False(t, isInsideFolders(folders, "/Shared/Photos2"))
+	assert.False(t, isInsideFolders(folders, "/Shared/Docs"))
+	assert.False(t, isInsideFolders(nil, "/Shared/Photos"))
+}
+
+func TestPlaceholder(t *testing.T) {
+	sum := md5.Sum([]byte("hello world"))
+	target := &FileDocWithRevisions{
+		FileDoc: &vfs.FileDoc{
+			Type:     consts.FileType,
+			DocID:    "ca2b5b6e-8e5f-0138-9a68-543d7eb8149c",
+			DocName:  "hello.txt",
+			ByteSize: 11,
+			MD5Sum:   sum[:],
+			Metadata: vfs.Metadata{"foo": "bar"},
+		},
+	}
+	placeholder := asPlaceholder(target)
+	assert.True(t, IsPlaceholder(placeholder.FileDoc))
+	assert.False(t, IsPlaceholder(target.FileDoc))
+	assert.EqualValues(t, 0, placeholder.ByteSize)
+	assert.EqualValues(t, 11, target.ByteSize)
+	assert.NotContains(t, target.Metadata, consts.SharingPlaceholderKey)
+
+	size, md5sum, ok := placeholderInfo(placeholder.Metadata)
+	assert.True(t, ok)
+	assert.EqualValues(t, 11, size)
+	assert.Equal(t, sum[:], md5sum)
+
+	// The content has been written locally: it is no longer a placeholder
+	placeholder.ByteSize = 4
+	assert.False(t, IsPlaceholder(placeholder.FileDoc))
+
+	file := map[string]interface{}{
+		"size":   "0",
+		"md5sum": base64.StdEncoding.EncodeToString(emptyMD5),
+		"metadata": map[string]interface{}{
+			"foo":                        "bar",
+			consts.SharingPlaceholderKey: placeholderMarker(11, sum[:]),
+		},
+	}
+	assert.True(t, restorePlaceholderFields(file))
+	assert.Equal(t, "11", file["size"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), file["md5sum"])
+	assert.NotContains(t, file["metadata"], consts.SharingPlaceholderKey)
+
+	file = map[string]interface{}{
+		"size":   "4",
+		"md5sum": "8Oc7Q3pUXVkrmd6i/9YdqQ==",
+		"metadata": map[string]interface{}{
+			consts.SharingPlaceholderKey: placeholderMarker(11, sum[:]),
+		},
+	}
+	assert.False(t, restorePlaceholderFields(file))
+	assert.Equal(t, "4", file["size"])
+	assert.NotContains(t, file["metadata"], consts.SharingPlaceholderKey)
+}
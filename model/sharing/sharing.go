@@ -59,6 +59,13 @@ type Sharing struct {
 	ShortcutID  string    `json:"shortcut_id,omitempty"`
 	MovedFrom   string    `json:"moved_from,omitempty"`
 
+	// SelectiveSync is only used on the instance of a recipient
+	SelectiveSync *SelectiveSync `json:"selective_sync,omitempty"`
+
+	// OCM is only used on the instance of a recipient, for a share received
+	// from an Open Cloud Mesh server
+	OCM *OCMShare `json:"ocm,omitempty"`
+
 	Rules []Rule `json:"rules"`
 
 	// Members[0] is the owner, Members[1...] are the recipients
@@ -95,6 +102,12 @@ func (s *Sharing) Clone() couchdb.Doc {
 	}
 	cloned.Members = make([]Member, len(s.Members))
 	copy(cloned.Members, s.Members)
+	for i := range s.Members {
+		if s.Members[i].OCM != nil {
+			ocm := *s.Members[i].OCM
+			cloned.Members[i].OCM = &ocm
+		}
+	}
 	cloned.Credentials = make([]Credentials, len(s.Credentials))
 	copy(cloned.Credentials, s.Credentials)
 	for i := range s.Credentials {
@@ -107,6 +120,11 @@ func (s *Sharing) Clone() couchdb.Doc {
 		cloned.Credentials[i].XorKey = make([]byte, len(s.Credentials[i].XorKey))
 		copy(cloned.Credentials[i].XorKey, s.Credentials[i].XorKey)
 	}
+	cloned.SelectiveSync = s.SelectiveSync.Clone()
+	if s.OCM != nil {
+		ocm := *s.OCM
+		cloned.OCM = &ocm
+	}
 	return &cloned
 }
 
@@ -193,6 +211,9 @@ func (s *Sharing) CreatePreviewPermissions(inst *instance.Instance) (*permission
 		if key == "" {
 			key = m.Instance
 		}
+		if key == "" && m.OCM != nil {
+			key = m.OCM.CloudID
+		}
 
 		// Checks that we don't already have a sharing code
 		if doc != nil {
@@ -435,6 +456,9 @@ func (s *Sharing) RevokeRecipientBySelf(inst *instance.Instance, sharingDirTrash
 	if s.Owner || len(s.Members) == 0 {
 		return ErrInvalidSharing
 	}
+	if s.OCM != nil {
+		return s.DeclineOCMShare(inst)
+	}
 	if err := s.RevokeOwner(inst); err != nil {
 		return err
 	}
@@ -577,7 +601,7 @@ func (s *Sharing) RevokeRecipientByNotification(inst *instance.Instance, m *Memb
 // NoMoreRecipient cleans up the sharing if there is no more active recipient
 func (s *Sharing) NoMoreRecipient(inst *instance.Instance) error {
 	for _, m := range s.Members {
-		if m.Status == MemberStatusReady {
+		if m.Status == MemberStatusReady || m.Status == MemberStatusFederated {
 			return couchdb.UpdateDoc(inst, s)
 		}
 	}
@@ -949,203 +973,212 @@ func CheckSharings(inst *instance.Instance) ([]map[string]interface{}, error) {
 		if err := json.Unmarshal(data, s); err != nil {
 			return err
 		}
+		checks = append(checks, s.checkConsistency(inst)...)
+		return nil
+	})
+	return checks, err
+}
 
-		if err := s.ValidateRules(); err != nil {
-			checks = append(checks, map[string]interface{}{
-				"id":    s.SID,
-				"type":  "invalid_rules",
-				"error": err.Error(),
-			})
+// checkConsistency checks the triggers and members/credentials of a sharing.
+func (s *Sharing) checkConsistency(inst *instance.Instance) []map[string]interface{} {
+	checks := []map[string]interface{}{}
+
+	if err := s.ValidateRules(); err != nil {
+		checks = append(checks, map[string]interface{}{
+			"id":    s.SID,
+			"type":  "invalid_rules",
+			"error": err.Error(),
+		})
+	}
+
+	accepted := false
+	for _, m := range s.Members {
+		if m.Status == MemberStatusReady {
+			accepted = true
 		}
+	}
 
-		accepted := false
-		for _, m := range s.Members {
-			if m.Status == MemberStatusReady {
-				accepted = true
+	// Check triggers
+	if s.Active && accepted {
+		if s.Triggers.TrackID == "" {
+			checks = append(checks, map[string]interface{}{
+				"id":      s.SID,
+				"type":    "missing_trigger_on_active_sharing",
+				"trigger": "track",
+			})
+		} else {
+			err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.TrackID, nil)
+			if couchdb.IsNotFoundError(err) {
+				checks = append(checks, map[string]interface{}{
+					"id":         s.SID,
+					"type":       "missing_trigger_on_active_sharing",
+					"trigger":    "track",
+					"trigger_id": s.Triggers.TrackID,
+				})
 			}
 		}
 
-		// Check triggers
-		if s.Active && accepted {
-			if s.Triggers.TrackID == "" {
+		if s.Owner || !s.ReadOnly() {
+			if s.Triggers.ReplicateID == "" {
 				checks = append(checks, map[string]interface{}{
 					"id":      s.SID,
 					"type":    "missing_trigger_on_active_sharing",
-					"trigger": "track",
+					"trigger": "replicate",
 				})
 			} else {
-				err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.TrackID, nil)
+				err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.ReplicateID, nil)
 				if couchdb.IsNotFoundError(err) {
 					checks = append(checks, map[string]interface{}{
 						"id":         s.SID,
 						"type":       "missing_trigger_on_active_sharing",
-						"trigger":    "track",
-						"trigger_id": s.Triggers.TrackID,
+						"trigger":    "replicate",
+						"trigger_id": s.Triggers.ReplicateID,
 					})
 				}
 			}
 
-			if s.Owner || !s.ReadOnly() {
-				if s.Triggers.ReplicateID == "" {
+			if s.FirstFilesRule() != nil {
+				if s.Triggers.UploadID == "" {
 					checks = append(checks, map[string]interface{}{
 						"id":      s.SID,
 						"type":    "missing_trigger_on_active_sharing",
-						"trigger": "replicate",
+						"trigger": "upload",
 					})
 				} else {
-					err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.ReplicateID, nil)
+					err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.UploadID, nil)
 					if couchdb.IsNotFoundError(err) {
 						checks = append(checks, map[string]interface{}{
 							"id":         s.SID,
 							"type":       "missing_trigger_on_active_sharing",
-							"trigger":    "replicate",
-							"trigger_id": s.Triggers.ReplicateID,
+							"trigger":    "upload",
+							"trigger_id": s.Triggers.UploadID,
 						})
 					}
 				}
-
-				if s.FirstFilesRule() != nil {
-					if s.Triggers.UploadID == "" {
-						checks = append(checks, map[string]interface{}{
-							"id":      s.SID,
-							"type":    "missing_trigger_on_active_sharing",
-							"trigger": "upload",
-						})
-					} else {
-						err := couchdb.GetDoc(inst, consts.Triggers, s.Triggers.UploadID, nil)
-						if couchdb.IsNotFoundError(err) {
-							checks = append(checks, map[string]interface{}{
-								"id":         s.SID,
-								"type":       "missing_trigger_on_active_sharing",
-								"trigger":    "upload",
-								"trigger_id": s.Triggers.UploadID,
-							})
-						}
-					}
-				}
-			}
-		} else {
-			if s.Triggers.TrackID != "" {
-				checks = append(checks, map[string]interface{}{
-					"id":         s.SID,
-					"type":       "trigger_on_inactive_sharing",
-					"trigger":    "track",
-					"trigger_id": s.Triggers.TrackID,
-				})
-			}
-			if s.Triggers.ReplicateID != "" {
-				checks = append(checks, map[string]interface{}{
-					"id":         s.SID,
-					"type":       "trigger_on_inactive_sharing",
-					"trigger":    "replicate",
-					"trigger_id": s.Triggers.TrackID,
-				})
-			}
-			if s.Triggers.UploadID != "" {
-				checks = append(checks, map[string]interface{}{
-					"id":         s.SID,
-					"type":       "trigger_on_inactive_sharing",
-					"trigger":    "upload",
-					"trigger_id": s.Triggers.TrackID,
-				})
 			}
 		}
-
-		// Check members and credentials
-		if len(s.Members) < 2 {
+	} else {
+		if s.Triggers.TrackID != "" {
 			checks = append(checks, map[string]interface{}{
 				"id":         s.SID,
-				"type":       "not_enough_members",
-				"nb_members": len(s.Members),
+				"type":       "trigger_on_inactive_sharing",
+				"trigger":    "track",
+				"trigger_id": s.Triggers.TrackID,
 			})
-			return nil
 		}
-
-		for i, m := range s.Members {
-			isFirst := i == 0
-			isOwner := m.Status == MemberStatusOwner
-			if isFirst != isOwner {
-				checks = append(checks, map[string]interface{}{
-					"id":     s.SID,
-					"type":   "invalid_member_status",
-					"member": i,
-					"status": m.Status,
-				})
-			}
+		if s.Triggers.ReplicateID != "" {
+			checks = append(checks, map[string]interface{}{
+				"id":         s.SID,
+				"type":       "trigger_on_inactive_sharing",
+				"trigger":    "replicate",
+				"trigger_id": s.Triggers.TrackID,
+			})
+		}
+		if s.Triggers.UploadID != "" {
+			checks = append(checks, map[string]interface{}{
+				"id":         s.SID,
+				"type":       "trigger_on_inactive_sharing",
+				"trigger":    "upload",
+				"trigger_id": s.Triggers.TrackID,
+			})
 		}
+	}
 
-		if !s.Active {
-			return nil
+	// Check members and credentials
+	if len(s.Members) < 2 {
+		checks = append(checks, map[string]interface{}{
+			"id":         s.SID,
+			"type":       "not_enough_members",
+			"nb_members": len(s.Members),
+		})
+		return checks
+	}
+
+	for i, m := range s.Members {
+		isFirst := i == 0
+		isOwner := m.Status == MemberStatusOwner
+		if isFirst != isOwner {
+			checks = append(checks, map[string]interface{}{
+				"id":     s.SID,
+				"type":   "invalid_member_status",
+				"member": i,
+				"status": m.Status,
+			})
 		}
+	}
 
-		if s.Owner {
-			for i, m := range s.Members {
-				if i == 0 || m.Status != MemberStatusReady {
-					continue
-				}
-				if s.Credentials[i-1].Client == nil {
-					checks = append(checks, map[string]interface{}{
-						"id":     s.SID,
-						"type":   "missing_oauth_client",
-						"member": i,
-						"owner":  true,
-					})
-				}
-				if s.Credentials[i-1].AccessToken == nil {
-					checks = append(checks, map[string]interface{}{
-						"id":     s.SID,
-						"type":   "missing_access_token",
-						"member": i,
-						"owner":  true,
-					})
-				}
-				if m.Instance == "" {
-					checks = append(checks, map[string]interface{}{
-						"id":     s.SID,
-						"type":   "missing_instance_for_member",
-						"member": i,
-					})
-				}
-			}
+	if !s.Active {
+		return checks
+	}
 
-			if len(s.Credentials)+1 != len(s.Members) {
-				checks = append(checks, map[string]interface{}{
-					"id":         s.SID,
-					"type":       "invalid_number_of_credentials",
-					"owner":      true,
-					"nb_members": len(s.Credentials),
-				})
-				return nil
+	if s.Owner {
+		// The number of credentials is checked first, as they are accessed
+		// by the index of the members
+		if len(s.Credentials)+1 != len(s.Members) {
+			checks = append(checks, map[string]interface{}{
+				"id":         s.SID,
+				"type":       "invalid_number_of_credentials",
+				"owner":      true,
+				"nb_members": len(s.Credentials),
+			})
+			return checks
+		}
+
+		for i, m := range s.Members {
+			if i == 0 || m.Status != MemberStatusReady {
+				continue
 			}
-		} else {
-			if len(s.Credentials) != 1 {
+			if s.Credentials[i-1].Client == nil {
 				checks = append(checks, map[string]interface{}{
-					"id":         s.SID,
-					"type":       "invalid_number_of_credentials",
-					"owner":      false,
-					"nb_members": len(s.Credentials),
+					"id":     s.SID,
+					"type":   "missing_oauth_client",
+					"member": i,
+					"owner":  true,
 				})
-				return nil
 			}
-
-			if s.Credentials[0].InboundClientID == "" {
+			if s.Credentials[i-1].AccessToken == nil {
 				checks = append(checks, map[string]interface{}{
-					"id":    s.SID,
-					"type":  "missing_inbound_client_id",
-					"owner": false,
+					"id":     s.SID,
+					"type":   "missing_access_token",
+					"member": i,
+					"owner":  true,
 				})
 			}
-
-			if !s.ReadOnly() && s.Members[0].Instance == "" {
+			if m.Instance == "" {
 				checks = append(checks, map[string]interface{}{
 					"id":     s.SID,
 					"type":   "missing_instance_for_member",
-					"member": 0,
+					"member": i,
 				})
 			}
 		}
+	} else {
+		if len(s.Credentials) != 1 {
+			checks = append(checks, map[string]interface{}{
+				"id":         s.SID,
+				"type":       "invalid_number_of_credentials",
+				"owner":      false,
+				"nb_members": len(s.Credentials),
+			})
+			return checks
+		}
 
-		return nil
-	})
-	return checks, err
+		if s.Credentials[0].InboundClientID == "" {
+			checks = append(checks, map[string]interface{}{
+				"id":    s.SID,
+				"type":  "missing_inbound_client_id",
+				"owner": false,
+			})
+		}
+
+		if !s.ReadOnly() && s.Members[0].Instance == "" {
+			checks = append(checks, map[string]interface{}{
+				"id":     s.SID,
+				"type":   "missing_instance_for_member",
+				"member": 0,
+			})
+		}
+	}
+
+	return checks
 }
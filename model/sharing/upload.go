@@ -1,10 +1,13 @@
 package sharing
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -49,6 +52,15 @@ func (s *Sharing) Upload(inst *instance.Instance, errors int) error {
 		}
 	}
 
+	var morePlaceholders bool
+	if !s.Owner && s.SelectiveSync != nil && s.SelectiveSync.Pending {
+		var err error
+		morePlaceholders, err = s.reconcilePlaceholders(inst)
+		if err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+
 	lastTry := errors+1 == MaxRetries
 	for i := 0; i < BatchSize; i++ {
 		if len(members) == 0 {
@@ -68,7 +80,7 @@ func (s *Sharing) Upload(inst *instance.Instance, errors int) error {
 	if errm != nil {
 		s.retryWorker(inst, "share-upload", errors)
 		inst.Logger().WithField("nspace", "upload").Infof("errm=%s\n", errm)
-	} else if len(members) > 0 {
+	} else if len(members) > 0 || morePlaceholders {
 		s.pushJob(inst, "share-upload")
 	}
 	return errm
@@ -238,6 +250,7 @@ func (s *Sharing) uploadFile(inst *instance.Instance, m *Member, file map[string
 		return err
 	}
 	origFileID := file["_id"].(string)
+	placeholder := restorePlaceholderFields(file)
 	s.TransformFileToSent(file, creds.XorKey, ruleIndex)
 	xoredFileID := file["_id"].(string)
 	body, err := json.Marshal(file)
@@ -279,11 +292,28 @@ func (s *Sharing) uploadFile(inst *instance.Instance, m *Member, file map[string
 		return err
 	}
 
+	// The content of a placeholder is empty, it must not be sent to the
+	// owner. If the owner asks for it, it means that the content has been
+	// changed on its side, and it will be synchronized later.
+	if placeholder {
+		return nil
+	}
+
 	fs := inst.VFS()
 	fileDoc, err := fs.FileByID(origFileID)
 	if err != nil {
 		return err
 	}
+
+	if resBody.Signature != nil && resBody.Signature.isValid() && fileDoc.ByteSize >= minDeltaSize {
+		err = s.uploadDelta(inst, m, creds, resBody, fileDoc)
+		if err != errDeltaRejected {
+			return err
+		}
+		// The other instance no longer has the base version of the delta,
+		// let's fall back to a full transfer.
+	}
+
 	content, err := fs.OpenFile(fileDoc)
 	if err != nil {
 		return err
@@ -313,6 +343,58 @@ func (s *Sharing) uploadFile(inst *instance.Instance, m *Member, file map[string
 	return nil
 }
 
+// errDeltaRejected is used when the other instance cannot apply a delta.
+var errDeltaRejected = errors.New("delta rejected")
+
+// uploadDelta sends the content of a file as a delta with the version known
+// by the other instance, described by its signature.
+func (s *Sharing) uploadDelta(inst *instance.Instance, m *Member, creds *Credentials, key KeyToUpload, fileDoc *vfs.FileDoc) error {
+	inst.Logger().WithField("nspace", "upload").Debugf("uploadDelta %s", fileDoc.DocID)
+	u, err := url.Parse(m.Instance)
+	if err != nil {
+		return err
+	}
+	content, err := inst.VFS().OpenFile(fileDoc)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		err := ComputeDelta(key.Signature, content, pw)
+		content.Close()
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	res, err := request.Req(&request.Options{
+		Method: http.MethodPut,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/sharings/" + s.SID + "/io.cozy.files/" + key.Key,
+		Queries: url.Values{
+			"from":  {inst.ContextualDomain()},
+			"delta": {"true"},
+		},
+		Headers: request.Headers{
+			"Authorization": "Bearer " + creds.AccessToken.AccessToken,
+			"Content-Type":  "application/octet-stream",
+		},
+		Body:   pr,
+		Client: http.DefaultClient,
+	})
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusPreconditionFailed {
+			return errDeltaRejected
+		}
+		if res != nil && res.StatusCode/100 == 5 {
+			return ErrInternalServerError
+		}
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
 // FileDocWithRevisions is the struct of the payload for synchronizing a file
 type FileDocWithRevisions struct {
 	*vfs.FileDoc
@@ -325,9 +407,11 @@ func (f *FileDocWithRevisions) Clone() couchdb.Doc {
 }
 
 // KeyToUpload contains the key for uploading a file (when syncing metadata is
-// not enough)
+// not enough). The signature of the current version of the file is also
+// given when the content can be sent as a delta.
 type KeyToUpload struct {
-	Key string `json:"key"`
+	Key       string     `json:"key"`
+	Signature *Signature `json:"signature,omitempty"`
 }
 
 func (s *Sharing) createUploadKey(inst *instance.Instance, target *FileDocWithRevisions) (*KeyToUpload, error) {
@@ -358,6 +442,13 @@ func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions
 			if rule, _ := s.findRuleForNewFile(target.FileDoc); rule == nil {
 				return nil, ErrSafety
 			}
+			materialize, errm := s.shouldMaterialize(inst, target.DirID, target.ByteSize)
+			if errm != nil {
+				return nil, errm
+			}
+			if !materialize {
+				return nil, s.createPlaceholder(inst, target)
+			}
 			return s.createUploadKey(inst, target)
 		}
 		return nil, err
@@ -377,12 +468,56 @@ func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions
 		// It's just the echo, there is nothing to do
 		return nil, nil
 	}
-	if !bytes.Equal(target.MD5Sum, current.MD5Sum) {
+	if IsPlaceholder(current) {
+		materialize, errm := s.shouldMaterialize(inst, target.DirID, target.ByteSize)
+		if errm != nil {
+			return nil, errm
+		}
+		if !materialize {
+			return nil, s.updateFileMetadata(inst, asPlaceholder(target), current, &ref)
+		}
 		return s.createUploadKey(inst, target)
 	}
+	if !bytes.Equal(target.MD5Sum, current.MD5Sum) {
+		key, err := s.createUploadKey(inst, target)
+		if err != nil {
+			return nil, err
+		}
+		key.Signature = signatureForDelta(inst, current, target.ByteSize)
+		return key, nil
+	}
 	return nil, s.updateFileMetadata(inst, target, current, &ref)
 }
 
+// signatureForDelta computes the signature of the current version of a file,
+// that can be used by the other instance to send only a delta for the new
+// version. It returns nil if the delta transfer is not worth it.
+func signatureForDelta(inst *instance.Instance, current *vfs.FileDoc, newSize int64) *Signature {
+	if current.ByteSize < minDeltaSize || current.ByteSize > maxDeltaSize || newSize < minDeltaSize {
+		return nil
+	}
+	content, err := inst.VFS().OpenFile(current)
+	if err != nil {
+		return nil
+	}
+	defer content.Close()
+	sig, err := ComputeSignature(content, current.ByteSize, current.MD5Sum)
+	if err != nil {
+		inst.Logger().WithField("nspace", "upload").
+			Infof("Cannot compute signature for %s: %s", current.DocID, err)
+		return nil
+	}
+	return sig
+}
+
+// createPlaceholder creates a file on the instance of a recipient, with the
+// metadata sent by the owner, but without its content.
+func (s *Sharing) createPlaceholder(inst *instance.Instance, target *FileDocWithRevisions) error {
+	inst.Logger().WithField("nspace", "upload").Debugf("createPlaceholder %s", target.DocID)
+	body := ioutil.NopCloser(bytes.NewReader(nil))
+	return s.UploadNewFile(inst, asPlaceholder(target), body)
+}
+
 // prepareFileWithAncestors find the parent directory for file, and recreates it
 // if it is missing.
 func (s *Sharing) prepareFileWithAncestors(inst *instance.Instance, newdoc *vfs.FileDoc, dirID string) error {
@@ -469,8 +604,9 @@ func (s *Sharing) updateFileMetadata(inst *instance.Instance, target *FileDocWit
 }
 
 // HandleFileUpload is used to receive a file upload when synchronizing just
-// the metadata was not enough.
-func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.ReadCloser) error {
+// the metadata was not enough. If delta is true, the body is a delta with
+// the current version of the file, and not the full content.
+func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.ReadCloser, delta bool) error {
 	defer body.Close()
 	target, err := getStore().Get(inst, key)
 	inst.Logger().WithField("nspace", "upload").Debugf("HandleFileUpload %#v %#v", target.FileDoc, target.Revisions)
@@ -494,12 +630,45 @@ func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.
 		return err
 	}
 
+	if delta {
+		if current == nil || IsPlaceholder(current) {
+			return ErrInvalidDelta
+		}
+		patched, err := patchContent(inst, current, body)
+		if err != nil {
+			return err
+		}
+		defer patched.Close()
+		return s.UploadExistingFile(inst, target, current, patched)
+	}
+
 	if current == nil {
 		return s.UploadNewFile(inst, target, body)
 	}
 	return s.UploadExistingFile(inst, target, current, body)
 }
 
+// patchContent returns a reader for the new version of a file, rebuilt from
+// its current version and the delta.
+func patchContent(inst *instance.Instance, current *vfs.FileDoc, delta io.Reader) (io.ReadCloser, error) {
+	r := bufio.NewReader(delta)
+	bs, err := readDeltaHeader(r, current.MD5Sum)
+	if err != nil {
+		return nil, err
+	}
+	base, err := inst.VFS().OpenFile(current)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		err := applyDeltaOps(base, current.ByteSize, bs, r, pw)
+		base.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
 // UploadNewFile is used to receive a new file.
 func (s *Sharing) UploadNewFile(inst *instance.Instance, target *FileDocWithRevisions, body io.ReadCloser) error {
 	inst.Logger().WithField("nspace", "upload").Debugf("UploadNewFile")
@@ -107,6 +107,11 @@ type Config struct {
 	CredentialsEncryptorKey string
 	CredentialsDecryptorKey string
 
+	// SecretFiles are the paths of the configuration files, and of the keys
+	// and secrets that they reference. They are hidden from the konnectors in
+	// the sandbox.
+	SecretFiles []string
+
 	RemoteAssets map[string]string
 
 	Fs             Fs
@@ -202,7 +207,20 @@ type Jobs struct {
 
 // Konnectors contains the configuration values for the konnectors
 type Konnectors struct {
-	Cmd string
+	Cmd     string
+	Sandbox KonnectorsSandbox
+}
+
+// KonnectorsSandbox contains the configuration of the built-in Linux sandbox
+// for running the konnectors.
+type KonnectorsSandbox struct {
+	Enabled      bool
+	CgroupDir    string
+	CPU          float64
+	Memory       uint
+	Pids         int
+	AllowedHosts []string
+	HiddenPaths  []string
 }
 
 // Matomo contains the configuration for the JS tracking
@@ -226,7 +244,8 @@ type Office struct {
 }
 
 // Notifications contains the configuration for the mobile push-notification
-// center, for Android and iOS
+// center, for Android and iOS, and for the Web Push notifications of the
+// browsers
 type Notifications struct {
 	Development bool
 
@@ -238,7 +257,23 @@ type Notifications struct {
 	IOSKeyID               string
 	IOSTeamID              string
 
+	VAPIDPrivateKeyPath string
+	VAPIDSubject        string
+
 	Contexts map[string]SMS
+	// Channels are the channels that the users can configure for receiving
+	// their notifications, by context and then by name of channel
+	Channels map[string]map[string]NotificationChannel
+}
+
+// NotificationChannel contains the configuration of a channel for sending
+// notifications to an external service, like a chat tool. The meaning of the
+// URL and the token depends of the channel: for Matrix, it is the homeserver
+// and the access token of the bot account, and for ntfy, the server and its
+// access token.
+type NotificationChannel struct {
+	URL   string
+	Token string
 }
 
 // SMS contains the configuration to send notifications by SMS.
@@ -370,6 +405,20 @@ func GetOIDC(contextName string) (map[string]interface{}, bool) {
 	return config, ok
 }
 
+// GetSAML returns the SAML config for the given context (with a boolean to say
+// if SAML is enabled).
+func GetSAML(contextName string) (map[string]interface{}, bool) {
+	if contextName == "" {
+		return nil, false
+	}
+	auth, ok := config.Authentication[contextName].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	config, ok := auth["saml"].(map[string]interface{})
+	return config, ok
+}
+
 var defaultPasswordResetInterval = 15 * time.Minute
 
 // PasswordResetInterval returns the minimal delay between two password reset
@@ -436,7 +485,37 @@ func Setup(cfgFile string) (err error) {
 		}
 	}
 
-	return UseViper(viper.GetViper())
+	if err := UseViper(viper.GetViper()); err != nil {
+		return err
+	}
+	for _, cfgFile = range cfgFiles {
+		if abs, err := filepath.Abs(cfgFile); err == nil {
+			config.SecretFiles = append(config.SecretFiles, abs)
+		}
+	}
+	return nil
+}
+
+// secretFiles returns the paths of the keys and secrets referenced by the
+// configuration.
+func secretFiles(v *viper.Viper, adminSecretFile string) []string {
+	var files []string
+	for _, key := range []string{
+		"couchdb.client_key",
+		"fs.client_key",
+		"vault.credentials_encryptor_key",
+		"vault.credentials_decryptor_key",
+		"notifications.ios_certificate_key_path",
+		"notifications.vapid_private_key_path",
+	} {
+		if file := v.GetString(key); file != "" {
+			files = append(files, file)
+		}
+	}
+	if file, err := FindConfigFile(adminSecretFile); err == nil {
+		files = append(files, file)
+	}
+	return files
 }
 
 func applyDefaults(v *viper.Viper) {
@@ -739,6 +818,8 @@ func UseViper(v *viper.Viper) error {
 		CredentialsEncryptorKey: v.GetString("vault.credentials_encryptor_key"),
 		CredentialsDecryptorKey: v.GetString("vault.credentials_decryptor_key"),
 
+		SecretFiles: secretFiles(v, adminSecretFile),
+
 		Fs: Fs{
 			URL:           fsURL,
 			Transport:     fsClient.Transport,
@@ -757,6 +838,15 @@ func UseViper(v *viper.Viper) error {
 		Jobs: jobs,
 		Konnectors: Konnectors{
 			Cmd: v.GetString("konnectors.cmd"),
+			Sandbox: KonnectorsSandbox{
+				Enabled:      v.GetBool("konnectors.sandbox.enabled"),
+				CgroupDir:    v.GetString("konnectors.sandbox.cgroup"),
+				CPU:          v.GetFloat64("konnectors.sandbox.cpu"),
+				Memory:       v.GetSizeInBytes("konnectors.sandbox.memory"),
+				Pids:         v.GetInt("konnectors.sandbox.pids"),
+				AllowedHosts: v.GetStringSlice("konnectors.sandbox.allowed_hosts"),
+				HiddenPaths:  v.GetStringSlice("konnectors.sandbox.hidden_paths"),
+			},
 		},
 		Matomo: Matomo{
 			URL:             v.GetString("matomo.url"),
@@ -777,7 +867,11 @@ func UseViper(v *viper.Viper) error {
 			IOSKeyID:               v.GetString("notifications.ios_key_id"),
 			IOSTeamID:              v.GetString("notifications.ios_team_id"),
 
+			VAPIDPrivateKeyPath: v.GetString("notifications.vapid_private_key_path"),
+			VAPIDSubject:        v.GetString("notifications.vapid_subject"),
+
 			Contexts: makeSMS(v.GetStringMap("notifications.contexts")),
+			Channels: makeNotificationChannels(v.GetStringMap("notifications.channels")),
 		},
 		Lock:                lockRedis,
 		SessionStorage:      sessionsRedis,
@@ -988,6 +1082,24 @@ func makeSMS(raw map[string]interface{}) map[string]SMS {
 	return sms
 }
 
+func makeNotificationChannels(raw map[string]interface{}) map[string]map[string]NotificationChannel {
+	channels := make(map[string]map[string]NotificationChannel)
+	for ctx, val := range raw {
+		entries, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		channels[ctx] = make(map[string]NotificationChannel)
+		for name, v := range entries {
+			entry, _ := v.(map[string]interface{})
+			url, _ := entry["url"].(string)
+			token, _ := entry["token"].(string)
+			channels[ctx][name] = NotificationChannel{URL: url, Token: token}
+		}
+	}
+	return channels
+}
+
 func createTestViper() *viper.Viper {
 	v := viper.New()
 	v.SetConfigName("cozy.test")
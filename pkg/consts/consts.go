@@ -25,6 +25,9 @@ const (
 	ContextSettingsID = "io.cozy.settings.context"
 	// DiskUsageID is the id of the settings JSON-API response for disk-usage
 	DiskUsageID = "io.cozy.settings.disk-usage"
+	// NotificationsSettingsID is the id of the settings document with the
+	// preferences of the user for the notifications
+	NotificationsSettingsID = "io.cozy.settings.notifications"
 	// InstanceSettingsID is the id of settings document for the instance
 	InstanceSettingsID = "io.cozy.settings.instance"
 	// CapabilitiesSettingsID is the id of the settings document with the
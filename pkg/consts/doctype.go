@@ -9,10 +9,16 @@ const Configs = "configs"
 const (
 	// Apps doc type for client-side application manifests
 	Apps = "io.cozy.apps"
+	// AppPasswords is a synthetic doctype for the app-specific passwords,
+	// used as the source of their permissions (they are stored on the
+	// instance document)
+	AppPasswords = "io.cozy.app_passwords"
 	// AppsSuggestion doc type for suggesting apps to the user
 	AppsSuggestion = "io.cozy.apps.suggestions"
 	// Konnectors doc type for konnector application manifests
 	Konnectors = "io.cozy.konnectors"
+	// Bills doc type for the bills saved by the konnectors
+	Bills = "io.cozy.bills"
 	// KonnectorsMaintenance doc type for maintenance of konnectors.
 	KonnectorsMaintenance = "io.cozy.konnectors.maintenance"
 	// Archives doc type for zip archives with files and directories
@@ -50,18 +56,39 @@ const (
 	Jobs = "io.cozy.jobs"
 	// JobEvents doc type for real time events sent by jobs
 	JobEvents = "io.cozy.jobs.events"
+	// JobReports doc type for the reports of the konnectors executions
+	JobReports = "io.cozy.jobs.reports"
 	// Support doc type for sending mail to the support
 	Support = "io.cozy.support"
 	// Notifications doc type for notifications
 	Notifications = "io.cozy.notifications"
+	// NotificationsDigest doc type for the notifications waiting to be sent
+	// in the next digest mail
+	NotificationsDigest = "io.cozy.notifications.digest"
+	// NotificationsSecrets doc type for the secrets and the access tokens of
+	// the notification channels
+	NotificationsSecrets = "io.cozy.notifications.secrets"
+	// NotificationsWebPush doc type for the Web Push subscriptions of the
+	// browsers
+	NotificationsWebPush = "io.cozy.notifications.webpush"
 	// OAuthAccessCodes doc type for OAuth2 access codes
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
+	// OAuthDeviceCodes doc type for the OAuth2 device authorization grant
+	OAuthDeviceCodes = "io.cozy.oauth.device_codes"
 	// OAuthClients doc type for OAuth2 clients
 	OAuthClients = "io.cozy.oauth.clients"
 	// Permissions doc type for permissions identifying a connection
 	Permissions = "io.cozy.permissions"
 	// Contacts doc type for sharing
 	Contacts = "io.cozy.contacts"
+	// ContactsGroups doc type for the groups of contacts
+	ContactsGroups = "io.cozy.contacts.groups"
+	// ContactsDuplicates doc type for the suggestions of contacts to merge
+	ContactsDuplicates = "io.cozy.contacts.duplicates"
+	// CalendarEvents doc type for the events of the calendar
+	CalendarEvents = "io.cozy.calendar.events"
+	// CalendarTodos doc type for the tasks of the calendar
+	CalendarTodos = "io.cozy.calendar.todos"
 	// RemoteRequests doc type for logging requests to remote websites
 	RemoteRequests = "io.cozy.remote.requests"
 	// RemoteSecrets doc type for secrets used by remote doctypes
@@ -104,6 +131,12 @@ const (
 	// BitwardenContacts doc type for Bitwarden users that can be added to
 	// an organization
 	BitwardenContacts = "com.bitwarden.contacts"
+	// BitwardenSends doc type for the texts and files shared with a link via
+	// Bitwarden Send
+	BitwardenSends = "com.bitwarden.sends"
+	// BitwardenEmergencyAccesses doc type for the emergency accesses between
+	// a grantor and a grantee
+	BitwardenEmergencyAccesses = "com.bitwarden.emergency_accesses"
 	// NotesDocuments doc type is used for manipulating the documents that
 	// represents a note before they are persisted to a file.
 	NotesDocuments = "io.cozy.notes.documents"
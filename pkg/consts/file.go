@@ -32,6 +32,10 @@ const (
 	CarbonCopyKey = "carbonCopy"
 	// ElectronicSafeKey is the metadata key for an electronic safe (certified)
 	ElectronicSafeKey = "electronicSafe"
+	// SharingPlaceholderKey is the metadata key used on the instance of a
+	// sharing recipient for a file that is kept as a placeholder (its content
+	// is only on the owner's instance)
+	SharingPlaceholderKey = "sharingPlaceholder"
 )
 
 const (
@@ -10,7 +10,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 31
+const IndexViewsVersion int = 33
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -33,12 +33,18 @@ var Indexes = []*mango.Index{
 	mango.IndexOnFields(consts.Jobs, "by-trigger-id", []string{"trigger_id", "queued_at"}),
 	mango.IndexOnFields(consts.Jobs, "by-queued-at", []string{"queued_at"}),
 
+	// Used to lookup the reports of the konnectors executions
+	mango.IndexOnFields(consts.JobReports, "by-trigger-id", []string{"trigger_id", "started_at"}),
+	mango.IndexOnFields(consts.JobReports, "by-slug", []string{"slug", "started_at"}),
+
 	// Used to lookup oauth clients by name
 	mango.IndexOnFields(consts.OAuthClients, "by-client-name", []string{"client_name"}),
 	mango.IndexOnFields(consts.OAuthClients, "by-notification-platform", []string{"notification_platform"}),
 
 	// Used to lookup login history by OS, browser, and IP
 	mango.IndexOnFields(consts.SessionsLogins, "by-os-browser-ip", []string{"os", "browser", "ip"}),
+	// Used to know if a login comes from a new country
+	mango.IndexOnFields(consts.SessionsLogins, "by-country-code", []string{"country_code"}),
 
 	// Used to lookup notifications by their source, ordered by their creation
 	// date
@@ -72,6 +72,12 @@ const (
 	WebhookTriggerType
 	// JobCleanClientType is used for cleaning unused OAuth clients
 	JobCleanClientType
+	// OCMShareType is used for counting the number of shares received from
+	// the OCM servers
+	OCMShareType
+	// SendAccessType is used for counting the number of attempts to access a
+	// bitwarden send protected by a password
+	SendAccessType
 )
 
 type counterConfig struct {
@@ -207,6 +213,18 @@ var configs = []counterConfig{
 		Limit:  100,
 		Period: 1 * time.Hour,
 	},
+	// OCMShareType
+	{
+		Prefix: "ocm-share",
+		Limit:  30,
+		Period: 1 * time.Hour,
+	},
+	// SendAccessType
+	{
+		Prefix: "send-access",
+		Limit:  10,
+		Period: 5 * time.Minute,
+	},
 }
 
 // Counter is an interface for counting number of attempts that can be used to
@@ -0,0 +1,110 @@
+// Package safehttp provides an HTTP client for the requests made by the stack
+// to URLs that are chosen by a user or by a remote server. The connections to
+// the loopback, private and link-local addresses are refused when dialing, so
+// that those URLs cannot be used to reach the internal network (SSRF).
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is used when the address to connect to is not a public
+// address.
+var ErrForbiddenAddress = errors.New("safehttp: connection to a non-public address is forbidden")
+
+// ErrRedirect is used when a client that does not follow the redirections
+// receives one.
+var ErrRedirect = errors.New("safehttp: redirections are not followed")
+
+var forbiddenNetworks []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "This" network
+		"10.0.0.0/8",     // Private
+		"100.64.0.0/10",  // Carrier-grade NAT
+		"127.0.0.0/8",    // Loopback
+		"169.254.0.0/16", // Link-local, and the cloud metadata services
+		"172.16.0.0/12",  // Private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // Private
+		"198.18.0.0/15",  // Benchmarking
+		"224.0.0.0/4",    // Multicast
+		"240.0.0.0/4",    // Reserved, and broadcast
+		"::/128",         // Unspecified
+		"::1/128",        // Loopback
+		"64:ff9b::/96",   // IPv4/IPv6 translation
+		"fc00::/7",       // Unique local
+		"fe80::/10",      // Link-local
+		"ff00::/8",       // Multicast
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		forbiddenNetworks = append(forbiddenNetworks, network)
+	}
+}
+
+// IsPublicIP returns true if the IP address can be reached by the stack for
+// a URL given by a user or a remote server. The IPv4-mapped IPv6 addresses are
+// checked with their IPv4 address.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range forbiddenNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// control is called after the resolution of the hostname, just before the
+// connection, and so it also protects against the DNS rebinding attacks.
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that refuses to connect to the non-public
+// addresses. If followRedirects is false, the redirections are not followed
+// and the request fails with ErrRedirect.
+func NewClient(timeout time.Duration, followRedirects bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	transport := &http.Transport{
+		// No proxy: the check must be done on the address of the server
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+	if !followRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return ErrRedirect
+		}
+	}
+	return client
+}
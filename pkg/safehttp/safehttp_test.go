@@ -0,0 +1,36 @@
+package safehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946", "8.8.8.8"} {
+		assert.True(t, IsPublicIP(net.ParseIP(addr)), addr)
+	}
+	for _, addr := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "::", "fe80::1", "fd00::1",
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1",
+	} {
+		assert.False(t, IsPublicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := NewClient(5*time.Second, false)
+	_, err := client.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrForbiddenAddress.Error())
+}
@@ -0,0 +1,43 @@
+package saml
+
+import "errors"
+
+var (
+	// ErrInvalidXML is used when the XML document can't be parsed, or has a
+	// DTD
+	ErrInvalidXML = errors.New("saml: invalid XML document")
+	// ErrMissingSignature is used when neither the response nor the
+	// assertion is signed
+	ErrMissingSignature = errors.New("saml: the response is not signed")
+	// ErrInvalidSignature is used when the signature can't be verified with
+	// the certificates of the identity provider
+	ErrInvalidSignature = errors.New("saml: invalid signature")
+	// ErrUnsupportedAlgorithm is used when the signature uses an algorithm
+	// that is not supported (like SHA-1)
+	ErrUnsupportedAlgorithm = errors.New("saml: unsupported algorithm")
+	// ErrInvalidResponse is used when the response is not a valid SAML
+	// response for this service provider
+	ErrInvalidResponse = errors.New("saml: invalid response")
+	// ErrEncryptedAssertion is used when the assertion is encrypted, as it is
+	// not supported
+	ErrEncryptedAssertion = errors.New("saml: encrypted assertions are not supported")
+	// ErrExpiredAssertion is used when the assertion is not valid at this
+	// time
+	ErrExpiredAssertion = errors.New("saml: the assertion has expired")
+	// ErrInvalidMetadata is used when the metadata of the identity provider
+	// can't be used
+	ErrInvalidMetadata = errors.New("saml: invalid metadata for the identity provider")
+	// ErrInvalidCertificate is used when a certificate can't be parsed, or
+	// has no RSA key
+	ErrInvalidCertificate = errors.New("saml: invalid certificate")
+)
+
+// StatusError is used when the identity provider responds with a status that
+// is not a success.
+type StatusError struct {
+	Code string
+}
+
+func (e *StatusError) Error() string {
+	return "saml: the identity provider responded with " + e.Code
+}
@@ -0,0 +1,91 @@
+package saml
+
+import (
+	"encoding/xml"
+)
+
+type idpMetadata struct {
+	XMLName          xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string   `xml:"entityID,attr"`
+	IDPSSODescriptor *struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SSOServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// ParseIdPMetadata parses the metadata of an identity provider, and returns
+// its entity ID, the URL of its single sign-on service with the HTTP-Redirect
+// binding, and its signing certificates.
+func ParseIdPMetadata(data []byte) (*IdentityProvider, error) {
+	if _, err := parseTree(data); err != nil {
+		return nil, err
+	}
+	var meta idpMetadata
+	if err := xml.Unmarshal(data, &meta); err != nil {
+		return nil, ErrInvalidMetadata
+	}
+	if meta.EntityID == "" || meta.IDPSSODescriptor == nil {
+		return nil, ErrInvalidMetadata
+	}
+
+	idp := &IdentityProvider{EntityID: meta.EntityID}
+	for _, sso := range meta.IDPSSODescriptor.SSOServices {
+		if sso.Binding == bindingRedir {
+			idp.SSOURL = sso.Location
+		}
+	}
+	for _, key := range meta.IDPSSODescriptor.KeyDescriptors {
+		if key.Use != "" && key.Use != "signing" {
+			continue
+		}
+		for _, data := range key.Certificates {
+			cert, err := ParseCertificate(data)
+			if err != nil {
+				return nil, err
+			}
+			idp.Certificates = append(idp.Certificates, cert)
+		}
+	}
+	if idp.SSOURL == "" || len(idp.Certificates) == 0 {
+		return nil, ErrInvalidMetadata
+	}
+	return idp, nil
+}
+
+type spMetadata struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// Metadata returns the metadata of the service provider, that can be used to
+// declare it on the identity provider.
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	meta := spMetadata{EntityID: sp.EntityID}
+	meta.SPSSODescriptor.WantAssertionsSigned = true
+	meta.SPSSODescriptor.ProtocolSupportEnumeration = nsProtocol
+	meta.SPSSODescriptor.NameIDFormat = nameIDUnspecif
+	meta.SPSSODescriptor.AssertionConsumerService.Binding = bindingPOST
+	meta.SPSSODescriptor.AssertionConsumerService.Location = sp.ACSURL
+	out, err := xml.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
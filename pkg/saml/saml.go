@@ -0,0 +1,290 @@
+// Package saml is a minimal implementation of a SAML 2.0 service provider,
+// with the HTTP-Redirect binding for the authentication requests, and the
+// HTTP-POST binding for the responses of the identity provider.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"net/url"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// The SAML namespaces and constants
+const (
+	nsAssertion    = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol     = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsMetadata     = "urn:oasis:names:tc:SAML:2.0:metadata"
+	statusSuccess  = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer   = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	bindingPOST    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	bindingRedir   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	nameIDUnspecif = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// MaxClockSkew is the tolerance for the time conditions of the assertions.
+const MaxClockSkew = 3 * time.Minute
+
+// IdentityProvider is the SAML identity provider for a context.
+type IdentityProvider struct {
+	EntityID     string
+	SSOURL       string
+	Certificates []*x509.Certificate
+}
+
+// ServiceProvider is the SAML service provider for an instance.
+type ServiceProvider struct {
+	EntityID string
+	ACSURL   string
+	IdP      *IdentityProvider
+}
+
+// Assertion is the result of a successful authentication on the identity
+// provider.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	SessionIndex string
+	Attributes   map[string][]string
+}
+
+// Attribute returns the first value of the attribute with the given name.
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// NewRequestID returns a random identifier for an authentication request.
+func NewRequestID() string {
+	// The XML identifiers can't start with a digit
+	return "_" + hex.EncodeToString(crypto.GenerateRandomBytes(20))
+}
+
+// AuthnRequest returns the XML of an authentication request.
+func (sp *ServiceProvider) AuthnRequest(id string, now time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<samlp:AuthnRequest xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"`)
+	writeAttr(&buf, "ID", id)
+	writeAttr(&buf, "Version", "2.0")
+	writeAttr(&buf, "IssueInstant", now.UTC().Format(time.RFC3339))
+	writeAttr(&buf, "Destination", sp.IdP.SSOURL)
+	writeAttr(&buf, "AssertionConsumerServiceURL", sp.ACSURL)
+	writeAttr(&buf, "ProtocolBinding", bindingPOST)
+	buf.WriteString(`><saml:Issuer>`)
+	_ = xml.EscapeText(&buf, []byte(sp.EntityID))
+	buf.WriteString(`</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`)
+	return buf.Bytes()
+}
+
+func writeAttr(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(" " + name + `="`)
+	_ = xml.EscapeText(buf, []byte(value))
+	buf.WriteString(`"`)
+}
+
+// RedirectURL returns the URL of the identity provider where the user can be
+// redirected to start the authentication, with the HTTP-Redirect binding.
+func (sp *ServiceProvider) RedirectURL(requestID, relayState string, now time.Time) (string, error) {
+	u, err := url.Parse(sp.IdP.SSOURL)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(sp.AuthnRequest(requestID, now)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ParseResponse checks the SAMLResponse sent by the identity provider with
+// the HTTP-POST binding, in response to the request with the given ID, and
+// returns the assertion. The response or the assertion must be signed.
+func (sp *ServiceProvider) ParseResponse(encoded, requestID string, now time.Time) (*Assertion, error) {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	res, err := parseTree(data)
+	if err != nil {
+		return nil, err
+	}
+	if !res.is(nsProtocol, "Response") {
+		return nil, ErrInvalidResponse
+	}
+	if dest, ok := res.attr("Destination"); ok && dest != sp.ACSURL {
+		return nil, ErrInvalidResponse
+	}
+	if res.attrValue("InResponseTo") != requestID || requestID == "" {
+		return nil, ErrInvalidResponse
+	}
+	if issuer := res.element(nsAssertion, "Issuer"); issuer != nil && issuer.text() != sp.IdP.EntityID {
+		return nil, ErrInvalidResponse
+	}
+	status := res.element(nsProtocol, "Status")
+	if status == nil {
+		return nil, ErrInvalidResponse
+	}
+	code := status.element(nsProtocol, "StatusCode")
+	if code == nil {
+		return nil, ErrInvalidResponse
+	}
+	if value := code.attrValue("Value"); value != statusSuccess {
+		return nil, &StatusError{Code: value}
+	}
+
+	if res.element(nsAssertion, "EncryptedAssertion") != nil {
+		return nil, ErrEncryptedAssertion
+	}
+	assertions := res.elements(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, ErrInvalidResponse
+	}
+	el := assertions[0]
+
+	// The signature can be on the response, on the assertion, or on both
+	responseSigned := res.element(nsDSig, "Signature") != nil
+	assertionSigned := el.element(nsDSig, "Signature") != nil
+	if !responseSigned && !assertionSigned {
+		return nil, ErrMissingSignature
+	}
+	if responseSigned {
+		if err := verifySignature(res, sp.IdP.Certificates); err != nil {
+			return nil, err
+		}
+	}
+	if assertionSigned {
+		if err := verifySignature(el, sp.IdP.Certificates); err != nil {
+			return nil, err
+		}
+	}
+
+	return sp.checkAssertion(el, requestID, now)
+}
+
+func (sp *ServiceProvider) checkAssertion(el *node, requestID string, now time.Time) (*Assertion, error) {
+	assertion := &Assertion{
+		ID:         el.attrValue("ID"),
+		Attributes: make(map[string][]string),
+	}
+	issuer := el.element(nsAssertion, "Issuer")
+	if issuer == nil || issuer.text() != sp.IdP.EntityID {
+		return nil, ErrInvalidResponse
+	}
+	assertion.Issuer = issuer.text()
+
+	subject := el.element(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, ErrInvalidResponse
+	}
+	nameID := subject.element(nsAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, ErrInvalidResponse
+	}
+	assertion.NameID = nameID.text()
+	confirmed := false
+	for _, confirmation := range subject.elements(nsAssertion, "SubjectConfirmation") {
+		if confirmation.attrValue("Method") != methodBearer {
+			continue
+		}
+		data := confirmation.element(nsAssertion, "SubjectConfirmationData")
+		if data == nil || data.attrValue("Recipient") != sp.ACSURL {
+			continue
+		}
+		if inResponseTo, ok := data.attr("InResponseTo"); ok && inResponseTo != requestID {
+			continue
+		}
+		notOnOrAfter, err := time.Parse(time.RFC3339, data.attrValue("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(MaxClockSkew)) {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return nil, ErrInvalidResponse
+	}
+
+	if conditions := el.element(nsAssertion, "Conditions"); conditions != nil {
+		if v, ok := conditions.attr("NotBefore"); ok {
+			notBefore, err := time.Parse(time.RFC3339, v)
+			if err != nil || now.Add(MaxClockSkew).Before(notBefore) {
+				return nil, ErrExpiredAssertion
+			}
+		}
+		if v, ok := conditions.attr("NotOnOrAfter"); ok {
+			notOnOrAfter, err := time.Parse(time.RFC3339, v)
+			if err != nil || !now.Before(notOnOrAfter.Add(MaxClockSkew)) {
+				return nil, ErrExpiredAssertion
+			}
+		}
+		for _, restriction := range conditions.elements(nsAssertion, "AudienceRestriction") {
+			found := false
+			for _, audience := range restriction.elements(nsAssertion, "Audience") {
+				if audience.text() == sp.EntityID {
+					found = true
+				}
+			}
+			if !found {
+				return nil, ErrInvalidResponse
+			}
+		}
+	}
+
+	if authn := el.element(nsAssertion, "AuthnStatement"); authn != nil {
+		assertion.SessionIndex = authn.attrValue("SessionIndex")
+	}
+	for _, statement := range el.elements(nsAssertion, "AttributeStatement") {
+		for _, attr := range statement.elements(nsAssertion, "Attribute") {
+			name := attr.attrValue("Name")
+			for _, value := range attr.elements(nsAssertion, "AttributeValue") {
+				assertion.Attributes[name] = append(assertion.Attributes[name], value.text())
+			}
+		}
+	}
+	return assertion, nil
+}
+
+// ParseCertificate parses a certificate of the identity provider, in PEM or
+// in base64 (like in the metadata).
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := decodeBase64(data)
+		if err != nil {
+			return nil, ErrInvalidCertificate
+		}
+		der = decoded
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+	if cert.PublicKeyAlgorithm != x509.RSA {
+		return nil, ErrInvalidCertificate
+	}
+	return cert, nil
+}
@@ -0,0 +1,289 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testIdP is a stand-in identity provider, that can sign the responses
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+const (
+	testIdPEntityID = "https://idp.example.org/metadata"
+	testSPEntityID  = "https://cozy.example/saml/the-context"
+	testACSURL      = "https://alice.cozy.example/auth/saml/acs"
+	sigPlaceholder  = "<!--signature-->"
+)
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testIdP{key: key, cert: cert}
+}
+
+func (idp *testIdP) serviceProvider() *ServiceProvider {
+	return &ServiceProvider{
+		EntityID: testSPEntityID,
+		ACSURL:   testACSURL,
+		IdP: &IdentityProvider{
+			EntityID:     testIdPEntityID,
+			SSOURL:       "https://idp.example.org/sso",
+			Certificates: []*x509.Certificate{idp.cert},
+		},
+	}
+}
+
+// sign replaces the first signature placeholder with the signature of the
+// element with the given ID.
+func (idp *testIdP) sign(t *testing.T, doc, id string) string {
+	root, err := parseTree([]byte(doc))
+	assert.NoError(t, err)
+	el := findByID(root, id)
+	if !assert.NotNil(t, el) {
+		return doc
+	}
+	digest := sha256.Sum256(canonicalize(el, nil, nil))
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + nsDSig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + algRSASHA256 + `"/>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"/>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"/>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="` + algSHA256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference></ds:SignedInfo>`
+	si, err := parseTree([]byte(signedInfo))
+	assert.NoError(t, err)
+	hashed := sha256.Sum256(canonicalize(si, nil, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hashed[:])
+	assert.NoError(t, err)
+	signature := `<ds:Signature xmlns:ds="` + nsDSig + `">` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(sig) + `</ds:SignatureValue>` +
+		`</ds:Signature>`
+	return strings.Replace(doc, sigPlaceholder, signature, 1)
+}
+
+func findByID(n *node, id string) *node {
+	if n.attrValue("ID") == id {
+		return n
+	}
+	for _, child := range n.children {
+		if el, ok := child.(*node); ok {
+			if found := findByID(el, id); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+type responseParams struct {
+	requestID string
+	audience  string
+	nameID    string
+	notAfter  time.Time
+}
+
+func makeResponse(p responseParams, responseSig, assertionSig bool) string {
+	now := time.Now().UTC()
+	issued := now.Format(time.RFC3339)
+	notAfter := p.notAfter.UTC().Format(time.RFC3339)
+	resSig, assertSig := "", ""
+	if responseSig {
+		resSig = sigPlaceholder
+	}
+	if assertionSig {
+		assertSig = sigPlaceholder
+	}
+	return `<samlp:Response xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `"` +
+		` ID="_response1" Version="2.0" IssueInstant="` + issued + `"` +
+		` Destination="` + testACSURL + `" InResponseTo="` + p.requestID + `">` +
+		`<saml:Issuer>` + testIdPEntityID + `</saml:Issuer>` + resSig +
+		`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"/></samlp:Status>` +
+		`<saml:Assertion xmlns:xs="http://www.w3.org/2001/XMLSchema" ID="_assertion1" Version="2.0" IssueInstant="` + issued + `">` +
+		`<saml:Issuer>` + testIdPEntityID + `</saml:Issuer>` + assertSig +
+		`<saml:Subject><saml:NameID>` + p.nameID + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + methodBearer + `">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + p.requestID + `" NotOnOrAfter="` + notAfter + `" Recipient="` + testACSURL + `"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + issued + `" NotOnOrAfter="` + notAfter + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + p.audience + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + issued + `" SessionIndex="_session1"/>` +
+		`<saml:AttributeStatement><saml:Attribute Name="uid">` +
+		"\n  " + `<saml:AttributeValue xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="xs:string">alice &amp; co</saml:AttributeValue>` +
+		`</saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion></samlp:Response>`
+}
+
+func encode(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestCanonicalize(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<root xmlns="http://a" xmlns:b="http://b" xmlns:unused="http://u"><!-- comment -->` +
+		`<b:child attr2="2" attr1="1" b:x="y">t &amp; &lt;x&gt; <![CDATA[<z>]]></b:child><other/></root>`
+	root, err := parseTree([]byte(doc))
+	assert.NoError(t, err)
+	assert.Equal(t, `<root xmlns="http://a"><b:child xmlns:b="http://b" attr1="1" attr2="2" b:x="y">`+
+		`t &amp; &lt;x&gt; &lt;z&gt;</b:child><other></other></root>`, string(canonicalize(root, nil, nil)))
+
+	child := root.children[0].(*node)
+	assert.Equal(t, `<b:child xmlns:b="http://b" attr1="1" attr2="2" b:x="y">t &amp; &lt;x&gt; &lt;z&gt;</b:child>`,
+		string(canonicalize(child, nil, nil)))
+	assert.Equal(t, `<b:child xmlns:b="http://b" xmlns:unused="http://u" attr1="1" attr2="2" b:x="y">t &amp; &lt;x&gt; &lt;z&gt;</b:child>`,
+		string(canonicalize(child, []string{"unused"}, nil)))
+
+	_, err = parseTree([]byte(`<!DOCTYPE foo [<!ENTITY x "y">]><foo>&x;</foo>`))
+	assert.Equal(t, ErrInvalidXML, err)
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := idp.serviceProvider()
+	now := time.Now()
+	params := responseParams{
+		requestID: NewRequestID(),
+		audience:  testSPEntityID,
+		nameID:    "alice@example.org",
+		notAfter:  now.Add(5 * time.Minute),
+	}
+
+	// Signed assertion
+	doc := idp.sign(t, makeResponse(params, false, true), "_assertion1")
+	assertion, err := sp.ParseResponse(encode(doc), params.requestID, now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, assertion) {
+		assert.Equal(t, "alice@example.org", assertion.NameID)
+		assert.Equal(t, "_session1", assertion.SessionIndex)
+		assert.Equal(t, "alice & co", assertion.Attribute("uid"))
+	}
+
+	// Signed response
+	doc = idp.sign(t, makeResponse(params, true, false), "_response1")
+	_, err = sp.ParseResponse(encode(doc), params.requestID, now)
+	assert.NoError(t, err)
+
+	// Unsigned
+	doc = makeResponse(params, false, false)
+	_, err = sp.ParseResponse(encode(doc), params.requestID, now)
+	assert.Equal(t, ErrMissingSignature, err)
+
+	// Tampered
+	doc = idp.sign(t, makeResponse(params, false, true), "_assertion1")
+	tampered := strings.Replace(doc, "alice@example.org", "bob@example.org", 1)
+	_, err = sp.ParseResponse(encode(tampered), params.requestID, now)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// Signature wrapping: a second assertion with the same ID
+	wrapped := strings.Replace(doc, "</samlp:Response>",
+		`<saml:Extensions ID="_assertion1"/></samlp:Response>`, 1)
+	_, err = sp.ParseResponse(encode(wrapped), params.requestID, now)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// Signed by another key
+	other := newTestIdP(t)
+	doc = other.sign(t, makeResponse(params, false, true), "_assertion1")
+	_, err = sp.ParseResponse(encode(doc), params.requestID, now)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	// Not the expected request
+	doc = idp.sign(t, makeResponse(params, false, true), "_assertion1")
+	_, err = sp.ParseResponse(encode(doc), NewRequestID(), now)
+	assert.Equal(t, ErrInvalidResponse, err)
+
+	// Expired
+	_, err = sp.ParseResponse(encode(doc), params.requestID, now.Add(time.Hour))
+	assert.Error(t, err)
+
+	// Another audience
+	p := params
+	p.audience = "https://another.example/sp"
+	doc = idp.sign(t, makeResponse(p, false, true), "_assertion1")
+	_, err = sp.ParseResponse(encode(doc), params.requestID, now)
+	assert.Equal(t, ErrInvalidResponse, err)
+}
+
+func TestRedirectURL(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := idp.serviceProvider()
+	id := NewRequestID()
+	u, err := sp.RedirectURL(id, "relay", time.Now())
+	assert.NoError(t, err)
+	parsed, err := url.Parse(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "idp.example.org", parsed.Host)
+	assert.Equal(t, "relay", parsed.Query().Get("RelayState"))
+	deflated, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	assert.NoError(t, err)
+	req, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	assert.NoError(t, err)
+	root, err := parseTree(req)
+	assert.NoError(t, err)
+	assert.True(t, root.is(nsProtocol, "AuthnRequest"))
+	assert.Equal(t, id, root.attrValue("ID"))
+	assert.Equal(t, testACSURL, root.attrValue("AssertionConsumerServiceURL"))
+}
+
+func TestMetadata(t *testing.T) {
+	idp := newTestIdP(t)
+	cert := base64.StdEncoding.EncodeToString(idp.cert.Raw)
+	metadata := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="` + nsMetadata + `" entityID="` + testIdPEntityID + `">
+  <md:IDPSSODescriptor protocolSupportEnumeration="` + nsProtocol + `">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="` + nsDSig + `"><ds:X509Data><ds:X509Certificate>` + cert + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="` + bindingPOST + `" Location="https://idp.example.org/sso/post"/>
+    <md:SingleSignOnService Binding="` + bindingRedir + `" Location="https://idp.example.org/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+	parsed, err := ParseIdPMetadata([]byte(metadata))
+	assert.NoError(t, err)
+	if assert.NotNil(t, parsed) {
+		assert.Equal(t, testIdPEntityID, parsed.EntityID)
+		assert.Equal(t, "https://idp.example.org/sso", parsed.SSOURL)
+		if assert.Len(t, parsed.Certificates, 1) {
+			assert.True(t, parsed.Certificates[0].Equal(idp.cert))
+		}
+	}
+
+	sp := idp.serviceProvider()
+	out, err := sp.Metadata()
+	assert.NoError(t, err)
+	root, err := parseTree(out)
+	assert.NoError(t, err)
+	assert.True(t, root.is(nsMetadata, "EntityDescriptor"))
+	assert.Equal(t, testSPEntityID, root.attrValue("entityID"))
+	acs := root.element(nsMetadata, "SPSSODescriptor").element(nsMetadata, "AssertionConsumerService")
+	assert.Equal(t, testACSURL, acs.attrValue("Location"))
+}
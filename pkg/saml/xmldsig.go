@@ -0,0 +1,457 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+
+	// Register the hash functions used by the signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// The namespaces and algorithms of XML Signature that are supported. Only the
+// exclusive canonicalization and SHA-2 based algorithms are accepted.
+const (
+	nsDSig       = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14N    = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	nsXML        = "http://www.w3.org/XML/1998/namespace"
+	algExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512    = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var signatureHashes = map[string]crypto.Hash{
+	algRSASHA256: crypto.SHA256,
+	algRSASHA512: crypto.SHA512,
+}
+
+var digestHashes = map[string]crypto.Hash{
+	algSHA256: crypto.SHA256,
+	algSHA512: crypto.SHA512,
+}
+
+// node is an XML element. The prefixes are kept as they are in the document,
+// as they are needed for the canonicalization.
+type node struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr
+	children []interface{} // *node or xml.CharData
+	parent   *node
+}
+
+// parseTree parses an XML document. The DTD are rejected, and the comments
+// and processing instructions are ignored.
+func parseTree(data []byte) (*node, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *node
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidXML
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{
+				prefix: t.Name.Space,
+				local:  t.Name.Local,
+				attrs:  append([]xml.Attr{}, t.Attr...),
+				parent: cur,
+			}
+			if cur != nil {
+				cur.children = append(cur.children, n)
+			} else if root == nil {
+				root = n
+			} else {
+				return nil, ErrInvalidXML
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, ErrInvalidXML
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, t.Copy())
+			}
+		case xml.Directive:
+			return nil, ErrInvalidXML
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, ErrInvalidXML
+	}
+	return root, nil
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// lookupNamespace returns the namespace URI bound to the prefix for this
+// element, and a boolean to say if a declaration has been found.
+func (n *node) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+	for cur := n; cur != nil; cur = cur.parent {
+		for _, a := range cur.attrs {
+			if prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns" {
+				return a.Value, true
+			}
+			if prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix {
+				return a.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (n *node) namespace() string {
+	ns, _ := n.lookupNamespace(n.prefix)
+	return ns
+}
+
+func (n *node) is(ns, local string) bool {
+	return n.local == local && n.namespace() == ns
+}
+
+// attr returns the value of an attribute without namespace.
+func (n *node) attr(local string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *node) attrValue(local string) string {
+	v, _ := n.attr(local)
+	return v
+}
+
+func (n *node) elements(ns, local string) []*node {
+	var list []*node
+	for _, child := range n.children {
+		if el, ok := child.(*node); ok && el.is(ns, local) {
+			list = append(list, el)
+		}
+	}
+	return list
+}
+
+func (n *node) element(ns, local string) *node {
+	for _, child := range n.children {
+		if el, ok := child.(*node); ok && el.is(ns, local) {
+			return el
+		}
+	}
+	return nil
+}
+
+func (n *node) text() string {
+	var sb strings.Builder
+	for _, child := range n.children {
+		if data, ok := child.(xml.CharData); ok {
+			sb.Write(data)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// countIDs counts the elements in the tree with the given ID. It is used to
+// prevent the signature wrapping attacks, where an element with the same ID
+// as the signed one is put elsewhere in the document.
+func (n *node) countIDs(id string) int {
+	count := 0
+	if v, ok := n.attr("ID"); ok && v == id {
+		count++
+	}
+	for _, child := range n.children {
+		if el, ok := child.(*node); ok {
+			count += el.countIDs(id)
+		}
+	}
+	return count
+}
+
+// canonicalize serializes the element with the Exclusive XML
+// Canonicalization algorithm, without comments. The inclusive parameter is
+// the InclusiveNamespaces PrefixList, and the exclude element is removed (it
+// is used for the enveloped signature transform).
+// See https://www.w3.org/TR/xml-exc-c14n/
+func canonicalize(n *node, inclusive []string, exclude *node) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, n, map[string]string{}, inclusive, exclude)
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, n *node, rendered map[string]string, inclusive []string, exclude *node) {
+	// The namespaces visibly utilized by the element and its attributes, and
+	// those of the inclusive prefix list
+	used := map[string]bool{n.prefix: true}
+	for _, a := range n.attrs {
+		if !isNamespaceDecl(a) && a.Name.Space != "" {
+			used[a.Name.Space] = true
+		}
+	}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if uri, ok := n.lookupNamespace(prefix); ok && uri != "" {
+			used[prefix] = true
+		}
+	}
+
+	var decls []xml.Attr
+	current := make(map[string]string, len(rendered))
+	for k, v := range rendered {
+		current[k] = v
+	}
+	for prefix := range used {
+		if prefix == "xml" {
+			continue
+		}
+		uri, _ := n.lookupNamespace(prefix)
+		if prev, ok := rendered[prefix]; ok && prev == uri {
+			continue
+		}
+		if _, ok := rendered[prefix]; !ok && uri == "" {
+			continue
+		}
+		decls = append(decls, xml.Attr{Name: xml.Name{Local: prefix}, Value: uri})
+		current[prefix] = uri
+	}
+	sort.Slice(decls, func(i, j int) bool {
+		return decls[i].Name.Local < decls[j].Name.Local
+	})
+
+	type attribute struct {
+		ns    string
+		qname string
+		local string
+		value string
+	}
+	var attrs []attribute
+	for _, a := range n.attrs {
+		if isNamespaceDecl(a) {
+			continue
+		}
+		attr := attribute{qname: a.Name.Local, local: a.Name.Local, value: a.Value}
+		if a.Name.Space != "" {
+			attr.ns, _ = n.lookupNamespace(a.Name.Space)
+			attr.qname = a.Name.Space + ":" + a.Name.Local
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].ns != attrs[j].ns {
+			return attrs[i].ns < attrs[j].ns
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	qname := n.local
+	if n.prefix != "" {
+		qname = n.prefix + ":" + n.local
+	}
+	buf.WriteString("<" + qname)
+	for _, d := range decls {
+		if d.Name.Local == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(" xmlns:" + d.Name.Local + `="`)
+		}
+		writeEscapedAttr(buf, d.Value)
+		buf.WriteString(`"`)
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + a.qname + `="`)
+		writeEscapedAttr(buf, a.value)
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+	for _, child := range n.children {
+		switch c := child.(type) {
+		case *node:
+			if c != exclude {
+				writeCanonical(buf, c, current, inclusive, exclude)
+			}
+		case xml.CharData:
+			writeEscapedText(buf, string(c))
+		}
+	}
+	buf.WriteString("</" + qname + ">")
+}
+
+func writeEscapedAttr(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\t':
+			buf.WriteString("&#x9;")
+		case '\n':
+			buf.WriteString("&#xA;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func writeEscapedText(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of a
+// canonicalization method or transform.
+func inclusivePrefixes(method *node) []string {
+	if in := method.element(nsExcC14N, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attrValue("PrefixList"))
+	}
+	return nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+// verifySignature checks the enveloped signature of the element with the
+// certificates of the identity provider. The certificates sent in the
+// KeyInfo of the signature are ignored.
+func verifySignature(el *node, certs []*x509.Certificate) error {
+	sig := el.element(nsDSig, "Signature")
+	if sig == nil {
+		return ErrMissingSignature
+	}
+	id, ok := el.attr("ID")
+	if !ok || id == "" {
+		return ErrInvalidSignature
+	}
+	root := el
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root.countIDs(id) != 1 {
+		return ErrInvalidSignature
+	}
+
+	signedInfo := sig.element(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return ErrInvalidSignature
+	}
+	c14nMethod := signedInfo.element(nsDSig, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attrValue("Algorithm") != algExcC14N {
+		return ErrUnsupportedAlgorithm
+	}
+	sigMethod := signedInfo.element(nsDSig, "SignatureMethod")
+	if sigMethod == nil {
+		return ErrInvalidSignature
+	}
+	sigHash, ok := signatureHashes[sigMethod.attrValue("Algorithm")]
+	if !ok {
+		return ErrUnsupportedAlgorithm
+	}
+
+	refs := signedInfo.elements(nsDSig, "Reference")
+	if len(refs) != 1 || refs[0].attrValue("URI") != "#"+id {
+		return ErrInvalidSignature
+	}
+	ref := refs[0]
+	enveloped := false
+	var prefixes []string
+	if transforms := ref.element(nsDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.elements(nsDSig, "Transform") {
+			switch transform.attrValue("Algorithm") {
+			case algEnveloped:
+				enveloped = true
+			case algExcC14N:
+				prefixes = inclusivePrefixes(transform)
+			default:
+				return ErrUnsupportedAlgorithm
+			}
+		}
+	}
+	if !enveloped {
+		return ErrInvalidSignature
+	}
+	digestMethod := ref.element(nsDSig, "DigestMethod")
+	if digestMethod == nil {
+		return ErrInvalidSignature
+	}
+	digestHash, ok := digestHashes[digestMethod.attrValue("Algorithm")]
+	if !ok {
+		return ErrUnsupportedAlgorithm
+	}
+	digestValue := ref.element(nsDSig, "DigestValue")
+	if digestValue == nil {
+		return ErrInvalidSignature
+	}
+	expected, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	h := digestHash.New()
+	h.Write(canonicalize(el, prefixes, sig))
+	if subtle.ConstantTimeCompare(h.Sum(nil), expected) != 1 {
+		return ErrInvalidSignature
+	}
+
+	sigValue := sig.element(nsDSig, "SignatureValue")
+	if sigValue == nil {
+		return ErrInvalidSignature
+	}
+	signature, err := decodeBase64(sigValue.text())
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	h = sigHash.New()
+	h.Write(canonicalize(signedInfo, inclusivePrefixes(c14nMethod), nil))
+	hashed := h.Sum(nil)
+	for _, cert := range certs {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(pub, sigHash, hashed, signature) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
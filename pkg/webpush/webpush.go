@@ -0,0 +1,282 @@
+// Package webpush implements the sending of Web Push messages to the
+// browsers, with the encryption of the payload (RFC 8291) and the VAPID
+// authentication of the application server (RFC 8292).
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+// recordSize is the record size used for the aes128gcm content encoding. The
+// payload is sent in a single record, so it must be smaller than that.
+const recordSize = 4096
+
+// MaxPayloadSize is the maximal size of a payload: a record minus the padding
+// delimiter and the authentication tag of AES-GCM, and the push services are
+// only required to accept 4096 bytes for the whole body with the header.
+const MaxPayloadSize = recordSize - 1 - 16 - (16 + 4 + 1 + 65)
+
+// vapidTokenDuration is the validity of the JWT sent to the push services.
+const vapidTokenDuration = 12 * time.Hour
+
+var (
+	// ErrInvalidSubscription is used when the keys of a subscription are not
+	// valid.
+	ErrInvalidSubscription = errors.New("webpush: invalid subscription")
+	// ErrPayloadTooLarge is used when the payload is larger than
+	// MaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("webpush: payload too large")
+	// ErrSubscriptionGone is used when the push service responds that the
+	// subscription has expired or has been removed: it should not be used
+	// again.
+	ErrSubscriptionGone = errors.New("webpush: subscription is no longer valid")
+	// ErrInvalidKey is used when the VAPID key cannot be parsed.
+	ErrInvalidKey = errors.New("webpush: invalid VAPID key")
+)
+
+// Subscription is the information given by the browser with
+// PushManager.subscribe(): the URL of the push service for this browser, and
+// the keys for encrypting the payloads.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Options are the parameters for sending a message.
+type Options struct {
+	// TTL is how long the push service must keep the message if the browser
+	// is not reachable
+	TTL time.Duration
+	// Urgency can be very-low, low, normal or high
+	Urgency string
+	// Topic is used to replace a pending message with the same topic
+	Topic string
+}
+
+// Sender sends the messages with the VAPID key of the application server.
+type Sender struct {
+	Key *ecdsa.PrivateKey
+	// Subject is a mailto: or https: URL to contact the operator of the
+	// application server
+	Subject string
+	Client  *http.Client
+}
+
+// GenerateKey generates a new VAPID key.
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// MarshalKey returns the key encoded in PEM.
+func MarshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKey parses a VAPID key encoded in PEM.
+func ParseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil || key.Curve != elliptic.P256() {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// EncodePublicKey returns the public key in the format expected by the
+// browsers for the applicationServerKey option of PushManager.subscribe():
+// the uncompressed point, encoded in base64url.
+func EncodePublicKey(key *ecdsa.PublicKey) string {
+	point := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+	return base64.RawURLEncoding.EncodeToString(point)
+}
+
+// Send encrypts the payload and sends it to the push service of the
+// subscription.
+func (s *Sender) Send(sub *Subscription, payload []byte, opts Options) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := s.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case res.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("webpush: unexpected status %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// authorization returns the value of the Authorization header for VAPID,
+// with a JWT for the origin of the push service.
+func (s *Sender) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", ErrInvalidSubscription
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenDuration).Unix(),
+		"sub": s.Subject,
+	})
+	signed, err := token.SignedString(s.Key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + EncodePublicKey(&s.Key.PublicKey), nil
+}
+
+// Encrypt encrypts the payload for the subscription, with the aes128gcm
+// content encoding (RFC 8188), as described by RFC 8291.
+func Encrypt(sub *Subscription, payload []byte) ([]byte, error) {
+	asPrivate, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(elliptic.P256(), x, y)
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return encrypt(sub, payload, asPrivate, asPublic, salt)
+}
+
+func encrypt(sub *Subscription, payload, asPrivate, asPublic, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, err := decodeBase64(sub.Keys.P256dh)
+	if err != nil {
+		return nil, ErrInvalidSubscription
+	}
+	authSecret, err := decodeBase64(sub.Keys.Auth)
+	if err != nil || len(authSecret) == 0 {
+		return nil, ErrInvalidSubscription
+	}
+	curve := elliptic.P256()
+	ux, uy := elliptic.Unmarshal(curve, uaPublic)
+	if ux == nil {
+		return nil, ErrInvalidSubscription
+	}
+
+	// ecdh_secret = ECDH(as_private, ua_public)
+	sx, _ := curve.ScalarMult(ux, uy, asPrivate)
+	ecdhSecret := make([]byte, 32)
+	sx.FillBytes(ecdhSecret)
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 ||
+	// ua_public || as_public, 32)
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+
+	// CEK and NONCE from the salt, as in RFC 8188
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single record, with the 0x02 delimiter for the last record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	rs := make([]byte, 4)
+	binary.BigEndian.PutUint32(rs, recordSize)
+	header = append(header, rs...)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// hkdf is the HMAC-based key derivation function of RFC 5869, for an output
+// length of at most 32 bytes.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{0x01})
+	return mac.Sum(nil)[:length]
+}
+
+// decodeBase64 decodes the keys of a subscription: the browsers use base64url
+// without padding, but some libraries add the padding or use the standard
+// alphabet.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
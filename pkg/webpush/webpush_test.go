@@ -0,0 +1,87 @@
+package webpush
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, s string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return data
+}
+
+func TestEncrypt(t *testing.T) {
+	// Test vector from RFC 8291, Appendix A
+	sub := &Subscription{Endpoint: "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV"}
+	sub.Keys.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	sub.Keys.Auth = "BTBZMqHH6r4Tts7J_aSIgg"
+	asPrivate := decode(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	x, y := elliptic.P256().ScalarBaseMult(asPrivate)
+	asPublic := elliptic.Marshal(elliptic.P256(), x, y)
+	salt := decode(t, "DGv6ra1nlYgDCS1FRnbzlw")
+	payload := []byte("When I grow up, I want to be a watermelon")
+
+	body, err := encrypt(sub, payload, asPrivate, asPublic, salt)
+	assert.NoError(t, err)
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	assert.Equal(t, expected, base64.RawURLEncoding.EncodeToString(body))
+
+	_, err = encrypt(sub, make([]byte, MaxPayloadSize+1), asPrivate, asPublic, salt)
+	assert.Equal(t, ErrPayloadTooLarge, err)
+	sub.Keys.P256dh = "invalid"
+	_, err = encrypt(sub, payload, asPrivate, asPublic, salt)
+	assert.Equal(t, ErrInvalidSubscription, err)
+}
+
+func TestKey(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	data, err := MarshalKey(key)
+	require.NoError(t, err)
+	parsed, err := ParseKey(data)
+	require.NoError(t, err)
+	assert.Equal(t, EncodePublicKey(&key.PublicKey), EncodePublicKey(&parsed.PublicKey))
+	assert.Len(t, decode(t, EncodePublicKey(&key.PublicKey)), 65)
+
+	_, err = ParseKey([]byte("not a key"))
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestSend(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	sender := &Sender{Key: key, Subject: "mailto:admin@cozy.example"}
+
+	var headers http.Header
+	status := http.StatusCreated
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	sub := &Subscription{Endpoint: ts.URL + "/push/abc"}
+	sub.Keys.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	sub.Keys.Auth = "BTBZMqHH6r4Tts7J_aSIgg"
+	err = sender.Send(sub, []byte(`{"title":"Hello"}`), Options{Urgency: "high", Topic: "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "aes128gcm", headers.Get("Content-Encoding"))
+	assert.Equal(t, "86400", headers.Get("TTL"))
+	assert.Equal(t, "high", headers.Get("Urgency"))
+	assert.Equal(t, "foo", headers.Get("Topic"))
+	auth := headers.Get("Authorization")
+	assert.True(t, strings.HasPrefix(auth, "vapid t="))
+	assert.True(t, strings.HasSuffix(auth, ", k="+EncodePublicKey(&key.PublicKey)))
+
+	status = http.StatusGone
+	err = sender.Send(sub, []byte(`{"title":"Hello"}`), Options{})
+	assert.Equal(t, ErrSubscriptionGone, err)
+}
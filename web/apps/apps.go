@@ -194,6 +194,7 @@ func updateHandler(installerType consts.AppType) echo.HandlerFunc {
 
 				PermissionsAcked:    permissionsAcked,
 				OverridenParameters: overridenParameters,
+				AllowRolledBack:     true,
 			},
 		)
 		if err != nil {
@@ -212,6 +213,23 @@ func updateHandler(installerType consts.AppType) echo.HandlerFunc {
 	}
 }
 
+// rollbackHandler handles the POST /apps/:slug/rollback request, used to
+// reinstall the previous version of a webapp.
+func rollbackHandler(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	slug := c.Param("slug")
+	source := "registry://" + slug
+	if err := middlewares.AllowInstallApp(c, consts.WebappType, source, permission.POST); err != nil {
+		return err
+	}
+	man, err := app.Rollback(instance, app.AppsFileServer(instance), slug)
+	if err != nil {
+		return wrapAppsError(err)
+	}
+	man.Instance = instance
+	return jsonapi.Data(c, http.StatusOK, &apiApp{man}, nil)
+}
+
 // deleteHandler handles all DELETE /:slug used to delete an application with
 // the specified slug.
 func deleteHandler(installerType consts.AppType) echo.HandlerFunc {
@@ -582,6 +600,7 @@ func WebappsRoutes(router *echo.Group) {
 	router.POST("/:slug", installHandler(consts.WebappType))
 	router.PUT("/:slug", updateHandler(consts.WebappType))
 	router.DELETE("/:slug", deleteHandler(consts.WebappType))
+	router.POST("/:slug/rollback", rollbackHandler)
 	router.GET("/:slug/icon", iconHandler(consts.WebappType))
 	router.GET("/:slug/icon/:version", iconHandler(consts.WebappType))
 }
@@ -618,6 +637,10 @@ func wrapAppsError(err error) error {
 		return jsonapi.BadRequest(err)
 	case app.ErrLinkedAppExists:
 		return jsonapi.BadRequest(err)
+	case app.ErrNoPreviousVersion:
+		return jsonapi.NotFound(err)
+	case app.ErrBadState:
+		return jsonapi.Conflict(err)
 	case limits.ErrRateLimitReached,
 		limits.ErrRateLimitExceeded:
 		return jsonapi.BadRequest(err)
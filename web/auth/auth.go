@@ -91,7 +91,7 @@ func Home(c echo.Context) error {
 				u.Fragment = splits[1]
 			}
 			q := url.Values{"redirect": {u.String()}}
-			return c.Redirect(http.StatusSeeOther, instance.PageURL("/oidc/start", q))
+			return c.Redirect(http.StatusSeeOther, delegatedAuthStartURL(instance, q))
 		}
 	}
 
@@ -105,7 +105,7 @@ func Home(c echo.Context) error {
 // SetCookieForNewSession creates a new session and sets the cookie on echo context
 func SetCookieForNewSession(c echo.Context, longRunSession bool) (string, error) {
 	instance := middlewares.GetInstance(c)
-	session, err := session.New(instance, longRunSession)
+	session, err := session.New(instance, longRunSession, c.Request())
 	if err != nil {
 		return "", err
 	}
@@ -149,7 +149,7 @@ func redirectOIDC(c echo.Context, inst *instance.Instance) error {
 	if redirect := c.QueryParam("redirect"); redirect != "" {
 		q = url.Values{"redirect": {redirect}}
 	}
-	return c.Redirect(http.StatusSeeOther, inst.PageURL("/oidc/start", q))
+	return c.Redirect(http.StatusSeeOther, delegatedAuthStartURL(inst, q))
 }
 
 func renderLoginForm(c echo.Context, i *instance.Instance, code int, credsErrors string, redirect *url.URL) error {
@@ -343,12 +343,13 @@ func login(c echo.Context) error {
 			migrateToHashedPassphrase(inst, settings, passphrase, iterations)
 		}
 
-		// In case the second factor authentication mode is "mail", we also
-		// check that the mail has been confirmed. If not, 2FA is not
-		// activated.
-		// If device is trusted, skip the 2FA.
-		if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+		// In case a second factor authentication mode is activated (mail,
+		// authenticator app or security key), the user is redirected to the
+		// 2FA form. If device is trusted, skip the 2FA. A passcode is also
+		// sent by mail when the login comes from a new country, even if the
+		// 2FA is not activated.
+		if session.NeedsTwoFactor(inst, c.Request()) && !isTrustedDevice(c, inst) {
+			twoFactorToken, err := lifecycle.StartTwoFactor(inst)
 			if err != nil {
 				return err
 			}
@@ -593,8 +594,26 @@ func Routes(router *echo.Group) {
 
 	router.POST("/access_token", accessToken)
 	router.POST("/secret_exchange", secretExchange)
+	router.POST("/introspect", introspectToken)
+	router.POST("/revoke", revokeToken)
+
+	// SAML service provider
+	router.GET("/saml/start", samlStart, middlewares.CheckOnboardingNotFinished)
+	router.POST("/saml/acs", samlACS)
+	router.GET("/saml/metadata", samlMetadata)
+
+	// OpenID Connect provider
+	router.GET("/jwks", jwks)
+	router.GET("/userinfo", userInfo)
+	router.POST("/userinfo", userInfo)
+
+	// OAuth device flow
+	router.POST("/device_authorization", deviceAuthorization)
+	router.GET("/device", deviceForm, noCSRF)
+	router.POST("/device", deviceAuthorize, noCSRF)
 
 	// 2FA
 	router.GET("/twofactor", twoFactorForm)
 	router.POST("/twofactor", twoFactor)
+	router.POST("/twofactor/mail", twoFactorMailFallback)
 }
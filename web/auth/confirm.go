@@ -24,7 +24,7 @@ func confirmForm(c echo.Context) error {
 	}
 	if !inst.IsPasswordAuthenticationEnabled() {
 		q := url.Values{"redirect": {redirect}, "confirm_state": {state}}
-		return c.Redirect(http.StatusSeeOther, inst.PageURL("/oidc/start", q))
+		return c.Redirect(http.StatusSeeOther, delegatedAuthStartURL(inst, q))
 	}
 
 	iterations := 0
@@ -69,8 +69,8 @@ func confirmAuth(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
+		twoFactorToken, err := lifecycle.StartTwoFactor(inst)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// deviceAuthorization is the device authorization endpoint of the OAuth2
+// device flow. See https://tools.ietf.org/html/rfc8628#section-3.1
+func deviceAuthorization(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	client, reason, err := authenticateClient(c, inst)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error":             "invalid_client",
+			"error_description": reason,
+		})
+	}
+	if !client.AllowDeviceFlow() || oauth.IsLinkedApp(client.SoftwareID) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "unauthorized_client",
+		})
+	}
+
+	scope := c.FormValue("scope")
+	if scope == "" || scope == oauth.ScopeLogin {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid_scope",
+		})
+	}
+	if _, _, err := scopePermissions(scope); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid_scope",
+		})
+	}
+
+	dc, err := oauth.CreateDeviceCode(inst, client, scope)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"device_code":               dc.DeviceCode(),
+		"user_code":                 dc.DisplayUserCode(),
+		"verification_uri":          inst.PageURL("/auth/device", nil),
+		"verification_uri_complete": inst.PageURL("/auth/device", url.Values{"user_code": {dc.DisplayUserCode()}}),
+		"expires_in":                int(oauth.DeviceCodeTTL.Seconds()),
+		"interval":                  dc.Interval,
+	})
+}
+
+// deviceForm is the verification page where the user types the code
+// displayed on the device, and then approves the permissions asked by the
+// client.
+func deviceForm(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		u := inst.PageURL("/auth/login", url.Values{
+			"redirect": {inst.FromURL(c.Request().URL)},
+		})
+		return c.Redirect(http.StatusSeeOther, u)
+	}
+
+	userCode := c.QueryParam("user_code")
+	if userCode == "" {
+		return renderUserCodeForm(c, inst, http.StatusOK, "")
+	}
+	dc, err := oauth.FindDeviceCodeByUserCode(inst, userCode)
+	if err == oauth.ErrInvalidDeviceCode || err == oauth.ErrExpiredToken {
+		return renderUserCodeForm(c, inst, http.StatusBadRequest,
+			inst.Translate("Authorize Device Invalid code"))
+	}
+	if err != nil {
+		return err
+	}
+	client, err := oauth.FindClient(inst, dc.ClientID)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Error No registered client")
+	}
+	permissions, identity, err := scopePermissions(dc.Scope)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Error Invalid scope")
+	}
+
+	values := authorizeFormValues(c, inst, client, permissions)
+	values["Identity"] = identity
+	values["UserCode"] = dc.DisplayUserCode()
+	return c.Render(http.StatusOK, "authorize.html", values)
+}
+
+// deviceAuthorize is called when the user has approved the permissions asked
+// by the client on the device.
+func deviceAuthorize(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !middlewares.IsLoggedIn(c) {
+		return renderError(c, http.StatusUnauthorized, "Error Must be authenticated")
+	}
+
+	dc, err := oauth.FindDeviceCodeByUserCode(inst, c.FormValue("user_code"))
+	if err == oauth.ErrInvalidDeviceCode || err == oauth.ErrExpiredToken {
+		return renderUserCodeForm(c, inst, http.StatusBadRequest,
+			inst.Translate("Authorize Device Invalid code"))
+	}
+	if err != nil {
+		return err
+	}
+	client, err := oauth.FindClient(inst, dc.ClientID)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Error No registered client")
+	}
+	if err := dc.Approve(inst, client); err != nil {
+		return err
+	}
+	inst.Logger().WithField("nspace", "loginaudit").
+		Infof("Device code approved for client %s with scope %s", client.ClientID, dc.Scope)
+
+	return c.Render(http.StatusOK, "authorize.html", echo.Map{
+		"Domain":         inst.ContextualDomain(),
+		"ContextName":    inst.ContextName,
+		"Locale":         inst.Locale,
+		"Title":          inst.TemplateTitle(),
+		"Favicon":        middlewares.Favicon(inst),
+		"DeviceApproved": true,
+	})
+}
+
+func renderUserCodeForm(c echo.Context, inst *instance.Instance, code int, errorMessage string) error {
+	return c.Render(code, "authorize.html", echo.Map{
+		"Domain":      inst.ContextualDomain(),
+		"ContextName": inst.ContextName,
+		"Locale":      inst.Locale,
+		"Title":       inst.TemplateTitle(),
+		"Favicon":     middlewares.Favicon(inst),
+		"AskUserCode": true,
+		"Error":       errorMessage,
+	})
+}
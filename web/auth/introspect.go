@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	accessTokenType  = "access_token"
+	refreshTokenType = "refresh_token"
+)
+
+// clientToken checks that the token is an access or refresh token of the
+// client, and returns its claims and its type. The hint is used to try first
+// the most probable type of token.
+func clientToken(inst *instance.Instance, client *oauth.Client, token, hint string) (permission.Claims, string, bool) {
+	types := []string{accessTokenType, refreshTokenType}
+	if hint == refreshTokenType {
+		types = []string{refreshTokenType, accessTokenType}
+	}
+	for _, typ := range types {
+		audience := consts.AccessTokenAudience
+		if typ == refreshTokenType {
+			audience = consts.RefreshTokenAudience
+		}
+		if claims, ok := client.ValidToken(inst, audience, token); ok {
+			return claims, typ, true
+		}
+	}
+	return permission.Claims{}, "", false
+}
+
+// introspectToken is the token introspection endpoint. A confidential client
+// can use it to know if one of its tokens is still active.
+// See https://tools.ietf.org/html/rfc7662
+func introspectToken(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	client, reason, err := authenticateClient(c, inst)
+	if err != nil {
+		return err
+	}
+	if reason == "" && client.IsPublic() {
+		reason = "a public client can't introspect a token"
+	}
+	if reason != "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error":             "invalid_client",
+			"error_description": reason,
+		})
+	}
+
+	token := c.FormValue("token")
+	claims, typ, ok := clientToken(inst, client, token, c.FormValue("token_type_hint"))
+	if !ok {
+		return c.JSON(http.StatusOK, echo.Map{"active": false})
+	}
+	res := echo.Map{
+		"active":     true,
+		"scope":      claims.Scope,
+		"client_id":  client.ClientID,
+		"token_type": typ,
+		"iat":        claims.IssuedAt,
+		"sub":        claims.Subject,
+		"aud":        claims.Audience,
+		"iss":        claims.Issuer,
+	}
+	if typ == accessTokenType {
+		res["exp"] = claims.IssuedAtUTC().Add(consts.AccessTokenValidityDuration).Unix()
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// revokeToken is the token revocation endpoint. When a client revokes one
+// of its access or refresh tokens, all the tokens issued for this client until
+// now are revoked. See https://tools.ietf.org/html/rfc7009
+func revokeToken(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	client, reason, err := authenticateClient(c, inst)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error":             "invalid_client",
+			"error_description": reason,
+		})
+	}
+
+	token := c.FormValue("token")
+	// An invalid token, or a token for another client, is not an error for
+	// the revocation endpoint.
+	if _, _, ok := clientToken(inst, client, token, c.FormValue("token_type_hint")); ok {
+		if err := client.RevokeTokens(inst); err != nil {
+			return err
+		}
+		inst.Logger().WithField("nspace", "loginaudit").
+			Infof("Tokens revoked for client %s", client.ClientID)
+	}
+	return c.NoContent(http.StatusOK)
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -36,14 +35,17 @@ type webappParams struct {
 }
 
 type authorizeParams struct {
-	instance    *instance.Instance
-	state       string
-	clientID    string
-	redirectURI string
-	scope       string
-	resType     string
-	client      *oauth.Client
-	webapp      *webappParams
+	instance            *instance.Instance
+	state               string
+	clientID            string
+	redirectURI         string
+	scope               string
+	resType             string
+	codeChallenge       string
+	codeChallengeMethod string
+	nonce               string
+	client              *oauth.Client
+	webapp              *webappParams
 }
 
 func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error) {
@@ -68,6 +70,13 @@ func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error)
 	if !params.client.AcceptRedirectURI(params.redirectURI) {
 		return true, renderError(c, http.StatusBadRequest, "Error Incorrect redirect_uri")
 	}
+	if params.codeChallenge != "" {
+		if !oauth.ValidCodeChallenge(params.codeChallenge, params.codeChallengeMethod) {
+			return true, renderError(c, http.StatusBadRequest, "Error Invalid code challenge")
+		}
+	} else if params.client.IsPublic() {
+		return true, renderError(c, http.StatusBadRequest, "Error No code challenge")
+	}
 
 	if appSlug := oauth.GetLinkedAppSlug(params.client.SoftwareID); appSlug != "" {
 		webapp, err := registry.GetLatestVersion(appSlug, "stable", params.instance.Registries())
@@ -110,12 +119,15 @@ func checkAuthorizeParams(c echo.Context, params *authorizeParams) (bool, error)
 func authorizeForm(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	params := authorizeParams{
-		instance:    instance,
-		state:       c.QueryParam("state"),
-		clientID:    c.QueryParam("client_id"),
-		redirectURI: c.QueryParam("redirect_uri"),
-		scope:       c.QueryParam("scope"),
-		resType:     c.QueryParam("response_type"),
+		instance:            instance,
+		state:               c.QueryParam("state"),
+		clientID:            c.QueryParam("client_id"),
+		redirectURI:         c.QueryParam("redirect_uri"),
+		scope:               c.QueryParam("scope"),
+		resType:             c.QueryParam("response_type"),
+		codeChallenge:       c.QueryParam("code_challenge"),
+		codeChallengeMethod: c.QueryParam("code_challenge_method"),
+		nonce:               c.QueryParam("nonce"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -133,7 +145,10 @@ func authorizeForm(c echo.Context) error {
 	// for the manager. It does not require any authorization from the user, and
 	// generate a code without asking any permission.
 	if params.scope == oauth.ScopeLogin {
-		access, err := oauth.CreateAccessCode(params.instance, params.client, "" /* = scope */)
+		access, err := oauth.CreateAccessCode(params.instance, params.client, "" /* = scope */, oauth.AccessCodeOptions{
+			CodeChallenge:       params.codeChallenge,
+			CodeChallengeMethod: params.codeChallengeMethod,
+		})
 		if err != nil {
 			return err
 		}
@@ -155,29 +170,67 @@ func authorizeForm(c echo.Context) error {
 		return c.Redirect(http.StatusFound, u.String()+"#")
 	}
 
-	permissions, err := permission.UnmarshalScopeString(params.scope)
+	permissions, identity, err := scopePermissions(params.scope)
 	if err != nil {
 		return renderError(c, http.StatusBadRequest, "Error Invalid scope")
 	}
+
+	values := authorizeFormValues(c, instance, params.client, permissions)
+	values["Identity"] = identity
+	values["State"] = params.state
+	values["RedirectURI"] = params.redirectURI
+	values["Scope"] = params.scope
+	values["CodeChallenge"] = params.codeChallenge
+	values["CodeChallengeMethod"] = params.codeChallengeMethod
+	values["Nonce"] = params.nonce
+	values["HasFallback"] = c.QueryParam("fallback_uri") != ""
+	values["Webapp"] = params.webapp
+	return c.Render(http.StatusOK, "authorize.html", values)
+}
+
+// scopePermissions returns the permissions for the scope asked by a client,
+// and the translation keys for the claims asked by an OpenID Connect client.
+func scopePermissions(scope string) (permission.Set, []string, error) {
+	oidc, rest := oauth.SplitOIDCScope(scope)
+	identity := make([]string, len(oidc))
+	for i, s := range oidc {
+		identity[i] = "Permissions " + s
+	}
+	if rest == "" {
+		if len(oidc) == 0 {
+			return nil, nil, permission.ErrBadScope
+		}
+		return permission.Set{}, identity, nil
+	}
+	permissions, err := permission.UnmarshalScopeString(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return permissions, identity, nil
+}
+
+// authorizeFormValues returns the values for the authorize.html template
+// that are used to display the client and the permissions that it asks.
+func authorizeFormValues(c echo.Context, instance *instance.Instance, client *oauth.Client, permissions permission.Set) echo.Map {
 	readOnly := true
 	for _, p := range permissions {
 		if !p.Verbs.ReadOnly() {
 			readOnly = false
 		}
 	}
-	params.client.ClientID = params.client.CouchID
+	client.ClientID = client.CouchID
 
 	var clientDomain string
-	clientURL, err := url.Parse(params.client.ClientURI)
+	clientURL, err := url.Parse(client.ClientURI)
 	if err != nil {
-		clientDomain = params.client.ClientURI
+		clientDomain = client.ClientURI
 	} else {
 		clientDomain = clientURL.Hostname()
 	}
 
 	// This Content-Security-Policy (CSP) nonce is here to allow the display of
 	// logos for OAuth clients on the authorize page.
-	if logoURI := params.client.LogoURI; logoURI != "" {
+	if logoURI := client.LogoURI; logoURI != "" {
 		logoURL, err := url.Parse(logoURI)
 		if err == nil {
 			csp := c.Response().Header().Get(echo.HeaderContentSecurityPolicy)
@@ -190,8 +243,7 @@ func authorizeForm(c echo.Context) error {
 
 	slugname, instanceDomain := instance.SlugAndDomain()
 
-	hasFallback := c.QueryParam("fallback_uri") != ""
-	return c.Render(http.StatusOK, "authorize.html", echo.Map{
+	return echo.Map{
 		"Domain":           instance.ContextualDomain(),
 		"ContextName":      instance.ContextName,
 		"Locale":           instance.Locale,
@@ -200,27 +252,25 @@ func authorizeForm(c echo.Context) error {
 		"InstanceSlugName": slugname,
 		"InstanceDomain":   instanceDomain,
 		"ClientDomain":     clientDomain,
-		"Client":           params.client,
-		"State":            params.state,
-		"RedirectURI":      params.redirectURI,
-		"Scope":            params.scope,
+		"Client":           client,
 		"Permissions":      permissions,
 		"ReadOnly":         readOnly,
 		"CSRF":             c.Get("csrf"),
-		"HasFallback":      hasFallback,
-		"Webapp":           params.webapp,
-	})
+	}
 }
 
 func authorize(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	params := authorizeParams{
-		instance:    instance,
-		state:       c.FormValue("state"),
-		clientID:    c.FormValue("client_id"),
-		redirectURI: c.FormValue("redirect_uri"),
-		scope:       c.FormValue("scope"),
-		resType:     c.FormValue("response_type"),
+		instance:            instance,
+		state:               c.FormValue("state"),
+		clientID:            c.FormValue("client_id"),
+		redirectURI:         c.FormValue("redirect_uri"),
+		scope:               c.FormValue("scope"),
+		resType:             c.FormValue("response_type"),
+		codeChallenge:       c.FormValue("code_challenge"),
+		codeChallengeMethod: c.FormValue("code_challenge_method"),
+		nonce:               c.FormValue("nonce"),
 	}
 
 	if hasError, err := checkAuthorizeParams(c, &params); hasError {
@@ -269,7 +319,15 @@ func authorize(c echo.Context) error {
 		}
 	}
 
-	access, err := oauth.CreateAccessCode(params.instance, params.client, params.scope)
+	opts := oauth.AccessCodeOptions{
+		CodeChallenge:       params.codeChallenge,
+		CodeChallengeMethod: params.codeChallengeMethod,
+		Nonce:               params.nonce,
+	}
+	if sess, ok := middlewares.GetSession(c); ok {
+		opts.AuthTime = sess.CreatedAt.Unix()
+	}
+	access, err := oauth.CreateAccessCode(params.instance, params.client, params.scope, opts)
 	if err != nil {
 		return err
 	}
@@ -466,7 +524,7 @@ func authorizeMoveForm(c echo.Context) error {
 			u := c.Request().URL
 			redirect := inst.PageURL(u.Path, u.Query())
 			q := url.Values{"redirect": {redirect}}
-			return c.Redirect(http.StatusSeeOther, inst.PageURL("/oidc/start", q))
+			return c.Redirect(http.StatusSeeOther, delegatedAuthStartURL(inst, q))
 		}
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
 		if err != nil {
@@ -567,8 +625,8 @@ func authorizeMove(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if session.NeedsTwoFactor(inst, c.Request()) && !isTrustedDevice(c, inst) {
+		twoFactorToken, err := lifecycle.StartTwoFactor(inst)
 		if err != nil {
 			return err
 		}
@@ -610,7 +668,7 @@ func moveSuccessURI(c echo.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	access, err := oauth.CreateAccessCode(inst, client, move.MoveScope)
+	access, err := oauth.CreateAccessCode(inst, client, move.MoveScope, oauth.AccessCodeOptions{})
 	if err != nil {
 		return "", err
 	}
@@ -653,42 +711,66 @@ type AccessTokenReponse struct {
 	Scope   string `json:"scope"`
 	Access  string `json:"access_token"`
 	Refresh string `json:"refresh_token,omitempty"`
+	IDToken string `json:"id_token,omitempty"`
 }
 
-func accessToken(c echo.Context) error {
-	grant := c.FormValue("grant_type")
+// authenticateClient loads the OAuth client that makes the request, and
+// checks its client_secret. The client_id and client_secret can be sent in
+// the body of the request, or via HTTP Basic auth. A public client only sends
+// its client_id. If the client can't be authenticated, the returned string is
+// the reason for that.
+func authenticateClient(c echo.Context, instance *instance.Instance) (*oauth.Client, string, error) {
 	clientID := c.FormValue("client_id")
 	clientSecret := c.FormValue("client_secret")
-	instance := middlewares.GetInstance(c)
-
-	if grant == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "the grant_type parameter is mandatory",
-		})
+	if id, secret, ok := c.Request().BasicAuth(); ok && clientID == "" {
+		// The credentials are form-urlencoded in the Basic auth, see
+		// https://tools.ietf.org/html/rfc6749#section-2.3.1
+		clientID, _ = url.QueryUnescape(id)
+		clientSecret, _ = url.QueryUnescape(secret)
 	}
+
 	if clientID == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "the client_id parameter is mandatory",
-		})
-	}
-	if clientSecret == "" {
-		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "the client_secret parameter is mandatory",
-		})
+		return nil, "the client_id parameter is mandatory", nil
 	}
-
 	client, err := oauth.FindClient(instance, clientID)
 	if err != nil {
 		if couchErr, isCouchErr := couchdb.IsCouchError(err); isCouchErr && couchErr.StatusCode >= 500 {
-			return err
+			return nil, "", err
+		}
+		return nil, "the client must be registered", nil
+	}
+	if client.IsPublic() {
+		if clientSecret != "" {
+			return nil, "invalid client_secret", nil
 		}
+		return client, "", nil
+	}
+	if clientSecret == "" {
+		return nil, "the client_secret parameter is mandatory", nil
+	}
+	if !client.CheckSecret(clientSecret) {
+		return nil, "invalid client_secret", nil
+	}
+	return client, "", nil
+}
+
+func accessToken(c echo.Context) error {
+	grant := c.FormValue("grant_type")
+	instance := middlewares.GetInstance(c)
+
+	if grant == "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "the client must be registered",
+			"error": "the grant_type parameter is mandatory",
 		})
 	}
-	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(client.ClientSecret)) == 0 {
+
+	client, reason, err := authenticateClient(c, instance)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
 		return c.JSON(http.StatusBadRequest, echo.Map{
-			"error": "invalid client_secret",
+			"error": reason,
 		})
 	}
 	out := AccessTokenReponse{
@@ -703,7 +785,7 @@ func accessToken(c echo.Context) error {
 	}
 
 	switch grant {
-	case "authorization_code":
+	case oauth.GrantTypeAuthorizationCode:
 		code := c.FormValue("code")
 		if code == "" {
 			return c.JSON(http.StatusBadRequest, echo.Map{
@@ -716,6 +798,21 @@ func accessToken(c echo.Context) error {
 				"error": "invalid code",
 			})
 		}
+		if accessCode.ClientID != client.ClientID {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid code",
+			})
+		}
+		if client.IsPublic() && accessCode.CodeChallenge == "" {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "the code_challenge is mandatory for a public client",
+			})
+		}
+		if !accessCode.CheckVerifier(c.FormValue("code_verifier")) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid code_verifier",
+			})
+		}
 		out.Scope = accessCode.Scope
 		out.Refresh, err = client.CreateJWT(instance, consts.RefreshTokenAudience, out.Scope)
 		if err != nil {
@@ -723,6 +820,14 @@ func accessToken(c echo.Context) error {
 				"error": "Can't generate refresh token",
 			})
 		}
+		if oauth.HasOpenIDScope(out.Scope) {
+			out.IDToken, err = client.CreateIDToken(instance, out.Scope, accessCode.Nonce, accessCode.AuthTime)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"error": "Can't generate ID token",
+				})
+			}
+		}
 		// Delete the access code, it can be used only once
 		err = couchdb.DeleteDoc(instance, accessCode)
 		if err != nil {
@@ -730,7 +835,7 @@ func accessToken(c echo.Context) error {
 				"[oauth] Failed to delete the access code: %s", err)
 		}
 
-	case "refresh_token":
+	case oauth.GrantTypeRefreshToken:
 		token := c.FormValue("refresh_token")
 		claims, ok := client.ValidToken(instance, consts.RefreshTokenAudience, token)
 		if !ok && client.ClientKind == "sharing" {
@@ -749,6 +854,34 @@ func accessToken(c echo.Context) error {
 			out.Scope = claims.Scope
 		}
 
+	case oauth.GrantTypeDeviceCode:
+		if !client.AllowDeviceFlow() {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "unauthorized_client",
+			})
+		}
+		deviceCode, err := oauth.FindDeviceCode(instance, client, c.FormValue("device_code"))
+		if err == nil {
+			err = deviceCode.Poll(instance)
+		}
+		switch err {
+		case nil:
+		case oauth.ErrInvalidDeviceCode, oauth.ErrAuthorizationPending,
+			oauth.ErrSlowDown, oauth.ErrExpiredToken:
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": err.Error(),
+			})
+		default:
+			return err
+		}
+		out.Scope = deviceCode.Scope
+		out.Refresh, err = client.CreateJWT(instance, consts.RefreshTokenAudience, out.Scope)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": "Can't generate refresh token",
+			})
+		}
+
 	default:
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "invalid grant type",
@@ -762,7 +895,7 @@ func accessToken(c echo.Context) error {
 		})
 	}
 
-	_ = session.RemoveLoginRegistration(instance.ContextualDomain(), clientID)
+	_ = session.RemoveLoginRegistration(instance.ContextualDomain(), client.ClientID)
 	return c.JSON(http.StatusOK, out)
 }
 
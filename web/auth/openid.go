@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// OpenIDConfiguration returns the discovery document of the stack as an
+// OpenID Connect provider.
+// See https://openid.net/specs/openid-connect-discovery-1_0.html
+func OpenIDConfiguration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	return c.JSON(http.StatusOK, echo.Map{
+		"issuer":                                oauth.Issuer(inst),
+		"authorization_endpoint":                inst.PageURL("/auth/authorize", nil),
+		"token_endpoint":                        inst.PageURL("/auth/access_token", nil),
+		"userinfo_endpoint":                     inst.PageURL("/auth/userinfo", nil),
+		"jwks_uri":                              inst.PageURL("/auth/jwks", nil),
+		"registration_endpoint":                 inst.PageURL("/auth/register", nil),
+		"introspection_endpoint":                inst.PageURL("/auth/introspect", nil),
+		"revocation_endpoint":                   inst.PageURL("/auth/revoke", nil),
+		"device_authorization_endpoint":         inst.PageURL("/auth/device_authorization", nil),
+		"scopes_supported":                      []string{oauth.ScopeOpenID, oauth.ScopeProfile, oauth.ScopeEmail},
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{oauth.GrantTypeAuthorizationCode, oauth.GrantTypeRefreshToken, oauth.GrantTypeDeviceCode},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{oauth.AuthMethodSecretPost, oauth.AuthMethodSecretBasic, oauth.AuthMethodNone},
+		"code_challenge_methods_supported":      []string{oauth.PKCEMethodS256, oauth.PKCEMethodPlain},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "preferred_username", "locale", "website", "email",
+		},
+	})
+}
+
+// jwks returns the public keys used to sign the ID tokens.
+func jwks(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	keys, err := oauth.GetJSONWebKeys(inst)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"keys": keys})
+}
+
+type userInfoResponse struct {
+	Subject string `json:"sub"`
+	*oauth.UserInfo
+}
+
+// userInfo returns the claims about the owner of the instance, for an access
+// token with the openid scope.
+// See https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func userInfo(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil || pdoc.Type != permission.TypeOauth {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_token"})
+	}
+	client, ok := pdoc.Client.(*oauth.Client)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_token"})
+	}
+	claims, ok := client.ValidToken(inst, consts.AccessTokenAudience, middlewares.GetRequestToken(c))
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid_token"})
+	}
+	if !oauth.HasOpenIDScope(claims.Scope) {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="insufficient_scope"`)
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "insufficient_scope"})
+	}
+
+	info, err := oauth.GetUserInfo(inst, claims.Scope)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, userInfoResponse{
+		Subject:  oauth.Subject(inst),
+		UserInfo: info,
+	})
+}
@@ -17,7 +17,9 @@ func LoginRateExceeded(i *instance.Instance) error {
 }
 
 // TwoFactorRateExceeded regenerates a new 2FA passcode after too many failed
-// attempts to login
+// attempts to login. For an authenticator app or a security key, there is no
+// passcode to regenerate, but the instance will be blocked after too many
+// failed attempts too.
 func TwoFactorRateExceeded(i *instance.Instance) error {
 	err := limits.CheckRateLimit(i, limits.TwoFactorGenerationType)
 	if limits.IsLimitReachedOrExceeded(err) {
@@ -25,6 +27,9 @@ func TwoFactorRateExceeded(i *instance.Instance) error {
 	}
 	// Reset the key and send a new passcode to the user
 	limits.ResetCounter(i, limits.TwoFactorType)
+	if !i.HasAuthMode(instance.TwoFactorMail) {
+		return nil
+	}
 	_, err = lifecycle.SendTwoFactorPasscode(i)
 	return err
 }
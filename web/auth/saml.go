@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/saml"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// samlRequestTTL is the maximal duration between the start of the SAML
+// authentication and the response of the identity provider.
+const samlRequestTTL = 15 * time.Minute
+
+// samlConfig is the config of a context to log in the users with a SAML
+// identity provider.
+type samlConfig struct {
+	SP                *saml.ServiceProvider
+	InstanceAttribute string
+	InstancePrefix    string
+	InstanceSuffix    string
+}
+
+// samlState is what is kept between the authentication request and the
+// response of the identity provider.
+type samlState struct {
+	Domain   string `json:"domain"`
+	Redirect string `json:"redirect,omitempty"`
+	Confirm  string `json:"confirm,omitempty"`
+}
+
+func getSAMLConfig(inst *instance.Instance) (*samlConfig, error) {
+	conf, ok := config.GetSAML(inst.ContextName)
+	if !ok {
+		return nil, errors.New("No SAML is configured for this context")
+	}
+
+	entityID, ok := conf["sp_entity_id"].(string)
+	if !ok {
+		return nil, errors.New("The sp_entity_id is missing for this context")
+	}
+
+	var idp *saml.IdentityProvider
+	if file, ok := conf["idp_metadata"].(string); ok {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if idp, err = saml.ParseIdPMetadata(data); err != nil {
+			return nil, err
+		}
+	} else {
+		idp = &saml.IdentityProvider{}
+		if idp.EntityID, ok = conf["idp_entity_id"].(string); !ok {
+			return nil, errors.New("The idp_entity_id is missing for this context")
+		}
+		if idp.SSOURL, ok = conf["idp_sso_url"].(string); !ok {
+			return nil, errors.New("The idp_sso_url is missing for this context")
+		}
+		data, ok := conf["idp_certificate"].(string)
+		if !ok {
+			return nil, errors.New("The idp_certificate is missing for this context")
+		}
+		cert, err := saml.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		idp.Certificates = append(idp.Certificates, cert)
+	}
+
+	mapping, _ := conf["attribute_mapping"].(map[string]interface{})
+	attribute, _ := mapping["instance"].(string)
+	prefix, _ := conf["instance_prefix"].(string)
+	suffix, _ := conf["instance_suffix"].(string)
+
+	return &samlConfig{
+		SP: &saml.ServiceProvider{
+			EntityID: entityID,
+			ACSURL:   inst.PageURL("/auth/saml/acs", nil),
+			IdP:      idp,
+		},
+		InstanceAttribute: attribute,
+		InstancePrefix:    prefix,
+		InstanceSuffix:    suffix,
+	}, nil
+}
+
+// domain returns the domain of the instance for the user authenticated by
+// the identity provider: the NameID (or the mapped attribute) is used with the
+// configured prefix and suffix.
+func (conf *samlConfig) domain(assertion *saml.Assertion) string {
+	value := assertion.NameID
+	if conf.InstanceAttribute != "" {
+		value = assertion.Attribute(conf.InstanceAttribute)
+	}
+	if value == "" {
+		return ""
+	}
+	return conf.InstancePrefix + strings.ToLower(value) + conf.InstanceSuffix
+}
+
+// delegatedAuthStartURL returns the URL where the user must go to log in when
+// the password authentication is disabled: SAML if it is configured for the
+// context of the instance, or else OpenID Connect.
+func delegatedAuthStartURL(inst *instance.Instance, q url.Values) string {
+	if _, ok := config.GetSAML(inst.ContextName); ok {
+		return inst.PageURL("/auth/saml/start", q)
+	}
+	return inst.PageURL("/oidc/start", q)
+}
+
+func samlStateKey(requestID string) string {
+	return "saml:" + requestID
+}
+
+// samlStart redirects the user to the identity provider with an
+// authentication request.
+func samlStart(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	conf, err := getSAMLConfig(inst)
+	if err != nil {
+		inst.Logger().WithField("nspace", "saml").Infof("Start error: %s", err)
+		return renderError(c, http.StatusNotFound, "Error SAML not configured")
+	}
+
+	var redirect string
+	if u, err := checkRedirectParam(c, nil); err != nil {
+		return err
+	} else if u != nil {
+		redirect = u.String()
+	}
+	state, err := json.Marshal(samlState{
+		Domain:   inst.Domain,
+		Redirect: redirect,
+		Confirm:  c.QueryParam("confirm_state"),
+	})
+	if err != nil {
+		return err
+	}
+	requestID := saml.NewRequestID()
+	config.GetConfig().CacheStorage.Set(samlStateKey(requestID), state, samlRequestTTL)
+
+	u, err := conf.SP.RedirectURL(requestID, requestID, time.Now())
+	if err != nil {
+		return renderError(c, http.StatusNotFound, "Error SAML not configured")
+	}
+	return c.Redirect(http.StatusSeeOther, u)
+}
+
+// samlACS is the assertion consumer service: the identity provider sends the
+// SAML response to this endpoint via the browser of the user. If the response
+// is valid, the user is logged in.
+func samlACS(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	conf, err := getSAMLConfig(inst)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Error SAML not configured")
+	}
+
+	// The state can be used only once, to avoid replay attacks
+	requestID := c.FormValue("RelayState")
+	cache := config.GetConfig().CacheStorage
+	data, ok := cache.Get(samlStateKey(requestID))
+	if !ok {
+		return renderError(c, http.StatusBadRequest, "Error SAML session expired")
+	}
+	cache.Clear(samlStateKey(requestID))
+	var state samlState
+	if err := json.Unmarshal(data, &state); err != nil || state.Domain != inst.Domain {
+		return renderError(c, http.StatusBadRequest, "Error SAML session expired")
+	}
+
+	assertion, err := conf.SP.ParseResponse(c.FormValue("SAMLResponse"), requestID, time.Now())
+	if err != nil {
+		inst.Logger().WithField("nspace", "saml").Warnf("Invalid response: %s", err)
+		return renderError(c, http.StatusBadRequest, "Error SAML invalid response")
+	}
+	if domain := conf.domain(assertion); domain != inst.Domain {
+		inst.Logger().WithField("nspace", "saml").
+			Warnf("Invalid domains: %s != %s", domain, inst.Domain)
+		return renderError(c, http.StatusBadRequest, "Error SAML instance not found")
+	}
+
+	// Check 2FA if enabled
+	if inst.HasTwoFactor() {
+		twoFactorToken, err := lifecycle.StartTwoFactor(inst)
+		if err != nil {
+			return err
+		}
+		v := url.Values{}
+		// As for OIDC, the trusted_device option can't be checked cleanly
+		v.Add("trusted_device_checkbox", "false")
+		v.Add("two_factor_token", string(twoFactorToken))
+		if state.Redirect != "" {
+			v.Add("redirect", state.Redirect)
+		}
+		if state.Confirm != "" {
+			v.Add("confirm", "true")
+			v.Add("state", state.Confirm)
+		}
+		return c.Redirect(http.StatusSeeOther, inst.PageURL("/auth/twofactor", v))
+	}
+
+	// The SAML authentication has been made to confirm the identity of the
+	// user, not for creating a new session.
+	if state.Confirm != "" {
+		return ConfirmSuccess(c, inst, state.Confirm)
+	}
+
+	sessionID, err := SetCookieForNewSession(c, false)
+	if err != nil {
+		return err
+	}
+	if err = session.StoreNewLoginEntry(inst, sessionID, "", c.Request(), "SAML", true); err != nil {
+		inst.Logger().Errorf("Could not store session history %q: %s", sessionID, err)
+	}
+	redirect := state.Redirect
+	if redirect == "" {
+		redirect = inst.DefaultRedirection().String()
+	}
+	return c.Redirect(http.StatusSeeOther, redirect)
+}
+
+// samlMetadata returns the metadata of the service provider, to declare it on
+// the identity provider.
+func samlMetadata(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	conf, err := getSAMLConfig(inst)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	data, err := conf.SP.Metadata()
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", data)
+}
@@ -1,11 +1,13 @@
 package auth
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/pkg/limits"
 	"github.com/cozy/cozy-stack/web/middlewares"
@@ -40,6 +42,23 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 	oauth := i.HasDomain(redirect.Host) && redirect.Path == "/auth/authorize" && clientScope != oauth.ScopeLogin
 	trustedCheckbox := !oauth && trustedDeviceCheckBox
 
+	// The options for the security keys are given to the page as JSON, to be
+	// used by the script with navigator.credentials.get
+	var webauthnOptions string
+	if len(i.WebAuthnCredentials) > 0 {
+		options, err := json.Marshal(i.WebAuthnRequestOptions(twoFactorToken))
+		if err != nil {
+			return err
+		}
+		webauthnOptions = string(options)
+	}
+
+	// Without 2FA, the passcode has been sent by mail for a suspicious login
+	authMode := i.AuthMode
+	if !i.HasTwoFactor() {
+		authMode = instance.TwoFactorMail
+	}
+
 	return c.Render(code, "twofactor.html", echo.Map{
 		"Domain":                i.ContextualDomain(),
 		"ContextName":           i.ContextName,
@@ -54,6 +73,9 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 		"LongRunSession":        longRunSession,
 		"TwoFactorToken":        string(twoFactorToken),
 		"TrustedDeviceCheckBox": trustedCheckbox,
+		"AuthMode":              instance.AuthModeToString(authMode),
+		"WebAuthnOptions":       webauthnOptions,
+		"MailFallback":          !i.HasAuthMode(instance.TwoFactorMail) && i.HasTwoFactor() && i.HasTwoFactorMailFallback(),
 	})
 }
 
@@ -150,6 +172,38 @@ func twoFactor(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, redirect.String())
 }
 
+// twoFactorMailFallback sends a passcode by mail to a user who can't use their
+// authenticator app or security key, and returns a new 2FA token.
+func twoFactorMailFallback(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if !inst.CheckTwoFactorToken([]byte(c.FormValue("two-factor-token"))) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": inst.Translate(TwoFactorErrorKey),
+		})
+	}
+	err := limits.CheckRateLimit(inst, limits.TwoFactorGenerationType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		if err := TwoFactorGenerationExceeded(inst); err != nil {
+			inst.Logger().WithField("nspace", "auth").Warning(err)
+		}
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"error": inst.Translate(TwoFactorExceededErrorKey),
+		})
+	}
+	token, err := lifecycle.SendTwoFactorFallbackPasscode(inst)
+	if err == instance.ErrInvalidTwoFactor {
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"two_factor_token": string(token),
+	})
+}
+
 // twoFactorFailed returns the 2FA form with an error message
 func twoFactorFailed(c echo.Context, inst *instance.Instance, token []byte) error {
 	errorMessage := inst.Translate(TwoFactorErrorKey)
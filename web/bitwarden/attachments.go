@@ -0,0 +1,382 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// fileUploadTypeDirect is used to tell the clients that the content of an
+// attachment must be uploaded to the stack (and not to Azure).
+const fileUploadTypeDirect = 0
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/attachmentRequest.ts
+type attachmentRequest struct {
+	FileName string `json:"fileName"`
+	Key      string `json:"key"`
+	FileSize int64  `json:"fileSize"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/attachmentResponse.ts
+type attachmentResponse struct {
+	Object   string `json:"Object"`
+	ID       string `json:"Id"`
+	URL      string `json:"Url"`
+	FileName string `json:"FileName"`
+	Key      string `json:"Key"`
+	Size     string `json:"Size"`
+	SizeName string `json:"SizeName"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/attachmentUploadDataResponse.ts
+type attachmentUploadResponse struct {
+	Object         string          `json:"Object"`
+	AttachmentID   string          `json:"AttachmentId"`
+	URL            string          `json:"Url"`
+	FileUploadType int             `json:"FileUploadType"`
+	CipherResponse *cipherResponse `json:"CipherResponse"`
+}
+
+// newAttachmentResponse returns the response for an attachment. The URL for
+// downloading its encrypted content is only given by GetAttachment, as the
+// clients ask for it before downloading an attachment.
+func newAttachmentResponse(a *bitwarden.Attachment) *attachmentResponse {
+	return &attachmentResponse{
+		Object:   "attachment",
+		ID:       a.ID,
+		FileName: a.FileName,
+		Key:      a.Key,
+		Size:     strconv.FormatInt(a.Size, 10),
+		SizeName: sizeName(a.Size),
+	}
+}
+
+func newAttachmentUploadResponse(inst *instance.Instance, c *bitwarden.Cipher, a *bitwarden.Attachment, setting *settings.Settings) *attachmentUploadResponse {
+	return &attachmentUploadResponse{
+		Object:         "attachment-fileUpload",
+		AttachmentID:   a.ID,
+		URL:            fmt.Sprintf("/ciphers/%s/attachment/%s", c.ID(), a.ID),
+		FileUploadType: fileUploadTypeDirect,
+		CipherResponse: newCipherResponse(inst, c, setting),
+	}
+}
+
+// sizeName returns the size in a human readable format, like Bitwarden does.
+func sizeName(size int64) string {
+	units := []string{"Bytes", "KB", "MB", "GB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %s", size, units[i])
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
+
+// getCipherForAttachment checks the permissions and loads the cipher from the
+// id parameter of the route.
+func getCipherForAttachment(c echo.Context, verb permission.Verb) (*bitwarden.Cipher, error) {
+	if err := middlewares.AllowWholeType(c, verb, consts.BitwardenCiphers); err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+
+	inst := middlewares.GetInstance(c)
+	cipher := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, cipher); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return cipher, nil
+}
+
+// saveCipherWithAttachments persists the cipher after a change of its
+// attachments, and updates the revision date.
+func saveCipherWithAttachments(inst *instance.Instance, cipher *bitwarden.Cipher) (*settings.Settings, error) {
+	if cipher.Metadata != nil {
+		cipher.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, cipher); err != nil {
+		return nil, err
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return nil, err
+	}
+	_ = settings.UpdateRevisionDate(inst, setting)
+	return setting, nil
+}
+
+func attachmentError(c echo.Context, err error) error {
+	switch err {
+	case bitwarden.ErrAttachmentNotFound:
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrAttachmentTooBig, vfs.ErrContentLengthMismatch:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+// CreateAttachment is the handler for adding an attachment to a cipher. The
+// metadata are sent first, and the client will then upload the encrypted
+// content with UploadAttachment.
+func CreateAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.POST)
+	if cipher == nil {
+		return err
+	}
+
+	var req attachmentRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.FileName == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "fileName is mandatory",
+		})
+	}
+	if err := bitwarden.CheckAttachmentSize(inst, req.FileSize); err != nil {
+		return attachmentError(c, err)
+	}
+
+	attachment, err := bitwarden.NewAttachment(req.FileName, req.Key, req.FileSize)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	cipher.Attachments = append(cipher.Attachments, *attachment)
+	setting, err := saveCipherWithAttachments(inst, cipher)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	res := newAttachmentUploadResponse(inst, cipher, attachment, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// RenewAttachmentUpload is the handler used by the clients to get again the
+// upload informations for an attachment.
+func RenewAttachmentUpload(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.GET)
+	if cipher == nil {
+		return err
+	}
+	attachment, err := cipher.FindAttachment(c.Param("attachment-id"))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	if attachment.IsUploadedOn(inst) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "attachment already uploaded",
+		})
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	res := newAttachmentUploadResponse(inst, cipher, attachment, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// UploadAttachment is the handler for uploading the encrypted content of an
+// attachment, sent as multipart/form-data.
+func UploadAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.POST)
+	if cipher == nil {
+		return err
+	}
+	attachment, err := cipher.FindAttachment(c.Param("attachment-id"))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	header, err := c.FormFile("data")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing data",
+		})
+	}
+	// The size has been checked against the quota when the attachment was
+	// created, and the content must have this size.
+	if header.Size != attachment.Size {
+		return attachmentError(c, vfs.ErrContentLengthMismatch)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	defer file.Close()
+	if err := bitwarden.UploadAttachment(inst, attachment, file, header.Size); err != nil {
+		return attachmentError(c, err)
+	}
+	if _, err := saveCipherWithAttachments(inst, cipher); err != nil {
+		return attachmentError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// CreateAttachmentLegacy is the handler for adding an attachment to a cipher,
+// with its metadata and its content in the same multipart/form-data request.
+// It is used by the old clients.
+func CreateAttachmentLegacy(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.POST)
+	if cipher == nil {
+		return err
+	}
+
+	header, err := c.FormFile("data")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing data",
+		})
+	}
+	if err := bitwarden.CheckAttachmentSize(inst, header.Size); err != nil {
+		return attachmentError(c, err)
+	}
+	attachment, err := bitwarden.NewAttachment(header.Filename, c.FormValue("key"), header.Size)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	defer file.Close()
+	if err := bitwarden.UploadAttachment(inst, attachment, file, header.Size); err != nil {
+		return attachmentError(c, err)
+	}
+
+	cipher.Attachments = append(cipher.Attachments, *attachment)
+	setting, err := saveCipherWithAttachments(inst, cipher)
+	if err != nil {
+		_ = bitwarden.DeleteAttachment(inst, attachment)
+		return attachmentError(c, err)
+	}
+	res := newCipherResponse(inst, cipher, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetAttachment returns the informations about an attachment, with a URL to
+// download its encrypted content.
+func GetAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.GET)
+	if cipher == nil {
+		return err
+	}
+	attachment, err := cipher.FindAttachment(c.Param("attachment-id"))
+	if err != nil || !attachment.IsUploadedOn(inst) {
+		return attachmentError(c, bitwarden.ErrAttachmentNotFound)
+	}
+	secret, err := vfs.GetStore().AddFile(inst, attachment.Path())
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	res := newAttachmentResponse(attachment)
+	res.URL = inst.PageURL("/files/downloads/"+secret+"/"+attachment.ID, nil)
+	return c.JSON(http.StatusOK, res)
+}
+
+// ShareAttachment is used to replace the content of an attachment, encrypted
+// with the key of an organization, before the cipher is shared with this
+// organization.
+func ShareAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.PUT)
+	if cipher == nil {
+		return err
+	}
+	if c.QueryParam("organizationId") == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "organizationId not provided",
+		})
+	}
+	attachment, err := cipher.FindAttachment(c.Param("attachment-id"))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	header, err := c.FormFile("data")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing data",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	defer file.Close()
+	if err := bitwarden.UploadAttachment(inst, attachment, file, header.Size); err != nil {
+		return attachmentError(c, err)
+	}
+	if key := c.FormValue("key"); key != "" {
+		attachment.Key = key
+	}
+	if header.Filename != "" {
+		attachment.FileName = header.Filename
+	}
+	if _, err := saveCipherWithAttachments(inst, cipher); err != nil {
+		return attachmentError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// DeleteAttachment is the handler for removing an attachment from a cipher.
+func DeleteAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	cipher, err := getCipherForAttachment(c, permission.DELETE)
+	if cipher == nil {
+		return err
+	}
+	attachment, err := cipher.FindAttachment(c.Param("attachment-id"))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	if err := bitwarden.DeleteAttachment(inst, attachment); err != nil {
+		return attachmentError(c, err)
+	}
+	cipher.RemoveAttachment(attachment.ID)
+	if _, err := saveCipherWithAttachments(inst, cipher); err != nil {
+		return attachmentError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
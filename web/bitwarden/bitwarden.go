@@ -242,7 +242,7 @@ func getInitialCredentials(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if session.NeedsTwoFactor(inst, c.Request()) {
 		if !checkTwoFactor(c, inst) {
 			return nil
 		}
@@ -361,6 +361,40 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 		return true
 	}
 
+	// With an authenticator app, the bitwarden clients can send a passcode
+	// from the authenticator app or a recovery code.
+	if inst.HasTwoFactor() && !inst.HasAuthMode(instance.TwoFactorMail) && len(inst.TOTPSecret) > 0 {
+		token, err := lifecycle.StartTwoFactor(inst)
+		if err != nil {
+			_ = c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+			return false
+		}
+		cache.Set(key, token, 5*time.Minute)
+		_ = c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_grant",
+			"error_description": "Two factor required.",
+			// 0 means authenticator
+			"TwoFactorProviders": []int{0},
+			"TwoFactorProviders2": map[string]map[string]string{
+				"0": {},
+			},
+		})
+		return false
+	}
+
+	// The bitwarden clients cannot use the security keys registered on the
+	// instance, only the passcode sent by mail if the context allows it. For a
+	// login from a new country without 2FA, the passcode is sent by mail.
+	if inst.HasTwoFactor() && !inst.HasTwoFactorMailFallback() {
+		_ = c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_grant",
+			"error_description": "The security keys are not supported by this client.",
+		})
+		return false
+	}
+
 	email, err := inst.SettingsEMail()
 	if err != nil {
 		_ = c.JSON(http.StatusInternalServerError, echo.Map{
@@ -523,6 +557,15 @@ func Routes(router *echo.Group) {
 	ciphers.POST("/:id/share", ShareCipher)
 	ciphers.PUT("/:id/share", ShareCipher)
 
+	ciphers.POST("/:id/attachment", CreateAttachmentLegacy)
+	ciphers.POST("/:id/attachment/v2", CreateAttachment)
+	ciphers.GET("/:id/attachment/:attachment-id", GetAttachment)
+	ciphers.POST("/:id/attachment/:attachment-id", UploadAttachment)
+	ciphers.GET("/:id/attachment/:attachment-id/renew", RenewAttachmentUpload)
+	ciphers.POST("/:id/attachment/:attachment-id/share", ShareAttachment)
+	ciphers.DELETE("/:id/attachment/:attachment-id", DeleteAttachment)
+	ciphers.POST("/:id/attachment/:attachment-id/delete", DeleteAttachment)
+
 	folders := api.Group("/folders")
 	folders.GET("", ListFolders)
 	folders.POST("", CreateFolder)
@@ -532,6 +575,41 @@ func Routes(router *echo.Group) {
 	folders.DELETE("/:id", DeleteFolder)
 	folders.POST("/:id/delete", DeleteFolder)
 
+	sends := api.Group("/sends")
+	sends.GET("", ListSends)
+	sends.POST("", CreateSend)
+	sends.POST("/file", CreateSendFileLegacy)
+	sends.POST("/file/v2", CreateSendFile)
+	sends.GET("/:id", GetSend)
+	sends.PUT("/:id", UpdateSend)
+	sends.PUT("/:id/remove-password", RemoveSendPassword)
+	sends.DELETE("/:id", DeleteSend)
+	sends.GET("/:id/file/:file-id", GetSendFileUpload)
+	sends.POST("/:id/file/:file-id", UploadSendFile)
+	// Anonymous routes for accessing a send
+	sends.POST("/access/:access-id", AccessSend)
+	sends.POST("/:id/access/file/:file-id", AccessSendFile)
+
+	emergency := api.Group("/emergency-access")
+	emergency.GET("/trusted", ListTrustedEmergencyAccesses)
+	emergency.GET("/granted", ListGrantedEmergencyAccesses)
+	emergency.POST("/invite", InviteEmergencyAccess)
+	emergency.GET("/:id", GetEmergencyAccess)
+	emergency.POST("/:id", UpdateEmergencyAccess)
+	emergency.PUT("/:id", UpdateEmergencyAccess)
+	emergency.DELETE("/:id", DeleteEmergencyAccess)
+	emergency.POST("/:id/delete", DeleteEmergencyAccess)
+	emergency.POST("/:id/reinvite", ReinviteEmergencyAccess)
+	emergency.POST("/:id/accept", AcceptEmergencyAccess)
+	emergency.POST("/:id/confirm", ConfirmEmergencyAccess)
+	emergency.POST("/:id/initiate", InitiateEmergencyAccess)
+	emergency.POST("/:id/approve", ApproveEmergencyAccess)
+	emergency.POST("/:id/reject", RejectEmergencyAccess)
+	emergency.POST("/:id/view", ViewEmergencyAccess)
+
+	api.GET("/exports", ListExports)
+	api.POST("/exports", ExportVault)
+
 	orgs := api.Group("/organizations")
 	orgs.POST("", CreateOrganization)
 	orgs.GET("/:id", GetOrganization)
@@ -543,6 +621,16 @@ func Routes(router *echo.Group) {
 	router.GET("/organizations/cozy", GetCozy)
 	router.DELETE("/contacts/:id", RefuseContact)
 
+	// Routes used between the stacks of the grantor and of the grantee
+	remote := router.Group("/emergency-access")
+	remote.POST("", ReceiveEmergencyAccessInvitation)
+	remote.GET("/:id", SendEmergencyAccessState)
+	remote.PUT("/:id", ReceiveEmergencyAccessState)
+	remote.DELETE("/:id", ReceiveEmergencyAccessDeletion)
+	remote.POST("/:id/accept", ReceiveEmergencyAccessAcceptation)
+	remote.POST("/:id/initiate", ReceiveEmergencyAccessInitiation)
+	remote.POST("/:id/view", SendEmergencyAccessView)
+
 	api.GET("/users/:id/public-key", GetPublicKey)
 
 	hub := router.Group("/notifications/hub")
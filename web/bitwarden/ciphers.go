@@ -9,6 +9,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -40,6 +41,13 @@ type cipherRequest struct {
 	SecureNote     bitwarden.MapData    `json:"securenote"`
 	Card           bitwarden.MapData    `json:"card"`
 	Identity       bitwarden.MapData    `json:"identity"`
+
+	// Used to update the encrypted names and keys of the attachments
+	Attachments  map[string]string `json:"attachments"`
+	Attachments2 map[string]struct {
+		FileName string `json:"fileName"`
+		Key      string `json:"key"`
+	} `json:"attachments2"`
 }
 
 func (r *cipherRequest) toCipher() (*bitwarden.Cipher, error) {
@@ -81,6 +89,30 @@ func (r *cipherRequest) toCipher() (*bitwarden.Cipher, error) {
 	return &c, nil
 }
 
+// copyAttachments keeps the attachments of the old cipher on the new one,
+// with the names and keys sent by the client.
+func (r *cipherRequest) copyAttachments(cipher, old *bitwarden.Cipher) {
+	if len(old.Attachments) == 0 {
+		return
+	}
+	cipher.Attachments = make([]bitwarden.Attachment, len(old.Attachments))
+	copy(cipher.Attachments, old.Attachments)
+	for i := range cipher.Attachments {
+		a := &cipher.Attachments[i]
+		if name, ok := r.Attachments[a.ID]; ok && name != "" {
+			a.FileName = name
+		}
+		if v, ok := r.Attachments2[a.ID]; ok {
+			if v.FileName != "" {
+				a.FileName = v.FileName
+			}
+			if v.Key != "" {
+				a.Key = v.Key
+			}
+		}
+	}
+}
+
 type importCipherRequest struct {
 	Ciphers             []cipherRequest `json:"ciphers"`
 	Folders             []folderRequest `json:"folders"`
@@ -121,7 +153,7 @@ type cipherResponse struct {
 	OrganizationID *string                `json:"OrganizationId"`
 	CollectionIDs  []string               `json:"CollectionIds"`
 	Fields         interface{}            `json:"Fields"`
-	Attachments    *string                `json:"Attachments"`
+	Attachments    []*attachmentResponse  `json:"Attachments"`
 	Login          *loginResponse         `json:"Login,omitempty"`
 	SecureNote     map[string]interface{} `json:"SecureNote,omitempty"`
 	Card           map[string]interface{} `json:"Card,omitempty"`
@@ -144,7 +176,7 @@ func titleizeKeys(data bitwarden.MapData) map[string]interface{} {
 	return res
 }
 
-func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
+func newCipherResponse(inst *instance.Instance, c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
 	r := cipherResponse{
 		Object:   "cipher",
 		ID:       c.CouchID,
@@ -188,6 +220,12 @@ func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherR
 		r.Fields = fields
 	}
 
+	for i := range c.Attachments {
+		if a := &c.Attachments[i]; a.IsUploadedOn(inst) {
+			r.Attachments = append(r.Attachments, newAttachmentResponse(a))
+		}
+	}
+
 	switch c.Type {
 	case bitwarden.LoginType:
 		if c.Login != nil {
@@ -260,7 +298,7 @@ func ListCiphers(c echo.Context) error {
 
 	res := &ciphersList{Object: "list"}
 	for _, f := range ciphers {
-		res.Data = append(res.Data, newCipherResponse(f, setting))
+		res.Data = append(res.Data, newCipherResponse(inst, f, setting))
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -311,7 +349,7 @@ func CreateCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -377,7 +415,7 @@ func CreateSharedCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -416,7 +454,7 @@ func GetCipher(c echo.Context) error {
 		})
 	}
 
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -481,6 +519,7 @@ func UpdateCipher(c echo.Context) error {
 		}
 	}
 
+	req.copyAttachments(cipher, old)
 	if old.Metadata != nil {
 		cipher.Metadata = old.Metadata.Clone()
 	}
@@ -501,7 +540,7 @@ func UpdateCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -533,6 +572,11 @@ func DeleteCipher(c echo.Context) error {
 		})
 	}
 
+	if err := bitwarden.DeleteAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
 	if err := couchdb.DeleteDoc(inst, cipher); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": err.Error(),
@@ -669,6 +713,11 @@ func BulkDeleteCiphers(c echo.Context) error {
 	docs := make([]couchdb.Doc, len(ciphers))
 	for i := range ciphers {
 		docs[i] = ciphers[i].Clone()
+		if err := bitwarden.DeleteAttachments(inst, &ciphers[i]); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
 	}
 	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -781,7 +830,7 @@ func BulkRestoreCiphers(c echo.Context) error {
 	res := &ciphersList{Object: "list"}
 	for i := range docs {
 		cipher := docs[i].(*bitwarden.Cipher)
-		res.Data = append(res.Data, newCipherResponse(cipher, setting))
+		res.Data = append(res.Data, newCipherResponse(inst, cipher, setting))
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -867,6 +916,7 @@ func ShareCipher(c echo.Context) error {
 		}
 	}
 
+	req.Cipher.copyAttachments(cipher, old)
 	if old.Metadata != nil {
 		cipher.Metadata = old.Metadata.Clone()
 	}
@@ -880,7 +930,7 @@ func ShareCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
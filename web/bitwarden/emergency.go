@@ -0,0 +1,463 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/emergencyAccessInviteRequest.ts
+type emergencyAccessInviteRequest struct {
+	Email        string                        `json:"email"`
+	Type         bitwarden.EmergencyAccessType `json:"type"`
+	WaitTimeDays int                           `json:"waitTimeDays"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/emergencyAccessConfirmRequest.ts
+type emergencyAccessConfirmRequest struct {
+	Key string `json:"key"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/emergencyAccessResponse.ts
+type emergencyAccessResponse struct {
+	ID           string                          `json:"Id"`
+	GranteeID    string                          `json:"GranteeId,omitempty"`
+	GrantorID    string                          `json:"GrantorId,omitempty"`
+	Name         string                          `json:"Name"`
+	Email        string                          `json:"Email"`
+	Type         bitwarden.EmergencyAccessType   `json:"Type"`
+	Status       bitwarden.EmergencyAccessStatus `json:"Status"`
+	WaitTimeDays int                             `json:"WaitTimeDays"`
+	CreationDate time.Time                       `json:"CreationDate"`
+	Object       string                          `json:"Object"`
+}
+
+func newEmergencyAccessResponse(e *bitwarden.EmergencyAccess) *emergencyAccessResponse {
+	r := &emergencyAccessResponse{
+		ID:           e.ID(),
+		Name:         e.Name,
+		Email:        e.Email,
+		Type:         e.Type,
+		Status:       e.Status,
+		WaitTimeDays: e.WaitTimeDays,
+	}
+	if e.Grantor {
+		r.GranteeID = e.UserID
+		r.Object = "emergencyAccessGranteeDetails"
+	} else {
+		r.GrantorID = e.UserID
+		r.Object = "emergencyAccessGrantorDetails"
+	}
+	if e.Metadata != nil {
+		r.CreationDate = e.Metadata.CreatedAt.UTC()
+	}
+	return r
+}
+
+type emergencyAccessList struct {
+	Data   []*emergencyAccessResponse `json:"Data"`
+	Object string                     `json:"Object"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/emergencyAccessResponse.ts
+type emergencyAccessViewResponse struct {
+	KeyEncrypted string            `json:"KeyEncrypted"`
+	Ciphers      []*cipherResponse `json:"Ciphers"`
+	Object       string            `json:"Object"`
+}
+
+func emergencyAccessError(c echo.Context, err error) error {
+	switch err {
+	case bitwarden.ErrEmergencyAccessNotFound:
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrEmergencyAccessInvalidToken:
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrEmergencyAccessInvalidStatus:
+		return c.JSON(http.StatusConflict, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrEmergencyAccessInvalidType, bitwarden.ErrEmergencyAccessUnknownGrantee,
+		bitwarden.ErrEmergencyAccessUnknownGrantor:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+// getEmergencyAccess checks the permissions and loads the emergency access
+// from the id parameter of the route. The emergency accesses are part of the
+// profile of the user.
+func getEmergencyAccess(c echo.Context, verb permission.Verb) (*bitwarden.EmergencyAccess, error) {
+	if err := middlewares.AllowWholeType(c, verb, consts.BitwardenProfiles); err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+	inst := middlewares.GetInstance(c)
+	e, err := bitwarden.GetEmergencyAccess(inst, c.Param("id"))
+	if err != nil {
+		return nil, emergencyAccessError(c, err)
+	}
+	return e, nil
+}
+
+func listEmergencyAccesses(c echo.Context, grantor bool) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+	list, err := bitwarden.ListEmergencyAccesses(inst, grantor)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+	res := &emergencyAccessList{Object: "list", Data: []*emergencyAccessResponse{}}
+	for _, e := range list {
+		res.Data = append(res.Data, newEmergencyAccessResponse(e))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// ListTrustedEmergencyAccesses is the route for listing the emergency
+// accesses given by the user to other people.
+func ListTrustedEmergencyAccesses(c echo.Context) error {
+	return listEmergencyAccesses(c, true)
+}
+
+// ListGrantedEmergencyAccesses is the route for listing the emergency
+// accesses that other people have given to the user.
+func ListGrantedEmergencyAccesses(c echo.Context) error {
+	return listEmergencyAccesses(c, false)
+}
+
+// GetEmergencyAccess is the route for getting an emergency access.
+func GetEmergencyAccess(c echo.Context) error {
+	e, err := getEmergencyAccess(c, permission.GET)
+	if e == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newEmergencyAccessResponse(e))
+}
+
+// InviteEmergencyAccess is the route used by the grantor to invite a grantee.
+// The grantee must be a contact whose public key is known, i.e. a member of
+// a shared organization.
+func InviteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req emergencyAccessInviteRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.Email == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	e, err := bitwarden.InviteEmergencyAccess(inst, req.Email, req.Type, req.WaitTimeDays)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.JSON(http.StatusOK, newEmergencyAccessResponse(e))
+}
+
+// ReinviteEmergencyAccess is the route used by the grantor to send again the
+// invitation.
+func ReinviteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.SendEmergencyAccessInvitation(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// UpdateEmergencyAccess is the route used by the grantor to change the type
+// or the waiting period of an emergency access.
+func UpdateEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.PUT)
+	if e == nil {
+		return err
+	}
+	var req emergencyAccessInviteRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if err := bitwarden.UpdateEmergencyAccess(inst, e, req.Type, req.WaitTimeDays); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.JSON(http.StatusOK, newEmergencyAccessResponse(e))
+}
+
+// DeleteEmergencyAccess is the route for deleting an emergency access. It can
+// be used by the grantor or by the grantee.
+func DeleteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.DELETE)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.DeleteEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// AcceptEmergencyAccess is the route used by the grantee to accept an
+// invitation.
+func AcceptEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.AcceptEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ConfirmEmergencyAccess is the route used by the grantor to confirm an
+// emergency access, with their key encrypted with the public key of the
+// grantee (see GET /bitwarden/api/users/:id/public-key).
+func ConfirmEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	var req emergencyAccessConfirmRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.Key == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if err := bitwarden.ConfirmEmergencyAccess(inst, e, req.Key); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// InitiateEmergencyAccess is the route used by the grantee to ask for the
+// access to the vault of the grantor.
+func InitiateEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.InitiateEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ApproveEmergencyAccess is the route used by the grantor to approve the
+// recovery without waiting.
+func ApproveEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.ApproveEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// RejectEmergencyAccess is the route used by the grantor to reject the
+// recovery.
+func RejectEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.RejectEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ViewEmergencyAccess is the route used by the grantee to get the key and the
+// ciphers of the grantor, when the recovery has been approved.
+func ViewEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getEmergencyAccess(c, permission.POST)
+	if e == nil {
+		return err
+	}
+	view, err := bitwarden.ViewEmergencyAccess(inst, e)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.JSONBlob(http.StatusOK, view)
+}
+
+// getRemoteEmergencyAccess loads the emergency access for a request made by
+// the other stack, and checks its token.
+func getRemoteEmergencyAccess(c echo.Context) (*bitwarden.EmergencyAccess, error) {
+	inst := middlewares.GetInstance(c)
+	e, err := bitwarden.GetEmergencyAccess(inst, c.Param("id"))
+	if err != nil {
+		return nil, emergencyAccessError(c, err)
+	}
+	if err := e.CheckToken(middlewares.GetRequestToken(c)); err != nil {
+		return nil, emergencyAccessError(c, err)
+	}
+	return e, nil
+}
+
+// ReceiveEmergencyAccessInvitation is the route used by the stack of the
+// grantor to send an invitation to the stack of the grantee.
+func ReceiveEmergencyAccessInvitation(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var invitation bitwarden.EmergencyAccessInvitation
+	if err := json.NewDecoder(c.Request().Body).Decode(&invitation); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	invitation.Token = middlewares.GetRequestToken(c)
+	if _, err := bitwarden.ReceiveEmergencyAccessInvitation(inst, &invitation); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+// SendEmergencyAccessState is the route used by the stack of the grantee to
+// check an invitation with the stack of the grantor.
+func SendEmergencyAccessState(c echo.Context) error {
+	e, err := getRemoteEmergencyAccess(c)
+	if e == nil {
+		return err
+	}
+	if !e.Grantor {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessNotFound)
+	}
+	return c.JSON(http.StatusOK, e.State())
+}
+
+// ReceiveEmergencyAccessState is the route used by the stack of the grantor
+// to send the new state of an emergency access to the stack of the grantee.
+func ReceiveEmergencyAccessState(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getRemoteEmergencyAccess(c)
+	if e == nil {
+		return err
+	}
+	if e.Grantor {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	var state bitwarden.EmergencyAccessState
+	if err := json.NewDecoder(c.Request().Body).Decode(&state); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	e.SetState(&state)
+	if err := couchdb.UpdateDoc(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReceiveEmergencyAccessDeletion is the route used by a stack to delete an
+// emergency access on the stack of the other party.
+func ReceiveEmergencyAccessDeletion(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getRemoteEmergencyAccess(c)
+	if e == nil {
+		return err
+	}
+	if err := couchdb.DeleteDoc(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func receiveEmergencyAccessAction(c echo.Context, action string) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getRemoteEmergencyAccess(c)
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.ReceiveEmergencyAccessAction(inst, e, action); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	return c.JSON(http.StatusOK, e.State())
+}
+
+// ReceiveEmergencyAccessAcceptation is the route used by the stack of the
+// grantee when the grantee has accepted the invitation.
+func ReceiveEmergencyAccessAcceptation(c echo.Context) error {
+	return receiveEmergencyAccessAction(c, "accept")
+}
+
+// ReceiveEmergencyAccessInitiation is the route used by the stack of the
+// grantee when the grantee asks for the access.
+func ReceiveEmergencyAccessInitiation(c echo.Context) error {
+	return receiveEmergencyAccessAction(c, "initiate")
+}
+
+// SendEmergencyAccessView is the route used by the stack of the grantee to get
+// the encrypted key and the personal ciphers of the grantor.
+func SendEmergencyAccessView(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getRemoteEmergencyAccess(c)
+	if e == nil {
+		return err
+	}
+	if !e.Grantor || e.Status != bitwarden.EmergencyAccessRecoveryApproved {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+	var ciphers []*bitwarden.Cipher
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenCiphers, req, &ciphers); err != nil {
+		if !couchdb.IsNoDatabaseError(err) {
+			return emergencyAccessError(c, err)
+		}
+	}
+	res := &emergencyAccessViewResponse{
+		KeyEncrypted: e.KeyEncrypted,
+		Ciphers:      []*cipherResponse{},
+		Object:       "emergencyAccessView",
+	}
+	for _, cipher := range ciphers {
+		if cipher.OrganizationID != "" || cipher.DeletedDate != nil {
+			continue
+		}
+		res.Ciphers = append(res.Ciphers, newCipherResponse(inst, cipher, setting))
+	}
+	return c.JSON(http.StatusOK, res)
+}
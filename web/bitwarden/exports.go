@@ -0,0 +1,103 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type exportRequest struct {
+	EncKeyValidation string `json:"encKeyValidation"`
+}
+
+type exportResponse struct {
+	ID           string    `json:"Id"`
+	Name         string    `json:"Name"`
+	Size         int64     `json:"Size"`
+	CreationDate time.Time `json:"CreationDate"`
+	URL          string    `json:"Url"`
+	Object       string    `json:"Object"`
+}
+
+type exportsList struct {
+	Data   []*exportResponse `json:"Data"`
+	Object string            `json:"Object"`
+}
+
+// ExportVault is the route for asking an encrypted export of the vault. The
+// export is made by a job, and the file can then be found with ListExports.
+func ExportVault(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req exportRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.EncKeyValidation == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "encKeyValidation is mandatory",
+		})
+	}
+	msg, err := job.NewMessage(&bitwarden.ExportMessage{
+		EncKeyValidation: req.EncKeyValidation,
+	})
+	if err != nil {
+		return err
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: bitwarden.ExportWorker,
+		Message:    msg,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"JobId":  j.ID(),
+		"Object": "export-job",
+	})
+}
+
+// ListExports is the route for listing the encrypted exports of the vault,
+// with a URL to download them.
+func ListExports(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	files, err := bitwarden.ListExports(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	res := &exportsList{Object: "list", Data: []*exportResponse{}}
+	for _, f := range files {
+		export := &exportResponse{
+			ID:           f.ID(),
+			Name:         f.DocName,
+			Size:         f.ByteSize,
+			CreationDate: f.CreatedAt.UTC(),
+			Object:       "export",
+		}
+		if secret, err := vfs.GetStore().AddFile(inst, bitwarden.ExportPath(f)); err == nil {
+			export.URL = inst.PageURL("/files/downloads/"+secret+"/"+f.DocName, nil)
+		}
+		res.Data = append(res.Data, export)
+	}
+	return c.JSON(http.StatusOK, res)
+}
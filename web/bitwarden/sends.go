@@ -0,0 +1,622 @@
+package bitwarden
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/sendRequest.ts
+type sendRequest struct {
+	Type           bitwarden.SendType `json:"type"`
+	FileLength     int64              `json:"fileLength"`
+	Name           string             `json:"name"`
+	Notes          string             `json:"notes"`
+	Key            string             `json:"key"`
+	MaxAccessCount *int               `json:"maxAccessCount"`
+	ExpirationDate *time.Time         `json:"expirationDate"`
+	DeletionDate   time.Time          `json:"deletionDate"`
+	Text           *struct {
+		Text   string `json:"text"`
+		Hidden bool   `json:"hidden"`
+	} `json:"text"`
+	File *struct {
+		FileName string `json:"fileName"`
+	} `json:"file"`
+	Password  string `json:"password"`
+	Disabled  bool   `json:"disabled"`
+	HideEmail bool   `json:"hideEmail"`
+}
+
+// toSend creates a new send from the request. For a file, the content is not
+// uploaded yet.
+func (r *sendRequest) toSend() (*bitwarden.Send, error) {
+	send := &bitwarden.Send{Type: r.Type}
+	if r.Type == bitwarden.SendTypeFile && r.File != nil {
+		send.File = &bitwarden.SendFile{
+			ID:       crypto.GenerateRandomString(32),
+			FileName: r.File.FileName,
+			Size:     r.FileLength,
+		}
+	}
+	if err := send.SetPassword(r.Password); err != nil {
+		return nil, err
+	}
+	r.applyTo(send)
+	md := metadata.New()
+	md.DocTypeVersion = bitwarden.DocTypeVersion
+	send.Metadata = md
+	return send, nil
+}
+
+// applyTo copies the fields that can be updated from the request to the send.
+// The type and the file of a send cannot be changed, and the password is
+// changed only if a new one is given.
+func (r *sendRequest) applyTo(send *bitwarden.Send) {
+	send.Name = r.Name
+	send.Notes = r.Notes
+	send.Key = r.Key
+	send.MaxAccessCount = r.MaxAccessCount
+	send.ExpirationDate = r.ExpirationDate
+	send.DeletionDate = r.DeletionDate
+	send.Disabled = r.Disabled
+	send.HideEmail = r.HideEmail
+	if send.Type == bitwarden.SendTypeText {
+		send.Text = nil
+		if r.Text != nil {
+			send.Text = &bitwarden.SendText{
+				Text:   r.Text.Text,
+				Hidden: r.Text.Hidden,
+			}
+		}
+	} else if send.File != nil && r.File != nil && r.File.FileName != "" {
+		send.File.FileName = r.File.FileName
+	}
+	if send.Metadata != nil {
+		send.Metadata.ChangeUpdatedAt()
+	}
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendFileResponse.ts
+type sendFileResponse struct {
+	ID       string `json:"Id"`
+	FileName string `json:"FileName"`
+	Size     string `json:"Size"`
+	SizeName string `json:"SizeName"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendTextResponse.ts
+type sendTextResponse struct {
+	Text   *string `json:"Text"`
+	Hidden bool    `json:"Hidden"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendResponse.ts
+type sendResponse struct {
+	Object         string             `json:"Object"`
+	ID             string             `json:"Id"`
+	AccessID       string             `json:"AccessId"`
+	Type           bitwarden.SendType `json:"Type"`
+	Name           string             `json:"Name"`
+	Notes          *string            `json:"Notes"`
+	File           *sendFileResponse  `json:"File"`
+	Text           *sendTextResponse  `json:"Text"`
+	Key            string             `json:"Key"`
+	MaxAccessCount *int               `json:"MaxAccessCount"`
+	AccessCount    int                `json:"AccessCount"`
+	Password       *string            `json:"Password"`
+	Disabled       bool               `json:"Disabled"`
+	HideEmail      bool               `json:"HideEmail"`
+	RevisionDate   time.Time          `json:"RevisionDate"`
+	ExpirationDate *time.Time         `json:"ExpirationDate"`
+	DeletionDate   time.Time          `json:"DeletionDate"`
+}
+
+func newSendFileResponse(f *bitwarden.SendFile) *sendFileResponse {
+	return &sendFileResponse{
+		ID:       f.ID,
+		FileName: f.FileName,
+		Size:     strconv.FormatInt(f.Size, 10),
+		SizeName: sizeName(f.Size),
+	}
+}
+
+func newSendTextResponse(t *bitwarden.SendText) *sendTextResponse {
+	r := &sendTextResponse{Hidden: t.Hidden}
+	if t.Text != "" {
+		text := t.Text
+		r.Text = &text
+	}
+	return r
+}
+
+func newSendResponse(s *bitwarden.Send) *sendResponse {
+	r := &sendResponse{
+		Object:         "send",
+		ID:             s.ID(),
+		AccessID:       s.AccessID(),
+		Type:           s.Type,
+		Name:           s.Name,
+		Key:            s.Key,
+		MaxAccessCount: s.MaxAccessCount,
+		AccessCount:    s.AccessCount,
+		Disabled:       s.Disabled,
+		HideEmail:      s.HideEmail,
+		DeletionDate:   s.DeletionDate.UTC(),
+	}
+	if s.Notes != "" {
+		notes := s.Notes
+		r.Notes = &notes
+	}
+	if s.File != nil {
+		r.File = newSendFileResponse(s.File)
+	}
+	if s.Text != nil {
+		r.Text = newSendTextResponse(s.Text)
+	}
+	if s.HasPassword() {
+		password := base64.StdEncoding.EncodeToString(s.Password)
+		r.Password = &password
+	}
+	if s.ExpirationDate != nil {
+		date := s.ExpirationDate.UTC()
+		r.ExpirationDate = &date
+	}
+	if s.Metadata != nil {
+		r.RevisionDate = s.Metadata.UpdatedAt.UTC()
+	}
+	return r
+}
+
+type sendsList struct {
+	Data   []*sendResponse `json:"Data"`
+	Object string          `json:"Object"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendFileUploadDataResponse.ts
+type sendFileUploadResponse struct {
+	Object         string        `json:"Object"`
+	URL            string        `json:"Url"`
+	FileUploadType int           `json:"FileUploadType"`
+	SendResponse   *sendResponse `json:"SendResponse"`
+}
+
+func newSendFileUploadResponse(s *bitwarden.Send) *sendFileUploadResponse {
+	return &sendFileUploadResponse{
+		Object:         "send-fileUpload",
+		URL:            fmt.Sprintf("/sends/%s/file/%s", s.ID(), s.File.ID),
+		FileUploadType: fileUploadTypeDirect,
+		SendResponse:   newSendResponse(s),
+	}
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendAccessResponse.ts
+type sendAccessResponse struct {
+	Object         string             `json:"Object"`
+	ID             string             `json:"Id"`
+	Type           bitwarden.SendType `json:"Type"`
+	Name           string             `json:"Name"`
+	File           *sendFileResponse  `json:"File"`
+	Text           *sendTextResponse  `json:"Text"`
+	ExpirationDate *time.Time         `json:"ExpirationDate"`
+	Creator        *string            `json:"CreatorIdentifier"`
+}
+
+func newSendAccessResponse(inst *instance.Instance, s *bitwarden.Send) *sendAccessResponse {
+	r := &sendAccessResponse{
+		Object: "send-access",
+		ID:     s.AccessID(),
+		Type:   s.Type,
+		Name:   s.Name,
+	}
+	if s.File != nil {
+		r.File = newSendFileResponse(s.File)
+	}
+	if s.Text != nil {
+		r.Text = newSendTextResponse(s.Text)
+	}
+	if s.ExpirationDate != nil {
+		date := s.ExpirationDate.UTC()
+		r.ExpirationDate = &date
+	}
+	if !s.HideEmail {
+		email := string(inst.PassphraseSalt())
+		r.Creator = &email
+	}
+	return r
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/response/sendFileDownloadDataResponse.ts
+type sendFileDownloadResponse struct {
+	Object string `json:"Object"`
+	ID     string `json:"Id"`
+	URL    string `json:"Url"`
+}
+
+// https://github.com/bitwarden/jslib/blob/master/common/src/models/request/sendAccessRequest.ts
+type sendAccessRequest struct {
+	Password string `json:"password"`
+}
+
+// getSend checks the permissions and loads the send from the id parameter of
+// the route.
+func getSend(c echo.Context, verb permission.Verb) (*bitwarden.Send, error) {
+	if err := middlewares.AllowWholeType(c, verb, consts.BitwardenSends); err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+	inst := middlewares.GetInstance(c)
+	send, err := bitwarden.GetSend(inst, c.Param("id"))
+	if err != nil {
+		return nil, sendError(c, err)
+	}
+	return send, nil
+}
+
+func sendError(c echo.Context, err error) error {
+	switch err {
+	case bitwarden.ErrSendNotFound:
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrSendPasswordRequired, bitwarden.ErrSendInvalidPassword:
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": err.Error(),
+		})
+	case limits.ErrRateLimitReached, limits.ErrRateLimitExceeded:
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"error": err.Error(),
+		})
+	case bitwarden.ErrSendInvalidDeletionDate, bitwarden.ErrSendInvalidExpirationDate,
+		bitwarden.ErrSendInvalidType, bitwarden.ErrAttachmentTooBig,
+		vfs.ErrContentLengthMismatch:
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+// ListSends is the route for listing the Bitwarden sends.
+func ListSends(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	sends, err := bitwarden.ListSends(inst)
+	if err != nil {
+		return sendError(c, err)
+	}
+	res := &sendsList{Object: "list", Data: []*sendResponse{}}
+	for _, s := range sends {
+		res.Data = append(res.Data, newSendResponse(s))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// CreateSend is the route for creating a text send.
+func CreateSend(c echo.Context) error {
+	return createSend(c, false)
+}
+
+// CreateSendFile is the route for creating a file send. The client will then
+// upload the encrypted content with UploadSendFile.
+func CreateSendFile(c echo.Context) error {
+	return createSend(c, true)
+}
+
+func createSend(c echo.Context, withFile bool) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if withFile != (req.Type == bitwarden.SendTypeFile) {
+		return sendError(c, bitwarden.ErrSendInvalidType)
+	}
+	if withFile {
+		if err := bitwarden.CheckAttachmentSize(inst, req.FileLength); err != nil {
+			return sendError(c, err)
+		}
+	}
+
+	send, err := req.toSend()
+	if err != nil {
+		return sendError(c, err)
+	}
+	if err := send.Validate(); err != nil {
+		return sendError(c, err)
+	}
+	if err := bitwarden.CreateSend(inst, send); err != nil {
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+
+	if withFile {
+		return c.JSON(http.StatusOK, newSendFileUploadResponse(send))
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// CreateSendFileLegacy is the route for creating a file send, with its
+// metadata and its content in the same multipart/form-data request. It is
+// used by the old clients.
+func CreateSendFileLegacy(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenSends); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.Unmarshal([]byte(c.FormValue("model")), &req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	header, err := c.FormFile("data")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing data",
+		})
+	}
+	if req.Type != bitwarden.SendTypeFile {
+		return sendError(c, bitwarden.ErrSendInvalidType)
+	}
+	if req.File == nil {
+		req.File = &struct {
+			FileName string `json:"fileName"`
+		}{FileName: header.Filename}
+	}
+	req.FileLength = header.Size
+	if err := bitwarden.CheckAttachmentSize(inst, header.Size); err != nil {
+		return sendError(c, err)
+	}
+
+	send, err := req.toSend()
+	if err != nil {
+		return sendError(c, err)
+	}
+	if err := send.Validate(); err != nil {
+		return sendError(c, err)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return sendError(c, err)
+	}
+	defer file.Close()
+	if err := bitwarden.UploadSendFile(inst, send, file, header.Size); err != nil {
+		return sendError(c, err)
+	}
+	if err := bitwarden.CreateSend(inst, send); err != nil {
+		_ = bitwarden.DeleteSend(inst, send)
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// GetSendFileUpload is the route used by the clients to get again the upload
+// informations for the file of a send.
+func GetSendFileUpload(c echo.Context) error {
+	send, err := getSend(c, permission.GET)
+	if send == nil {
+		return err
+	}
+	if send.File == nil || send.File.ID != c.Param("file-id") {
+		return sendError(c, bitwarden.ErrSendNotFound)
+	}
+	if send.File.FileID != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "file already uploaded",
+		})
+	}
+	return c.JSON(http.StatusOK, newSendFileUploadResponse(send))
+}
+
+// UploadSendFile is the route for uploading the encrypted content of the file
+// of a send, as multipart/form-data.
+func UploadSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := getSend(c, permission.POST)
+	if send == nil {
+		return err
+	}
+	if send.File == nil || send.File.ID != c.Param("file-id") {
+		return sendError(c, bitwarden.ErrSendNotFound)
+	}
+
+	header, err := c.FormFile("data")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing data",
+		})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return sendError(c, err)
+	}
+	defer file.Close()
+	if err := bitwarden.UploadSendFile(inst, send, file, header.Size); err != nil {
+		return sendError(c, err)
+	}
+	if err := bitwarden.UpdateSend(inst, send); err != nil {
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// GetSend returns information about a single send.
+func GetSend(c echo.Context) error {
+	send, err := getSend(c, permission.GET)
+	if send == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// UpdateSend is the route for changing a send.
+func UpdateSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := getSend(c, permission.PUT)
+	if send == nil {
+		return err
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != send.Type {
+		return sendError(c, bitwarden.ErrSendInvalidType)
+	}
+	req.applyTo(send)
+	if req.Password != "" {
+		if err := send.SetPassword(req.Password); err != nil {
+			return sendError(c, err)
+		}
+	}
+	if err := send.Validate(); err != nil {
+		return sendError(c, err)
+	}
+	if err := bitwarden.UpdateSend(inst, send); err != nil {
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// RemoveSendPassword is the route for removing the password protection of a
+// send.
+func RemoveSendPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := getSend(c, permission.PUT)
+	if send == nil {
+		return err
+	}
+	_ = send.SetPassword("")
+	if send.Metadata != nil {
+		send.Metadata.ChangeUpdatedAt()
+	}
+	if err := bitwarden.UpdateSend(inst, send); err != nil {
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// DeleteSend is the route for deleting a send.
+func DeleteSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := getSend(c, permission.DELETE)
+	if send == nil {
+		return err
+	}
+	if err := bitwarden.DeleteSend(inst, send); err != nil {
+		return sendError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// checkSendAccess checks the password for accessing a send. The routes are
+// not authenticated, and checking a password is costly: the number of
+// attempts is limited for each send.
+func checkSendAccess(inst *instance.Instance, send *bitwarden.Send, password string) error {
+	if send.HasPassword() {
+		key := inst.DBPrefix() + ":" + send.ID()
+		if err := limits.CheckRateLimitKey(key, limits.SendAccessType); err != nil {
+			return err
+		}
+	}
+	return send.CheckAccess(password)
+}
+
+// AccessSend is the route used by anyone with the link to a send to access
+// it. No token is needed, but a password can be asked. For a text, the access
+// count is incremented. For a file, it will be incremented when the client
+// asks for the download URL.
+func AccessSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var req sendAccessRequest
+	_ = json.NewDecoder(c.Request().Body).Decode(&req)
+
+	send, err := bitwarden.GetSend(inst, c.Param("access-id"))
+	if err != nil {
+		return sendError(c, err)
+	}
+	if err := checkSendAccess(inst, send, req.Password); err != nil {
+		return sendError(c, err)
+	}
+	if send.Type == bitwarden.SendTypeText {
+		if err := bitwarden.RecordSendAccess(inst, send); err != nil {
+			return sendError(c, err)
+		}
+	}
+	return c.JSON(http.StatusOK, newSendAccessResponse(inst, send))
+}
+
+// AccessSendFile is the route used by anyone with the link to a file send to
+// get a URL for downloading its encrypted content.
+func AccessSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var req sendAccessRequest
+	_ = json.NewDecoder(c.Request().Body).Decode(&req)
+
+	send, err := bitwarden.GetSend(inst, c.Param("id"))
+	if err != nil {
+		return sendError(c, err)
+	}
+	if send.File == nil || send.File.ID != c.Param("file-id") || send.File.FileID == "" {
+		return sendError(c, bitwarden.ErrSendNotFound)
+	}
+	if err := checkSendAccess(inst, send, req.Password); err != nil {
+		return sendError(c, err)
+	}
+	if err := bitwarden.RecordSendAccess(inst, send); err != nil {
+		return sendError(c, err)
+	}
+
+	secret, err := vfs.GetStore().AddFile(inst, send.FilePath())
+	if err != nil {
+		return sendError(c, err)
+	}
+	return c.JSON(http.StatusOK, &sendFileDownloadResponse{
+		Object: "send-fileDownload",
+		ID:     send.File.ID,
+		URL:    inst.PageURL("/files/downloads/"+secret+"/"+send.File.ID, nil),
+	})
+}
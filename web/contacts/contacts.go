@@ -1,18 +1,26 @@
-// Package contacts exposes a route for the myself document.
+// Package contacts exposes a route for the myself document, the routes for
+// importing and exporting the contacts, and the routes for merging the
+// duplicate contacts.
 package contacts
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
 )
 
+// maxImportSize is the maximal size of a file of contacts to import.
+const maxImportSize = 50 << 20
+
 type apiMyself struct{ *contact.Contact }
 
 func (m *apiMyself) MarshalJSON() ([]byte, error)           { return json.Marshal(m.Contact) }
@@ -20,6 +28,24 @@ func (m *apiMyself) Links() *jsonapi.LinksList              { return &jsonapi.Li
 func (m *apiMyself) Relationships() jsonapi.RelationshipMap { return jsonapi.RelationshipMap{} }
 func (m *apiMyself) Included() []jsonapi.Object             { return []jsonapi.Object{} }
 
+type apiContact struct{ *contact.Contact }
+
+func (c *apiContact) MarshalJSON() ([]byte, error) { return json.Marshal(c.Contact) }
+func (c *apiContact) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/data/" + consts.Contacts + "/" + c.ID()}
+}
+func (c *apiContact) Relationships() jsonapi.RelationshipMap { return jsonapi.RelationshipMap{} }
+func (c *apiContact) Included() []jsonapi.Object             { return []jsonapi.Object{} }
+
+type apiDuplicate struct{ *contact.Duplicate }
+
+func (d *apiDuplicate) MarshalJSON() ([]byte, error) { return json.Marshal(d.Duplicate) }
+func (d *apiDuplicate) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/contacts/duplicates/" + d.ID()}
+}
+func (d *apiDuplicate) Relationships() jsonapi.RelationshipMap { return jsonapi.RelationshipMap{} }
+func (d *apiDuplicate) Included() []jsonapi.Object             { return []jsonapi.Object{} }
+
 // MyselfHandler is the handler for POST /contacts/myself. It returns the
 // information about the io.cozy.contacts document for the owner of this
 // instance, the "myself" contact. If the document does not exist, it is
@@ -45,7 +71,206 @@ func MyselfHandler(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, &apiMyself{myself}, nil)
 }
 
+// Import is the handler for POST /contacts/import. It imports the contacts
+// from a vCard or CSV file (Google Contacts and Outlook formats), and merges
+// the duplicates with the existing contacts.
+func Import(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Contacts); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Contacts); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	groupID := c.QueryParam("Group")
+	if groupID != "" {
+		if _, err := getAddressBook(c, groupID); err != nil {
+			return err
+		}
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize)
+	contacts, err := contact.ReadContacts(body)
+	if err != nil {
+		return wrapError(err)
+	}
+	result, err := contact.Import(inst, contacts, groupID)
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// Export is the handler for GET /contacts/export. It returns the contacts (or
+// the contacts of a group) as a vCard or CSV file. The permissions that
+// restrict the fields of the contacts are refused, as the files have all the
+// fields.
+func Export(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Contacts); err != nil {
+		return err
+	}
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if pdoc.Permissions.HasFieldsRestriction(consts.Contacts) {
+		return middlewares.ErrForbidden
+	}
+	ab, err := getAddressBook(c, c.QueryParam("Group"))
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("Format")
+	if format == "" {
+		format = contact.FormatVCard
+	}
+	var contentType, filename string
+	switch format {
+	case contact.FormatVCard:
+		contentType, filename = "text/vcard; charset=utf-8", "contacts.vcf"
+	case contact.FormatCSV:
+		contentType, filename = "text/csv; charset=utf-8", "contacts.csv"
+	default:
+		return wrapError(contact.ErrUnknownFormat)
+	}
+
+	inst := middlewares.GetInstance(c)
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	res.WriteHeader(http.StatusOK)
+	return ab.Export(inst, res, format, c.QueryParam("Version"))
+}
+
+// ListDuplicates is the handler for GET /contacts/duplicates. It returns the
+// suggestions of duplicate contacts, the pending ones by default.
+func ListDuplicates(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Contacts); err != nil {
+		return err
+	}
+	state := c.QueryParam("State")
+	switch state {
+	case "":
+		state = contact.DuplicatePending
+	case "all":
+		state = ""
+	case contact.DuplicatePending, contact.DuplicateDismissed, contact.DuplicateMerged:
+	default:
+		return jsonapi.InvalidParameter("State", errors.New("Unknown state"))
+	}
+
+	inst := middlewares.GetInstance(c)
+	dups, err := contact.ListDuplicates(inst, state)
+	if err != nil {
+		return err
+	}
+	objs := make([]jsonapi.Object, len(dups))
+	for i, d := range dups {
+		objs[i] = &apiDuplicate{d}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// DetectDuplicates is the handler for POST /contacts/duplicates/detect. It
+// pushes a job for looking for the duplicate contacts without waiting for the
+// trigger.
+func DetectDuplicates(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Contacts); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	msg, err := job.NewMessage(struct{}{})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: contact.DedupWorker,
+		Message:    msg,
+	})
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// DismissDuplicate is the handler for POST /contacts/duplicates/:id/dismiss.
+// It marks a suggestion of duplicates as rejected by the user.
+func DismissDuplicate(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Contacts); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	dup, err := contact.FindDuplicate(inst, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if err := dup.Dismiss(inst); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiDuplicate{dup}, nil)
+}
+
+// Merge is the handler for POST /contacts/merge. It merges some contacts in
+// another contact, the survivor, and rewrites the references to the merged
+// contacts.
+func Merge(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Contacts); err != nil {
+		return err
+	}
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Contacts); err != nil {
+		return err
+	}
+	var body struct {
+		SurvivorID string   `json:"survivor_id"`
+		MergedIDs  []string `json:"merged_ids"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return jsonapi.BadJSON()
+	}
+
+	inst := middlewares.GetInstance(c)
+	survivor, err := contact.MergeContacts(inst, body.SurvivorID, body.MergedIDs)
+	if err != nil {
+		return wrapError(err)
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiContact{survivor}, nil)
+}
+
+// getAddressBook returns the address book for the given group, or the default
+// address book if the group is empty.
+func getAddressBook(c echo.Context, groupID string) (*contact.AddressBook, error) {
+	if groupID == "" {
+		groupID = contact.DefaultAddressBook
+	} else if err := middlewares.AllowWholeType(c, permission.GET, consts.ContactsGroups); err != nil {
+		return nil, err
+	}
+	inst := middlewares.GetInstance(c)
+	ab, err := contact.GetAddressBook(inst, groupID)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return ab, nil
+}
+
+func wrapError(err error) error {
+	switch err {
+	case contact.ErrAddressBookNotFound, contact.ErrNotFound:
+		return jsonapi.NotFound(err)
+	case contact.ErrInvalidVCard, contact.ErrInvalidCSV, contact.ErrUnknownFormat,
+		contact.ErrInvalidMerge:
+		return jsonapi.BadRequest(err)
+	}
+	return err
+}
+
 // Routes sets the routing for the contacts.
 func Routes(router *echo.Group) {
 	router.POST("/myself", MyselfHandler)
+	router.POST("/import", Import)
+	router.GET("/export", Export)
+	router.GET("/duplicates", ListDuplicates)
+	router.POST("/duplicates/detect", DetectDuplicates)
+	router.POST("/duplicates/:id/dismiss", DismissDuplicate)
+	router.POST("/merge", Merge)
 }
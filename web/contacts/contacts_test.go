@@ -2,14 +2,17 @@ package contacts
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -20,6 +23,7 @@ import (
 var ts *httptest.Server
 var testInstance *instance.Instance
 var token string
+var restrictedToken string
 
 func assertMyself(t *testing.T, res *http.Response) {
 	assert.Equal(t, 200, res.StatusCode)
@@ -59,6 +63,143 @@ func TestMyself(t *testing.T) {
 	assertMyself(t, res2)
 }
 
+func TestImportExport(t *testing.T) {
+	vcards := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Bob\r\nEMAIL:bob@example.com\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Robert\r\nEMAIL:BOB@example.com\r\nTEL:+33 6 12 34 56 78\r\nEND:VCARD\r\n"
+	req, _ := http.NewRequest("POST", ts.URL+"/contacts/import", strings.NewReader(vcards))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "text/vcard")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result contact.ImportResult
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 1, result.Merged)
+
+	csv := "Given Name,Family Name,Phone 1 - Type,Phone 1 - Value\n" +
+		"Bob,,Mobile,06 12 34 56 78\n" +
+		"Carol,Smith,Work,+44 20 7946 0958\n"
+	req, _ = http.NewRequest("POST", ts.URL+"/contacts/import", strings.NewReader(csv))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "text/csv")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, 1, result.Merged)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/contacts/export?Format=csv", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Disposition"), "contacts.csv")
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Contains(t, string(body), "bob@example.com")
+	assert.Contains(t, string(body), "Carol")
+
+	req, _ = http.NewRequest("GET", ts.URL+"/contacts/export?Format=xml", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+
+	// The fields of the contacts can't be filtered in the exported files
+	req, _ = http.NewRequest("GET", ts.URL+"/contacts/export", nil)
+	req.Header.Add("Authorization", "Bearer "+restrictedToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+}
+
+func TestDuplicatesAndMerge(t *testing.T) {
+	dave := contact.New()
+	dave.M["fullname"] = "Dave Jones"
+	dave.M["email"] = []interface{}{
+		map[string]interface{}{"address": "dave@example.com"},
+	}
+	assert.NoError(t, couchdb.CreateDoc(testInstance, dave))
+	other := contact.New()
+	other.M["fullname"] = "Dave Jonnes"
+	other.M["company"] = "ACME"
+	other.M["email"] = []interface{}{
+		map[string]interface{}{"address": "dave@acme.example.com"},
+	}
+	assert.NoError(t, couchdb.CreateDoc(testInstance, other))
+
+	fs := testInstance.VFS()
+	dir, err := vfs.NewDirDocWithPath("Dave", consts.RootDirID, "/", nil)
+	assert.NoError(t, err)
+	dir.AddReferencedBy(couchdb.DocReference{Type: consts.Contacts, ID: other.ID()})
+	assert.NoError(t, fs.CreateDir(dir))
+
+	_, err = contact.DetectDuplicates(testInstance)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", ts.URL+"/contacts/duplicates", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result struct {
+		Data []struct {
+			ID         string `json:"id"`
+			Attributes struct {
+				ContactIDs []string `json:"contact_ids"`
+				Reasons    []string `json:"reasons"`
+				State      string   `json:"state"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	found := false
+	for _, d := range result.Data {
+		ids := d.Attributes.ContactIDs
+		if len(ids) != 2 || (ids[0] != dave.ID() && ids[1] != dave.ID()) {
+			continue
+		}
+		found = true
+		assert.ElementsMatch(t, []string{dave.ID(), other.ID()}, ids)
+		assert.Equal(t, []string{"name"}, d.Attributes.Reasons)
+		assert.Equal(t, "pending", d.Attributes.State)
+	}
+	assert.True(t, found)
+
+	body := `{"survivor_id": "` + dave.ID() + `", "merged_ids": ["` + dave.ID() + `"]}`
+	req, _ = http.NewRequest("POST", ts.URL+"/contacts/merge", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+
+	body = `{"survivor_id": "` + dave.ID() + `", "merged_ids": ["` + other.ID() + `"]}`
+	req, _ = http.NewRequest("POST", ts.URL+"/contacts/merge", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	survivor, err := contact.Find(testInstance, dave.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, "Dave Jones", survivor.PrimaryName())
+	assert.Equal(t, "ACME", survivor.M["company"])
+	assert.Len(t, survivor.M["email"], 2)
+	_, err = contact.Find(testInstance, other.ID())
+	assert.True(t, couchdb.IsNotFoundError(err))
+
+	dir, err = fs.DirByID(dir.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, []couchdb.DocReference{{Type: consts.Contacts, ID: dave.ID()}}, dir.ReferencedBy)
+
+	dups, err := contact.ListDuplicates(testInstance, contact.DuplicateMerged)
+	assert.NoError(t, err)
+	assert.Len(t, dups, 1)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
@@ -68,6 +209,7 @@ func TestMain(m *testing.M) {
 		PublicName: "Alice",
 	})
 	_, token = setup.GetTestClient(consts.Contacts)
+	_, restrictedToken = setup.GetTestClient("io.cozy.contacts:GET:::fullname")
 	ts = setup.GetTestServer("/contacts", Routes)
 	os.Exit(setup.Run())
 }
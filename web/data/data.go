@@ -87,6 +87,9 @@ func getDoc(c echo.Context) error {
 	}
 
 	if paramIsTrue(c, "revs") {
+		if err := forbidRestrictedFields(c, doctype); err != nil {
+			return err
+		}
 		return proxy(c, docid)
 	}
 
@@ -102,6 +105,14 @@ func getDoc(c echo.Context) error {
 		return err
 	}
 
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if p := pdoc.Permissions.Projection(&out); p != nil {
+		out.M = p.Apply(out.M)
+	}
+
 	return c.JSON(http.StatusOK, out.ToMapWithType())
 }
 
@@ -328,6 +339,14 @@ func findDocuments(c echo.Context) error {
 		return err
 	}
 
+	projection, err := projectionForType(c, doctype)
+	if err != nil {
+		return err
+	}
+	if err := checkFindRequest(projection, findRequest); err != nil {
+		return err
+	}
+
 	limit, hasLimit := findRequest["limit"].(float64)
 	if !hasLimit || limit > consts.MaxItemsPerPageForMango {
 		limit = 100
@@ -339,6 +358,11 @@ func findDocuments(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	if projection != nil {
+		for i := range results {
+			results[i].M = projection.Apply(results[i].M)
+		}
+	}
 	// There might be more docs next when the returned docs reached the limit
 	next := len(results) >= int(limit)
 	out := echo.Map{
@@ -361,6 +385,12 @@ func allDocs(c echo.Context) error {
 	if err := middlewares.AllowWholeType(c, permission.GET, doctype); err != nil {
 		return err
 	}
+	// include_docs can also be given in the body for the POST requests
+	if paramIsTrue(c, "include_docs") || c.Request().Method == http.MethodPost {
+		if err := forbidRestrictedFields(c, doctype); err != nil {
+			return err
+		}
+	}
 	return proxy(c, "_all_docs")
 }
 
@@ -390,6 +420,13 @@ func normalDocs(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	projection, err := projectionForType(c, doctype)
+	if err != nil {
+		return err
+	}
+	if err := projectRawDocs(projection, res.Rows); err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, res)
 }
 
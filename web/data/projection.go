@@ -0,0 +1,76 @@
+package data
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// projectionForType returns the fields that the request can read on all the
+// documents of the doctype, or nil if there is no restriction.
+func projectionForType(c echo.Context, doctype string) (*permission.Projection, error) {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return nil, err
+	}
+	return pdoc.Permissions.ProjectionForType(doctype), nil
+}
+
+// forbidRestrictedFields returns an error if the permissions of the request
+// restrict the fields of the documents of this doctype. It is used for the
+// routes that are proxied to CouchDB, where the documents can't be filtered.
+func forbidRestrictedFields(c echo.Context, doctype string) error {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if pdoc.Permissions.HasFieldsRestriction(doctype) {
+		return middlewares.ErrForbidden
+	}
+	return nil
+}
+
+// checkFindRequest returns an error if the mango query uses a field that
+// can't be read, as the results would leak its value.
+func checkFindRequest(p *permission.Projection, req map[string]interface{}) error {
+	if !p.CanReadQuery(req) {
+		return middlewares.ErrForbidden
+	}
+	return nil
+}
+
+// projectRawDocs removes the fields that can't be read from the JSON
+// documents.
+func projectRawDocs(p *permission.Projection, docs []json.RawMessage) error {
+	if p == nil {
+		return nil
+	}
+	for i, raw := range docs {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		projected, err := json.Marshal(p.Apply(doc))
+		if err != nil {
+			return err
+		}
+		docs[i] = projected
+	}
+	return nil
+}
+
+// projectChanges removes the fields that can't be read from the documents
+// included in the changes feed.
+func projectChanges(p *permission.Projection, res *couchdb.ChangesResponse) {
+	if p == nil {
+		return
+	}
+	for i := range res.Results {
+		if res.Results[i].Doc.M != nil {
+			res.Results[i].Doc.M = p.Apply(res.Results[i].Doc.M)
+		}
+	}
+}
@@ -68,6 +68,10 @@ func bulkGet(c echo.Context) error {
 		return err
 	}
 
+	if err := forbidRestrictedFields(c, doctype); err != nil {
+		return err
+	}
+
 	return proxy(c, "_bulk_get")
 }
 
@@ -240,6 +244,14 @@ func changesFeed(c echo.Context) error {
 		}
 	}
 
+	if includeDocs {
+		projection, err := projectionForType(c, doctype)
+		if err != nil {
+			return err
+		}
+		projectChanges(projection, results)
+	}
+
 	return c.JSON(http.StatusOK, results)
 }
 
@@ -0,0 +1,451 @@
+package dav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// icalExt is the extension of the iCalendar resources in the calendars.
+const icalExt = ".ics"
+
+// timeRangeFormat is the format of the start and end attributes of the
+// time-range elements.
+const timeRangeFormat = "20060102T150405Z"
+
+var (
+	reportCalendarMultiget = xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}
+	reportCalendarQuery    = xml.Name{Space: nsCalDAV, Local: "calendar-query"}
+	reportFreeBusy         = xml.Name{Space: nsCalDAV, Local: "free-busy-query"}
+)
+
+func calendarHref(cal string) string {
+	return calendarsPath + url.PathEscape(cal) + "/"
+}
+
+func icalHref(cal, id string) string {
+	return calendarHref(cal) + url.PathEscape(id) + icalExt
+}
+
+func itemETag(item *calendar.Item) string {
+	return `"` + item.Rev() + `"`
+}
+
+func calendarProps(cal *calendar.Calendar, token string) []property {
+	return []property{
+		resourceType(collectionType(), newProp(nsCalDAV, "calendar", "")),
+		newProp(nsDAV, "displayname", cal.Name),
+		hrefProp(nsDAV, "current-user-principal", principalPath),
+		hrefProp(nsDAV, "owner", principalPath),
+		newProp(nsCalendarServer, "getctag", token),
+		newProp(nsDAV, "sync-token", token),
+		supportedReports(reportCalendarMultiget, reportCalendarQuery, reportSync, reportFreeBusy),
+		newProp(nsCalDAV, "supported-calendar-component-set", "",
+			property{
+				XMLName: xml.Name{Space: nsCalDAV, Local: "comp"},
+				Attrs:   []xml.Attr{{Name: xml.Name{Local: "name"}, Value: cal.Component}},
+			},
+		),
+		newProp(nsCalDAV, "supported-calendar-data", "",
+			property{
+				XMLName: xml.Name{Space: nsCalDAV, Local: "calendar-data"},
+				Attrs: []xml.Attr{
+					{Name: xml.Name{Local: "content-type"}, Value: "text/calendar"},
+					{Name: xml.Name{Local: "version"}, Value: "2.0"},
+				},
+			},
+		),
+		newProp(nsCalDAV, "max-resource-size", "10485760"),
+		privilegeSet("read", "write", "write-content", "bind", "unbind"),
+	}
+}
+
+// icalProps returns the properties of an iCalendar resource. The
+// calendar-data is only included if it has been requested.
+func icalProps(cal *calendar.Calendar, item *calendar.Item, withData bool) []property {
+	props := []property{
+		resourceType(),
+		newProp(nsDAV, "getetag", itemETag(item)),
+		newProp(nsDAV, "getcontenttype", "text/calendar; charset=utf-8"),
+	}
+	if meta, ok := item.Get("cozyMetadata").(map[string]interface{}); ok {
+		if updatedAt, ok := meta["updatedAt"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+				props = append(props, newProp(nsDAV, "getlastmodified", t.UTC().Format(http.TimeFormat)))
+			}
+		}
+	}
+	if withData {
+		ics := item.ICalendar(cal).Bytes()
+		props = append(props, newProp(nsCalDAV, "calendar-data", string(ics)))
+	}
+	return props
+}
+
+func icalResponse(cal *calendar.Calendar, item *calendar.Item, p *prop) response {
+	withData := findRequested(p, nsCalDAV, "calendar-data") != nil
+	return propResponse(icalHref(cal.ID, item.ID()), icalProps(cal, item, withData), requestedProps(p))
+}
+
+// timeRange parses the start and end attributes of a time-range element. The
+// missing bounds are replaced by dates far in the past or in the future.
+func timeRange(tr *property) (time.Time, time.Time, error) {
+	from := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, attr := range tr.Attrs {
+		t, err := time.Parse(timeRangeFormat, attr.Value)
+		switch attr.Name.Local {
+		case "start":
+			if err != nil {
+				return from, to, err
+			}
+			from = t
+		case "end":
+			if err != nil {
+				return from, to, err
+			}
+			to = t
+		}
+	}
+	return from, to, nil
+}
+
+// queryFilter returns the component and the time-range asked in the filter of
+// a calendar-query report. The other filters (on the properties and on the
+// parameters) are not supported, and the client has to apply them.
+func queryFilter(filter *property) (string, *property) {
+	if filter == nil {
+		return "", nil
+	}
+	var comp string
+	var tr *property
+	var walk func(p *property)
+	walk = func(p *property) {
+		for i, child := range p.Children {
+			if child.XMLName.Space != nsCalDAV {
+				continue
+			}
+			switch child.XMLName.Local {
+			case "comp-filter":
+				for _, attr := range child.Attrs {
+					if attr.Name.Local == "name" && attr.Value != "VCALENDAR" {
+						comp = attr.Value
+					}
+				}
+				walk(&p.Children[i])
+			case "time-range":
+				if tr == nil {
+					tr = &p.Children[i]
+				}
+			}
+		}
+	}
+	walk(filter)
+	return comp, tr
+}
+
+// PropfindCalendars returns the properties of the home collection of the
+// calendars, and of the calendars if the depth is not 0.
+func PropfindCalendars(c echo.Context) error {
+	if err := checkAuth(c); err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	requested := requestedProps(pf.Prop)
+	available := []property{
+		resourceType(collectionType()),
+		newProp(nsDAV, "displayname", "Calendars"),
+		hrefProp(nsDAV, "current-user-principal", principalPath),
+		hrefProp(nsDAV, "owner", principalPath),
+	}
+	ms := &multistatus{}
+	ms.Responses = append(ms.Responses, propResponse(calendarsPath, available, requested))
+	if depth(c) > 0 {
+		for _, cal := range calendar.ListCalendars() {
+			// Only the calendars that can be read with this token are listed
+			if allowWholeType(c, permission.GET, cal.DocType) != nil {
+				continue
+			}
+			token, err := cal.SyncToken(inst)
+			if err != nil {
+				return wrapError(err)
+			}
+			props := calendarProps(cal, syncTokenFor(token))
+			ms.Responses = append(ms.Responses, propResponse(calendarHref(cal.ID), props, requested))
+		}
+	}
+	return sendMultistatus(c, ms)
+}
+
+// PropfindCalendar returns the properties of a calendar, and of its events or
+// tasks if the depth is not 0.
+func PropfindCalendar(c echo.Context) error {
+	cal, err := getCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	token, err := cal.SyncToken(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	ms := &multistatus{}
+	props := calendarProps(cal, syncTokenFor(token))
+	ms.Responses = append(ms.Responses, propResponse(calendarHref(cal.ID), props, requestedProps(pf.Prop)))
+	if depth(c) > 0 {
+		items, err := cal.ListItems(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+		for _, item := range items {
+			ms.Responses = append(ms.Responses, icalResponse(cal, item, pf.Prop))
+		}
+	}
+	return sendMultistatus(c, ms)
+}
+
+// ReportCalendar handles the calendar-multiget, calendar-query,
+// sync-collection, and free-busy-query reports on a calendar.
+func ReportCalendar(c echo.Context) error {
+	cal, err := getCalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	var r report
+	if err := readXML(c, &r); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	inst := middlewares.GetInstance(c)
+	ms := &multistatus{}
+
+	switch r.XMLName {
+	case reportCalendarMultiget:
+		for _, href := range r.Hrefs {
+			ms.Responses = append(ms.Responses, calendarMultigetResponse(inst, cal, href, r.Prop))
+		}
+
+	case reportCalendarQuery:
+		comp, tr := queryFilter(r.Filter)
+		if comp != "" && comp != cal.Component {
+			break
+		}
+		var from, to time.Time
+		if tr != nil {
+			if from, to, err = timeRange(tr); err != nil {
+				return sendPreconditionError(c, http.StatusForbidden, nsCalDAV, "valid-filter")
+			}
+		}
+		items, err := cal.ListItems(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+		for _, item := range items {
+			if tr != nil && !item.InTimeRange(from, to) {
+				continue
+			}
+			ms.Responses = append(ms.Responses, icalResponse(cal, item, r.Prop))
+		}
+
+	case reportSync:
+		since, err := parseSyncToken(r.SyncToken)
+		if err != nil {
+			return sendPreconditionError(c, http.StatusForbidden, nsDAV, "valid-sync-token")
+		}
+		changes, err := cal.Changes(inst, since)
+		if err != nil {
+			if err == calendar.ErrInvalidSyncToken {
+				return sendPreconditionError(c, http.StatusForbidden, nsDAV, "valid-sync-token")
+			}
+			return wrapError(err)
+		}
+		for _, item := range changes.Updated {
+			ms.Responses = append(ms.Responses, icalResponse(cal, item, r.Prop))
+		}
+		for _, id := range changes.Removed {
+			ms.Responses = append(ms.Responses, statusResponse(icalHref(cal.ID, id), http.StatusNotFound))
+		}
+		ms.SyncToken = syncTokenFor(changes.SyncToken)
+
+	case reportFreeBusy:
+		if r.TimeRange == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "The time-range is missing")
+		}
+		from, to, err := timeRange(r.TimeRange)
+		if err != nil || !to.After(from) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid time-range")
+		}
+		// The tasks don't make the user busy
+		var periods []calendar.Period
+		if cal.ID == calendar.EventsCalendar {
+			periods, err = calendar.FreeBusy(inst, from, to)
+			if err != nil {
+				return wrapError(err)
+			}
+		}
+		fb := calendar.FreeBusyCalendar(from, to, periods)
+		return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", fb.Bytes())
+
+	default:
+		return sendPreconditionError(c, http.StatusForbidden, nsDAV, "supported-report")
+	}
+
+	return sendMultistatus(c, ms)
+}
+
+func calendarMultigetResponse(inst *instance.Instance, cal *calendar.Calendar, href string, p *prop) response {
+	u, err := url.Parse(href)
+	if err != nil {
+		return statusResponse(href, http.StatusBadRequest)
+	}
+	dir, file := path.Split(u.Path)
+	if dir != calendarsPath+cal.ID+"/" {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	id, ok := idFromFile(file, icalExt)
+	if !ok {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	item, err := cal.GetItem(inst, id)
+	if err != nil {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	return icalResponse(cal, item, p)
+}
+
+// PropfindICalendar returns the properties of an event or a task.
+func PropfindICalendar(c echo.Context) error {
+	cal, item, err := getICalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	ms := &multistatus{}
+	ms.Responses = append(ms.Responses, icalResponse(cal, item, pf.Prop))
+	return sendMultistatus(c, ms)
+}
+
+// GetICalendar returns an event or a task in the iCalendar format.
+func GetICalendar(c echo.Context) error {
+	cal, item, err := getICalendar(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("ETag", itemETag(item))
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", item.ICalendar(cal).Bytes())
+}
+
+// PutICalendar creates or updates an event or a task from an iCalendar
+// stream.
+func PutICalendar(c echo.Context) error {
+	cal, err := getCalendar(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+	id, ok := idFromFile(c.Param("file"), icalExt)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid resource name")
+	}
+	inst := middlewares.GetInstance(c)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxRequestSize))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+
+	item, err := cal.GetItem(inst, id)
+	exists := err == nil
+	if err != nil {
+		if err != calendar.ErrItemNotFound {
+			return wrapError(err)
+		}
+		item = calendar.NewItem(cal, id)
+	}
+	if err := checkPreconditions(c, itemETag(item), exists); err != nil {
+		return err
+	}
+	if !exists {
+		if err := allowWholeType(c, permission.POST, cal.DocType); err != nil {
+			return err
+		}
+	}
+	if err := cal.SaveItem(inst, item, body); err != nil {
+		switch err {
+		case calendar.ErrInvalidICalendar:
+			return sendPreconditionError(c, http.StatusBadRequest, nsCalDAV, "valid-calendar-data")
+		case calendar.ErrInvalidComponent:
+			return sendPreconditionError(c, http.StatusForbidden, nsCalDAV, "supported-calendar-component")
+		}
+		return wrapError(err)
+	}
+	c.Response().Header().Set("ETag", itemETag(item))
+	if exists {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+// DeleteICalendar deletes an event or a task.
+func DeleteICalendar(c echo.Context) error {
+	cal, item, err := getICalendar(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	if err := checkPreconditions(c, itemETag(item), true); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := cal.DeleteItem(inst, item); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getCalendar(c echo.Context, v permission.Verb) (*calendar.Calendar, error) {
+	if err := checkAuth(c); err != nil {
+		return nil, err
+	}
+	cal, err := calendar.GetCalendar(c.Param("cal"))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if err := allowWholeType(c, v, cal.DocType); err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+func getICalendar(c echo.Context, v permission.Verb) (*calendar.Calendar, *calendar.Item, error) {
+	cal, err := getCalendar(c, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, ok := idFromFile(c.Param("file"), icalExt)
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	inst := middlewares.GetInstance(c)
+	item, err := cal.GetItem(inst, id)
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+	return cal, item, nil
+}
@@ -0,0 +1,468 @@
+package dav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// vcardExt is the extension of the vCard resources in the address books.
+const vcardExt = ".vcf"
+
+// syncTokenPrefix is used to make the sync tokens valid URIs, as asked by
+// RFC 6578.
+const syncTokenPrefix = "https://cozy.io/ns/sync/"
+
+var (
+	reportMultiget = xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}
+	reportQuery    = xml.Name{Space: nsCardDAV, Local: "addressbook-query"}
+	reportSync     = xml.Name{Space: nsDAV, Local: "sync-collection"}
+)
+
+func addressBookHref(book string) string {
+	return addressBooksPath + url.PathEscape(book) + "/"
+}
+
+func vcardHref(book, id string) string {
+	return addressBookHref(book) + url.PathEscape(id) + vcardExt
+}
+
+func syncTokenFor(seq string) string {
+	return syncTokenPrefix + url.PathEscape(seq)
+}
+
+func parseSyncToken(token string) (string, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return "", contact.ErrInvalidSyncToken
+	}
+	seq, err := url.PathUnescape(strings.TrimPrefix(token, syncTokenPrefix))
+	if err != nil || seq == "" {
+		return "", contact.ErrInvalidSyncToken
+	}
+	return seq, nil
+}
+
+// idFromFile returns the identifier of the document for the name of a vCard
+// or iCalendar resource. The documents created via CardDAV or CalDAV use the
+// name chosen by the client as identifier.
+func idFromFile(file, ext string) (string, bool) {
+	if !strings.HasSuffix(file, ext) {
+		return "", false
+	}
+	id := strings.TrimSuffix(file, ext)
+	if id == "" || strings.HasPrefix(id, "_") {
+		return "", false
+	}
+	for _, r := range id {
+		valid := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.'
+		if !valid {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+func contactETag(c *contact.Contact) string {
+	return `"` + c.Rev() + `"`
+}
+
+func canReadGroups(c echo.Context) bool {
+	return allowWholeType(c, permission.GET, consts.ContactsGroups) == nil
+}
+
+// vcardVersion returns the version of vCard asked by the client, via the
+// Accept header or the version attribute of the address-data property.
+func vcardVersion(accept string, requested *property) string {
+	if requested != nil {
+		for _, attr := range requested.Attrs {
+			if attr.Name.Local == "version" {
+				accept = "version=" + attr.Value
+			}
+		}
+	}
+	if strings.Contains(accept, "version=4.0") {
+		return contact.VCardVersion4
+	}
+	return contact.VCardVersion3
+}
+
+func addressBookProps(ab *contact.AddressBook, token string) []property {
+	return []property{
+		resourceType(collectionType(), newProp(nsCardDAV, "addressbook", "")),
+		newProp(nsDAV, "displayname", ab.Name),
+		hrefProp(nsDAV, "current-user-principal", principalPath),
+		hrefProp(nsDAV, "owner", principalPath),
+		newProp(nsCalendarServer, "getctag", token),
+		newProp(nsDAV, "sync-token", token),
+		supportedReports(reportMultiget, reportQuery, reportSync),
+		newProp(nsCardDAV, "supported-address-data", "",
+			property{
+				XMLName: xml.Name{Space: nsCardDAV, Local: "address-data-type"},
+				Attrs: []xml.Attr{
+					{Name: xml.Name{Local: "content-type"}, Value: "text/vcard"},
+					{Name: xml.Name{Local: "version"}, Value: contact.VCardVersion3},
+				},
+			},
+			property{
+				XMLName: xml.Name{Space: nsCardDAV, Local: "address-data-type"},
+				Attrs: []xml.Attr{
+					{Name: xml.Name{Local: "content-type"}, Value: "text/vcard"},
+					{Name: xml.Name{Local: "version"}, Value: contact.VCardVersion4},
+				},
+			},
+		),
+		newProp(nsCardDAV, "max-resource-size", "10485760"),
+		privilegeSet("read", "write", "write-content", "bind", "unbind"),
+	}
+}
+
+// vcardProps returns the properties of a vCard resource. The address-data is
+// only included if it has been requested.
+func vcardProps(c *contact.Contact, addressData *property) []property {
+	props := []property{
+		resourceType(),
+		newProp(nsDAV, "getetag", contactETag(c)),
+		newProp(nsDAV, "getcontenttype", "text/vcard; charset=utf-8"),
+	}
+	if meta, ok := c.Get("cozyMetadata").(map[string]interface{}); ok {
+		if updatedAt, ok := meta["updatedAt"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+				props = append(props, newProp(nsDAV, "getlastmodified", t.UTC().Format(http.TimeFormat)))
+			}
+		}
+	}
+	if addressData != nil {
+		card := c.ToVCard(vcardVersion("", addressData))
+		props = append(props, newProp(nsCardDAV, "address-data", string(card.Bytes())))
+	}
+	return props
+}
+
+func vcardResponse(book string, c *contact.Contact, p *prop) response {
+	addressData := findRequested(p, nsCardDAV, "address-data")
+	return propResponse(vcardHref(book, c.ID()), vcardProps(c, addressData), requestedProps(p))
+}
+
+// PropfindAddressBooks returns the properties of the home collection of the
+// address books, and of the address books if the depth is not 0.
+func PropfindAddressBooks(c echo.Context) error {
+	if err := allow(c, permission.GET, consts.Contacts); err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	requested := requestedProps(pf.Prop)
+	available := []property{
+		resourceType(collectionType()),
+		newProp(nsDAV, "displayname", "Contacts"),
+		hrefProp(nsDAV, "current-user-principal", principalPath),
+		hrefProp(nsDAV, "owner", principalPath),
+	}
+	ms := &multistatus{}
+	ms.Responses = append(ms.Responses, propResponse(addressBooksPath, available, requested))
+	if depth(c) > 0 {
+		books, err := contact.ListAddressBooks(inst, canReadGroups(c))
+		if err != nil {
+			return wrapError(err)
+		}
+		seq, err := contact.SyncToken(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+		token := syncTokenFor(seq)
+		for _, ab := range books {
+			props := addressBookProps(ab, token)
+			ms.Responses = append(ms.Responses, propResponse(addressBookHref(ab.ID), props, requested))
+		}
+	}
+	return sendMultistatus(c, ms)
+}
+
+// PropfindAddressBook returns the properties of an address book, and of its
+// vCards if the depth is not 0.
+func PropfindAddressBook(c echo.Context) error {
+	ab, err := getAddressBook(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	seq, err := contact.SyncToken(inst)
+	if err != nil {
+		return wrapError(err)
+	}
+	ms := &multistatus{}
+	props := addressBookProps(ab, syncTokenFor(seq))
+	ms.Responses = append(ms.Responses, propResponse(addressBookHref(ab.ID), props, requestedProps(pf.Prop)))
+	if depth(c) > 0 {
+		contacts, err := ab.ListContacts(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+		for _, doc := range contacts {
+			ms.Responses = append(ms.Responses, vcardResponse(ab.ID, doc, pf.Prop))
+		}
+	}
+	return sendMultistatus(c, ms)
+}
+
+// ReportAddressBook handles the addressbook-multiget, addressbook-query, and
+// sync-collection reports on an address book.
+func ReportAddressBook(c echo.Context) error {
+	ab, err := getAddressBook(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	var r report
+	if err := readXML(c, &r); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	inst := middlewares.GetInstance(c)
+	ms := &multistatus{}
+
+	switch r.XMLName {
+	case reportMultiget:
+		for _, href := range r.Hrefs {
+			ms.Responses = append(ms.Responses, multigetResponse(inst, ab, href, r.Prop))
+		}
+
+	case reportQuery:
+		// The filters are not supported: all the vCards of the address book
+		// are returned, and the client can filter them.
+		contacts, err := ab.ListContacts(inst)
+		if err != nil {
+			return wrapError(err)
+		}
+		for _, doc := range contacts {
+			ms.Responses = append(ms.Responses, vcardResponse(ab.ID, doc, r.Prop))
+		}
+
+	case reportSync:
+		since, err := parseSyncToken(r.SyncToken)
+		if err != nil {
+			return sendPreconditionError(c, http.StatusForbidden, nsDAV, "valid-sync-token")
+		}
+		changes, err := ab.Changes(inst, since)
+		if err != nil {
+			if err == contact.ErrInvalidSyncToken {
+				return sendPreconditionError(c, http.StatusForbidden, nsDAV, "valid-sync-token")
+			}
+			return wrapError(err)
+		}
+		for _, doc := range changes.Updated {
+			ms.Responses = append(ms.Responses, vcardResponse(ab.ID, doc, r.Prop))
+		}
+		for _, id := range changes.Removed {
+			ms.Responses = append(ms.Responses, statusResponse(vcardHref(ab.ID, id), http.StatusNotFound))
+		}
+		ms.SyncToken = syncTokenFor(changes.SyncToken)
+
+	default:
+		return sendPreconditionError(c, http.StatusForbidden, nsDAV, "supported-report")
+	}
+
+	return sendMultistatus(c, ms)
+}
+
+func multigetResponse(inst *instance.Instance, ab *contact.AddressBook, href string, p *prop) response {
+	u, err := url.Parse(href)
+	if err != nil {
+		return statusResponse(href, http.StatusBadRequest)
+	}
+	dir, file := path.Split(u.Path)
+	if dir != addressBooksPath+ab.ID+"/" {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	id, ok := idFromFile(file, vcardExt)
+	if !ok {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	doc, err := ab.GetContact(inst, id)
+	if err != nil {
+		return statusResponse(href, http.StatusNotFound)
+	}
+	return vcardResponse(ab.ID, doc, p)
+}
+
+// PropfindVCard returns the properties of a vCard.
+func PropfindVCard(c echo.Context) error {
+	ab, doc, err := getVCard(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	ms := &multistatus{}
+	ms.Responses = append(ms.Responses, vcardResponse(ab.ID, doc, pf.Prop))
+	return sendMultistatus(c, ms)
+}
+
+// GetVCard returns a contact in the vCard format.
+func GetVCard(c echo.Context) error {
+	_, doc, err := getVCard(c, permission.GET)
+	if err != nil {
+		return err
+	}
+	version := vcardVersion(c.Request().Header.Get(echo.HeaderAccept), nil)
+	c.Response().Header().Set("ETag", contactETag(doc))
+	return c.Blob(http.StatusOK, "text/vcard; charset=utf-8", doc.ToVCard(version).Bytes())
+}
+
+// PutVCard creates or updates a contact from a vCard.
+func PutVCard(c echo.Context) error {
+	ab, err := getAddressBook(c, permission.PUT)
+	if err != nil {
+		return err
+	}
+	id, ok := idFromFile(c.Param("file"), vcardExt)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid resource name")
+	}
+	inst := middlewares.GetInstance(c)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxRequestSize))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+	card, err := contact.ParseVCard(body)
+	if err != nil {
+		return sendPreconditionError(c, http.StatusBadRequest, nsCardDAV, "valid-address-data")
+	}
+
+	doc, err := contact.Find(inst, id)
+	exists := err == nil && !doc.IsTrashed()
+	if err != nil {
+		if !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+			return wrapError(err)
+		}
+		doc = contact.New()
+		doc.SetID(id)
+	}
+	if err := checkPreconditions(c, contactETag(doc), exists); err != nil {
+		return err
+	}
+	if doc.Rev() == "" {
+		if err := allowWholeType(c, permission.POST, consts.Contacts); err != nil {
+			return err
+		}
+	}
+	if err := ab.SaveContact(inst, doc, card); err != nil {
+		return wrapError(err)
+	}
+	c.Response().Header().Set("ETag", contactETag(doc))
+	if exists {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.NoContent(http.StatusCreated)
+}
+
+// DeleteVCard removes a contact from an address book. See
+// contact.AddressBook.RemoveContact for the details.
+func DeleteVCard(c echo.Context) error {
+	ab, doc, err := getVCard(c, permission.DELETE)
+	if err != nil {
+		return err
+	}
+	if err := checkPreconditions(c, contactETag(doc), true); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if err := ab.RemoveContact(inst, doc); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkPreconditions checks the If-Match and If-None-Match headers, that are
+// used by the clients to not overwrite the changes made by another client.
+func checkPreconditions(c echo.Context, etag string, exists bool) error {
+	header := c.Request().Header
+	if match := header.Get("If-Match"); match != "" {
+		if !exists || (match != "*" && match != etag) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed)
+		}
+	}
+	if noneMatch := header.Get("If-None-Match"); noneMatch != "" && exists {
+		if noneMatch == "*" || noneMatch == etag {
+			return echo.NewHTTPError(http.StatusPreconditionFailed)
+		}
+	}
+	return nil
+}
+
+func getAddressBook(c echo.Context, v permission.Verb) (*contact.AddressBook, error) {
+	if err := allow(c, v, consts.Contacts); err != nil {
+		return nil, err
+	}
+	id := c.Param("book")
+	if id != contact.DefaultAddressBook && !canReadGroups(c) {
+		return nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	inst := middlewares.GetInstance(c)
+	ab, err := contact.GetAddressBook(inst, id)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return ab, nil
+}
+
+func getVCard(c echo.Context, v permission.Verb) (*contact.AddressBook, *contact.Contact, error) {
+	ab, err := getAddressBook(c, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, ok := idFromFile(c.Param("file"), vcardExt)
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	inst := middlewares.GetInstance(c)
+	doc, err := ab.GetContact(inst, id)
+	if err != nil {
+		return nil, nil, wrapError(err)
+	}
+	return ab, doc, nil
+}
+
+// wrapError returns a formatted error
+func wrapError(err error) error {
+	switch err {
+	case contact.ErrNotFound, contact.ErrAddressBookNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case calendar.ErrCalendarNotFound, calendar.ErrItemNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case contact.ErrInvalidVCard, contact.ErrInvalidSyncToken,
+		calendar.ErrInvalidICalendar, calendar.ErrInvalidSyncToken:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if couchdb.IsConflictError(err) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	}
+	logger.WithNamespace("dav").Warnf("Not wrapped error: %s", err)
+	return err
+}
@@ -0,0 +1,369 @@
+// Package dav exposes the contacts via CardDAV, and the events and the tasks
+// via CalDAV, for the phones and the desktop applications that can
+// synchronize them natively.
+package dav
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// The XML namespaces used by the DAV protocols.
+const (
+	nsDAV            = "DAV:"
+	nsCardDAV        = "urn:ietf:params:xml:ns:carddav"
+	nsCalDAV         = "urn:ietf:params:xml:ns:caldav"
+	nsCalendarServer = "http://calendarserver.org/ns/"
+)
+
+// The paths of the principal and of the home collections.
+const (
+	davPath          = "/dav/"
+	principalPath    = "/dav/principals/me/"
+	addressBooksPath = "/dav/contacts/"
+	calendarsPath    = "/dav/calendars/"
+)
+
+// maxRequestSize is the maximal size of the body of a DAV request.
+const maxRequestSize = 10 << 20
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
+	SyncToken string     `xml:"DAV: sync-token,omitempty"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat,omitempty"`
+	Status    string     `xml:"DAV: status,omitempty"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type prop struct {
+	Properties []property `xml:",any"`
+}
+
+// property is a generic XML element, used for the properties of the DAV
+// resources.
+type property struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []property `xml:",any"`
+}
+
+type propfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *prop     `xml:"DAV: prop"`
+}
+
+// report is the body of a REPORT request. The same struct is used for the
+// different reports, the XMLName tells which one is used.
+type report struct {
+	XMLName   xml.Name
+	AllProp   *struct{} `xml:"DAV: allprop"`
+	Prop      *prop     `xml:"DAV: prop"`
+	Hrefs     []string  `xml:"DAV: href"`
+	SyncToken string    `xml:"DAV: sync-token"`
+	Filter    *property `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	TimeRange *property `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+type davError struct {
+	XMLName   xml.Name `xml:"DAV: error"`
+	Condition property
+}
+
+func newProp(space, local, text string, children ...property) property {
+	return property{
+		XMLName:  xml.Name{Space: space, Local: local},
+		Text:     text,
+		Children: children,
+	}
+}
+
+func hrefProp(space, local, href string) property {
+	return newProp(space, local, "", newProp(nsDAV, "href", href))
+}
+
+func resourceType(types ...property) property {
+	return newProp(nsDAV, "resourcetype", "", types...)
+}
+
+func collectionType() property {
+	return newProp(nsDAV, "collection", "")
+}
+
+func privilegeSet(privileges ...string) property {
+	set := newProp(nsDAV, "current-user-privilege-set", "")
+	for _, p := range privileges {
+		set.Children = append(set.Children,
+			newProp(nsDAV, "privilege", "", newProp(nsDAV, p, "")))
+	}
+	return set
+}
+
+func supportedReports(reports ...xml.Name) property {
+	set := newProp(nsDAV, "supported-report-set", "")
+	for _, r := range reports {
+		set.Children = append(set.Children,
+			newProp(nsDAV, "supported-report", "",
+				newProp(nsDAV, "report", "", newProp(r.Space, r.Local, ""))))
+	}
+	return set
+}
+
+// requestedProps returns the names of the properties asked in a PROPFIND or
+// REPORT request. A nil slice means that all the properties are asked.
+func requestedProps(p *prop) []xml.Name {
+	if p == nil {
+		return nil
+	}
+	names := make([]xml.Name, 0, len(p.Properties))
+	for _, property := range p.Properties {
+		names = append(names, property.XMLName)
+	}
+	return names
+}
+
+// findRequested returns the requested property with the given name, or nil.
+func findRequested(p *prop, space, local string) *property {
+	if p == nil {
+		return nil
+	}
+	for i, property := range p.Properties {
+		if property.XMLName.Space == space && property.XMLName.Local == local {
+			return &p.Properties[i]
+		}
+	}
+	return nil
+}
+
+// propResponse returns the response for a resource, with the requested
+// properties in a 200 propstat, and the unknown properties in a 404 propstat.
+func propResponse(href string, available []property, requested []xml.Name) response {
+	res := response{Href: href}
+	if requested == nil {
+		res.Propstats = []propstat{{
+			Prop:   prop{Properties: available},
+			Status: statusLine(http.StatusOK),
+		}}
+		return res
+	}
+	var found, missing []property
+	for _, name := range requested {
+		ok := false
+		for _, p := range available {
+			if p.XMLName == name {
+				found = append(found, p)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			missing = append(missing, property{XMLName: name})
+		}
+	}
+	if len(found) > 0 {
+		res.Propstats = append(res.Propstats, propstat{
+			Prop:   prop{Properties: found},
+			Status: statusLine(http.StatusOK),
+		})
+	}
+	if len(missing) > 0 {
+		res.Propstats = append(res.Propstats, propstat{
+			Prop:   prop{Properties: missing},
+			Status: statusLine(http.StatusNotFound),
+		})
+	}
+	return res
+}
+
+func statusResponse(href string, status int) response {
+	return response{Href: href, Status: statusLine(status)}
+}
+
+func statusLine(status int) string {
+	return "HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status)
+}
+
+// readPropfind parses the body of a PROPFIND request. An empty body is the
+// same as asking all the properties.
+func readPropfind(c echo.Context) (*propfind, error) {
+	var pf propfind
+	if err := readXML(c, &pf); err != nil {
+		if err == io.EOF {
+			return &pf, nil
+		}
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return &pf, nil
+}
+
+func readXML(c echo.Context, v interface{}) error {
+	body := io.LimitReader(c.Request().Body, maxRequestSize)
+	return xml.NewDecoder(body).Decode(v)
+}
+
+// depth returns the value of the Depth header: 0 or 1 (infinity is treated
+// like 1).
+func depth(c echo.Context) int {
+	if c.Request().Header.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+func sendMultistatus(c echo.Context, ms *multistatus) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/xml; charset=utf-8")
+	res.WriteHeader(http.StatusMultiStatus)
+	if _, err := res.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	return xml.NewEncoder(res).Encode(ms)
+}
+
+func sendPreconditionError(c echo.Context, status int, space, local string) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/xml; charset=utf-8")
+	res.WriteHeader(status)
+	if _, err := res.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	return xml.NewEncoder(res).Encode(davError{Condition: newProp(space, local, "")})
+}
+
+// checkAuth checks that the request has a valid token (OAuth or app-specific
+// password), and asks for a basic authentication if it is not the case, as the
+// DAV clients expect.
+func checkAuth(c echo.Context) error {
+	if _, err := middlewares.GetPermission(c); err != nil {
+		return errUnauthorized(c)
+	}
+	return nil
+}
+
+// allow checks that the request has a permission for the verb on the whole
+// doctype.
+func allow(c echo.Context, v permission.Verb, doctype string) error {
+	if err := checkAuth(c); err != nil {
+		return err
+	}
+	return allowWholeType(c, v, doctype)
+}
+
+// allowWholeType checks that the request has a permission for the verb on
+// the whole doctype. The permissions that restrict the fields of the
+// documents are refused, as the vCards and iCalendars have all the fields.
+func allowWholeType(c echo.Context, v permission.Verb, doctype string) error {
+	if err := middlewares.AllowWholeType(c, v, doctype); err != nil {
+		return err
+	}
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if pdoc.Permissions.HasFieldsRestriction(doctype) {
+		return middlewares.ErrForbidden
+	}
+	return nil
+}
+
+func errUnauthorized(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cozy"`)
+	return echo.NewHTTPError(http.StatusUnauthorized)
+}
+
+// Options returns the methods and the DAV classes supported by the
+// endpoints.
+func Options(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, HEAD, PUT, DELETE")
+	header.Set("DAV", "1, 3, addressbook, calendar-access")
+	return c.NoContent(http.StatusOK)
+}
+
+// PropfindRoot returns the properties of the root of the DAV endpoints, and
+// of the principal. They are used by the clients to discover the address
+// books and the calendars.
+func PropfindRoot(c echo.Context) error {
+	if err := checkAuth(c); err != nil {
+		return err
+	}
+	pf, err := readPropfind(c)
+	if err != nil {
+		return err
+	}
+	href := davPath
+	types := []property{collectionType()}
+	if strings.HasPrefix(c.Request().URL.Path, strings.TrimSuffix(principalPath, "/")) {
+		href = principalPath
+		types = append(types, newProp(nsDAV, "principal", ""))
+	}
+	available := []property{
+		resourceType(types...),
+		newProp(nsDAV, "displayname", "Cozy"),
+		hrefProp(nsDAV, "current-user-principal", principalPath),
+		hrefProp(nsDAV, "principal-URL", principalPath),
+		hrefProp(nsCardDAV, "addressbook-home-set", addressBooksPath),
+		hrefProp(nsCalDAV, "calendar-home-set", calendarsPath),
+	}
+	ms := &multistatus{}
+	ms.Responses = append(ms.Responses, propResponse(href, available, requestedProps(pf.Prop)))
+	return sendMultistatus(c, ms)
+}
+
+// Routes sets the routing for the DAV endpoints
+func Routes(router *echo.Group) {
+	for _, p := range []string{"", "/", "/principals/me", "/principals/me/"} {
+		router.OPTIONS(p, Options)
+		router.Add("PROPFIND", p, PropfindRoot)
+	}
+
+	for _, p := range []string{"/contacts", "/contacts/"} {
+		router.OPTIONS(p, Options)
+		router.Add("PROPFIND", p, PropfindAddressBooks)
+	}
+	for _, p := range []string{"/contacts/:book", "/contacts/:book/"} {
+		router.OPTIONS(p, Options)
+		router.Add("PROPFIND", p, PropfindAddressBook)
+		router.Add("REPORT", p, ReportAddressBook)
+	}
+	router.OPTIONS("/contacts/:book/:file", Options)
+	router.Add("PROPFIND", "/contacts/:book/:file", PropfindVCard)
+	router.GET("/contacts/:book/:file", GetVCard)
+	router.HEAD("/contacts/:book/:file", GetVCard)
+	router.PUT("/contacts/:book/:file", PutVCard)
+	router.DELETE("/contacts/:book/:file", DeleteVCard)
+
+	for _, p := range []string{"/calendars", "/calendars/"} {
+		router.OPTIONS(p, Options)
+		router.Add("PROPFIND", p, PropfindCalendars)
+	}
+	for _, p := range []string{"/calendars/:cal", "/calendars/:cal/"} {
+		router.OPTIONS(p, Options)
+		router.Add("PROPFIND", p, PropfindCalendar)
+		router.Add("REPORT", p, ReportCalendar)
+	}
+	router.OPTIONS("/calendars/:cal/:file", Options)
+	router.Add("PROPFIND", "/calendars/:cal/:file", PropfindICalendar)
+	router.GET("/calendars/:cal/:file", GetICalendar)
+	router.HEAD("/calendars/:cal/:file", GetICalendar)
+	router.PUT("/calendars/:cal/:file", PutICalendar)
+	router.DELETE("/calendars/:cal/:file", DeleteICalendar)
+}
@@ -0,0 +1,242 @@
+package dav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+var ts *httptest.Server
+var testInstance *instance.Instance
+var token string
+var restrictedToken string
+
+func davRequest(t *testing.T, method, path, body string, headers map[string]string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	return res, content
+}
+
+func parseMultistatus(t *testing.T, content []byte) *multistatus {
+	var ms multistatus
+	err := xml.Unmarshal(content, &ms)
+	assert.NoError(t, err)
+	return &ms
+}
+
+func TestUnauthorized(t *testing.T) {
+	req, _ := http.NewRequest("PROPFIND", ts.URL+"/dav/contacts/", nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+	assert.Contains(t, res.Header.Get("WWW-Authenticate"), "Basic")
+}
+
+func TestFieldsRestriction(t *testing.T) {
+	req, _ := http.NewRequest("PROPFIND", ts.URL+"/dav/contacts/", nil)
+	req.Header.Add("Authorization", "Bearer "+restrictedToken)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 403, res.StatusCode)
+
+	req, _ = http.NewRequest("REPORT", ts.URL+"/dav/calendars/events/", nil)
+	req.Header.Add("Authorization", "Bearer "+restrictedToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 403, res.StatusCode)
+}
+
+func TestDiscovery(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:current-user-principal/><card:addressbook-home-set/></d:prop>
+</d:propfind>`
+	res, content := davRequest(t, "PROPFIND", "/dav/principals/me/", body, map[string]string{"Depth": "0"})
+	assert.Equal(t, 207, res.StatusCode)
+	assert.Contains(t, string(content), "/dav/contacts/")
+
+	res, content = davRequest(t, "PROPFIND", "/dav/contacts/", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, 207, res.StatusCode)
+	ms := parseMultistatus(t, content)
+	if assert.Len(t, ms.Responses, 2) {
+		assert.Equal(t, "/dav/contacts/default/", ms.Responses[1].Href)
+	}
+}
+
+func TestCreateUpdateDeleteVCard(t *testing.T) {
+	res, content := davRequest(t, "PROPFIND", "/dav/contacts/default/", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, 207, res.StatusCode)
+	ms := parseMultistatus(t, content)
+	var syncToken string
+	for _, p := range ms.Responses[0].Propstats[0].Prop.Properties {
+		if p.XMLName.Local == "sync-token" {
+			syncToken = p.Text
+		}
+	}
+	assert.NotEmpty(t, syncToken)
+
+	vcard := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Bob\r\nN:;Bob;;;\r\nEMAIL:bob@example.com\r\nEND:VCARD\r\n"
+	res, _ = davRequest(t, "PUT", "/dav/contacts/default/bob-123.vcf", vcard, map[string]string{
+		"Content-Type":  "text/vcard",
+		"If-None-Match": "*",
+	})
+	assert.Equal(t, 201, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	doc, err := contact.Find(testInstance, "bob-123")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", doc.PrimaryName())
+
+	res, _ = davRequest(t, "PUT", "/dav/contacts/default/bob-123.vcf", vcard, map[string]string{
+		"If-None-Match": "*",
+	})
+	assert.Equal(t, 412, res.StatusCode)
+
+	res, content = davRequest(t, "GET", "/dav/contacts/default/bob-123.vcf", "", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, etag, res.Header.Get("ETag"))
+	assert.Contains(t, string(content), "EMAIL:bob@example.com")
+
+	report := `<?xml version="1.0"?>
+<d:sync-collection xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:sync-token>` + syncToken + `</d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop><d:getetag/><card:address-data/></d:prop>
+</d:sync-collection>`
+	res, content = davRequest(t, "REPORT", "/dav/contacts/default/", report, nil)
+	assert.Equal(t, 207, res.StatusCode)
+	ms = parseMultistatus(t, content)
+	if assert.Len(t, ms.Responses, 1) {
+		assert.Equal(t, "/dav/contacts/default/bob-123.vcf", ms.Responses[0].Href)
+	}
+	assert.NotEqual(t, syncToken, ms.SyncToken)
+	syncToken = ms.SyncToken
+
+	vcard = strings.Replace(vcard, "FN:Bob", "FN:Bobby", 1)
+	res, _ = davRequest(t, "PUT", "/dav/contacts/default/bob-123.vcf", vcard, map[string]string{
+		"If-Match": `"1-wrong"`,
+	})
+	assert.Equal(t, 412, res.StatusCode)
+	res, _ = davRequest(t, "PUT", "/dav/contacts/default/bob-123.vcf", vcard, map[string]string{
+		"If-Match": etag,
+	})
+	assert.Equal(t, 204, res.StatusCode)
+
+	res, _ = davRequest(t, "DELETE", "/dav/contacts/default/bob-123.vcf", "", nil)
+	assert.Equal(t, 204, res.StatusCode)
+	res, _ = davRequest(t, "GET", "/dav/contacts/default/bob-123.vcf", "", nil)
+	assert.Equal(t, 404, res.StatusCode)
+
+	report = strings.Replace(report, "<card:address-data/>", "", 1)
+	res, content = davRequest(t, "REPORT", "/dav/contacts/default/", report, nil)
+	assert.Equal(t, 207, res.StatusCode)
+	ms = parseMultistatus(t, content)
+	if assert.Len(t, ms.Responses, 1) {
+		assert.Contains(t, ms.Responses[0].Status, "404")
+	}
+
+	report = strings.Replace(report, syncToken, "https://cozy.io/ns/sync/invalid", 1)
+	res, _ = davRequest(t, "REPORT", "/dav/contacts/default/", report, nil)
+	assert.Equal(t, 403, res.StatusCode)
+}
+
+func TestCalendars(t *testing.T) {
+	res, content := davRequest(t, "PROPFIND", "/dav/calendars/", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, 207, res.StatusCode)
+	ms := parseMultistatus(t, content)
+	if assert.Len(t, ms.Responses, 3) {
+		assert.Equal(t, "/dav/calendars/events/", ms.Responses[1].Href)
+		assert.Equal(t, "/dav/calendars/todos/", ms.Responses[2].Href)
+	}
+
+	ics := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nDTSTAMP:20210401T080000Z\r\n" +
+		"DTSTART:20210405T080000Z\r\nDTEND:20210405T081500Z\r\n" +
+		"RRULE:FREQ=DAILY;COUNT=5\r\nSUMMARY:Standup\r\n" +
+		"END:VEVENT\r\nEND:VCALENDAR\r\n"
+	res, _ = davRequest(t, "PUT", "/dav/calendars/todos/standup.ics", ics, nil)
+	assert.Equal(t, 403, res.StatusCode)
+	res, _ = davRequest(t, "PUT", "/dav/calendars/events/standup.ics", ics, map[string]string{
+		"Content-Type":  "text/calendar",
+		"If-None-Match": "*",
+	})
+	assert.Equal(t, 201, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	res, content = davRequest(t, "GET", "/dav/calendars/events/standup.ics", "", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, etag, res.Header.Get("ETag"))
+	assert.Contains(t, string(content), "RRULE:FREQ=DAILY;COUNT=5")
+
+	query := `<?xml version="1.0"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><d:getetag/></d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="VEVENT">
+        <c:time-range start="20210407T000000Z" end="20210408T000000Z"/>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`
+	res, content = davRequest(t, "REPORT", "/dav/calendars/events/", query, nil)
+	assert.Equal(t, 207, res.StatusCode)
+	ms = parseMultistatus(t, content)
+	assert.Len(t, ms.Responses, 1)
+	query = strings.Replace(query, "20210407", "20210410", 1)
+	query = strings.Replace(query, "20210408", "20210411", 1)
+	res, content = davRequest(t, "REPORT", "/dav/calendars/events/", query, nil)
+	assert.Equal(t, 207, res.StatusCode)
+	ms = parseMultistatus(t, content)
+	assert.Len(t, ms.Responses, 0)
+
+	freebusy := `<?xml version="1.0"?>
+<c:free-busy-query xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <c:time-range start="20210406T000000Z" end="20210407T000000Z"/>
+</c:free-busy-query>`
+	res, content = davRequest(t, "REPORT", "/dav/calendars/events/", freebusy, nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, string(content), "FREEBUSY;FBTYPE=BUSY:20210406T080000Z/20210406T081500Z")
+
+	res, _ = davRequest(t, "DELETE", "/dav/calendars/events/standup.ics", "", map[string]string{
+		"If-Match": etag,
+	})
+	assert.Equal(t, 204, res.StatusCode)
+	res, _ = davRequest(t, "GET", "/dav/calendars/events/standup.ics", "", nil)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestMain(m *testing.M) {
+	config.UseTestFile()
+	testutils.NeedCouchdb()
+	setup := testutils.NewSetup(m, "dav_test")
+	testInstance = setup.GetTestInstance()
+	_, token = setup.GetTestClient(consts.Contacts + " " + consts.CalendarEvents + " " + consts.CalendarTodos)
+	_, restrictedToken = setup.GetTestClient("io.cozy.contacts:GET:::fullname io.cozy.calendar.events:GET:::summary")
+	ts = setup.GetTestServer("/dav", Routes)
+	os.Exit(setup.Run())
+}
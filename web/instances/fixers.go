@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/stack"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -317,3 +319,45 @@ func indexesFixer(c echo.Context) error {
 
 	return c.NoContent(http.StatusNoContent)
 }
+
+func sharingsFixer(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		SharingID string `json:"sharing_id"`
+		Member    *int   `json:"member"`
+	}{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	s, err := sharing.FindSharing(inst, body.SharingID)
+	if err != nil {
+		return err
+	}
+
+	indexes := make([]int, len(s.Members))
+	for i := range s.Members {
+		indexes[i] = i
+	}
+	if body.Member != nil {
+		indexes = []int{*body.Member}
+	}
+
+	res := make(map[string][]string)
+	for _, index := range indexes {
+		actions, err := s.FixMember(inst, index)
+		if err == sharing.ErrMemberNotFound && body.Member == nil {
+			continue // The changes are not sent to this member
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity,
+				fmt.Sprintf("Cannot fix member %d: %s (actions done: %v)", index, err, actions))
+		}
+		res[strconv.Itoa(index)] = actions
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -112,6 +112,7 @@ func createHandler(c echo.Context) error {
 	}
 	in.CLISecret = nil
 	in.OAuthSecret = nil
+	in.OIDCPrivateKey = nil
 	in.SessSecret = nil
 	in.PassphraseHash = nil
 	return jsonapi.Data(c, http.StatusCreated, &apiInstance{in}, nil)
@@ -125,6 +126,7 @@ func showHandler(c echo.Context) error {
 	}
 	in.CLISecret = nil
 	in.OAuthSecret = nil
+	in.OIDCPrivateKey = nil
 	in.SessSecret = nil
 	in.PassphraseHash = nil
 	return jsonapi.Data(c, http.StatusOK, &apiInstance{in}, nil)
@@ -227,6 +229,7 @@ func listHandler(c echo.Context) error {
 	for i, in := range instances {
 		in.CLISecret = nil
 		in.OAuthSecret = nil
+		in.OIDCPrivateKey = nil
 		in.SessSecret = nil
 		in.PassphraseHash = nil
 		objs[i] = &apiInstance{in}
@@ -260,11 +263,13 @@ func updatesHandler(c echo.Context) error {
 	domainsWithContext := c.QueryParam("DomainsWithContext")
 	forceRegistry, _ := strconv.ParseBool(c.QueryParam("ForceRegistry"))
 	onlyRegistry, _ := strconv.ParseBool(c.QueryParam("OnlyRegistry"))
+	skipRollout, _ := strconv.ParseBool(c.QueryParam("SkipRollout"))
 	msg, err := job.NewMessage(&updates.Options{
 		Slugs:              slugs,
 		Force:              true,
 		ForceRegistry:      forceRegistry,
 		OnlyRegistry:       onlyRegistry,
+		SkipRollout:        skipRollout,
 		Domain:             domain,
 		DomainsWithContext: domainsWithContext,
 		AllDomains:         domain == "",
@@ -505,4 +510,5 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/fixers/content-mismatch", contentMismatchFixer)
 	router.POST("/:domain/fixers/orphan-account", orphanAccountFixer)
 	router.POST("/:domain/fixers/indexes", indexesFixer)
+	router.POST("/:domain/fixers/sharings", sharingsFixer)
 }
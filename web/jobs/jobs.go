@@ -27,11 +27,14 @@ import (
 
 	// import workers
 	_ "github.com/cozy/cozy-stack/worker/archive"
+	_ "github.com/cozy/cozy-stack/worker/bitwarden"
+	_ "github.com/cozy/cozy-stack/worker/contacts"
 	_ "github.com/cozy/cozy-stack/worker/log"
 	_ "github.com/cozy/cozy-stack/worker/mails"
 	_ "github.com/cozy/cozy-stack/worker/migrations"
 	_ "github.com/cozy/cozy-stack/worker/moves"
 	_ "github.com/cozy/cozy-stack/worker/notes"
+	_ "github.com/cozy/cozy-stack/worker/notifications"
 	_ "github.com/cozy/cozy-stack/worker/oauth"
 	_ "github.com/cozy/cozy-stack/worker/push"
 	_ "github.com/cozy/cozy-stack/worker/share"
@@ -65,6 +68,9 @@ type (
 		t *job.TriggerInfos
 		s *job.TriggerState
 	}
+	apiReport struct {
+		r *job.Report
+	}
 	apiTriggerRequest struct {
 		Type            string          `json:"type"`
 		Arguments       string          `json:"arguments"`
@@ -143,6 +149,22 @@ func (t apiTriggerState) MarshalJSON() ([]byte, error) {
 	return json.Marshal(t.s)
 }
 
+func (r apiReport) ID() string                             { return r.r.ID() }
+func (r apiReport) Rev() string                            { return r.r.Rev() }
+func (r apiReport) DocType() string                        { return consts.JobReports }
+func (r apiReport) Clone() couchdb.Doc                     { return r }
+func (r apiReport) SetID(_ string)                         {}
+func (r apiReport) SetRev(_ string)                        {}
+func (r apiReport) Relationships() jsonapi.RelationshipMap { return nil }
+func (r apiReport) Included() []jsonapi.Object             { return nil }
+func (r apiReport) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/jobs/" + r.ID() + "/report"}
+}
+
+func (r apiReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.r)
+}
+
 func getQueue(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	workerType := c.Param("worker-type")
@@ -371,6 +393,41 @@ func getTriggerJobs(c echo.Context) error {
 	return jsonapi.DataList(c, http.StatusOK, objs, nil)
 }
 
+func getTriggerReports(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+
+	var err error
+
+	var limit int
+	if queryLimit := c.QueryParam("Limit"); queryLimit != "" {
+		limit, err = strconv.Atoi(queryLimit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+	}
+
+	sched := job.System()
+	t, err := sched.GetTrigger(instance, c.Param("trigger-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	if err = middlewares.Allow(c, permission.GET, t); err != nil {
+		return err
+	}
+
+	reports, err := job.GetReports(t, t.ID(), limit)
+	if err != nil {
+		return wrapJobsError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(reports))
+	for i, r := range reports {
+		objs[i] = apiReport{r}
+	}
+
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
 func launchTrigger(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	t, err := job.System().GetTrigger(instance, c.Param("trigger-id"))
@@ -512,6 +569,22 @@ func getJob(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, apiJob{j}, nil)
 }
 
+func getJobReport(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	r, err := job.GetReport(instance, c.Param("job-id"))
+	if err != nil {
+		return wrapJobsError(err)
+	}
+	// The permissions on the job are enough to read its report
+	j := &job.Job{JobID: r.ID(), WorkerType: r.WorkerType, TriggerID: r.TriggerID}
+	if err := middlewares.Allow(c, permission.GET, j); err != nil {
+		if err = middlewares.Allow(c, permission.GET, r); err != nil {
+			return err
+		}
+	}
+	return jsonapi.Data(c, http.StatusOK, apiReport{r}, nil)
+}
+
 func patchJob(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 	j, err := job.Get(inst, c.Param("job-id"))
@@ -693,6 +766,7 @@ func Routes(router *echo.Group) {
 	router.GET("/triggers/:trigger-id", getTrigger)
 	router.GET("/triggers/:trigger-id/state", getTriggerState)
 	router.GET("/triggers/:trigger-id/jobs", getTriggerJobs)
+	router.GET("/triggers/:trigger-id/reports", getTriggerReports)
 	router.POST("/triggers/:trigger-id/launch", launchTrigger)
 	router.DELETE("/triggers/:trigger-id", deleteTrigger)
 
@@ -701,6 +775,7 @@ func Routes(router *echo.Group) {
 	router.POST("/clean", cleanJobs)
 	router.DELETE("/purge", purgeJobs)
 	router.GET("/:job-id", getJob)
+	router.GET("/:job-id/report", getJobReport)
 	router.PATCH("/:job-id", patchJob)
 }
 
@@ -708,6 +783,7 @@ func wrapJobsError(err error) error {
 	switch err {
 	case job.ErrNotFoundTrigger,
 		job.ErrNotFoundJob,
+		job.ErrNotFoundReport,
 		job.ErrUnknownWorker:
 		return jsonapi.NotFound(err)
 	case job.ErrUnknownTrigger:
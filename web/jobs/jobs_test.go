@@ -600,6 +600,70 @@ func TestClientJobs(t *testing.T) {
 	assert.NotEmpty(t, v3.Data.Attributes.FinishedAt)
 }
 
+func TestGetJobReport(t *testing.T) {
+	report := &job.Report{
+		DocID:      "report-print-1",
+		WorkerType: "print",
+		Slug:       "printer",
+		State:      job.Errored,
+		Error:      "LOGIN_FAILED",
+		StartedAt:  time.Now().Add(-2 * time.Second),
+		FinishedAt: time.Now(),
+		Logs: []job.ReportLog{
+			{Time: time.Now(), Level: "critical", Message: "LOGIN_FAILED"},
+		},
+		FilesCreated: []string{"file-1"},
+		BillsSaved:   2,
+	}
+	assert.NoError(t, report.Save(testInstance))
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/jobs/report-print-1/report", nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var v struct {
+		Data struct {
+			ID         string     `json:"id"`
+			Type       string     `json:"type"`
+			Attributes job.Report `json:"attributes"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&v))
+	assert.Equal(t, "report-print-1", v.Data.ID)
+	assert.Equal(t, consts.JobReports, v.Data.Type)
+	assert.Equal(t, "printer", v.Data.Attributes.Slug)
+	assert.Equal(t, "LOGIN_FAILED", v.Data.Attributes.Error)
+	assert.Len(t, v.Data.Attributes.Logs, 1)
+	assert.Equal(t, []string{"file-1"}, v.Data.Attributes.FilesCreated)
+	assert.Equal(t, 2, v.Data.Attributes.BillsSaved)
+
+	other := &job.Report{
+		DocID:      "report-konnector-1",
+		WorkerType: "konnector",
+		Slug:       "other",
+		StartedAt:  time.Now(),
+	}
+	assert.NoError(t, other.Save(testInstance))
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/jobs/report-konnector-1/report", nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res2, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res2.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res2.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/jobs/unknown-job/report", nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res3, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res3.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res3.StatusCode)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// AcceptAppPasswords is a middleware for the routes where the app-specific
+// passwords can be used. When the request has an HTTP Basic auth with such a
+// password, the permissions of this password are used for the request. Else,
+// the password of the Basic auth is parsed as a token, like on the other
+// routes.
+func AcceptAppPasswords(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, password, ok := c.Request().BasicAuth(); ok {
+			inst := GetInstance(c)
+			if ap := inst.CheckAppPassword(password); ap != nil {
+				c.Set(contextPermissionDoc, ap.Permission())
+			}
+		}
+		return next(c)
+	}
+}
@@ -99,8 +99,11 @@ func GetForOauth(instance *instance.Instance, claims *permission.Claims, c inter
 		}
 		set = manifest.Permissions()
 	} else {
-		set, err = permission.UnmarshalScopeString(claims.Scope)
-		if err != nil {
+		// The OpenID Connect scopes are not permissions on doctypes
+		_, scope := oauth.SplitOIDCScope(claims.Scope)
+		if scope == "" {
+			set = permission.Set{}
+		} else if set, err = permission.UnmarshalScopeString(scope); err != nil {
 			return nil, err
 		}
 	}
@@ -192,6 +195,9 @@ func ParseJWT(c echo.Context, instance *instance.Instance, token string) (*permi
 			}
 			return nil, permission.ErrInvalidToken
 		}
+		if c.IsRevoked(&claims) {
+			return nil, permission.ErrInvalidToken
+		}
 		return GetForOauth(instance, &claims, c)
 
 	case consts.CLIAudience:
@@ -7,9 +7,11 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
 )
@@ -34,6 +36,26 @@ func (n *apiNotif) MarshalJSON() ([]byte, error) {
 	return json.Marshal(n.n)
 }
 
+type apiPreferences struct {
+	*notification.Preferences
+}
+
+func (p *apiPreferences) Relationships() jsonapi.RelationshipMap { return nil }
+func (p *apiPreferences) Included() []jsonapi.Object             { return nil }
+func (p *apiPreferences) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/notifications/preferences"}
+}
+
+type apiSubscription struct {
+	*notification.WebPushSubscription
+}
+
+func (s *apiSubscription) Relationships() jsonapi.RelationshipMap { return nil }
+func (s *apiSubscription) Included() []jsonapi.Object             { return nil }
+func (s *apiSubscription) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/notifications/webpush/subscriptions/" + s.ID()}
+}
+
 func createHandler(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 	n := &notification.Notification{}
@@ -50,6 +72,123 @@ func createHandler(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusCreated, &apiNotif{n}, nil)
 }
 
+func getPreferences(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	prefs.DocID = consts.NotificationsSettingsID
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs.WithoutSecrets()}, nil)
+}
+
+func updatePreferences(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+
+	var attrs notification.Preferences
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	attrs.KeepSecrets(prefs)
+	if err := attrs.Validate(); err != nil {
+		return wrapErrors(err)
+	}
+	if err := center.ValidateChannels(inst, &attrs); err != nil {
+		return wrapErrors(err)
+	}
+	prefs.Default = attrs.Default
+	prefs.Apps = attrs.Apps
+	prefs.Categories = attrs.Categories
+	prefs.DigestFrequency = attrs.DigestFrequency
+	prefs.DigestTime = attrs.DigestTime
+	prefs.DigestWeekday = attrs.DigestWeekday
+	prefs.QuietHours = attrs.QuietHours
+	prefs.Timezone = attrs.Timezone
+	prefs.Channels = attrs.Channels
+	if err := prefs.Save(inst); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs.WithoutSecrets()}, nil)
+}
+
+func getVAPIDKey(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.NotificationsWebPush); err != nil {
+		return err
+	}
+	sender, err := notification.WebPushSender()
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"public_key": webpush.EncodePublicKey(&sender.Key.PublicKey),
+	})
+}
+
+func createSubscription(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.NotificationsWebPush); err != nil {
+		return err
+	}
+	if _, err := notification.WebPushSender(); err != nil {
+		return wrapErrors(err)
+	}
+	inst := middlewares.GetInstance(c)
+	sub := &notification.WebPushSubscription{}
+	if _, err := jsonapi.Bind(c.Request().Body, sub); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if sub.UserAgent == "" {
+		sub.UserAgent = c.Request().UserAgent()
+	}
+	if err := notification.SaveWebPushSubscription(inst, sub); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiSubscription{sub}, nil)
+}
+
+func listSubscriptions(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.NotificationsWebPush); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	subs, err := notification.ListWebPushSubscriptions(inst)
+	if err != nil {
+		return err
+	}
+	objs := make([]jsonapi.Object, len(subs))
+	for i, sub := range subs {
+		objs[i] = &apiSubscription{sub}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func deleteSubscription(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.NotificationsWebPush); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	sub, err := notification.GetWebPushSubscription(inst, c.Param("id"))
+	if err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return jsonapi.NotFound(err)
+		}
+		return err
+	}
+	if err := notification.DeleteWebPushSubscription(inst, sub); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func wrapErrors(err error) error {
 	if err == nil {
 		return nil
@@ -63,6 +202,17 @@ func wrapErrors(err error) error {
 		return jsonapi.Forbidden(err)
 	case center.ErrCategoryNotFound:
 		return jsonapi.Forbidden(err)
+	case notification.ErrUnknownMode, notification.ErrInvalidDigest,
+		notification.ErrInvalidTime, notification.ErrUnknownTimezone:
+		return jsonapi.BadRequest(err)
+	case notification.ErrInvalidSubscription:
+		return jsonapi.BadRequest(err)
+	case notification.ErrUnknownChannel, notification.ErrInvalidChannelSettings:
+		return jsonapi.BadRequest(err)
+	case notification.ErrChannelNotAvailable:
+		return jsonapi.Forbidden(err)
+	case notification.ErrWebPushNotConfigured:
+		return jsonapi.NewError(http.StatusNotImplemented, err.Error())
 	case app.ErrNotFound:
 		return jsonapi.NotFound(err)
 	}
@@ -72,4 +222,10 @@ func wrapErrors(err error) error {
 // Routes sets the routing for the notification service.
 func Routes(router *echo.Group) {
 	router.POST("", createHandler)
+	router.GET("/preferences", getPreferences)
+	router.PUT("/preferences", updatePreferences)
+	router.GET("/webpush/key", getVAPIDKey)
+	router.GET("/webpush/subscriptions", listSubscriptions)
+	router.POST("/webpush/subscriptions", createSubscription)
+	router.DELETE("/webpush/subscriptions/:id", deleteSubscription)
 }
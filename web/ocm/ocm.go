@@ -0,0 +1,252 @@
+// Package ocm is for the Open Cloud Mesh endpoints, used by other servers
+// (Nextcloud, ownCloud, etc.) to send shares to a Cozy, and to access the
+// files shared by a Cozy via WebDAV.
+package ocm
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// Discovery returns the informations about the OCM endpoints of this
+// instance.
+func Discovery(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	return c.JSON(http.StatusOK, sharing.LocalOCMDiscovery(inst))
+}
+
+// CreateShare is used by an OCM server to send a share to the user of this
+// instance. This route is not authenticated, and makes requests to the OCM
+// server of the sender: it is rate-limited.
+func CreateShare(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := limits.CheckRateLimit(inst, limits.OCMShareType); err != nil {
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	var req sharing.OCMShareRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if _, err := sharing.ReceiveOCMShare(inst, &req); err != nil {
+		return wrapErrors(err)
+	}
+	name, _ := inst.PublicName()
+	return c.JSON(http.StatusCreated, echo.Map{"recipientDisplayName": name})
+}
+
+// ReceiveNotification is used by an OCM server to notify this instance of a
+// change for a share.
+func ReceiveNotification(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var n sharing.OCMNotification
+	if err := c.Bind(&n); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	s, err := sharing.FindOCMSharing(inst, &n)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err := s.HandleOCMNotification(inst, &n); err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusCreated, echo.Map{})
+}
+
+// webdavSharing checks the shared secret sent by an OCM server for accessing
+// the files via WebDAV, and returns the sharing for this secret. The secret
+// is the username of the basic authentication, but it is also accepted as a
+// bearer token.
+func webdavSharing(c echo.Context) (*sharing.Sharing, error) {
+	inst := middlewares.GetInstance(c)
+	token, pass, ok := c.Request().BasicAuth()
+	if !ok {
+		token = middlewares.GetRequestToken(c)
+	} else if token == "" {
+		token = pass
+	}
+	if token == "" {
+		return nil, errUnauthorized(c)
+	}
+	pdoc, err := middlewares.ParseJWT(c, inst, token)
+	if err != nil || pdoc.Type != permission.TypeSharePreview {
+		return nil, errUnauthorized(c)
+	}
+	sharingID := strings.TrimPrefix(pdoc.SourceID, consts.Sharings+"/")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil || !s.Owner || !s.Active {
+		return nil, errUnauthorized(c)
+	}
+	m, err := s.FindMemberBySharecode(inst, token)
+	if err != nil || m.OCM == nil {
+		return nil, errUnauthorized(c)
+	}
+	return s, nil
+}
+
+func errUnauthorized(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cozy"`)
+	return echo.NewHTTPError(http.StatusUnauthorized)
+}
+
+// WebDAVOptions returns the methods allowed on the WebDAV endpoint.
+func WebDAVOptions(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set("Allow", "OPTIONS, PROPFIND, GET, HEAD")
+	header.Set("DAV", "1")
+	return c.NoContent(http.StatusOK)
+}
+
+// WebDAVGet sends the content of a shared file.
+func WebDAVGet(c echo.Context) error {
+	s, err := webdavSharing(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	_, file, err := s.ResolveOCMPath(inst, c.Param("*"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if file == nil {
+		return echo.NewHTTPError(http.StatusMethodNotAllowed)
+	}
+	return vfs.ServeFileContent(inst.VFS(), file, nil, "", "", c.Request(), c.Response())
+}
+
+// WebDAVPropfind returns the properties of a shared file or directory, and of
+// the children of a directory if the depth is not 0.
+func WebDAVPropfind(c echo.Context) error {
+	s, err := webdavSharing(c)
+	if err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	p := path.Clean("/" + c.Param("*"))
+	dir, file, err := s.ResolveOCMPath(inst, p)
+	if err != nil {
+		return wrapErrors(err)
+	}
+
+	ms := sharing.WebDAVMultistatus{}
+	if file != nil {
+		ms.Responses = append(ms.Responses, fileResponse(p, file))
+	} else {
+		ms.Responses = append(ms.Responses, dirResponse(p, dir))
+		if c.Request().Header.Get("Depth") != "0" {
+			responses, err := childrenResponses(inst, p, dir)
+			if err != nil {
+				return wrapErrors(err)
+			}
+			ms.Responses = append(ms.Responses, responses...)
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/xml; charset=utf-8")
+	res.WriteHeader(http.StatusMultiStatus)
+	if _, err := res.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	return xml.NewEncoder(res).Encode(ms)
+}
+
+func childrenResponses(inst *instance.Instance, p string, dir *vfs.DirDoc) ([]sharing.WebDAVResponse, error) {
+	var responses []sharing.WebDAVResponse
+	iter := inst.VFS().DirIterator(dir, nil)
+	for {
+		d, f, err := iter.Next()
+		if err == vfs.ErrIteratorDone {
+			return responses, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			responses = append(responses, dirResponse(path.Join(p, d.DocName), d))
+		} else {
+			responses = append(responses, fileResponse(path.Join(p, f.DocName), f))
+		}
+	}
+}
+
+func webdavHref(p string) string {
+	u := url.URL{Path: path.Join(sharing.OCMWebDAVPath, p)}
+	return u.EscapedPath()
+}
+
+func okPropstat(prop sharing.WebDAVProp) []sharing.WebDAVPropstat {
+	return []sharing.WebDAVPropstat{{Prop: prop, Status: "HTTP/1.1 200 OK"}}
+}
+
+func dirResponse(p string, dir *vfs.DirDoc) sharing.WebDAVResponse {
+	prop := sharing.WebDAVProp{
+		DisplayName:  dir.DocName,
+		LastModified: dir.UpdatedAt.UTC().Format(http.TimeFormat),
+		ETag:         `"` + dir.Rev() + `"`,
+		ResourceType: sharing.WebDAVResourceType{Collection: &struct{}{}},
+	}
+	return sharing.WebDAVResponse{
+		Href:     strings.TrimSuffix(webdavHref(p), "/") + "/",
+		Propstat: okPropstat(prop),
+	}
+}
+
+func fileResponse(p string, file *vfs.FileDoc) sharing.WebDAVResponse {
+	prop := sharing.WebDAVProp{
+		DisplayName:   file.DocName,
+		ContentLength: strconv.FormatInt(file.ByteSize, 10),
+		ContentType:   file.Mime,
+		LastModified:  file.UpdatedAt.UTC().Format(http.TimeFormat),
+		ETag:          `"` + file.Rev() + `"`,
+	}
+	return sharing.WebDAVResponse{
+		Href:     webdavHref(p),
+		Propstat: okPropstat(prop),
+	}
+}
+
+// wrapErrors returns a formatted error
+func wrapErrors(err error) error {
+	switch err {
+	case sharing.ErrInvalidOCMShare, sharing.ErrInvalidCloudID:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case sharing.ErrOCMNotSupported:
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	case sharing.ErrAlreadyAccepted:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case sharing.ErrMemberNotFound, sharing.ErrInvalidSharing, os.ErrNotExist:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	logger.WithNamespace("ocm").Warnf("Not wrapped error: %s", err)
+	return err
+}
+
+// Routes sets the routing for the Open Cloud Mesh endpoints
+func Routes(router *echo.Group) {
+	router.GET("", Discovery)
+	router.GET("/", Discovery)
+	router.POST("/shares", CreateShare)
+	router.POST("/notifications", ReceiveNotification)
+
+	for _, p := range []string{"/webdav", "/webdav/*"} {
+		router.OPTIONS(p, WebDAVOptions)
+		router.Add("PROPFIND", p, WebDAVPropfind)
+		router.GET(p, WebDAVGet)
+		router.HEAD(p, WebDAVGet)
+	}
+}
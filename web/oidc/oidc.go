@@ -1,7 +1,6 @@
 package oidc
 
 import (
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -114,8 +113,8 @@ func Login(c echo.Context) error {
 	}
 
 	// Check 2FA if enabled
-	if inst.HasAuthMode(instance.TwoFactorMail) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if inst.HasTwoFactor() {
+		twoFactorToken, err := lifecycle.StartTwoFactor(inst)
 		if err != nil {
 			return err
 		}
@@ -191,7 +190,7 @@ func AccessToken(c echo.Context) error {
 			"error": "the client must be registered",
 		})
 	}
-	if subtle.ConstantTimeCompare([]byte(reqBody.ClientSecret), []byte(client.ClientSecret)) == 0 {
+	if !client.CheckSecret(reqBody.ClientSecret) {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": "invalid client_secret",
 		})
@@ -337,6 +337,8 @@ func patchPermission(getPerms getPermsFunc, paramName string) echo.HandlerFunc {
 					toPatch.RemoveRule(r)
 				} else if err := permission.CheckDoctypeName(r.Type, true); err != nil {
 					return err
+				} else if err := (permission.Set{r}).CheckFields(); err != nil {
+					return err
 				} else if current.Permissions.RuleInSubset(r) {
 					toPatch.AddRules(r)
 				} else {
@@ -2,9 +2,11 @@ package realtime
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -148,8 +150,42 @@ func authorized(i *instance.Instance, perms permission.Set, permType, id string)
 	}
 }
 
+// wsPermissions keeps the permissions of the websocket client, as they are
+// needed when sending the events to remove the fields that can't be read.
+type wsPermissions struct {
+	mu  sync.RWMutex
+	set permission.Set
+}
+
+func (w *wsPermissions) setPermissions(set permission.Set) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.set = set
+}
+
+// project returns the document of an event, without the fields that the
+// client can't read.
+func (w *wsPermissions) project(doc realtime.Doc) interface{} {
+	w.mu.RLock()
+	set := w.set
+	w.mu.RUnlock()
+	if !set.HasFieldsRestriction(doc.DocType()) {
+		return doc
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil
+	}
+	jdoc := couchdb.JSONDoc{M: m, Type: doc.DocType()}
+	return set.Projection(&jdoc).Apply(m)
+}
+
 func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *websocket.Conn,
-	ds *realtime.DynamicSubscriber, errc chan *wsError, withAuthentication bool) {
+	ds *realtime.DynamicSubscriber, errc chan *wsError, perms *wsPermissions, withAuthentication bool) {
 	defer close(errc)
 
 	var err error
@@ -174,6 +210,7 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 			sendErr(ctx, errc, unauthorized(auth))
 			return
 		}
+		perms.setPermissions(pdoc.Permissions)
 	}
 
 	for {
@@ -269,7 +306,8 @@ func Ws(c echo.Context) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	errc := make(chan *wsError)
-	go readPump(ctx, c, inst, ws, ds, errc, withAuthentication)
+	perms := &wsPermissions{}
+	go readPump(ctx, c, inst, ws, ds, errc, perms, withAuthentication)
 
 	ticker := time.NewTicker(pingPeriod)
 	defer ticker.Stop()
@@ -295,7 +333,7 @@ func Ws(c echo.Context) error {
 				Payload: wsResponsePayload{
 					Type: e.Doc.DocType(),
 					ID:   e.Doc.ID(),
-					Doc:  e.Doc,
+					Doc:  perms.project(e.Doc),
 				},
 			}
 			if err := ws.WriteJSON(res); err != nil {
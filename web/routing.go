@@ -18,6 +18,7 @@ import (
 	"github.com/cozy/cozy-stack/web/compat"
 	"github.com/cozy/cozy-stack/web/contacts"
 	"github.com/cozy/cozy-stack/web/data"
+	"github.com/cozy/cozy-stack/web/dav"
 	"github.com/cozy/cozy-stack/web/errors"
 	"github.com/cozy/cozy-stack/web/files"
 	"github.com/cozy/cozy-stack/web/instances"
@@ -27,6 +28,7 @@ import (
 	"github.com/cozy/cozy-stack/web/move"
 	"github.com/cozy/cozy-stack/web/notes"
 	"github.com/cozy/cozy-stack/web/notifications"
+	"github.com/cozy/cozy-stack/web/ocm"
 	"github.com/cozy/cozy-stack/web/office"
 	"github.com/cozy/cozy-stack/web/oidc"
 	"github.com/cozy/cozy-stack/web/permissions"
@@ -202,9 +204,14 @@ func SetupRoutes(router *echo.Echo) error {
 		mws := append(mwsNotBlocked,
 			middlewares.CheckInstanceBlocked,
 			middlewares.CheckTOSDeadlineExpired)
+		// The app-specific passwords can be used on some routes, for the
+		// clients that can't use OAuth2
+		mwsAppPasswords := append([]echo.MiddlewareFunc{}, mws...)
+		mwsAppPasswords = append(mwsAppPasswords, middlewares.AcceptAppPasswords)
 		registry.Routes(router.Group("/registry", mws...))
-		data.Routes(router.Group("/data", mws...))
-		files.Routes(router.Group("/files", mws...))
+		data.Routes(router.Group("/data", mwsAppPasswords...))
+		files.Routes(router.Group("/files", mwsAppPasswords...))
+		dav.Routes(router.Group("/dav", mwsAppPasswords...))
 		contacts.Routes(router.Group("/contacts", mws...))
 		intents.Routes(router.Group("/intents", mws...))
 		jobs.Routes(router.Group("/jobs", mws...))
@@ -216,6 +223,9 @@ func SetupRoutes(router *echo.Echo) error {
 		office.Routes(router.Group("/office", mws...))
 		remote.Routes(router.Group("/remote", mws...))
 		sharings.Routes(router.Group("/sharings", mws...))
+		ocm.Routes(router.Group("/ocm", mws...))
+		router.GET("/ocm-provider", ocm.Discovery, mws...)
+		router.GET("/ocm-provider/", ocm.Discovery, mws...)
 		bitwarden.Routes(router.Group("/bitwarden", mws...))
 		shortcuts.Routes(router.Group("/shortcuts", mws...))
 
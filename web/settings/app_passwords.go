@@ -0,0 +1,109 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiAppPassword struct {
+	*instance.AppPassword
+	// Password is only sent when the app password is created
+	Password string `json:"password,omitempty"`
+}
+
+func (a *apiAppPassword) ID() string                             { return a.AppPassword.ID }
+func (a *apiAppPassword) Rev() string                            { return "" }
+func (a *apiAppPassword) DocType() string                        { return consts.AppPasswords }
+func (a *apiAppPassword) Clone() couchdb.Doc                     { return a }
+func (a *apiAppPassword) SetID(_ string)                         {}
+func (a *apiAppPassword) SetRev(_ string)                        {}
+func (a *apiAppPassword) Relationships() jsonapi.RelationshipMap { return nil }
+func (a *apiAppPassword) Included() []jsonapi.Object             { return nil }
+func (a *apiAppPassword) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/settings/app_passwords/" + a.ID()}
+}
+
+// MarshalJSON does not serialize the hash of the password
+func (a *apiAppPassword) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*instance.AppPassword
+		Hash     string `json:"hash,omitempty"`
+		Password string `json:"password,omitempty"`
+	}{a.AppPassword, "", a.Password})
+}
+
+func listAppPasswords(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+
+	objs := make([]jsonapi.Object, len(inst.AppPasswords))
+	for i := range inst.AppPasswords {
+		ap := inst.AppPasswords[i]
+		objs[i] = &apiAppPassword{AppPassword: &ap}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func createAppPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+
+	var attrs struct {
+		Name        string         `json:"name"`
+		Permissions permission.Set `json:"permissions"`
+	}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if attrs.Name == "" {
+		return jsonapi.InvalidParameter("name", errors.New("The name is mandatory"))
+	}
+	if len(attrs.Permissions) == 0 {
+		return jsonapi.InvalidParameter("permissions", errors.New("The permissions are mandatory"))
+	}
+	if err := attrs.Permissions.CheckFields(); err != nil {
+		return jsonapi.InvalidParameter("permissions", err)
+	}
+	// An app password cannot give more permissions than the caller has
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if !attrs.Permissions.IsSubSetOf(pdoc.Permissions) {
+		return jsonapi.Forbidden(errors.New("The permissions are not a subset of the permissions of the caller"))
+	}
+
+	ap, password := inst.GenerateAppPassword(attrs.Name, attrs.Permissions)
+	if err := inst.Update(); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiAppPassword{ap, password}, nil)
+}
+
+func revokeAppPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+
+	if err := inst.RemoveAppPassword(c.Param("id")); err != nil {
+		return jsonapi.NotFound(err)
+	}
+	if err := inst.Update(); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -18,6 +18,7 @@ type apiCapabilities struct {
 	FlatSubdomains bool   `json:"flat_subdomains"`
 	PasswordAuth   bool   `json:"can_auth_with_password"`
 	OIDCAuth       bool   `json:"can_auth_with_oidc"`
+	SAMLAuth       bool   `json:"can_auth_with_saml"`
 }
 
 func (c *apiCapabilities) ID() string                             { return c.DocID }
@@ -47,6 +48,7 @@ func NewCapabilities(inst *instance.Instance) jsonapi.Object {
 
 	password := inst.IsPasswordAuthenticationEnabled()
 	_, oidc := config.GetOIDC(inst.ContextName)
+	_, saml := config.GetSAML(inst.ContextName)
 
 	return &apiCapabilities{
 		DocID:          consts.CapabilitiesSettingsID,
@@ -54,6 +56,7 @@ func NewCapabilities(inst *instance.Instance) jsonapi.Object {
 		FlatSubdomains: flat,
 		PasswordAuth:   password,
 		OIDCAuth:       oidc,
+		SAMLAuth:       saml,
 	}
 }
 
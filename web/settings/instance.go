@@ -159,6 +159,12 @@ func updateInstanceAuthMode(c echo.Context) error {
 		if ok := inst.ValidateMailConfirmationCode(args.TwoFactorActivationCode); !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+	case instance.TwoFactorTOTP, instance.TwoFactorWebAuthn:
+		// The authenticator app or the security key must have been enrolled
+		// with the /settings/instance/two_factor routes
+		if err := inst.CheckSecondFactor(authMode); err != nil {
+			return c.NoContent(http.StatusUnprocessableEntity)
+		}
 	}
 
 	err = lifecycle.Patch(inst, &lifecycle.Options{AuthMode: args.AuthMode})
@@ -202,10 +202,10 @@ func updatePassphrase(c echo.Context) error {
 	}
 
 	// Else, we keep going on the standard checks (2FA, current passphrase, ...)
-	if inst.HasAuthMode(instance.TwoFactorMail) && len(args.TwoFactorToken) == 0 {
+	if inst.HasTwoFactor() && len(args.TwoFactorToken) == 0 {
 		if lifecycle.CheckPassphrase(inst, currentPassphrase) == nil {
 			var twoFactorToken []byte
-			twoFactorToken, err = lifecycle.SendTwoFactorPasscode(inst)
+			twoFactorToken, err = lifecycle.StartTwoFactor(inst)
 			if err != nil {
 				return err
 			}
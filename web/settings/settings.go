@@ -16,7 +16,8 @@ import (
 )
 
 type apiSession struct {
-	s *session.Session
+	s       *session.Session
+	current bool
 }
 
 func (s *apiSession) ID() string                             { return s.s.ID() }
@@ -28,7 +29,12 @@ func (s *apiSession) SetRev(_ string)                        {}
 func (s *apiSession) Relationships() jsonapi.RelationshipMap { return nil }
 func (s *apiSession) Included() []jsonapi.Object             { return nil }
 func (s *apiSession) Links() *jsonapi.LinksList              { return nil }
-func (s *apiSession) MarshalJSON() ([]byte, error)           { return json.Marshal(s.s) }
+func (s *apiSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*session.Session
+		Current bool `json:"current,omitempty"`
+	}{s.s, s.current})
+}
 
 func getSessions(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
@@ -42,14 +48,38 @@ func getSessions(c echo.Context) error {
 		return err
 	}
 
+	var currentID string
+	if current, ok := middlewares.GetSession(c); ok {
+		currentID = current.ID()
+	}
+
 	objs := make([]jsonapi.Object, len(sessions))
 	for i, s := range sessions {
-		objs[i] = &apiSession{s}
+		objs[i] = &apiSession{s, s.ID() == currentID}
 	}
 
 	return jsonapi.DataList(c, http.StatusOK, objs, nil)
 }
 
+// revokeSession logs out a device, by deleting its session.
+func revokeSession(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.Sessions); err != nil {
+		return err
+	}
+
+	sess, err := session.GetByID(inst, c.Param("id"))
+	if err != nil {
+		return jsonapi.NotFound(err)
+	}
+	cookie := sess.Delete(inst)
+	if current, ok := middlewares.GetSession(c); ok && current.ID() == sess.ID() {
+		c.SetCookie(cookie)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func warnings(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 
@@ -94,12 +124,25 @@ func Routes(router *echo.Group) {
 	router.GET("/instance", getInstance)
 	router.PUT("/instance", updateInstance)
 	router.PUT("/instance/auth_mode", updateInstanceAuthMode)
+	router.GET("/instance/two_factor", getTwoFactor)
+	router.POST("/instance/two_factor/totp", startTOTPEnrolment)
+	router.PUT("/instance/two_factor/totp", finishTOTPEnrolment)
+	router.DELETE("/instance/two_factor/totp", removeTOTP)
+	router.POST("/instance/two_factor/webauthn", startWebAuthnRegistration)
+	router.PUT("/instance/two_factor/webauthn", finishWebAuthnRegistration)
+	router.DELETE("/instance/two_factor/webauthn/:id", removeWebAuthnCredential)
+	router.POST("/instance/two_factor/recovery_codes", regenerateRecoveryCodes)
 	router.PUT("/instance/sign_tos", updateInstanceTOS)
 	router.DELETE("/instance/moved_from", clearMovedFrom)
 
 	router.GET("/flags", getFlags)
 
 	router.GET("/sessions", getSessions)
+	router.DELETE("/sessions/:id", revokeSession)
+
+	router.GET("/app_passwords", listAppPasswords)
+	router.POST("/app_passwords", createAppPassword)
+	router.DELETE("/app_passwords/:id", revokeAppPassword)
 
 	router.GET("/clients", listClients)
 	router.DELETE("/clients/:id", revokeClient)
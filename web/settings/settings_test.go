@@ -632,6 +632,34 @@ func TestRevokeClient(t *testing.T) {
 	assert.Len(t, data, 1)
 }
 
+func TestRevokeSession(t *testing.T) {
+	sess, err := session.New(testInstance, false, nil)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+
+	_, err = session.GetByID(testInstance, sess.ID())
+	assert.Equal(t, session.ErrInvalidID, err)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/settings/sessions/"+sess.ID(), nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
 func TestRedirectOnboardingSecret(t *testing.T) {
 	url := tsB.URL + "/settings/onboarded"
 
@@ -916,7 +944,7 @@ func TestMain(m *testing.M) {
 		Email:       "alice@example.com",
 		ContextName: "test-context",
 	})
-	scope := consts.Settings + " " + consts.OAuthClients
+	scope := consts.Settings + " " + consts.OAuthClients + " " + consts.Sessions
 	_, token = setup.GetTestClient(scope)
 
 	ts = setup.GetTestServer("/settings", Routes)
@@ -941,7 +969,7 @@ func TestMain(m *testing.M) {
 func fakeAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		instance := c.Get("instance").(*instance.Instance)
-		sess, _ := session.New(instance, true)
+		sess, _ := session.New(instance, true, nil)
 		c.Set("session", sess)
 		return next(c)
 	}
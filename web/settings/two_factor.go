@@ -0,0 +1,167 @@
+package settings
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiSecurityKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// getTwoFactor returns the second factors enrolled for the instance.
+func getTwoFactor(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+
+	keys := make([]apiSecurityKey, len(inst.WebAuthnCredentials))
+	for i, cred := range inst.WebAuthnCredentials {
+		keys[i] = apiSecurityKey{ID: cred.ID, Name: cred.Name, CreatedAt: cred.CreatedAt}
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"auth_mode":      instance.AuthModeToString(inst.AuthMode),
+		"totp":           len(inst.TOTPSecret) > 0,
+		"security_keys":  keys,
+		"recovery_codes": len(inst.RecoveryCodes),
+		"mail_fallback":  inst.HasTwoFactorMailFallback(),
+	})
+}
+
+// startTOTPEnrolment generates a secret for an authenticator app.
+func startTOTPEnrolment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	enrolment, err := inst.GenerateTOTPEnrolment()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, enrolment)
+}
+
+// finishTOTPEnrolment checks a passcode generated by the authenticator app,
+// and uses the authenticator app as the second factor.
+func finishTOTPEnrolment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	var args struct {
+		Token    string `json:"token"`
+		Passcode string `json:"passcode"`
+	}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadJSON()
+	}
+	secret, err := inst.ValidateTOTPEnrolment([]byte(args.Token), args.Passcode)
+	if err != nil {
+		return c.NoContent(http.StatusUnprocessableEntity)
+	}
+	codes, err := lifecycle.EnableTOTP(inst, secret)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
+
+// removeTOTP removes the authenticator app.
+func removeTOTP(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	if err := lifecycle.DisableTOTP(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// startWebAuthnRegistration returns the options for registering a new
+// security key with navigator.credentials.create.
+func startWebAuthnRegistration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	token, options, err := inst.GenerateWebAuthnRegistration()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"token":     token,
+		"publicKey": options,
+	})
+}
+
+// finishWebAuthnRegistration checks the credential created by the browser,
+// and uses the security keys as the second factor.
+func finishWebAuthnRegistration(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	var args struct {
+		Token      string                       `json:"token"`
+		Name       string                       `json:"name"`
+		Credential instance.WebAuthnAttestation `json:"credential"`
+	}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadJSON()
+	}
+	cred, err := inst.ValidateWebAuthnRegistration([]byte(args.Token), args.Name, &args.Credential)
+	if err != nil {
+		return jsonapi.InvalidParameter("credential", err)
+	}
+	codes, err := lifecycle.AddWebAuthnCredential(inst, cred)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"id":             cred.ID,
+		"recovery_codes": codes,
+	})
+}
+
+// removeWebAuthnCredential removes a security key.
+func removeWebAuthnCredential(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	if err := lifecycle.RemoveWebAuthnCredential(inst, c.Param("id")); err != nil {
+		if err == instance.ErrInvalidWebAuthn {
+			return jsonapi.NotFound(err)
+		}
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// regenerateRecoveryCodes replaces the recovery codes by new ones.
+func regenerateRecoveryCodes(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	codes, err := lifecycle.RegenerateRecoveryCodes(inst)
+	if err == instance.ErrNoSecondFactor {
+		return jsonapi.PreconditionFailed("recovery_codes", err)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
@@ -0,0 +1,99 @@
+package sharings
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// AddOCMRecipient sends the sharing to a user of an Open Cloud Mesh server
+// (on the sharer).
+func AddOCMRecipient(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	var body struct {
+		ShareWith string `json:"share_with"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err := s.AddOCMRecipient(inst, body.ShareWith); err != nil {
+		return wrapErrors(err)
+	}
+	if err := s.SendOCMShares(inst); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapiSharingWithDocs(c, s)
+}
+
+// AcceptOCMShare accepts a share received from an Open Cloud Mesh server (on
+// the recipient).
+func AcceptOCMShare(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	if err := s.AcceptOCMShare(inst); err != nil {
+		return wrapErrors(err)
+	}
+	return jsonapiSharingWithDocs(c, s)
+}
+
+// ListOCMShare lists the files of a directory inside a share received from
+// an Open Cloud Mesh server (on the recipient).
+func ListOCMShare(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return err
+	}
+	entries, err := s.ListOCMShare(inst, c.QueryParam("path"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// DownloadOCMFile sends the content of a file inside a share received from
+// an Open Cloud Mesh server (on the recipient).
+func DownloadOCMFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return err
+	}
+	res, err := s.OpenOCMFile(inst, c.QueryParam("path"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	defer res.Body.Close()
+	header := c.Response().Header()
+	for _, key := range []string{echo.HeaderContentType, echo.HeaderContentLength, "Etag", echo.HeaderLastModified} {
+		if value := res.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response(), res.Body)
+	return err
+}
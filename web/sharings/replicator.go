@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/web/middlewares"
@@ -86,6 +87,29 @@ func GetFolder(c echo.Context) error {
 	return c.JSON(http.StatusOK, folder)
 }
 
+// GetFileContent sends the content of a shared file. It is used by a
+// recipient with a selective sync to fetch the content of a placeholder.
+func GetFileContent(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Sharing was not found: %s", err)
+		return wrapErrors(err)
+	}
+	member, err := requestMember(c, s)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Member was not found: %s", err)
+		return wrapErrors(err)
+	}
+	file, err := s.GetFileContent(inst, member, c.Param("id"))
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("File was not found: %s", err)
+		return wrapErrors(err)
+	}
+	return vfs.ServeFileContent(inst.VFS(), file, nil, "", "attachment", c.Request(), c.Response())
+}
+
 // SyncFile will try to synchronize a file from just its metadata. If it's not
 // possible, it will respond with a key that allow to send the content to
 // finish the synchronization.
@@ -126,7 +150,8 @@ func FileHandler(c echo.Context) error {
 		inst.Logger().WithField("nspace", "replicator").Infof("Sharing was not found: %s", err)
 		return wrapErrors(err)
 	}
-	if err := s.HandleFileUpload(inst, c.Param("id"), c.Request().Body); err != nil {
+	delta := c.QueryParam("delta") == "true"
+	if err := s.HandleFileUpload(inst, c.Param("id"), c.Request().Body, delta); err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on file upload: %s", err)
 		return wrapErrors(err)
 	}
@@ -165,6 +190,7 @@ func replicatorRoutes(router *echo.Group) {
 	group.POST("/:sharing-id/_revs_diff", RevsDiff, checkSharingWritePermissions)
 	group.POST("/:sharing-id/_bulk_docs", BulkDocs, checkSharingWritePermissions)
 	group.GET("/:sharing-id/io.cozy.files/:id", GetFolder, checkSharingReadPermissions)
+	group.GET("/:sharing-id/io.cozy.files/:id/content", GetFileContent, checkSharingReadPermissions)
 	group.PUT("/:sharing-id/io.cozy.files/:id/metadata", SyncFile, checkSharingWritePermissions)
 	group.PUT("/:sharing-id/io.cozy.files/:id", FileHandler, checkSharingWritePermissions)
 	group.POST("/:sharing-id/reupload", ReuploadHandler, checkSharingReadPermissions)
@@ -0,0 +1,73 @@
+package sharings
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// GetSelectiveSync returns the selective sync settings of a sharing (on a
+// recipient).
+func GetSelectiveSync(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return err
+	}
+	if s.Owner {
+		return wrapErrors(sharing.ErrInvalidSharing)
+	}
+	sel := s.SelectiveSync
+	if sel == nil {
+		sel = &sharing.SelectiveSync{}
+	}
+	return c.JSON(http.StatusOK, sel)
+}
+
+// PutSelectiveSync changes the selective sync settings of a sharing (on a
+// recipient).
+func PutSelectiveSync(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	var sel sharing.SelectiveSync
+	if err := c.Bind(&sel); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err := s.UpdateSelectiveSync(inst, &sel); err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, s.SelectiveSync)
+}
+
+// FetchPlaceholder downloads the content of a file kept as a placeholder
+// from the owner's instance (on a recipient).
+func FetchPlaceholder(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = checkCreatePermissions(c, s); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	file, err := inst.VFS().FileByID(c.Param("file-id"))
+	if err != nil {
+		return wrapErrors(sharing.ErrMissingFileMetadata)
+	}
+	if err := s.FetchPlaceholder(inst, file); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 
@@ -648,6 +649,24 @@ func GetPreviewURL(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"url": previewURL})
 }
 
+// GetHealth returns a report on the state of the synchronization of the
+// sharing.
+func GetHealth(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return err
+	}
+	health, err := s.HealthReport(inst)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
 // GetAvatar returns the avatar of the given member of the sharing.
 func GetAvatar(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
@@ -724,13 +743,25 @@ func Routes(router *echo.Group) {
 	router.DELETE("/:sharing-id/answer", RevocationOwnerNotif, checkSharingWritePermissions)                 // On the sharer
 	router.POST("/:sharing-id/public-key", ReceivePublicKey)
 
+	// Selective sync
+	router.GET("/:sharing-id/selective-sync", GetSelectiveSync)           // On the recipient
+	router.PUT("/:sharing-id/selective-sync", PutSelectiveSync)           // On the recipient
+	router.POST("/:sharing-id/selective-sync/:file-id", FetchPlaceholder) // On the recipient
+
 	// Delegated routes for open sharing
 	router.POST("/:sharing-id/recipients/delegated", AddRecipientsDelegated, checkSharingWritePermissions)
 
+	// Open Cloud Mesh
+	router.POST("/:sharing-id/recipients/ocm", AddOCMRecipient) // On the sharer
+	router.POST("/:sharing-id/ocm/accept", AcceptOCMShare)      // On the recipient
+	router.GET("/:sharing-id/ocm/files", ListOCMShare)          // On the recipient
+	router.GET("/:sharing-id/ocm/download", DownloadOCMFile)    // On the recipient
+
 	// Misc
 	router.GET("/news", CountNewShortcuts)
 	router.GET("/doctype/:doctype", GetSharingsInfoByDocType)
 	router.GET("/:sharing-id/recipients/:index/avatar", GetAvatar)
+	router.GET("/:sharing-id/health", GetHealth)
 
 	// Register the URL of their Cozy for recipients
 	router.GET("/:sharing-id/discovery", GetDiscovery)
@@ -880,6 +911,16 @@ func wrapErrors(err error) error {
 		return jsonapi.BadRequest(err)
 	case sharing.ErrAlreadyAccepted:
 		return jsonapi.Conflict(err)
+	case sharing.ErrInvalidDelta:
+		return jsonapi.PreconditionFailed("delta", err)
+	case sharing.ErrInvalidCloudID:
+		return jsonapi.InvalidParameter("share_with", err)
+	case sharing.ErrOCMNotSupported:
+		return jsonapi.BadGateway(err)
+	case sharing.ErrInvalidOCMShare:
+		return jsonapi.BadRequest(err)
+	case os.ErrNotExist:
+		return jsonapi.NotFound(err)
 	case vfs.ErrInvalidHash:
 		return jsonapi.InvalidParameter("md5sum", err)
 	case vfs.ErrContentLengthMismatch:
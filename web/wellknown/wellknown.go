@@ -3,7 +3,9 @@ package wellknown
 import (
 	"net/http"
 
+	"github.com/cozy/cozy-stack/web/auth"
 	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/cozy/cozy-stack/web/ocm"
 	"github.com/labstack/echo/v4"
 )
 
@@ -15,8 +17,26 @@ func ChangePassword(c echo.Context) error {
 	return c.Redirect(http.StatusFound, inst.ChangePasswordURL())
 }
 
+// CardDAV is an handler that redirects the CardDAV clients to the root of
+// the DAV endpoints, where they can discover the address books.
+// See https://tools.ietf.org/html/rfc6764#section-5
+func CardDAV(c echo.Context) error {
+	return c.Redirect(http.StatusMovedPermanently, "/dav/")
+}
+
+// CalDAV is an handler that redirects the CalDAV clients to the root of the
+// DAV endpoints, where they can discover the calendars.
+// See https://tools.ietf.org/html/rfc6764#section-5
+func CalDAV(c echo.Context) error {
+	return c.Redirect(http.StatusMovedPermanently, "/dav/")
+}
+
 // Routes sets the routing for the status service
 func Routes(router *echo.Group) {
 	router.GET("/change-password", ChangePassword)
 	router.HEAD("/change-password", ChangePassword)
+	router.GET("/ocm", ocm.Discovery)
+	router.Any("/carddav", CardDAV)
+	router.Any("/caldav", CalDAV)
+	router.GET("/openid-configuration", auth.OpenIDConfiguration)
 }
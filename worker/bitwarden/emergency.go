@@ -0,0 +1,36 @@
+package bitwarden
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   bitwarden.EmergencyAccessWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Minute,
+		WorkerFunc:   WorkerApproveEmergencyAccess,
+	})
+}
+
+// WorkerApproveEmergencyAccess is used to approve the recovery of an
+// emergency access when the waiting period is over, if the grantor has not
+// rejected it.
+func WorkerApproveEmergencyAccess(ctx *job.WorkerContext) error {
+	var msg bitwarden.EmergencyAccessMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	err := bitwarden.ApproveExpiredEmergencyAccess(ctx.Instance, msg.EmergencyAccessID)
+	if err != nil {
+		ctx.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot approve emergency access %s: %s", msg.EmergencyAccessID, err)
+	}
+	return err
+}
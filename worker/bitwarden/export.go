@@ -0,0 +1,36 @@
+package bitwarden
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   bitwarden.ExportWorker,
+		Concurrency:  1,
+		MaxExecCount: 1,
+		Reserved:     true,
+		Timeout:      5 * time.Minute,
+		WorkerFunc:   WorkerExport,
+	})
+}
+
+// WorkerExport is used to write an encrypted export of the vault in the VFS.
+func WorkerExport(ctx *job.WorkerContext) error {
+	var msg bitwarden.ExportMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	doc, err := bitwarden.ExportVault(ctx.Instance, msg.EncKeyValidation)
+	if err != nil {
+		ctx.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot export the vault: %s", err)
+		return err
+	}
+	ctx.Logger().WithField("nspace", "bitwarden").
+		Infof("Vault exported in %s", doc.ID())
+	return nil
+}
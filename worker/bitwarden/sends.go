@@ -0,0 +1,35 @@
+package bitwarden
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   bitwarden.SendDeletionWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Minute,
+		WorkerFunc:   WorkerDeleteSend,
+	})
+}
+
+// WorkerDeleteSend is used to delete a Bitwarden send (and the encrypted
+// content of its file) when its deletion date is reached.
+func WorkerDeleteSend(ctx *job.WorkerContext) error {
+	var msg bitwarden.SendDeletionMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	err := bitwarden.DeleteExpiredSend(ctx.Instance, msg.SendID)
+	if err != nil {
+		ctx.Logger().WithField("nspace", "bitwarden").
+			Warnf("Cannot delete send %s: %s", msg.SendID, err)
+	}
+	return err
+}
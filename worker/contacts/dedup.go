@@ -0,0 +1,37 @@
+package contacts
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   contact.DedupWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      5 * time.Minute,
+		WorkerFunc:   WorkerDedup,
+	})
+}
+
+// WorkerDedup is used to detect the contacts that are probably duplicates,
+// and to create suggestions for merging them. It is triggered when contacts
+// are created or updated, with a debounce, as the konnectors and sharings
+// often create many contacts at once.
+func WorkerDedup(ctx *job.WorkerContext) error {
+	log := ctx.Instance.Logger().WithField("nspace", "contacts")
+	created, err := contact.DetectDuplicates(ctx.Instance)
+	if err != nil {
+		log.Warnf("Cannot detect the duplicate contacts: %s", err)
+		return err
+	}
+	if created > 0 {
+		log.Infof("%d new suggestions of duplicate contacts", created)
+	}
+	return nil
+}
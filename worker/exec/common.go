@@ -11,6 +11,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/metrics"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -77,10 +78,29 @@ func worker(ctx *job.WorkerContext) (err error) {
 		return err
 	}
 
+	// The konnectors and services shipped as a WebAssembly module are executed
+	// inside the stack.
+	if ww, ok := worker.(wasmWorker); ok && ww.WasmModule() != "" {
+		return runWasm(ctx, worker, ww, workDir, env)
+	}
+
 	var stderrBuf bytes.Buffer
 	cmd := CreateCmd(cmdStr, workDir)
 	cmd.Env = env
 
+	// The konnectors can be run in the built-in sandbox
+	var sb *sandbox
+	if sw, ok := worker.(sandboxedWorker); ok && config.GetConfig().Konnectors.Sandbox.Enabled {
+		if sb, err = newSandbox(ctx.Instance, sw.AllowedHosts(ctx.Instance)); err != nil {
+			worker.Logger(ctx).Errorf("Sandbox: %s", err)
+			return err
+		}
+		defer sb.Close()
+		if err = sb.Wrap(cmd, workDir); err != nil {
+			return err
+		}
+	}
+
 	// set stderr writable with a bytes.Buffer limited total size of 256Ko
 	cmd.Stderr = utils.LimitWriterDiscard(&stderrBuf, 256*1024)
 
@@ -117,6 +137,12 @@ func worker(ctx *job.WorkerContext) (err error) {
 	if err = cmd.Start(); err != nil {
 		return wrapErr(ctx, err)
 	}
+	if sb != nil {
+		if err = sb.Started(cmd); err != nil {
+			_ = cmd.Wait()
+			return err
+		}
+	}
 
 	waitDone := make(chan error)
 	go func() {
@@ -140,7 +166,16 @@ func worker(ctx *job.WorkerContext) (err error) {
 		<-waitDone
 	}
 
-	return worker.Error(ctx.Instance, err)
+	err = worker.Error(ctx.Instance, err)
+	if sb != nil {
+		ctx.SetResourceUsage(sb.Usage(cmd))
+		if violation := sb.Violation(cmd, err); violation != nil {
+			log.Warnf("Sandbox violation: %s", violation)
+			ctx.SetNoRetry()
+			err = violation
+		}
+	}
+	return err
 }
 
 func commit(ctx *job.WorkerContext, errjob error) error {
@@ -10,6 +10,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/app"
@@ -39,6 +40,9 @@ type konnectorWorker struct {
 	slug string
 	msg  *KonnectorMessage
 	man  *app.KonnManifest
+	wasm string
+
+	report *runReport
 
 	err     error
 	lastErr error
@@ -171,6 +175,7 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 	// Reset the errors from previous runs on retries
 	w.err = nil
 	w.lastErr = nil
+	w.wasm = ""
 
 	var err error
 	var data json.RawMessage
@@ -187,6 +192,11 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 	w.slug = slug
 	w.msg = &msg
 
+	// The report is shared by all the executions of the job
+	if w.report == nil {
+		w.report = newRunReport(ctx, i, slug, msg.Account)
+	}
+
 	w.man, err = app.GetKonnectorBySlugAndUpdate(i, slug,
 		app.Copier(consts.KonnectorType, i), i.Registries())
 	if err == app.ErrNotFound {
@@ -265,6 +275,13 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 		workDir = path.Join(workDir, fileExecPath)
 	}
 
+	if man.Language() == wasmLanguage {
+		w.wasm = workDir
+		if !w.msg.AccountDeleted {
+			w.wasm = path.Join(workDir, wasmModuleFile)
+		}
+	}
+
 	return workDir, cleanDir, nil
 }
 
@@ -533,6 +550,29 @@ func (w *konnectorWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Inst
 	return
 }
 
+// AllowedHosts returns the hosts that the konnector can reach when it runs in
+// the sandbox: the hosts declared in its manifest, and the instance.
+func (w *konnectorWorker) AllowedHosts(i *instance.Instance) []string {
+	return append(instanceHosts(i), w.man.AllowedHosts()...)
+}
+
+// WasmModule returns the path of the WebAssembly module of the konnector, if
+// its language is "wasm".
+func (w *konnectorWorker) WasmModule() string {
+	return w.wasm
+}
+
+// WasmPermissions returns the permissions of the konnector.
+func (w *konnectorWorker) WasmPermissions(i *instance.Instance) (*permission.Permission, error) {
+	return permission.GetForKonnector(i, w.slug)
+}
+
+// FetchHosts returns the hosts that a WebAssembly konnector can reach: the
+// same as in the sandbox.
+func (w *konnectorWorker) FetchHosts(i *instance.Instance) []string {
+	return w.AllowedHosts(i)
+}
+
 func (w *konnectorWorker) Logger(ctx *job.WorkerContext) *logrus.Entry {
 	return ctx.Logger().WithField("slug", w.slug)
 }
@@ -542,16 +582,36 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 		Type    string `json:"type"`
 		Message string `json:"message"`
 		NoRetry bool   `json:"no_retry"`
+
+		// For the HTTP calls
+		Method   string `json:"method"`
+		URL      string `json:"url"`
+		Status   int    `json:"status"`
+		Duration int64  `json:"duration"` // in milliseconds
 	}
 	if err := json.Unmarshal(line, &msg); err != nil {
 		return fmt.Errorf("Could not parse stdout as JSON: %q", string(line))
 	}
 
+	if msg.Type == konnectorMsgTypeHTTP {
+		w.ReportHTTPCall(job.ReportHTTPCall{
+			Method:   msg.Method,
+			URL:      msg.URL,
+			Status:   msg.Status,
+			Duration: time.Duration(msg.Duration) * time.Millisecond,
+		})
+		return nil
+	}
+
 	// Truncate very long messages
 	if len(msg.Message) > 4000 {
 		msg.Message = msg.Message[:4000]
 	}
 
+	if w.report != nil {
+		w.report.Log(msg.Type, msg.Message)
+	}
+
 	log := w.Logger(ctx)
 	switch msg.Type {
 	case konnectorMsgTypeDebug, konnectorMsgTypeInfo:
@@ -582,6 +642,13 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 	return nil
 }
 
+// ReportHTTPCall adds an HTTP call made by the konnector to its report.
+func (w *konnectorWorker) ReportHTTPCall(call job.ReportHTTPCall) {
+	if w.report != nil {
+		w.report.HTTPCall(call)
+	}
+}
+
 func (w *konnectorWorker) Error(i *instance.Instance, err error) error {
 	if w.err != nil {
 		return w.err
@@ -605,5 +672,10 @@ func (w *konnectorWorker) Commit(ctx *job.WorkerContext, errjob error) error {
 	} else {
 		log.Infof("Konnector failure: %s", errjob)
 	}
+	if w.report != nil {
+		if err := w.report.Save(ctx.Instance, errjob); err != nil {
+			log.Warnf("Cannot save the report: %s", err)
+		}
+	}
 	return nil
 }
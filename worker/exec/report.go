@@ -0,0 +1,184 @@
+package exec
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// konnectorMsgTypeHTTP is the type of the messages written on stdout by a
+// konnector to report an HTTP call. They are not logs.
+const konnectorMsgTypeHTTP = "http"
+
+// httpReporter is implemented by the workers that keep a report of the HTTP
+// calls made by the executed code.
+type httpReporter interface {
+	ReportHTTPCall(call job.ReportHTTPCall)
+}
+
+// runReport collects the events of a konnector execution (logs, HTTP calls,
+// files and bills saved) to build its job.Report. The files and bills are
+// detected via the realtime events of the instance.
+type runReport struct {
+	mu     sync.Mutex
+	report *job.Report
+	files  map[string]struct{}
+	bills  map[string]struct{}
+	sub    *realtime.DynamicSubscriber
+	done   chan struct{}
+}
+
+func newRunReport(ctx *job.WorkerContext, i *instance.Instance, slug, accountID string) *runReport {
+	triggerID, _ := ctx.TriggerID()
+	r := &runReport{
+		report: &job.Report{
+			DocID:      ctx.JobID(),
+			WorkerType: ctx.WorkerType(),
+			Slug:       slug,
+			TriggerID:  triggerID,
+			AccountID:  accountID,
+			Manual:     ctx.Manual(),
+			StartedAt:  time.Now(),
+		},
+		files: make(map[string]struct{}),
+		bills: make(map[string]struct{}),
+		done:  make(chan struct{}),
+	}
+	r.sub = realtime.GetHub().Subscriber(i)
+	_ = r.sub.Subscribe(consts.Files)
+	_ = r.sub.Subscribe(consts.Bills)
+	go r.watch()
+	return r
+}
+
+func (r *runReport) watch() {
+	defer close(r.done)
+	for e := range r.sub.Channel {
+		if e.Verb != realtime.EventCreate && e.Verb != realtime.EventUpdate {
+			continue
+		}
+		doc, err := json.Marshal(e.Doc)
+		if err != nil {
+			continue
+		}
+		var infos struct {
+			ID       string `json:"_id"`
+			Type     string `json:"type"`
+			Metadata struct {
+				CreatedByApp  string `json:"createdByApp"`
+				UpdatedByApps []struct {
+					Slug string    `json:"slug"`
+					Date time.Time `json:"date"`
+				} `json:"updatedByApps"`
+			} `json:"cozyMetadata"`
+		}
+		if err := json.Unmarshal(doc, &infos); err != nil || infos.ID == "" {
+			continue
+		}
+		created := e.Verb == realtime.EventCreate &&
+			infos.Metadata.CreatedByApp == r.report.Slug
+		updated := false
+		if e.Verb == realtime.EventUpdate {
+			for _, app := range infos.Metadata.UpdatedByApps {
+				if app.Slug == r.report.Slug && !app.Date.Before(r.report.StartedAt) {
+					updated = true
+				}
+			}
+		}
+		if !created && !updated {
+			continue
+		}
+		switch e.Doc.DocType() {
+		case consts.Files:
+			if infos.Type == consts.FileType {
+				r.addFile(infos.ID, created)
+			}
+		case consts.Bills:
+			r.mu.Lock()
+			r.bills[infos.ID] = struct{}{}
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *runReport) addFile(id string, created bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.files[id]; ok {
+		return
+	}
+	r.files[id] = struct{}{}
+	if created {
+		r.report.FilesCreated = r.appendID(r.report.FilesCreated, id)
+	} else {
+		r.report.FilesUpdated = r.appendID(r.report.FilesUpdated, id)
+	}
+}
+
+func (r *runReport) appendID(ids []string, id string) []string {
+	if len(ids) >= job.MaxReportEntries {
+		r.report.Truncated = true
+		return ids
+	}
+	return append(ids, id)
+}
+
+// Log adds a log line to the report.
+func (r *runReport) Log(level, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.report.Logs) >= job.MaxReportEntries {
+		r.report.Truncated = true
+		return
+	}
+	r.report.Logs = append(r.report.Logs, job.ReportLog{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+	})
+}
+
+// HTTPCall adds an HTTP call to the report.
+func (r *runReport) HTTPCall(call job.ReportHTTPCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.report.HTTPCalls) >= job.MaxReportEntries {
+		r.report.Truncated = true
+		return
+	}
+	if call.Time.IsZero() {
+		call.Time = time.Now()
+	}
+	r.report.HTTPCalls = append(r.report.HTTPCalls, call)
+}
+
+// Save persists the report with the final state of the job.
+func (r *runReport) Save(i *instance.Instance, errjob error) error {
+	return r.finish(errjob).Save(i)
+}
+
+// finish stops watching the realtime events, and fills the report with the
+// final state of the job.
+func (r *runReport) finish(errjob error) *job.Report {
+	_ = r.sub.Close()
+	<-r.done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report
+	report.FinishedAt = time.Now()
+	report.Duration = report.FinishedAt.Sub(report.StartedAt)
+	report.BillsSaved = len(r.bills)
+	if errjob == nil {
+		report.State = job.Done
+	} else {
+		report.State = job.Errored
+		report.Error = errjob.Error()
+	}
+	return report
+}
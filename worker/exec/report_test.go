@@ -0,0 +1,60 @@
+package exec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunReport(t *testing.T) {
+	db := &instance.Instance{Domain: "report.cozy.localhost"}
+	j := &job.Job{JobID: "job-1", WorkerType: "konnector", TriggerID: "trigger-1"}
+	ctx := job.NewWorkerContext("worker-1", j, db)
+	r := newRunReport(ctx, db, "konn", "account-1")
+
+	r.Log("info", "Starting")
+	r.Log("critical", "LOGIN_FAILED")
+	r.HTTPCall(job.ReportHTTPCall{Method: "GET", URL: "https://example.com/", Status: 200})
+
+	hub := realtime.GetHub()
+	created := &vfs.FileDoc{DocID: "file-1", Type: consts.FileType, CozyMetadata: vfs.NewCozyMetadata("")}
+	created.CozyMetadata.CreatedByApp = "konn"
+	hub.Publish(db, realtime.EventCreate, created, nil)
+	updated := &vfs.FileDoc{DocID: "file-2", Type: consts.FileType, CozyMetadata: vfs.NewCozyMetadata("")}
+	updated.CozyMetadata.UpdatedByApp(&metadata.UpdatedByAppEntry{Slug: "konn", Date: time.Now()})
+	hub.Publish(db, realtime.EventUpdate, updated, nil)
+	other := &vfs.FileDoc{DocID: "file-3", Type: consts.FileType, CozyMetadata: vfs.NewCozyMetadata("")}
+	other.CozyMetadata.CreatedByApp = "drive"
+	hub.Publish(db, realtime.EventCreate, other, nil)
+	bill := &couchdb.JSONDoc{Type: consts.Bills, M: map[string]interface{}{
+		"_id":          "bill-1",
+		"cozyMetadata": map[string]interface{}{"createdByApp": "konn"},
+	}}
+	hub.Publish(db, realtime.EventCreate, bill, nil)
+
+	// Wait for the realtime events to be received
+	time.Sleep(100 * time.Millisecond)
+	report := r.finish(errors.New("LOGIN_FAILED"))
+
+	assert.Equal(t, "job-1", report.ID())
+	assert.Equal(t, "trigger-1", report.TriggerID)
+	assert.Equal(t, "account-1", report.AccountID)
+	assert.Equal(t, job.Errored, report.State)
+	assert.Equal(t, "LOGIN_FAILED", report.Error)
+	assert.Len(t, report.Logs, 2)
+	assert.Equal(t, "critical", report.Logs[1].Level)
+	assert.Len(t, report.HTTPCalls, 1)
+	assert.Equal(t, []string{"file-1"}, report.FilesCreated)
+	assert.Equal(t, []string{"file-2"}, report.FilesUpdated)
+	assert.Equal(t, 1, report.BillsSaved)
+	assert.True(t, report.Duration > 0)
+}
@@ -0,0 +1,263 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+)
+
+var (
+	// ErrSandboxUnavailable is used when the sandbox is enabled but can't be
+	// used on this platform.
+	ErrSandboxUnavailable = errors.New("The konnectors sandbox is only available on Linux")
+	// ErrSandboxMemoryLimit is used when a konnector has been killed because
+	// it has used too much memory.
+	ErrSandboxMemoryLimit = errors.New("SANDBOX_VIOLATION.MEMORY_LIMIT")
+	// ErrSandboxPidsLimit is used when a konnector has tried to create more
+	// processes or threads than allowed.
+	ErrSandboxPidsLimit = errors.New("SANDBOX_VIOLATION.PIDS_LIMIT")
+	// ErrSandboxForbiddenSyscall is used when a konnector has been killed
+	// because it has made a syscall forbidden by the seccomp filter.
+	ErrSandboxForbiddenSyscall = errors.New("SANDBOX_VIOLATION.FORBIDDEN_SYSCALL")
+	// ErrSandboxNetworkEgress is used when a konnector has failed after trying
+	// to reach a host that is not in its allow-list.
+	ErrSandboxNetworkEgress = errors.New("SANDBOX_VIOLATION.NETWORK_EGRESS")
+)
+
+// sandboxProxyPort is the port of the HTTP proxy inside the network namespace
+// of the sandbox.
+const sandboxProxyPort = "3128"
+
+// The environment variables used to configure the sandbox init process. They
+// are removed before the konnector is launched.
+const (
+	sandboxEnvWorkDir = "COZY_SANDBOX_WORKDIR"
+	sandboxEnvProxy   = "COZY_SANDBOX_PROXY"
+	sandboxEnvHidden  = "COZY_SANDBOX_HIDDEN"
+)
+
+// The names used as argv[0] when the stack re-executes itself inside the
+// sandbox.
+const (
+	sandboxInitArg = "cozy-sandbox-init"
+	sandboxExecArg = "cozy-sandbox-exec"
+)
+
+// sandboxedWorker is implemented by the workers that can be run inside the
+// sandbox.
+type sandboxedWorker interface {
+	AllowedHosts(i *instance.Instance) []string
+}
+
+// hostAllowed returns true if the host (with an optional port) matches one of
+// the allowed hosts. An allowed host can start with "*." to match all the
+// subdomains of a domain.
+func hostAllowed(host string, allowed []string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return false
+	}
+	for _, pattern := range allowed {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceHosts returns the hosts of the instance. A konnector can always
+// reach them, even if they resolve to a private address.
+func instanceHosts(i *instance.Instance) []string {
+	hosts := []string{i.ContextualDomain()}
+	if u, err := url.Parse(i.PageURL("/", nil)); err == nil {
+		hosts = append(hosts, u.Host)
+	}
+	return hosts
+}
+
+// publicOnly is used as the Control function of the dialers, to refuse the
+// connections to the loopback, private and link-local addresses. It is called
+// after the resolution of the hostname.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !safehttp.IsPublicIP(ip) {
+		return safehttp.ErrForbiddenAddress
+	}
+	return nil
+}
+
+// egressProxy is an HTTP proxy that only allows requests to some hosts. It is
+// the only way for a konnector in the sandbox to reach the network. The
+// allowed hosts are chosen by the konnectors, and so they can't be reached if
+// they resolve to a private address, except for the trusted hosts (the
+// instance).
+type egressProxy struct {
+	allowed   []string
+	trusted   []string
+	server    *http.Server
+	transport *http.Transport
+
+	mu     sync.Mutex
+	denied map[string]struct{}
+}
+
+func newEgressProxy(l net.Listener, allowed, trusted []string) *egressProxy {
+	p := &egressProxy{
+		allowed: allowed,
+		trusted: trusted,
+		denied:  make(map[string]struct{}),
+	}
+	p.transport = &http.Transport{
+		Proxy:                 nil,
+		DialContext:           p.dialContext,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	p.server = &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() { _ = p.server.Serve(l) }()
+	return p
+}
+
+// DeniedHosts returns the hosts that the konnector has tried to reach without
+// being allowed to.
+func (p *egressProxy) DeniedHosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hosts := make([]string, 0, len(p.denied))
+	for host := range p.denied {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (p *egressProxy) Close() error {
+	p.transport.CloseIdleConnections()
+	return p.server.Close()
+}
+
+// dialContext opens a connection to an allowed host. Only the trusted hosts
+// can be reached on a private address.
+func (p *egressProxy) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !hostAllowed(address, p.trusted) {
+		dialer.Control = publicOnly
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if r.Method != http.MethodConnect {
+		host = r.URL.Host
+	}
+	if !hostAllowed(host, p.allowed) {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		p.mu.Lock()
+		p.denied[host] = struct{}{}
+		p.mu.Unlock()
+		http.Error(w, "Host not allowed by the sandbox", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+	} else {
+		p.forward(w, r)
+	}
+}
+
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	go pipe(conn, upstream)
+}
+
+func (p *egressProxy) forward(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Scheme != "http" {
+		http.Error(w, "Unsupported scheme", http.StatusBadRequest)
+		return
+	}
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	res, err := p.transport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	for k, values := range res.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(w, res.Body)
+}
+
+// pipe copies the data in both directions between the two connections, and
+// closes them when one side has finished.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,503 @@
+package exec
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+)
+
+// When the stack re-executes itself inside the sandbox, the main function of
+// the stack must not be called.
+func init() {
+	switch os.Args[0] {
+	case sandboxInitArg:
+		os.Exit(sandboxInit())
+	case sandboxExecArg:
+		os.Exit(sandboxExec())
+	}
+}
+
+// sandbox runs a konnector with its own namespaces (user, mount, pid, network,
+// uts and ipc), a seccomp filter, and the limits of a cgroup v2. The only way
+// for the konnector to reach the network is an HTTP proxy that checks the
+// hosts against an allow-list.
+type sandbox struct {
+	conf   config.KonnectorsSandbox
+	dir    string
+	cgroup string
+	proxy  *egressProxy
+	ready  *os.File
+	signal *os.File
+}
+
+func newSandbox(inst *instance.Instance, allowed []string) (*sandbox, error) {
+	sb := &sandbox{conf: config.GetConfig().Konnectors.Sandbox}
+	dir, err := ioutil.TempDir("", "konnector-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	sb.dir = dir
+	l, err := net.Listen("unix", filepath.Join(dir, "proxy.sock"))
+	if err != nil {
+		sb.Close()
+		return nil, err
+	}
+	hosts := append([]string{}, allowed...)
+	hosts = append(hosts, sb.conf.AllowedHosts...)
+	sb.proxy = newEgressProxy(l, hosts, instanceHosts(inst))
+	if sb.conf.CgroupDir != "" {
+		if err := sb.createCgroup(); err != nil {
+			sb.Close()
+			return nil, err
+		}
+	}
+	return sb, nil
+}
+
+func (sb *sandbox) createCgroup() error {
+	name := "konnector-" + hex.EncodeToString(crypto.GenerateRandomBytes(8))
+	dir := filepath.Join(sb.conf.CgroupDir, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	sb.cgroup = dir
+	limits := map[string]string{}
+	if sb.conf.CPU > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d 100000", int(sb.conf.CPU*100000))
+	}
+	if sb.conf.Memory > 0 {
+		limits["memory.max"] = strconv.FormatUint(uint64(sb.conf.Memory), 10)
+		limits["memory.swap.max"] = "0"
+	}
+	if sb.conf.Pids > 0 {
+		limits["pids.max"] = strconv.Itoa(sb.conf.Pids)
+	}
+	for file, value := range limits {
+		err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+		// The swap can be disabled on the host
+		if err != nil && file != "memory.swap.max" {
+			return fmt.Errorf("cannot set %s for the sandbox: %s", file, err)
+		}
+	}
+	return nil
+}
+
+// Wrap changes the command to launch it in the sandbox: the stack is executed
+// with a special argv[0] to setup the sandbox, and it will then execute the
+// konnector.
+func (sb *sandbox) Wrap(cmd *exec.Cmd, workDir string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	sb.ready, sb.signal = r, w
+
+	if info, err := os.Stat(workDir); err == nil && !info.IsDir() {
+		workDir = filepath.Dir(workDir)
+	}
+	hidden := append([]string{}, sb.conf.HiddenPaths...)
+	for _, file := range config.GetConfig().SecretFiles {
+		if abs, err := filepath.Abs(file); err == nil {
+			hidden = append(hidden, abs)
+		}
+	}
+	if fsURL := config.FsURL(); fsURL.Scheme == config.SchemeFile {
+		hidden = append(hidden, fsURL.Path)
+	}
+	args := append([]string{sandboxInitArg, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = args
+	cmd.Env = append(cmd.Env,
+		sandboxEnvWorkDir+"="+workDir,
+		sandboxEnvProxy+"="+filepath.Join(sb.dir, "proxy.sock"),
+		sandboxEnvHidden+"="+strings.Join(hidden, string(filepath.ListSeparator)),
+	)
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	return nil
+}
+
+// Started must be called when the command has been started: the process is
+// put in the cgroup before it can launch the konnector.
+func (sb *sandbox) Started(cmd *exec.Cmd) error {
+	sb.ready.Close()
+	defer sb.signal.Close()
+	if sb.cgroup != "" {
+		pid := strconv.Itoa(cmd.Process.Pid)
+		procs := filepath.Join(sb.cgroup, "cgroup.procs")
+		if err := ioutil.WriteFile(procs, []byte(pid), 0644); err != nil {
+			_ = KillCmd(cmd)
+			return fmt.Errorf("cannot put the konnector in its cgroup: %s", err)
+		}
+	}
+	_, err := sb.signal.Write([]byte{1})
+	return err
+}
+
+// Usage returns the resources used by the konnector. The values of the cgroup
+// are used if possible, and the rusage of the process otherwise.
+func (sb *sandbox) Usage(cmd *exec.Cmd) *job.ResourceUsage {
+	usage := &job.ResourceUsage{DeniedHosts: sb.proxy.DeniedHosts()}
+	if state := cmd.ProcessState; state != nil {
+		usage.CPUTime = state.UserTime() + state.SystemTime()
+		if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+			usage.MemoryPeak = uint64(rusage.Maxrss) * 1024
+		}
+	}
+	if sb.cgroup == "" {
+		return usage
+	}
+	if usec, ok := readCgroupKey(sb.cgroup, "cpu.stat", "usage_usec"); ok {
+		usage.CPUTime = time.Duration(usec) * time.Microsecond
+	}
+	if peak, ok := readCgroupValue(sb.cgroup, "memory.peak"); ok {
+		usage.MemoryPeak = peak
+	}
+	if peak, ok := readCgroupValue(sb.cgroup, "pids.peak"); ok {
+		usage.PidsPeak = peak
+	}
+	return usage
+}
+
+// Violation returns an error if the konnector has failed because of the
+// limits of the sandbox.
+func (sb *sandbox) Violation(cmd *exec.Cmd, err error) error {
+	if err == nil {
+		return nil
+	}
+	if sb.cgroup != "" {
+		if n, ok := readCgroupKey(sb.cgroup, "memory.events", "oom_kill"); ok && n > 0 {
+			return ErrSandboxMemoryLimit
+		}
+		if n, ok := readCgroupKey(sb.cgroup, "pids.events", "max"); ok && n > 0 {
+			return ErrSandboxPidsLimit
+		}
+	}
+	if state := cmd.ProcessState; state != nil && state.ExitCode() == 128+int(syscall.SIGSYS) {
+		return ErrSandboxForbiddenSyscall
+	}
+	if len(sb.proxy.DeniedHosts()) > 0 {
+		return ErrSandboxNetworkEgress
+	}
+	return nil
+}
+
+// Close removes the cgroup and stops the proxy.
+func (sb *sandbox) Close() {
+	if sb.proxy != nil {
+		_ = sb.proxy.Close()
+	}
+	if sb.dir != "" {
+		_ = os.RemoveAll(sb.dir)
+	}
+	if sb.cgroup != "" {
+		_ = ioutil.WriteFile(filepath.Join(sb.cgroup, "cgroup.kill"), []byte("1"), 0644)
+		// The processes can take a few milliseconds to exit after the kill
+		for i := 0; i < 10; i++ {
+			if err := os.Remove(sb.cgroup); err == nil || os.IsNotExist(err) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func readCgroupValue(dir, file string) (uint64, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return n, err == nil
+}
+
+func readCgroupKey(dir, file, key string) (uint64, bool) {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseUint(fields[1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// sandboxInit is executed as the PID 1 of the sandbox. It setups the mounts,
+// the network, and launches the konnector. It stays alive to forward the
+// connections to the proxy, and to reap the zombie processes.
+func sandboxInit() int {
+	// Wait that the stack has put this process in the cgroup
+	ready := os.NewFile(3, "ready")
+	buf := make([]byte, 1)
+	if n, _ := ready.Read(buf); n != 1 {
+		return 1
+	}
+	ready.Close()
+
+	workDir := os.Getenv(sandboxEnvWorkDir)
+	proxy := os.Getenv(sandboxEnvProxy)
+	hidden := filepath.SplitList(os.Getenv(sandboxEnvHidden))
+	if err := setupSandboxMounts(workDir, hidden); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: cannot setup the mounts: %s\n", err)
+		return 1
+	}
+	_ = syscall.Sethostname([]byte("konnector"))
+	if err := setLoopbackUp(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: cannot setup the network: %s\n", err)
+		return 1
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+sandboxProxyPort)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: cannot listen for the proxy: %s\n", err)
+		return 1
+	}
+	go forwardToProxy(l, proxy)
+
+	proxyURL := "http://127.0.0.1:" + sandboxProxyPort
+	env := []string{
+		"HTTP_PROXY=" + proxyURL,
+		"HTTPS_PROXY=" + proxyURL,
+		"http_proxy=" + proxyURL,
+		"https_proxy=" + proxyURL,
+		"TMPDIR=" + workDir,
+	}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		switch strings.ToUpper(name) {
+		case sandboxEnvWorkDir, sandboxEnvProxy, sandboxEnvHidden, "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "TMPDIR":
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = append([]string{sandboxExecArg}, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: cannot start the konnector: %s\n", err)
+		return 1
+	}
+	return reapUntil(cmd.Process.Pid)
+}
+
+// sandboxExec drops the capabilities, installs the seccomp filter and
+// executes the konnector.
+func sandboxExec() int {
+	runtime.LockOSThread()
+	if len(os.Args) < 2 {
+		return 1
+	}
+	// Drop all the capabilities from the bounding set, so that the konnector
+	// has no capability, even in its user namespace.
+	for c := 0; c < 64; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0); errno == syscall.EINVAL {
+			break
+		}
+	}
+	if err := installSeccompFilter(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: cannot install the seccomp filter: %s\n", err)
+		return 1
+	}
+	err := syscall.Exec(os.Args[1], os.Args[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: cannot execute %s: %s\n", os.Args[1], err)
+	return 1
+}
+
+// reapUntil waits for the processes of the sandbox, and returns the exit code
+// of the konnector when it has finished. When the konnector is killed by a
+// signal, the exit code is 128 + the signal number, like for a shell.
+func reapUntil(pid int) int {
+	for {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 1
+		}
+		if wpid != pid {
+			continue
+		}
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+}
+
+// forwardToProxy forwards the TCP connections from inside the network
+// namespace to the unix socket of the proxy of the stack.
+func forwardToProxy(l net.Listener, proxy string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			upstream, err := net.Dial("unix", proxy)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			pipe(conn, upstream)
+		}()
+	}
+}
+
+// The flags of statfs that must be kept when a file system is remounted
+// read-only.
+const (
+	stRelatime    = 0x1000
+	msLockedFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME
+)
+
+// setupSandboxMounts makes the file systems read-only, except for the work
+// directory of the konnector, hides some paths, and mounts a new /proc for the
+// pid namespace.
+func setupSandboxMounts(workDir string, hidden []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	if err := syscall.Mount(workDir, workDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+	points, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, point := range points {
+		if point == workDir || point == "/proc" || strings.HasPrefix(point, "/proc/") {
+			continue
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(point, &st); err != nil {
+			continue
+		}
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) |
+			uintptr(st.Flags)&msLockedFlags
+		if st.Flags&stRelatime != 0 {
+			flags |= syscall.MS_RELATIME
+		} else if st.Flags&(syscall.MS_NOATIME|syscall.MS_NODIRATIME) == 0 {
+			flags |= syscall.MS_STRICTATIME
+		}
+		err := syscall.Mount("", point, "", flags, "")
+		if err != nil && point == "/" {
+			return err
+		}
+	}
+	for _, path := range hidden {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY|msLockedFlags, "size=4k")
+		} else {
+			err = syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("cannot hide %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// mountPoints returns the mount points listed in /proc/self/mountinfo.
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var points []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		points = append(points, unescapeMountPoint(fields[4]))
+	}
+	return points, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes (like \040 for a space) used
+// in /proc/self/mountinfo.
+func unescapeMountPoint(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// setLoopbackUp enables the loopback interface of the network namespace.
+func setLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var ifr struct {
+		Name  [syscall.IFNAMSIZ]byte
+		Flags uint16
+		_     [22]byte
+	}
+	copy(ifr.Name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	ifr.Flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// +build !linux
+
+package exec
+
+import (
+	"os/exec"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+// sandbox is not available on this platform.
+type sandbox struct{}
+
+func newSandbox(inst *instance.Instance, allowed []string) (*sandbox, error) {
+	return nil, ErrSandboxUnavailable
+}
+
+func (sb *sandbox) Wrap(cmd *exec.Cmd, workDir string) error { return ErrSandboxUnavailable }
+func (sb *sandbox) Started(cmd *exec.Cmd) error              { return ErrSandboxUnavailable }
+func (sb *sandbox) Usage(cmd *exec.Cmd) *job.ResourceUsage   { return nil }
+func (sb *sandbox) Violation(cmd *exec.Cmd, err error) error { return nil }
+func (sb *sandbox) Close()                                   {}
@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"example.com", "*.example.org"}
+	assert.True(t, hostAllowed("example.com", allowed))
+	assert.True(t, hostAllowed("EXAMPLE.com:443", allowed))
+	assert.True(t, hostAllowed("api.example.org", allowed))
+	assert.True(t, hostAllowed("a.b.example.org:8080", allowed))
+	assert.False(t, hostAllowed("example.org", allowed))
+	assert.False(t, hostAllowed("www.example.com", allowed))
+	assert.False(t, hostAllowed("badexample.org", allowed))
+	assert.False(t, hostAllowed("", allowed))
+}
+
+func TestEgressProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	u, _ := url.Parse(ts.URL)
+	proxy := newEgressProxy(l, []string{"127.0.0.1"}, []string{"127.0.0.1"})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	res, err := client.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	res, err = client.Get("http://localhost:" + u.Port())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	res.Body.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", u.Host, u.Host)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection established\r\n", status)
+	conn.Close()
+
+	assert.Equal(t, []string{"localhost"}, proxy.DeniedHosts())
+}
+
+func TestEgressProxyPrivateAddress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	// The host is allowed, but it is not trusted and it resolves to the
+	// loopback address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	u, _ := url.Parse(ts.URL)
+	proxy := newEgressProxy(l, []string{"localhost", "127.0.0.1"}, []string{"cozy.localhost"})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	res, err := client.Get("http://localhost:" + u.Port())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+	res.Body.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", u.Host, u.Host)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 502 Bad Gateway\r\n", status)
+	conn.Close()
+}
@@ -0,0 +1,83 @@
+// +build linux,amd64 linux,arm64
+
+package exec
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The constants for the BPF programs and seccomp, from the linux headers.
+const (
+	bpfLD  = 0x00
+	bpfJMP = 0x05
+	bpfRET = 0x06
+	bpfW   = 0x00
+	bpfABS = 0x20
+	bpfJEQ = 0x10
+	bpfJGE = 0x30
+	bpfK   = 0x00
+
+	prSetSeccomp          = 22
+	prSetNoNewPrivs       = 38
+	seccompModeFilter     = 2
+	seccompRetKillProcess = 0x80000000
+	seccompRetAllow       = 0x7fff0000
+	seccompDataNrOffset   = 0
+	seccompDataArchOffset = 4
+)
+
+type sockFilter struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+type sockFprog struct {
+	Len    uint16
+	Filter *sockFilter
+}
+
+func bpfStmt(code uint16, k uint32) sockFilter {
+	return sockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) sockFilter {
+	return sockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter returns the BPF program that kills the process when it makes
+// one of the denied syscalls, or a syscall with an unexpected ABI.
+func seccompFilter() []sockFilter {
+	kill := bpfStmt(bpfRET|bpfK, seccompRetKillProcess)
+	prog := []sockFilter{
+		bpfStmt(bpfLD|bpfW|bpfABS, seccompDataArchOffset),
+		bpfJump(bpfJMP|bpfJEQ|bpfK, seccompAuditArch, 1, 0),
+		kill,
+		bpfStmt(bpfLD|bpfW|bpfABS, seccompDataNrOffset),
+	}
+	if seccompX32Bit != 0 {
+		prog = append(prog, bpfJump(bpfJMP|bpfJGE|bpfK, seccompX32Bit, 0, 1), kill)
+	}
+	for _, nr := range seccompDeniedSyscalls {
+		prog = append(prog, bpfJump(bpfJMP|bpfJEQ|bpfK, nr, 0, 1), kill)
+	}
+	return append(prog, bpfStmt(bpfRET|bpfK, seccompRetAllow))
+}
+
+// installSeccompFilter installs the seccomp filter on the current thread. It
+// must be called just before the exec of the konnector, on a locked thread.
+func installSeccompFilter() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+	filter := seccompFilter()
+	prog := sockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp,
+		seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package exec
+
+import "syscall"
+
+// seccompAuditArch is the AUDIT_ARCH_X86_64 value, to check that the syscalls
+// are made with the expected ABI.
+const seccompAuditArch = 0xc000003e
+
+// seccompX32Bit is set on the numbers of the syscalls of the x32 ABI, that
+// are not allowed in the sandbox.
+const seccompX32Bit = 0x40000000
+
+// seccompDeniedSyscalls is the list of the syscalls that kill the konnector.
+// The numbers are hardcoded for the syscalls that are too recent for the
+// syscall package.
+var seccompDeniedSyscalls = []uint32{
+	syscall.SYS_MOUNT,
+	syscall.SYS_UMOUNT2,
+	syscall.SYS_PIVOT_ROOT,
+	syscall.SYS_SWAPON,
+	syscall.SYS_SWAPOFF,
+	syscall.SYS_REBOOT,
+	syscall.SYS_KEXEC_LOAD,
+	320, // kexec_file_load
+	syscall.SYS_INIT_MODULE,
+	313, // finit_module
+	syscall.SYS_DELETE_MODULE,
+	syscall.SYS_PTRACE,
+	310, // process_vm_readv
+	311, // process_vm_writev
+	321, // bpf
+	syscall.SYS_PERF_EVENT_OPEN,
+	syscall.SYS_KEYCTL,
+	syscall.SYS_ADD_KEY,
+	syscall.SYS_REQUEST_KEY,
+	syscall.SYS_UNSHARE,
+	308, // setns
+	syscall.SYS_ACCT,
+	syscall.SYS_SETTIMEOFDAY,
+	syscall.SYS_CLOCK_SETTIME,
+	syscall.SYS_QUOTACTL,
+	syscall.SYS_SYSLOG,
+	323, // userfaultfd
+	303, // name_to_handle_at
+	304, // open_by_handle_at
+	syscall.SYS_SETHOSTNAME,
+	syscall.SYS_SETDOMAINNAME,
+}
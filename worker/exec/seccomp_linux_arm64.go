@@ -0,0 +1,47 @@
+package exec
+
+import "syscall"
+
+// seccompAuditArch is the AUDIT_ARCH_AARCH64 value, to check that the syscalls
+// are made with the expected ABI.
+const seccompAuditArch = 0xc00000b7
+
+// seccompX32Bit is not used on arm64.
+const seccompX32Bit = 0
+
+// seccompDeniedSyscalls is the list of the syscalls that kill the konnector.
+// The numbers are hardcoded for the syscalls that are too recent for the
+// syscall package.
+var seccompDeniedSyscalls = []uint32{
+	syscall.SYS_MOUNT,
+	syscall.SYS_UMOUNT2,
+	syscall.SYS_PIVOT_ROOT,
+	syscall.SYS_SWAPON,
+	syscall.SYS_SWAPOFF,
+	syscall.SYS_REBOOT,
+	syscall.SYS_KEXEC_LOAD,
+	294, // kexec_file_load
+	syscall.SYS_INIT_MODULE,
+	syscall.SYS_FINIT_MODULE,
+	syscall.SYS_DELETE_MODULE,
+	syscall.SYS_PTRACE,
+	syscall.SYS_PROCESS_VM_READV,
+	syscall.SYS_PROCESS_VM_WRITEV,
+	syscall.SYS_BPF,
+	syscall.SYS_PERF_EVENT_OPEN,
+	syscall.SYS_KEYCTL,
+	syscall.SYS_ADD_KEY,
+	syscall.SYS_REQUEST_KEY,
+	syscall.SYS_UNSHARE,
+	syscall.SYS_SETNS,
+	syscall.SYS_ACCT,
+	syscall.SYS_SETTIMEOFDAY,
+	syscall.SYS_CLOCK_SETTIME,
+	syscall.SYS_QUOTACTL,
+	syscall.SYS_SYSLOG,
+	282, // userfaultfd
+	syscall.SYS_NAME_TO_HANDLE_AT,
+	syscall.SYS_OPEN_BY_HANDLE_AT,
+	syscall.SYS_SETHOSTNAME,
+	syscall.SYS_SETDOMAINNAME,
+}
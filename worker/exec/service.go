@@ -11,6 +11,7 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/appfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -29,9 +30,11 @@ type ServiceOptions struct {
 }
 
 type serviceWorker struct {
-	man  *app.WebappManifest
-	slug string
-	name string
+	man     *app.WebappManifest
+	service *app.Service
+	slug    string
+	name    string
+	wasm    string
 }
 
 func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (workDir string, cleanDir func(), err error) {
@@ -110,6 +113,8 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Insta
 	}
 
 	w.man = man
+	w.service = service
+	w.wasm = ""
 
 	osFS := afero.NewOsFs()
 	workDir, err = afero.TempDir(osFS, "", "service-"+slug)
@@ -133,7 +138,14 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Insta
 	}
 	defer src.Close()
 
-	dst, err := workFS.OpenFile("index.js", os.O_CREATE|os.O_WRONLY, 0640)
+	// The services can be shipped as a WebAssembly module
+	filename := "index.js"
+	if path.Ext(service.File) == ".wasm" {
+		filename = wasmModuleFile
+		w.wasm = path.Join(workDir, wasmModuleFile)
+	}
+
+	dst, err := workFS.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0640)
 	if err != nil {
 		return
 	}
@@ -188,6 +200,22 @@ func (w *serviceWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instan
 	return
 }
 
+// WasmModule returns the path of the WebAssembly module of the service, if its
+// file has the .wasm extension.
+func (w *serviceWorker) WasmModule() string {
+	return w.wasm
+}
+
+// WasmPermissions returns the permissions of the webapp of the service.
+func (w *serviceWorker) WasmPermissions(i *instance.Instance) (*permission.Permission, error) {
+	return permission.GetForWebapp(i, w.slug)
+}
+
+// FetchHosts returns the hosts declared in the manifest for the service.
+func (w *serviceWorker) FetchHosts(i *instance.Instance) []string {
+	return w.service.AllowedHosts
+}
+
 func (w *serviceWorker) Logger(ctx *job.WorkerContext) *logrus.Entry {
 	log := ctx.Logger().WithField("slug", w.Slug())
 	if w.name != "" {
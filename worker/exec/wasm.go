@@ -0,0 +1,669 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metrics"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/web/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+var (
+	// ErrWasmForbidden is used when a WebAssembly module tries to do an
+	// operation that is not allowed by the permissions of its manifest.
+	ErrWasmForbidden = errors.New("Not allowed by the permissions of the manifest")
+	// ErrWasmHostNotAllowed is used when a WebAssembly module tries to fetch
+	// a URL on a host that is not declared in its manifest.
+	ErrWasmHostNotAllowed = errors.New("Host not allowed by the manifest")
+	// ErrWasmUnknownMethod is used when a WebAssembly module calls a host
+	// function with a method that does not exist.
+	ErrWasmUnknownMethod = errors.New("Unknown method")
+	// ErrWasmTooLarge is used when a file or an HTTP response is too large to
+	// be copied in the memory of a WebAssembly module.
+	ErrWasmTooLarge = errors.New("Content too large")
+)
+
+const (
+	// wasmHostModule is the name of the module with the host functions that
+	// can be imported by the WebAssembly konnectors and services.
+	wasmHostModule = "cozy"
+	// wasmModuleFile is the name of the WebAssembly module in the work dir.
+	wasmModuleFile = "index.wasm"
+	// wasmLanguage is the language of the konnectors shipped as a WebAssembly
+	// module.
+	wasmLanguage = "wasm"
+	// wasmMemoryLimitPages is the maximal number of memory pages (64KiB each)
+	// that a module can use: 256MiB.
+	wasmMemoryLimitPages = 4096
+	// wasmMaxContentSize is the maximal size of a file or of an HTTP response
+	// body that can be given to a module.
+	wasmMaxContentSize = 32 << 20
+	// wasmFindLimit is the default and maximal number of documents returned by
+	// a find on the data host function.
+	wasmFindLimit = 1000
+)
+
+// wasmWorker is implemented by the workers that can execute a WebAssembly
+// module inside the stack instead of running the konnectors command.
+type wasmWorker interface {
+	// WasmModule returns the path of the WebAssembly module to execute, or an
+	// empty string if the job must be run with the konnectors command.
+	WasmModule() string
+	// WasmPermissions returns the permissions used to scope the host API.
+	WasmPermissions(i *instance.Instance) (*permission.Permission, error)
+	// FetchHosts returns the hosts that the module can reach via fetch.
+	FetchHosts(i *instance.Instance) []string
+}
+
+func runWasm(ctx *job.WorkerContext, worker execWorker, ww wasmWorker, workDir string, env []string) (err error) {
+	log := worker.Logger(ctx)
+	module := ww.WasmModule()
+	bin, err := ioutil.ReadFile(module)
+	if err != nil {
+		return err
+	}
+	perms, err := ww.WasmPermissions(ctx.Instance)
+	if err != nil {
+		return err
+	}
+	host := newWasmHost(ctx.Instance, worker.Slug(), perms, ww.FetchHosts(ctx.Instance))
+	if hr, ok := worker.(httpReporter); ok {
+		host.onFetch = hr.ReportHTTPCall
+	}
+
+	// The work dir is a file when the konnector is executed for the deletion
+	// of an account.
+	dir := workDir
+	if infos, errs := os.Stat(workDir); errs == nil && !infos.IsDir() {
+		dir = filepath.Dir(workDir)
+	}
+
+	var stderrBuf bytes.Buffer
+	defer func() {
+		if stderrBuf.Len() > 0 {
+			log.Error("Stderr: ", stderrBuf.String())
+		}
+	}()
+	stdout := &lineWriter{fn: func(line []byte) {
+		if errOut := worker.ScanOutput(ctx, ctx.Instance, line); errOut != nil {
+			log.Debug(errOut)
+		}
+	}}
+
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		var result string
+		if err != nil {
+			result = metrics.WorkerExecResultErrored
+		} else {
+			result = metrics.WorkerExecResultSuccess
+		}
+		metrics.WorkersKonnectorsExecDurations.
+			WithLabelValues(worker.Slug(), result).
+			Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	err = execWasm(ctx, bin, path.Base(module), dir, env, host,
+		stdout, utils.LimitWriterDiscard(&stderrBuf, 256*1024))
+	stdout.Flush()
+	return worker.Error(ctx.Instance, wrapErr(ctx, err))
+}
+
+// execWasm instantiates the WebAssembly module with WASI and the host API,
+// which runs its _start function. The dir is mounted as the root of the
+// filesystem of the module, and env is a list of KEY=value.
+func execWasm(ctx context.Context, bin []byte, name, dir string, env []string, host *wasmHost, stdout, stderr io.Writer) error {
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryLimitPages))
+	defer rt.Close(ctx)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		return err
+	}
+	if err := host.Instantiate(ctx, rt); err != nil {
+		return err
+	}
+
+	cfg := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(name).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithRandSource(rand.Reader).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep()
+	if dir != "" {
+		cfg = cfg.WithFSConfig(wazero.NewFSConfig().WithDirMount(dir, "/"))
+	}
+	for _, kv := range env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			cfg = cfg.WithEnv(parts[0], parts[1])
+		}
+	}
+
+	_, err := rt.InstantiateWithConfig(ctx, bin, cfg)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == 0 {
+			return nil
+		}
+		return fmt.Errorf("exit status %d", exitErr.ExitCode())
+	}
+	return err
+}
+
+// lineWriter is an io.Writer that calls fn for each line written to it.
+type lineWriter struct {
+	fn  func(line []byte)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.fn(w.buf[:idx])
+		w.buf = w.buf[idx+1:]
+	}
+	// Like for the stdout of the konnectors command, very long lines are
+	// discarded.
+	if len(w.buf) > 64*1024 {
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush calls fn for the last line if it doesn't end with a newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.fn(w.buf)
+		w.buf = nil
+	}
+}
+
+// wasmHost is the host API given to a WebAssembly module. Each host function
+// takes a JSON request in the memory of the module, and returns the size of
+// the JSON response, that the module can then copy in its memory by calling
+// the result function. The response has a "result" field on success, and an
+// "error" field on failure.
+type wasmHost struct {
+	inst          *instance.Instance
+	slug          string
+	perms         *permission.Permission
+	allowed       []string
+	trusted       []string
+	client        *http.Client
+	trustedClient *http.Client
+	result        []byte
+	onFetch       func(call job.ReportHTTPCall)
+}
+
+type wasmResult struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func newWasmHost(inst *instance.Instance, slug string, perms *permission.Permission, allowed []string) *wasmHost {
+	h := &wasmHost{
+		inst:    inst,
+		slug:    slug,
+		perms:   perms,
+		allowed: allowed,
+	}
+	if inst != nil {
+		h.trusted = instanceHosts(inst)
+	}
+	// The allowed hosts are chosen by the manifest: they can't be reached on
+	// a private address, except for the instance, and the redirections are
+	// not followed.
+	h.client = safehttp.NewClient(60*time.Second, false)
+	h.trustedClient = &http.Client{
+		Timeout: 60 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return safehttp.ErrRedirect
+		},
+	}
+	return h
+}
+
+// Instantiate adds the host module with the host functions to the runtime.
+func (h *wasmHost) Instantiate(ctx context.Context, rt wazero.Runtime) error {
+	_, err := rt.NewHostModuleBuilder(wasmHostModule).
+		NewFunctionBuilder().WithFunc(h.call(h.data)).Export("data").
+		NewFunctionBuilder().WithFunc(h.call(h.files)).Export("files").
+		NewFunctionBuilder().WithFunc(h.call(h.fetch)).Export("fetch").
+		NewFunctionBuilder().WithFunc(h.readResult).Export("result").
+		Instantiate(ctx)
+	return err
+}
+
+// call wraps a host function to read its request from the memory of the
+// module, and keep its response for the result function.
+func (h *wasmHost) call(fn func(req []byte) (interface{}, error)) func(context.Context, api.Module, uint32, uint32) uint32 {
+	return func(ctx context.Context, m api.Module, ptr, size uint32) uint32 {
+		var res wasmResult
+		if req, ok := m.Memory().Read(ptr, size); !ok {
+			res.Error = "Out of bounds memory access"
+		} else if result, err := fn(req); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Result = result
+		}
+		var err error
+		if h.result, err = json.Marshal(res); err != nil {
+			h.result, _ = json.Marshal(wasmResult{Error: err.Error()})
+		}
+		return uint32(len(h.result))
+	}
+}
+
+// readResult copies the response of the last host function call in the
+// memory of the module, and returns its size (0 if the copy has failed).
+func (h *wasmHost) readResult(ctx context.Context, m api.Module, ptr uint32) uint32 {
+	if !m.Memory().Write(ptr, h.result) {
+		return 0
+	}
+	size := len(h.result)
+	h.result = nil
+	return uint32(size)
+}
+
+type wasmDataRequest struct {
+	Method   string                 `json:"method"`
+	Doctype  string                 `json:"doctype"`
+	ID       string                 `json:"id"`
+	Rev      string                 `json:"rev"`
+	Doc      map[string]interface{} `json:"doc"`
+	Selector json.RawMessage        `json:"selector"`
+	Limit    int                    `json:"limit"`
+}
+
+// data gives access to the CouchDB documents, with the methods get, find,
+// create, update and delete.
+func (h *wasmHost) data(body []byte) (interface{}, error) {
+	var req wasmDataRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	switch req.Method {
+	case "get":
+		return h.getDoc(&req)
+	case "find":
+		return h.findDocs(&req)
+	case "create":
+		return h.createDoc(&req)
+	case "update":
+		return h.updateDoc(&req)
+	case "delete":
+		return h.deleteDoc(&req)
+	}
+	return nil, ErrWasmUnknownMethod
+}
+
+func (h *wasmHost) getDoc(req *wasmDataRequest) (interface{}, error) {
+	if err := permission.CheckReadable(req.Doctype); err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{}
+	if err := couchdb.GetDoc(h.inst, req.Doctype, req.ID, &doc); err != nil {
+		return nil, err
+	}
+	doc.Type = req.Doctype
+	if !h.perms.Permissions.Allow(permission.GET, &doc) {
+		return nil, ErrWasmForbidden
+	}
+	projection := h.perms.Permissions.Projection(&doc)
+	h.decrypt(doc)
+	doc.M = projection.Apply(doc.M)
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) findDocs(req *wasmDataRequest) (interface{}, error) {
+	if err := permission.CheckReadable(req.Doctype); err != nil {
+		return nil, err
+	}
+	if !h.perms.Permissions.AllowWholeType(permission.GET, req.Doctype) {
+		return nil, ErrWasmForbidden
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > wasmFindLimit {
+		limit = wasmFindLimit
+	}
+	var selector interface{} = map[string]interface{}{}
+	if len(req.Selector) > 0 {
+		if err := json.Unmarshal(req.Selector, &selector); err != nil {
+			return nil, err
+		}
+	}
+	find := map[string]interface{}{
+		"selector": selector,
+		"limit":    limit,
+	}
+	// The selector can't use the fields that can't be read, as the results
+	// would leak their values
+	projection := h.perms.Permissions.ProjectionForType(req.Doctype)
+	if !projection.CanReadQuery(find) {
+		return nil, ErrWasmForbidden
+	}
+	var docs []couchdb.JSONDoc
+	if _, err := couchdb.FindDocsRaw(h.inst, req.Doctype, find, &docs); err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, len(docs))
+	for i := range docs {
+		docs[i].Type = req.Doctype
+		h.decrypt(docs[i])
+		docs[i].M = projection.Apply(docs[i].M)
+		results[i] = docs[i].ToMapWithType()
+	}
+	return results, nil
+}
+
+func (h *wasmHost) createDoc(req *wasmDataRequest) (interface{}, error) {
+	if err := permission.CheckWritable(req.Doctype); err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{Type: req.Doctype, M: req.Doc}
+	if doc.M == nil {
+		doc.M = make(map[string]interface{})
+	}
+	if !h.perms.Permissions.Allow(permission.POST, &doc) {
+		return nil, ErrWasmForbidden
+	}
+	h.encrypt(doc)
+	var err error
+	if doc.ID() != "" {
+		err = couchdb.CreateNamedDocWithDB(h.inst, &doc)
+	} else {
+		err = couchdb.CreateDoc(h.inst, &doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) updateDoc(req *wasmDataRequest) (interface{}, error) {
+	if err := permission.CheckWritable(req.Doctype); err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{Type: req.Doctype, M: req.Doc}
+	if doc.ID() == "" || doc.Rev() == "" {
+		return nil, errors.New("The document must have an _id and a _rev")
+	}
+	if !h.perms.Permissions.AllowWholeType(permission.PUT, req.Doctype) {
+		old := couchdb.JSONDoc{}
+		if err := couchdb.GetDoc(h.inst, req.Doctype, doc.ID(), &old); err != nil {
+			return nil, err
+		}
+		old.Type = req.Doctype
+		if !h.perms.Permissions.Allow(permission.PUT, &old) ||
+			!h.perms.Permissions.Allow(permission.PUT, &doc) {
+			return nil, ErrWasmForbidden
+		}
+	}
+	h.encrypt(doc)
+	if err := couchdb.UpdateDoc(h.inst, &doc); err != nil {
+		return nil, err
+	}
+	return doc.ToMapWithType(), nil
+}
+
+func (h *wasmHost) deleteDoc(req *wasmDataRequest) (interface{}, error) {
+	if err := permission.CheckWritable(req.Doctype); err != nil {
+		return nil, err
+	}
+	doc := couchdb.JSONDoc{}
+	if err := couchdb.GetDoc(h.inst, req.Doctype, req.ID, &doc); err != nil {
+		return nil, err
+	}
+	doc.Type = req.Doctype
+	if !h.perms.Permissions.Allow(permission.DELETE, &doc) {
+		return nil, ErrWasmForbidden
+	}
+	if req.Rev != "" {
+		doc.SetRev(req.Rev)
+	}
+	if err := couchdb.DeleteDoc(h.inst, &doc); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": doc.ID(), "rev": doc.Rev()}, nil
+}
+
+// decrypt gives the credentials of the accounts to the konnectors, like the
+// data API does.
+func (h *wasmHost) decrypt(doc couchdb.JSONDoc) {
+	if doc.Type == consts.Accounts && h.perms.Type == permission.TypeKonnector {
+		data.DecryptAccount(doc)
+	}
+}
+
+func (h *wasmHost) encrypt(doc couchdb.JSONDoc) {
+	if doc.Type == consts.Accounts {
+		data.EncryptAccount(doc)
+	}
+}
+
+type wasmFilesRequest struct {
+	Method  string `json:"method"`
+	ID      string `json:"id"`
+	DirID   string `json:"dir_id"`
+	Name    string `json:"name"`
+	Mime    string `json:"mime"`
+	Content []byte `json:"content"`
+}
+
+type wasmFileContent struct {
+	*vfs.FileDoc
+	Content []byte `json:"content"`
+}
+
+// files gives access to the VFS, with the methods stat, read and create. The
+// content of the files is encoded in base64.
+func (h *wasmHost) files(body []byte) (interface{}, error) {
+	var req wasmFilesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	switch req.Method {
+	case "stat":
+		return h.statFile(&req)
+	case "read":
+		return h.readFile(&req)
+	case "create":
+		return h.createFile(&req)
+	}
+	return nil, ErrWasmUnknownMethod
+}
+
+func (h *wasmHost) statFile(req *wasmFilesRequest) (interface{}, error) {
+	fs := h.inst.VFS()
+	dir, file, err := fs.DirOrFileByID(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		if err := vfs.Allows(fs, h.perms.Permissions, permission.GET, dir); err != nil {
+			return nil, ErrWasmForbidden
+		}
+		return dir, nil
+	}
+	if err := vfs.Allows(fs, h.perms.Permissions, permission.GET, file); err != nil {
+		return nil, ErrWasmForbidden
+	}
+	return file, nil
+}
+
+func (h *wasmHost) readFile(req *wasmFilesRequest) (interface{}, error) {
+	fs := h.inst.VFS()
+	doc, err := fs.FileByID(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := vfs.Allows(fs, h.perms.Permissions, permission.GET, doc); err != nil {
+		return nil, ErrWasmForbidden
+	}
+	if doc.ByteSize > wasmMaxContentSize {
+		return nil, ErrWasmTooLarge
+	}
+	f, err := fs.OpenFile(doc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return wasmFileContent{FileDoc: doc, Content: content}, nil
+}
+
+func (h *wasmHost) createFile(req *wasmFilesRequest) (interface{}, error) {
+	fs := h.inst.VFS()
+	mime, class := vfs.ExtractMimeAndClassFromFilename(req.Name)
+	if req.Mime != "" {
+		mime, class = vfs.ExtractMimeAndClass(req.Mime)
+	}
+	dirID := req.DirID
+	if dirID == "" {
+		dirID = consts.RootDirID
+	}
+	doc, err := vfs.NewFileDoc(req.Name, dirID, int64(len(req.Content)), nil,
+		mime, class, time.Now(), false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := vfs.Allows(fs, h.perms.Permissions, permission.POST, doc); err != nil {
+		return nil, ErrWasmForbidden
+	}
+	doc.CozyMetadata = vfs.NewCozyMetadata(h.inst.PageURL("/", nil))
+	doc.CozyMetadata.CreatedByApp = h.slug
+	doc.CozyMetadata.UploadedBy = &vfs.UploadedByEntry{Slug: h.slug}
+	f, err := fs.CreateFile(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(req.Content)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+type wasmFetchRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+type wasmFetchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+// fetch makes an HTTP request to one of the hosts allowed by the manifest.
+// The bodies of the request and of the response are encoded in base64.
+func (h *wasmHost) fetch(body []byte) (interface{}, error) {
+	var req wasmFetchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme %q", u.Scheme)
+	}
+	if !hostAllowed(u.Host, h.allowed) {
+		return nil, ErrWasmHostNotAllowed
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	r, err := http.NewRequest(method, u.String(), bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Headers {
+		r.Header.Set(k, v)
+	}
+	client := h.client
+	if hostAllowed(u.Host, h.trusted) {
+		client = h.trustedClient
+	}
+	start := time.Now()
+	res, err := client.Do(r)
+	if h.onFetch != nil {
+		call := job.ReportHTTPCall{
+			Time:     start,
+			Method:   method,
+			URL:      u.String(),
+			Duration: time.Since(start),
+		}
+		if err == nil {
+			call.Status = res.StatusCode
+		}
+		h.onFetch(call)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(res.Body, wasmMaxContentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > wasmMaxContentSize {
+		return nil, ErrWasmTooLarge
+	}
+	headers := make(map[string]string, len(res.Header))
+	for k := range res.Header {
+		headers[strings.ToLower(k)] = res.Header.Get(k)
+	}
+	return wasmFetchResponse{
+		Status:  res.StatusCode,
+		Headers: headers,
+		Body:    content,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line []byte) {
+		lines = append(lines, string(line))
+	}}
+	_, _ = w.Write([]byte("foo\nb"))
+	_, _ = w.Write([]byte("ar\nbaz"))
+	assert.Equal(t, []string{"foo", "bar"}, lines)
+	w.Flush()
+	assert.Equal(t, []string{"foo", "bar", "baz"}, lines)
+}
+
+func TestExecWasmFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	host := newWasmHost(nil, "test", nil, []string{"127.0.0.1"})
+	host.trusted = []string{"127.0.0.1"}
+	req := fmt.Sprintf(`{"method":"POST","url":%q}`, ts.URL)
+	var stdout, stderr bytes.Buffer
+	err := execWasm(context.Background(), fetchModule(req), "index.wasm", "", nil, host, &stdout, &stderr)
+	assert.NoError(t, err)
+
+	var res struct {
+		Result wasmFetchResponse `json:"result"`
+		Error  string            `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+	assert.Empty(t, res.Error)
+	assert.Equal(t, 200, res.Result.Status)
+	assert.Equal(t, "POST", res.Result.Headers["x-method"])
+	assert.Equal(t, "ok", string(res.Result.Body))
+
+	host = newWasmHost(nil, "test", nil, []string{"example.com"})
+	stdout.Reset()
+	err = execWasm(context.Background(), fetchModule(req), "index.wasm", "", nil, host, &stdout, &stderr)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+	assert.Equal(t, ErrWasmHostNotAllowed.Error(), res.Error)
+
+	// An allowed host on a private address can't be reached
+	host = newWasmHost(nil, "test", nil, []string{"127.0.0.1"})
+	stdout.Reset()
+	err = execWasm(context.Background(), fetchModule(req), "index.wasm", "", nil, host, &stdout, &stderr)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &res))
+	assert.Contains(t, res.Error, safehttp.ErrForbiddenAddress.Error())
+}
+
+func TestExecWasmExitCode(t *testing.T) {
+	host := newWasmHost(nil, "test", nil, nil)
+	var stdout, stderr bytes.Buffer
+	err := execWasm(context.Background(), exitModule(3), "index.wasm", "", nil, host, &stdout, &stderr)
+	assert.Error(t, err)
+	assert.Equal(t, "exit status 3", err.Error())
+
+	err = execWasm(context.Background(), exitModule(0), "index.wasm", "", nil, host, &stdout, &stderr)
+	assert.NoError(t, err)
+}
+
+// fetchModule returns a WebAssembly module that calls the fetch host function
+// with the given request, and writes the response on stdout.
+func fetchModule(req string) []byte {
+	types := [][]byte{
+		{0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f}, // fd_write
+		{0x60, 2, 0x7f, 0x7f, 1, 0x7f},             // fetch
+		{0x60, 1, 0x7f, 1, 0x7f},                   // result
+		{0x60, 0, 0},                               // _start
+	}
+	imports := [][]byte{
+		wasmImport("wasi_snapshot_preview1", "fd_write", 0),
+		wasmImport(wasmHostModule, "fetch", 1),
+		wasmImport(wasmHostModule, "result", 2),
+	}
+	// The iovec for fd_write is at 0, the response at 1024, and the request
+	// at 64.
+	code := concat(
+		i32Const(4), i32Const(64), i32Const(int32(len(req))), []byte{0x10, 1},
+		[]byte{0x36, 2, 0}, // i32.store the size of the response in the iovec
+		i32Const(1024), []byte{0x10, 2, 0x1a},
+		i32Const(1), i32Const(0), i32Const(1), i32Const(8), []byte{0x10, 0, 0x1a},
+	)
+	data := [][]byte{
+		wasmData(0, []byte{0x00, 0x04, 0, 0}),
+		wasmData(64, []byte(req)),
+	}
+	return wasmBinary(types, imports, 3, code, data)
+}
+
+// exitModule returns a WebAssembly module that exits with the given code.
+func exitModule(code int32) []byte {
+	types := [][]byte{
+		{0x60, 1, 0x7f, 0}, // proc_exit
+		{0x60, 0, 0},       // _start
+	}
+	imports := [][]byte{
+		wasmImport("wasi_snapshot_preview1", "proc_exit", 0),
+	}
+	body := concat(i32Const(code), []byte{0x10, 0})
+	return wasmBinary(types, imports, 1, body, nil)
+}
+
+// wasmBinary encodes a module with an exported memory and a _start function.
+func wasmBinary(types, imports [][]byte, startType byte, code []byte, data [][]byte) []byte {
+	funcIndex := byte(len(imports))
+	body := concat([]byte{0}, code, []byte{0x0b})
+	exports := [][]byte{
+		concat(wasmName("memory"), []byte{0x02, 0}),
+		concat(wasmName("_start"), []byte{0x00, funcIndex}),
+	}
+	return concat(
+		[]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		wasmSection(1, wasmVec(types)),
+		wasmSection(2, wasmVec(imports)),
+		wasmSection(3, wasmVec([][]byte{{startType}})),
+		wasmSection(5, wasmVec([][]byte{{0x00, 1}})),
+		wasmSection(7, wasmVec(exports)),
+		wasmSection(10, wasmVec([][]byte{concat(uleb(uint32(len(body))), body)})),
+		wasmSection(11, wasmVec(data)),
+	)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	return concat([]byte{id}, uleb(uint32(len(content))), content)
+}
+
+func wasmVec(items [][]byte) []byte {
+	return concat(uleb(uint32(len(items))), concat(items...))
+}
+
+func wasmName(name string) []byte {
+	return concat(uleb(uint32(len(name))), []byte(name))
+}
+
+func wasmImport(module, name string, typ byte) []byte {
+	return concat(wasmName(module), wasmName(name), []byte{0x00, typ})
+}
+
+func wasmData(offset int32, content []byte) []byte {
+	return concat([]byte{0}, i32Const(offset), []byte{0x0b}, uleb(uint32(len(content))), content)
+}
+
+func i32Const(v int32) []byte {
+	out := []byte{0x41}
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func uleb(v uint32) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -7,7 +7,9 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/vfs"
@@ -37,6 +39,7 @@ const (
 
 	accountsToOrganization = "accounts-to-organization"
 	notesMimeType          = "notes-mime-type"
+	contactsDedupTrigger   = "contacts-dedup-trigger"
 )
 
 // maxSimultaneousCalls is the maximal number of simultaneous calls to Swift
@@ -77,6 +80,8 @@ func worker(ctx *job.WorkerContext) error {
 		return migrateAccountsToOrganization(ctx.Instance.Domain)
 	case notesMimeType:
 		return migrateNotesMimeType(ctx.Instance.Domain)
+	case contactsDedupTrigger:
+		return migrateContactsDedupTrigger(ctx.Instance.Domain)
 	default:
 		return fmt.Errorf("unknown migration type %q", msg.Type)
 	}
@@ -135,6 +140,36 @@ func migrateNotesMimeType(domain string) error {
 	return nil
 }
 
+// migrateContactsDedupTrigger adds the trigger for looking for duplicate
+// contacts to an instance created before this trigger existed.
+func migrateContactsDedupTrigger(domain string) error {
+	inst, err := instance.GetFromCouch(domain)
+	if err != nil {
+		return err
+	}
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(inst)
+	if err != nil {
+		return err
+	}
+	for _, t := range triggers {
+		if t.Infos().WorkerType == contact.DedupWorker {
+			return nil
+		}
+	}
+	for _, infos := range lifecycle.Triggers(inst) {
+		if infos.WorkerType != contact.DedupWorker {
+			continue
+		}
+		t, err := job.NewTrigger(inst, infos, nil)
+		if err != nil {
+			return err
+		}
+		return sched.AddTrigger(t)
+	}
+	return nil
+}
+
 func migrateToSwiftV3(domain string) error {
 	c := config.GetSwiftConnection()
 	inst, err := instance.GetFromCouch(domain)
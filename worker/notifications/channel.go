@@ -0,0 +1,35 @@
+package notifications
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   center.ChannelWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Second,
+		WorkerFunc:   WorkerChannel,
+	})
+}
+
+// WorkerChannel is used to send a notification to a channel like a webhook,
+// a Matrix room or a ntfy topic.
+func WorkerChannel(ctx *job.WorkerContext) error {
+	var msg center.ChannelMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	err := center.SendToChannel(ctx.Instance, &msg)
+	if err != nil {
+		ctx.Logger().WithField("nspace", "notifications").
+			Warnf("Cannot send the notification to the %s channel: %s", msg.Channel, err)
+	}
+	return err
+}
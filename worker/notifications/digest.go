@@ -0,0 +1,31 @@
+package notifications
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   center.DigestWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Minute,
+		WorkerFunc:   WorkerDigest,
+	})
+}
+
+// WorkerDigest is used to send a mail with the notifications that the user
+// has chosen to receive in a digest, instead of one by one.
+func WorkerDigest(ctx *job.WorkerContext) error {
+	err := center.SendDigest(ctx.Instance)
+	if err != nil {
+		ctx.Logger().WithField("nspace", "notifications").
+			Warnf("Cannot send the digest of notifications: %s", err)
+	}
+	return err
+}
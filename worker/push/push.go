@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -15,11 +16,13 @@ import (
 	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/mail"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/sirupsen/logrus"
 
 	fcm "github.com/appleboy/go-fcm"
@@ -134,6 +137,9 @@ func Worker(ctx *job.WorkerContext) error {
 				Warnf("could not send notification on device: %s", err)
 		}
 	}
+	if pushToBrowsers(ctx, &msg) {
+		sent = true
+	}
 	if !sent {
 		sendFallbackMail(ctx.Instance, msg.MailFallback)
 	}
@@ -278,6 +284,78 @@ func pushToAPNS(ctx *job.WorkerContext, c *oauth.Client, msg *center.PushMessage
 	return nil
 }
 
+// webPushPayload is the JSON sent to the service worker of the browsers.
+type webPushPayload struct {
+	NotificationID string                 `json:"notification_id,omitempty"`
+	Source         string                 `json:"source,omitempty"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body,omitempty"`
+	Sound          string                 `json:"sound,omitempty"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+}
+
+// pushToBrowsers sends the message to the browsers that have subscribed to
+// the Web Push notifications, and returns true if at least one of them has
+// accepted it. The subscriptions that have expired or that are rejected by the
+// push service are removed.
+func pushToBrowsers(ctx *job.WorkerContext, msg *center.PushMessage) bool {
+	sender, err := notification.WebPushSender()
+	if err != nil {
+		return false
+	}
+	subs, err := notification.ListWebPushSubscriptions(ctx.Instance)
+	if err != nil {
+		ctx.Logger().Warnf("could not list the web push subscriptions: %s", err)
+		return false
+	}
+	if len(subs) == 0 {
+		return false
+	}
+
+	payload, err := json.Marshal(webPushPayload{
+		NotificationID: msg.NotificationID,
+		Source:         msg.Source,
+		Title:          msg.Title,
+		Body:           msg.Message,
+		Sound:          msg.Sound,
+		Data:           msg.Data,
+	})
+	if err != nil {
+		return false
+	}
+	opts := webpush.Options{Urgency: "normal"}
+	if msg.Priority == "high" {
+		opts.Urgency = "high"
+	}
+	if msg.Collapsible {
+		// The topic is limited to 32 characters from the base64url alphabet
+		opts.Topic = hex.EncodeToString(hashSource(msg.Source))
+	}
+
+	sent := false
+	now := time.Now()
+	for _, sub := range subs {
+		if sub.Expired(now) {
+			err = webpush.ErrSubscriptionGone
+		} else {
+			err = sender.Send(&sub.Subscription, payload, opts)
+		}
+		switch err {
+		case nil:
+			sent = true
+		case webpush.ErrSubscriptionGone:
+			if err := notification.DeleteWebPushSubscription(ctx.Instance, sub); err != nil {
+				ctx.Logger().Warnf("could not delete the web push subscription: %s", err)
+			}
+		default:
+			ctx.Logger().
+				WithField("subscription_id", sub.ID()).
+				Warnf("could not send notification to browser: %s", err)
+		}
+	}
+	return sent
+}
+
 func hashSource(source string) []byte {
 	h := md5.New()
 	_, _ = h.Write([]byte(source))
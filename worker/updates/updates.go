@@ -67,6 +67,8 @@ func updateErrorFromInstaller(inst *app.Installer, step string, reason error) *u
 //     update
 //   - ForceRegistry: translates the git:// sourced application into
 //     registry://
+//   - SkipRollout: updates the webapps on all the instances, even if a staged
+//     rollout is configured for their context
 type Options struct {
 	Slugs              []string `json:"slugs,omitempty"`
 	Domain             string   `json:"domain,omitempty"`
@@ -75,6 +77,7 @@ type Options struct {
 	Force              bool     `json:"force"`
 	ForceRegistry      bool     `json:"force_registry"`
 	OnlyRegistry       bool     `json:"only_registry"`
+	SkipRollout        bool     `json:"skip_rollout"`
 }
 
 // Worker is the worker method to launch the updates.
@@ -255,6 +258,9 @@ func installerPush(inst *instance.Instance, insc chan *app.Installer, errc chan
 			if opts.OnlyRegistry && strings.HasPrefix(webapp.Source(), "registry://") {
 				continue
 			}
+			if !opts.SkipRollout && !app.InRollout(inst, webapp) {
+				continue
+			}
 			installer, err := createInstaller(inst, registries, webapp, opts)
 			if err != nil {
 				errc <- &updateError{